@@ -0,0 +1,403 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+// The activities are the heavyweight operations that are currently
+// running on a node, such as rebalance moves, replica builds,
+// compactions, hibernation transfers and backfills, so that operators
+// get a single "what is the cluster busy doing" view.  The operations
+// register themselves with StartActivity, except for the backfills and
+// replica builds, which are derived from the feeds' backfilling
+// partitions.
+
+// The kinds of activities.
+const (
+	ACTIVITY_REBALANCE_MOVE       = "rebalanceMove"
+	ACTIVITY_REPLICA_BUILD        = "replicaBuild"
+	ACTIVITY_COMPACTION           = "compaction"
+	ACTIVITY_HIBERNATION_TRANSFER = "hibernationTransfer"
+	ACTIVITY_BACKFILL             = "backfill"
+)
+
+// An Activity is a heavyweight operation that's running on a node.
+type Activity struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"` // Such as a pindex or an object key.
+	IndexName string `json:"indexName,omitempty"`
+
+	// TargetNode is the node that the activity works on, when it's
+	// not the node that runs the activity, such as for a rebalance
+	// move.
+	TargetNode string `json:"targetNode,omitempty"`
+
+	PriorityClass string    `json:"priorityClass,omitempty"`
+	StartedAt     time.Time `json:"startedAt"`
+}
+
+// A FeedBackfills is a Feed that reports its backfilling partitions.
+type FeedBackfills interface {
+	BackfillingPartitions() []string
+}
+
+// NodeActivities are the activities of a node, along with the node's
+// resource governors.
+type NodeActivities struct {
+	Node       string         `json:"node"`
+	Activities []*Activity    `json:"activities"`
+	Counts     map[string]int `json:"counts"` // Keyed by kind.
+
+	// Shares are the fractions of the activities of each priority
+	// class, which are compared with the configured GovernorShares.
+	Shares         map[string]float64 `json:"shares"`
+	GovernorShares map[string]float64 `json:"governorShares"`
+
+	BackfillSlotsInflight int    `json:"backfillSlotsInflight"`
+	BackfillSlotsLimit    int    `json:"backfillSlotsLimit"` // 0 is unlimited.
+	TransferRateLimit     int64  `json:"transferRateLimit"`  // In bytes/sec, 0 is unlimited.
+	MemoryUsed            uint64 `json:"memoryUsed"`         // In bytes, of the pindexes.
+	MemoryQuota           uint64 `json:"memoryQuota"`        // In bytes, 0 is unlimited.
+}
+
+// ClusterActivities are the activities of the wanted nodes.
+type ClusterActivities struct {
+	Nodes  map[string]*NodeActivities `json:"nodes"`  // Keyed by node UUID.
+	Counts map[string]int             `json:"counts"` // Keyed by kind.
+	Errors map[string]string          `json:"errors,omitempty"`
+
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// StartActivity registers an activity of the node, and returns the
+// func that unregisters it when the activity is done.  It's safe to
+// call on a nil Manager.
+func (mgr *Manager) StartActivity(kind, name, indexName,
+	targetNode string) (done func()) {
+	if mgr == nil {
+		return func() {}
+	}
+
+	a := &Activity{
+		Kind:       kind,
+		Name:       name,
+		IndexName:  indexName,
+		TargetNode: targetNode,
+		StartedAt:  time.Now(),
+	}
+	if indexName != "" {
+		a.PriorityClass = mgr.IndexPriorityClass(indexName)
+	}
+
+	id := atomic.AddUint64(&mgr.activitySeq, 1)
+
+	mgr.activitiesM.Lock()
+	if mgr.activities == nil {
+		mgr.activities = map[uint64]*Activity{}
+	}
+	mgr.activities[id] = a
+	mgr.activitiesM.Unlock()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			mgr.activitiesM.Lock()
+			delete(mgr.activities, id)
+			mgr.activitiesM.Unlock()
+		})
+	}
+}
+
+// LocalActivities returns the activities of this node.
+func (mgr *Manager) LocalActivities() *NodeActivities {
+	rv := &NodeActivities{
+		Node:           mgr.uuid,
+		Activities:     []*Activity{},
+		Counts:         map[string]int{},
+		Shares:         map[string]float64{},
+		GovernorShares: map[string]float64{},
+	}
+
+	mgr.activitiesM.Lock()
+	for _, a := range mgr.activities {
+		x := *a
+		rv.Activities = append(rv.Activities, &x)
+	}
+	mgr.activitiesM.Unlock()
+
+	rv.Activities = append(rv.Activities, mgr.backfillActivities()...)
+
+	sort.Slice(rv.Activities, func(i, j int) bool {
+		a, b := rv.Activities[i], rv.Activities[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+
+	for _, a := range rv.Activities {
+		rv.Counts[a.Kind]++
+
+		class := a.PriorityClass
+		if class == "" {
+			class = PriorityClassNormal
+		}
+		rv.Shares[class] += 1 / float64(len(rv.Activities))
+	}
+
+	for class, share := range PriorityClassShares {
+		rv.GovernorShares[class] = share
+	}
+
+	if throttle := mgr.BackfillThrottle(); throttle.Enabled() {
+		rv.BackfillSlotsInflight = throttle.Inflight()
+		rv.BackfillSlotsLimit = throttle.Limit()
+	}
+
+	if limiter := mgr.TransferRateLimiter(); limiter != nil {
+		rv.TransferRateLimit = limiter.Rate()
+	}
+
+	if governor := mgr.MemoryGovernor(); governor != nil {
+		rv.MemoryUsed = governor.Used()
+		rv.MemoryQuota = governor.Quota()
+	}
+
+	return rv
+}
+
+// backfillActivities returns an activity for each local pindex whose
+// feed is backfilling any of its partitions, which is a replica build
+// when the pindex is planned as a replica on this node.
+func (mgr *Manager) backfillActivities() []*Activity {
+	feeds, pindexes := mgr.CurrentMaps()
+
+	pindexesByDest := map[Dest]*PIndex{}
+	for _, pindex := range pindexes {
+		if pindex.Dest != nil {
+			pindexesByDest[pindex.Dest] = pindex
+		}
+	}
+
+	backfilling := map[string]*PIndex{} // Keyed by pindex name.
+	for _, feed := range feeds {
+		fb, ok := feed.(FeedBackfills)
+		if !ok {
+			continue
+		}
+
+		dests := feed.Dests()
+		for _, partition := range fb.BackfillingPartitions() {
+			if pindex := pindexesByDest[dests[partition]]; pindex != nil {
+				backfilling[pindex.Name] = pindex
+			}
+		}
+	}
+
+	if len(backfilling) == 0 {
+		return nil
+	}
+
+	var planPIndexes *PlanPIndexes
+	if mgr.cfg != nil {
+		planPIndexes, _, _ = CfgGetPlanPIndexes(mgr.cfg)
+	}
+
+	rv := make([]*Activity, 0, len(backfilling))
+
+	for name, pindex := range backfilling {
+		kind := ACTIVITY_BACKFILL
+		if planPIndexes != nil {
+			planPIndex := planPIndexes.PlanPIndexes[name]
+			if planPIndex != nil && planPIndex.Nodes[mgr.uuid] != nil &&
+				planPIndex.Nodes[mgr.uuid].Priority > 0 {
+				kind = ACTIVITY_REPLICA_BUILD
+			}
+		}
+
+		rv = append(rv, &Activity{
+			Kind:          kind,
+			Name:          name,
+			IndexName:     pindex.IndexName,
+			PriorityClass: mgr.IndexPriorityClass(pindex.IndexName),
+		})
+	}
+
+	return rv
+}
+
+// ClusterActivities returns the activities of the wanted nodes, where
+// the activities of the remote nodes are fetched from their REST APIs.
+func (mgr *Manager) ClusterActivities() (*ClusterActivities, error) {
+	rv := &ClusterActivities{
+		Nodes:     map[string]*NodeActivities{},
+		Counts:    map[string]int{},
+		UpdatedAt: time.Now(),
+	}
+
+	add := func(na *NodeActivities) {
+		rv.Nodes[na.Node] = na
+		for kind, count := range na.Counts {
+			rv.Counts[kind] += count
+		}
+	}
+
+	if mgr.cfg == nil {
+		add(mgr.LocalActivities())
+		return rv, nil
+	}
+
+	nodeDefs, _, err := CfgGetNodeDefs(mgr.cfg, NODE_DEFS_WANTED)
+	if err != nil {
+		return nil, err
+	}
+
+	if nodeDefs == nil || nodeDefs.NodeDefs[mgr.uuid] == nil {
+		add(mgr.LocalActivities())
+	}
+
+	if nodeDefs == nil {
+		return rv, nil
+	}
+
+	nodeUUIDs := make([]string, 0, len(nodeDefs.NodeDefs))
+	for nodeUUID := range nodeDefs.NodeDefs {
+		nodeUUIDs = append(nodeUUIDs, nodeUUID)
+	}
+	sort.Strings(nodeUUIDs)
+
+	for _, nodeUUID := range nodeUUIDs {
+		if nodeUUID == mgr.uuid {
+			add(mgr.LocalActivities())
+			continue
+		}
+
+		na, err := fetchNodeActivities(nodeDefs.NodeDefs[nodeUUID])
+		if err != nil {
+			if rv.Errors == nil {
+				rv.Errors = map[string]string{}
+			}
+			rv.Errors[nodeUUID] = err.Error()
+			continue
+		}
+
+		na.Node = nodeUUID
+		add(na)
+	}
+
+	return rv, nil
+}
+
+func fetchNodeActivities(nodeDef *NodeDef) (*NodeActivities, error) {
+	hostPortUrl := "http://" + nodeDef.HostPort
+	if u, err := nodeDef.HttpsURL(); err == nil {
+		hostPortUrl = u
+	}
+
+	resp, err := alertHttpGet(hostPortUrl + "/api/activities?local=true")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("activities: status: %d", resp.StatusCode)
+	}
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var r struct {
+		Activities *NodeActivities `json:"activities"`
+	}
+	err = json.Unmarshal(buf, &r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Activities == nil {
+		return nil, fmt.Errorf("activities: no activities")
+	}
+
+	return r.Activities, nil
+}
+
+// ------------------------------------------------------------------------
+
+// An activityObjStoreClient is an object store client whose uploads and
+// downloads are the hibernation transfer activities of the node.
+type activityObjStoreClient struct {
+	objcli.Client
+
+	mgr *Manager
+}
+
+type activityReadCloser struct {
+	io.ReadCloser
+
+	done func()
+}
+
+func (r *activityReadCloser) Close() error {
+	r.done()
+	return r.ReadCloser.Close()
+}
+
+func (c *activityObjStoreClient) GetObject(ctx context.Context,
+	bucket, key string, br *objval.ByteRange) (*objval.Object, error) {
+	done := c.mgr.StartActivity(ACTIVITY_HIBERNATION_TRANSFER, key, "", "")
+
+	obj, err := c.Client.GetObject(ctx, bucket, key, br)
+	if err != nil {
+		done()
+		return nil, err
+	}
+
+	// The download lasts until its body is closed.
+	obj.Body = &activityReadCloser{ReadCloser: obj.Body, done: done}
+
+	return obj, nil
+}
+
+func (c *activityObjStoreClient) PutObject(ctx context.Context,
+	bucket, key string, body io.ReadSeeker) error {
+	defer c.mgr.StartActivity(ACTIVITY_HIBERNATION_TRANSFER, key, "", "")()
+
+	return c.Client.PutObject(ctx, bucket, key, body)
+}
+
+func (c *activityObjStoreClient) AppendToObject(ctx context.Context,
+	bucket, key string, data io.ReadSeeker) error {
+	defer c.mgr.StartActivity(ACTIVITY_HIBERNATION_TRANSFER, key, "", "")()
+
+	return c.Client.AppendToObject(ctx, bucket, key, data)
+}
+
+func (c *activityObjStoreClient) UploadPart(ctx context.Context,
+	bucket, id, key string, number int, body io.ReadSeeker) (objval.Part, error) {
+	defer c.mgr.StartActivity(ACTIVITY_HIBERNATION_TRANSFER,
+		fmt.Sprintf("%s#%d", key, number), "", "")()
+
+	return c.Client.UploadPart(ctx, bucket, id, key, number, body)
+}
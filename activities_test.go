@@ -0,0 +1,107 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStartActivity(t *testing.T) {
+	var nilMgr *Manager
+	nilMgr.StartActivity(ACTIVITY_COMPACTION, "x", "", "")()
+
+	mgr := NewManager(VERSION, nil, "n0", nil,
+		"", 1, "", "", "", "", nil)
+
+	done0 := mgr.StartActivity(ACTIVITY_REBALANCE_MOVE, "p0", "idx", "n1")
+	done1 := mgr.StartActivity(ACTIVITY_COMPACTION, "idx", "idx", "")
+
+	na := mgr.LocalActivities()
+	if len(na.Activities) != 2 ||
+		na.Counts[ACTIVITY_REBALANCE_MOVE] != 1 ||
+		na.Counts[ACTIVITY_COMPACTION] != 1 {
+		t.Fatalf("unexpected activities: %#v", na)
+	}
+	if na.Activities[0].Kind != ACTIVITY_COMPACTION ||
+		na.Activities[1].TargetNode != "n1" {
+		t.Errorf("unexpected order: %#v", na.Activities)
+	}
+	if na.Shares[PriorityClassNormal] != 1 {
+		t.Errorf("unexpected shares: %#v", na.Shares)
+	}
+
+	done0()
+	done0()
+	done1()
+
+	na = mgr.LocalActivities()
+	if len(na.Activities) != 0 || len(na.Counts) != 0 {
+		t.Errorf("expected no activities: %#v", na)
+	}
+}
+
+func TestClusterActivities(t *testing.T) {
+	cfg := NewCfgMem()
+	local, remote, down := "n0", "n1", "n2"
+
+	nodeDefs := NewNodeDefs(VERSION)
+	nodeDefs.NodeDefs[local] = &NodeDef{UUID: local, HostPort: "local:8094"}
+	nodeDefs.NodeDefs[remote] = &NodeDef{UUID: remote, HostPort: "remote:8094"}
+	nodeDefs.NodeDefs[down] = &NodeDef{UUID: down, HostPort: "down:8094"}
+	_, err := CfgSetNodeDefs(cfg, NODE_DEFS_WANTED, nodeDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mgr := NewManager(VERSION, cfg, local, nil,
+		"", 1, "", "", "", "", nil)
+
+	defer mgr.StartActivity(ACTIVITY_COMPACTION, "idx", "", "")()
+
+	prevHttpGet := alertHttpGet
+	defer func() { alertHttpGet = prevHttpGet }()
+
+	alertHttpGet = func(u string) (*http.Response, error) {
+		if u == "http://down:8094/api/activities?local=true" {
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Body:       io.NopCloser(strings.NewReader("")),
+			}, nil
+		}
+		if u != "http://remote:8094/api/activities?local=true" {
+			t.Errorf("unexpected url: %s", u)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body: io.NopCloser(strings.NewReader(`{"status":"ok",` +
+				`"activities":{"node":"n1","activities":[` +
+				`{"kind":"backfill","name":"p1"},` +
+				`{"kind":"compaction","name":"idx"}],` +
+				`"counts":{"backfill":1,"compaction":1}}}`)),
+		}, nil
+	}
+
+	ca, err := mgr.ClusterActivities()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if len(ca.Nodes) != 2 || ca.Nodes[local] == nil || ca.Nodes[remote] == nil {
+		t.Fatalf("unexpected nodes: %#v", ca.Nodes)
+	}
+	if ca.Counts[ACTIVITY_COMPACTION] != 2 || ca.Counts[ACTIVITY_BACKFILL] != 1 {
+		t.Errorf("unexpected counts: %#v", ca.Counts)
+	}
+	if len(ca.Errors) != 1 || ca.Errors[down] == "" {
+		t.Errorf("unexpected errors: %#v", ca.Errors)
+	}
+}
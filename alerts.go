@@ -0,0 +1,845 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// The alerting evaluates threshold-based alerts on each node, so that
+// small deployments get basic alerting without an external monitoring
+// stack.  Each alert is measured by a named AlertProbe, such as the
+// lag of the indexes, and compared against the warning and critical
+// levels of its AlertThreshold, where the changes of the alerts'
+// severities are sent to the registered AlertNotifier's.
+
+// ALERT_THRESHOLDS_KEY is the Cfg key of the alert thresholds.
+const ALERT_THRESHOLDS_KEY = "alertThresholds"
+
+// ALERT_INTERVAL_OPTION is the manager option that holds the number of
+// seconds between the evaluations of the alerts, where a negative
+// value disables the alerting.
+const ALERT_INTERVAL_OPTION = "alertIntervalSecs"
+
+// ALERT_WEBHOOK_URL_OPTION is the manager option that holds the URL
+// that the "webhook" notifier posts the JSON of the alerts to.
+const ALERT_WEBHOOK_URL_OPTION = "alertWebhookURL"
+
+// AlertInterval is the default time between the evaluations of the
+// alerts.
+var AlertInterval = 60 * time.Second
+
+// The names of the built-in alerts.
+const (
+	AlertIndexLag          = "indexLag"          // In mutations.
+	AlertReplicaDivergence = "replicaDivergence" // In mutations.
+	AlertFeedDown          = "feedDown"          // In seconds.
+	AlertDiskUsage         = "diskUsage"         // In percent.
+)
+
+// The severities of an alert.
+const (
+	AlertSeverityWarning  = "warning"
+	AlertSeverityCritical = "critical"
+	AlertSeverityResolved = "resolved"
+)
+
+// AlertEventID is the system event ID of the alerts, see the
+// "eventLog" notifier.
+const AlertEventID uint32 = 3077
+
+// An AlertThreshold holds the levels of an alert, where a level of 0
+// is unused.
+type AlertThreshold struct {
+	Warning  float64 `json:"warning"`
+	Critical float64 `json:"critical"`
+	Disabled bool    `json:"disabled,omitempty"`
+}
+
+// DefaultAlertThresholds are the thresholds of the alerts that aren't
+// overridden in the Cfg.
+var DefaultAlertThresholds = map[string]*AlertThreshold{
+	AlertIndexLag:          {Warning: 100000, Critical: 1000000},
+	AlertReplicaDivergence: {Warning: 10000, Critical: 100000},
+	AlertFeedDown:          {Warning: 60, Critical: 300},
+	AlertDiskUsage:         {Warning: 85, Critical: 95},
+}
+
+// Severity returns the severity of the value, or "" when it's below
+// the levels.
+func (t *AlertThreshold) Severity(value float64) string {
+	if t.Critical > 0 && value >= t.Critical {
+		return AlertSeverityCritical
+	}
+	if t.Warning > 0 && value >= t.Warning {
+		return AlertSeverityWarning
+	}
+	return ""
+}
+
+// AlertThresholds is the Cfg value of the alert thresholds, which
+// override the DefaultAlertThresholds.
+type AlertThresholds struct {
+	UUID        string                     `json:"uuid"`
+	ImplVersion string                     `json:"implVersion"`
+	Thresholds  map[string]*AlertThreshold `json:"thresholds"` // Keyed by alert.
+}
+
+// CfgGetAlertThresholds retrieves the alert thresholds from a Cfg
+// provider, which is nil when there are none.
+func CfgGetAlertThresholds(cfg Cfg) (*AlertThresholds, uint64, error) {
+	v, cas, err := cfg.Get(ALERT_THRESHOLDS_KEY, 0)
+	if err != nil {
+		return nil, cas, err
+	}
+	if v == nil {
+		return nil, cas, nil
+	}
+	rv := &AlertThresholds{}
+	err = UnmarshalJSON(v, rv)
+	if err != nil {
+		return nil, cas, err
+	}
+	if rv.Thresholds == nil {
+		rv.Thresholds = map[string]*AlertThreshold{}
+	}
+	return rv, cas, nil
+}
+
+// CfgSetAlertThresholds updates the alert thresholds on a Cfg provider.
+func CfgSetAlertThresholds(cfg Cfg, thresholds *AlertThresholds,
+	cas uint64) (uint64, error) {
+	buf, err := MarshalJSON(thresholds)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Set(ALERT_THRESHOLDS_KEY, buf, cas)
+}
+
+// CfgSetAlertThreshold creates or replaces the threshold of an alert,
+// where a nil threshold reverts the alert to its default threshold.
+func CfgSetAlertThreshold(cfg Cfg, version, name string,
+	threshold *AlertThreshold) error {
+	if name == "" {
+		return fmt.Errorf("alerts: name is required")
+	}
+
+	if threshold != nil && threshold.Warning > 0 && threshold.Critical > 0 &&
+		threshold.Warning > threshold.Critical {
+		return fmt.Errorf("alerts: name: %s, warning: %v is above"+
+			" critical: %v", name, threshold.Warning, threshold.Critical)
+	}
+
+	return RetryOnCASMismatch(func() error {
+		thresholds, cas, err := CfgGetAlertThresholds(cfg)
+		if err != nil {
+			return err
+		}
+		if thresholds == nil {
+			thresholds = &AlertThresholds{
+				Thresholds: map[string]*AlertThreshold{},
+			}
+		}
+
+		if threshold == nil {
+			if thresholds.Thresholds[name] == nil {
+				return nil
+			}
+			delete(thresholds.Thresholds, name)
+		} else {
+			thresholds.Thresholds[name] = threshold
+		}
+
+		thresholds.UUID = NewUUID()
+		thresholds.ImplVersion = version
+
+		_, err = CfgSetAlertThresholds(cfg, thresholds, cas)
+		return err
+	}, 100)
+}
+
+// EffectiveAlertThresholds returns the DefaultAlertThresholds merged
+// with the thresholds of the Cfg.
+func EffectiveAlertThresholds(cfg Cfg) (map[string]*AlertThreshold, error) {
+	rv := make(map[string]*AlertThreshold, len(DefaultAlertThresholds))
+	for name, t := range DefaultAlertThresholds {
+		rv[name] = t
+	}
+
+	if cfg == nil {
+		return rv, nil
+	}
+
+	thresholds, _, err := CfgGetAlertThresholds(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if thresholds != nil {
+		for name, t := range thresholds.Thresholds {
+			rv[name] = t
+		}
+	}
+
+	return rv, nil
+}
+
+// ------------------------------------------------------------------------
+
+// An AlertMeasurement is a value measured by an AlertProbe for a
+// subject, such as "index:beer-sample".
+type AlertMeasurement struct {
+	Subject string
+	Value   float64
+}
+
+// An AlertProbe measures the values of an alert on the node of the
+// manager.
+type AlertProbe func(mgr *Manager) ([]AlertMeasurement, error)
+
+// An Alert is an alert of a subject whose value is at or above a level
+// of its threshold, or which was resolved.
+type Alert struct {
+	Name      string    `json:"name"`
+	Subject   string    `json:"subject"`
+	Node      string    `json:"node"`
+	Severity  string    `json:"severity"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`       // Of the severity.
+	Since     time.Time `json:"since"`           // When first raised.
+	Time      time.Time `json:"time"`            // When last measured.
+	Prev      string    `json:"prev,omitempty"`  // The previous severity.
+	Error     string    `json:"error,omitempty"` // Of a notifier.
+}
+
+// An AlertNotifier is sent the alerts whose severity changed.
+type AlertNotifier interface {
+	NotifyAlert(mgr *Manager, alert *Alert) error
+}
+
+var alertRegistryM sync.RWMutex
+
+// alertProbes is a registry of the AlertProbe's, keyed by alert name.
+var alertProbes = map[string]AlertProbe{}
+
+// alertNotifiers is a registry of the AlertNotifier's, keyed by name.
+var alertNotifiers = map[string]AlertNotifier{}
+
+// RegisterAlertProbe registers the probe of an alert, which is only
+// evaluated when the alert has a threshold.  Registering a nil probe
+// unregisters the alert.
+func RegisterAlertProbe(name string, probe AlertProbe) {
+	alertRegistryM.Lock()
+	if probe != nil {
+		alertProbes[name] = probe
+	} else {
+		delete(alertProbes, name)
+	}
+	alertRegistryM.Unlock()
+}
+
+// RegisterAlertNotifier registers a notifier under a name, such as to
+// send the alerts to a paging system.  Registering a nil notifier
+// unregisters the name.
+func RegisterAlertNotifier(name string, n AlertNotifier) {
+	alertRegistryM.Lock()
+	if n != nil {
+		alertNotifiers[name] = n
+	} else {
+		delete(alertNotifiers, name)
+	}
+	alertRegistryM.Unlock()
+}
+
+func init() {
+	RegisterAlertProbe(AlertIndexLag, indexLagAlertProbe)
+	RegisterAlertProbe(AlertReplicaDivergence, replicaDivergenceAlertProbe)
+	RegisterAlertProbe(AlertFeedDown, feedDownAlertProbe)
+	RegisterAlertProbe(AlertDiskUsage, diskUsageAlertProbe)
+
+	RegisterAlertNotifier("log", LogAlertNotifier{})
+	RegisterAlertNotifier("webhook", WebhookAlertNotifier{})
+	RegisterAlertNotifier("eventLog", EventLogAlertNotifier{})
+}
+
+// ------------------------------------------------------------------------
+
+// LogAlertNotifier logs the alerts.
+type LogAlertNotifier struct{}
+
+func (LogAlertNotifier) NotifyAlert(mgr *Manager, alert *Alert) error {
+	f := log.Warnf
+	if alert.Severity == AlertSeverityResolved {
+		f = log.Printf
+	}
+	f("alerts: %s, name: %s, subject: %s, value: %v, threshold: %v,"+
+		" since: %v", alert.Severity, alert.Name, alert.Subject,
+		alert.Value, alert.Threshold, alert.Since)
+	return nil
+}
+
+// WebhookAlertNotifier posts the JSON of the alerts to the URL of the
+// ALERT_WEBHOOK_URL_OPTION, when set.
+type WebhookAlertNotifier struct{}
+
+func (WebhookAlertNotifier) NotifyAlert(mgr *Manager, alert *Alert) error {
+	u := mgr.GetOption(ALERT_WEBHOOK_URL_OPTION)
+	if u == "" {
+		return nil
+	}
+
+	buf, err := MarshalJSON(alert)
+	if err != nil {
+		return err
+	}
+
+	client := HttpClient()
+	if client == nil {
+		return fmt.Errorf("alerts: webhook, HttpClient unavailable")
+	}
+
+	resp, err := client.Post(u, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("alerts: webhook, err: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alerts: webhook, status: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// EventLogAlertNotifier publishes the alerts as system events, when
+// the system event listener is started.
+type EventLogAlertNotifier struct{}
+
+func (EventLogAlertNotifier) NotifyAlert(mgr *Manager, alert *Alert) error {
+	severity := "warn"
+	if alert.Severity == AlertSeverityResolved {
+		severity = "info"
+	}
+
+	ev := NewSystemEvent(AlertEventID, severity,
+		"Alert "+alert.Severity+": "+alert.Name,
+		map[string]interface{}{
+			"name":      alert.Name,
+			"subject":   alert.Subject,
+			"node":      alert.Node,
+			"value":     alert.Value,
+			"threshold": alert.Threshold,
+		})
+	if ev == nil {
+		return nil // The system event listener isn't started.
+	}
+
+	return PublishSystemEvent(ev)
+}
+
+// ------------------------------------------------------------------------
+
+// alertKey returns the key of an alert in the Manager.alerts.
+func alertKey(name, subject string) string {
+	return name + "/" + subject
+}
+
+// EvaluateAlerts measures the alerts that have a threshold, and sends
+// the alerts whose severity changed, including the resolved alerts, to
+// the registered notifiers.  Returns the alerts whose severity changed.
+func (mgr *Manager) EvaluateAlerts() ([]*Alert, error) {
+	thresholds, err := EffectiveAlertThresholds(mgr.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	alertRegistryM.RLock()
+	names := make([]string, 0, len(alertProbes))
+	probes := make(map[string]AlertProbe, len(alertProbes))
+	for name, probe := range alertProbes {
+		names = append(names, name)
+		probes[name] = probe
+	}
+	alertRegistryM.RUnlock()
+
+	sort.Strings(names)
+
+	now := time.Now()
+
+	measured := map[string]bool{} // Names whose probes succeeded.
+	raised := map[string]*Alert{} // Keyed by alertKey().
+
+	for _, name := range names {
+		threshold := thresholds[name]
+		if threshold == nil || threshold.Disabled {
+			continue
+		}
+
+		ms, err := probes[name](mgr)
+		if err != nil {
+			log.Warnf("alerts: probe: %s, err: %v", name, err)
+			continue
+		}
+		measured[name] = true
+
+		for _, m := range ms {
+			severity := threshold.Severity(m.Value)
+			if severity == "" {
+				continue
+			}
+
+			level := threshold.Warning
+			if severity == AlertSeverityCritical {
+				level = threshold.Critical
+			}
+
+			raised[alertKey(name, m.Subject)] = &Alert{
+				Name:      name,
+				Subject:   m.Subject,
+				Node:      mgr.uuid,
+				Severity:  severity,
+				Value:     m.Value,
+				Threshold: level,
+				Since:     now,
+				Time:      now,
+			}
+		}
+	}
+
+	var changed []*Alert
+
+	mgr.alertsM.Lock()
+	if mgr.alerts == nil {
+		mgr.alerts = map[string]*Alert{}
+	}
+
+	for key, alert := range raised {
+		prev := mgr.alerts[key]
+		if prev != nil {
+			alert.Since = prev.Since
+			if prev.Severity == alert.Severity {
+				mgr.alerts[key] = alert
+				continue
+			}
+			alert.Prev = prev.Severity
+		}
+		mgr.alerts[key] = alert
+		changed = append(changed, alert)
+	}
+
+	for key, prev := range mgr.alerts {
+		keep := raised[key] != nil
+		if !measured[prev.Name] {
+			// The alerts of a failed probe are kept as they are, unless
+			// their threshold was removed or disabled.
+			t := thresholds[prev.Name]
+			keep = t != nil && !t.Disabled
+		}
+		if keep {
+			continue
+		}
+
+		delete(mgr.alerts, key)
+
+		resolved := *prev
+		resolved.Severity = AlertSeverityResolved
+		resolved.Prev = prev.Severity
+		resolved.Time = now
+		changed = append(changed, &resolved)
+	}
+	mgr.alertsM.Unlock()
+
+	sort.Slice(changed, func(i, j int) bool {
+		return alertKey(changed[i].Name, changed[i].Subject) <
+			alertKey(changed[j].Name, changed[j].Subject)
+	})
+
+	mgr.notifyAlerts(changed)
+
+	return changed, nil
+}
+
+func (mgr *Manager) notifyAlerts(alerts []*Alert) {
+	if len(alerts) == 0 {
+		return
+	}
+
+	alertRegistryM.RLock()
+	notifiers := make(map[string]AlertNotifier, len(alertNotifiers))
+	for name, n := range alertNotifiers {
+		notifiers[name] = n
+	}
+	alertRegistryM.RUnlock()
+
+	for _, alert := range alerts {
+		for name, n := range notifiers {
+			err := n.NotifyAlert(mgr, alert)
+			if err != nil {
+				log.Warnf("alerts: notifier: %s, alert: %s/%s, err: %v",
+					name, alert.Name, alert.Subject, err)
+			}
+		}
+	}
+}
+
+// ActiveAlerts returns the alerts that are currently raised, sorted by
+// name and subject.
+func (mgr *Manager) ActiveAlerts() []*Alert {
+	mgr.alertsM.Lock()
+	rv := make([]*Alert, 0, len(mgr.alerts))
+	for _, alert := range mgr.alerts {
+		a := *alert
+		rv = append(rv, &a)
+	}
+	mgr.alertsM.Unlock()
+
+	sort.Slice(rv, func(i, j int) bool {
+		return alertKey(rv[i].Name, rv[i].Subject) <
+			alertKey(rv[j].Name, rv[j].Subject)
+	})
+
+	return rv
+}
+
+// alertInterval returns the time between the evaluations of the
+// alerts, which is negative when they're disabled.
+func (mgr *Manager) alertInterval() time.Duration {
+	v := mgr.GetOption(ALERT_INTERVAL_OPTION)
+	if v == "" {
+		return AlertInterval
+	}
+
+	secs, err := strconv.Atoi(v)
+	if err != nil {
+		log.Warnf("alerts: option: %s, err: %v", ALERT_INTERVAL_OPTION, err)
+		return AlertInterval
+	}
+	if secs == 0 {
+		return AlertInterval
+	}
+
+	return time.Duration(secs) * time.Second
+}
+
+// AlertLoop periodically evaluates the alerts.
+func (mgr *Manager) AlertLoop() {
+	for {
+		interval := mgr.alertInterval()
+		if interval > 0 && mgr.cfg != nil {
+			_, err := mgr.EvaluateAlerts()
+			if err != nil {
+				log.Warnf("alerts: evaluate, err: %v", err)
+			}
+		} else {
+			// Check again later whether the alerting was enabled.
+			interval = AlertInterval
+		}
+
+		select {
+		case <-mgr.stopCh:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ------------------------------------------------------------------------
+
+// indexLagAlertProbe measures the mutations of each index that the
+// local pindexes haven't processed yet, for the pindexes whose Dest is
+// a DestLastProcessed.
+func indexLagAlertProbe(mgr *Manager) ([]AlertMeasurement, error) {
+	_, pindexes := mgr.CurrentMaps()
+
+	sourceSeqs := map[string]map[string]UUIDSeq{} // Keyed by source.
+	lags := map[string]float64{}                  // Keyed by index.
+
+	for _, pindex := range pindexes {
+		d, ok := pindex.Dest.(DestLastProcessed)
+		if !ok {
+			continue
+		}
+
+		key := pindex.SourceType + "/" + pindex.SourceName + "/" +
+			pindex.SourceUUID
+		seqs, exists := sourceSeqs[key]
+		if !exists {
+			feedType, ok := FeedTypes[pindex.SourceType]
+			if ok && feedType.PartitionSeqs != nil {
+				var err error
+				seqs, err = feedType.PartitionSeqs(pindex.SourceType,
+					pindex.SourceName, pindex.SourceUUID,
+					pindex.SourceParams, mgr.Server(), mgr.Options())
+				if err != nil {
+					log.Warnf("alerts: indexLag, pindex: %s, err: %v",
+						pindex.Name, err)
+				}
+			}
+			sourceSeqs[key] = seqs
+		}
+		if seqs == nil {
+			continue
+		}
+
+		lastProcessed := d.LastProcessed()
+
+		lag := lags[pindex.IndexName]
+		for _, partition := range strings.Split(pindex.SourcePartitions, ",") {
+			uuidSeq, exists := seqs[partition]
+			if exists && uuidSeq.Seq > lastProcessed[partition].Seq {
+				lag += float64(uuidSeq.Seq - lastProcessed[partition].Seq)
+			}
+		}
+		lags[pindex.IndexName] = lag
+	}
+
+	rv := make([]AlertMeasurement, 0, len(lags))
+	for indexName, lag := range lags {
+		rv = append(rv, AlertMeasurement{Subject: "index:" + indexName, Value: lag})
+	}
+
+	return rv, nil
+}
+
+// alertHttpGet is overridable for testing.
+var alertHttpGet = func(u string) (*http.Response, error) {
+	client := HttpClient()
+	if client == nil {
+		return nil, fmt.Errorf("alerts: HttpClient unavailable")
+	}
+	u, err := CBAuthURL(u)
+	if err != nil {
+		return nil, err
+	}
+	return client.Get(u)
+}
+
+// replicaDivergenceAlertProbe measures how many mutations each local
+// replica pindex is behind its primary pindex, as exported by the
+// primary's node, for the pindexes whose Dest is a DestLastProcessed.
+func replicaDivergenceAlertProbe(mgr *Manager) ([]AlertMeasurement, error) {
+	_, pindexes := mgr.CurrentMaps()
+
+	planPIndexes, _, err := mgr.GetPlanPIndexes(false)
+	if err != nil || planPIndexes == nil {
+		return nil, err
+	}
+
+	nodeDefs, err := mgr.GetNodeDefs(NODE_DEFS_WANTED, false)
+	if err != nil || nodeDefs == nil {
+		return nil, err
+	}
+
+	// The primaries' last processed seqs, keyed by node/index, and then
+	// by pindex.
+	exports := map[string]map[string]map[string]QueryPartitionSeq{}
+
+	var rv []AlertMeasurement
+
+	for _, pindex := range pindexes {
+		d, ok := pindex.Dest.(DestLastProcessed)
+		if !ok {
+			continue
+		}
+
+		planPIndex := planPIndexes.PlanPIndexes[pindex.Name]
+		if planPIndex == nil || planPIndex.Nodes[mgr.uuid] == nil ||
+			planPIndex.Nodes[mgr.uuid].Priority <= 0 {
+			continue // Not a replica.
+		}
+
+		var primary *NodeDef
+		for nodeUUID, node := range planPIndex.Nodes {
+			if node.Priority == 0 && nodeDefs.NodeDefs[nodeUUID] != nil {
+				primary = nodeDefs.NodeDefs[nodeUUID]
+			}
+		}
+		if primary == nil {
+			continue
+		}
+
+		key := primary.UUID + "/" + pindex.IndexName
+		export, exists := exports[key]
+		if !exists {
+			export, err = fetchLastProcessed(primary, pindex.IndexName)
+			if err != nil {
+				log.Warnf("alerts: replicaDivergence, pindex: %s,"+
+					" primary: %s, err: %v", pindex.Name, primary.UUID, err)
+			}
+			exports[key] = export
+		}
+
+		primarySeqs, exists := export[pindex.Name]
+		if !exists {
+			continue
+		}
+
+		replicaSeqs := d.LastProcessed()
+
+		var divergence float64
+		for partition, seq := range primarySeqs {
+			if seq.Seq > replicaSeqs[partition].Seq {
+				divergence += float64(seq.Seq - replicaSeqs[partition].Seq)
+			}
+		}
+
+		rv = append(rv, AlertMeasurement{
+			Subject: "pindex:" + pindex.Name,
+			Value:   divergence,
+		})
+	}
+
+	return rv, nil
+}
+
+// fetchLastProcessed returns the last processed seqs of the pindexes
+// of an index on a node, from the node's partition state export, where
+// an indexName of "" means all the node's pindexes.
+func fetchLastProcessed(nodeDef *NodeDef, indexName string) (
+	map[string]map[string]QueryPartitionSeq, error) {
+	hostPortUrl := "http://" + nodeDef.HostPort
+	if u, err := nodeDef.HttpsURL(); err == nil {
+		hostPortUrl = u
+	}
+
+	u := hostPortUrl + "/api/stats/partitionState"
+	if indexName != "" {
+		u = u + "?indexName=" + url.QueryEscape(indexName)
+	}
+
+	resp, err := alertHttpGet(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("alerts: partition state, status: %d",
+			resp.StatusCode)
+	}
+
+	rv := map[string]map[string]QueryPartitionSeq{}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var line struct {
+			Kind          string                       `json:"kind"`
+			Name          string                       `json:"name"`
+			LastProcessed map[string]QueryPartitionSeq `json:"lastProcessed"`
+		}
+		err = json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			return nil, err
+		}
+		if line.Kind == "pindex" && line.LastProcessed != nil {
+			rv[line.Name] = line.LastProcessed
+		}
+	}
+
+	return rv, scanner.Err()
+}
+
+// feedDownAlertProbe measures the seconds that each local pindex,
+// which is planned to ingest, has been without a feed.
+func feedDownAlertProbe(mgr *Manager) ([]AlertMeasurement, error) {
+	feeds, pindexes := mgr.CurrentMaps()
+
+	fed := map[string]bool{}
+	for _, feed := range feeds {
+		for _, dest := range feed.Dests() {
+			for _, pindex := range pindexes {
+				if pindex.Dest == dest {
+					fed[pindex.Name] = true
+				}
+			}
+		}
+	}
+
+	planPIndexes, _, err := mgr.GetPlanPIndexes(false)
+	if err != nil || planPIndexes == nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var rv []AlertMeasurement
+
+	mgr.alertsM.Lock()
+	defer mgr.alertsM.Unlock()
+
+	downSince := map[string]time.Time{}
+
+	for _, pindex := range pindexes {
+		if fed[pindex.Name] || mgr.IsBucketBeingHibernated(pindex.SourceName) {
+			continue
+		}
+
+		// The pindexes whose ingest is paused aren't expected to be fed.
+		planPIndex := planPIndexes.PlanPIndexes[pindex.Name]
+		if planPIndex == nil ||
+			!PlanPIndexNodeCanWrite(planPIndex.Nodes[mgr.uuid]) {
+			continue
+		}
+
+		since, exists := mgr.feedDownSince[pindex.Name]
+		if !exists {
+			since = now
+		}
+		downSince[pindex.Name] = since
+
+		rv = append(rv, AlertMeasurement{
+			Subject: "pindex:" + pindex.Name,
+			Value:   now.Sub(since).Seconds(),
+		})
+	}
+
+	mgr.feedDownSince = downSince
+
+	return rv, nil
+}
+
+// diskUsageAlertProbe measures the percent of the disk used on the
+// node, via the NodeDiskCapacityHook.
+func diskUsageAlertProbe(mgr *Manager) ([]AlertMeasurement, error) {
+	if NodeDiskCapacityHook == nil {
+		return nil, nil
+	}
+
+	nodeDefs, err := mgr.GetNodeDefs(NODE_DEFS_KNOWN, false)
+	if err != nil || nodeDefs == nil {
+		return nil, err
+	}
+
+	nodeDef := nodeDefs.NodeDefs[mgr.uuid]
+	if nodeDef == nil {
+		return nil, nil
+	}
+
+	c, err := NodeDiskCapacityHook(nodeDef)
+	if err != nil || c == nil || c.Total <= 0 {
+		return nil, err
+	}
+
+	return []AlertMeasurement{{
+		Subject: "node:" + mgr.uuid,
+		Value:   float64(c.Used) * 100 / float64(c.Total),
+	}}, nil
+}
@@ -0,0 +1,202 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"sync"
+	"testing"
+)
+
+type testAlertNotifier struct {
+	m      sync.Mutex
+	alerts []*Alert
+}
+
+func (n *testAlertNotifier) NotifyAlert(mgr *Manager, alert *Alert) error {
+	n.m.Lock()
+	n.alerts = append(n.alerts, alert)
+	n.m.Unlock()
+	return nil
+}
+
+func (n *testAlertNotifier) take() []*Alert {
+	n.m.Lock()
+	defer n.m.Unlock()
+	rv := n.alerts
+	n.alerts = nil
+	return rv
+}
+
+func TestAlertThresholdSeverity(t *testing.T) {
+	th := &AlertThreshold{Warning: 10, Critical: 20}
+	for value, expected := range map[float64]string{
+		5:  "",
+		10: AlertSeverityWarning,
+		19: AlertSeverityWarning,
+		20: AlertSeverityCritical,
+	} {
+		if s := th.Severity(value); s != expected {
+			t.Errorf("value: %v, expected: %q, got: %q", value, expected, s)
+		}
+	}
+
+	if s := (&AlertThreshold{Critical: 20}).Severity(15); s != "" {
+		t.Errorf("expected no warning level, got: %q", s)
+	}
+}
+
+func TestCfgSetAlertThreshold(t *testing.T) {
+	cfg := NewCfgMem()
+
+	err := CfgSetAlertThreshold(cfg, VERSION, AlertIndexLag,
+		&AlertThreshold{Warning: 20, Critical: 10})
+	if err == nil {
+		t.Fatalf("expected an err for warning above critical")
+	}
+
+	err = CfgSetAlertThreshold(cfg, VERSION, AlertIndexLag,
+		&AlertThreshold{Warning: 1, Critical: 2})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	thresholds, err := EffectiveAlertThresholds(cfg)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if thresholds[AlertIndexLag].Critical != 2 ||
+		thresholds[AlertDiskUsage] != DefaultAlertThresholds[AlertDiskUsage] {
+		t.Fatalf("unexpected thresholds: %#v", thresholds)
+	}
+
+	err = CfgSetAlertThreshold(cfg, VERSION, AlertIndexLag, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	thresholds, _ = EffectiveAlertThresholds(cfg)
+	if thresholds[AlertIndexLag] != DefaultAlertThresholds[AlertIndexLag] {
+		t.Fatalf("expected the default threshold, got: %#v",
+			thresholds[AlertIndexLag])
+	}
+}
+
+func TestEvaluateAlerts(t *testing.T) {
+	cfg := NewCfgMem()
+	mgr := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	var value float64
+	RegisterAlertProbe("testAlert", func(mgr *Manager) ([]AlertMeasurement, error) {
+		return []AlertMeasurement{{Subject: "s0", Value: value}}, nil
+	})
+	defer RegisterAlertProbe("testAlert", nil)
+
+	n := &testAlertNotifier{}
+	RegisterAlertNotifier("test", n)
+	defer RegisterAlertNotifier("test", nil)
+
+	err := CfgSetAlertThreshold(cfg, VERSION, "testAlert",
+		&AlertThreshold{Warning: 10, Critical: 20})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expect := func(value float64, severities ...string) {
+		mgr.EvaluateAlerts()
+		alerts := n.take()
+		if len(alerts) != len(severities) {
+			t.Fatalf("value: %v, expected: %v, got: %#v",
+				value, severities, alerts)
+		}
+		for i, alert := range alerts {
+			if alert.Name != "testAlert" || alert.Subject != "s0" ||
+				alert.Severity != severities[i] {
+				t.Fatalf("value: %v, unexpected alert: %#v", value, alert)
+			}
+		}
+	}
+
+	value = 5
+	expect(value)
+
+	value = 15
+	expect(value, AlertSeverityWarning)
+	expect(value) // No repeated notification.
+
+	value = 25
+	expect(value, AlertSeverityCritical)
+
+	if alerts := mgr.ActiveAlerts(); len(alerts) != 1 ||
+		alerts[0].Severity != AlertSeverityCritical ||
+		alerts[0].Prev != AlertSeverityWarning {
+		t.Fatalf("unexpected active alerts: %#v", alerts)
+	}
+
+	value = 0
+	expect(value, AlertSeverityResolved)
+
+	if alerts := mgr.ActiveAlerts(); len(alerts) != 0 {
+		t.Fatalf("expected no active alerts, got: %#v", alerts)
+	}
+
+	// Disabling the threshold resolves its alerts.
+	value = 15
+	expect(value, AlertSeverityWarning)
+
+	CfgSetAlertThreshold(cfg, VERSION, "testAlert",
+		&AlertThreshold{Warning: 10, Critical: 20, Disabled: true})
+	expect(value, AlertSeverityResolved)
+}
+
+func TestFeedDownAlertProbe(t *testing.T) {
+	cfg := NewCfgMem()
+	uuid := NewUUID()
+
+	planPIndexes := NewPlanPIndexes(VERSION)
+	planPIndexes.PlanPIndexes["p0"] = &PlanPIndex{
+		Name: "p0",
+		Nodes: map[string]*PlanPIndexNode{
+			uuid: {CanRead: true, CanWrite: true},
+		},
+	}
+	planPIndexes.PlanPIndexes["p1"] = &PlanPIndex{
+		Name: "p1",
+		Nodes: map[string]*PlanPIndexNode{
+			uuid: {CanRead: true, CanWrite: false},
+		},
+	}
+	_, err := CfgSetPlanPIndexes(cfg, planPIndexes, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mgr := NewManager(VERSION, cfg, uuid, nil,
+		"", 1, "", "", "", "", nil)
+
+	mgr.GetPlanPIndexes(true)
+
+	mgr.registerPIndex(&PIndex{Name: "p0", SourceName: "b0"})
+	mgr.registerPIndex(&PIndex{Name: "p1", SourceName: "b0"})
+
+	ms, err := feedDownAlertProbe(mgr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(ms) != 1 || ms[0].Subject != "pindex:p0" {
+		t.Fatalf("expected only the writable pindex, got: %#v", ms)
+	}
+
+	since := mgr.feedDownSince["p0"]
+
+	ms, _ = feedDownAlertProbe(mgr)
+	if len(ms) != 1 || !mgr.feedDownSince["p0"].Equal(since) {
+		t.Fatalf("expected the down since to be kept, got: %#v", ms)
+	}
+}
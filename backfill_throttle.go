@@ -0,0 +1,293 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// A BackfillImpactSample holds the source cluster impact signals
+// observed by a BackfillImpactProbeHook.
+type BackfillImpactSample struct {
+	// OpLatency is a recent, representative (e.g., p99) latency of
+	// the source cluster's data operations.
+	OpLatency time.Duration
+
+	// Backoffs is the cumulative count of backoff responses (e.g.,
+	// temporary failures or DCP flow control pushback) observed from
+	// the source cluster.
+	Backoffs uint64
+}
+
+// BackfillImpactProbeHook is an optional, pluggable callback that
+// allows applications to report source cluster impact signals, which
+// the backfill throttle controller uses to adjust the number of
+// concurrently backfilling DCP streams of a node.  When nil, the
+// controller only reacts to the backoffs observed by the feeds.
+var BackfillImpactProbeHook func(mgr *Manager) (*BackfillImpactSample, error)
+
+// DefaultBackfillThrottleInterval is the default interval between the
+// backfill throttle controller's adjustments, which can be overridden
+// by the "backfillThrottleIntervalMS" manager option.
+var DefaultBackfillThrottleInterval = 5 * time.Second
+
+// BackfillSlotMaxHold bounds how long a DCP stream may hold on to a
+// backfill slot, such as for a stream over an idle vbucket which never
+// reports that it has caught up.
+var BackfillSlotMaxHold = 2 * time.Minute
+
+// ErrBackfillThrottleClosed is returned when a wait for a backfill
+// slot is abandoned because the waiter is closing.
+var ErrBackfillThrottleClosed = fmt.Errorf("backfill_throttle: closed")
+
+// A BackfillThrottle limits the number of DCP streams of a node that
+// are concurrently backfilling (e.g., during initial index builds),
+// where the limit is adjusted between a min and a max with an
+// additive-increase/multiplicative-decrease feedback controller, so
+// that the impact to the source cluster stays within a budget.
+type BackfillThrottle struct {
+	m        sync.Mutex
+	min      int
+	max      int // A max <= 0 means unlimited / disabled.
+	limit    int
+	inflight int
+	waitCh   chan struct{} // Closed and replaced when slots free up.
+
+	backoffs     uint64 // Backoffs observed by the feeds, atomic.
+	lastBackoffs uint64 // Backoffs at the last adjustment.
+
+	TotAcquires    uint64
+	TotWaits       uint64
+	TotDecreases   uint64
+	TotIncreases   uint64
+	TotProbeErrors uint64
+}
+
+// NewBackfillThrottle returns a BackfillThrottle that allows between
+// min and max concurrently backfilling streams, starting at max.
+func NewBackfillThrottle(min, max int) *BackfillThrottle {
+	if min < 1 {
+		min = 1
+	}
+	if max > 0 && max < min {
+		min = max
+	}
+	return &BackfillThrottle{
+		min:    min,
+		max:    max,
+		limit:  max,
+		waitCh: make(chan struct{}),
+	}
+}
+
+// Enabled returns true when the throttle limits backfills.
+func (t *BackfillThrottle) Enabled() bool {
+	return t != nil && t.max > 0
+}
+
+// Limit returns the current number of allowed concurrent backfills.
+func (t *BackfillThrottle) Limit() int {
+	t.m.Lock()
+	limit := t.limit
+	t.m.Unlock()
+	return limit
+}
+
+// Inflight returns the current number of backfills.
+func (t *BackfillThrottle) Inflight() int {
+	t.m.Lock()
+	inflight := t.inflight
+	t.m.Unlock()
+	return inflight
+}
+
+// Acquire blocks until a backfill slot is available, or until the
+// closeCh is closed.
+func (t *BackfillThrottle) Acquire(closeCh <-chan struct{}) error {
+	return t.AcquireShare(closeCh, 1.0)
+}
+
+// AcquireShare is like Acquire, but only takes a slot while fewer
+// than the given share of the limit (at least 1) are in use, such as
+// for the streams of a lower priority class (see PriorityClassShare).
+func (t *BackfillThrottle) AcquireShare(closeCh <-chan struct{},
+	share float64) error {
+	if !t.Enabled() {
+		return nil
+	}
+
+	atomic.AddUint64(&t.TotAcquires, 1)
+
+	var waited bool
+	for {
+		t.m.Lock()
+		limit := t.limit
+		if share > 0 && share < 1 {
+			limit = int(float64(limit) * share)
+			if limit < 1 {
+				limit = 1
+			}
+		}
+		if t.inflight < limit {
+			t.inflight++
+			t.m.Unlock()
+			return nil
+		}
+		waitCh := t.waitCh
+		t.m.Unlock()
+
+		if !waited {
+			waited = true
+			atomic.AddUint64(&t.TotWaits, 1)
+		}
+
+		select {
+		case <-closeCh:
+			return ErrBackfillThrottleClosed
+		case <-waitCh:
+		}
+	}
+}
+
+// Release frees up a backfill slot obtained by Acquire.
+func (t *BackfillThrottle) Release() {
+	if !t.Enabled() {
+		return
+	}
+
+	t.m.Lock()
+	if t.inflight > 0 {
+		t.inflight--
+	}
+	t.wakeWaitersLOCKED()
+	t.m.Unlock()
+}
+
+func (t *BackfillThrottle) wakeWaitersLOCKED() {
+	close(t.waitCh)
+	t.waitCh = make(chan struct{})
+}
+
+// NoteBackoff records a backoff response from the source cluster.
+func (t *BackfillThrottle) NoteBackoff() {
+	if t != nil {
+		atomic.AddUint64(&t.backoffs, 1)
+	}
+}
+
+// Adjust applies a round of feedback control, where the limit is
+// halved when the source cluster is impacted beyond the latency
+// budget or has backed off since the last round, and increased by 1
+// otherwise.  The sample may be nil.
+func (t *BackfillThrottle) Adjust(sample *BackfillImpactSample,
+	latencyBudget time.Duration) {
+	if !t.Enabled() {
+		return
+	}
+
+	backoffs := atomic.LoadUint64(&t.backoffs)
+	if sample != nil {
+		backoffs += sample.Backoffs
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	impacted := backoffs > t.lastBackoffs ||
+		(sample != nil && latencyBudget > 0 && sample.OpLatency > latencyBudget)
+	t.lastBackoffs = backoffs
+
+	prev := t.limit
+	if impacted {
+		t.limit = t.limit / 2
+		if t.limit < t.min {
+			t.limit = t.min
+		}
+	} else if t.limit < t.max {
+		t.limit++
+	}
+
+	if t.limit < prev {
+		atomic.AddUint64(&t.TotDecreases, 1)
+		log.Printf("backfill_throttle: decreased limit: %d -> %d", prev, t.limit)
+	} else if t.limit > prev {
+		atomic.AddUint64(&t.TotIncreases, 1)
+		t.wakeWaitersLOCKED()
+	}
+}
+
+// ------------------------------------------------------------------------
+
+func newBackfillThrottleFromOptions(options map[string]string) *BackfillThrottle {
+	max, _ := ParseOptionsInt(options, "backfillMaxConcurrentStreams")
+	min, _ := ParseOptionsInt(options, "backfillMinConcurrentStreams")
+	return NewBackfillThrottle(min, max)
+}
+
+// BackfillThrottle returns the node's backfill throttle, which is
+// enabled by the "backfillMaxConcurrentStreams" manager option.
+func (mgr *Manager) BackfillThrottle() *BackfillThrottle {
+	return mgr.backfillThrottle
+}
+
+// BackfillThrottleLoop periodically samples the source cluster impact
+// via the BackfillImpactProbeHook and adjusts the backfill throttle,
+// until the manager is stopped.
+func (mgr *Manager) BackfillThrottleLoop() {
+	if !mgr.backfillThrottle.Enabled() {
+		return
+	}
+
+	interval := DefaultBackfillThrottleInterval
+	options := mgr.Options()
+	if v, found := ParseOptionsInt(options, "backfillThrottleIntervalMS"); found && v > 0 {
+		interval = time.Duration(v) * time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mgr.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		var latencyBudget time.Duration
+		v, err := strconv.Atoi(mgr.GetOption("backfillImpactBudgetLatencyMS"))
+		if err == nil && v > 0 {
+			latencyBudget = time.Duration(v) * time.Millisecond
+		}
+
+		var sample *BackfillImpactSample
+		if BackfillImpactProbeHook != nil {
+			sample, err = BackfillImpactProbeHook(mgr)
+			if err != nil {
+				atomic.AddUint64(&mgr.backfillThrottle.TotProbeErrors, 1)
+				log.Warnf("backfill_throttle: probe, err: %v", err)
+				sample = nil
+			}
+		}
+
+		// Back off while the node has eaten into its reserved headroom.
+		if err = mgr.ResourceHeadroomExceeded(); err != nil {
+			log.Printf("backfill_throttle: %v", err)
+			mgr.backfillThrottle.NoteBackoff()
+		}
+
+		mgr.backfillThrottle.Adjust(sample, latencyBudget)
+	}
+}
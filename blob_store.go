@@ -0,0 +1,234 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+// A BlobStore is a remote object store (e.g., S3, Azure Blob Storage or
+// Google Cloud Storage) that's usable for hibernation, where the store
+// is selected by the URI scheme of a hibernation remote path.  Only the
+// s3:// scheme is registered by default, while an application that can
+// provide the clients of another object store registers its BlobStore
+// with RegisterBlobStore().
+type BlobStore interface {
+	// Scheme returns the URI scheme of the store's remote paths, such
+	// as "s3", without the "://".
+	Scheme() string
+
+	// Provider returns the cloud provider of the store's clients.
+	Provider() objval.Provider
+
+	// ParseRemotePath splits a remote path of the form
+	// <scheme>://<bucket>/<key> into its bucket (or container) and key.
+	ParseRemotePath(remotePath string) (bucket, key string, err error)
+
+	// NewClient returns an object store client for the given region.
+	NewClient(region string) (objcli.Client, error)
+}
+
+var blobStoresM sync.Mutex
+var blobStores = map[string]BlobStore{} // Keyed by scheme.
+
+// RegisterBlobStore registers a BlobStore for its URI scheme, which
+// replaces any previously registered BlobStore of the same scheme.
+func RegisterBlobStore(bs BlobStore) {
+	blobStoresM.Lock()
+	blobStores[bs.Scheme()] = bs
+	blobStoresM.Unlock()
+}
+
+// BlobStoreForRemotePath returns the registered BlobStore for the URI
+// scheme of the remote path.  The remote path may be prefixed by a
+// hibernation task, as in "hibernate:s3://<bucket>/<key>".
+func BlobStoreForRemotePath(remotePath string) (BlobStore, error) {
+	scheme := RemotePathScheme(remotePath)
+	if scheme == "" {
+		return nil, fmt.Errorf("blob_store: no scheme, remotePath: %s",
+			remotePath)
+	}
+
+	blobStoresM.Lock()
+	bs, exists := blobStores[scheme]
+	blobStoresM.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("blob_store: unknown scheme: %s,"+
+			" remotePath: %s", scheme, remotePath)
+	}
+
+	return bs, nil
+}
+
+// RemotePathScheme returns the URI scheme of a remote path, ignoring
+// any hibernation task prefix, or "" when there's no scheme.
+func RemotePathScheme(remotePath string) string {
+	i := strings.Index(remotePath, "://")
+	if i < 0 {
+		return ""
+	}
+	scheme := remotePath[:i]
+	if j := strings.LastIndex(scheme, ":"); j >= 0 {
+		scheme = scheme[j+1:]
+	}
+	return strings.ToLower(scheme)
+}
+
+// ParseBlobStoreRemotePath parses the remote path with the BlobStore
+// of its URI scheme.
+func ParseBlobStoreRemotePath(remotePath string) (
+	BlobStore, string, string, error) {
+	bs, err := BlobStoreForRemotePath(remotePath)
+	if err != nil {
+		return nil, "", "", err
+	}
+	bucket, key, err := bs.ParseRemotePath(remotePath)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return bs, bucket, key, nil
+}
+
+// BlobStoreGet downloads the object at the bucket and key.
+func BlobStoreGet(ctx context.Context, client objcli.Client,
+	bucket, key string) ([]byte, error) {
+	obj, err := client.GetObject(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("blob_store: get, bucket: %s, key: %s,"+
+			" err: %v", bucket, key, err)
+	}
+	defer obj.Body.Close()
+
+	return io.ReadAll(obj.Body)
+}
+
+// BlobStorePut uploads the data as the object at the bucket and key.
+func BlobStorePut(ctx context.Context, client objcli.Client,
+	bucket, key string, data []byte) error {
+	err := client.PutObject(ctx, bucket, key, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("blob_store: put, bucket: %s, key: %s,"+
+			" err: %v", bucket, key, err)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------
+
+// A CloudBlobStore is a BlobStore of a major cloud's object store,
+// whose clients are created by its ClientHook, or by the
+// HibernationClientHook when the ClientHook is nil.  A CloudBlobStore
+// only defines the remote paths of its scheme, as cbgt itself provides
+// no object store clients.
+type CloudBlobStore struct {
+	scheme   string
+	provider objval.Provider
+
+	// bucketRE validates the bucket (or container) names.
+	bucketRE *regexp.Regexp
+
+	ClientHook func(region string) (objcli.Client, error)
+}
+
+// S3BlobStore is the BlobStore of remote paths of the form
+// s3://<s3-bucket-name>/<key>.
+var S3BlobStore = &CloudBlobStore{
+	scheme:   "s3",
+	provider: objval.ProviderAWS,
+	bucketRE: regexp.MustCompile(`^[a-z0-9][a-z0-9.\-]{1,61}[a-z0-9]$`),
+}
+
+// AzureBlobStore is the BlobStore of Azure Blob Storage, with remote
+// paths of the form az://<container-name>/<key>.  It's not registered
+// by default, so an application that provides the Azure clients
+// registers it, as in:
+//
+//	cbgt.AzureBlobStore.ClientHook = newAzureClient
+//	cbgt.RegisterBlobStore(cbgt.AzureBlobStore)
+var AzureBlobStore = &CloudBlobStore{
+	scheme:   "az",
+	provider: objval.ProviderAzure,
+	bucketRE: regexp.MustCompile(`^[a-z0-9](-?[a-z0-9])+$`),
+}
+
+// GCSBlobStore is the BlobStore of Google Cloud Storage, with remote
+// paths of the form gs://<gcs-bucket-name>/<key>.  Like the
+// AzureBlobStore, it's registered by an application that provides the
+// GCS clients.
+var GCSBlobStore = &CloudBlobStore{
+	scheme:   "gs",
+	provider: objval.ProviderGCP,
+	bucketRE: regexp.MustCompile(`^[a-z0-9][a-z0-9_.\-]{1,220}[a-z0-9]$`),
+}
+
+func init() {
+	RegisterBlobStore(S3BlobStore)
+}
+
+func (s *CloudBlobStore) Scheme() string {
+	return s.scheme
+}
+
+func (s *CloudBlobStore) Provider() objval.Provider {
+	return s.provider
+}
+
+func (s *CloudBlobStore) ParseRemotePath(remotePath string) (
+	string, string, error) {
+	if RemotePathScheme(remotePath) != s.scheme {
+		return "", "", fmt.Errorf("blob_store: expected scheme: %s,"+
+			" remotePath: %s", s.scheme, remotePath)
+	}
+
+	rest := remotePath[strings.Index(remotePath, "://")+3:]
+
+	bucket, key, _ := strings.Cut(rest, "/")
+	key = strings.Trim(key, "/")
+	if key == "" {
+		return "", "", fmt.Errorf("blob_store: missing key,"+
+			" remotePath: %s", remotePath)
+	}
+
+	// Only GCS allows bucket names longer than 63, when they're dotted.
+	if len(bucket) < 3 || (len(bucket) > 63 && s.provider != objval.ProviderGCP) ||
+		!s.bucketRE.MatchString(bucket) {
+		return "", "", fmt.Errorf("blob_store: invalid %s bucket name: %q,"+
+			" remotePath: %s", s.provider, bucket, remotePath)
+	}
+
+	return bucket, key, nil
+}
+
+func (s *CloudBlobStore) NewClient(region string) (objcli.Client, error) {
+	clientHook := s.ClientHook
+	if clientHook == nil {
+		clientHook = HibernationClientHook
+	}
+
+	client, err := clientHook(region)
+	if err != nil {
+		return nil, err
+	}
+
+	if client != nil && client.Provider() != s.provider {
+		return nil, fmt.Errorf("blob_store: %s client for scheme: %s",
+			client.Provider(), s.scheme)
+	}
+
+	return client, nil
+}
@@ -0,0 +1,63 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"testing"
+)
+
+func TestParseBlobStoreRemotePath(t *testing.T) {
+	// Only the s3:// scheme is registered by default.
+	if _, _, _, err := ParseBlobStoreRemotePath("az://container/k"); err == nil {
+		t.Fatalf("expected an unregistered az:// scheme")
+	}
+
+	RegisterBlobStore(AzureBlobStore)
+	RegisterBlobStore(GCSBlobStore)
+	defer func() {
+		blobStoresM.Lock()
+		delete(blobStores, AzureBlobStore.Scheme())
+		delete(blobStores, GCSBlobStore.Scheme())
+		blobStoresM.Unlock()
+	}()
+
+	tests := []struct {
+		remotePath string
+		scheme     string
+		bucket     string
+		key        string
+		expErr     bool
+	}{
+		{"s3://bkt/a/b", "s3", "bkt", "a/b", false},
+		{"hibernate:s3://bkt/a", "s3", "bkt", "a", false},
+		{"az://my-container/tenant/x/", "az", "my-container", "tenant/x", false},
+		{"unhibernate:gs://my_bucket.x/k", "gs", "my_bucket.x", "k", false},
+		{"az://my_container/k", "", "", "", true},
+		{"az://my--container/k", "", "", "", true},
+		{"gs://bkt", "", "", "", true},
+		{"ftp://bkt/k", "", "", "", true},
+		{"/tmp/bkt/k", "", "", "", true},
+	}
+
+	for i, test := range tests {
+		bs, bucket, key, err := ParseBlobStoreRemotePath(test.remotePath)
+		if (err != nil) != test.expErr {
+			t.Errorf("test: %d, remotePath: %s, expErr: %v, err: %v",
+				i, test.remotePath, test.expErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if bs.Scheme() != test.scheme || bucket != test.bucket || key != test.key {
+			t.Errorf("test: %d, remotePath: %s, got: %s %s %s",
+				i, test.remotePath, bs.Scheme(), bucket, key)
+		}
+	}
+}
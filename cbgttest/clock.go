@@ -0,0 +1,107 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgttest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Clock is the source of time of a Cluster, so that the deadlines
+// of its waits can be made independent of the speed of the machine
+// that runs the tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is a Clock that follows the wall clock.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time { return time.Now() }
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// A VirtualClock is a Clock that only moves forward when it's
+// advanced, which fires the waiters whose deadlines were reached in
+// the order of their deadlines.
+type VirtualClock struct {
+	m       sync.Mutex
+	now     time.Time
+	waiters []*virtualClockWaiter
+}
+
+type virtualClockWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// NewVirtualClock returns a VirtualClock that starts at the given time.
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.now
+}
+
+func (c *VirtualClock) After(d time.Duration) <-chan time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, &virtualClockWaiter{
+		deadline: c.now.Add(d),
+		ch:       ch,
+	})
+
+	return ch
+}
+
+// Advance moves the clock forward and fires the waiters whose
+// deadlines were reached.
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.now = c.now.Add(d)
+
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].deadline.Before(c.waiters[j].deadline)
+	})
+
+	var waiters []*virtualClockWaiter
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- w.deadline
+	}
+	c.waiters = waiters
+}
+
+// Waiters returns the number of waiters that haven't fired yet.
+func (c *VirtualClock) Waiters() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return len(c.waiters)
+}
@@ -0,0 +1,484 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+// Package cbgttest provides a harness for multi-node integration
+// tests, which runs several Managers with their real planner and
+// janitor loops in a single process, on a shared Cfg, with the
+// "primary" feed as the data source, so that cbgt and the services
+// built on it can test index creation, topology changes and
+// rebalances end to end.
+package cbgttest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/rebalance"
+)
+
+// RebalancedNodeTags are the node tags of a cluster whose topology
+// changes are made by Cluster.Rebalance, which leave out the planner,
+// as its loops would otherwise compete with the rebalancer.
+var RebalancedNodeTags = []string{"feed", "pindex", "janitor", "queryer"}
+
+// DefaultPollInterval is the default time, on the Clock of a Cluster,
+// between the checks of its waits.
+var DefaultPollInterval = 100 * time.Millisecond
+
+// DefaultWaitTimeout is the default time, on the Clock of a Cluster,
+// that its waits give up after.
+var DefaultWaitTimeout = 60 * time.Second
+
+// RealPollInterval is the wall clock time between the checks of the
+// waits of a Cluster with a VirtualClock, which lets the Managers make
+// progress before the VirtualClock is advanced.
+var RealPollInterval = 10 * time.Millisecond
+
+// ClusterOptions are the options of a Cluster, where the zero value
+// is a cluster of nodes with all the roles, on an in-memory Cfg, and
+// with a VirtualClock.
+type ClusterOptions struct {
+	// Dir is the parent of the data directories of the nodes, which
+	// defaults to a temporary directory that's removed on Close.
+	Dir string
+
+	// Cfg defaults to a CfgSimple when CfgPath is set, or to a CfgMem.
+	Cfg     cbgt.Cfg
+	CfgPath string
+
+	// Clock defaults to a VirtualClock.
+	Clock Clock
+
+	// Tags are the node tags, where nil means all the roles.
+	Tags []string
+
+	// ManagerOptions are the options of every Manager.
+	ManagerOptions map[string]string
+
+	PollInterval time.Duration
+	WaitTimeout  time.Duration
+}
+
+// A Node is a Manager of a Cluster, where the node's name is also its
+// UUID and bindHttp.
+type Node struct {
+	Name    string
+	Dir     string
+	Manager *cbgt.Manager
+}
+
+// A Cluster is a set of Nodes on a shared Cfg.
+type Cluster struct {
+	options ClusterOptions
+	cfg     cbgt.Cfg
+	clock   Clock
+	dir     string
+	tempDir bool
+
+	m     sync.Mutex
+	nodes map[string]*Node // Keyed by node name, running nodes only.
+	seqs  map[string]uint64
+}
+
+// NewCluster returns a Cluster without any nodes, see AddNode.
+func NewCluster(options ClusterOptions) (*Cluster, error) {
+	c := &Cluster{
+		options: options,
+		cfg:     options.Cfg,
+		clock:   options.Clock,
+		dir:     options.Dir,
+		nodes:   map[string]*Node{},
+		seqs:    map[string]uint64{},
+	}
+
+	if c.dir == "" {
+		dir, err := os.MkdirTemp("", "cbgttest")
+		if err != nil {
+			return nil, fmt.Errorf("cbgttest: NewCluster, err: %v", err)
+		}
+		c.dir = dir
+		c.tempDir = true
+	}
+
+	if c.cfg == nil {
+		if options.CfgPath != "" {
+			cfg := cbgt.NewCfgSimple(options.CfgPath)
+			if _, err := os.Stat(options.CfgPath); err == nil {
+				err = cfg.Load()
+				if err != nil {
+					c.Close()
+					return nil, fmt.Errorf("cbgttest: NewCluster,"+
+						" cfgPath: %s, err: %v", options.CfgPath, err)
+				}
+			}
+			c.cfg = cfg
+		} else {
+			c.cfg = cbgt.NewCfgMem()
+		}
+	}
+
+	if c.clock == nil {
+		c.clock = NewVirtualClock(time.Unix(0, 0))
+	}
+
+	if c.options.PollInterval <= 0 {
+		c.options.PollInterval = DefaultPollInterval
+	}
+	if c.options.WaitTimeout <= 0 {
+		c.options.WaitTimeout = DefaultWaitTimeout
+	}
+
+	return c, nil
+}
+
+// Cfg returns the shared Cfg of the cluster.
+func (c *Cluster) Cfg() cbgt.Cfg { return c.cfg }
+
+// Clock returns the Clock of the cluster.
+func (c *Cluster) Clock() Clock { return c.clock }
+
+// AddNode starts a node and registers it as wanted.
+func (c *Cluster) AddNode(name string) (*Node, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.nodes[name] != nil {
+		return nil, fmt.Errorf("cbgttest: AddNode, node: %s, already running",
+			name)
+	}
+
+	dir := filepath.Join(c.dir, name)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("cbgttest: AddNode, node: %s, err: %v",
+			name, err)
+	}
+
+	options := map[string]string{}
+	for k, v := range c.options.ManagerOptions {
+		options[k] = v
+	}
+
+	mgr := cbgt.NewManagerEx(cbgt.VERSION, c.cfg, name, c.options.Tags,
+		"", 1, "", name, dir, ".", nil, options)
+
+	err = mgr.Start("wanted")
+	if err != nil {
+		mgr.Stop()
+		return nil, fmt.Errorf("cbgttest: AddNode, node: %s, err: %v",
+			name, err)
+	}
+
+	node := &Node{Name: name, Dir: dir, Manager: mgr}
+	c.nodes[name] = node
+
+	return node, nil
+}
+
+// Node returns the running node with the given name, or nil.
+func (c *Cluster) Node(name string) *Node {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.nodes[name]
+}
+
+// Nodes returns the sorted names of the running nodes.
+func (c *Cluster) Nodes() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	rv := make([]string, 0, len(c.nodes))
+	for name := range c.nodes {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+
+	return rv
+}
+
+// StopNode shuts a node down while leaving it registered, as when a
+// node fails.  The node can be started again with AddNode.
+func (c *Cluster) StopNode(name string) error {
+	c.m.Lock()
+	node := c.nodes[name]
+	delete(c.nodes, name)
+	c.m.Unlock()
+
+	if node == nil {
+		return fmt.Errorf("cbgttest: StopNode, node: %s, not running", name)
+	}
+
+	return node.Manager.Shutdown(node.Manager.ShutdownDeadline())
+}
+
+// RemoveNode shuts a node down, if it's running, and unregisters it.
+func (c *Cluster) RemoveNode(name string) error {
+	if c.Node(name) != nil {
+		err := c.StopNode(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return cbgt.UnregisterNodes(c.cfg, cbgt.VERSION, []string{name})
+}
+
+// anyManager returns the Manager of a running node.
+func (c *Cluster) anyManager() (*cbgt.Manager, error) {
+	names := c.Nodes()
+	if len(names) == 0 {
+		return nil, fmt.Errorf("cbgttest: no running nodes")
+	}
+
+	return c.Node(names[0]).Manager, nil
+}
+
+// CreateIndex creates an index with a "primary" source of the given
+// number of partitions, where an indexType of "" means "blackhole".
+func (c *Cluster) CreateIndex(indexType, indexName, indexParams string,
+	numPartitions int, planParams cbgt.PlanParams) error {
+	mgr, err := c.anyManager()
+	if err != nil {
+		return err
+	}
+
+	if indexType == "" {
+		indexType = "blackhole"
+	}
+
+	sourceParams := fmt.Sprintf(`{"numPartitions":%d}`, numPartitions)
+
+	return mgr.CreateIndex("primary", indexName, "", sourceParams,
+		indexType, indexName, indexParams, planParams, "")
+}
+
+// DeleteIndex deletes an index.
+func (c *Cluster) DeleteIndex(indexName string) error {
+	mgr, err := c.anyManager()
+	if err != nil {
+		return err
+	}
+
+	return mgr.DeleteIndex(indexName)
+}
+
+// DataUpdate sends a mutation of the source partition of an index to
+// every running node with a feed for that partition, which are the
+// nodes of the partition's pindex and its replicas.  The seqs of each
+// partition are assigned in order, starting at 1.
+func (c *Cluster) DataUpdate(indexName, partition string,
+	key, val []byte) error {
+	var dests []*cbgt.PrimaryFeed
+
+	for _, name := range c.Nodes() {
+		node := c.Node(name)
+		if node == nil {
+			continue
+		}
+
+		feeds, _ := node.Manager.CurrentMaps()
+		for _, feed := range feeds {
+			if feed.IndexName() != indexName {
+				continue
+			}
+			if _, exists := feed.Dests()[partition]; exists {
+				if f, ok := feed.(*cbgt.PrimaryFeed); ok {
+					dests = append(dests, f)
+				}
+			}
+		}
+	}
+
+	if len(dests) == 0 {
+		return fmt.Errorf("cbgttest: DataUpdate, index: %s, partition: %s,"+
+			" no feeds", indexName, partition)
+	}
+
+	c.m.Lock()
+	c.seqs[indexName+"/"+partition]++
+	seq := c.seqs[indexName+"/"+partition]
+	c.m.Unlock()
+
+	for _, dest := range dests {
+		err := dest.DataUpdate(partition, key, seq, val, 0,
+			cbgt.DEST_EXTRAS_TYPE_NIL, nil)
+		if err != nil {
+			return fmt.Errorf("cbgttest: DataUpdate, index: %s,"+
+				" partition: %s, err: %v", indexName, partition, err)
+		}
+	}
+
+	return nil
+}
+
+// Converged returns true when every index is planned, every planned
+// node is wanted, and every running node runs exactly the pindexes
+// that the plan assigns to it, or else the reason why not.
+func (c *Cluster) Converged() (bool, string) {
+	indexDefs, nodeDefs, planPIndexes, _, err :=
+		cbgt.PlannerGetPlan(c.cfg, cbgt.VERSION, "")
+	if err != nil {
+		return false, err.Error()
+	}
+
+	planned := map[string]bool{}    // Keyed by index name.
+	wanted := map[string][]string{} // Keyed by node name.
+
+	if planPIndexes != nil {
+		for _, planPIndex := range planPIndexes.PlanPIndexes {
+			planned[planPIndex.IndexName] = true
+			for node := range planPIndex.Nodes {
+				if nodeDefs == nil || nodeDefs.NodeDefs[node] == nil {
+					return false, fmt.Sprintf("pindex: %s, planned on"+
+						" unwanted node: %s", planPIndex.Name, node)
+				}
+				wanted[node] = append(wanted[node], planPIndex.Name)
+			}
+		}
+	}
+
+	if indexDefs != nil {
+		for indexName := range indexDefs.IndexDefs {
+			if !planned[indexName] {
+				return false, fmt.Sprintf("index: %s, not planned", indexName)
+			}
+		}
+	}
+
+	for _, name := range c.Nodes() {
+		node := c.Node(name)
+		if node == nil {
+			continue
+		}
+
+		_, pindexes := node.Manager.CurrentMaps()
+
+		var have []string
+		for pindexName := range pindexes {
+			have = append(have, pindexName)
+		}
+
+		sort.Strings(have)
+		sort.Strings(wanted[name])
+
+		if strings.Join(have, ",") != strings.Join(wanted[name], ",") {
+			return false, fmt.Sprintf("node: %s, has pindexes: %v,"+
+				" wanted: %v", name, have, wanted[name])
+		}
+	}
+
+	return true, ""
+}
+
+// WaitFor checks the condition every PollInterval until it's true,
+// returning an error with the last reason that it gave when it's
+// still false after the WaitTimeout, as measured on the Clock.
+func (c *Cluster) WaitFor(cond func() (bool, string)) error {
+	deadline := c.clock.Now().Add(c.options.WaitTimeout)
+
+	for {
+		ok, reason := cond()
+		if ok {
+			return nil
+		}
+
+		if !c.clock.Now().Before(deadline) {
+			return fmt.Errorf("cbgttest: WaitFor, timeout: %v, reason: %s",
+				c.options.WaitTimeout, reason)
+		}
+
+		ch := c.clock.After(c.options.PollInterval)
+		if vc, ok := c.clock.(*VirtualClock); ok {
+			time.Sleep(RealPollInterval)
+			vc.Advance(c.options.PollInterval)
+		}
+		<-ch
+	}
+}
+
+// WaitForConvergence waits until the cluster has Converged.
+func (c *Cluster) WaitForConvergence() error {
+	return c.WaitFor(c.Converged)
+}
+
+// Rebalance rebalances the cluster onto its wanted nodes, then removes
+// the given nodes.  The partition seq checks are skipped, as the
+// "primary" feed has no seqs to catch up to.
+func (c *Cluster) Rebalance(nodesToRemove ...string) error {
+	r, err := rebalance.StartRebalance(cbgt.VERSION, c.cfg, ".", nil,
+		nodesToRemove,
+		rebalance.RebalanceOptions{
+			HttpGet:       c.httpGet,
+			SkipSeqChecks: true,
+		})
+	if err != nil {
+		return fmt.Errorf("cbgttest: Rebalance, err: %v", err)
+	}
+
+	for progress := range r.ProgressCh() {
+		if progress.Error != nil && err == nil {
+			err = progress.Error
+		}
+	}
+
+	r.Stop()
+
+	if err != nil {
+		return fmt.Errorf("cbgttest: Rebalance, err: %v", err)
+	}
+
+	for _, name := range nodesToRemove {
+		err = c.RemoveNode(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// httpGet stands in for the REST stats requests of the rebalancer,
+// which aren't needed when its seq checks are skipped.
+func (c *Cluster) httpGet(url string) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewBufferString("{}")),
+	}, nil
+}
+
+// Close shuts down the running nodes, and removes the data
+// directories when they're in a temporary directory.
+func (c *Cluster) Close() error {
+	var firstErr error
+
+	for _, name := range c.Nodes() {
+		err := c.StopNode(name)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if c.tempDir {
+		err := os.RemoveAll(c.dir)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,156 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgttest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbgt"
+)
+
+func TestVirtualClock(t *testing.T) {
+	c := NewVirtualClock(time.Unix(0, 0))
+
+	ch1 := c.After(time.Second)
+	ch2 := c.After(2 * time.Second)
+
+	c.Advance(time.Second)
+	select {
+	case <-ch1:
+	default:
+		t.Fatalf("expected ch1 to fire")
+	}
+	select {
+	case <-ch2:
+		t.Fatalf("expected ch2 to not fire yet")
+	default:
+	}
+
+	if c.Waiters() != 1 {
+		t.Fatalf("expected 1 waiter, got: %d", c.Waiters())
+	}
+
+	c.Advance(time.Minute)
+	if at := <-ch2; !at.Equal(time.Unix(2, 0)) {
+		t.Fatalf("expected ch2 to fire at its deadline, got: %v", at)
+	}
+	if !c.Now().Equal(time.Unix(61, 0)) {
+		t.Fatalf("expected now to be 61s, got: %v", c.Now())
+	}
+}
+
+func TestClusterPlanner(t *testing.T) {
+	c, err := NewCluster(ClusterOptions{})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	defer c.Close()
+
+	for _, name := range []string{"a", "b"} {
+		if _, err = c.AddNode(name); err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+
+	err = c.CreateIndex("", "x", "", 4,
+		cbgt.PlanParams{MaxPartitionsPerPIndex: 1})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = c.WaitForConvergence(); err != nil {
+		t.Fatalf("expected convergence, got: %v", err)
+	}
+
+	if err = c.DataUpdate("x", "0", []byte("k"), []byte("v")); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = c.DataUpdate("x", "99", []byte("k"), []byte("v")); err == nil {
+		t.Fatalf("expected an err for an unknown partition")
+	}
+
+	if _, err = c.AddNode("c"); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = c.RemoveNode("a"); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = c.WaitForConvergence(); err != nil {
+		t.Fatalf("expected convergence, got: %v", err)
+	}
+
+	_, pindexes := c.Node("c").Manager.CurrentMaps()
+	if len(pindexes) == 0 {
+		t.Fatalf("expected pindexes on the added node")
+	}
+}
+
+func TestClusterRebalance(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cbgttest")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewCluster(ClusterOptions{
+		Dir:     dir,
+		CfgPath: filepath.Join(dir, "cfg.json"),
+		Tags:    RebalancedNodeTags,
+	})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	defer c.Close()
+
+	for _, name := range []string{"a", "b"} {
+		if _, err = c.AddNode(name); err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+
+	err = c.CreateIndex("", "x", "", 4,
+		cbgt.PlanParams{MaxPartitionsPerPIndex: 1})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	// Without a planner, the index is only planned by a rebalance.
+	if ok, _ := c.Converged(); ok {
+		t.Fatalf("expected no convergence before the rebalance")
+	}
+
+	if err = c.Rebalance(); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = c.WaitForConvergence(); err != nil {
+		t.Fatalf("expected convergence, got: %v", err)
+	}
+
+	if _, err = c.AddNode("c"); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = c.Rebalance("a"); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = c.WaitForConvergence(); err != nil {
+		t.Fatalf("expected convergence, got: %v", err)
+	}
+
+	nodeDefs, _, err := cbgt.CfgGetNodeDefs(c.Cfg(), cbgt.NODE_DEFS_WANTED)
+	if err != nil || nodeDefs.NodeDefs["a"] != nil ||
+		len(nodeDefs.NodeDefs) != 2 {
+		t.Fatalf("expected nodes b and c, got: %+v, err: %v", nodeDefs, err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "cfg.json")); err != nil {
+		t.Fatalf("expected a cfg file, got: %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// CFG_ARCHIVE_VERSION is the format version of the CfgArchive's that
+// are exported by this node, where newer versions can't be imported.
+const CFG_ARCHIVE_VERSION = 1
+
+// CfgArchiveMaxAttempts is the number of times that the Cfg is re-read
+// to export a consistent CfgArchive, before the archive is returned as
+// inconsistent.
+var CfgArchiveMaxAttempts = 10
+
+// CfgArchiveKeys are the Cfg keys that are exported into a CfgArchive
+// by default, in the order that they're restored, so that the
+// definitions are restored before the plan.  Applications may append
+// their own keys.
+var CfgArchiveKeys = []string{
+	MANAGER_CLUSTER_OPTIONS_KEY,
+	FEATURE_FLAGS_KEY,
+	ALERT_THRESHOLDS_KEY,
+	QUERY_MIRRORS_KEY,
+	INDEX_DEFS_KEY,
+	INDEX_TRASH_KEY,
+	SHADOW_COPY_DEFS_KEY,
+	CfgNodeDefsKey(NODE_DEFS_KNOWN),
+	CfgNodeDefsKey(NODE_DEFS_WANTED),
+	PLAN_PINDEXES_KEY,
+	LAST_REBALANCE_STATUS_KEY,
+}
+
+// A CfgArchive is the state of a Cfg's keys, in a single versioned
+// document, for disaster recovery or for the cloning of the index
+// definitions, node definitions and plan into another environment.
+type CfgArchive struct {
+	Version     int       `json:"version"`
+	ImplVersion string    `json:"implVersion"` // Of the exporting node.
+	CreatedAt   time.Time `json:"createdAt"`
+
+	// Consistent is true when the Cfg's keys were unchanged while they
+	// were read, so that they're from a single point in time.
+	Consistent bool `json:"consistent"`
+
+	Entries []*CfgArchiveEntry `json:"entries"`
+}
+
+// A CfgArchiveEntry is a Cfg key's value and CAS, where the keys that
+// don't exist aren't archived.
+type CfgArchiveEntry struct {
+	Key string          `json:"key"`
+	CAS uint64          `json:"cas"`
+	Val json.RawMessage `json:"val"`
+}
+
+// CfgExport returns a CfgArchive of the keys of the cfg, or of the
+// CfgArchiveKeys and the current plan format's key when keys is nil.
+// The keys are re-read until they're unchanged between two reads.
+func CfgExport(cfg Cfg, keys []string) (*CfgArchive, error) {
+	if keys == nil {
+		keys = append([]string(nil), CfgArchiveKeys...)
+		if CurrentPlanFormat != nil &&
+			CurrentPlanFormat.Version > PLAN_PINDEXES_FORMAT_LEGACY {
+			keys = append(keys, CurrentPlanFormat.Key)
+		}
+	}
+
+	rv := &CfgArchive{
+		Version:     CFG_ARCHIVE_VERSION,
+		ImplVersion: VERSION,
+	}
+
+	var prev []*CfgArchiveEntry
+
+	for i := 0; i < CfgArchiveMaxAttempts && !rv.Consistent; i++ {
+		entries, err := readCfgArchiveEntries(cfg, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		rv.Entries = entries
+		rv.Consistent = prev != nil && sameCfgArchiveCASes(prev, entries)
+
+		prev = entries
+	}
+
+	rv.CreatedAt = time.Now()
+
+	if !rv.Consistent {
+		log.Warnf("cfg_archive: export, inconsistent after attempts: %d",
+			CfgArchiveMaxAttempts)
+	}
+
+	return rv, nil
+}
+
+func readCfgArchiveEntries(cfg Cfg, keys []string) (
+	[]*CfgArchiveEntry, error) {
+	rv := make([]*CfgArchiveEntry, 0, len(keys))
+
+	for _, key := range keys {
+		val, cas, err := cfg.Get(key, 0)
+		if err != nil {
+			return nil, fmt.Errorf("cfg_archive: get, key: %s, err: %v",
+				key, err)
+		}
+		if val == nil {
+			continue
+		}
+		if !json.Valid(val) {
+			return nil, fmt.Errorf("cfg_archive: key: %s,"+
+				" value is not json", key)
+		}
+
+		rv = append(rv, &CfgArchiveEntry{Key: key, CAS: cas, Val: val})
+	}
+
+	return rv, nil
+}
+
+func sameCfgArchiveCASes(a, b []*CfgArchiveEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || a[i].CAS != b[i].CAS {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteCfgArchive writes the archive as JSON into w.
+func WriteCfgArchive(w io.Writer, archive *CfgArchive) error {
+	buf, err := MarshalJSON(archive)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadCfgArchive reads a CfgArchive from r, and checks that its format
+// version is one that this node understands.
+func ReadCfgArchive(r io.Reader) (*CfgArchive, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var archive CfgArchive
+	err = UnmarshalJSON(buf, &archive)
+	if err != nil {
+		return nil, fmt.Errorf("cfg_archive: read, err: %v", err)
+	}
+
+	if archive.Version <= 0 || archive.Version > CFG_ARCHIVE_VERSION {
+		return nil, fmt.Errorf("cfg_archive: unsupported version: %d",
+			archive.Version)
+	}
+
+	for _, entry := range archive.Entries {
+		if entry.Key == "" || len(entry.Val) == 0 {
+			return nil, fmt.Errorf("cfg_archive: invalid entry, key: %q",
+				entry.Key)
+		}
+	}
+
+	return &archive, nil
+}
+
+// CfgRestoreOptions are the options of a CfgRestore.
+type CfgRestoreOptions struct {
+	// Keys are the keys of the archive that are restored, where nil
+	// means all of them, such as for the cloning of only the index
+	// definitions into another environment.
+	Keys []string
+
+	// DryRun reports what would be restored without changing the Cfg.
+	DryRun bool
+}
+
+// A CfgRestoreResult is the outcome of the restore of a key.
+type CfgRestoreResult struct {
+	Key    string `json:"key"`
+	Action string `json:"action"` // "set", "unchanged" or "skipped".
+	OldCAS uint64 `json:"oldCAS"`
+	NewCAS uint64 `json:"newCAS,omitempty"`
+}
+
+// CfgRestore writes the keys of the archive into the cfg, in the
+// archive's order, overwriting their current values.  The keys whose
+// current values are the same as the archived values are left alone.
+func CfgRestore(cfg Cfg, archive *CfgArchive,
+	options CfgRestoreOptions) ([]*CfgRestoreResult, error) {
+	if archive.Version <= 0 || archive.Version > CFG_ARCHIVE_VERSION {
+		return nil, fmt.Errorf("cfg_archive: unsupported version: %d",
+			archive.Version)
+	}
+
+	var wanted map[string]bool
+	if options.Keys != nil {
+		wanted = map[string]bool{}
+		for _, key := range options.Keys {
+			wanted[key] = true
+		}
+	}
+
+	rv := make([]*CfgRestoreResult, 0, len(archive.Entries))
+
+	for _, entry := range archive.Entries {
+		result := &CfgRestoreResult{Key: entry.Key, Action: "skipped"}
+		rv = append(rv, result)
+
+		if wanted != nil && !wanted[entry.Key] {
+			continue
+		}
+
+		val, cas, err := cfg.Get(entry.Key, 0)
+		if err != nil {
+			return rv, fmt.Errorf("cfg_archive: get, key: %s, err: %v",
+				entry.Key, err)
+		}
+
+		result.OldCAS = cas
+
+		if val != nil && bytes.Equal(val, entry.Val) {
+			result.Action = "unchanged"
+			continue
+		}
+
+		result.Action = "set"
+
+		if options.DryRun {
+			continue
+		}
+
+		result.NewCAS, err = cfg.Set(entry.Key, entry.Val, CFG_CAS_FORCE)
+		if err != nil {
+			return rv, fmt.Errorf("cfg_archive: set, key: %s, err: %v",
+				entry.Key, err)
+		}
+
+		log.Printf("cfg_archive: restored, key: %s, oldCAS: %d, newCAS: %d",
+			entry.Key, cas, result.NewCAS)
+	}
+
+	return rv, nil
+}
@@ -0,0 +1,94 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCfgExportRestore(t *testing.T) {
+	src := NewCfgMem()
+
+	indexDefs := NewIndexDefs(VERSION)
+	indexDefs.IndexDefs["idx"] = &IndexDef{Name: "idx", UUID: "u0"}
+	if _, err := CfgSetIndexDefs(src, indexDefs, CFG_CAS_FORCE); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	nodeDefs := NewNodeDefs(VERSION)
+	nodeDefs.NodeDefs["n0"] = &NodeDef{UUID: "n0", HostPort: "a:8094"}
+	_, err := CfgSetNodeDefs(src, NODE_DEFS_WANTED, nodeDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	archive, err := CfgExport(src, nil)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if !archive.Consistent || archive.Version != CFG_ARCHIVE_VERSION ||
+		len(archive.Entries) != 2 ||
+		archive.Entries[0].Key != INDEX_DEFS_KEY {
+		t.Fatalf("unexpected archive: %#v", archive)
+	}
+
+	var buf bytes.Buffer
+	if err = WriteCfgArchive(&buf, archive); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	archive, err = ReadCfgArchive(&buf)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	// Only the index definitions are cloned into another environment.
+	dst := NewCfgMem()
+	options := CfgRestoreOptions{Keys: []string{INDEX_DEFS_KEY}, DryRun: true}
+
+	results, err := CfgRestore(dst, archive, options)
+	if err != nil || len(results) != 2 ||
+		results[0].Action != "set" || results[1].Action != "skipped" {
+		t.Fatalf("unexpected results: %#v, err: %v", results, err)
+	}
+	if v, _, _ := dst.Get(INDEX_DEFS_KEY, 0); v != nil {
+		t.Fatalf("expected no changes on a dry run")
+	}
+
+	options.DryRun = false
+	if _, err = CfgRestore(dst, archive, options); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	got, _, err := CfgGetIndexDefs(dst)
+	if err != nil || got == nil || got.IndexDefs["idx"] == nil {
+		t.Fatalf("expected restored index defs, got: %#v, err: %v", got, err)
+	}
+	if v, _, _ := dst.Get(CfgNodeDefsKey(NODE_DEFS_WANTED), 0); v != nil {
+		t.Errorf("expected node defs not restored")
+	}
+
+	results, err = CfgRestore(dst, archive, options)
+	if err != nil || results[0].Action != "unchanged" {
+		t.Errorf("unexpected results: %#v, err: %v", results, err)
+	}
+}
+
+func TestReadCfgArchiveVersion(t *testing.T) {
+	_, err := ReadCfgArchive(strings.NewReader(`{"version":100,"entries":[]}`))
+	if err == nil {
+		t.Errorf("expected err on a newer version")
+	}
+
+	_, err = ReadCfgArchive(strings.NewReader(
+		`{"version":1,"entries":[{"key":"","val":{}}]}`))
+	if err == nil {
+		t.Errorf("expected err on an invalid entry")
+	}
+}
@@ -0,0 +1,264 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// CfgCacheKeys are the Cfg keys that a CfgCache caches by default,
+// which are read on the hot paths, such as by the planner, the janitor
+// and the defragmented utilization stats.
+var CfgCacheKeys = []string{
+	INDEX_DEFS_KEY,
+	CfgNodeDefsKey(NODE_DEFS_KNOWN),
+	CfgNodeDefsKey(NODE_DEFS_WANTED),
+	PLAN_PINDEXES_KEY,
+}
+
+// cfgCacheEventKeys maps the keys of the Cfg events that invalidate a
+// cached key to that key, where a CfgMetaKv notifies the changes of
+// its plan via the plan's directory stamp.
+var cfgCacheEventKeys = map[string]string{
+	PLAN_PINDEXES_DIRECTORY_STAMP: PLAN_PINDEXES_KEY,
+}
+
+// CfgCache is a Cfg wrapper that caches the values of some keys of the
+// underlying Cfg, so that their reads don't block on the Cfg's backend.
+// The cached values are invalidated by the underlying Cfg's events of
+// their keys, by the writes via the CfgCache, and by the CAS errors of
+// those writes, so that the read-modify-write loops re-read the keys.
+//
+// The events of the keys that are subscribed via the CfgCache are
+// delivered only after their cached values are invalidated, so that
+// the subscribers never re-read a stale value on an event.
+//
+// Only the raw values are cached, and not their parsed forms, as the
+// callers of the likes of CfgGetNodeDefs() may modify what's returned.
+type CfgCache struct {
+	Cfg
+
+	keys map[string]bool
+
+	m       sync.Mutex
+	entries map[string]*cfgCacheEntry       // Keyed by key.
+	gens    map[string]uint64               // Keyed by key.
+	subs    map[chan CfgEvent]chan CfgEvent // Keyed by subscriber channel.
+
+	stats CfgCacheStats
+}
+
+type cfgCacheEntry struct {
+	val []byte
+	cas uint64
+}
+
+// CfgCacheStats are the counters of a CfgCache.
+type CfgCacheStats struct {
+	TotHit        uint64 `json:"totHit"`
+	TotMiss       uint64 `json:"totMiss"`
+	TotInvalidate uint64 `json:"totInvalidate"`
+}
+
+// NewCfgCache returns a CfgCache of the keys of the cfg, or of the
+// CfgCacheKeys when keys is nil, which is also a VersionReader when
+// the cfg is.
+func NewCfgCache(cfg Cfg, keys []string) (Cfg, error) {
+	if keys == nil {
+		keys = CfgCacheKeys
+	}
+
+	c := &CfgCache{
+		Cfg:     cfg,
+		keys:    map[string]bool{},
+		entries: map[string]*cfgCacheEntry{},
+		gens:    map[string]uint64{},
+		subs:    map[chan CfgEvent]chan CfgEvent{},
+	}
+
+	for _, key := range keys {
+		c.keys[key] = true
+	}
+
+	// Invalidate on the changes by others, even when nobody else has
+	// subscribed to them.
+	ch := make(chan CfgEvent, 10)
+
+	subscribed := map[string]bool{}
+	for key := range c.keys {
+		subscribed[key] = true
+	}
+	for eventKey, key := range cfgCacheEventKeys {
+		if c.keys[key] {
+			subscribed[eventKey] = true
+		}
+	}
+	for key := range subscribed {
+		err := cfg.Subscribe(key, ch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	go func() {
+		for ev := range ch {
+			c.invalidate(ev.Key)
+		}
+	}()
+
+	if vr, ok := cfg.(VersionReader); ok {
+		return &cfgCacheVersionReader{CfgCache: c, VersionReader: vr}, nil
+	}
+
+	return c, nil
+}
+
+type cfgCacheVersionReader struct {
+	*CfgCache
+	VersionReader
+}
+
+// cachedKey returns the cached key whose value an event of the key
+// invalidates, or "".
+func (c *CfgCache) cachedKey(key string) string {
+	if c.keys[key] {
+		return key
+	}
+	if k := cfgCacheEventKeys[key]; c.keys[k] {
+		return k
+	}
+	return ""
+}
+
+func (c *CfgCache) invalidate(key string) {
+	key = c.cachedKey(key)
+	if key == "" {
+		return
+	}
+
+	c.m.Lock()
+	delete(c.entries, key)
+	c.gens[key]++
+	c.m.Unlock()
+
+	atomic.AddUint64(&c.stats.TotInvalidate, 1)
+}
+
+// Get returns the cached value of a cached key, unless a CAS match is
+// requested, which is always checked by the underlying Cfg.
+func (c *CfgCache) Get(key string, cas uint64) ([]byte, uint64, error) {
+	if cas != 0 || !c.keys[key] {
+		return c.Cfg.Get(key, cas)
+	}
+
+	c.m.Lock()
+	entry := c.entries[key]
+	gen := c.gens[key]
+	c.m.Unlock()
+
+	if entry != nil {
+		atomic.AddUint64(&c.stats.TotHit, 1)
+		return append([]byte(nil), entry.val...), entry.cas, nil
+	}
+
+	atomic.AddUint64(&c.stats.TotMiss, 1)
+
+	val, casSuccess, err := c.Cfg.Get(key, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Cache the value unless it was invalidated meanwhile, as then
+	// it may be stale.
+	c.m.Lock()
+	if c.gens[key] == gen {
+		c.entries[key] = &cfgCacheEntry{
+			val: append([]byte(nil), val...),
+			cas: casSuccess,
+		}
+	}
+	c.m.Unlock()
+
+	return val, casSuccess, nil
+}
+
+func (c *CfgCache) Set(key string, val []byte, cas uint64) (
+	uint64, error) {
+	casSuccess, err := c.Cfg.Set(key, val, cas)
+	c.invalidate(key)
+	return casSuccess, err
+}
+
+func (c *CfgCache) Del(key string, cas uint64) error {
+	err := c.Cfg.Del(key, cas)
+	c.invalidate(key)
+	return err
+}
+
+// Subscribe subscribes to the key of the underlying Cfg, via a channel
+// per subscriber channel that invalidates the cached value of the key
+// before forwarding its events.
+func (c *CfgCache) Subscribe(key string, ch chan CfgEvent) error {
+	c.m.Lock()
+	inner, exists := c.subs[ch]
+	if !exists {
+		inner = make(chan CfgEvent, cap(ch))
+		c.subs[ch] = inner
+
+		go func() {
+			for ev := range inner {
+				c.invalidate(ev.Key)
+				ch <- ev
+			}
+		}()
+	}
+	c.m.Unlock()
+
+	return c.Cfg.Subscribe(key, inner)
+}
+
+// Refresh drops the cached values before refreshing the underlying
+// Cfg.
+func (c *CfgCache) Refresh() error {
+	c.m.Lock()
+	for key := range c.keys {
+		delete(c.entries, key)
+		c.gens[key]++
+	}
+	c.m.Unlock()
+
+	return c.Cfg.Refresh()
+}
+
+// Stats returns a copy of the counters of the CfgCache.
+func (c *CfgCache) Stats() CfgCacheStats {
+	return CfgCacheStats{
+		TotHit:        atomic.LoadUint64(&c.stats.TotHit),
+		TotMiss:       atomic.LoadUint64(&c.stats.TotMiss),
+		TotInvalidate: atomic.LoadUint64(&c.stats.TotInvalidate),
+	}
+}
+
+// WithKeyPrefix implements the CfgKeyPrefixer interface, so that the
+// cache stays above a tenant's prefix, where the tenant's keys are
+// cached.
+func (c *CfgCache) WithKeyPrefix(keyPrefix string) (Cfg, error) {
+	cfg, err := NewCfgPrefixed(c.Cfg, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(c.keys))
+	for key := range c.keys {
+		keys = append(keys, key)
+	}
+
+	return NewCfgCache(cfg, keys)
+}
@@ -0,0 +1,95 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"testing"
+	"time"
+)
+
+// A cfgGetCounter counts the Get()'s of the underlying Cfg.
+type cfgGetCounter struct {
+	Cfg
+	gets int
+}
+
+func (c *cfgGetCounter) Get(key string, cas uint64) ([]byte, uint64, error) {
+	c.gets++
+	return c.Cfg.Get(key, cas)
+}
+
+func TestCfgCache(t *testing.T) {
+	cfgMem := NewCfgMem()
+	counter := &cfgGetCounter{Cfg: cfgMem}
+
+	cfg, err := NewCfgCache(counter, nil)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	c := cfg.(*CfgCache)
+
+	testCfg(t, cfg)
+
+	nodeDefs := NewNodeDefs(VERSION)
+	nodeDefs.NodeDefs["n0"] = &NodeDef{UUID: "n0"}
+	cas, err := CfgSetNodeDefs(cfg, NODE_DEFS_KNOWN, nodeDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	counter.gets = 0
+	for i := 0; i < 10; i++ {
+		got, gotCAS, err := CfgGetNodeDefs(cfg, NODE_DEFS_KNOWN)
+		if err != nil || gotCAS != cas || got.NodeDefs["n0"] == nil {
+			t.Fatalf("unexpected node defs: %#v, err: %v", got, err)
+		}
+		got.NodeDefs["n1"] = &NodeDef{UUID: "n1"} // Doesn't leak into the cache.
+	}
+	if counter.gets != 1 {
+		t.Errorf("expected 1 backend get, got: %d", counter.gets)
+	}
+	if stats := c.Stats(); stats.TotHit != 9 || stats.TotMiss < 1 {
+		t.Errorf("unexpected stats: %#v", stats)
+	}
+
+	// A stale CAS write invalidates, so a retry re-reads.
+	_, err = CfgSetNodeDefs(cfg, NODE_DEFS_KNOWN, nodeDefs, cas+100)
+	if err == nil {
+		t.Fatalf("expected a CAS err")
+	}
+	counter.gets = 0
+	CfgGetNodeDefs(cfg, NODE_DEFS_KNOWN)
+	if counter.gets != 1 {
+		t.Errorf("expected a re-read after a CAS err, got: %d", counter.gets)
+	}
+
+	// A subscriber re-reads the new value on the event of a change by
+	// others, made directly to the underlying Cfg.
+	ec := make(chan CfgEvent, 1)
+	if err = cfg.Subscribe(CfgNodeDefsKey(NODE_DEFS_KNOWN), ec); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	nodeDefs.NodeDefs["n2"] = &NodeDef{UUID: "n2"}
+	_, err = CfgSetNodeDefs(cfgMem, NODE_DEFS_KNOWN, nodeDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	select {
+	case <-ec:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected an event")
+	}
+
+	got, _, err := CfgGetNodeDefs(cfg, NODE_DEFS_KNOWN)
+	if err != nil || got.NodeDefs["n2"] == nil {
+		t.Errorf("expected the new node defs, got: %#v, err: %v", got, err)
+	}
+}
@@ -0,0 +1,397 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+
+	log "github.com/couchbase/clog"
+)
+
+// The values of big clusters, such as their PlanPIndexes, can exceed
+// the value size limits of the Cfg providers.  CfgCompressed is a Cfg
+// wrapper that transparently compresses the large values, and splits
+// the compressed values that are still too large into chunks, which
+// are stored under sibling keys of the value's key.
+//
+// A compressed value starts with a marker and a format version, where
+// the marker isn't valid JSON, so that older nodes fail loudly on
+// parsing the value rather than silently misparsing it, and newer
+// format versions fail loudly on this node.  The marker is followed by
+// a JSON header line and, unless the value is chunked, by the
+// compressed bytes.
+//
+// The chunks of a value are written before its key, under a generation
+// that's unique to the write, so that a reader never mixes the chunks
+// of different writes, and the chunks of the replaced value are
+// deleted after its key is replaced.
+
+// CFG_COMPRESSED_VERSION is the newest format version of the
+// compressed Cfg values that this node understands.
+const CFG_COMPRESSED_VERSION = 1
+
+// CfgCompressThresholdDefault is the default size of the values at and
+// above which they're compressed.
+var CfgCompressThresholdDefault = 64 * 1024
+
+// CfgChunkSizeDefault is the default max size of the compressed values,
+// above which they're split into chunks of this size.
+var CfgChunkSizeDefault = 256 * 1024
+
+// CfgCompressedMaxReadAttempts is the number of times that a chunked
+// value is re-read when its chunks were concurrently replaced.
+var CfgCompressedMaxReadAttempts = 3
+
+var cfgCompressedMarker = []byte("\x00cbgt-cfgz:")
+
+// The header of a compressed value.
+type cfgCompressedHeader struct {
+	Codec  string `json:"codec"`
+	Len    int    `json:"len"` // Of the uncompressed value.
+	CRC    uint32 `json:"crc"` // Of the compressed bytes.
+	Gen    string `json:"gen,omitempty"`
+	Chunks int    `json:"chunks,omitempty"`
+}
+
+// CfgCompressed is a Cfg wrapper that compresses and chunks the large
+// values of the underlying Cfg.
+type CfgCompressed struct {
+	Cfg
+
+	threshold int
+	chunkSize int
+}
+
+// NewCfgCompressed returns a Cfg that compresses and chunks the large
+// values of the cfg, which is also a VersionReader when the cfg is.
+//
+// Allowed options include:
+//   - 'compressThreshold': the size of the values at and above which
+//     they're compressed.
+//   - 'chunkSize': the max size of the compressed values, above which
+//     they're split into chunks.
+func NewCfgCompressed(cfg Cfg, options map[string]string) (Cfg, error) {
+	c := &CfgCompressed{
+		Cfg:       cfg,
+		threshold: CfgCompressThresholdDefault,
+		chunkSize: CfgChunkSizeDefault,
+	}
+
+	for option, v := range map[string]*int{
+		"compressThreshold": &c.threshold,
+		"chunkSize":         &c.chunkSize,
+	} {
+		if s, exists := options[option]; exists {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("cfg_compress: invalid %s: %s",
+					option, s)
+			}
+			*v = n
+		}
+	}
+
+	if vr, ok := cfg.(VersionReader); ok {
+		return &cfgCompressedVersionReader{CfgCompressed: c, VersionReader: vr}, nil
+	}
+
+	return c, nil
+}
+
+type cfgCompressedVersionReader struct {
+	*CfgCompressed
+	VersionReader
+}
+
+// passThrough returns true for the keys whose values the underlying
+// Cfg encodes on its own, such as the keys that CfgMetaKv compresses
+// and splits.
+func (c *CfgCompressed) passThrough(key string) bool {
+	if _, ok := c.Cfg.(*CfgMetaKv); ok {
+		if _, exists := cfgMetaKvAdvancedKeys[key]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+func cfgChunkKey(key, gen string, i int) string {
+	return fmt.Sprintf("%s.chunk.%s.%d", key, gen, i)
+}
+
+func (c *CfgCompressed) Get(key string, cas uint64) (
+	[]byte, uint64, error) {
+	if c.passThrough(key) {
+		return c.Cfg.Get(key, cas)
+	}
+
+	var err error
+	for i := 0; i < CfgCompressedMaxReadAttempts; i++ {
+		val, casSuccess, err2 := c.Cfg.Get(key, cas)
+		if err2 != nil || !bytes.HasPrefix(val, cfgCompressedMarker) {
+			return val, casSuccess, err2
+		}
+
+		var header *cfgCompressedHeader
+		var payload []byte
+		header, payload, err = parseCfgCompressed(key, val)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if header.Chunks > 0 {
+			payload, err = c.getChunks(key, header)
+			if err == nil {
+				val, err = decodeCfgCompressed(key, header, payload)
+			}
+			if err != nil {
+				continue // The chunks may have been replaced, so retry.
+			}
+			return val, casSuccess, nil
+		}
+
+		val, err = decodeCfgCompressed(key, header, payload)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return val, casSuccess, nil
+	}
+
+	return nil, 0, err
+}
+
+func (c *CfgCompressed) getChunks(key string,
+	header *cfgCompressedHeader) ([]byte, error) {
+	var payload []byte
+	for i := 0; i < header.Chunks; i++ {
+		chunk, _, err := c.Cfg.Get(cfgChunkKey(key, header.Gen, i), 0)
+		if err != nil {
+			return nil, err
+		}
+		if chunk == nil {
+			return nil, fmt.Errorf("cfg_compress: key: %s,"+
+				" missing chunk: %d, gen: %s", key, i, header.Gen)
+		}
+		payload = append(payload, chunk...)
+	}
+	return payload, nil
+}
+
+func (c *CfgCompressed) Set(key string, val []byte, cas uint64) (
+	uint64, error) {
+	if c.passThrough(key) ||
+		(len(val) < c.threshold && !bytes.HasPrefix(val, cfgCompressedMarker)) {
+		prevHeader := c.getHeader(key)
+
+		casSuccess, err := c.Cfg.Set(key, val, cas)
+		if err == nil {
+			c.delChunks(key, prevHeader)
+		}
+		return casSuccess, err
+	}
+
+	compressed, err := gzipCompress(val)
+	if err != nil {
+		return 0, err
+	}
+
+	header := &cfgCompressedHeader{
+		Codec: "gzip",
+		Len:   len(val),
+		CRC:   crc32.ChecksumIEEE(compressed),
+	}
+
+	if len(compressed) > c.chunkSize {
+		header.Gen = NewUUID()
+
+		for i := 0; i*c.chunkSize < len(compressed); i++ {
+			end := (i + 1) * c.chunkSize
+			if end > len(compressed) {
+				end = len(compressed)
+			}
+
+			_, err = c.Cfg.Set(cfgChunkKey(key, header.Gen, i),
+				compressed[i*c.chunkSize:end], CFG_CAS_FORCE)
+			if err != nil {
+				c.delChunks(key, header)
+				return 0, err
+			}
+
+			header.Chunks = i + 1
+		}
+
+		compressed = nil
+	}
+
+	hbuf, err := json.Marshal(header)
+	if err != nil {
+		c.delChunks(key, header)
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(cfgCompressedMarker) + len(hbuf) + len(compressed) + 4)
+	buf.Write(cfgCompressedMarker)
+	buf.WriteString(strconv.Itoa(CFG_COMPRESSED_VERSION))
+	buf.WriteByte('\n')
+	buf.Write(hbuf)
+	buf.WriteByte('\n')
+	buf.Write(compressed)
+
+	prevHeader := c.getHeader(key)
+
+	casSuccess, err := c.Cfg.Set(key, buf.Bytes(), cas)
+	if err != nil {
+		c.delChunks(key, header)
+		return 0, err
+	}
+
+	c.delChunks(key, prevHeader)
+
+	return casSuccess, nil
+}
+
+func (c *CfgCompressed) Del(key string, cas uint64) error {
+	if c.passThrough(key) {
+		return c.Cfg.Del(key, cas)
+	}
+
+	prevHeader := c.getHeader(key)
+
+	err := c.Cfg.Del(key, cas)
+	if err == nil {
+		c.delChunks(key, prevHeader)
+	}
+
+	return err
+}
+
+// getHeader returns the header of the key's current value when it's a
+// chunked value, or nil.
+func (c *CfgCompressed) getHeader(key string) *cfgCompressedHeader {
+	val, _, err := c.Cfg.Get(key, 0)
+	if err != nil || !bytes.HasPrefix(val, cfgCompressedMarker) {
+		return nil
+	}
+
+	header, _, err := parseCfgCompressed(key, val)
+	if err != nil || header.Chunks <= 0 {
+		return nil
+	}
+
+	return header
+}
+
+// delChunks deletes the chunks of a replaced value, where the chunks
+// that are left behind on errors are only wasted space.
+func (c *CfgCompressed) delChunks(key string, header *cfgCompressedHeader) {
+	if header == nil {
+		return
+	}
+
+	for i := 0; i < header.Chunks; i++ {
+		err := c.Cfg.Del(cfgChunkKey(key, header.Gen, i), 0)
+		if err != nil {
+			log.Warnf("cfg_compress: del chunk, key: %s, gen: %s, i: %d,"+
+				" err: %v", key, header.Gen, i, err)
+		}
+	}
+}
+
+// parseCfgCompressed returns the header and the inline compressed
+// bytes of a compressed value.
+func parseCfgCompressed(key string, val []byte) (
+	*cfgCompressedHeader, []byte, error) {
+	rest := val[len(cfgCompressedMarker):]
+
+	nl := bytes.IndexByte(rest, '\n')
+	if nl < 0 {
+		return nil, nil, fmt.Errorf("cfg_compress: key: %s,"+
+			" malformed value", key)
+	}
+
+	version, err := strconv.Atoi(string(rest[:nl]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cfg_compress: key: %s,"+
+			" malformed version, err: %v", key, err)
+	}
+	if version > CFG_COMPRESSED_VERSION {
+		return nil, nil, fmt.Errorf("cfg_compress: key: %s,"+
+			" unsupported value version: %d, this node supports up to: %d",
+			key, version, CFG_COMPRESSED_VERSION)
+	}
+	rest = rest[nl+1:]
+
+	nl = bytes.IndexByte(rest, '\n')
+	if nl < 0 {
+		return nil, nil, fmt.Errorf("cfg_compress: key: %s,"+
+			" malformed header", key)
+	}
+
+	var header cfgCompressedHeader
+	err = json.Unmarshal(rest[:nl], &header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cfg_compress: key: %s,"+
+			" header, err: %v", key, err)
+	}
+
+	return &header, rest[nl+1:], nil
+}
+
+func decodeCfgCompressed(key string, header *cfgCompressedHeader,
+	payload []byte) ([]byte, error) {
+	if crc32.ChecksumIEEE(payload) != header.CRC {
+		return nil, fmt.Errorf("cfg_compress: key: %s, crc mismatch", key)
+	}
+
+	if header.Codec != "gzip" {
+		return nil, fmt.Errorf("cfg_compress: key: %s,"+
+			" unsupported codec: %s", key, header.Codec)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("cfg_compress: key: %s, err: %v", key, err)
+	}
+	defer r.Close()
+
+	val := make([]byte, 0, header.Len)
+	buf := bytes.NewBuffer(val)
+	_, err = io.Copy(buf, r)
+	if err != nil {
+		return nil, fmt.Errorf("cfg_compress: key: %s, err: %v", key, err)
+	}
+
+	if buf.Len() != header.Len {
+		return nil, fmt.Errorf("cfg_compress: key: %s, len: %d,"+
+			" expected: %d", key, buf.Len(), header.Len)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func gzipCompress(val []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(val)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,122 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestCfgCompressed(t *testing.T) {
+	cfgMem := NewCfgMem()
+	cfg, err := NewCfgCompressed(cfgMem, map[string]string{
+		"compressThreshold": "100",
+		"chunkSize":         "1000",
+	})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	small := []byte(`{"small":true}`)
+	if _, err = cfg.Set("a", small, 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if raw, _, _ := cfgMem.Get("a", 0); !bytes.Equal(raw, small) {
+		t.Errorf("expected small value stored as is, got: %q", raw)
+	}
+
+	// Compressible, so inlined.
+	large := []byte(`{"large":"` + strings.Repeat("x", 10000) + `"}`)
+	cas, err := cfg.Set("a", large, 0)
+	if err == nil {
+		t.Fatalf("expected err on a zero cas create of an existing key")
+	}
+	_, cas, _ = cfgMem.Get("a", 0)
+	if cas, err = cfg.Set("a", large, cas); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	raw, _, _ := cfgMem.Get("a", 0)
+	if !bytes.HasPrefix(raw, cfgCompressedMarker) || len(raw) >= len(large) {
+		t.Errorf("expected a compressed value, got len: %d", len(raw))
+	}
+	if err = UnmarshalJSON(raw, &struct{}{}); err == nil {
+		t.Errorf("expected old readers to fail on a compressed value")
+	}
+
+	val, casGot, err := cfg.Get("a", 0)
+	if err != nil || !bytes.Equal(val, large) || casGot != cas {
+		t.Fatalf("expected large value, got len: %d, err: %v", len(val), err)
+	}
+
+	// Incompressible, so chunked.
+	rnd := make([]byte, 5000)
+	rand.Read(rnd)
+	huge := []byte(`{"huge":"` + hex.EncodeToString(rnd) + `"}`)
+	if _, err = cfg.Set("a", huge, CFG_CAS_FORCE); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if val, _, err = cfg.Get("a", 0); err != nil || !bytes.Equal(val, huge) {
+		t.Fatalf("expected huge value, got len: %d, err: %v", len(val), err)
+	}
+
+	chunkKeys := func() []string {
+		var rv []string
+		for key := range cfgMem.Entries {
+			if strings.HasPrefix(key, "a.chunk.") {
+				rv = append(rv, key)
+			}
+		}
+		return rv
+	}
+	if n := len(chunkKeys()); n < 5 {
+		t.Errorf("expected chunks, got: %d", n)
+	}
+
+	// Rewriting the value replaces the chunks.
+	if _, err = cfg.Set("a", huge, CFG_CAS_FORCE); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	n := len(chunkKeys())
+	if _, err = cfg.Set("a", small, CFG_CAS_FORCE); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if n == 0 || len(chunkKeys()) != 0 {
+		t.Errorf("expected chunks deleted, had: %d, got: %v", n, chunkKeys())
+	}
+
+	if _, err = cfg.Set("a", huge, CFG_CAS_FORCE); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = cfg.Del("a", 0); err != nil || len(chunkKeys()) != 0 {
+		t.Errorf("expected chunks deleted, err: %v, got: %v", err, chunkKeys())
+	}
+}
+
+func TestCfgCompressedVersion(t *testing.T) {
+	cfgMem := NewCfgMem()
+	cfg, _ := NewCfgCompressed(cfgMem, nil)
+
+	newer := append(append([]byte(nil), cfgCompressedMarker...),
+		[]byte("2\n{\"codec\":\"zstd\"}\n")...)
+	cfgMem.Set("a", newer, CFG_CAS_FORCE)
+
+	_, _, err := cfg.Get("a", 0)
+	if err == nil || !strings.Contains(err.Error(), "unsupported value version") {
+		t.Errorf("expected err on a newer version, got: %v", err)
+	}
+
+	if _, err = NewCfgCompressed(cfgMem,
+		map[string]string{"chunkSize": "0"}); err == nil {
+		t.Errorf("expected err on an invalid chunkSize")
+	}
+}
@@ -0,0 +1,476 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// CFG_CONSUL is the name of the Consul Cfg provider.
+const CFG_CONSUL = "consul"
+
+// CfgConsulWaitDefault is the default max duration of a blocking query
+// of the Consul KV, after which the watcher re-issues the query.
+var CfgConsulWaitDefault = 5 * time.Minute
+
+// CfgConsulRetrySleep is how long the watcher sleeps after a failed
+// query before retrying.
+var CfgConsulRetrySleep = time.Second
+
+// CfgConsul is an implementation of Cfg on a HashiCorp Consul KV
+// store, via Consul's HTTP API, so that cbgt nodes can be clustered in
+// Consul-centric environments.
+//
+// Each Cfg entry is a Consul key under a key prefix, where the entry's
+// CAS is the key's ModifyIndex.  The writes are Consul transactions of
+// check-and-set operations, which give the Cfg's CAS semantics and the
+// ModifyIndex of the write in one round trip.  The subscriptions are
+// served by a watcher that runs blocking queries on the key prefix,
+// which fires the events of the keys whose ModifyIndex changed.
+//
+// CfgConsul doesn't use Consul sessions, as it never holds a lock.  A
+// node's read-modify-write of an entry is an optimistic Get and a
+// check-and-set of the ModifyIndex that it read, where each
+// transaction is applied atomically or not at all, so a node that
+// crashes midway leaves nothing behind that needs a session's
+// invalidation to be released; the next writer's check-and-set simply
+// proceeds.  Like with the other Cfg implementations, the liveness of
+// the nodes is tracked by the cluster's node definitions rather than
+// by the Cfg's backend.
+type CfgConsul struct {
+	url        string // The Consul agent, like "http://127.0.0.1:8500".
+	prefix     string // Prepended to the Cfg keys, like "cbgt/".
+	token      string // Optional ACL token.
+	wait       time.Duration
+	httpClient *http.Client
+
+	m             sync.Mutex
+	subscriptions map[string][]chan<- CfgEvent // Keyed by key.
+	casM          map[string]uint64            // Keyed by key, as last watched.
+	watching      bool
+	seeded        bool // True once the watcher's casM is initialized.
+	closeCh       chan struct{}
+}
+
+// NewCfgConsul returns a Cfg implementation on the Consul KV of the
+// Consul agent at the urlStr.
+//
+// Allowed options include:
+//   - 'keyPrefix': an optional prefix of the Consul keys, which
+//     separates the Cfg of a cluster from the other users of the KV.
+//   - 'token': an optional Consul ACL token.
+//   - 'waitSecs': the max duration of the blocking queries.
+func NewCfgConsul(urlStr string, options map[string]string) (
+	*CfgConsul, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("cfg_consul: invalid url: %s", urlStr)
+	}
+
+	c := &CfgConsul{
+		url:           u.Scheme + "://" + u.Host,
+		prefix:        options["keyPrefix"],
+		token:         options["token"],
+		wait:          CfgConsulWaitDefault,
+		httpClient:    &http.Client{},
+		subscriptions: map[string][]chan<- CfgEvent{},
+		casM:          map[string]uint64{},
+		closeCh:       make(chan struct{}),
+	}
+
+	if v, exists := options["waitSecs"]; exists {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			return nil, fmt.Errorf("cfg_consul: invalid waitSecs: %s", v)
+		}
+		c.wait = time.Duration(secs) * time.Second
+	}
+
+	return c, nil
+}
+
+// A consulKV is an entry of the Consul KV, as returned by its API.
+type consulKV struct {
+	Key         string
+	Value       []byte // Base64 encoded in JSON.
+	ModifyIndex uint64
+}
+
+type consulTxnOp struct {
+	KV *consulTxnKVOp `json:"KV"`
+}
+
+type consulTxnKVOp struct {
+	Verb  string
+	Key   string
+	Value []byte `json:",omitempty"`
+	Index uint64 `json:",omitempty"`
+}
+
+type consulTxnResult struct {
+	Results []struct {
+		KV *consulKV `json:"KV"`
+	}
+	Errors []struct {
+		OpIndex int
+		What    string
+	}
+}
+
+// WithKeyPrefix implements the CfgKeyPrefixer interface, by returning
+// a CfgConsul of the tenant's sub-prefix of the Consul keys.
+func (c *CfgConsul) WithKeyPrefix(keyPrefix string) (Cfg, error) {
+	err := ValidateCfgKeyPrefix(keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CfgConsul{
+		url:           c.url,
+		prefix:        c.prefix + keyPrefix + "/",
+		token:         c.token,
+		wait:          c.wait,
+		httpClient:    c.httpClient,
+		subscriptions: map[string][]chan<- CfgEvent{},
+		casM:          map[string]uint64{},
+		closeCh:       make(chan struct{}),
+	}, nil
+}
+
+// Load checks that the Consul agent is reachable.
+func (c *CfgConsul) Load() error {
+	_, _, err := c.list(context.Background(), 0, 0)
+	return err
+}
+
+// Close stops the watcher of the subscriptions.
+func (c *CfgConsul) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	select {
+	case <-c.closeCh:
+	default:
+		close(c.closeCh)
+	}
+
+	return nil
+}
+
+func (c *CfgConsul) Get(key string, cas uint64) (
+	[]byte, uint64, error) {
+	resp, err := c.request(context.Background(), "GET",
+		"/v1/kv/"+c.escapedKey(key), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, 0, nil
+	}
+
+	var kvs []*consulKV
+	err = c.decode(resp, &kvs)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(kvs) == 0 {
+		return nil, 0, nil
+	}
+
+	if cas != 0 && cas != kvs[0].ModifyIndex {
+		return nil, 0, &CfgCASError{}
+	}
+
+	return kvs[0].Value, kvs[0].ModifyIndex, nil
+}
+
+func (c *CfgConsul) Set(key string, val []byte, cas uint64) (
+	uint64, error) {
+	op := &consulTxnKVOp{Key: c.prefix + key, Value: val}
+	if val == nil {
+		op.Value = []byte{}
+	}
+
+	if cas == CFG_CAS_FORCE {
+		op.Verb = "set"
+	} else {
+		// A zero Index is a creation, which fails if the key exists.
+		op.Verb = "cas"
+		op.Index = cas
+	}
+
+	result, err := c.txn(op)
+	if err != nil {
+		if _, ok := err.(*CfgCASError); ok && cas == 0 {
+			return 0, fmt.Errorf("cfg_consul: entry already exists,"+
+				" key: %s", key)
+		}
+		return 0, err
+	}
+
+	if len(result.Results) == 0 || result.Results[0].KV == nil {
+		return 0, fmt.Errorf("cfg_consul: Set, no result, key: %s", key)
+	}
+
+	return result.Results[0].KV.ModifyIndex, nil
+}
+
+func (c *CfgConsul) Del(key string, cas uint64) error {
+	op := &consulTxnKVOp{Key: c.prefix + key, Verb: "delete"}
+	if cas != 0 {
+		op.Verb = "delete-cas"
+		op.Index = cas
+	}
+
+	_, err := c.txn(op)
+
+	return err
+}
+
+// txn runs a Consul transaction of a single KV operation, where a
+// failed check-and-set results in a CfgCASError.
+func (c *CfgConsul) txn(op *consulTxnKVOp) (*consulTxnResult, error) {
+	body, err := json.Marshal([]*consulTxnOp{{KV: op}})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.request(context.Background(), "PUT", "/v1/txn", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		// The transaction was rolled back, as its check failed.
+		return nil, &CfgCASError{}
+	}
+
+	var result consulTxnResult
+	err = c.decode(resp, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Errors) > 0 {
+		return nil, fmt.Errorf("cfg_consul: txn, key: %s, err: %s",
+			op.Key, result.Errors[0].What)
+	}
+
+	return &result, nil
+}
+
+func (c *CfgConsul) Subscribe(key string, ch chan CfgEvent) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.subscriptions[key] = append(c.subscriptions[key], ch)
+
+	if !c.watching {
+		c.watching = true
+		go c.watch()
+	}
+
+	return nil
+}
+
+// Refresh fires the events of every subscribed key, with its current
+// CAS.
+func (c *CfgConsul) Refresh() error {
+	kvs, _, err := c.list(context.Background(), 0, 0)
+	if err != nil {
+		return err
+	}
+
+	casM := c.casOfKVs(kvs)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for key := range c.subscriptions {
+		c.fireEvent(key, casM[key], nil)
+	}
+
+	return nil
+}
+
+// watch runs blocking queries on the key prefix, and fires the events
+// of the subscribed keys whose ModifyIndex changed, until the Cfg is
+// closed.
+func (c *CfgConsul) watch() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-c.closeCh
+		cancel()
+	}()
+
+	var index uint64
+
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		default:
+		}
+
+		kvs, nextIndex, err := c.list(ctx, index, c.wait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.Warnf("cfg_consul: watch, index: %d, err: %v", index, err)
+
+			select {
+			case <-c.closeCh:
+				return
+			case <-time.After(CfgConsulRetrySleep):
+			}
+
+			continue
+		}
+
+		casM := c.casOfKVs(kvs)
+
+		c.m.Lock()
+		if c.seeded {
+			for key := range c.subscriptions {
+				if casM[key] != c.casM[key] {
+					c.fireEvent(key, casM[key], nil)
+				}
+			}
+		}
+		c.casM = casM
+		c.seeded = true
+		c.m.Unlock()
+
+		// Per Consul's guidance, an index that went backwards, such as
+		// after a restore of the Consul servers, resets the query, and
+		// an index is never less than 1, so the queries always block.
+		if nextIndex < index {
+			nextIndex = 0
+		} else if nextIndex < 1 {
+			nextIndex = 1
+		}
+		index = nextIndex
+	}
+}
+
+// list returns the entries under the key prefix, and the Consul index
+// for the next blocking query, where a non-zero index blocks until the
+// entries change past that index or the wait elapses.
+func (c *CfgConsul) list(ctx context.Context, index uint64,
+	wait time.Duration) ([]*consulKV, uint64, error) {
+	path := "/v1/kv/" + c.escapedKey("") + "?recurse=true"
+	if index > 0 {
+		path += "&index=" + strconv.FormatUint(index, 10) +
+			"&wait=" + strconv.Itoa(int(wait/time.Second)) + "s"
+
+		// Consul adds up to wait/16 of jitter to a blocking query.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wait+wait/16+10*time.Second)
+		defer cancel()
+	}
+
+	resp, err := c.request(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	nextIndex, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nextIndex, nil
+	}
+
+	var kvs []*consulKV
+	err = c.decode(resp, &kvs)
+
+	return kvs, nextIndex, err
+}
+
+func (c *CfgConsul) casOfKVs(kvs []*consulKV) map[string]uint64 {
+	rv := make(map[string]uint64, len(kvs))
+	for _, kv := range kvs {
+		if strings.HasPrefix(kv.Key, c.prefix) {
+			rv[kv.Key[len(c.prefix):]] = kv.ModifyIndex
+		}
+	}
+	return rv
+}
+
+func (c *CfgConsul) fireEvent(key string, cas uint64, err error) {
+	for _, ch := range c.subscriptions[key] {
+		go func(ch chan<- CfgEvent) {
+			ch <- CfgEvent{
+				Key: key, CAS: cas, Error: err,
+			}
+		}(ch)
+	}
+}
+
+func (c *CfgConsul) escapedKey(key string) string {
+	parts := strings.Split(c.prefix+key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (c *CfgConsul) request(ctx context.Context, method, path string,
+	body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+func (c *CfgConsul) decode(resp *http.Response, v interface{}) error {
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cfg_consul: %s %s, status: %d, body: %s",
+			resp.Request.Method, resp.Request.URL.Path,
+			resp.StatusCode, buf)
+	}
+
+	return json.Unmarshal(buf, v)
+}
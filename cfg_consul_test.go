@@ -0,0 +1,254 @@
+//  Copyright 2014-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul serves a subset of the Consul KV and txn APIs.
+type fakeConsul struct {
+	m         sync.Mutex
+	index     uint64
+	kvs       map[string]*consulKV
+	changedCh chan struct{} // Closed and replaced on each change.
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		kvs:       map[string]*consulKV{},
+		changedCh: make(chan struct{}),
+	}
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/v1/kv/"):
+		f.get(w, req, strings.TrimPrefix(req.URL.Path, "/v1/kv/"))
+	case req.Method == "PUT" && req.URL.Path == "/v1/txn":
+		f.txn(w, req)
+	default:
+		http.Error(w, "unsupported", http.StatusBadRequest)
+	}
+}
+
+func (f *fakeConsul) get(w http.ResponseWriter, req *http.Request,
+	key string) {
+	recurse := req.URL.Query().Get("recurse") == "true"
+	index, _ := strconv.ParseUint(req.URL.Query().Get("index"), 10, 64)
+
+	f.m.Lock()
+	if index > 0 && f.index <= index {
+		changedCh := f.changedCh
+		f.m.Unlock()
+		select {
+		case <-changedCh:
+		case <-req.Context().Done():
+			return
+		case <-time.After(time.Second):
+		}
+		f.m.Lock()
+	}
+
+	var rv []*consulKV
+	for k, kv := range f.kvs {
+		if k == key || (recurse && strings.HasPrefix(k, key)) {
+			rv = append(rv, kv)
+		}
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Key < rv[j].Key })
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	f.m.Unlock()
+
+	if len(rv) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(rv)
+}
+
+func (f *fakeConsul) txn(w http.ResponseWriter, req *http.Request) {
+	var ops []*consulTxnOp
+	err := json.NewDecoder(req.Body).Decode(&ops)
+	if err != nil || len(ops) != 1 {
+		http.Error(w, "bad txn", http.StatusBadRequest)
+		return
+	}
+	op := ops[0].KV
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	prev := f.kvs[op.Key]
+	prevIndex := uint64(0)
+	if prev != nil {
+		prevIndex = prev.ModifyIndex
+	}
+
+	if (op.Verb == "cas" && op.Index != prevIndex) ||
+		(op.Verb == "delete-cas" && (prev == nil || op.Index != prevIndex)) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Errors": []map[string]interface{}{
+				{"OpIndex": 0, "What": "failed to " + op.Verb},
+			},
+		})
+		return
+	}
+
+	f.index++
+	close(f.changedCh)
+	f.changedCh = make(chan struct{})
+
+	result := consulTxnResult{}
+	if op.Verb == "delete" || op.Verb == "delete-cas" {
+		delete(f.kvs, op.Key)
+	} else {
+		kv := &consulKV{Key: op.Key, Value: op.Value, ModifyIndex: f.index}
+		f.kvs[op.Key] = kv
+		result.Results = append(result.Results, struct {
+			KV *consulKV `json:"KV"`
+		}{KV: &consulKV{Key: kv.Key, ModifyIndex: kv.ModifyIndex}})
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+func TestCfgConsul(t *testing.T) {
+	s := httptest.NewServer(newFakeConsul())
+	defer s.Close()
+
+	_, err := NewCfgConsul("not-a-url", nil)
+	if err == nil {
+		t.Errorf("expected err on a bad url")
+	}
+
+	c, err := NewCfgConsul(s.URL, map[string]string{"keyPrefix": "cbgt/"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err = c.Load(); err != nil {
+		t.Fatalf("expected Load() to work, err: %v", err)
+	}
+
+	testCfg(t, c)
+
+	// CFG_CAS_FORCE overwrites, whatever the CAS.
+	cas, err := c.Set("b", []byte("B"), CFG_CAS_FORCE)
+	if err != nil || cas == 0 {
+		t.Errorf("expected a forced Set() to work, err: %v", err)
+	}
+	cas, err = c.Set("b", []byte("BB"), CFG_CAS_FORCE)
+	if err != nil || cas == 0 {
+		t.Errorf("expected a forced Set() to work, err: %v", err)
+	}
+	v, _, err := c.Get("b", cas)
+	if err != nil || string(v) != "BB" {
+		t.Errorf("expected Get() of the forced Set(), got: %s, err: %v",
+			v, err)
+	}
+}
+
+func TestCfgConsulSubscribe(t *testing.T) {
+	s := httptest.NewServer(newFakeConsul())
+	defer s.Close()
+
+	c, err := NewCfgConsul(s.URL, map[string]string{
+		"keyPrefix": "cbgt/",
+		"waitSecs":  "1",
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+
+	// Another cluster's keys in the same KV are ignored.
+	other, _ := NewCfgConsul(s.URL, map[string]string{"keyPrefix": "other/"})
+
+	ec := make(chan CfgEvent, 10)
+	ec2 := make(chan CfgEvent, 10)
+	c.Subscribe("a", ec)
+	c.Subscribe("aaa", ec2)
+
+	expectEvent := func(ch chan CfgEvent, key string, cas uint64) {
+		select {
+		case e := <-ch:
+			if e.Key != key || e.CAS != cas {
+				t.Fatalf("expected event, key: %s, cas: %d, got: %#v",
+					key, cas, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected event, key: %s, cas: %d", key, cas)
+		}
+	}
+
+	// Wait for the watcher's initial listing.
+	for i := 0; i < 100; i++ {
+		c.m.Lock()
+		ready := c.seeded
+		c.m.Unlock()
+		if ready {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cas1, err := c.Set("a", []byte("A"), 0)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expectEvent(ec, "a", cas1)
+
+	_, err = other.Set("a", []byte("other"), 0)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cas2, err := c.Set("a", []byte("AA"), cas1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expectEvent(ec, "a", cas2)
+
+	err = c.Del("a", cas2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expectEvent(ec, "a", 0)
+
+	select {
+	case e := <-ec2:
+		t.Errorf("expected no events for ec2, got: %#v", e)
+	case e := <-ec:
+		t.Errorf("expected no more events for ec, got: %#v", e)
+	default:
+	}
+
+	cas3, err := c.Set("a", []byte("AAA"), 0)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expectEvent(ec, "a", cas3)
+
+	err = c.Refresh()
+	if err != nil {
+		t.Fatalf("expected Refresh() to work, err: %v", err)
+	}
+	expectEvent(ec, "a", cas3)
+	expectEvent(ec2, "aaa", 0)
+}
@@ -0,0 +1,546 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// CfgHistoryMaxEntriesDefault is the default max number of entries
+// that a CfgHistory keeps, after which the oldest entries are dropped.
+var CfgHistoryMaxEntriesDefault = 1000
+
+// CfgHistoryMaxValBytesDefault is the default max total size of the
+// values that a CfgHistory keeps for rollbacks, after which the values
+// of the oldest entries are dropped, while the entries themselves keep
+// the hashes of their values.
+var CfgHistoryMaxValBytesDefault = 8 * 1024 * 1024
+
+// A CfgHistoryEntry is a single mutation of a Cfg key.
+type CfgHistoryEntry struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Op      string    `json:"op"` // "set" or "del".
+	Key     string    `json:"key"`
+	OldCAS  uint64    `json:"oldCAS"`
+	NewCAS  uint64    `json:"newCAS"`
+	ValHash string    `json:"valHash,omitempty"` // Hex sha256 of the new value.
+	Actor   string    `json:"actor,omitempty"`
+
+	// RollbackOf is the Seq of the entry that this mutation rolled the
+	// key back to, if any.
+	RollbackOf uint64 `json:"rollbackOf,omitempty"`
+}
+
+// CfgHistory is a Cfg wrapper that keeps an append-only history of
+// the mutations of the underlying Cfg's keys, along with the values
+// they wrote, so that a key can be rolled back to a previous revision,
+// such as after a bad index definition update.  The values are kept
+// within a byte budget, see SetMaxValBytes, and the entries, but not
+// the values, can be persisted across restarts, see Persist.
+//
+// The mutations are attributed to the CfgHistory's actor, such as the
+// node's UUID, unless they're made via WithActor.  The mutations made
+// by others, such as by the other nodes of a cluster, are also kept
+// for the keys given to TrackKeys, with an unknown actor.
+type CfgHistory struct {
+	Cfg
+
+	actor      string
+	maxEntries int
+
+	m           sync.Mutex
+	seq         uint64
+	entries     []*CfgHistoryEntry
+	vals        map[string][]byte // Keyed by ValHash.
+	valBytes    int
+	maxValBytes int
+	lastCAS     map[string]uint64 // Keyed by key.
+	stopCh      chan struct{}
+
+	path        string   // The path of the persisted entries, if any.
+	file        *os.File // Appended with each entry.
+	fileEntries int
+}
+
+// NewCfgHistory returns a CfgHistory of the cfg, whose mutations are
+// attributed to the actor.  A maxEntries of 0 means
+// CfgHistoryMaxEntriesDefault.
+func NewCfgHistory(cfg Cfg, actor string, maxEntries int) *CfgHistory {
+	if maxEntries <= 0 {
+		maxEntries = CfgHistoryMaxEntriesDefault
+	}
+
+	return &CfgHistory{
+		Cfg:         cfg,
+		actor:       actor,
+		maxEntries:  maxEntries,
+		vals:        map[string][]byte{},
+		maxValBytes: CfgHistoryMaxValBytesDefault,
+		lastCAS:     map[string]uint64{},
+		stopCh:      make(chan struct{}),
+	}
+}
+
+// SetMaxValBytes changes the max total size of the values that are
+// kept for rollbacks.
+func (h *CfgHistory) SetMaxValBytes(maxValBytes int) {
+	h.m.Lock()
+	h.maxValBytes = maxValBytes
+	h.dropValsLOCKED()
+	h.m.Unlock()
+}
+
+// Persist loads the entries that were persisted at the path, if any,
+// and then appends each new entry to the file of the path, which is
+// compacted as the oldest entries are dropped.  The values aren't
+// persisted, so the revisions from before a restart can be inspected,
+// but not rolled back to.
+func (h *CfgHistory) Persist(path string) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.file != nil {
+		return fmt.Errorf("cfg_history: already persisted to: %s", h.path)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	var loaded []*CfgHistoryEntry
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		e := &CfgHistoryEntry{}
+		if json.Unmarshal(scanner.Bytes(), e) != nil {
+			continue // Such as a partial write before a crash.
+		}
+		loaded = append(loaded, e)
+	}
+	if err = scanner.Err(); err != nil {
+		f.Close()
+		return err
+	}
+
+	h.path = path
+	h.file = f
+	h.fileEntries = len(loaded)
+
+	for _, e := range loaded {
+		if e.Seq > h.seq {
+			h.seq = e.Seq
+		}
+	}
+
+	// The loaded entries come before any that were recorded since.
+	for _, e := range h.entries {
+		h.seq++
+		e.Seq = h.seq
+		h.appendFileLOCKED(e)
+	}
+	h.entries = append(loaded, h.entries...)
+	h.dropEntriesLOCKED()
+
+	return nil
+}
+
+// WithVersionReader returns the CfgHistory as a Cfg that's also a
+// VersionReader when the underlying Cfg is one, so that the history
+// doesn't hide the cluster's compatibility version.
+func (h *CfgHistory) WithVersionReader() Cfg {
+	if vr, ok := h.Cfg.(VersionReader); ok {
+		return &cfgHistoryVersionReader{CfgHistory: h, VersionReader: vr}
+	}
+	return h
+}
+
+type cfgHistoryVersionReader struct {
+	*CfgHistory
+	VersionReader
+}
+
+// Unwrap returns the underlying Cfg.
+func (h *CfgHistory) Unwrap() Cfg {
+	return h.Cfg
+}
+
+func (h *CfgHistory) cfgHistory() *CfgHistory {
+	return h
+}
+
+func (h *CfgHistory) Set(key string, val []byte, cas uint64) (
+	uint64, error) {
+	return h.set(h.actor, key, val, cas, 0)
+}
+
+func (h *CfgHistory) Del(key string, cas uint64) error {
+	return h.del(h.actor, key, cas, 0)
+}
+
+func (h *CfgHistory) set(actor, key string, val []byte, cas uint64,
+	rollbackOf uint64) (uint64, error) {
+	casSuccess, err := h.Cfg.Set(key, val, cas)
+	if err == nil {
+		h.record(actor, "set", key, val, casSuccess, rollbackOf)
+	}
+
+	return casSuccess, err
+}
+
+func (h *CfgHistory) del(actor, key string, cas uint64,
+	rollbackOf uint64) error {
+	err := h.Cfg.Del(key, cas)
+	if err == nil {
+		h.record(actor, "del", key, nil, 0, rollbackOf)
+	}
+
+	return err
+}
+
+// WithActor returns a Cfg whose mutations of the CfgHistory's
+// underlying Cfg are attributed to the actor, such as the user of a
+// REST request.
+func (h *CfgHistory) WithActor(actor string) Cfg {
+	return &cfgHistoryActor{CfgHistory: h, actor: actor}
+}
+
+type cfgHistoryActor struct {
+	*CfgHistory
+
+	actor string
+}
+
+func (a *cfgHistoryActor) Set(key string, val []byte, cas uint64) (
+	uint64, error) {
+	return a.set(a.actor, key, val, cas, 0)
+}
+
+func (a *cfgHistoryActor) Del(key string, cas uint64) error {
+	return a.del(a.actor, key, cas, 0)
+}
+
+// TrackKeys additionally keeps the mutations of the given keys that
+// are made by others, as seen via the underlying Cfg's subscription
+// events.
+func (h *CfgHistory) TrackKeys(keys []string) error {
+	h.m.Lock()
+	stopCh := h.stopCh
+	h.m.Unlock()
+
+	if stopCh == nil {
+		return fmt.Errorf("cfg_history: closed")
+	}
+
+	ch := make(chan CfgEvent, 10)
+
+	for _, key := range keys {
+		err := h.Cfg.Subscribe(key, ch)
+		if err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case <-stopCh:
+				return
+
+			case ev := <-ch:
+				if ev.Error != nil {
+					continue
+				}
+
+				val, cas, err := h.Cfg.Get(ev.Key, 0)
+				if err != nil {
+					log.Warnf("cfg_history: get, key: %s, err: %v", ev.Key, err)
+					continue
+				}
+
+				if val == nil {
+					h.record("", "del", ev.Key, nil, 0, 0)
+				} else {
+					h.record("", "set", ev.Key, val, cas, 0)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops the tracking of the keys of TrackKeys, and the
+// persisting of the entries.
+func (h *CfgHistory) Close() error {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.stopCh != nil {
+		close(h.stopCh)
+		h.stopCh = nil
+	}
+
+	if h.file != nil {
+		err := h.file.Close()
+		h.file = nil
+		return err
+	}
+
+	return nil
+}
+
+// record appends a mutation, unless it's already been appended as
+// seen from both a write and its subscription event.
+func (h *CfgHistory) record(actor, op, key string, val []byte,
+	cas uint64, rollbackOf uint64) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	oldCAS, exists := h.lastCAS[key]
+	if exists && oldCAS == cas {
+		return
+	}
+	h.lastCAS[key] = cas
+
+	h.seq++
+
+	e := &CfgHistoryEntry{
+		Seq:        h.seq,
+		Time:       time.Now(),
+		Op:         op,
+		Key:        key,
+		OldCAS:     oldCAS,
+		NewCAS:     cas,
+		Actor:      actor,
+		RollbackOf: rollbackOf,
+	}
+
+	if op == "set" {
+		sum := sha256.Sum256(val)
+		e.ValHash = hex.EncodeToString(sum[:])
+		if _, exists := h.vals[e.ValHash]; !exists &&
+			len(val) <= h.maxValBytes {
+			h.vals[e.ValHash] = append([]byte(nil), val...)
+			h.valBytes += len(val)
+		}
+	}
+
+	h.entries = append(h.entries, e)
+	h.appendFileLOCKED(e)
+
+	h.dropEntriesLOCKED()
+	h.dropValsLOCKED()
+}
+
+// dropEntriesLOCKED drops the oldest entries past the maxEntries,
+// along with their values that are no longer referenced.
+func (h *CfgHistory) dropEntriesLOCKED() {
+	if len(h.entries) <= h.maxEntries {
+		return
+	}
+
+	h.entries = append([]*CfgHistoryEntry(nil),
+		h.entries[len(h.entries)-h.maxEntries:]...)
+
+	kept := make(map[string][]byte, len(h.vals))
+	h.valBytes = 0
+	for _, e := range h.entries {
+		if val, exists := h.vals[e.ValHash]; exists && kept[e.ValHash] == nil {
+			kept[e.ValHash] = val
+			h.valBytes += len(val)
+		}
+	}
+	h.vals = kept
+
+	// The file is compacted once it's twice the kept entries.
+	if h.file != nil && h.fileEntries > 2*h.maxEntries {
+		h.compactFileLOCKED()
+	}
+}
+
+// dropValsLOCKED drops the values of the oldest entries until the
+// kept values fit within the maxValBytes.
+func (h *CfgHistory) dropValsLOCKED() {
+	for _, e := range h.entries {
+		if h.valBytes <= h.maxValBytes {
+			return
+		}
+		if val, exists := h.vals[e.ValHash]; exists {
+			delete(h.vals, e.ValHash)
+			h.valBytes -= len(val)
+		}
+	}
+}
+
+// appendFileLOCKED appends an entry to the persisted entries, if any.
+func (h *CfgHistory) appendFileLOCKED(e *CfgHistoryEntry) {
+	if h.file == nil {
+		return
+	}
+
+	buf, err := json.Marshal(e)
+	if err == nil {
+		_, err = h.file.Write(append(buf, '\n'))
+	}
+	if err != nil {
+		log.Warnf("cfg_history: append, path: %s, err: %v", h.path, err)
+		return
+	}
+
+	h.fileEntries++
+}
+
+// compactFileLOCKED rewrites the persisted entries with only the kept
+// entries.
+func (h *CfgHistory) compactFileLOCKED() {
+	tmpPath := h.path + ".tmp"
+
+	err := func() error {
+		f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+			0600)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		enc := json.NewEncoder(w)
+		for _, e := range h.entries {
+			if err = enc.Encode(e); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		if err = w.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmpPath, h.path)
+	}()
+	if err != nil {
+		log.Warnf("cfg_history: compact, path: %s, err: %v", h.path, err)
+		os.Remove(tmpPath)
+		return
+	}
+
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Warnf("cfg_history: reopen, path: %s, err: %v", h.path, err)
+		return
+	}
+
+	h.file.Close()
+	h.file = f
+	h.fileEntries = len(h.entries)
+}
+
+// Entries returns the kept entries of the key, or of all keys when
+// key is "", oldest first.
+func (h *CfgHistory) Entries(key string) []*CfgHistoryEntry {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	rv := make([]*CfgHistoryEntry, 0, len(h.entries))
+	for _, e := range h.entries {
+		if key == "" || e.Key == key {
+			x := *e
+			rv = append(rv, &x)
+		}
+	}
+
+	return rv
+}
+
+// Value returns the value that was written by the entry of the seq.
+func (h *CfgHistory) Value(seq uint64) (*CfgHistoryEntry, []byte, error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for _, e := range h.entries {
+		if e.Seq == seq {
+			x := *e
+			return &x, h.vals[e.ValHash], nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("cfg_history: unknown seq: %d", seq)
+}
+
+// Rollback restores the key to its revision of the entry of the seq,
+// by rewriting the value of that revision, or by deleting the key when
+// that revision was a deletion, which is attributed to the actor.  The
+// rollback is itself appended to the history.
+func (h *CfgHistory) Rollback(key string, seq uint64, actor string) (
+	*CfgHistoryEntry, error) {
+	e, val, err := h.Value(seq)
+	if err != nil {
+		return nil, err
+	}
+	if e.Key != key {
+		return nil, fmt.Errorf("cfg_history: seq: %d is of key: %s,"+
+			" not key: %s", seq, e.Key, key)
+	}
+	if e.Op == "set" && val == nil {
+		return nil, fmt.Errorf("cfg_history: value of seq: %d"+
+			" is no longer kept", seq)
+	}
+
+	_, cas, err := h.Cfg.Get(key, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Op == "del" {
+		if cas == 0 {
+			return e, nil // Already deleted.
+		}
+		err = h.del(actor, key, cas, seq)
+	} else {
+		_, err = h.set(actor, key, val, cas, seq)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("cfg_history: rollback, key: %s, seq: %d, actor: %s",
+		key, seq, actor)
+
+	return e, nil
+}
+
+// CfgHistory returns the CfgHistory of the manager's Cfg, which may
+// be wrapped by other Cfg's, or nil when the manager's Cfg doesn't
+// keep a history.
+func (mgr *Manager) CfgHistory() *CfgHistory {
+	cfg := mgr.Cfg()
+	for cfg != nil {
+		if h, ok := cfg.(interface{ cfgHistory() *CfgHistory }); ok {
+			return h.cfgHistory()
+		}
+		u, ok := cfg.(interface{ Unwrap() Cfg })
+		if !ok {
+			return nil
+		}
+		cfg = u.Unwrap()
+	}
+	return nil
+}
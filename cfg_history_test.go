@@ -0,0 +1,198 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCfgHistoryRollback(t *testing.T) {
+	cfgMem := NewCfgMem()
+	h := NewCfgHistory(cfgMem, "n0", 0)
+	defer h.Close()
+
+	cas, err := h.Set("a", []byte("good"), 0)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if _, err = h.WithActor("bob").Set("a", []byte("bad"), cas); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if _, err = h.Set("b", []byte("1"), 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = h.Del("b", 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	entries := h.Entries("a")
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got: %#v", entries)
+	}
+	if entries[0].Actor != "n0" || entries[1].Actor != "bob" ||
+		entries[1].OldCAS != entries[0].NewCAS ||
+		entries[0].ValHash == entries[1].ValHash {
+		t.Errorf("unexpected entries: %#v, %#v", entries[0], entries[1])
+	}
+	if len(h.Entries("")) != 4 {
+		t.Errorf("expected 4 entries, got: %#v", h.Entries(""))
+	}
+
+	if _, err = h.Rollback("b", entries[0].Seq, "alice"); err == nil {
+		t.Errorf("expected err on a seq of another key")
+	}
+	if _, err = h.Rollback("a", 100, "alice"); err == nil {
+		t.Errorf("expected err on an unknown seq")
+	}
+
+	if _, err = h.Rollback("a", entries[0].Seq, "alice"); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	val, _, _ := cfgMem.Get("a", 0)
+	if string(val) != "good" {
+		t.Errorf("expected rolled back val, got: %s", val)
+	}
+
+	entries = h.Entries("a")
+	last := entries[len(entries)-1]
+	if last.Actor != "alice" || last.RollbackOf != entries[0].Seq ||
+		last.ValHash != entries[0].ValHash {
+		t.Errorf("unexpected rollback entry: %#v", last)
+	}
+
+	// Rolling back to before a deletion recreates the key.
+	bEntries := h.Entries("b")
+	if _, err = h.Rollback("b", bEntries[0].Seq, "alice"); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if val, _, _ = cfgMem.Get("b", 0); string(val) != "1" {
+		t.Errorf("expected recreated val, got: %s", val)
+	}
+}
+
+func TestCfgHistoryTrackKeysAndMaxEntries(t *testing.T) {
+	cfgMem := NewCfgMem()
+	h := NewCfgHistory(cfgMem, "n0", 3)
+	defer h.Close()
+
+	if err := h.TrackKeys([]string{"c"}); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	cfgMem.Set("c", []byte("remote"), 0)
+
+	for i := 0; i < 100; i++ {
+		if len(h.Entries("c")) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	entries := h.Entries("c")
+	if len(entries) != 1 || entries[0].Actor != "" {
+		t.Fatalf("expected a tracked entry, got: %#v", entries)
+	}
+
+	for _, v := range []string{"1", "2", "3"} {
+		if _, err := h.Set("d", []byte(v), CFG_CAS_FORCE); err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+
+	entries = h.Entries("")
+	if len(entries) != 3 || entries[0].Key != "d" {
+		t.Fatalf("expected the oldest entries dropped, got: %#v", entries)
+	}
+	if _, err := h.Rollback("c", 1, "alice"); err == nil {
+		t.Errorf("expected err on a dropped seq")
+	}
+	if _, val, err := h.Value(entries[0].Seq); err != nil || string(val) != "1" {
+		t.Errorf("expected kept val, got: %s, err: %v", val, err)
+	}
+}
+
+func TestCfgHistoryMaxValBytes(t *testing.T) {
+	h := NewCfgHistory(NewCfgMem(), "n0", 0)
+	defer h.Close()
+	h.SetMaxValBytes(10)
+
+	for _, v := range []string{"aaaa", "bbbb", "cccc", "a-too-large-val"} {
+		if _, err := h.Set("k", []byte(v), CFG_CAS_FORCE); err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+
+	entries := h.Entries("k")
+	if len(entries) != 4 {
+		t.Fatalf("expected all of the entries, got: %#v", entries)
+	}
+	for i, exp := range []string{"", "bbbb", "cccc", ""} {
+		_, val, err := h.Value(entries[i].Seq)
+		if err != nil || string(val) != exp || entries[i].ValHash == "" {
+			t.Errorf("entry: %d, expected val: %q, got: %q, err: %v",
+				i, exp, val, err)
+		}
+	}
+	if _, err := h.Rollback("k", entries[0].Seq, "alice"); err == nil {
+		t.Errorf("expected err on a dropped val")
+	}
+}
+
+func TestCfgHistoryPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfgHistory")
+
+	cfgMem := NewCfgMem()
+	h := NewCfgHistory(cfgMem, "n0", 2)
+	if err := h.Persist(path); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	for _, v := range []string{"1", "2", "3", "4", "5", "6"} {
+		h.Set("k", []byte(v), CFG_CAS_FORCE)
+	}
+	h.Close()
+
+	h = NewCfgHistory(cfgMem, "n0", 2)
+	defer h.Close()
+	if err := h.Persist(path); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	entries := h.Entries("k")
+	if len(entries) != 2 || entries[0].Seq != 5 || entries[1].Seq != 6 ||
+		entries[1].Actor != "n0" {
+		t.Fatalf("expected the persisted entries, got: %#v", entries)
+	}
+
+	h.Set("k", []byte("7"), CFG_CAS_FORCE)
+	entries = h.Entries("k")
+	if len(entries) != 2 || entries[1].Seq != 7 {
+		t.Fatalf("expected the seq to continue, got: %#v", entries)
+	}
+}
+
+func TestManagerCfgHistoryUnwrap(t *testing.T) {
+	h := NewCfgHistory(NewCfgMem(), "n0", 0)
+	defer h.Close()
+
+	cfg := NewCfgMetrics(NewCfgMetrics(h.WithVersionReader(),
+		&CfgStats{}), &CfgStats{})
+
+	mgr := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+	if mgr.CfgHistory() != h {
+		t.Fatalf("expected the history through the wrappers")
+	}
+
+	mgr = NewManager(VERSION, NewCfgMetrics(NewCfgMem(), &CfgStats{}), NewUUID(),
+		nil, "", 1, "", "", "", "", nil)
+	if mgr.CfgHistory() != nil {
+		t.Fatalf("expected no history")
+	}
+}
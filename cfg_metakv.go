@@ -14,6 +14,7 @@ import (
 	"hash/crc32"
 	"io"
 	"net/http"
+	"path"
 	"reflect"
 	"sort"
 	"strconv"
@@ -112,6 +113,7 @@ type CfgMetaKv struct {
 	m            sync.Mutex // Protects the fields that follow.
 	cfgMem       *CfgMem
 	cancelCh     chan struct{}
+	observing    bool // Whether the metakv observer was started.
 	lastSplitCAS uint64
 	splitEntries map[string]CfgMetaKvEntry
 	nsServerUrl  string
@@ -139,31 +141,62 @@ type VersionReader interface {
 
 // NewCfgMetaKv returns a CfgMetaKv that reads and stores its single
 // configuration file in the metakv.
+//
+// Allowed options include:
+//   - 'nsServerURL': the URL of the ns_server.
+//   - 'keyPrefix': an optional tenant prefix, which separates the Cfg
+//     of a cluster from the other clusters that share the metakv, by
+//     storing it in the tenant's directory (see cfgMetaKvTenantPrefix).
 func NewCfgMetaKv(nodeUUID string, options map[string]string) (*CfgMetaKv, error) {
 	nsServerURL, _ := options["nsServerURL"]
 
+	prefix := CfgMetaKvPrefix
+	if keyPrefix := options["keyPrefix"]; keyPrefix != "" {
+		err := ValidateCfgKeyPrefix(keyPrefix)
+		if err != nil {
+			return nil, err
+		}
+		prefix = cfgMetaKvTenantPrefix(keyPrefix)
+	}
+
+	return newCfgMetaKv(nodeUUID, prefix, nsServerURL+"/pools/default"), nil
+}
+
+func newCfgMetaKv(nodeUUID, prefix, nsServerUrl string) *CfgMetaKv {
 	cfg := &CfgMetaKv{
-		prefix:       CfgMetaKvPrefix,
+		prefix:       prefix,
 		nodeUUID:     nodeUUID,
 		cfgMem:       NewCfgMem(),
 		cancelCh:     make(chan struct{}),
 		splitEntries: map[string]CfgMetaKvEntry{},
+		nsServerUrl:  nsServerUrl,
 
 		readersPool: &sync.Pool{New: func() interface{} { return new(gzip.Reader) }},
 		writersPool: &sync.Pool{New: func() interface{} { return new(gzip.Writer) }},
 	}
 
+	return cfg
+}
+
+// observeLOCKED starts the metakv observer of the CfgMetaKv's
+// directory, if it's not started yet.  The observer only fires the
+// events of the subscriptions, so it's started by the first Subscribe,
+// which keeps a CfgMetaKv that's only used to get a tenant's CfgMetaKv
+// via WithKeyPrefix from observing the metakv for nothing.
+func (c *CfgMetaKv) observeLOCKED() {
+	if c.observing {
+		return
+	}
+	c.observing = true
+
 	backoffStartSleepMS := 200
 	backoffFactor := float32(1.5)
 	backoffMaxSleepMS := 5000
-	leanPlanKeyPrefix = CfgMetaKvPrefix + leanPlanKeyPrefix
-
-	cfg.nsServerUrl = nsServerURL + "/pools/default"
 
 	go ExponentialBackoffLoop("cfg_metakv.RunObserveChildren",
 		func() int {
-			err := metakv.RunObserveChildren(cfg.prefix, cfg.metaKVCallback,
-				cfg.cancelCh)
+			err := metakv.RunObserveChildren(c.prefix, c.metaKVCallback,
+				c.cancelCh)
 			if err == nil {
 				return -1 // Success, so stop the loop.
 			}
@@ -173,8 +206,36 @@ func NewCfgMetaKv(nodeUUID string, options map[string]string) (*CfgMetaKv, error
 			return 0 // No progress, so exponential backoff.
 		},
 		backoffStartSleepMS, backoffFactor, backoffMaxSleepMS)
+}
+
+// cfgMetaKvTenantPrefix returns the metakv directory of a tenant's
+// Cfg, which is a sibling of the CfgMetaKvPrefix directory, like
+// "/fts/cbgt/tenants/$keyPrefix/" for "/fts/cbgt/cfg/", so that the
+// observers, iterations and deletions of the default Cfg never see
+// the tenants' keys.
+func cfgMetaKvTenantPrefix(keyPrefix string) string {
+	return path.Dir(strings.TrimSuffix(CfgMetaKvPrefix, "/")) +
+		"/tenants/" + keyPrefix + "/"
+}
+
+// WithKeyPrefix implements the CfgKeyPrefixer interface, by returning
+// a loaded CfgMetaKv of the tenant's directory of the metakv, which
+// observes only the tenant's directory once it's subscribed to.
+func (c *CfgMetaKv) WithKeyPrefix(keyPrefix string) (Cfg, error) {
+	err := ValidateCfgKeyPrefix(keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	c2 := newCfgMetaKv(c.nodeUUID, cfgMetaKvTenantPrefix(keyPrefix),
+		c.nsServerUrl)
+
+	err = c2.Load()
+	if err != nil {
+		return nil, err
+	}
 
-	return cfg, nil
+	return c2, nil
 }
 
 func (c *CfgMetaKv) Get(key string, cas uint64) ([]byte, uint64, error) {
@@ -305,6 +366,9 @@ func (c *CfgMetaKv) metaKVCallback(kve metakv.KVEntry) error {
 func (c *CfgMetaKv) Subscribe(key string, ch chan CfgEvent) error {
 	c.m.Lock()
 	err := c.cfgMem.Subscribe(key, ch)
+	if err == nil {
+		c.observeLOCKED()
+	}
 	c.m.Unlock()
 
 	return err
@@ -35,6 +35,12 @@ var curMetaKvPlanKey = "curMetaKvPlanKey"
 
 var leanPlanKeyPrefix = "planPIndexesLean/planPIndexesLean-"
 
+// leanPlanPathPrefix returns the metakv path prefix of the lean plan
+// directories.
+func (c *CfgMetaKv) leanPlanPathPrefix() string {
+	return c.keyToPath(leanPlanKeyPrefix)
+}
+
 var md5HashLength = 32
 
 var PlanPurgeTimeout = int64(90000) // 15 min default
@@ -282,7 +288,7 @@ RETRY:
 
 	// compare the hash of the fetched metakv content and
 	// that from the the directory name stamp.
-	hashStart := len(leanPlanKeyPrefix)
+	hashStart := len(c.leanPlanPathPrefix())
 	hashFromName := planMeta.Path[hashStart : hashStart+32]
 	if hashFromName != hashMD5 {
 		if attempt < maxRetry {
@@ -449,8 +455,8 @@ func purgeOrphanedLeanPlans(c *CfgMetaKv, curPath string) error {
 	// purge all those orphan lean planPIndex directory paths
 	// which are older than PlanPurgeTimeout value
 	for orphanPath := range planDirPaths {
-		if strings.HasPrefix(orphanPath, leanPlanKeyPrefix) {
-			bornTimeStr := orphanPath[len(leanPlanKeyPrefix)+
+		if strings.HasPrefix(orphanPath, c.leanPlanPathPrefix()) {
+			bornTimeStr := orphanPath[len(c.leanPlanPathPrefix())+
 				md5HashLength+1 : len(orphanPath)-1]
 			// check if older than PlanPurgeTimeout
 			bornTimeMs, _ := strconv.Atoi(bornTimeStr)
@@ -0,0 +1,146 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CfgStats are the counters of the Cfg operations of a manager, which
+// help tell when the Cfg's backend, such as metakv, is the bottleneck,
+// such as during a rebalance.  The CAS conflicts aren't counted as
+// errors.
+type CfgStats struct {
+	TotGet         uint64
+	TotGetErr      uint64
+	TotGetCASError uint64
+	TotGetTimeNS   uint64
+
+	TotSet         uint64
+	TotSetErr      uint64
+	TotSetCASError uint64
+	TotSetTimeNS   uint64
+
+	TotDel         uint64
+	TotDelErr      uint64
+	TotDelCASError uint64
+	TotDelTimeNS   uint64
+}
+
+// AtomicCopyTo copies the stats from s to r (from source to result).
+func (s *CfgStats) AtomicCopyTo(r *CfgStats) {
+	AtomicCopyMetrics(s, r, nil)
+}
+
+// CfgMetrics is a Cfg wrapper that counts the Get/Set/Del operations
+// of the underlying Cfg into its CfgStats, and reports their latencies,
+// errors and CAS conflicts to the process-wide MetricsProvider, as the
+// "cbgt_cfg_op_seconds", "cbgt_cfg_op_errors_total" and
+// "cbgt_cfg_cas_conflicts_total" metrics, labeled by the "op".
+type CfgMetrics struct {
+	Cfg
+
+	stats *CfgStats
+}
+
+// NewCfgMetrics returns a CfgMetrics of the cfg that counts into the
+// stats, which is also a VersionReader when the cfg is, or nil when the
+// cfg is nil.
+func NewCfgMetrics(cfg Cfg, stats *CfgStats) Cfg {
+	if cfg == nil {
+		return nil
+	}
+
+	c := &CfgMetrics{Cfg: cfg, stats: stats}
+
+	if vr, ok := cfg.(VersionReader); ok {
+		return &cfgMetricsVersionReader{CfgMetrics: c, VersionReader: vr}
+	}
+
+	return c
+}
+
+type cfgMetricsVersionReader struct {
+	*CfgMetrics
+	VersionReader
+}
+
+// observe counts an operation of the op, which started at the
+// startTime and returned the err.
+func (c *CfgMetrics) observe(op string, startTime time.Time, err error,
+	tot, totErr, totCASError, totTimeNS *uint64) {
+	d := time.Since(startTime)
+
+	atomic.AddUint64(tot, 1)
+	atomic.AddUint64(totTimeNS, uint64(d))
+
+	p := GetMetricsProvider()
+	labels := MetricLabels{"op": op}
+
+	p.Histogram("cbgt_cfg_op_seconds", labels).Observe(d.Seconds())
+
+	if err != nil {
+		if _, ok := err.(*CfgCASError); ok {
+			atomic.AddUint64(totCASError, 1)
+			p.Counter("cbgt_cfg_cas_conflicts_total", labels).Add(1)
+		} else {
+			atomic.AddUint64(totErr, 1)
+			p.Counter("cbgt_cfg_op_errors_total", labels).Add(1)
+		}
+	}
+}
+
+func (c *CfgMetrics) Get(key string, cas uint64) (
+	val []byte, casSuccess uint64, err error) {
+	startTime := time.Now()
+	val, casSuccess, err = c.Cfg.Get(key, cas)
+	c.observe("get", startTime, err, &c.stats.TotGet, &c.stats.TotGetErr,
+		&c.stats.TotGetCASError, &c.stats.TotGetTimeNS)
+	return val, casSuccess, err
+}
+
+func (c *CfgMetrics) Set(key string, val []byte, cas uint64) (
+	casSuccess uint64, err error) {
+	startTime := time.Now()
+	casSuccess, err = c.Cfg.Set(key, val, cas)
+	c.observe("set", startTime, err, &c.stats.TotSet, &c.stats.TotSetErr,
+		&c.stats.TotSetCASError, &c.stats.TotSetTimeNS)
+	return casSuccess, err
+}
+
+func (c *CfgMetrics) Del(key string, cas uint64) error {
+	startTime := time.Now()
+	err := c.Cfg.Del(key, cas)
+	c.observe("del", startTime, err, &c.stats.TotDel, &c.stats.TotDelErr,
+		&c.stats.TotDelCASError, &c.stats.TotDelTimeNS)
+	return err
+}
+
+// WithKeyPrefix implements the CfgKeyPrefixer interface, so that the
+// underlying Cfg separates the tenant's keys on its own when it can.
+func (c *CfgMetrics) WithKeyPrefix(keyPrefix string) (Cfg, error) {
+	cfg, err := NewCfgPrefixed(c.Cfg, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCfgMetrics(cfg, c.stats), nil
+}
+
+// Unwrap returns the underlying Cfg.
+func (c *CfgMetrics) Unwrap() Cfg {
+	return c.Cfg
+}
+
+// CfgStatsCopyTo copies the current stats of the manager's Cfg
+// operations to the dst stats.
+func (mgr *Manager) CfgStatsCopyTo(dst *CfgStats) {
+	mgr.cfgStats.AtomicCopyTo(dst)
+}
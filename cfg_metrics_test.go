@@ -0,0 +1,72 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestCfgMetrics(t *testing.T) {
+	p := NewJSONMetricsProvider()
+	SetMetricsProvider(p)
+	defer SetMetricsProvider(NewJSONMetricsProvider())
+
+	if NewCfgMetrics(nil, &CfgStats{}) != nil {
+		t.Errorf("expected nil for a nil cfg")
+	}
+
+	testCfg(t, NewCfgMetrics(NewCfgMem(), &CfgStats{}))
+
+	p = NewJSONMetricsProvider()
+	SetMetricsProvider(p)
+
+	var stats CfgStats
+	cfg := NewCfgMetrics(NewCfgMem(), &stats)
+
+	if _, _, err := cfg.Get("a", 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	cas, err := cfg.Set("a", []byte("1"), 0)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if _, err = cfg.Set("a", []byte("2"), cas+100); err == nil {
+		t.Fatalf("expected a CAS err")
+	}
+	if err = cfg.Del("a", cas+100); err == nil {
+		t.Fatalf("expected a CAS err")
+	}
+
+	var curr CfgStats
+	stats.AtomicCopyTo(&curr)
+	if curr.TotGet != 1 || curr.TotSet != 2 || curr.TotDel != 1 ||
+		curr.TotSetCASError != 1 || curr.TotDelCASError != 1 ||
+		curr.TotSetErr != 0 || curr.TotSetTimeNS == 0 {
+		t.Errorf("unexpected stats: %#v", curr)
+	}
+
+	var buf bytes.Buffer
+	if err = p.WriteMetrics(&buf); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	var m map[string]interface{}
+	if err = json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("expected json, got: %s, err: %v", buf.String(), err)
+	}
+	if m[`cbgt_cfg_cas_conflicts_total{op="set"}`] != 1.0 {
+		t.Errorf("expected a set CAS conflict, got: %s", buf.String())
+	}
+	lat, _ := m[`cbgt_cfg_op_seconds{op="get"}`].(map[string]interface{})
+	if lat["count"] != 1.0 {
+		t.Errorf("expected get latencies, got: %s", buf.String())
+	}
+}
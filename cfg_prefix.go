@@ -0,0 +1,142 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// CFG_KEY_PREFIX_OPTION is the manager option that holds the tenant
+// prefix of the manager's Cfg keys, so that multiple logical cbgt
+// clusters can share a Cfg store without key collisions.
+const CFG_KEY_PREFIX_OPTION = "cfgKeyPrefix"
+
+// CFG_KEY_PREFIX_REGEXP is the pattern of the Cfg key prefixes.
+const CFG_KEY_PREFIX_REGEXP = `^[A-Za-z0-9][0-9A-Za-z_\-]*$`
+
+var cfgKeyPrefixRE = regexp.MustCompile(CFG_KEY_PREFIX_REGEXP)
+
+// CfgKeyPrefixesReserved are the names that aren't valid tenant
+// prefixes, as they're the names of the Cfg keys, or of their
+// directories in the stores that split the keys, like CfgMetaKv.
+var CfgKeyPrefixesReserved = map[string]bool{
+	INDEX_DEFS_KEY:                   true,
+	PLAN_PINDEXES_KEY:                true,
+	"planPIndexesLean":               true,
+	CfgNodeDefsKey(NODE_DEFS_KNOWN):  true,
+	CfgNodeDefsKey(NODE_DEFS_WANTED): true,
+	"tenants":                        true,
+}
+
+// ValidateCfgKeyPrefix returns an error when the tenant prefix isn't
+// a valid Cfg key prefix.
+func ValidateCfgKeyPrefix(keyPrefix string) error {
+	if !cfgKeyPrefixRE.MatchString(keyPrefix) {
+		return fmt.Errorf("cfg_prefix: invalid key prefix: %q,"+
+			" must match: %s", keyPrefix, CFG_KEY_PREFIX_REGEXP)
+	}
+	if CfgKeyPrefixesReserved[keyPrefix] {
+		return fmt.Errorf("cfg_prefix: reserved key prefix: %q", keyPrefix)
+	}
+	return nil
+}
+
+// A CfgKeyPrefixer is a Cfg that can separate the keys of a tenant on
+// its own, such as by a sub-directory of its store, which is preferred
+// over a CfgPrefixed when the Cfg encodes some keys in special ways,
+// like CfgMetaKv.
+type CfgKeyPrefixer interface {
+	WithKeyPrefix(keyPrefix string) (Cfg, error)
+}
+
+// NewCfgPrefixed returns a Cfg whose keys are those of the tenant's
+// keyPrefix in the cfg, via the cfg's own CfgKeyPrefixer support when
+// it has it, or else via a CfgPrefixed.
+func NewCfgPrefixed(cfg Cfg, keyPrefix string) (Cfg, error) {
+	err := ValidateCfgKeyPrefix(keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if p, ok := cfg.(CfgKeyPrefixer); ok {
+		return p.WithKeyPrefix(keyPrefix)
+	}
+
+	c := &CfgPrefixed{
+		Cfg:    cfg,
+		prefix: keyPrefix + "/",
+		subs:   map[chan CfgEvent]chan CfgEvent{},
+	}
+
+	if vr, ok := cfg.(VersionReader); ok {
+		return &cfgPrefixedVersionReader{CfgPrefixed: c, VersionReader: vr}, nil
+	}
+
+	return c, nil
+}
+
+// CfgPrefixed is a Cfg wrapper that prepends a tenant's prefix to the
+// keys of the underlying Cfg, and strips it from the keys of the
+// subscription events.
+type CfgPrefixed struct {
+	Cfg
+
+	prefix string // Like "tenant/".
+
+	m    sync.Mutex
+	subs map[chan CfgEvent]chan CfgEvent // Keyed by subscriber channel.
+}
+
+type cfgPrefixedVersionReader struct {
+	*CfgPrefixed
+	VersionReader
+}
+
+func (c *CfgPrefixed) Get(key string, cas uint64) ([]byte, uint64, error) {
+	return c.Cfg.Get(c.prefix+key, cas)
+}
+
+func (c *CfgPrefixed) Set(key string, val []byte, cas uint64) (
+	uint64, error) {
+	return c.Cfg.Set(c.prefix+key, val, cas)
+}
+
+func (c *CfgPrefixed) Del(key string, cas uint64) error {
+	return c.Cfg.Del(c.prefix+key, cas)
+}
+
+// Subscribe subscribes to the prefixed key of the underlying Cfg, via
+// a channel per subscriber channel whose events are forwarded with the
+// prefix stripped from their keys.
+func (c *CfgPrefixed) Subscribe(key string, ch chan CfgEvent) error {
+	c.m.Lock()
+	inner, exists := c.subs[ch]
+	if !exists {
+		inner = make(chan CfgEvent, cap(ch))
+		c.subs[ch] = inner
+
+		go func() {
+			for ev := range inner {
+				ev.Key = strings.TrimPrefix(ev.Key, c.prefix)
+				ch <- ev
+			}
+		}()
+	}
+	c.m.Unlock()
+
+	return c.Cfg.Subscribe(c.prefix+key, inner)
+}
+
+// KeyPrefix returns the tenant's prefix of the keys, like "tenant/".
+func (c *CfgPrefixed) KeyPrefix() string {
+	return c.prefix
+}
@@ -0,0 +1,143 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCfgPrefixed(t *testing.T) {
+	cfgMem := NewCfgMem()
+
+	for _, keyPrefix := range []string{"", "/a", "a/b", "a b",
+		"indexDefs", "planPIndexes", "planPIndexesLean",
+		"nodeDefs-known", "nodeDefs-wanted", "tenants"} {
+		if _, err := NewCfgPrefixed(cfgMem, keyPrefix); err == nil {
+			t.Errorf("expected err on key prefix: %q", keyPrefix)
+		}
+	}
+
+	c, err := NewCfgPrefixed(cfgMem, "t1")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	testCfg(t, c)
+
+	other, err := NewCfgPrefixed(cfgMem, "t2")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	ec := make(chan CfgEvent, 10)
+	if err = c.Subscribe(INDEX_DEFS_KEY, ec); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = c.Subscribe(PLAN_PINDEXES_KEY, ec); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	// The other tenant's writes neither collide nor fire events.
+	if _, err = other.Set(INDEX_DEFS_KEY, []byte("other"), 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if _, err = c.Set(INDEX_DEFS_KEY, []byte("mine"), 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if _, err = c.Set(PLAN_PINDEXES_KEY, []byte("plan"), 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-ec:
+			seen[e.Key] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected events, got: %v", seen)
+		}
+	}
+	if !seen[INDEX_DEFS_KEY] || !seen[PLAN_PINDEXES_KEY] {
+		t.Errorf("expected unprefixed event keys, got: %v", seen)
+	}
+
+	v, _, _ := other.Get(INDEX_DEFS_KEY, 0)
+	if string(v) != "other" {
+		t.Errorf("expected other tenant's value, got: %s", v)
+	}
+	v, _, _ = cfgMem.Get("t1/"+INDEX_DEFS_KEY, 0)
+	if string(v) != "mine" {
+		t.Errorf("expected prefixed key in the store, got: %s", v)
+	}
+}
+
+func TestCfgPrefixedConsul(t *testing.T) {
+	s := httptest.NewServer(newFakeConsul())
+	defer s.Close()
+
+	base, err := NewCfgConsul(s.URL, map[string]string{"keyPrefix": "cbgt/"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	c, err := NewCfgPrefixed(base, "t1")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if cc, ok := c.(*CfgConsul); !ok || cc.prefix != "cbgt/t1/" {
+		t.Fatalf("expected a tenant CfgConsul, got: %#v", c)
+	}
+
+	testCfg(t, c)
+}
+
+func TestManagerCfgKeyPrefix(t *testing.T) {
+	cfgMem := NewCfgMem()
+
+	mgr := NewManagerEx(VERSION, cfgMem, NewUUID(), nil,
+		"", 1, "", ":1000", t.TempDir(), "some-datasource", nil,
+		map[string]string{CFG_KEY_PREFIX_OPTION: "t1"})
+	if err := mgr.Start("wanted"); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	defer mgr.Stop()
+
+	if v, _, _ := cfgMem.Get(CfgNodeDefsKey(NODE_DEFS_WANTED), 0); v != nil {
+		t.Errorf("expected no unprefixed node defs")
+	}
+	if v, _, _ := cfgMem.Get("t1/"+CfgNodeDefsKey(NODE_DEFS_WANTED), 0); v == nil {
+		t.Errorf("expected the tenant's node defs")
+	}
+
+	mgr = NewManagerEx(VERSION, cfgMem, NewUUID(), nil,
+		"", 1, "", ":1000", t.TempDir(), "some-datasource", nil,
+		map[string]string{CFG_KEY_PREFIX_OPTION: "bad/prefix"})
+	if err := mgr.Start("wanted"); err == nil {
+		t.Errorf("expected err on an invalid key prefix")
+	}
+}
+
+func TestCfgMetaKvTenantPrefix(t *testing.T) {
+	prevPrefix := CfgMetaKvPrefix
+	defer func() { CfgMetaKvPrefix = prevPrefix }()
+
+	CfgMetaKvPrefix = "/fts/cbgt/cfg/"
+
+	// The tenants are siblings of the default Cfg, not nested in it.
+	p := cfgMetaKvTenantPrefix("t1")
+	if p != "/fts/cbgt/tenants/t1/" || strings.HasPrefix(p, CfgMetaKvPrefix) {
+		t.Errorf("unexpected tenant prefix: %s", p)
+	}
+	if strings.HasPrefix(cfgMetaKvTenantPrefix("t10"), p) {
+		t.Errorf("expected the tenants not to nest in each other")
+	}
+}
@@ -0,0 +1,304 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// A CfgRecord is a single recorded Cfg change, as written one JSON
+// object per line by a CfgRecorder.
+type CfgRecord struct {
+	Time time.Time `json:"time"`
+	Op   string    `json:"op"` // "set" or "del".
+	Key  string    `json:"key"`
+	Val  []byte    `json:"val,omitempty"`
+	CAS  uint64    `json:"cas"`
+}
+
+// CfgRecorder is a Cfg wrapper that records the successful changes to
+// the underlying Cfg, so that they can be later replayed with a
+// CfgReplayer, for example, to reproduce a planner or janitor issue
+// seen in production in a unit test.
+type CfgRecorder struct {
+	Cfg
+
+	m       sync.Mutex
+	w       io.Writer
+	closer  io.Closer
+	lastCAS map[string]uint64 // Keyed by key.
+	stopCh  chan struct{}
+}
+
+// NewCfgRecorder returns a CfgRecorder that records the changes to
+// the cfg into w.
+func NewCfgRecorder(cfg Cfg, w io.Writer) *CfgRecorder {
+	return &CfgRecorder{
+		Cfg:     cfg,
+		w:       w,
+		lastCAS: map[string]uint64{},
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// NewCfgRecorderFile returns a CfgRecorder that appends the changes
+// to the cfg into the file at path.
+func NewCfgRecorderFile(cfg Cfg, path string) (*CfgRecorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewCfgRecorder(cfg, f)
+	r.closer = f
+
+	return r, nil
+}
+
+func (r *CfgRecorder) Set(key string, val []byte, cas uint64) (
+	uint64, error) {
+	casSuccess, err := r.Cfg.Set(key, val, cas)
+	if err == nil {
+		r.record("set", key, val, casSuccess)
+	}
+
+	return casSuccess, err
+}
+
+func (r *CfgRecorder) Del(key string, cas uint64) error {
+	err := r.Cfg.Del(key, cas)
+	if err == nil {
+		r.record("del", key, nil, 0)
+	}
+
+	return err
+}
+
+// RecordKeys additionally records the changes to the given keys that
+// are made by others, such as by the other nodes of a cluster, as
+// seen via the underlying Cfg's subscription events.
+func (r *CfgRecorder) RecordKeys(keys []string) error {
+	r.m.Lock()
+	stopCh := r.stopCh
+	r.m.Unlock()
+
+	if stopCh == nil {
+		return fmt.Errorf("cfg_recorder: closed")
+	}
+
+	ch := make(chan CfgEvent, 10)
+
+	for _, key := range keys {
+		err := r.Cfg.Subscribe(key, ch)
+		if err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case <-stopCh:
+				return
+
+			case ev := <-ch:
+				if ev.Error != nil {
+					continue
+				}
+
+				val, cas, err := r.Cfg.Get(ev.Key, 0)
+				if err != nil {
+					log.Warnf("cfg_recorder: get, key: %s, err: %v", ev.Key, err)
+					continue
+				}
+
+				if val == nil {
+					r.record("del", ev.Key, nil, 0)
+				} else {
+					r.record("set", ev.Key, val, cas)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops recording and closes the file of a CfgRecorder from
+// NewCfgRecorderFile.
+func (r *CfgRecorder) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.stopCh != nil {
+		close(r.stopCh)
+		r.stopCh = nil
+	}
+
+	r.w = nil
+
+	if r.closer != nil {
+		err := r.closer.Close()
+		r.closer = nil
+		return err
+	}
+
+	return nil
+}
+
+// record writes a change, unless it's already been recorded as seen
+// from both a write and its subscription event.
+func (r *CfgRecorder) record(op, key string, val []byte, cas uint64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.w == nil {
+		return
+	}
+
+	lastCAS, exists := r.lastCAS[key]
+	if exists && lastCAS == cas {
+		return
+	}
+	r.lastCAS[key] = cas
+
+	buf, err := json.Marshal(&CfgRecord{
+		Time: time.Now(),
+		Op:   op,
+		Key:  key,
+		Val:  val,
+		CAS:  cas,
+	})
+	if err == nil {
+		_, err = r.w.Write(append(buf, '\n'))
+	}
+	if err != nil {
+		log.Warnf("cfg_recorder: record, key: %s, err: %v", key, err)
+	}
+}
+
+// ------------------------------------------------------------------------
+
+// CfgReplayer feeds previously recorded Cfg changes, one at a time,
+// into an in-memory Cfg.  The CAS values of the replayed entries are
+// those assigned by the CfgMem and not the recorded ones.
+type CfgReplayer struct {
+	cfg     *CfgMem
+	records []*CfgRecord
+	next    int
+}
+
+// NewCfgReplayer returns a CfgReplayer of the records read from rd,
+// which are fed into a new CfgMem.
+func NewCfgReplayer(rd io.Reader) (*CfgReplayer, error) {
+	var records []*CfgRecord
+
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(nil, 64*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record CfgRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return nil, fmt.Errorf("cfg_recorder: line: %d, err: %v",
+				line, err)
+		}
+
+		records = append(records, &record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &CfgReplayer{cfg: NewCfgMem(), records: records}, nil
+}
+
+// NewCfgReplayerFile returns a CfgReplayer of the records in the file
+// at path.
+func NewCfgReplayerFile(path string) (*CfgReplayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewCfgReplayer(f)
+}
+
+// Cfg returns the in-memory Cfg that the records are fed into.
+func (p *CfgReplayer) Cfg() *CfgMem {
+	return p.cfg
+}
+
+// Records returns all the records of the CfgReplayer.
+func (p *CfgReplayer) Records() []*CfgRecord {
+	return p.records
+}
+
+// Next feeds the next record into the Cfg and returns it, or returns
+// nil when all the records have been replayed.
+func (p *CfgReplayer) Next() (*CfgRecord, error) {
+	if p.next >= len(p.records) {
+		return nil, nil
+	}
+
+	record := p.records[p.next]
+	p.next++
+
+	var err error
+	switch record.Op {
+	case "set":
+		_, err = p.cfg.Set(record.Key, record.Val, CFG_CAS_FORCE)
+	case "del":
+		err = p.cfg.Del(record.Key, 0)
+	default:
+		err = fmt.Errorf("cfg_recorder: unknown op: %s", record.Op)
+	}
+
+	return record, err
+}
+
+// ReplayUntil feeds the records into the Cfg up to and including the
+// last record that's not after t, and returns the number of records
+// fed.
+func (p *CfgReplayer) ReplayUntil(t time.Time) (int, error) {
+	n := 0
+	for p.next < len(p.records) && !p.records[p.next].Time.After(t) {
+		_, err := p.Next()
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// ReplayAll feeds all the remaining records into the Cfg.
+func (p *CfgReplayer) ReplayAll() error {
+	for {
+		record, err := p.Next()
+		if err != nil || record == nil {
+			return err
+		}
+	}
+}
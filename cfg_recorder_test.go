@@ -0,0 +1,91 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCfgRecorderReplayer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfg.records")
+
+	cfgMem := NewCfgMem()
+	r, err := NewCfgRecorderFile(cfgMem, path)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	cas, err := r.Set("a", []byte("1"), 0)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if _, err = r.Set("a", []byte("x"), cas+100); err == nil {
+		t.Fatalf("expected a CAS err")
+	}
+	if _, err = r.Set("a", []byte("2"), cas); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if _, err = r.Set("b", []byte("3"), 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = r.Del("b", 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	mid := time.Now()
+
+	// Changes made directly to the underlying cfg, as by other nodes.
+	if err = r.RecordKeys([]string{"c"}); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	cfgMem.Set("c", []byte("4"), 0)
+
+	for i := 0; i < 100; i++ {
+		p, _ := NewCfgReplayerFile(path)
+		if p != nil && len(p.Records()) >= 5 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err = r.Close(); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	p, err := NewCfgReplayerFile(path)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if len(p.Records()) != 5 {
+		t.Fatalf("expected 5 records, got: %d", len(p.Records()))
+	}
+
+	n, err := p.ReplayUntil(mid)
+	if err != nil || n != 4 {
+		t.Fatalf("expected 4 replayed, got: %d, err: %v", n, err)
+	}
+	val, _, _ := p.Cfg().Get("a", 0)
+	if string(val) != "2" {
+		t.Fatalf("expected a = 2, got: %s", val)
+	}
+	if val, _, _ = p.Cfg().Get("b", 0); val != nil {
+		t.Fatalf("expected b deleted, got: %s", val)
+	}
+
+	if err = p.ReplayAll(); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if val, _, _ = p.Cfg().Get("c", 0); string(val) != "4" {
+		t.Fatalf("expected c = 4, got: %s", val)
+	}
+	if record, err := p.Next(); record != nil || err != nil {
+		t.Fatalf("expected the end, got: %+v, err: %v", record, err)
+	}
+}
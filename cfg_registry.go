@@ -16,6 +16,7 @@ const (
 	componentPlanPIndexesCache
 	componentNodeDefsCache
 	componentRebalanceStatus
+	componentFeatureFlags
 )
 
 type cfgSubscription struct {
@@ -52,6 +53,11 @@ var cfgSubscriptions = map[string]*cfgSubscription{
 			componentRebalanceStatus: []string{
 				LAST_REBALANCE_STATUS_KEY,
 			},
+			componentFeatureFlags: []string{
+				FEATURE_FLAGS_KEY,
+				CfgNodeDefsKey(NODE_DEFS_KNOWN),
+				CfgNodeDefsKey(NODE_DEFS_WANTED),
+			},
 		},
 	},
 
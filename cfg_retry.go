@@ -0,0 +1,74 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"math/rand"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// CfgCASRetryStartSleep is the initial backoff of the retries of a
+// CfgRetryOnCAS, which doubles per retry up to CfgCASRetryMaxSleep.
+var CfgCASRetryStartSleep = 5 * time.Millisecond
+
+// CfgCASRetryMaxSleep is the max backoff of the retries of a
+// CfgRetryOnCAS.
+var CfgCASRetryMaxSleep = 500 * time.Millisecond
+
+// CfgRetryOnCAS invokes the task, which is typically a read-modify-write
+// of some Cfg keys, until the task returns something other than a
+// CfgCASError, or for up to maxTries, where a maxTries <= 0 means
+// unlimited tries.  On running out of tries, the task's last
+// CfgCASError is returned.
+//
+// The retries sleep for a random duration of up to an exponentially
+// growing backoff, so that the racing writers, such as the planners of
+// multiple nodes, spread out rather than collide again.  The retries
+// are counted by the "cbgt_cfg_cas_retries_total" metric and the
+// running out of tries by the "cbgt_cfg_cas_retries_exhausted_total"
+// metric, both labeled by the name of the caller, like "planner".
+func CfgRetryOnCAS(name string, maxTries int, task func() error) error {
+	labels := MetricLabels{"name": name}
+
+	backoff := CfgCASRetryStartSleep
+
+	for tries := 1; ; tries++ {
+		err := task()
+		if _, ok := err.(*CfgCASError); !ok {
+			return err
+		}
+
+		if maxTries > 0 && tries >= maxTries {
+			GetMetricsProvider().Counter(
+				"cbgt_cfg_cas_retries_exhausted_total", labels).Add(1)
+
+			log.Warnf("cfg_retry: %s, cas mismatch, gave up after tries: %d",
+				name, tries)
+
+			return err
+		}
+
+		GetMetricsProvider().Counter(
+			"cbgt_cfg_cas_retries_total", labels).Add(1)
+
+		log.Printf("cfg_retry: %s, retrying due to cas mismatch, tries: %d",
+			name, tries)
+
+		if backoff > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(backoff))) + 1)
+		}
+
+		backoff *= 2
+		if backoff > CfgCASRetryMaxSleep {
+			backoff = CfgCASRetryMaxSleep
+		}
+	}
+}
@@ -0,0 +1,116 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCfgRetryOnCAS(t *testing.T) {
+	p := NewJSONMetricsProvider()
+	SetMetricsProvider(p)
+	defer SetMetricsProvider(NewJSONMetricsProvider())
+
+	tries := 0
+	err := CfgRetryOnCAS("test", 5, func() error {
+		tries++
+		if tries < 3 {
+			return &CfgCASError{}
+		}
+		return nil
+	})
+	if err != nil || tries != 3 {
+		t.Errorf("expected success on the 3rd try, got: %d, err: %v",
+			tries, err)
+	}
+
+	tries = 0
+	err = CfgRetryOnCAS("test", 4, func() error {
+		tries++
+		return &CfgCASError{}
+	})
+	if _, ok := err.(*CfgCASError); !ok || tries != 4 {
+		t.Errorf("expected a CAS err after 4 tries, got: %d, err: %v",
+			tries, err)
+	}
+
+	tries = 0
+	err = CfgRetryOnCAS("test", 0, func() error {
+		tries++
+		return ErrNoIndexDefs
+	})
+	if err != ErrNoIndexDefs || tries != 1 {
+		t.Errorf("expected no retries of other errs, got: %d, err: %v",
+			tries, err)
+	}
+
+	err = RetryOnCASMismatch(func() error { return &CfgCASError{} }, 2)
+	if err == nil || !strings.Contains(err.Error(), "too many tries") {
+		t.Errorf("expected too many tries, got: %v", err)
+	}
+
+	var buf bytes.Buffer
+	p.WriteMetrics(&buf)
+
+	var m map[string]interface{}
+	if err = json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("expected json, got: %s, err: %v", buf.String(), err)
+	}
+	if m[`cbgt_cfg_cas_retries_total{name="test"}`] != 5.0 ||
+		m[`cbgt_cfg_cas_retries_exhausted_total{name="test"}`] != 1.0 ||
+		m[`cbgt_cfg_cas_retries_exhausted_total{name="misc"}`] != 1.0 {
+		t.Errorf("unexpected metrics: %s", buf.String())
+	}
+}
+
+// A cfgPlanConflicter fails the first Set() of the plan with a CAS
+// err, as if a concurrent planner won.
+type cfgPlanConflicter struct {
+	Cfg
+	conflicts int
+}
+
+func (c *cfgPlanConflicter) Set(key string, val []byte, cas uint64) (
+	uint64, error) {
+	if key == PLAN_PINDEXES_KEY && c.conflicts == 0 {
+		c.conflicts++
+		return 0, &CfgCASError{}
+	}
+	return c.Cfg.Set(key, val, cas)
+}
+
+func TestPlanRetriesOnCAS(t *testing.T) {
+	cfgMem := NewCfgMem()
+
+	indexDefs, nodeDefs := stickinessTestDefs("4")
+	if _, err := CfgSetIndexDefs(cfgMem, indexDefs, 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if _, err := CfgSetNodeDefs(cfgMem, NODE_DEFS_WANTED,
+		nodeDefs, 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	cfg := &cfgPlanConflicter{Cfg: cfgMem}
+
+	changed, err := Plan(cfg, VERSION, "", "", nil, nil)
+	if err != nil || !changed || cfg.conflicts != 1 {
+		t.Fatalf("expected a changed plan after a retry, got: %v,"+
+			" conflicts: %d, err: %v", changed, cfg.conflicts, err)
+	}
+
+	planPIndexes, _, err := CfgGetPlanPIndexes(cfgMem)
+	if err != nil || planPIndexes == nil ||
+		len(planPIndexes.PlanPIndexes) != 4 {
+		t.Errorf("expected a saved plan, got: %#v, err: %v", planPIndexes, err)
+	}
+}
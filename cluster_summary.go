@@ -0,0 +1,250 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"strconv"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// The cluster summary is a compact, materialized document in the Cfg,
+// so that lightweight consumers such as UIs and CLIs can learn the
+// shape of the cluster with a single Get, instead of reassembling it
+// from the index definitions, node definitions and plan.  It's
+// refreshed periodically by the planner nodes, and after the rebalance
+// status checkpoints.
+
+// CLUSTER_SUMMARY_KEY is the Cfg key of the cluster summary.
+const CLUSTER_SUMMARY_KEY = "clusterSummary"
+
+// CLUSTER_SUMMARY_REFRESH_OPTION is the manager option that holds the
+// number of seconds between the refreshes of the cluster summary,
+// where a negative value disables the periodic refreshes.
+const CLUSTER_SUMMARY_REFRESH_OPTION = "clusterSummaryRefreshIntervalSecs"
+
+// ClusterSummaryRefreshInterval is the default time between the
+// refreshes of the cluster summary.
+var ClusterSummaryRefreshInterval = 30 * time.Second
+
+// The values of ClusterSummary.LastRebalanceStatus.
+var clusterSummaryRebalanceStatuses = map[LastRebalanceStatus]string{
+	RebNoRecord:  "none",
+	RebStarted:   "started",
+	RebCompleted: "completed",
+}
+
+// ClusterSummary is the Cfg value of the cluster summary.
+type ClusterSummary struct {
+	UUID      string    `json:"uuid"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	IndexCount     int `json:"indexCount"`
+	NodeCount      int `json:"nodeCount"`
+	PartitionCount int `json:"partitionCount"`
+
+	// NodePartitionCounts are the number of pindexes, including the
+	// replicas, that are planned onto each wanted node, keyed by node
+	// UUID.
+	NodePartitionCounts map[string]int `json:"nodePartitionCounts"`
+
+	// LastRebalanceStatus is "none", "started" or "completed", and
+	// LastRebalanceTime is when the status was first seen.
+	LastRebalanceStatus string    `json:"lastRebalanceStatus"`
+	LastRebalanceTime   time.Time `json:"lastRebalanceTime,omitempty"`
+}
+
+// CfgGetClusterSummary retrieves the cluster summary from a Cfg
+// provider, which is nil when it hasn't been refreshed yet.
+func CfgGetClusterSummary(cfg Cfg) (*ClusterSummary, uint64, error) {
+	v, cas, err := cfg.Get(CLUSTER_SUMMARY_KEY, 0)
+	if err != nil {
+		return nil, cas, err
+	}
+	if v == nil {
+		return nil, cas, nil
+	}
+	rv := &ClusterSummary{}
+	err = UnmarshalJSON(v, rv)
+	if err != nil {
+		return nil, cas, err
+	}
+	return rv, cas, nil
+}
+
+// CfgSetClusterSummary updates the cluster summary on a Cfg provider.
+func CfgSetClusterSummary(cfg Cfg, summary *ClusterSummary,
+	cas uint64) (uint64, error) {
+	buf, err := MarshalJSON(summary)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Set(CLUSTER_SUMMARY_KEY, buf, cas)
+}
+
+// CalcClusterSummary returns the cluster summary of the given defs and
+// plan, where the prev summary, which may be nil, provides the time of
+// the last rebalance status.  The UUID and UpdatedAt are left empty.
+func CalcClusterSummary(indexDefs *IndexDefs, nodeDefs *NodeDefs,
+	planPIndexes *PlanPIndexes, rebStatus LastRebalanceStatus,
+	prev *ClusterSummary, now time.Time) *ClusterSummary {
+	rv := &ClusterSummary{
+		NodePartitionCounts: map[string]int{},
+		LastRebalanceStatus: clusterSummaryRebalanceStatuses[rebStatus],
+	}
+
+	if indexDefs != nil {
+		rv.IndexCount = len(indexDefs.IndexDefs)
+	}
+
+	if nodeDefs != nil {
+		rv.NodeCount = len(nodeDefs.NodeDefs)
+		for nodeUUID := range nodeDefs.NodeDefs {
+			rv.NodePartitionCounts[nodeUUID] = 0
+		}
+	}
+
+	if planPIndexes != nil {
+		rv.PartitionCount = len(planPIndexes.PlanPIndexes)
+		for _, planPIndex := range planPIndexes.PlanPIndexes {
+			for nodeUUID := range planPIndex.Nodes {
+				rv.NodePartitionCounts[nodeUUID]++
+			}
+		}
+	}
+
+	if prev != nil && prev.LastRebalanceStatus == rv.LastRebalanceStatus {
+		rv.LastRebalanceTime = prev.LastRebalanceTime
+	} else if rebStatus != RebNoRecord {
+		rv.LastRebalanceTime = now
+	}
+
+	return rv
+}
+
+// sameClusterSummary returns true if both summaries are the same,
+// ignoring their UUID and UpdatedAt.
+func sameClusterSummary(a, b *ClusterSummary) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	// The times are compared with Equal, as their JSON round trip drops
+	// their monotonic clock readings.
+	if !a.LastRebalanceTime.Equal(b.LastRebalanceTime) {
+		return false
+	}
+
+	ac, bc := *a, *b
+	for _, s := range []*ClusterSummary{&ac, &bc} {
+		s.UUID = ""
+		s.UpdatedAt = time.Time{}
+		s.LastRebalanceTime = time.Time{}
+	}
+
+	return reflect.DeepEqual(ac, bc)
+}
+
+// CfgRefreshClusterSummary recalculates the cluster summary from the
+// Cfg and updates it, unless it's unchanged.
+func CfgRefreshClusterSummary(cfg Cfg) (*ClusterSummary, error) {
+	var rv *ClusterSummary
+
+	err := RetryOnCASMismatch(func() error {
+		prev, cas, err := CfgGetClusterSummary(cfg)
+		if err != nil {
+			return err
+		}
+
+		indexDefs, _, err := CfgGetIndexDefs(cfg)
+		if err != nil {
+			return err
+		}
+
+		nodeDefs, _, err := CfgGetNodeDefs(cfg, NODE_DEFS_WANTED)
+		if err != nil {
+			return err
+		}
+
+		planPIndexes, _, err := CfgGetPlanPIndexes(cfg)
+		if err != nil {
+			return err
+		}
+
+		rebStatus, _, err := CfgGetLastRebalanceStatus(cfg)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		rv = CalcClusterSummary(indexDefs, nodeDefs, planPIndexes,
+			rebStatus, prev, now)
+		if sameClusterSummary(prev, rv) {
+			rv = prev
+			return nil
+		}
+
+		rv.UUID = NewUUID()
+		rv.UpdatedAt = now
+
+		_, err = CfgSetClusterSummary(cfg, rv, cas)
+		return err
+	}, 100)
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// clusterSummaryRefreshInterval returns the time between the refreshes
+// of the cluster summary, which is negative when they're disabled.
+func (mgr *Manager) clusterSummaryRefreshInterval() time.Duration {
+	v := mgr.GetOption(CLUSTER_SUMMARY_REFRESH_OPTION)
+	if v == "" {
+		return ClusterSummaryRefreshInterval
+	}
+
+	secs, err := strconv.Atoi(v)
+	if err != nil {
+		log.Warnf("cluster_summary: option: %s, err: %v",
+			CLUSTER_SUMMARY_REFRESH_OPTION, err)
+		return ClusterSummaryRefreshInterval
+	}
+	if secs == 0 {
+		return ClusterSummaryRefreshInterval
+	}
+
+	return time.Duration(secs) * time.Second
+}
+
+// ClusterSummaryLoop periodically refreshes the cluster summary.
+func (mgr *Manager) ClusterSummaryLoop() {
+	for {
+		interval := mgr.clusterSummaryRefreshInterval()
+		if interval > 0 && mgr.cfg != nil {
+			_, err := CfgRefreshClusterSummary(mgr.cfg)
+			if err != nil {
+				log.Warnf("cluster_summary: refresh, err: %v", err)
+			}
+		} else {
+			// Check again later whether the refreshes were enabled.
+			interval = ClusterSummaryRefreshInterval
+		}
+
+		select {
+		case <-mgr.stopCh:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,80 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCfgRefreshClusterSummary(t *testing.T) {
+	cfg := NewCfgMem()
+
+	s, err := CfgRefreshClusterSummary(cfg)
+	if err != nil || s == nil {
+		t.Fatalf("expected a summary, got: %+v, err: %v", s, err)
+	}
+	if s.IndexCount != 0 || s.LastRebalanceStatus != "none" ||
+		!s.LastRebalanceTime.IsZero() {
+		t.Fatalf("unexpected empty summary: %+v", s)
+	}
+
+	indexDefs := NewIndexDefs(VERSION)
+	indexDefs.IndexDefs["i0"] = &IndexDef{Name: "i0"}
+	indexDefs.IndexDefs["i1"] = &IndexDef{Name: "i1"}
+	CfgSetIndexDefs(cfg, indexDefs, CFG_CAS_FORCE)
+
+	nodeDefs := NewNodeDefs(VERSION)
+	nodeDefs.NodeDefs["a"] = &NodeDef{UUID: "a"}
+	nodeDefs.NodeDefs["b"] = &NodeDef{UUID: "b"}
+	nodeDefs.NodeDefs["c"] = &NodeDef{UUID: "c"}
+	CfgSetNodeDefs(cfg, NODE_DEFS_WANTED, nodeDefs, CFG_CAS_FORCE)
+
+	planPIndexes := NewPlanPIndexes(VERSION)
+	planPIndexes.PlanPIndexes["p0"] = &PlanPIndex{Name: "p0", IndexName: "i0",
+		Nodes: map[string]*PlanPIndexNode{"a": {}, "b": {}}}
+	planPIndexes.PlanPIndexes["p1"] = &PlanPIndex{Name: "p1", IndexName: "i1",
+		Nodes: map[string]*PlanPIndexNode{"a": {}}}
+	CfgSetPlanPIndexes(cfg, planPIndexes, CFG_CAS_FORCE)
+
+	CfgSetLastRebalanceStatus(cfg, RebStarted, CFG_CAS_FORCE)
+
+	s, err = CfgRefreshClusterSummary(cfg)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if s.IndexCount != 2 || s.NodeCount != 3 || s.PartitionCount != 2 ||
+		!reflect.DeepEqual(s.NodePartitionCounts,
+			map[string]int{"a": 2, "b": 1, "c": 0}) ||
+		s.LastRebalanceStatus != "started" || s.LastRebalanceTime.IsZero() {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+
+	// An unchanged cluster leaves the summary in the Cfg as is.
+	_, cas, _ := CfgGetClusterSummary(cfg)
+	s2, err := CfgRefreshClusterSummary(cfg)
+	if err != nil || s2.UUID != s.UUID {
+		t.Fatalf("expected an unchanged summary, got: %+v, err: %v", s2, err)
+	}
+	if _, cas2, _ := CfgGetClusterSummary(cfg); cas2 != cas {
+		t.Fatalf("expected no write of an unchanged summary")
+	}
+
+	CfgSetLastRebalanceStatus(cfg, RebCompleted, CFG_CAS_FORCE)
+	s2, err = CfgRefreshClusterSummary(cfg)
+	if err != nil || s2.LastRebalanceStatus != "completed" ||
+		s2.LastRebalanceTime.Before(s.LastRebalanceTime) {
+		t.Fatalf("unexpected summary: %+v, err: %v", s2, err)
+	}
+
+	s3, _, err := CfgGetClusterSummary(cfg)
+	if err != nil || s3.UUID != s2.UUID {
+		t.Fatalf("expected the summary in the cfg, got: %+v, err: %v", s3, err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/couchbase/cbgt"
@@ -45,15 +46,88 @@ func MainCfgEx(baseName, connect, bindHttp,
 	case strings.HasPrefix(connect, "couchbase:"):
 		cfg, err = MainCfgCB(baseName, connect[len("couchbase:"):],
 			bindHttp, register, dataDir)
+	case strings.HasPrefix(connect, "consul:"):
+		cfg, err = MainCfgConsul(baseName, connect[len("consul:"):],
+			bindHttp, register, dataDir)
 	case strings.HasPrefix(connect, "metakv"):
 		cfg, err = MainCfgMetaKv(baseName, connect[len("metakv"):],
 			bindHttp, register, dataDir, uuid, options)
 	default:
 		err = fmt.Errorf("main_cfg1: unsupported cfg connect: %s", connect)
 	}
+	if err == nil && options["cfgCompression"] == "true" {
+		compressOptions := map[string]string{}
+		for option, compressOption := range map[string]string{
+			"cfgCompressThreshold": "compressThreshold",
+			"cfgChunkSize":         "chunkSize",
+		} {
+			if v, exists := options[option]; exists {
+				compressOptions[compressOption] = v
+			}
+		}
+		cfg, err = cbgt.NewCfgCompressed(cfg, compressOptions)
+	}
+	if err == nil && options["cfgCache"] == "true" {
+		cfg, err = cbgt.NewCfgCache(cfg, nil)
+	}
+	if err == nil && options["cfgHistory"] == "true" {
+		historyPath := ""
+		if dataDir != "" {
+			historyPath = dataDir + string(os.PathSeparator) +
+				baseName + ".cfgHistory"
+		}
+		cfg, err = MainCfgHistory(cfg, uuid, historyPath, options)
+	}
 	return cfg, err
 }
 
+// MainCfgHistory wraps the cfg with a cbgt.CfgHistory, which keeps
+// the mutations of the cluster's definitions and plan so that they can
+// be rolled back, per the optional "cfgHistoryMaxEntries" and
+// "cfgHistoryMaxValBytes" options.  The entries are persisted at the
+// path, unless it's "".
+func MainCfgHistory(cfg cbgt.Cfg, uuid, path string,
+	options map[string]string) (cbgt.Cfg, error) {
+	maxEntries := 0
+	if v, exists := options["cfgHistoryMaxEntries"]; exists {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("main_cfg: invalid cfgHistoryMaxEntries: %s", v)
+		}
+		maxEntries = n
+	}
+
+	h := cbgt.NewCfgHistory(cfg, uuid, maxEntries)
+
+	if v, exists := options["cfgHistoryMaxValBytes"]; exists {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("main_cfg: invalid cfgHistoryMaxValBytes: %s", v)
+		}
+		h.SetMaxValBytes(n)
+	}
+
+	if path != "" {
+		err := h.Persist(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := h.TrackKeys([]string{
+		cbgt.INDEX_DEFS_KEY,
+		cbgt.PLAN_PINDEXES_KEY,
+		cbgt.CfgNodeDefsKey(cbgt.NODE_DEFS_WANTED),
+		cbgt.CfgNodeDefsKey(cbgt.NODE_DEFS_KNOWN),
+	})
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	return h.WithVersionReader(), nil
+}
+
 // ------------------------------------------------
 
 func MainCfgSimple(baseName, connect, bindHttp, register, dataDir string) (
@@ -104,6 +178,37 @@ func MainCfgCB(baseName, urlStr, bindHttp, register, dataDir string) (
 	return cfg, nil
 }
 
+// MainCfgConsul connects to the Consul KV of the agent at the urlStr,
+// like "http://127.0.0.1:8500/cbgt/", where the url path is the prefix
+// of the Consul keys and an optional "token" query param is the ACL
+// token.
+func MainCfgConsul(baseName, urlStr, bindHttp, register, dataDir string) (
+	cbgt.Cfg, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	options := map[string]string{
+		"keyPrefix": strings.TrimPrefix(u.Path, "/"),
+	}
+	if token := u.Query().Get("token"); token != "" {
+		options["token"] = token
+	}
+
+	cfg, err := cbgt.NewCfgConsul(urlStr, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
 func MainCfgMetaKv(baseName, urlStr, bindHttp, register, dataDir, uuid string,
 	options map[string]string) (
 	cbgt.Cfg, error) {
@@ -53,6 +53,18 @@ func TestMainCfg(t *testing.T) {
 		t.Errorf("expected err on bad server")
 	}
 
+	cfg, err = MainCfg("cbgt", "consul:not-a-url",
+		bindHttp, register, emptyDir)
+	if err == nil || cfg != nil {
+		t.Errorf("expected err on bad consul url")
+	}
+
+	cfg, err = MainCfg("cbgt", "consul:http://127.0.0.1:666/cbgt/",
+		bindHttp, register, emptyDir)
+	if err == nil || cfg != nil {
+		t.Errorf("expected err on bad consul server")
+	}
+
 	if false { // metakv skipped due to log spam.
 		cfg, err = MainCfg("cbgt", "metakv",
 			bindHttp, register, emptyDir)
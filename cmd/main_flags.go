@@ -38,6 +38,21 @@ func MainCommon(version string, flagAliases map[string][]string) {
 	LogFlags(flagAliases)
 }
 
+// MainStart starts the manager, see cbgt.Manager.Start(), and then
+// shuts it down gracefully on a SIGTERM or an interrupt, see
+// ShutdownOnSignalForPlatform(), which an application that doesn't use
+// MainStart needs to start itself.
+func MainStart(mgr *cbgt.Manager, register string) error {
+	err := mgr.Start(register)
+	if err != nil {
+		return err
+	}
+
+	go ShutdownOnSignalForPlatform(mgr)
+
+	return nil
+}
+
 func LogFlags(flagAliases map[string][]string) {
 	flag.VisitAll(func(f *flag.Flag) {
 		if flagAliases[f.Name] != nil {
@@ -0,0 +1,76 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/couchbase/clog"
+
+	"github.com/couchbase/cbgt"
+)
+
+// ShutdownExit is invoked once a shutdown on signal is done, and is
+// overridable for unit testing.
+var ShutdownExit = os.Exit
+
+// ShutdownOnSignalForPlatform gracefully shuts down the manager on a
+// SIGTERM or an interrupt; see ShutdownOnSignal().  It's started by
+// MainStart(), so an application that starts its manager in another
+// way needs to start it itself, as in:
+//
+//	go cmd.ShutdownOnSignalForPlatform(mgr)
+//
+// An application that handles the signals itself can instead use
+// ShutdownOnContext().
+func ShutdownOnSignalForPlatform(mgr *cbgt.Manager) {
+	ShutdownOnSignal(mgr, syscall.SIGTERM, os.Interrupt)
+}
+
+// ShutdownOnSignal waits for any of the signals, and then gracefully
+// shuts down the manager, see ShutdownOnContext(), before exiting the
+// process.  The exit code is non-zero if the deadline was exceeded.
+func ShutdownOnSignal(mgr *cbgt.Manager, signals ...os.Signal) {
+	ctx, stop := signal.NotifyContext(context.Background(), signals...)
+	defer stop()
+
+	err := ShutdownOnContext(ctx, mgr)
+	if err != nil {
+		ShutdownExit(1)
+		return
+	}
+
+	ShutdownExit(0)
+}
+
+// ShutdownOnContext waits until the ctx is done, and then closes all
+// the manager's feeds and pindexes within the manager's shutdown
+// deadline (see the "shutdownDeadlineSecs" manager option), returning
+// an error if the deadline was exceeded.  Unlike ShutdownOnSignal(),
+// it leaves the exiting of the process to the caller.
+func ShutdownOnContext(ctx context.Context, mgr *cbgt.Manager) error {
+	<-ctx.Done()
+
+	deadline := mgr.ShutdownDeadline()
+
+	log.Printf("shutdown: %v, closing pindexes and feeds, deadline: %v",
+		context.Cause(ctx), deadline)
+
+	err := mgr.Shutdown(deadline)
+	if err != nil {
+		log.Errorf("shutdown: err: %v", err)
+		return err
+	}
+
+	log.Printf("shutdown: done")
+	return nil
+}
@@ -0,0 +1,78 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbgt"
+)
+
+func TestShutdownOnContext(t *testing.T) {
+	emptyDir, _ := os.MkdirTemp("./tmp", "test")
+	defer os.RemoveAll(emptyDir)
+
+	mgr := cbgt.NewManager(cbgt.VERSION, cbgt.NewCfgMem(), cbgt.NewUUID(),
+		nil, "", 1, "", "", emptyDir, "", nil)
+	err := mgr.Start("wanted")
+	if err != nil {
+		t.Fatalf("expected Start() to work, err: %v", err)
+	}
+
+	err = mgr.CreateIndex("primary", "default", "123", "",
+		"blackhole", "foo", "", cbgt.PlanParams{}, "")
+	if err != nil {
+		t.Fatalf("expected CreateIndex() to work, err: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		mgr.PlannerNOOP("test")
+		mgr.JanitorNOOP("test")
+		feeds, pindexes := mgr.CurrentMaps()
+		if len(feeds) > 0 && len(pindexes) > 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	feeds, pindexes := mgr.CurrentMaps()
+	if len(feeds) == 0 || len(pindexes) == 0 {
+		t.Fatalf("expected feeds and pindexes")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	doneCh := make(chan error, 1)
+	go func() { doneCh <- ShutdownOnContext(ctx, mgr) }()
+
+	select {
+	case err = <-doneCh:
+		t.Fatalf("expected no shutdown before the cancel, err: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+
+	select {
+	case err = <-doneCh:
+		if err != nil {
+			t.Fatalf("expected the shutdown to work, err: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the shutdown to finish")
+	}
+
+	feeds, pindexes = mgr.CurrentMaps()
+	if len(feeds) != 0 || len(pindexes) != 0 {
+		t.Fatalf("expected the feeds and pindexes drained, got: %d, %d",
+			len(feeds), len(pindexes))
+	}
+}
@@ -0,0 +1,142 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/couchbase/cbauth/service"
+	log "github.com/couchbase/clog"
+)
+
+// CtlAuditEventIDs are the audit event IDs of the audited CtlMgr
+// operations, keyed by operation name.  An application overrides them
+// to match the event descriptors that it registers with the audit
+// daemon.
+var CtlAuditEventIDs = map[string]uint32{
+	"PrepareTopologyChange": 0x6100,
+	"StartTopologyChange":   0x6101,
+	"CancelTask":            0x6102,
+	"PreparePause":          0x6103,
+	"Pause":                 0x6104,
+	"PrepareResume":         0x6105,
+	"Resume":                0x6106,
+	"CancelPartitionMove":   0x6107,
+}
+
+// CtlAuditUser is the real_userid of the audit records, as the
+// service.Manager operations are only invoked by the cluster manager.
+var CtlAuditUser = CtlAuditUserID{Domain: "internal", User: "@ns_server"}
+
+// The outcomes of the audited operations.
+const (
+	CtlAuditOutcomeSuccess = "success"
+	CtlAuditOutcomeFailure = "failure"
+)
+
+// CtlAuditUserID is the identity of who invoked an audited operation.
+type CtlAuditUserID struct {
+	Domain string `json:"domain"`
+	User   string `json:"user"`
+}
+
+// A CtlAuditRecord is the audit record of a CtlMgr operation, in the
+// format of the records of the Couchbase audit daemon.
+type CtlAuditRecord struct {
+	ID          uint32         `json:"id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Timestamp   string         `json:"timestamp"`
+	RealUserID  CtlAuditUserID `json:"real_userid"`
+	NodeUUID    string         `json:"node_uuid,omitempty"`
+	Parameters  interface{}    `json:"parameters,omitempty"`
+	Outcome     string         `json:"outcome"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// CtlAuditTimestampFormat is the audit daemon's timestamp format.
+const CtlAuditTimestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// A CtlAuditSink receives the audit records of the CtlMgr operations,
+// such as to forward them to the audit daemon.
+type CtlAuditSink interface {
+	Audit(rec *CtlAuditRecord) error
+}
+
+// CtlAuditLogSink is the default CtlAuditSink, which logs the audit
+// records as JSON.
+type CtlAuditLogSink struct{}
+
+func (CtlAuditLogSink) Audit(rec *CtlAuditRecord) error {
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("ctl/audit: %s", buf)
+
+	return nil
+}
+
+// audit emits the audit record of a CtlMgr operation to the audit
+// sink of the CtlOptions.
+func (m *CtlMgr) audit(op, description string,
+	params interface{}, err error) {
+	sink := m.ctl.optionsCtl.AuditSink
+	if sink == nil {
+		sink = CtlAuditLogSink{}
+	}
+
+	rec := &CtlAuditRecord{
+		ID:          CtlAuditEventIDs[op],
+		Name:        op,
+		Description: description,
+		Timestamp:   time.Now().Format(CtlAuditTimestampFormat),
+		RealUserID:  CtlAuditUser,
+		Parameters:  params,
+		Outcome:     CtlAuditOutcomeSuccess,
+	}
+
+	if m.nodeInfo != nil {
+		rec.NodeUUID = string(m.nodeInfo.NodeID)
+	}
+
+	if err != nil {
+		rec.Outcome = CtlAuditOutcomeFailure
+		rec.Error = err.Error()
+	}
+
+	if err = sink.Audit(rec); err != nil {
+		log.Warnf("ctl/audit: op: %s, err: %v", op, err)
+	}
+}
+
+// auditTopologyChangeParams returns the audited parameters of a
+// topology change, which omit the node details other than their IDs.
+func auditTopologyChangeParams(
+	change service.TopologyChange) map[string]interface{} {
+	keepNodes := make([]string, 0, len(change.KeepNodes))
+	for _, node := range change.KeepNodes {
+		keepNodes = append(keepNodes, string(node.NodeInfo.NodeID))
+	}
+
+	ejectNodes := make([]string, 0, len(change.EjectNodes))
+	for _, node := range change.EjectNodes {
+		ejectNodes = append(ejectNodes, string(node.NodeID))
+	}
+
+	return map[string]interface{}{
+		"id":                 change.ID,
+		"currentTopologyRev": string(change.CurrentTopologyRev),
+		"type":               string(change.Type),
+		"keepNodes":          keepNodes,
+		"ejectNodes":         ejectNodes,
+	}
+}
@@ -0,0 +1,247 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/cbauth/service"
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/hibernate"
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+// testAuditSink is a CtlAuditSink that records the audit records.
+type testAuditSink struct {
+	m    sync.Mutex
+	recs []*CtlAuditRecord
+}
+
+func (s *testAuditSink) Audit(rec *CtlAuditRecord) error {
+	s.m.Lock()
+	s.recs = append(s.recs, rec)
+	s.m.Unlock()
+	return nil
+}
+
+// take returns and clears the recorded audit records.
+func (s *testAuditSink) take() []*CtlAuditRecord {
+	s.m.Lock()
+	defer s.m.Unlock()
+	rv := s.recs
+	s.recs = nil
+	return rv
+}
+
+func TestAudit(t *testing.T) {
+	hibernated := cbgt.NewIndexDefs(cbgt.VERSION)
+	hibernated.IndexDefs["i0"] = &cbgt.IndexDef{
+		Name: "i0", Type: "blackhole", SourceName: "b0",
+	}
+	metadata, _ := cbgt.MarshalJSON(hibernated)
+
+	clientErr := error(nil)
+
+	prevClientHook := cbgt.HibernationClientHook
+	cbgt.HibernationClientHook = func(string) (objcli.Client, error) {
+		if clientErr != nil {
+			return nil, clientErr
+		}
+		return objcli.NewTestClient(t, objval.ProviderAWS), nil
+	}
+	prevPathHook := hibernate.GetRemoteBucketAndPathHook
+	hibernate.GetRemoteBucketAndPathHook = func(remotePath string) (
+		string, string, error) {
+		return "bkt", "r0", nil
+	}
+	prevDownloadHook := hibernate.DownloadMetadataHook
+	hibernate.DownloadMetadataHook = func(client objcli.Client,
+		ctx context.Context, bucket, key string) ([]byte, error) {
+		return metadata, nil
+	}
+	defer func() {
+		cbgt.HibernationClientHook = prevClientHook
+		hibernate.GetRemoteBucketAndPathHook = prevPathHook
+		hibernate.DownloadMetadataHook = prevDownloadHook
+	}()
+
+	m := testPrepareCtlMgr(t)
+	m.nodeInfo = &service.NodeInfo{NodeID: "n0"}
+
+	sink := &testAuditSink{}
+	m.ctl.optionsCtl.AuditSink = sink
+
+	_, err := cbgt.CfgSetNodeDefs(m.ctl.cfg, cbgt.NODE_DEFS_WANTED,
+		cbgt.NewNodeDefs(cbgt.VERSION), cbgt.CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected CfgSetNodeDefs to work, err: %v", err)
+	}
+
+	change := service.TopologyChange{
+		ID:   "c0",
+		Type: service.TopologyChangeTypeRebalance,
+		EjectNodes: []service.NodeInfo{
+			{NodeID: "n1", Priority: 1, Opaque: "secret"},
+		},
+	}
+
+	expectRec := func(op, outcome string) *CtlAuditRecord {
+		recs := sink.take()
+		if len(recs) != 1 {
+			t.Fatalf("op: %s, expected 1 audit record, got: %d", op, len(recs))
+		}
+		rec := recs[0]
+		if rec.Name != op || rec.ID != CtlAuditEventIDs[op] ||
+			rec.Outcome != outcome || rec.NodeUUID != "n0" ||
+			rec.RealUserID != CtlAuditUser || rec.Timestamp == "" {
+			t.Fatalf("op: %s, outcome: %s, unexpected record: %+v",
+				op, outcome, rec)
+		}
+		if (outcome == CtlAuditOutcomeFailure) != (rec.Error != "") {
+			t.Fatalf("op: %s, unexpected record error: %q", op, rec.Error)
+		}
+		return rec
+	}
+
+	// PrepareTopologyChange.
+	if err = m.PrepareTopologyChange(change); err != nil {
+		t.Fatalf("expected PrepareTopologyChange to work, err: %v", err)
+	}
+	rec := expectRec("PrepareTopologyChange", CtlAuditOutcomeSuccess)
+	if !reflect.DeepEqual(rec.Parameters, auditTopologyChangeParams(change)) {
+		t.Errorf("unexpected parameters: %+v", rec.Parameters)
+	}
+
+	if err = m.PrepareTopologyChange(change); err == nil {
+		t.Fatalf("expected a conflicting PrepareTopologyChange")
+	}
+	expectRec("PrepareTopologyChange", CtlAuditOutcomeFailure)
+
+	// StartTopologyChange.
+	badRev := change
+	badRev.CurrentTopologyRev = service.Revision("not-the-rev")
+	if err = m.StartTopologyChange(badRev); err == nil {
+		t.Fatalf("expected a StartTopologyChange rev conflict")
+	}
+	expectRec("StartTopologyChange", CtlAuditOutcomeFailure)
+
+	// CancelTask.
+	if err = m.CancelTask("prepare:c0", nil); err != nil {
+		t.Fatalf("expected CancelTask to work, err: %v", err)
+	}
+	expectRec("CancelTask", CtlAuditOutcomeSuccess)
+
+	if err = m.CancelTask("prepare:c0", nil); err == nil {
+		t.Fatalf("expected CancelTask of a missing task to fail")
+	}
+	expectRec("CancelTask", CtlAuditOutcomeFailure)
+
+	// PreparePause and Pause.
+	clientErr = fmt.Errorf("no object store")
+	if err = m.PreparePause(service.PauseParams{ID: "p0",
+		Bucket: "b0"}); err == nil {
+		t.Fatalf("expected PreparePause to fail")
+	}
+	expectRec("PreparePause", CtlAuditOutcomeFailure)
+	clientErr = nil
+
+	if err = m.PreparePause(service.PauseParams{ID: "p1",
+		Bucket: "b0"}); err != nil {
+		t.Fatalf("expected PreparePause to work, err: %v", err)
+	}
+	expectRec("PreparePause", CtlAuditOutcomeSuccess)
+
+	if err = m.waitForHibernationPrepare(); err != nil {
+		t.Fatalf("expected the pause prepare to work, err: %v", err)
+	}
+	if err = m.CancelTask("prepare:p1", nil); err != nil {
+		t.Fatalf("expected CancelTask to work, err: %v", err)
+	}
+	expectRec("CancelTask", CtlAuditOutcomeSuccess)
+
+	// PrepareResume and Resume.
+	resumeParams := service.ResumeParams{ID: "r0", Bucket: "b0",
+		RemotePath: "s3://bkt/r0"}
+	if err = m.PrepareResume(resumeParams); err != nil {
+		t.Fatalf("expected PrepareResume to work, err: %v", err)
+	}
+	expectRec("PrepareResume", CtlAuditOutcomeSuccess)
+
+	if err = m.Resume(resumeParams); err != nil {
+		t.Fatalf("expected Resume to work, err: %v", err)
+	}
+	m.ctl.m.Lock()
+	doneCh := m.ctl.ctlDoneCh
+	m.ctl.m.Unlock()
+	defer func() {
+		// Wait for the resume to stop before the hooks are restored.
+		m.ctl.StopHibernationTask()
+		if doneCh != nil {
+			<-doneCh
+		}
+	}()
+	expectRec("Resume", CtlAuditOutcomeSuccess)
+
+	if err = m.PrepareResume(resumeParams); err == nil {
+		t.Fatalf("expected a conflicting PrepareResume")
+	}
+	expectRec("PrepareResume", CtlAuditOutcomeFailure)
+
+	// A running resume conflicts with a pause.
+	if err = m.Pause(service.PauseParams{ID: "p2", Bucket: "b0"}); err == nil {
+		t.Fatalf("expected a conflicting Pause")
+	}
+	expectRec("Pause", CtlAuditOutcomeFailure)
+
+	if err = m.Resume(resumeParams); err == nil {
+		t.Fatalf("expected a conflicting Resume")
+	}
+	expectRec("Resume", CtlAuditOutcomeFailure)
+}
+
+func TestCtlAuditLogSink(t *testing.T) {
+	rec := &CtlAuditRecord{
+		ID:         CtlAuditEventIDs["CancelTask"],
+		Name:       "CancelTask",
+		RealUserID: CtlAuditUser,
+		Parameters: map[string]interface{}{"taskId": "t0"},
+		Outcome:    CtlAuditOutcomeFailure,
+		Error:      "not found",
+	}
+
+	if err := (CtlAuditLogSink{}).Audit(rec); err != nil {
+		t.Fatalf("expected the log sink to work, err: %v", err)
+	}
+
+	// The records are in the audit daemon's field names.
+	buf, _ := json.Marshal(rec)
+	var fields map[string]interface{}
+	json.Unmarshal(buf, &fields)
+	for _, k := range []string{"id", "name", "real_userid", "parameters",
+		"outcome", "error"} {
+		if _, exists := fields[k]; !exists {
+			t.Errorf("expected field: %s, got: %s", k, buf)
+		}
+	}
+	if _, exists := fields["node_uuid"]; exists {
+		t.Errorf("expected no empty node_uuid, got: %s", buf)
+	}
+
+	// An unmarshalable record is an error.
+	rec.Parameters = make(chan int)
+	if err := (CtlAuditLogSink{}).Audit(rec); err == nil {
+		t.Fatalf("expected the log sink to fail")
+	}
+}
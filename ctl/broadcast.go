@@ -0,0 +1,160 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"sync"
+	"time"
+
+	"github.com/couchbase/cbauth/service"
+)
+
+// CtlMgrMinWakeupInterval is the minimum time between the responses
+// to a long-poll waiter, so that a burst of changes, such as progress
+// updates, is coalesced into a single response, where 0 disables the
+// rate limit.
+var CtlMgrMinWakeupInterval = 250 * time.Millisecond
+
+// revBroadcastHistory is the number of recent revisions whose publish
+// times and snapshots are remembered, for the rate limit of the waiters
+// and the diffs against recent revisions.
+const revBroadcastHistory = 16
+
+// A revPublish is a recent publish of a revBroadcast.
+type revPublish struct {
+	at       time.Time
+	snapshot interface{}
+}
+
+// A revBroadcast serves many long-poll waiters of a revision cheaply.
+// A change wakes all the waiters with the single close of a shared
+// channel, and the waiters share the snapshot that was published with
+// the change, rather than each of them rebuilding it under a contended
+// lock.  A waiter is woken no sooner than the min interval after its
+// revision was published, which bounds the wakeup rate of each
+// long-poll connection, as its revision is the one that it was last
+// served.
+type revBroadcast struct {
+	m sync.Mutex // Protects the fields that follow.
+
+	revNum   uint64
+	snapshot interface{}   // Immutable, at the revNum.
+	waitCh   chan struct{} // Closed on the next publish.
+
+	recent map[uint64]revPublish // Keyed by recent revNum.
+}
+
+func newRevBroadcast(revNum uint64, snapshot interface{}) *revBroadcast {
+	return &revBroadcast{
+		revNum:   revNum,
+		snapshot: snapshot,
+		waitCh:   make(chan struct{}),
+		recent:   map[uint64]revPublish{revNum: {time.Now(), snapshot}},
+	}
+}
+
+// publish makes the immutable snapshot of the revNum current, and
+// wakes all the waiters.
+func (b *revBroadcast) publish(revNum uint64, snapshot interface{}) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.revNum = revNum
+	b.snapshot = snapshot
+
+	b.recent[revNum] = revPublish{time.Now(), snapshot}
+	if len(b.recent) > revBroadcastHistory {
+		for r := range b.recent {
+			if r+revBroadcastHistory <= revNum {
+				delete(b.recent, r)
+			}
+		}
+	}
+
+	close(b.waitCh)
+	b.waitCh = make(chan struct{})
+}
+
+// current returns the current revNum and snapshot, along with a
+// channel that's closed on the next publish.
+func (b *revBroadcast) current() (uint64, interface{}, chan struct{}) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.revNum, b.snapshot, b.waitCh
+}
+
+// wakeupAt returns the earliest time that a waiter of the revNum is
+// woken, which is zero for an unknown or old revNum.
+func (b *revBroadcast) wakeupAt(revNum uint64,
+	minInterval time.Duration) time.Time {
+	if minInterval <= 0 {
+		return time.Time{}
+	}
+
+	b.m.Lock()
+	p, exists := b.recent[revNum]
+	b.m.Unlock()
+	if !exists {
+		return time.Time{}
+	}
+
+	return p.at.Add(minInterval)
+}
+
+// snapshotAt returns the snapshot that was published at the revNum,
+// which is false for an unknown or old revNum.
+func (b *revBroadcast) snapshotAt(revNum uint64) (interface{}, bool) {
+	b.m.Lock()
+	p, exists := b.recent[revNum]
+	b.m.Unlock()
+
+	return p.snapshot, exists
+}
+
+// wait blocks until the current revNum differs from the haveRevNum and
+// the min interval has passed, or until the timeout, and returns the
+// latest snapshot.
+func (b *revBroadcast) wait(haveRevNum uint64, minInterval time.Duration,
+	cancelCh service.Cancel, timeout time.Duration) (interface{}, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	revNum, snapshot, waitCh := b.current()
+	for revNum == haveRevNum {
+		select {
+		case <-cancelCh:
+			return nil, service.ErrCanceled
+
+		case <-timer.C:
+			return snapshot, nil // TIMEOUT.
+
+		case <-waitCh:
+			revNum, snapshot, waitCh = b.current()
+		}
+	}
+
+	// Coalesce the changes within the min interval into one response.
+	if d := time.Until(b.wakeupAt(haveRevNum, minInterval)); d > 0 {
+		delay := time.NewTimer(d)
+		defer delay.Stop()
+
+		select {
+		case <-cancelCh:
+			return nil, service.ErrCanceled
+
+		case <-timer.C:
+		case <-delay.C:
+		}
+
+		_, snapshot, _ = b.current()
+	}
+
+	return snapshot, nil
+}
@@ -0,0 +1,158 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbauth/service"
+)
+
+func TestRevBroadcastWaiters(t *testing.T) {
+	b := newRevBroadcast(1, "s1")
+
+	const numWaiters = 20
+
+	var wg sync.WaitGroup
+	results := make(chan interface{}, numWaiters)
+	for i := 0; i < numWaiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			snapshot, err := b.wait(1, 0, nil, time.Minute)
+			if err != nil {
+				t.Errorf("expected the wait to work, err: %v", err)
+			}
+			results <- snapshot
+		}()
+	}
+
+	// The waiters are all blocked at the unchanged revision.
+	select {
+	case snapshot := <-results:
+		t.Fatalf("expected no wakeup before a publish, got: %v", snapshot)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.publish(2, "s2")
+	wg.Wait()
+	close(results)
+
+	n := 0
+	for snapshot := range results {
+		if snapshot != "s2" {
+			t.Errorf("expected the published snapshot, got: %v", snapshot)
+		}
+		n++
+	}
+	if n != numWaiters {
+		t.Errorf("expected %d wakeups, got: %d", numWaiters, n)
+	}
+
+	// A waiter of an older revision returns at once.
+	snapshot, err := b.wait(1, 0, nil, time.Minute)
+	if err != nil || snapshot != "s2" {
+		t.Errorf("expected the current snapshot, got: %v, err: %v",
+			snapshot, err)
+	}
+}
+
+func TestRevBroadcastWaitCancelAndTimeout(t *testing.T) {
+	b := newRevBroadcast(1, "s1")
+
+	cancelCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := b.wait(1, 0, cancelCh, time.Minute)
+		errCh <- err
+	}()
+
+	close(cancelCh)
+	if err := <-errCh; err != service.ErrCanceled {
+		t.Errorf("expected ErrCanceled, got: %v", err)
+	}
+
+	snapshot, err := b.wait(1, 0, nil, 10*time.Millisecond)
+	if err != nil || snapshot != "s1" {
+		t.Errorf("expected the unchanged snapshot on a timeout,"+
+			" got: %v, err: %v", snapshot, err)
+	}
+
+	// A cancel during the coalescing delay is also honored.
+	b = newRevBroadcast(1, "s1")
+	b.publish(2, "s2")
+
+	cancelCh = make(chan struct{})
+	go func() {
+		_, err := b.wait(1, time.Minute, cancelCh, time.Minute)
+		errCh <- err
+	}()
+
+	close(cancelCh)
+	if err := <-errCh; err != service.ErrCanceled {
+		t.Errorf("expected ErrCanceled during the delay, got: %v", err)
+	}
+}
+
+func TestRevBroadcastMinWakeupInterval(t *testing.T) {
+	minInterval := 100 * time.Millisecond
+
+	b := newRevBroadcast(1, "s1")
+	start := time.Now()
+
+	doneCh := make(chan interface{}, 1)
+	go func() {
+		snapshot, _ := b.wait(1, minInterval, nil, time.Minute)
+		doneCh <- snapshot
+	}()
+
+	// A burst of changes is coalesced into one wakeup with the latest
+	// snapshot, no sooner than the min interval after the revision
+	// that the waiter had.
+	b.publish(2, "s2")
+	b.publish(3, "s3")
+
+	snapshot := <-doneCh
+	if time.Since(start) < minInterval {
+		t.Errorf("expected the wakeup to be delayed by the min interval")
+	}
+	if snapshot != "s3" {
+		t.Errorf("expected the latest snapshot, got: %v", snapshot)
+	}
+
+	// An unknown revision isn't rate limited.
+	if at := b.wakeupAt(100, minInterval); !at.IsZero() {
+		t.Errorf("expected no wakeup limit for an unknown rev, got: %v", at)
+	}
+	if at := b.wakeupAt(1, 0); !at.IsZero() {
+		t.Errorf("expected no wakeup limit for a 0 interval, got: %v", at)
+	}
+}
+
+func TestRevBroadcastHistory(t *testing.T) {
+	b := newRevBroadcast(0, 0)
+	for r := uint64(1); r <= 3*revBroadcastHistory; r++ {
+		b.publish(r, r)
+	}
+
+	if len(b.recent) > revBroadcastHistory+1 {
+		t.Errorf("expected a bounded history, got: %d", len(b.recent))
+	}
+
+	last := uint64(3 * revBroadcastHistory)
+	if snapshot, exists := b.snapshotAt(last); !exists || snapshot != last {
+		t.Errorf("expected the latest snapshot, got: %v, %t",
+			snapshot, exists)
+	}
+	if _, exists := b.snapshotAt(1); exists {
+		t.Errorf("expected an old revision to be forgotten")
+	}
+}
@@ -10,12 +10,15 @@
 package ctl
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -83,8 +86,14 @@ type Ctl struct {
 
 	movingPartitionsCount int
 
+	// 1 when this node is the task orchestrator, or 2 when it took
+	// over a forced orchestration, see orchestratorChanged().
 	orchestrator uint32
 
+	// The UUID of the node that's the task orchestrator, as last seen
+	// in the Cfg, see CtlOrchestratorKey.
+	orchestratorNode string
+
 	// Indicates whether planning has to be deferred for index related changes
 	// Currently, defered during hibernation.
 	deferPlanning bool
@@ -92,6 +101,16 @@ type Ctl struct {
 	lastIndexDefs *cbgt.IndexDefs
 
 	hm *hibernate.Manager
+
+	metrics *ctlMetrics
+
+	// The idealPlanDiffsM serializes the computations of the ideal
+	// plan diffs, which are cached until the plan, the index or node
+	// definitions or the member nodes change.
+	idealPlanDiffsM     sync.Mutex
+	idealPlanDiffsKey   string
+	idealPlanDiffs      int
+	idealPlanDiffsTotal int
 }
 
 type CtlOptions struct {
@@ -101,6 +120,28 @@ type CtlOptions struct {
 	WaitForMemberNodes                 int // Seconds to wait for wanted member nodes to appear.
 	MaxConcurrentPartitionMovesPerNode int
 	Manager                            *cbgt.Manager
+
+	// Optional, receives the audit records of the CtlMgr operations,
+	// defaults to a CtlAuditLogSink.
+	AuditSink CtlAuditSink
+
+	// Optional, the timeout of the CtlMgr's long-polls and waits,
+	// defaults to the CtlMgrTimeout.
+	MgrTimeout time.Duration
+
+	// Optional, the interval that the progress updates are coalesced
+	// within for the long-poll waiters, defaults to the
+	// CtlMgrMinWakeupInterval, where a negative value disables it.
+	ProgressCoalesceInterval time.Duration
+
+	// Optional, returns whether the copy of a pindex on a node is ready
+	// to serve in place of a failed over node's copy, such as from the
+	// node's stats, see CtlMgr.FailoverReadiness().
+	PIndexReady func(node, pindex string) bool
+
+	// The manager options "ctlMgrTimeoutSecs" and
+	// "ctlProgressCoalesceIntervalMS" override the above at runtime,
+	// such as via the manager options REST API.
 }
 
 type CtlNode struct {
@@ -140,11 +181,20 @@ type CtlTopology struct {
 	// ChangeTopology will be non-nil when a service topology change
 	// is in progress.
 	ChangeTopology *CtlChangeTopology
+
+	// Orchestrator is the UUID of the node that's the current task
+	// orchestrator, if any.
+	Orchestrator string
 }
 
 type CtlChangeTopology struct {
 	Rev string // Works as CAS, so use the last Topology response’s Rev.
 
+	// Optional, the ID of the topology change, which enables the
+	// persisted plan of a rebalance to be resumed by a new
+	// orchestrator, see rebalance.RebalancePlan.
+	ID string
+
 	// Use Mode of "failover-hard" for hard failover.
 	// Use Mode of "failover-graceful" for graceful failover.
 	// Use Mode of "rebalance" for rebalance-style, clean and safe topology change.
@@ -160,6 +210,11 @@ type CtlChangeTopology struct {
 	// The EjectNodeUUIDs are the service nodes that should be removed from
 	// the service cluster after the topology change is finished.
 	EjectNodeUUIDs []string
+
+	// Optional, the context of the trace span of the topology change,
+	// which the trace spans of the ctl and of the rebalance are
+	// children of.
+	TraceContext context.Context `json:"-"`
 }
 
 // CtlOnProgressFunc defines the callback func signature that's
@@ -179,8 +234,16 @@ type CtlOnProgressFunc func(
 func StartCtl(cfg cbgt.Cfg, server string,
 	optionsMgr map[string]string, optionsCtl CtlOptions) (
 	*Ctl, error) {
+	metrics := newCtlMetrics()
+
+	// The ctl, and so the rebalances and hibernations that it runs,
+	// reads and writes the Cfg keys of the manager's tenant, if any.
+	if optionsCtl.Manager != nil && optionsCtl.Manager.CfgKeyPrefix() != "" {
+		cfg = optionsCtl.Manager.Cfg()
+	}
+
 	ctl := &Ctl{
-		cfg:        cfg,
+		cfg:        newCtlMetricsCfg(cfg, metrics),
 		cfgEventCh: make(chan cbgt.CfgEvent),
 		server:     server,
 		optionsMgr: optionsMgr,
@@ -189,6 +252,7 @@ func StartCtl(cfg cbgt.Cfg, server string,
 		initCh:     make(chan error),
 		stopCh:     make(chan struct{}),
 		revNum:     1,
+		metrics:    metrics,
 	}
 
 	go ctl.run()
@@ -299,6 +363,95 @@ func (ctl *Ctl) getMovingPartitionsCount(keepNodeUUIDs, existingNodes []string)
 	return mpCount, nil
 }
 
+// getIdealPlanDiffs computes a fresh, ideal plan for the current index
+// definitions over the given member nodes (honoring their weights and
+// the replica constraints), starting from the current plan, and
+// returns the number of pindex-to-node assignments of the current plan
+// that the ideal plan differs by, out of the ideal plan's total.  The
+// outcome is cached by the revisions of its inputs, as it's checked on
+// every topology request.
+func (ctl *Ctl) getIdealPlanDiffs(memberNodes []CtlNode) (
+	diffs, total int, err error) {
+	version := cbgt.CfgGetVersion(ctl.cfg)
+
+	_, indexDefsCAS, err := cbgt.CfgGetIndexDefs(ctl.cfg)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	planPIndexes, planPIndexesCAS, err :=
+		cbgt.PlannerGetPlanPIndexes(ctl.cfg, version)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Newly added member nodes might not be wanted yet, so their
+	// definitions come from the known node definitions.
+	nodeDefsKnown, nodeDefsKnownCAS, err :=
+		cbgt.CfgGetNodeDefs(ctl.cfg, cbgt.NODE_DEFS_KNOWN)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	memberNodeUUIDs := make([]string, 0, len(memberNodes))
+	for _, memberNode := range memberNodes {
+		memberNodeUUIDs = append(memberNodeUUIDs, memberNode.UUID)
+	}
+	sort.Strings(memberNodeUUIDs)
+
+	key := fmt.Sprintf("%s/%d/%d/%d/%s", version, indexDefsCAS,
+		planPIndexesCAS, nodeDefsKnownCAS, strings.Join(memberNodeUUIDs, ","))
+
+	ctl.idealPlanDiffsM.Lock()
+	defer ctl.idealPlanDiffsM.Unlock()
+
+	if ctl.idealPlanDiffsKey == key {
+		return ctl.idealPlanDiffs, ctl.idealPlanDiffsTotal, nil
+	}
+
+	indexDefs, err := cbgt.PlannerGetIndexDefs(ctl.cfg, version)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	nodeDefs := cbgt.NewNodeDefs(version)
+	if nodeDefsKnown != nil {
+		for _, memberNode := range memberNodes {
+			nodeDef, exists := nodeDefsKnown.NodeDefs[memberNode.UUID]
+			if exists && nodeDef != nil {
+				nodeDefs.NodeDefs[memberNode.UUID] = nodeDef
+			}
+		}
+	}
+
+	if len(indexDefs.IndexDefs) > 0 && len(nodeDefs.NodeDefs) > 0 {
+		// The ideal plan is only a comparison, so it mustn't replace
+		// the placement traces of the actual plan.
+		options := map[string]string{}
+		for k, v := range ctl.getManagerOptions() {
+			options[k] = v
+		}
+		options["disablePlacementTraces"] = "true"
+
+		planPIndexesIdeal, err := cbgt.CalcPlan("", indexDefs, nodeDefs,
+			cbgt.CopyPlanPIndexes(planPIndexes, version), version,
+			ctl.optionsCtl.Manager.Server(), options, nil)
+		if err != nil {
+			return 0, 0, fmt.Errorf("ctl: getIdealPlanDiffs, CalcPlan,"+
+				" err: %v", err)
+		}
+
+		diffs, total = cbgt.CountPlanPIndexesNodeDiffs(planPIndexes,
+			planPIndexesIdeal)
+	}
+
+	ctl.idealPlanDiffsKey = key
+	ctl.idealPlanDiffs = diffs
+	ctl.idealPlanDiffsTotal = total
+
+	return diffs, total, nil
+}
+
 // ----------------------------------------------------
 
 func (ctl *Ctl) Stop() error {
@@ -479,6 +632,22 @@ func (ctl *Ctl) run() {
 		return
 	}
 
+	err = ctl.cfg.Subscribe(CtlOrchestratorKey, ctl.cfgEventCh)
+	if err != nil {
+		ctl.initCh <- err
+		close(ctl.initCh)
+		return
+	}
+
+	err = ctl.cfg.Subscribe(cbgt.INDEX_DISK_QUOTAS_KEY, ctl.cfgEventCh)
+	if err != nil {
+		ctl.initCh <- err
+		close(ctl.initCh)
+		return
+	}
+
+	ctl.orchestratorChanged(true)
+
 	err = kickIndexDefs("init", time.Time{})
 	if err != nil {
 		ctl.initCh <- err
@@ -504,12 +673,25 @@ func (ctl *Ctl) run() {
 	for {
 		select {
 		case <-ctl.stopCh:
-			ctl.dispatchCtl("", "stop", nil, nil)
+			ctl.dispatchCtl(context.Background(), "", "", "stop", nil, nil)
 			return
 
 		case ev := <-ctl.cfgEventCh:
 			log.Printf("ctl: cfgEvent, kind: %s", ev.Key)
 
+			if ev.Key == CtlOrchestratorKey {
+				ctl.orchestratorChanged(false)
+				continue
+			}
+
+			if ev.Key == cbgt.INDEX_DISK_QUOTAS_KEY {
+				// Have the topology watchers see the disk quota warnings.
+				ctl.m.Lock()
+				ctl.incRevNumLOCKED()
+				ctl.m.Unlock()
+				continue
+			}
+
 			if ev.Key == cbgt.INDEX_DEFS_KEY {
 				// debounce the indexDef related cfg events.
 				ev = ctl.debounceCfgEvents(ev)
@@ -544,6 +726,9 @@ func (ctl *Ctl) run() {
 				ctl.memberNodes = memberNodes
 				ctl.incRevNumLOCKED()
 				ctl.m.Unlock()
+
+				// The orchestrator might have been removed.
+				ctl.orchestratorChanged(false)
 			}
 		}
 	}
@@ -713,7 +898,7 @@ func (ctl *Ctl) WaitGetTopology(haveRev service.Revision,
 			case <-cancelCh:
 				return nil, ErrCtlCanceled
 
-			case <-time.After(CtlMgrTimeout):
+			case <-time.After(ctl.mgrTimeout()):
 				// TIMEOUT
 				ctl.m.Lock()
 				break OUTER
@@ -762,6 +947,7 @@ func (ctl *Ctl) getTopologyLOCKED() *CtlTopology {
 		PrevWarnings:   ctl.prevWarnings,
 		PrevErrs:       ctl.prevErrs,
 		ChangeTopology: ctl.ctlChangeTopology,
+		Orchestrator:   ctl.orchestratorNode,
 	}
 }
 
@@ -847,7 +1033,9 @@ func (ctl *Ctl) ChangeTopology(changeTopology *CtlChangeTopology,
 	ctl.purgeStalePlanAndClusterNodes(changeTopology)
 
 	return ctl.dispatchCtl(
+		changeTopology.TraceContext,
 		changeTopology.Rev,
+		changeTopology.ID,
 		changeTopology.Mode,
 		changeTopology.MemberNodeUUIDs,
 		cb)
@@ -856,12 +1044,13 @@ func (ctl *Ctl) ChangeTopology(changeTopology *CtlChangeTopology,
 // StopChangeTopology synchronously stops a current change topology
 // operation.
 func (ctl *Ctl) StopChangeTopology(rev string) {
-	ctl.dispatchCtl(rev, "stopChangeTopology", nil, nil)
+	ctl.dispatchCtl(context.Background(), rev, "", "stopChangeTopology",
+		nil, nil)
 }
 
 // ----------------------------------------------------
 
-func (ctl *Ctl) dispatchCtl(rev string,
+func (ctl *Ctl) dispatchCtl(ctx context.Context, rev, id string,
 	mode string, memberNodeUUIDs []string, cb CtlOnProgressFunc) (
 	*CtlTopology, error) {
 	ctl.m.Lock()
@@ -875,7 +1064,7 @@ func (ctl *Ctl) dispatchCtl(rev string,
 	}
 
 	ctl.m.Lock()
-	err = ctl.dispatchCtlLOCKED(rev, mode, memberNodeUUIDs,
+	err = ctl.dispatchCtlLOCKED(ctx, rev, id, mode, memberNodeUUIDs,
 		movingPartitionsCount, cb)
 	topology := ctl.getTopologyLOCKED()
 	ctl.m.Unlock()
@@ -884,7 +1073,9 @@ func (ctl *Ctl) dispatchCtl(rev string,
 }
 
 func (ctl *Ctl) dispatchCtlLOCKED(
+	ctx context.Context,
 	rev string,
+	id string,
 	mode string,
 	memberNodeUUIDs []string,
 	movingPartitionsCount int,
@@ -909,7 +1100,7 @@ func (ctl *Ctl) dispatchCtlLOCKED(
 	if ctl.ctlDoneCh == nil &&
 		mode != "stop" &&
 		mode != "stopChangeTopology" {
-		return ctl.startCtlLOCKED(mode, memberNodeUUIDs,
+		return ctl.startCtlLOCKED(ctx, id, mode, memberNodeUUIDs,
 			movingPartitionsCount, ctlOnProgress)
 	}
 
@@ -919,6 +1110,8 @@ func (ctl *Ctl) dispatchCtlLOCKED(
 // ----------------------------------------------------
 
 func (ctl *Ctl) startCtlLOCKED(
+	ctx context.Context,
+	id string,
 	mode string,
 	memberNodeUUIDs []string,
 	movingPartitionsCount int,
@@ -933,8 +1126,10 @@ func (ctl *Ctl) startCtlLOCKED(
 
 	ctlChangeTopology := &CtlChangeTopology{
 		Rev:             fmt.Sprintf("%d", ctl.revNum),
+		ID:              id,
 		Mode:            mode,
 		MemberNodeUUIDs: memberNodeUUIDs,
+		TraceContext:    ctx,
 	}
 	ctl.ctlChangeTopology = ctlChangeTopology
 
@@ -951,6 +1146,15 @@ func (ctl *Ctl) startCtlLOCKED(
 		return cbgt.HttpClient().Get(urlStr)
 	}
 
+	httpPostWithAuth := func(urlStr, contentType string,
+		body io.Reader) (resp *http.Response, err error) {
+		if authType == "cbauth" {
+			return cbgt.CBAuthHttpPost(urlStr, contentType, body)
+		}
+
+		return cbgt.HttpClient().Post(urlStr, contentType, body)
+	}
+
 	ctl.movingPartitionsCount = movingPartitionsCount
 	existingNodeUUIDs := ctl.prevMemberNodeUUIDs
 
@@ -963,6 +1167,12 @@ func (ctl *Ctl) startCtlLOCKED(
 
 		wasCtlStopped := false
 
+		traceCtx, span := cbgt.StartSpan(ctx, "cbgt.ctl.ChangeTopology",
+			cbgt.TraceAttributes{
+				"mode": mode,
+				"rev":  ctlChangeTopology.Rev,
+			})
+
 		// Cleanup ctl goroutine.
 		//
 		defer func() {
@@ -1016,9 +1226,19 @@ func (ctl *Ctl) startCtlLOCKED(
 
 			ctl.setTaskOrchestratorTo(false)
 
+			for _, err := range ctlErrs {
+				span.RecordError(err)
+			}
+			span.SetAttributes(cbgt.TraceAttributes{
+				"stopped":  fmt.Sprintf("%t", wasCtlStopped),
+				"warnings": fmt.Sprintf("%d", len(ctlWarnings)),
+			})
+			span.End()
+
 			close(ctlDoneCh)
 
-			if mode != "rebalance" && mode != "failover-hard" {
+			if mode != "rebalance" && mode != "failover-hard" &&
+				mode != "failover-graceful" {
 				return
 			}
 
@@ -1082,8 +1302,16 @@ func (ctl *Ctl) startCtlLOCKED(
 				seqChecksTimeoutInSec, _ := cbgt.ParseOptionsInt(ctl.getManagerOptions(),
 					"seqChecksTimeoutInSec")
 
+				warmCache, _ := strconv.ParseBool(
+					ctl.getManagerOptions()["rebalanceWarmCache"])
+				if ctl.optionsCtl.Manager != nil {
+					warmCache = ctl.optionsCtl.Manager.FeatureFlagBool(
+						"rebalanceWarmCache", warmCache)
+				}
+
 				// Start rebalance and monitor progress.
-				ctl.r, err = rebalance.StartRebalance(version,
+				var r *rebalance.Rebalancer
+				r, err = rebalance.StartRebalance(version,
 					ctl.cfg, ctl.server, ctl.optionsMgr,
 					nodesToRemove,
 					rebalance.RebalanceOptions{
@@ -1093,9 +1321,18 @@ func (ctl *Ctl) startCtlLOCKED(
 						DryRun:                             ctl.optionsCtl.DryRun,
 						Verbose:                            ctl.optionsCtl.Verbose,
 						HttpGet:                            httpGetWithAuth,
+						HttpPost:                           httpPostWithAuth,
+						WarmCache:                          warmCache,
 						Manager:                            ctl.optionsCtl.Manager,
 						ExistingNodes:                      existingNodeUUIDs,
+						TraceContext:                       traceCtx,
+						TopologyChangeID:                   id,
 					})
+
+				ctl.m.Lock()
+				ctl.r = r
+				ctl.m.Unlock()
+
 				if err != nil {
 					log.Warnf("ctl: StartRebalance, err: %v", err)
 
@@ -1190,6 +1427,50 @@ func (ctl *Ctl) startCtlLOCKED(
 
 		// 3) Run planner steps, like unregister and failover.
 		//
+		if mode == "failover-graceful" {
+			// Drain the in-flight mutations to the replicas, then
+			// promote the replicas, and only then remove the nodes.
+			drainTimeoutInSec, _ := cbgt.ParseOptionsInt(ctl.getManagerOptions(),
+				"gracefulFailoverDrainTimeoutInSec")
+
+			err = rebalance.DrainReplicas(ctl.cfg, version, nodesToRemove,
+				rebalance.DrainOptions{
+					TimeoutInSec: drainTimeoutInSec,
+					HttpGet:      httpGetWithAuth,
+				}, ctlStopCh)
+			if err == rebalance.ErrorDrainStopped {
+				wasCtlStopped = true
+				return
+			}
+			if errors.Is(err, rebalance.ErrorDrainUnreachable) ||
+				errors.Is(err, rebalance.ErrorDrainTimeout) {
+				// The nodes being failed over are often dead, so fall
+				// back to a hard failover rather than failing it.
+				log.Warnf("ctl: DrainReplicas, falling back to a hard"+
+					" failover, err: %v", err)
+				err = nil
+			}
+			if err != nil {
+				log.Warnf("ctl: DrainReplicas, err: %v", err)
+				ctlErrs = append(ctlErrs, err)
+				return
+			}
+
+			for _, step := range []string{"failover_", "unregister"} {
+				err = cmd.PlannerSteps(map[string]bool{step: true},
+					ctl.cfg, version, ctl.server, ctl.optionsMgr, nodesToRemove,
+					ctl.optionsCtl.DryRun, ctl.plannerFilterNewIndexesOnly,
+					time.Time{})
+				if err != nil {
+					log.Warnf("ctl: PlannerSteps, step: %s, err: %v", step, err)
+					ctlErrs = append(ctlErrs, err)
+					return
+				}
+			}
+
+			return
+		}
+
 		steps := map[string]bool{"unregister": true}
 		if failover {
 			steps["failover_"] = true
@@ -1349,7 +1630,7 @@ func (ctl *Ctl) checkAndReregisterSelf(selfUUID string) {
 // ----------------------------------------------------
 
 func (ctl *Ctl) isTaskOrchestrator() bool {
-	return atomic.LoadUint32(&ctl.orchestrator) == 1
+	return atomic.LoadUint32(&ctl.orchestrator) != 0
 }
 
 func (ctl *Ctl) setTaskOrchestratorTo(to bool) {
@@ -1358,6 +1639,8 @@ func (ctl *Ctl) setTaskOrchestratorTo(to bool) {
 	} else {
 		atomic.StoreUint32(&ctl.orchestrator, 0)
 	}
+
+	ctl.recordTaskOrchestrator(to)
 }
 
 // ----------------------------------------------------
@@ -1400,17 +1683,18 @@ func (ctl *Ctl) hibernationTaskType() string {
 
 // ----------------------------------------------------
 
+// startHibernation asynchronously pauses or resumes a bucket, where
+// the returned channel is closed once the hibernation is done.
 func (ctl *Ctl) startHibernation(dryRun bool, bucketName, remotePath string,
-	taskType hibernate.OperationType,
-	onProgress func(progressEntries map[string]float64,
-		errs []error)) error {
+	indexNames []string, taskType hibernate.OperationType,
+	onProgress hibernate.HibernationProgressFunc) (chan struct{}, error) {
 	var err error
 	// first check whether there are indexes for the given bucketName.
 	indexDefs, _, err := cbgt.CfgGetIndexDefs(ctl.cfg)
 	if err != nil {
 		log.Warnf("ctl: startHibernation, CfgGetIndexDefs failed,"+
 			" err: %v", err)
-		return err
+		return nil, err
 	}
 
 	var sourceType string
@@ -1447,6 +1731,7 @@ func (ctl *Ctl) startHibernation(dryRun bool, bucketName, remotePath string,
 		HttpGet:         httpGetWithAuth,
 		Manager:         ctl.optionsCtl.Manager,
 		DryRun:          dryRun,
+		IndexNames:      indexNames,
 	}
 
 	ctlStopCh := make(chan struct{})
@@ -1473,7 +1758,7 @@ func (ctl *Ctl) startHibernation(dryRun bool, bucketName, remotePath string,
 			ctl.prevErrs = ctlErrs
 
 			if onProgress != nil {
-				onProgress(nil, ctlErrs)
+				onProgress(nil, nil, ctlErrs)
 			}
 
 			ctl.m.Unlock()
@@ -1521,7 +1806,7 @@ func (ctl *Ctl) startHibernation(dryRun bool, bucketName, remotePath string,
 		}
 	}()
 
-	return nil
+	return ctlDoneCh, nil
 }
 
 // StopHibernationTask asynchronously stops any ongoing hibernation
@@ -0,0 +1,49 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"testing"
+
+	"github.com/couchbase/cbgt"
+)
+
+func TestStartCtlCfgKeyPrefix(t *testing.T) {
+	cfg := cbgt.NewCfgMem()
+
+	mgr := cbgt.NewManagerEx(cbgt.VERSION, cfg, cbgt.NewUUID(), nil,
+		"", 1, "", "", "", "", nil,
+		map[string]string{cbgt.CFG_KEY_PREFIX_OPTION: "t1"})
+
+	nodeDefs := cbgt.NewNodeDefs(cbgt.VERSION)
+	nodeDefs.NodeDefs[mgr.UUID()] = &cbgt.NodeDef{UUID: mgr.UUID()}
+	_, err := cbgt.CfgSetNodeDefs(mgr.Cfg(), cbgt.NODE_DEFS_WANTED, nodeDefs,
+		cbgt.CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	// The unprefixed Cfg is passed, as by the embedders.
+	c, err := StartCtl(cfg, "", nil, CtlOptions{Manager: mgr})
+	if err != nil {
+		t.Fatalf("expected StartCtl to work, err: %v", err)
+	}
+	defer c.Stop()
+
+	memberNodes, err := CurrentMemberNodes(c.cfg)
+	if err != nil || len(memberNodes) != 1 ||
+		memberNodes[0].UUID != mgr.UUID() {
+		t.Fatalf("expected the tenant's member nodes, got: %+v, err: %v",
+			memberNodes, err)
+	}
+
+	if mgr.CfgKeyPrefix() != "t1" {
+		t.Errorf("expected the tenant prefix, got: %q", mgr.CfgKeyPrefix())
+	}
+}
@@ -0,0 +1,161 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/rest"
+)
+
+// FailoverReadiness reports whether the service can absorb the
+// failover of a node without losing any pindexes.
+type FailoverReadiness struct {
+	Node string `json:"node"`
+	Safe bool   `json:"safe"`
+
+	// The number of pindexes that are assigned to the node.
+	PIndexes int `json:"pindexes"`
+
+	// The pindexes, keyed by index name, that are assigned to the node
+	// and that have no ready copy on any other wanted node.
+	Uncovered map[string][]string `json:"uncovered,omitempty"`
+
+	// Reasons lists why the failover isn't safe.
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// FailoverReadiness returns whether the failover of the node is safe,
+// meaning that every pindex assigned to the node has a ready copy on
+// some other wanted node that can be promoted, so that ns_server's
+// auto-failover can consult the service before acting.  A copy is
+// ready when the plan lets it be read and written, and the optional
+// CtlOptions.PIndexReady agrees, so that a replica that's still being
+// built doesn't count.
+func (m *CtlMgr) FailoverReadiness(node string) (*FailoverReadiness, error) {
+	nodeDefs, _, err := cbgt.CfgGetNodeDefs(m.ctl.cfg, cbgt.NODE_DEFS_WANTED)
+	if err != nil {
+		return nil, err
+	}
+
+	planPIndexes, _, err := cbgt.PlannerGetPlanPIndexes(m.ctl.cfg,
+		cbgt.CfgGetVersion(m.ctl.cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	rv := &FailoverReadiness{Node: node}
+
+	if nodeDefs == nil {
+		nodeDefs = cbgt.NewNodeDefs(cbgt.CfgGetVersion(m.ctl.cfg))
+	}
+
+	if planPIndexes != nil {
+		for name, planPIndex := range planPIndexes.PlanPIndexes {
+			if planPIndex.Nodes[node] == nil {
+				continue
+			}
+
+			rv.PIndexes++
+
+			covered := false
+			for other, planPIndexNode := range planPIndex.Nodes {
+				if other != node && nodeDefs.NodeDefs[other] != nil &&
+					m.isPIndexReady(other, name, planPIndexNode) {
+					covered = true
+					break
+				}
+			}
+
+			if !covered {
+				if rv.Uncovered == nil {
+					rv.Uncovered = map[string][]string{}
+				}
+				rv.Uncovered[planPIndex.IndexName] =
+					append(rv.Uncovered[planPIndex.IndexName], name)
+			}
+		}
+	}
+
+	for indexName, pindexes := range rv.Uncovered {
+		sort.Strings(pindexes)
+		rv.Reasons = append(rv.Reasons,
+			fmt.Sprintf("index: %s has %d pindexes without a ready replica",
+				indexName, len(pindexes)))
+	}
+	sort.Strings(rv.Reasons)
+
+	m.ctl.m.Lock()
+	if m.ctl.ctlChangeTopology != nil {
+		rv.Reasons = append(rv.Reasons, "a topology change is in progress")
+	}
+	m.ctl.m.Unlock()
+
+	rv.Safe = len(rv.Reasons) == 0
+
+	return rv, nil
+}
+
+// isPIndexReady returns whether the copy of a pindex on a node is
+// ready to serve, see FailoverReadiness().
+func (m *CtlMgr) isPIndexReady(node, pindex string,
+	planPIndexNode *cbgt.PlanPIndexNode) bool {
+	if planPIndexNode == nil ||
+		!planPIndexNode.CanRead || !planPIndexNode.CanWrite {
+		return false
+	}
+
+	if m.ctl.optionsCtl.PIndexReady != nil {
+		return m.ctl.optionsCtl.PIndexReady(node, pindex)
+	}
+
+	return true
+}
+
+// ------------------------------------------------
+
+// CtlFailoverReadinessHandler is a REST handler that reports whether
+// the failover of the node given by the "node" query param is safe.
+type CtlFailoverReadinessHandler struct {
+	m *CtlMgr
+}
+
+func NewCtlFailoverReadinessHandler(
+	mgr *CtlMgr) *CtlFailoverReadinessHandler {
+	return &CtlFailoverReadinessHandler{m: mgr}
+}
+
+func (h *CtlFailoverReadinessHandler) RESTOpts(opts map[string]string) {
+	opts["param: node"] =
+		"required, string, URL query parameter\n\n" +
+			"The UUID of the node to be failed over."
+}
+
+func (h *CtlFailoverReadinessHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	node := req.FormValue("node")
+	if node == "" {
+		rest.ShowError(w, req, "ctl/manager: node param is required",
+			http.StatusBadRequest)
+		return
+	}
+
+	rv, err := h.m.FailoverReadiness(node)
+	if err != nil {
+		rest.ShowError(w, req, fmt.Sprintf("ctl/manager:"+
+			" could not check failover readiness, err: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	rest.MustEncode(w, rv)
+}
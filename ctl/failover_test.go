@@ -0,0 +1,79 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/cbgt"
+)
+
+func TestFailoverReadiness(t *testing.T) {
+	cfg := cbgt.NewCfgMem()
+
+	nodeDefs := cbgt.NewNodeDefs(cbgt.VERSION)
+	for _, node := range []string{"n0", "n1", "n2"} {
+		nodeDefs.NodeDefs[node] = &cbgt.NodeDef{UUID: node}
+	}
+	_, err := cbgt.CfgSetNodeDefs(cfg, cbgt.NODE_DEFS_WANTED, nodeDefs,
+		cbgt.CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected no err, err: %v", err)
+	}
+
+	ready := &cbgt.PlanPIndexNode{CanRead: true, CanWrite: true}
+
+	planPIndexes := cbgt.NewPlanPIndexes(cbgt.VERSION)
+	for name, nodes := range map[string]map[string]*cbgt.PlanPIndexNode{
+		// A ready replica.
+		"p0": {"n0": ready, "n1": ready},
+		// A replica that can't be read, such as one being built.
+		"p1": {"n0": ready, "n1": {CanWrite: true, Priority: 1}},
+		// A replica that the PIndexReady says isn't caught up.
+		"p2": {"n0": ready, "n2": ready},
+		// A replica on a node that isn't wanted.
+		"p3": {"n0": ready, "n3": ready},
+	} {
+		planPIndexes.PlanPIndexes[name] = &cbgt.PlanPIndex{
+			Name: name, IndexName: "i0", Nodes: nodes,
+		}
+	}
+	_, err = cbgt.CfgSetPlanPIndexes(cfg, planPIndexes, cbgt.CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected no err, err: %v", err)
+	}
+
+	m := NewCtlMgr(nil, &Ctl{
+		cfg: cfg,
+		optionsCtl: CtlOptions{
+			PIndexReady: func(node, pindex string) bool {
+				return node != "n2"
+			},
+		},
+	})
+
+	rv, err := m.FailoverReadiness("n0")
+	if err != nil {
+		t.Fatalf("expected no err, err: %v", err)
+	}
+	if rv.Safe || rv.PIndexes != 4 ||
+		!reflect.DeepEqual(rv.Uncovered["i0"], []string{"p1", "p2", "p3"}) {
+		t.Fatalf("expected only p0 to be covered, got: %+v", rv)
+	}
+
+	// The failover of n1 leaves p0 and p1 on the ready n0.
+	rv, err = m.FailoverReadiness("n1")
+	if err != nil {
+		t.Fatalf("expected no err, err: %v", err)
+	}
+	if !rv.Safe || rv.PIndexes != 2 || len(rv.Uncovered) != 0 {
+		t.Fatalf("expected a safe failover, got: %+v", rv)
+	}
+}
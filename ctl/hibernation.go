@@ -0,0 +1,728 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/cbauth/service"
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/hibernate"
+	"github.com/couchbase/cbgt/rest"
+	log "github.com/couchbase/clog"
+)
+
+// MultiBucketPauseParams are the parameters for pausing several
+// buckets (e.g., of a tenant) under a single task.
+type MultiBucketPauseParams struct {
+	ID      string
+	Buckets []service.PauseParams
+
+	// ContinueOnError means that the remaining buckets are still
+	// paused after a bucket fails, rather than being skipped, unless
+	// they depend on the failed bucket.
+	ContinueOnError bool
+
+	// DependsOn optionally lists the buckets that have to be paused
+	// before a bucket, keyed by bucket name.
+	DependsOn map[string][]string
+
+	// MaxConcurrency is the upper limit of the buckets that are paused
+	// at the same time, see MaxConcurrentBucketHibernations.
+	MaxConcurrency int
+}
+
+// MultiBucketResumeParams are the parameters for resuming several
+// buckets under a single task.
+type MultiBucketResumeParams struct {
+	ID      string
+	Buckets []service.ResumeParams
+
+	// ContinueOnError means that the remaining buckets are still
+	// resumed after a bucket fails, rather than being skipped, unless
+	// they depend on the failed bucket.
+	ContinueOnError bool
+
+	// IndexNames optionally limits the resume of a bucket to the named
+	// indexes, keyed by bucket name.
+	IndexNames map[string][]string
+
+	// DependsOn optionally lists the buckets that have to be resumed
+	// before a bucket, such as the buckets holding metadata, keyed by
+	// bucket name.
+	DependsOn map[string][]string
+
+	// MaxConcurrency is the upper limit of the buckets that are
+	// resumed at the same time, see MaxConcurrentBucketHibernations.
+	MaxConcurrency int
+}
+
+// ResumeIndexesParams are the parameters for resuming only selected
+// indexes of a hibernated bucket, where the other indexes remain in
+// the remote path and can be resumed later.
+type ResumeIndexesParams struct {
+	service.ResumeParams
+
+	IndexNames []string `json:"indexNames"`
+}
+
+// MaxConcurrentBucketHibernations caps the MaxConcurrency of the
+// multi-bucket pause/resume tasks, as the hibernation of a node
+// handles one bucket at a time.
+var MaxConcurrentBucketHibernations = 1
+
+// The statuses of a bucket in a multi-bucket pause/resume task.
+const (
+	BucketHibernationPending = cbgt.HibernationBatchPending
+	BucketHibernationRunning = cbgt.HibernationBatchRunning
+	BucketHibernationDone    = cbgt.HibernationBatchDone
+	BucketHibernationFailed  = cbgt.HibernationBatchFailed
+	BucketHibernationSkipped = cbgt.HibernationBatchSkipped
+)
+
+// A BucketHibernationStatus is the status of the sub-task of one
+// bucket of a multi-bucket pause/resume task, which are listed under
+// the "buckets" key of the task's Extra.
+type BucketHibernationStatus struct {
+	ID        string   `json:"id"` // The task ID + "/" + the bucket.
+	Bucket    string   `json:"bucket"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+	Status    string   `json:"status"`
+	Progress  float64  `json:"progress"` // In the range of 0 to 1.
+	Error     string   `json:"error,omitempty"`
+}
+
+// PauseBuckets pauses several buckets under a single bucket pause
+// task, in the order of their dependencies, with independent
+// per-bucket progress.  The task fails if any of the buckets fail,
+// where the buckets that failed or were skipped are no longer tracked
+// for hibernation, and can be paused again by ResumeHibernationBatch().
+func (m *CtlMgr) PauseBuckets(params MultiBucketPauseParams) error {
+	log.Printf("ctl/manager: PauseBuckets, params: %+v", params)
+
+	batch := &cbgt.HibernationBatch{
+		ID:              params.ID,
+		Task:            cbgt.HIBERNATE_TASK,
+		MaxConcurrency:  params.MaxConcurrency,
+		ContinueOnError: params.ContinueOnError,
+	}
+	for _, p := range params.Buckets {
+		batch.Buckets = append(batch.Buckets, &cbgt.HibernationBatchBucket{
+			Bucket:     p.Bucket,
+			RemotePath: p.RemotePath,
+			Region:     p.BlobStorageRegion,
+			RateLimit:  p.RateLimit,
+			DependsOn:  params.DependsOn[p.Bucket],
+		})
+	}
+
+	return m.startHibernationBatch(batch,
+		map[string]interface{}{"pauseBuckets": params})
+}
+
+// ResumeBuckets resumes several buckets under a single bucket resume
+// task, in the order of their dependencies, with independent
+// per-bucket progress.  The task fails if any of the buckets fail.
+func (m *CtlMgr) ResumeBuckets(params MultiBucketResumeParams) error {
+	log.Printf("ctl/manager: ResumeBuckets, params: %+v", params)
+
+	batch := &cbgt.HibernationBatch{
+		ID:              params.ID,
+		Task:            cbgt.UNHIBERNATE_TASK,
+		MaxConcurrency:  params.MaxConcurrency,
+		ContinueOnError: params.ContinueOnError,
+	}
+	for _, p := range params.Buckets {
+		batch.Buckets = append(batch.Buckets, &cbgt.HibernationBatchBucket{
+			Bucket:     p.Bucket,
+			RemotePath: p.RemotePath,
+			Region:     p.BlobStorageRegion,
+			RateLimit:  p.RateLimit,
+			DryRun:     p.DryRun,
+			IndexNames: params.IndexNames[p.Bucket],
+			DependsOn:  params.DependsOn[p.Bucket],
+		})
+	}
+
+	return m.startHibernationBatch(batch,
+		map[string]interface{}{"resumeBuckets": params})
+}
+
+// ResumeHibernationBatch continues the multi-bucket pause/resume task
+// of the given ID after its interruption, such as by a cancel or the
+// restart of the orchestrator, or after its failure, where the buckets
+// that are already done are skipped.
+func (m *CtlMgr) ResumeHibernationBatch(id string) error {
+	log.Printf("ctl/manager: ResumeHibernationBatch, id: %s", id)
+
+	batch, _, err := cbgt.CfgGetHibernationBatch(m.ctl.cfg)
+	if err != nil {
+		return err
+	}
+	if batch == nil || batch.ID != id {
+		log.Errorf("ctl/manager: ResumeHibernationBatch, id: %s, err: %v",
+			id, service.ErrNotFound)
+		return service.ErrNotFound
+	}
+
+	batch.ResetForResume()
+
+	return m.startHibernationBatch(batch,
+		map[string]interface{}{"resumedBatch": id})
+}
+
+func (m *CtlMgr) startHibernationBatch(batch *cbgt.HibernationBatch,
+	extra map[string]interface{}) error {
+	task := batch.Task
+
+	var taskType service.TaskType
+	var description string
+	if task == cbgt.HIBERNATE_TASK {
+		taskType, description = service.TaskTypeBucketPause,
+			"pause buckets change"
+	} else {
+		taskType, description = service.TaskTypeBucketResume,
+			"resume buckets change"
+	}
+
+	err := batch.Validate()
+	if err != nil {
+		return fmt.Errorf("ctl/manager: %s buckets, err: %v", task, err)
+	}
+
+	err = m.waitForHibernationPrepare()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, th := range m.tasks.taskHandles {
+		if th.task.Type == service.TaskTypeRebalance ||
+			th.task.Type == service.TaskTypeBucketPause ||
+			th.task.Type == service.TaskTypeBucketResume {
+			log.Errorf("ctl/manager: %s buckets, conflicts with task type: %s,"+
+				" err: %v", task, th.task.Type, service.ErrConflict)
+			return service.ErrConflict
+		}
+	}
+
+	for _, bb := range batch.Buckets {
+		if bb.Status == "" {
+			bb.Status = cbgt.HibernationBatchPending
+		}
+	}
+
+	now := time.Now()
+	if batch.CreatedAt.IsZero() {
+		batch.CreatedAt = now
+	}
+	batch.UpdatedAt = now
+
+	_, err = cbgt.CfgSetHibernationBatch(m.ctl.cfg, batch, cbgt.CFG_CAS_FORCE)
+	if err != nil {
+		return fmt.Errorf("ctl/manager: %s buckets, could not persist"+
+			" batch, err: %v", task, err)
+	}
+
+	// Track all the remaining buckets up front, so that none of their
+	// partitions resume ingesting while the earlier buckets are
+	// hibernated.
+	mgr := m.ctl.optionsCtl.Manager
+
+	var bucketTaskKeys []string
+	for _, bb := range batch.Buckets {
+		if !bb.DryRun && bb.Status != cbgt.HibernationBatchDone {
+			bucketTaskKeys = append(bucketTaskKeys, task+":"+bb.Bucket)
+		}
+	}
+	if len(bucketTaskKeys) > 0 {
+		mgr.SetOption(task, "true", false)
+		mgr.MarkBucketsForHibernation(bucketTaskKeys)
+	}
+
+	taskId := string(hibernate.OperationType(task)) + ":" + batch.ID
+
+	extraNext := map[string]interface{}{}
+	for k, v := range extra {
+		extraNext[k] = v
+	}
+	extraNext["buckets"] = bucketHibernationStatuses(taskId, batch)
+
+	stopCh := make(chan struct{})
+
+	th := &taskHandle{
+		startTime: time.Now(),
+		task: &service.Task{
+			Rev:              EncodeRev(m.allocRevNumLOCKED(m.tasks.revNum)),
+			ID:               taskId,
+			Type:             taskType,
+			Status:           service.TaskStatusRunning,
+			IsCancelable:     true,
+			Progress:         0.0,
+			DetailedProgress: map[service.NodeID]float64{},
+			Description:      description,
+			ErrorMessage:     "",
+			Extra:            extraNext,
+		},
+		stop: func() {
+			log.Printf("ctl/manager: stop %s buckets: %s", task, taskId)
+
+			close(stopCh)
+			mgr.ResetBucketTrackedForHibernation()
+			m.ctl.StopHibernationTask()
+		},
+	}
+
+	m.prepare = nil
+
+	m.updateTasksLOCKED(func(s *tasks) {
+		s.taskHandles = []*taskHandle{th}
+	})
+
+	go m.runHibernationBatch(taskType, taskId, extra, batch, stopCh)
+
+	log.Printf("ctl/manager: %s buckets, started, taskId: %s", task, taskId)
+
+	return nil
+}
+
+func bucketHibernationStatuses(taskId string,
+	batch *cbgt.HibernationBatch) []BucketHibernationStatus {
+	rv := make([]BucketHibernationStatus, 0, len(batch.Buckets))
+	for _, bb := range batch.Buckets {
+		rv = append(rv, BucketHibernationStatus{
+			ID:        taskId + "/" + bb.Bucket,
+			Bucket:    bb.Bucket,
+			DependsOn: bb.DependsOn,
+			Status:    bb.Status,
+			Progress:  bb.Progress,
+			Error:     bb.Error,
+		})
+	}
+	return rv
+}
+
+// runHibernationBatch hibernates the buckets of the batch in the order
+// of their dependencies, persisting the batch as each bucket starts
+// and finishes, so that an interrupted batch can be resumed.
+func (m *CtlMgr) runHibernationBatch(taskType service.TaskType,
+	taskId string, extra map[string]interface{},
+	batch *cbgt.HibernationBatch, stopCh chan struct{}) {
+	mgr := m.ctl.optionsCtl.Manager
+	task := batch.Task
+
+	var sm sync.Mutex // Protects the batch.
+
+	snapshot := func() (float64, map[string]interface{}) {
+		sm.Lock()
+		defer sm.Unlock()
+
+		var progress float64
+		for _, bb := range batch.Buckets {
+			progress += bb.Progress
+		}
+
+		extraNext := map[string]interface{}{}
+		for k, v := range extra {
+			extraNext[k] = v
+		}
+		extraNext["buckets"] = bucketHibernationStatuses(taskId, batch)
+		if stats := mgr.HibernationCompressionStats(); stats != nil {
+			extraNext["compression"] = stats
+		}
+
+		return progress / float64(len(batch.Buckets)), extraNext
+	}
+
+	reportProgress := func() {
+		progress, extraNext := snapshot()
+
+		m.taskProgressMailbox.post(taskProgress{
+			taskId:         taskId,
+			progressExists: true,
+			progress:       progress,
+			extra:          extraNext,
+		})
+	}
+
+	persist := func() {
+		sm.Lock()
+		batch.UpdatedAt = time.Now()
+		_, err := cbgt.CfgSetHibernationBatch(m.ctl.cfg, batch,
+			cbgt.CFG_CAS_FORCE)
+		sm.Unlock()
+		if err != nil {
+			log.Warnf("ctl/manager: %s buckets, taskId: %s, could not"+
+				" persist batch, err: %v", task, taskId, err)
+		}
+	}
+
+	limit := batch.MaxConcurrency
+	if limit <= 0 || limit > MaxConcurrentBucketHibernations {
+		limit = MaxConcurrentBucketHibernations
+	}
+
+	// Buffered, so that the running buckets don't block after a stop.
+	doneCh := make(chan struct{}, len(batch.Buckets))
+	running := 0
+
+	for {
+		sm.Lock()
+		skipped := batch.SkipBlocked()
+		next := batch.Next()
+		if len(next) > limit-running {
+			next = next[:limit-running]
+		}
+		for _, bb := range next {
+			bb.Status = cbgt.HibernationBatchRunning
+		}
+		sm.Unlock()
+
+		for _, bucket := range skipped {
+			mgr.ResetBucketTrackedForHibernationFor(bucket)
+		}
+
+		if len(skipped) > 0 || len(next) > 0 {
+			persist()
+			reportProgress()
+		}
+
+		for _, bb := range next {
+			running++
+			go func(bb *cbgt.HibernationBatchBucket) {
+				m.runHibernationBatchBucket(task, taskId, bb, &sm,
+					reportProgress, stopCh)
+				doneCh <- struct{}{}
+			}(bb)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		select {
+		case <-doneCh:
+			running--
+			persist()
+			reportProgress()
+		case <-stopCh:
+			return
+		}
+	}
+
+	progress, extraNext := snapshot()
+
+	var errs []error
+	allDone := true
+	for _, bb := range batch.Buckets {
+		if bb.Status == cbgt.HibernationBatchFailed {
+			errs = append(errs, fmt.Errorf("bucket: %s, err: %s",
+				bb.Bucket, bb.Error))
+		}
+		if bb.Status != cbgt.HibernationBatchDone {
+			allDone = false
+		}
+	}
+
+	// A batch that's all done is no longer resumable.
+	if allDone {
+		err := cbgt.CfgDelHibernationBatch(m.ctl.cfg)
+		if err != nil {
+			log.Warnf("ctl/manager: %s buckets, taskId: %s, could not"+
+				" remove batch, err: %v", task, taskId, err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The final status is applied directly, rather than through the
+	// taskProgressMailbox, so that it's applied before returning.
+	m.updateTaskLOCKED(taskId, taskType, func(t *service.Task) {
+		t.Progress = progress
+		t.Extra = extraNext
+		setTaskErrors(t, errs)
+		if len(errs) > 0 {
+			t.Status = service.TaskStatusFailed
+		}
+	})
+
+	log.Printf("ctl/manager: %s buckets, taskId: %s, done, errs: %d",
+		task, taskId, len(errs))
+}
+
+// runHibernationBatchBucket hibernates one bucket of a batch, where
+// the sm protects the batch.
+func (m *CtlMgr) runHibernationBatchBucket(task, taskId string,
+	bb *cbgt.HibernationBatchBucket, sm *sync.Mutex,
+	reportProgress func(), stopCh chan struct{}) {
+	mgr := m.ctl.optionsCtl.Manager
+
+	var bucketErrs []error
+
+	onProgress := func(progressEntries map[string]float64,
+		_ hibernate.PIndexNodeProgress, errs []error) {
+		sm.Lock()
+		if len(progressEntries) > 0 {
+			var tot float64
+			for _, p := range progressEntries {
+				tot += p
+			}
+			bb.Progress = tot / float64(len(progressEntries))
+		}
+		bucketErrs = append(bucketErrs, errs...)
+		sm.Unlock()
+
+		reportProgress()
+	}
+
+	err := mgr.PrepareHibernationContextWithPath(bb.RemotePath, bb.Region,
+		bb.RateLimit)
+	if err == nil {
+		var doneCh chan struct{}
+		doneCh, err = m.ctl.startHibernation(bb.DryRun, bb.Bucket,
+			task+":"+bb.RemotePath, bb.IndexNames,
+			hibernate.OperationType(task), onProgress)
+		if err == nil {
+			select {
+			case <-doneCh:
+			case <-stopCh:
+				return
+			}
+		}
+	}
+
+	sm.Lock()
+	if err != nil {
+		bucketErrs = append(bucketErrs, err)
+	}
+	if len(bucketErrs) > 0 {
+		var msgs []string
+		for _, e := range bucketErrs {
+			msgs = append(msgs, e.Error())
+		}
+		bb.Status = cbgt.HibernationBatchFailed
+		bb.Error = strings.Join(msgs, "; ")
+	} else {
+		bb.Status = cbgt.HibernationBatchDone
+		bb.Progress = 1.0
+	}
+	failed := bb.Status == cbgt.HibernationBatchFailed
+	errMsg := bb.Error
+	sm.Unlock()
+
+	if failed {
+		log.Warnf("ctl/manager: %s buckets, taskId: %s, bucket: %s,"+
+			" err: %s", task, taskId, bb.Bucket, errMsg)
+
+		mgr.ResetBucketTrackedForHibernationFor(bb.Bucket)
+	}
+}
+
+// HibernationRateLimitParams are the params to change the rate limit
+// of an in-flight pause/resume task.
+type HibernationRateLimitParams struct {
+	ID        string `json:"id"`        // The ID of the pause/resume task.
+	RateLimit uint64 `json:"rateLimit"` // In bytes/sec, where 0 is unlimited.
+}
+
+// SetHibernationRateLimit changes the upload/download rate limit of an
+// in-flight pause/resume task, which was given at prepare time, where
+// the new rate limit is propagated to all the nodes participating in
+// the transfers through the cluster options.
+func (m *CtlMgr) SetHibernationRateLimit(params HibernationRateLimitParams) error {
+	log.Printf("ctl/manager: SetHibernationRateLimit, params: %+v", params)
+
+	m.mu.Lock()
+	found := false
+	for _, th := range m.tasks.taskHandles {
+		if th.task.ID == params.ID &&
+			th.task.Status == service.TaskStatusRunning &&
+			(th.task.Type == service.TaskTypeBucketPause ||
+				th.task.Type == service.TaskTypeBucketResume) {
+			found = true
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	if !found {
+		log.Errorf("ctl/manager: SetHibernationRateLimit, taskId: %s,"+
+			" err: %v", params.ID, service.ErrNotFound)
+		return service.ErrNotFound
+	}
+
+	return m.ctl.optionsCtl.Manager.SetHibernationRateLimit(params.RateLimit)
+}
+
+// ColdBucketParams are the params to make the hibernated indexes of a
+// paused bucket queryable in the cold query mode.
+type ColdBucketParams struct {
+	Bucket     string `json:"bucket"`
+	RemotePath string `json:"remotePath"`
+	Region     string `json:"region"`
+}
+
+// OpenColdBucket makes the hibernated indexes of a paused bucket
+// queryable on this node in the cold query mode, without resuming the
+// bucket, and returns the names of the cold indexes.
+func (m *CtlMgr) OpenColdBucket(params ColdBucketParams) ([]string, error) {
+	log.Printf("ctl/manager: OpenColdBucket, params: %+v", params)
+
+	mgr := m.ctl.optionsCtl.Manager
+
+	if params.Bucket == "" || !hibernate.CheckIfRemotePathIsValid(params.RemotePath) {
+		return nil, fmt.Errorf("ctl/manager: OpenColdBucket, invalid"+
+			" bucket: %q or remotePath: %q", params.Bucket, params.RemotePath)
+	}
+
+	if mgr.IsBucketBeingHibernated(params.Bucket) {
+		log.Errorf("ctl/manager: OpenColdBucket, bucket: %s,"+
+			" is being hibernated, err: %v", params.Bucket, service.ErrConflict)
+		return nil, service.ErrConflict
+	}
+
+	indexDefs, err := hibernate.OpenColdBucket(mgr, params.Bucket,
+		params.RemotePath, params.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := make([]string, 0, len(indexDefs.IndexDefs))
+	for indexName := range indexDefs.IndexDefs {
+		rv = append(rv, indexName)
+	}
+	sort.Strings(rv)
+
+	return rv, nil
+}
+
+// CloseColdBucket stops the cold queries of a paused bucket on this
+// node.
+func (m *CtlMgr) CloseColdBucket(bucket string) {
+	log.Printf("ctl/manager: CloseColdBucket, bucket: %s", bucket)
+
+	m.ctl.optionsCtl.Manager.CloseColdIndexes(bucket)
+}
+
+// CtlColdBucketHandler is a REST handler that opens, with a POST of
+// the JSON of the ColdBucketParams, or closes, with a DELETE, the cold
+// queries of a paused bucket on this node.
+type CtlColdBucketHandler struct {
+	m *CtlMgr
+}
+
+func NewCtlColdBucketHandler(mgr *CtlMgr) *CtlColdBucketHandler {
+	return &CtlColdBucketHandler{m: mgr}
+}
+
+func (h *CtlColdBucketHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	bucket := rest.BucketNameLookup(req)
+	if bucket == "" {
+		rest.ShowError(w, req, "ctl/manager: bucket name is required",
+			http.StatusBadRequest)
+		return
+	}
+
+	if req.Method == http.MethodDelete {
+		h.m.CloseColdBucket(bucket)
+
+		rest.MustEncode(w, struct {
+			Status string `json:"status"`
+		}{Status: "ok"})
+		return
+	}
+
+	var params ColdBucketParams
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil {
+		rest.ShowError(w, req, fmt.Sprintf("ctl/manager: OpenColdBucket,"+
+			" could not parse request body, err: %v", err),
+			http.StatusBadRequest)
+		return
+	}
+	params.Bucket = bucket
+
+	indexNames, err := h.m.OpenColdBucket(params)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == service.ErrConflict {
+			status = http.StatusConflict
+		}
+		rest.ShowError(w, req, fmt.Sprintf("ctl/manager: OpenColdBucket,"+
+			" bucket: %s, err: %v", bucket, err), status)
+		return
+	}
+
+	rest.MustEncode(w, struct {
+		Status      string   `json:"status"`
+		ColdIndexes []string `json:"coldIndexes"`
+	}{Status: "ok", ColdIndexes: indexNames})
+}
+
+// ------------------------------------------------
+
+// CtlResumeIndexesHandler is a REST handler that resumes only the
+// selected indexes of a hibernated bucket, with a POST of the JSON of
+// the ResumeIndexesParams, after the resume of the bucket is prepared,
+// see CtlMgr.PrepareResume().
+type CtlResumeIndexesHandler struct {
+	m *CtlMgr
+}
+
+func NewCtlResumeIndexesHandler(mgr *CtlMgr) *CtlResumeIndexesHandler {
+	return &CtlResumeIndexesHandler{m: mgr}
+}
+
+func (h *CtlResumeIndexesHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	bucket := rest.BucketNameLookup(req)
+	if bucket == "" {
+		rest.ShowError(w, req, "ctl/manager: bucket name is required",
+			http.StatusBadRequest)
+		return
+	}
+
+	var params ResumeIndexesParams
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil {
+		rest.ShowError(w, req, fmt.Sprintf("ctl/manager: ResumeIndexes,"+
+			" could not parse request body, err: %v", err),
+			http.StatusBadRequest)
+		return
+	}
+	params.Bucket = bucket
+
+	err = h.m.ResumeIndexes(params)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == service.ErrConflict {
+			status = http.StatusConflict
+		} else if errors.Is(err, hibernate.ErrIndexNotHibernated) {
+			status = http.StatusNotFound
+		}
+		rest.ShowError(w, req, fmt.Sprintf("ctl/manager: ResumeIndexes,"+
+			" bucket: %s, err: %v", bucket, err), status)
+		return
+	}
+
+	rest.MustEncode(w, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
@@ -0,0 +1,118 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/cbauth/service"
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/hibernate"
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+	"github.com/gorilla/mux"
+)
+
+func TestResumeIndexesHandler(t *testing.T) {
+	hibernated := cbgt.NewIndexDefs(cbgt.VERSION)
+	for _, indexName := range []string{"i0", "i1", "i2"} {
+		hibernated.IndexDefs[indexName] = &cbgt.IndexDef{
+			Name: indexName, Type: "blackhole", SourceName: "b0",
+		}
+	}
+	metadata, _ := cbgt.MarshalJSON(hibernated)
+
+	prevClientHook := cbgt.HibernationClientHook
+	cbgt.HibernationClientHook = func(string) (objcli.Client, error) {
+		return objcli.NewTestClient(t, objval.ProviderAWS), nil
+	}
+	prevPathHook := hibernate.GetRemoteBucketAndPathHook
+	hibernate.GetRemoteBucketAndPathHook = func(remotePath string) (
+		string, string, error) {
+		return "bkt", "r0", nil
+	}
+	prevDownloadHook := hibernate.DownloadMetadataHook
+	hibernate.DownloadMetadataHook = func(client objcli.Client,
+		ctx context.Context, bucket, key string) ([]byte, error) {
+		if key != "r0/"+hibernate.INDEX_METADATA_PATH {
+			return nil, fmt.Errorf("unexpected key: %s", key)
+		}
+		return metadata, nil
+	}
+	defer func() {
+		cbgt.HibernationClientHook = prevClientHook
+		hibernate.GetRemoteBucketAndPathHook = prevPathHook
+		hibernate.DownloadMetadataHook = prevDownloadHook
+	}()
+
+	m := testPrepareCtlMgr(t)
+
+	err := m.PrepareResume(service.ResumeParams{ID: "r0", Bucket: "b0",
+		RemotePath: "s3://bkt/r0"})
+	if err != nil {
+		t.Fatalf("expected the prepare to work, err: %v", err)
+	}
+
+	h := NewCtlResumeIndexesHandler(m)
+
+	resume := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/ctl/b0/resumeIndexes",
+			bytes.NewBufferString(body))
+		req = mux.SetURLVars(req, map[string]string{"bucketName": "b0"})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	for body, expCode := range map[string]int{
+		`not json`:                            http.StatusBadRequest,
+		`{"id":"r0"}`:                         http.StatusBadRequest,
+		`{"id":"r0","indexNames":[" "]}`:      http.StatusBadRequest,
+		`{"id":"r0","indexNames":["i0","x"]}`: http.StatusNotFound,
+	} {
+		w := resume(body)
+		if w.Code != expCode {
+			t.Fatalf("body: %s, expected: %d, got: %d, %s",
+				body, expCode, w.Code, w.Body.String())
+		}
+		if testPreparedTask(m, "resume:r0") != nil {
+			t.Fatalf("body: %s, expected no resume task", body)
+		}
+	}
+
+	w := resume(`{"id":"r0","remotePath":"s3://bkt/r0",` +
+		`"indexNames":["i2"," i0","i2"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected a resume, got: %d, %s", w.Code, w.Body.String())
+	}
+	defer m.ctl.StopHibernationTask()
+
+	task := testPreparedTask(m, "resume:r0")
+	if task == nil {
+		t.Fatalf("expected a resume task")
+	}
+	if !reflect.DeepEqual(task.Extra["indexNames"], []string{"i0", "i2"}) {
+		t.Errorf("expected the filtered index names, got: %v",
+			task.Extra["indexNames"])
+	}
+
+	// Another resume conflicts with the running one.
+	w = resume(`{"id":"r1","remotePath":"s3://bkt/r0","indexNames":["i1"]}`)
+	if w.Code != http.StatusConflict ||
+		!strings.Contains(w.Body.String(), "conflict") {
+		t.Errorf("expected a conflict, got: %d, %s", w.Code, w.Body.String())
+	}
+}
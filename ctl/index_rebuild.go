@@ -0,0 +1,424 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/couchbase/cbauth/service"
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/rest"
+	log "github.com/couchbase/clog"
+)
+
+// TaskTypeIndexRebuild is the task type of a rolling index rebuild,
+// see cbgt.Manager.StartIndexRebuild().
+const TaskTypeIndexRebuild service.TaskType = "task-index-rebuild"
+
+// IndexRebuildPollInterval is how often a rolling index rebuild checks
+// whether its shadow index has caught up with the original index.
+var IndexRebuildPollInterval = 5 * time.Second
+
+// IndexRebuildMaxProgressErrors is the number of consecutive polls of
+// a rolling index rebuild that fail to retrieve its progress, after
+// which the rebuild fails.
+var IndexRebuildMaxProgressErrors = 60
+
+// CtlIndexRebuildsKey is the Cfg key that records the rolling index
+// rebuilds that are in progress, so that the shadow indexes of the
+// rebuilds that were orphaned, such as by a restart of their node,
+// are cleaned up.
+const CtlIndexRebuildsKey = "ctlIndexRebuilds"
+
+// A CtlIndexRebuild is the record of a rolling index rebuild that's in
+// progress, as kept in the CtlIndexRebuildsKey, keyed by the shadow
+// index name.
+type CtlIndexRebuild struct {
+	IndexName string    `json:"indexName"`
+	NodeUUID  string    `json:"nodeUUID"` // The node that runs the task.
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// CfgGetCtlIndexRebuilds returns the records of the rolling index
+// rebuilds that are in progress, keyed by shadow index name.
+func CfgGetCtlIndexRebuilds(cfg cbgt.Cfg) (
+	map[string]*CtlIndexRebuild, uint64, error) {
+	val, cas, err := cfg.Get(CtlIndexRebuildsKey, 0)
+	if err != nil || val == nil {
+		return nil, cas, err
+	}
+
+	rv := map[string]*CtlIndexRebuild{}
+	err = cbgt.UnmarshalJSON(val, &rv)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return rv, cas, nil
+}
+
+// cfgUpdateCtlIndexRebuilds applies the change to the records of the
+// rolling index rebuilds, where no records removes the key.
+func cfgUpdateCtlIndexRebuilds(cfg cbgt.Cfg,
+	change func(rebuilds map[string]*CtlIndexRebuild)) error {
+	return cbgt.CfgRetryOnCAS("indexRebuild", 100, func() error {
+		rebuilds, cas, err := CfgGetCtlIndexRebuilds(cfg)
+		if err != nil {
+			return err
+		}
+		if rebuilds == nil {
+			rebuilds = map[string]*CtlIndexRebuild{}
+		}
+
+		change(rebuilds)
+
+		if len(rebuilds) == 0 {
+			if cas == 0 {
+				return nil
+			}
+			return cfg.Del(CtlIndexRebuildsKey, cas)
+		}
+
+		val, err := cbgt.MarshalJSON(rebuilds)
+		if err != nil {
+			return err
+		}
+
+		_, err = cfg.Set(CtlIndexRebuildsKey, val, cas)
+		return err
+	})
+}
+
+// IndexRebuildParams are the parameters of a rolling index rebuild,
+// where the empty fields of the IndexDef default to those of the
+// index that's rebuilt.
+type IndexRebuildParams struct {
+	IndexName string         `json:"indexName"`
+	IndexDef  *cbgt.IndexDef `json:"indexDef,omitempty"`
+}
+
+// RebuildIndex starts a rolling index rebuild as a task, which builds
+// a shadow index from the new index definition, reports the shadow
+// index's catch-up progress through the task list, and swaps the
+// shadow index in when it has caught up, after which the task is
+// removed.  Canceling the task deletes the shadow index.
+func (m *CtlMgr) RebuildIndex(params IndexRebuildParams) (
+	taskId string, err error) {
+	log.Printf("ctl/manager: RebuildIndex, indexName: %s", params.IndexName)
+
+	defer func() {
+		m.audit("RebuildIndex", "rebuild index", params, err)
+	}()
+
+	mgr := m.ctl.optionsCtl.Manager
+	if mgr == nil {
+		return "", service.ErrNotSupported
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, th := range m.tasks.taskHandles {
+		if th.task.Type == TaskTypeIndexRebuild &&
+			th.task.Extra["indexName"] == params.IndexName {
+			log.Errorf("ctl/manager: RebuildIndex, indexName: %s,"+
+				" already rebuilding, err: %v",
+				params.IndexName, service.ErrConflict)
+			return "", service.ErrConflict
+		}
+	}
+
+	// The rebuild is recorded before its shadow index is created, so
+	// that the shadow index isn't orphaned when this node restarts.
+	shadowName := cbgt.IndexRebuildShadowName(params.IndexName)
+
+	err = cfgUpdateCtlIndexRebuilds(mgr.Cfg(),
+		func(rebuilds map[string]*CtlIndexRebuild) {
+			rebuilds[shadowName] = &CtlIndexRebuild{
+				IndexName: params.IndexName,
+				NodeUUID:  mgr.UUID(),
+				StartedAt: time.Now(),
+			}
+		})
+	if err != nil {
+		log.Errorf("ctl/manager: RebuildIndex, record, err: %v", err)
+		return "", err
+	}
+
+	indexDef, err := mgr.StartIndexRebuildEx(
+		params.IndexName, shadowName, params.IndexDef)
+	if err != nil {
+		log.Errorf("ctl/manager: RebuildIndex, err: %v", err)
+		m.endIndexRebuild(mgr, shadowName, false)
+		return "", err
+	}
+
+	taskId = "index-rebuild:" + shadowName
+
+	stopCh := make(chan struct{})
+	var stopOnce sync.Once
+
+	revNum := m.allocRevNumLOCKED(m.tasks.revNum)
+
+	th := &taskHandle{
+		startTime: time.Now(),
+		task: &service.Task{
+			Rev:              EncodeRev(revNum),
+			ID:               taskId,
+			Type:             TaskTypeIndexRebuild,
+			Status:           service.TaskStatusRunning,
+			IsCancelable:     true,
+			Progress:         0.0,
+			DetailedProgress: map[service.NodeID]float64{},
+			Description:      "index rebuild",
+			ErrorMessage:     "",
+			Extra: map[string]interface{}{
+				"indexName":  params.IndexName,
+				"indexUUID":  indexDef.UUID,
+				"shadowName": shadowName,
+				"phase":      "building",
+			},
+		},
+		stop: func() {
+			log.Printf("ctl/manager: stop RebuildIndex, indexName: %s,"+
+				" shadowName: %s", params.IndexName, shadowName)
+
+			stopOnce.Do(func() { close(stopCh) })
+		},
+	}
+
+	taskHandlesNext := append([]*taskHandle(nil), m.tasks.taskHandles...)
+	taskHandlesNext = append(taskHandlesNext, th)
+
+	m.updateTasksLOCKED(func(s *tasks) {
+		s.taskHandles = taskHandlesNext
+	})
+
+	go m.runIndexRebuild(mgr, taskId, params.IndexName, indexDef.UUID,
+		shadowName, stopCh)
+
+	log.Printf("ctl/manager: RebuildIndex, started, taskId: %s", taskId)
+
+	return taskId, nil
+}
+
+// runIndexRebuild follows the catch-up of the shadow index of a
+// rebuild until it's swapped in, or until the task is stopped.
+func (m *CtlMgr) runIndexRebuild(mgr *cbgt.Manager, taskId,
+	indexName, indexUUID, shadowName string, stopCh chan struct{}) {
+	ticker := time.NewTicker(IndexRebuildPollInterval)
+	defer ticker.Stop()
+
+	fail := func(err error) {
+		log.Errorf("ctl/manager: runIndexRebuild, taskId: %s, err: %v",
+			taskId, err)
+
+		m.endIndexRebuild(mgr, shadowName, true)
+
+		m.taskProgressMailbox.post(taskProgress{
+			taskId: taskId,
+			errs:   []error{err},
+		})
+	}
+
+	progressErrs := 0
+
+	for {
+		select {
+		case <-stopCh:
+			m.endIndexRebuild(mgr, shadowName, true)
+			return
+		case <-ticker.C:
+		}
+
+		p, err := mgr.IndexRebuildProgress(indexName, shadowName)
+		if err == nil && len(p.Errors) > 0 {
+			err = fmt.Errorf("node errors: %v", p.Errors)
+		}
+		if err != nil {
+			progressErrs++
+			if progressErrs >= IndexRebuildMaxProgressErrors {
+				fail(fmt.Errorf("could not get the progress of the"+
+					" rebuild of index: %s, shadowName: %s, after %d"+
+					" attempts, err: %v", indexName, shadowName,
+					progressErrs, err))
+				return
+			}
+
+			log.Warnf("ctl/manager: runIndexRebuild, taskId: %s,"+
+				" IndexRebuildProgress, err: %v", taskId, err)
+		} else {
+			progressErrs = 0
+		}
+		if p == nil {
+			continue
+		}
+
+		extra := map[string]interface{}{
+			"phase":  "building",
+			"seqGap": p.SeqGap,
+		}
+		if len(p.Errors) > 0 {
+			extra["nodeErrors"] = p.Errors
+		}
+		if p.CaughtUp {
+			extra["phase"] = "swapping"
+		}
+
+		m.taskProgressMailbox.post(taskProgress{
+			taskId:         taskId,
+			progressExists: true,
+			progress:       p.Progress,
+			extra:          extra,
+		})
+
+		if !p.CaughtUp {
+			continue
+		}
+
+		err = mgr.SwapIndexRebuild(indexName, indexUUID, shadowName)
+		if err != nil {
+			fail(fmt.Errorf("could not swap the rebuilt index: %s,"+
+				" shadowName: %s, err: %v", indexName, shadowName, err))
+			return
+		}
+
+		log.Printf("ctl/manager: runIndexRebuild, taskId: %s, done", taskId)
+
+		m.endIndexRebuild(mgr, shadowName, false)
+
+		// An update without progress or errors removes the task.
+		m.taskProgressMailbox.post(taskProgress{taskId: taskId})
+
+		return
+	}
+}
+
+// endIndexRebuild removes the record of a rebuild, after deleting its
+// shadow index when deleteShadow is true.
+func (m *CtlMgr) endIndexRebuild(mgr *cbgt.Manager, shadowName string,
+	deleteShadow bool) {
+	if deleteShadow {
+		_, err := mgr.DeleteIndexEx(shadowName, "")
+		if err != nil {
+			log.Warnf("ctl/manager: endIndexRebuild,"+
+				" shadowName: %s, err: %v", shadowName, err)
+		}
+	}
+
+	err := cfgUpdateCtlIndexRebuilds(mgr.Cfg(),
+		func(rebuilds map[string]*CtlIndexRebuild) {
+			delete(rebuilds, shadowName)
+		})
+	if err != nil {
+		log.Warnf("ctl/manager: endIndexRebuild, record,"+
+			" shadowName: %s, err: %v", shadowName, err)
+	}
+}
+
+// cleanupIndexRebuilds deletes the shadow indexes of the recorded
+// rebuilds that were orphaned, which are those of this node, as its
+// tasks didn't survive its restart, and those of the nodes that are
+// no longer wanted in the cluster.
+func (m *CtlMgr) cleanupIndexRebuilds() {
+	mgr := m.ctl.optionsCtl.Manager
+	if mgr == nil || mgr.Cfg() == nil {
+		return
+	}
+
+	rebuilds, _, err := CfgGetCtlIndexRebuilds(mgr.Cfg())
+	if err != nil || len(rebuilds) == 0 {
+		if err != nil {
+			log.Warnf("ctl/manager: cleanupIndexRebuilds, err: %v", err)
+		}
+		return
+	}
+
+	nodeDefs, _, err := cbgt.CfgGetNodeDefs(mgr.Cfg(), cbgt.NODE_DEFS_WANTED)
+	if err != nil {
+		log.Warnf("ctl/manager: cleanupIndexRebuilds, err: %v", err)
+		return
+	}
+
+	for shadowName, r := range rebuilds {
+		if r.NodeUUID != mgr.UUID() &&
+			nodeDefs != nil && nodeDefs.NodeDefs[r.NodeUUID] != nil {
+			continue
+		}
+
+		log.Printf("ctl/manager: cleanupIndexRebuilds, orphaned rebuild,"+
+			" indexName: %s, shadowName: %s, nodeUUID: %s",
+			r.IndexName, shadowName, r.NodeUUID)
+
+		m.endIndexRebuild(mgr, shadowName, true)
+	}
+}
+
+// ------------------------------------------------
+
+// CtlIndexRebuildHandler is a REST handler that starts a rolling index
+// rebuild, whose progress is then reported by the task list.
+type CtlIndexRebuildHandler struct {
+	m *CtlMgr
+}
+
+func NewCtlIndexRebuildHandler(mgr *CtlMgr) *CtlIndexRebuildHandler {
+	return &CtlIndexRebuildHandler{m: mgr}
+}
+
+func (h *CtlIndexRebuildHandler) RESTOpts(opts map[string]string) {
+	opts["request body"] =
+		"A JSON object with the indexName of the index to rebuild," +
+			" and the new indexDef, whose empty fields default to those" +
+			" of the index"
+}
+
+func (h *CtlIndexRebuildHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	var params IndexRebuildParams
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err == nil {
+		err = cbgt.UnmarshalJSON(requestBody, &params)
+	}
+	if err == nil && params.IndexName == "" {
+		err = fmt.Errorf("indexName is required")
+	}
+	if err != nil {
+		rest.ShowErrorBody(w, requestBody, fmt.Sprintf("ctl/manager:"+
+			" invalid index rebuild request, err: %v", err),
+			http.StatusBadRequest)
+		return
+	}
+
+	taskId, err := h.m.RebuildIndex(params)
+	if err != nil {
+		var badRequestError *cbgt.BadRequestError
+		status := http.StatusInternalServerError
+		if errors.As(err, &badRequestError) {
+			status = http.StatusBadRequest
+		} else if err == service.ErrConflict {
+			status = http.StatusConflict
+		}
+		rest.ShowErrorBody(w, requestBody, fmt.Sprintf("ctl/manager:"+
+			" could not rebuild index: %s, err: %v", params.IndexName, err),
+			status)
+		return
+	}
+
+	rest.MustEncode(w, struct {
+		Status string `json:"status"`
+		TaskId string `json:"taskId"`
+	}{Status: "ok", TaskId: taskId})
+}
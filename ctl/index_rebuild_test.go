@@ -0,0 +1,141 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbgt"
+)
+
+func TestCleanupIndexRebuilds(t *testing.T) {
+	cfg := cbgt.NewCfgMem()
+
+	indexDefs := cbgt.NewIndexDefs(cbgt.VERSION)
+	for _, indexName := range []string{"i0", "i0_rebuild_a", "i0_rebuild_b",
+		"i0_rebuild_c"} {
+		indexDefs.IndexDefs[indexName] = &cbgt.IndexDef{
+			Name:       indexName,
+			UUID:       cbgt.NewUUID(),
+			Type:       "blackhole",
+			SourceName: "b0",
+		}
+	}
+	_, err := cbgt.CfgSetIndexDefs(cfg, indexDefs, cbgt.CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected CfgSetIndexDefs to work, err: %v", err)
+	}
+
+	// Without the planner tag, the index deletes don't wait on a planner.
+	mgr := cbgt.NewManager(cbgt.VERSION, cfg, cbgt.NewUUID(),
+		[]string{"queryer"}, "", 1, "", "", "", "", nil)
+	m := NewCtlMgr(nil, &Ctl{cfg: cfg, optionsCtl: CtlOptions{Manager: mgr}})
+
+	nodeDefs := cbgt.NewNodeDefs(cbgt.VERSION)
+	nodeDefs.NodeDefs["n1"] = &cbgt.NodeDef{UUID: "n1"}
+	_, err = cbgt.CfgSetNodeDefs(mgr.Cfg(), cbgt.NODE_DEFS_WANTED, nodeDefs,
+		cbgt.CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	err = cfgUpdateCtlIndexRebuilds(mgr.Cfg(),
+		func(rebuilds map[string]*CtlIndexRebuild) {
+			// This node's rebuild didn't survive its restart, and the
+			// node of another rebuild left, while n1's is still going.
+			rebuilds["i0_rebuild_a"] = &CtlIndexRebuild{
+				IndexName: "i0", NodeUUID: mgr.UUID()}
+			rebuilds["i0_rebuild_b"] = &CtlIndexRebuild{
+				IndexName: "i0", NodeUUID: "gone"}
+			rebuilds["i0_rebuild_c"] = &CtlIndexRebuild{
+				IndexName: "i0", NodeUUID: "n1"}
+		})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	m.cleanupIndexRebuilds()
+
+	rebuilds, _, err := CfgGetCtlIndexRebuilds(mgr.Cfg())
+	if err != nil || len(rebuilds) != 1 || rebuilds["i0_rebuild_c"] == nil {
+		t.Fatalf("expected only n1's rebuild, got: %+v, err: %v",
+			rebuilds, err)
+	}
+
+	indexDefs, _, err = cbgt.CfgGetIndexDefs(mgr.Cfg())
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	for name, exp := range map[string]bool{
+		"i0": true, "i0_rebuild_a": false, "i0_rebuild_b": false,
+		"i0_rebuild_c": true,
+	} {
+		if (indexDefs.IndexDefs[name] != nil) != exp {
+			t.Errorf("index: %s, expected exists: %v", name, exp)
+		}
+	}
+}
+
+// A testPlanErrCfg fails to get the plan.
+type testPlanErrCfg struct {
+	cbgt.Cfg
+}
+
+func (c *testPlanErrCfg) Get(key string, cas uint64) ([]byte, uint64, error) {
+	if key == cbgt.PLAN_PINDEXES_KEY {
+		return nil, 0, fmt.Errorf("no plan")
+	}
+	return c.Cfg.Get(key, cas)
+}
+
+func TestIndexRebuildProgressErrors(t *testing.T) {
+	prevPollInterval := IndexRebuildPollInterval
+	prevMaxProgressErrors := IndexRebuildMaxProgressErrors
+	IndexRebuildPollInterval = time.Millisecond
+	IndexRebuildMaxProgressErrors = 3
+	defer func() {
+		IndexRebuildPollInterval = prevPollInterval
+		IndexRebuildMaxProgressErrors = prevMaxProgressErrors
+	}()
+
+	cfg := &testPlanErrCfg{Cfg: cbgt.NewCfgMem()}
+	mgr := cbgt.NewManager(cbgt.VERSION, cfg, cbgt.NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+	m := NewCtlMgr(nil, &Ctl{cfg: cfg, optionsCtl: CtlOptions{Manager: mgr}})
+
+	err := cfgUpdateCtlIndexRebuilds(cfg,
+		func(rebuilds map[string]*CtlIndexRebuild) {
+			rebuilds["i0_rebuild_a"] = &CtlIndexRebuild{
+				IndexName: "i0", NodeUUID: mgr.UUID()}
+		})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		m.runIndexRebuild(mgr, "index-rebuild:i0_rebuild_a", "i0", "u0",
+			"i0_rebuild_a", make(chan struct{}))
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the rebuild to fail")
+	}
+
+	rebuilds, _, err := CfgGetCtlIndexRebuilds(cfg)
+	if err != nil || len(rebuilds) != 0 {
+		t.Fatalf("expected the record to be removed, got: %+v, err: %v",
+			rebuilds, err)
+	}
+}
@@ -11,7 +11,10 @@ package ctl
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"reflect"
@@ -33,6 +36,79 @@ import (
 // Timeout for CtlMgr's exported APIs
 var CtlMgrTimeout = time.Duration(20 * time.Second)
 
+// mgrTimeout returns the timeout of the long-polls and waits of the
+// CtlMgr's exported APIs, which is the "ctlMgrTimeoutSecs" manager
+// option, else the CtlOptions.MgrTimeout, else the CtlMgrTimeout.
+func (ctl *Ctl) mgrTimeout() time.Duration {
+	if secs, ok := ctl.getIntManagerOption("ctlMgrTimeoutSecs"); ok && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if ctl.optionsCtl.MgrTimeout > 0 {
+		return ctl.optionsCtl.MgrTimeout
+	}
+	return CtlMgrTimeout
+}
+
+// CtlHibernationPrepareTimeout is how long a bucket pause or resume
+// waits for the prepare phase of its prepared task to be done.
+var CtlHibernationPrepareTimeout = 10 * time.Minute
+
+// hibernationPrepareTimeout returns how long a bucket pause or resume
+// waits for its prepare phase, which is the
+// "ctlHibernationPrepareTimeoutSecs" manager option, else the
+// CtlHibernationPrepareTimeout.
+func (ctl *Ctl) hibernationPrepareTimeout() time.Duration {
+	if secs, ok := ctl.getIntManagerOption(
+		"ctlHibernationPrepareTimeoutSecs"); ok && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return CtlHibernationPrepareTimeout
+}
+
+// minWakeupInterval returns the interval that the changes of the tasks,
+// such as progress updates, are coalesced within for the long-poll
+// waiters, which is the "ctlProgressCoalesceIntervalMS" manager option,
+// else the CtlOptions.ProgressCoalesceInterval, else the
+// CtlMgrMinWakeupInterval.  A negative value disables the coalescing.
+func (ctl *Ctl) minWakeupInterval() time.Duration {
+	if ms, ok := ctl.getIntManagerOption("ctlProgressCoalesceIntervalMS"); ok {
+		return time.Duration(ms) * time.Millisecond
+	}
+	if ctl.optionsCtl.ProgressCoalesceInterval != 0 {
+		return ctl.optionsCtl.ProgressCoalesceInterval
+	}
+	return CtlMgrMinWakeupInterval
+}
+
+// getIntManagerOption returns the int value of a manager option, which
+// is false when it's unset or invalid.
+func (ctl *Ctl) getIntManagerOption(name string) (int, bool) {
+	if ctl == nil || ctl.optionsCtl.Manager == nil {
+		return 0, false
+	}
+
+	v, ok := ctl.getManagerOptions()[name]
+	if !ok || v == "" {
+		return 0, false
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.Warnf("ctl/manager: option: %s, value: %q, err: %v", name, v, err)
+		return 0, false
+	}
+
+	return i, true
+}
+
+// TopologyChangeTypeFailoverGraceful requests a failover that first
+// lets the replicas catch up with the primaries on the failed over
+// nodes before promoting them.  A TopologyChangeTypeFailover is also
+// treated as graceful when the "gracefulFailover" manager option is
+// "true".
+const TopologyChangeTypeFailoverGraceful = service.TopologyChangeType(
+	"topology-change-failover-graceful")
+
 // CtlMgr implements the cbauth/service.Manager interface and
 // provides the adapter or glue between ns-server's service API
 // and cbgt's Ctl implementation.
@@ -41,19 +117,35 @@ type CtlMgr struct {
 
 	ctl *Ctl
 
-	taskProgressCh chan taskProgress
+	// The latest progress updates of the tasks, which are handled by
+	// the handleTaskProgress() goroutine.
+	taskProgressMailbox *taskProgressMailbox
 
 	mu sync.Mutex // Protects the fields that follow.
 
 	revNumNext uint64 // The next rev num to use.
 
-	tasks       tasks
-	tasksWaitCh chan struct{} // Closed when the tasks change.
+	tasks tasks
 
-	lastTaskList service.TaskList
+	// The asynchronous prepare phase of the prepared task, if any.
+	prepare *hibernationPrepare
+
+	// Publishes the *service.TaskList of each change of the tasks to
+	// the GetTaskList() long-poll waiters.
+	tasksBroadcast *revBroadcast
+
+	// The recently canceled or expired tasks, so that their removal
+	// isn't reported as a completion, see noteCanceledTaskLOCKED().
+	canceledTasks []canceledTask
+
+	lastTaskListM sync.Mutex
+	lastTaskList  service.TaskList
 
 	lastTopologyM sync.Mutex
 	lastTopology  service.Topology
+
+	traceM              sync.Mutex
+	topologyChangeSpans map[string]*topologyChangeSpan // Keyed by change ID.
 }
 
 type tasks struct {
@@ -72,22 +164,36 @@ type taskProgress struct {
 	errs           []error
 	progressExists bool
 	progress       float64
+	extra          map[string]interface{} // Optional, merged into the Extra.
+	errStatus      service.TaskStatus     // Optional, instead of failed on errs.
+
+	// Optional, node -> progress, for the DetailedProgress.
+	detailedProgress map[service.NodeID]float64
 }
 
 // ------------------------------------------------
 
 func NewCtlMgr(nodeInfo *service.NodeInfo, ctl *Ctl) *CtlMgr {
 	m := &CtlMgr{
-		nodeInfo:       nodeInfo,
-		ctl:            ctl,
-		revNumNext:     1,
-		tasks:          tasks{revNum: 0},
-		taskProgressCh: make(chan taskProgress, 10),
+		nodeInfo:            nodeInfo,
+		ctl:                 ctl,
+		revNumNext:          1,
+		tasks:               tasks{revNum: 0},
+		taskProgressMailbox: newTaskProgressMailbox(),
+	}
+
+	m.tasksBroadcast = newRevBroadcast(m.tasks.revNum, m.getTaskListLOCKED())
+
+	if ctl != nil {
+		go m.cleanupIndexRebuilds()
+		go m.runShadowCopyTasks()
 	}
 
 	go func() {
-		for taskProgress := range m.taskProgressCh {
-			m.handleTaskProgress(taskProgress)
+		for range m.taskProgressMailbox.kickCh {
+			for _, taskProgress := range m.taskProgressMailbox.take() {
+				m.handleTaskProgress(taskProgress)
+			}
 		}
 	}()
 
@@ -109,64 +215,102 @@ func (m *CtlMgr) Shutdown() error {
 
 func (m *CtlMgr) GetTaskList(haveTasksRev service.Revision,
 	cancelCh service.Cancel) (*service.TaskList, error) {
-	m.mu.Lock()
-
-	if len(haveTasksRev) > 0 {
-		haveTasksRevNum, err := DecodeRev(haveTasksRev)
-		if err != nil {
-			m.mu.Unlock()
+	rv, err := m.waitTaskList("GetTaskList", haveTasksRev, cancelCh)
+	if err != nil {
+		return nil, err
+	}
 
-			log.Errorf("ctl/manager: GetTaskList, DecodeRev"+
-				", haveTasksRev: %s, err: %v", haveTasksRev, err)
+	// The snapshot is shared by the waiters, so a copy is returned.
+	rvCopy := *rv
 
-			return nil, err
-		}
+	m.lastTaskListM.Lock()
+	changed := string(m.lastTaskList.Rev) != string(rvCopy.Rev)
+	if changed {
+		m.lastTaskList.Rev = rvCopy.Rev
+		changed = !reflect.DeepEqual(&m.lastTaskList, &rvCopy)
+		m.lastTaskList = rvCopy
+	}
+	m.lastTaskListM.Unlock()
 
-	OUTER:
-		for haveTasksRevNum == m.tasks.revNum {
-			if m.tasksWaitCh == nil {
-				m.tasksWaitCh = make(chan struct{})
-			}
-			tasksWaitCh := m.tasksWaitCh
+	if changed {
+		log.Printf("ctl/manager: GetTaskList, haveTasksRev: %s,"+
+			" changed, rv: %+v", haveTasksRev, &rvCopy)
+	}
 
-			m.mu.Unlock()
-			select {
-			case <-cancelCh:
-				return nil, service.ErrCanceled
+	return &rvCopy, nil
+}
 
-			case <-time.After(CtlMgrTimeout):
-				// TIMEOUT
-				m.mu.Lock()
-				break OUTER
+// waitTaskList returns the shared snapshot of the task list, waiting
+// for a change when the haveTasksRev is supplied.
+func (m *CtlMgr) waitTaskList(caller string, haveTasksRev service.Revision,
+	cancelCh service.Cancel) (*service.TaskList, error) {
+	m.expirePreparedTasks()
 
-			case <-tasksWaitCh:
-				// FALLTHRU
-			}
-			m.mu.Lock()
-		}
+	if len(haveTasksRev) == 0 {
+		_, snapshot, _ := m.tasksBroadcast.current()
+		return snapshot.(*service.TaskList), nil
 	}
 
-	rv := m.getTaskListLOCKED()
+	haveTasksRevNum, err := DecodeRev(haveTasksRev)
+	if err != nil {
+		log.Errorf("ctl/manager: %s, DecodeRev"+
+			", haveTasksRev: %s, err: %v", caller, haveTasksRev, err)
 
-	m.lastTaskList.Rev = rv.Rev
-	same := reflect.DeepEqual(&m.lastTaskList, rv)
-	m.lastTaskList = *rv
+		return nil, err
+	}
 
-	m.mu.Unlock()
+	snapshot, err := m.tasksBroadcast.wait(haveTasksRevNum,
+		m.ctl.minWakeupInterval(), cancelCh, m.ctl.mgrTimeout())
+	if err != nil {
+		return nil, err
+	}
 
-	if !same {
-		log.Printf("ctl/manager: GetTaskList, haveTasksRev: %s,"+
-			" changed, rv: %+v", haveTasksRev, rv)
+	rv := snapshot.(*service.TaskList)
+	if hasPreparedTask(rv) {
+		rv = m.expirePreparedTasks()
 	}
 
 	return rv, nil
 }
 
+// expirePreparedTasks expires the stale prepared tasks, and returns
+// the current task list.
+func (m *CtlMgr) expirePreparedTasks() *service.TaskList {
+	_, snapshot, _ := m.tasksBroadcast.current()
+	if !hasPreparedTask(snapshot.(*service.TaskList)) {
+		return snapshot.(*service.TaskList)
+	}
+
+	m.mu.Lock()
+	m.expirePreparedTasksLOCKED()
+	m.mu.Unlock()
+
+	_, snapshot, _ = m.tasksBroadcast.current()
+
+	return snapshot.(*service.TaskList)
+}
+
+func hasPreparedTask(taskList *service.TaskList) bool {
+	for i := range taskList.Tasks {
+		if taskList.Tasks[i].Type == service.TaskTypePrepared {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *CtlMgr) CancelTask(
-	taskId string, taskRev service.Revision) error {
+	taskId string, taskRev service.Revision) (err error) {
 	log.Printf("ctl/manager: CancelTask, taskId: %s, taskRev: %s",
 		taskId, taskRev)
 
+	defer func() {
+		m.audit("CancelTask", "cancel task", map[string]interface{}{
+			"taskId":  taskId,
+			"taskRev": string(taskRev),
+		}, err)
+	}()
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -193,6 +337,12 @@ func (m *CtlMgr) CancelTask(
 				return service.ErrNotSupported
 			}
 
+			if task.Type == service.TaskTypePrepared ||
+				task.Type == service.TaskTypeRebalance {
+				m.endTopologyChangeSpan(topologyChangeID(task.ID),
+					"canceled", nil)
+			}
+
 			if taskHandle.stop != nil {
 				taskHandle.stop()
 			} else {
@@ -200,6 +350,8 @@ func (m *CtlMgr) CancelTask(
 					" nil taskHandle", taskId, taskRev)
 			}
 
+			m.noteCanceledTaskLOCKED(task)
+
 			canceled = true
 		} else {
 			taskHandlesNext = append(taskHandlesNext, taskHandle)
@@ -222,6 +374,76 @@ func (m *CtlMgr) CancelTask(
 	return nil
 }
 
+// DefaultPreparedTaskTTL is the default age after which a prepared task
+// that never proceeded, such as to a StartTopologyChange or a Pause, is
+// expired and removed from the task list, so that it doesn't block
+// further prepares until it's canceled.  It can be overridden by the
+// "preparedTaskTTLSecs" manager option, where a TTL <= 0 means never.
+var DefaultPreparedTaskTTL = 10 * time.Minute
+
+func (m *CtlMgr) preparedTaskTTL() time.Duration {
+	ttl := DefaultPreparedTaskTTL
+	if v, ok := m.ctl.getManagerOptions()["preparedTaskTTLSecs"]; ok {
+		if secs, err := strconv.Atoi(v); err == nil {
+			ttl = time.Duration(secs) * time.Second
+		}
+	}
+	return ttl
+}
+
+// expirePreparedTasksLOCKED stops and removes the prepared tasks that
+// are older than the prepared task TTL.
+func (m *CtlMgr) expirePreparedTasksLOCKED() {
+	ttl := m.preparedTaskTTL()
+	if ttl <= 0 {
+		return
+	}
+
+	var taskHandlesNext []*taskHandle
+	var expired bool
+
+	for _, th := range m.tasks.taskHandles {
+		if th.task.Type != service.TaskTypePrepared ||
+			time.Since(th.startTime) <= ttl {
+			taskHandlesNext = append(taskHandlesNext, th)
+			continue
+		}
+
+		log.Warnf("ctl/manager: expiring prepared task, taskId: %s,"+
+			" startTime: %v, ttl: %v", th.task.ID, th.startTime, ttl)
+
+		m.endTopologyChangeSpan(topologyChangeID(th.task.ID), "expired", nil)
+
+		if th.stop != nil {
+			th.stop()
+		}
+
+		m.noteCanceledTaskLOCKED(th.task)
+
+		// A prepared pause or resume tracks its bucket for hibernation.
+		_, preparePause := th.task.Extra["preparePause"]
+		_, prepareResume := th.task.Extra["prepareResume"]
+		if preparePause || prepareResume {
+			m.ctl.optionsCtl.Manager.ResetBucketTrackedForHibernation()
+		}
+
+		expired = true
+	}
+
+	if expired {
+		m.updateTasksLOCKED(func(s *tasks) {
+			s.taskHandles = taskHandlesNext
+		})
+	}
+}
+
+// DefaultIsBalancedMaxPlanDiffPercent is the default percentage of
+// pindex-to-node assignments by which the current plan may differ
+// from a freshly computed, ideal plan while still being reported as
+// balanced, which can be overridden by the "isBalancedMaxPlanDiffPercent"
+// manager option.
+var DefaultIsBalancedMaxPlanDiffPercent = 5.0
+
 func isBalanced(ctl *Ctl, ctlTopology *CtlTopology) bool {
 	if len(ctlTopology.PrevWarnings) > 0 {
 		for _, w := range ctlTopology.PrevWarnings {
@@ -239,6 +461,39 @@ func isBalanced(ctl *Ctl, ctlTopology *CtlTopology) bool {
 		return false
 	}
 
+	if ctlTopology.ChangeTopology != nil {
+		return false
+	}
+
+	diffs, total, err := ctl.getIdealPlanDiffs(ctlTopology.MemberNodes)
+	if err != nil {
+		// Whether the cluster is balanced is unknown, so it's not
+		// reported as balanced.
+		log.Warnf("ctl/manager: isBalanced, getIdealPlanDiffs, err: %v", err)
+		return false
+	}
+	if diffs == 0 {
+		return true
+	}
+
+	maxDiffPercent := DefaultIsBalancedMaxPlanDiffPercent
+	if v, ok := ctl.getManagerOptions()["isBalancedMaxPlanDiffPercent"]; ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			maxDiffPercent = f
+		}
+	}
+
+	diffPercent := 100.0
+	if total > 0 {
+		diffPercent = float64(diffs) * 100.0 / float64(total)
+	}
+	if diffPercent > maxDiffPercent {
+		log.Printf("ctl/manager: isBalanced, not balanced, diffs: %d,"+
+			" total: %d, diffPercent: %.2f > maxDiffPercent: %.2f",
+			diffs, total, diffPercent, maxDiffPercent)
+		return false
+	}
+
 	return true
 }
 
@@ -264,7 +519,6 @@ func (m *CtlMgr) GetCurrentTopology(haveTopologyRev service.Revision,
 		rv.Nodes = append(rv.Nodes, service.NodeID(ctlNode.UUID))
 	}
 
-	// TODO: Need a proper IsBalanced computation.
 	rv.IsBalanced = isBalanced(m.ctl, ctlTopology)
 
 	for resourceName, resourceWarnings := range ctlTopology.PrevWarnings {
@@ -290,6 +544,13 @@ func (m *CtlMgr) GetCurrentTopology(haveTopologyRev service.Revision,
 		rv.Messages = append(rv.Messages, fmt.Sprintf("error: %v", err))
 	}
 
+	quotas, _, err := cbgt.CfgGetIndexDiskQuotas(m.ctl.cfg)
+	if err != nil {
+		log.Warnf("ctl/manager: GetCurrentTopology,"+
+			" CfgGetIndexDiskQuotas, err: %v", err)
+	}
+	rv.Messages = append(rv.Messages, cbgt.IndexDiskQuotaMessages(quotas)...)
+
 	m.lastTopologyM.Lock()
 	m.lastTopology.Rev = rv.Rev
 	same := reflect.DeepEqual(&m.lastTopology, rv)
@@ -308,12 +569,30 @@ func (m *CtlMgr) PrepareTopologyChange(
 	change service.TopologyChange) (err error) {
 	log.Printf("ctl/manager: PrepareTopologyChange, change: %v", change)
 
+	defer func() {
+		m.audit("PrepareTopologyChange", "prepare topology change",
+			auditTopologyChangeParams(change), err)
+	}()
+
+	root := startTopologyChangeSpan(change)
+	_, span := cbgt.StartSpan(root.ctx, "cbgt.ctl.PrepareTopologyChange",
+		cbgt.TraceAttributes{"taskID": "prepare:" + change.ID})
+
 	m.mu.Lock()
 	defer func() {
 		m.mu.Unlock()
-		if err == nil {
-			m.ctl.onSuccessfulPrepare(true)
+
+		span.RecordError(err)
+		span.End()
+
+		if err != nil {
+			root.end("failed", []error{err})
+			return
 		}
+
+		m.trackTopologyChangeSpan(root)
+
+		m.ctl.onSuccessfulPrepare(true)
 	}()
 
 	// Possible for caller to not care about current topology, but
@@ -326,6 +605,8 @@ func (m *CtlMgr) PrepareTopologyChange(
 		return err
 	}
 
+	m.expirePreparedTasksLOCKED()
+
 	for _, taskHandle := range m.tasks.taskHandles {
 		if taskHandle.task.Type == service.TaskTypePrepared ||
 			taskHandle.task.Type == service.TaskTypeRebalance {
@@ -379,11 +660,32 @@ func (m *CtlMgr) PrepareTopologyChange(
 	return nil
 }
 
-func (m *CtlMgr) StartTopologyChange(change service.TopologyChange) error {
+func (m *CtlMgr) StartTopologyChange(
+	change service.TopologyChange) (err error) {
 	log.Printf("ctl/manager: StartTopologyChange, change: %v", change)
 
+	defer func() {
+		m.audit("StartTopologyChange", "start topology change",
+			auditTopologyChangeParams(change), err)
+	}()
+
+	root, rootCreated := m.topologyChangeSpanFor(change)
+	_, span := cbgt.StartSpan(root.ctx, "cbgt.ctl.StartTopologyChange",
+		cbgt.TraceAttributes{"taskID": "rebalance:" + change.ID})
+
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer func() {
+		m.mu.Unlock()
+
+		span.RecordError(err)
+		span.End()
+
+		// A prepared change keeps its root span until it's canceled,
+		// expired or started again.
+		if err != nil && rootCreated {
+			m.endTopologyChangeSpan(change.ID, "failed", []error{err})
+		}
+	}()
 
 	// Possible for caller to not care about current topology, but
 	// just wants to impose or force a topology change.
@@ -394,7 +696,9 @@ func (m *CtlMgr) StartTopologyChange(change service.TopologyChange) error {
 		return service.ErrConflict
 	}
 
-	var err error
+	// An expired prepare isn't started, even when the task list
+	// hasn't been polled since it expired.
+	m.expirePreparedTasksLOCKED()
 
 	started := false
 
@@ -408,7 +712,7 @@ func (m *CtlMgr) StartTopologyChange(change service.TopologyChange) error {
 		}
 
 		if th.task.Type == service.TaskTypePrepared {
-			th, err = m.startTopologyChangeTaskHandleLOCKED(change)
+			th, err = m.startTopologyChangeTaskHandleLOCKED(change, root.ctx)
 			if err != nil {
 				log.Errorf("ctl/manager: StartTopologyChange,"+
 					" prepared, err: %v", err)
@@ -435,9 +739,12 @@ func (m *CtlMgr) StartTopologyChange(change service.TopologyChange) error {
 }
 
 func (m *CtlMgr) startTopologyChangeTaskHandleLOCKED(
-	change service.TopologyChange) (*taskHandle, error) {
+	change service.TopologyChange,
+	traceCtx context.Context) (*taskHandle, error) {
 	ctlChangeTopology := &CtlChangeTopology{
-		Rev: string(change.CurrentTopologyRev),
+		Rev:          string(change.CurrentTopologyRev),
+		ID:           change.ID,
+		TraceContext: traceCtx,
 	}
 
 	switch change.Type {
@@ -446,6 +753,12 @@ func (m *CtlMgr) startTopologyChangeTaskHandleLOCKED(
 
 	case service.TopologyChangeTypeFailover:
 		ctlChangeTopology.Mode = "failover-hard"
+		if m.ctl.getManagerOptions()["gracefulFailover"] == "true" {
+			ctlChangeTopology.Mode = "failover-graceful"
+		}
+
+	case TopologyChangeTypeFailoverGraceful:
+		ctlChangeTopology.Mode = "failover-graceful"
 
 	default:
 		log.Warnf("ctl/manager: unknown change.Type: %v", change.Type)
@@ -486,6 +799,12 @@ func (m *CtlMgr) startTopologyChangeTaskHandleLOCKED(
 			progressEntries, errs)
 
 		if progressEntries == nil {
+			outcome := "completed"
+			if len(errs) > 0 {
+				outcome = "failed"
+			}
+			m.endTopologyChangeSpan(change.ID, outcome, errs)
+
 			return "DONE"
 		}
 
@@ -569,7 +888,8 @@ func (m *CtlMgr) computeProgPercent(pe *rebalance.ProgressEntry,
 }
 
 func (m *CtlMgr) updateHibernationProgress(taskId string,
-	progressEntries map[string]float64, errs []error) {
+	progressEntries map[string]float64,
+	pindexNodeProgress hibernate.PIndexNodeProgress, errs []error) {
 	var totalProgress float64
 	if progressEntries != nil {
 		var currTotalProgress float64
@@ -585,13 +905,34 @@ func (m *CtlMgr) updateHibernationProgress(taskId string,
 		progress:       totalProgress,
 	}
 
-	select {
-	case m.taskProgressCh <- taskProgressVal:
-		// NO-OP.
-	default:
-		// NO-OP, if the handleTaskProgress() goroutine is behind,
-		// drop notifications rather than hold up the hibernation manager.
+	taskProgressVal.extra = map[string]interface{}{
+		"rateLimit": m.ctl.optionsCtl.Manager.HibernationRateLimit(),
+	}
+	if stats := m.ctl.optionsCtl.Manager.HibernationCompressionStats(); stats != nil {
+		taskProgressVal.extra["compression"] = stats
+	}
+
+	// The per pindex per node progress shows which node or pindex is
+	// holding up the hibernation.
+	if len(pindexNodeProgress) > 0 {
+		taskProgressVal.extra["pindexProgress"] = pindexNodeProgress
+
+		nodeProgress := pindexNodeProgress.NodeProgress()
+		taskProgressVal.detailedProgress =
+			make(map[service.NodeID]float64, len(nodeProgress))
+		for node, progress := range nodeProgress {
+			taskProgressVal.detailedProgress[service.NodeID(node)] = progress
+		}
+	}
+
+	// Corrupted hibernated data can't be resumed by retrying.
+	for _, err := range errs {
+		if errors.Is(err, cbgt.ErrHibernationChecksum) {
+			taskProgressVal.errStatus = service.TaskStatusCannotResume
+		}
 	}
+
+	m.taskProgressMailbox.post(taskProgressVal)
 }
 
 // ------------------------------------------------
@@ -680,13 +1021,7 @@ func (m *CtlMgr) updateProgress(
 		progress:       progress,
 	}
 
-	select {
-	case m.taskProgressCh <- taskProgressVal:
-		// NO-OP.
-	default:
-		// NO-OP, if the handleTaskProgress() goroutine is behind,
-		// drop notifications rather than hold up the rebalancer.
-	}
+	m.taskProgressMailbox.post(taskProgressVal)
 }
 
 func (m *CtlMgr) handleTaskProgress(taskProgress taskProgress) {
@@ -705,22 +1040,32 @@ func (m *CtlMgr) handleTaskProgress(taskProgress taskProgress) {
 				taskNext := *th.task // Copy.
 				taskNext.Rev = EncodeRev(revNum)
 				taskNext.Progress = taskProgress.progress
+				if taskProgress.extra != nil {
+					extraNext := make(map[string]interface{},
+						len(th.task.Extra)+len(taskProgress.extra))
+					for k, v := range th.task.Extra {
+						extraNext[k] = v
+					}
+					for k, v := range taskProgress.extra {
+						extraNext[k] = v
+					}
+					taskNext.Extra = extraNext
+				}
 
 				log.Printf("ctl/manager: revNum: %d, progress: %f",
 					revNum, taskProgress.progress)
 
-				// TODO: DetailedProgress.
-
-				taskNext.ErrorMessage = ""
-				for _, err := range taskProgress.errs {
-					if len(taskNext.ErrorMessage) > 0 {
-						taskNext.ErrorMessage = taskNext.ErrorMessage + "\n"
-					}
-					taskNext.ErrorMessage = taskNext.ErrorMessage + err.Error()
+				if taskProgress.detailedProgress != nil {
+					taskNext.DetailedProgress = taskProgress.detailedProgress
 				}
 
+				setTaskErrors(&taskNext, taskProgress.errs)
+
 				if len(taskProgress.errs) > 0 {
 					taskNext.Status = service.TaskStatusFailed
+					if taskProgress.errStatus != "" {
+						taskNext.Status = taskProgress.errStatus
+					}
 				}
 
 				taskHandlesNext = append(taskHandlesNext, &taskHandle{
@@ -757,6 +1102,42 @@ func parsePIndexName(pindexName string) string {
 	return ""
 }
 
+// updateTaskLOCKED applies an update to a copy of the task of the
+// given id and type, if it's still in the task lists, returning true
+// if the task was updated.
+func (m *CtlMgr) updateTaskLOCKED(taskId string, taskType service.TaskType,
+	update func(task *service.Task)) bool {
+	var taskHandlesNext []*taskHandle
+	var updated bool
+
+	for _, th := range m.tasks.taskHandles {
+		if th.task.ID != taskId || th.task.Type != taskType {
+			taskHandlesNext = append(taskHandlesNext, th)
+			continue
+		}
+
+		taskNext := *th.task // Copy.
+		taskNext.Rev = EncodeRev(m.allocRevNumLOCKED(0))
+		update(&taskNext)
+
+		taskHandlesNext = append(taskHandlesNext, &taskHandle{
+			startTime: th.startTime,
+			task:      &taskNext,
+			stop:      th.stop,
+		})
+
+		updated = true
+	}
+
+	if updated {
+		m.updateTasksLOCKED(func(s *tasks) {
+			s.taskHandles = taskHandlesNext
+		})
+	}
+
+	return updated
+}
+
 // ------------------------------------------------
 
 func (m *CtlMgr) getTaskListLOCKED() *service.TaskList {
@@ -779,10 +1160,7 @@ func (m *CtlMgr) updateTasksLOCKED(body func(tasks *tasks)) {
 
 	m.tasks.revNum = m.allocRevNumLOCKED(m.tasks.revNum)
 
-	if m.tasksWaitCh != nil {
-		close(m.tasksWaitCh)
-		m.tasksWaitCh = nil
-	}
+	m.tasksBroadcast.publish(m.tasks.revNum, m.getTaskListLOCKED())
 }
 
 // ------------------------------------------------
@@ -818,12 +1196,18 @@ func NewCtlManagerStatusHandler(mgr *CtlMgr) *CtlManagerStatusHandler {
 
 func (h *CtlManagerStatusHandler) ServeHTTP(
 	w http.ResponseWriter, req *http.Request) {
+	h.m.ctl.m.Lock()
+	orchestratorNode := h.m.ctl.orchestratorNode
+	h.m.ctl.m.Unlock()
+
 	rv := struct {
-		Orchestrator bool   `json:"orchestrator"`
-		Status       string `json:"status"`
+		Orchestrator     bool   `json:"orchestrator"`
+		OrchestratorNode string `json:"orchestratorNode,omitempty"`
+		Status           string `json:"status"`
 	}{
-		Status:       "ok",
-		Orchestrator: h.m.ctl.isTaskOrchestrator(),
+		Status:           "ok",
+		Orchestrator:     h.m.ctl.isTaskOrchestrator(),
+		OrchestratorNode: orchestratorNode,
 	}
 	rest.MustEncode(w, rv)
 }
@@ -852,41 +1236,325 @@ func (h *CtlHibernationStatusHandler) ServeHTTP(
 
 // ------------------------------------------------
 
-// DefragmentedUtilizationHook allows applications to register a
-// callback to determine the projected "defragmented" utilization
-// stats for the nodes belonging to the service. This should be
-// set only during the init()'ialization phase of the process.
-var DefragmentedUtilizationHook func(nodeDefs *cbgt.NodeDefs) (
+// CtlHibernationRateLimitHandler is a REST handler that changes the
+// rate limit of an in-flight pause/resume task.
+type CtlHibernationRateLimitHandler struct {
+	m *CtlMgr
+}
+
+func NewCtlHibernationRateLimitHandler(
+	mgr *CtlMgr) *CtlHibernationRateLimitHandler {
+	return &CtlHibernationRateLimitHandler{m: mgr}
+}
+
+func (h *CtlHibernationRateLimitHandler) RESTOpts(opts map[string]string) {
+	opts["request body"] =
+		"A JSON object with the id of the pause/resume task and its" +
+			" new rateLimit, in bytes/sec, where 0 means unlimited"
+}
+
+func (h *CtlHibernationRateLimitHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	var params HibernationRateLimitParams
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err == nil {
+		err = cbgt.UnmarshalJSON(requestBody, &params)
+	}
+	if err != nil || params.ID == "" {
+		rest.ShowErrorBody(w, requestBody, fmt.Sprintf("ctl/manager:"+
+			" invalid hibernation rate limit request, err: %v", err),
+			http.StatusBadRequest)
+		return
+	}
+
+	err = h.m.SetHibernationRateLimit(params)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == service.ErrNotFound {
+			code = http.StatusNotFound
+		}
+		rest.ShowErrorBody(w, requestBody, fmt.Sprintf("ctl/manager:"+
+			" could not set hibernation rate limit, err: %v", err), code)
+		return
+	}
+
+	rest.MustEncode(w, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
+
+// ------------------------------------------------
+
+// A DefragmentedUtilizationHook determines projected "defragmented"
+// utilization stats for the nodes belonging to the service, keyed by
+// node and then by stat, such as the stats of a single resource.
+type DefragmentedUtilizationHook func(nodeDefs *cbgt.NodeDefs) (
 	*service.DefragmentedUtilizationInfo, error)
 
+var defragmentedUtilizationHooksM sync.RWMutex
+
+// defragmentedUtilizationHooks is a registry of the
+// DefragmentedUtilizationHook's, keyed by name.
+var defragmentedUtilizationHooks = map[string]DefragmentedUtilizationHook{}
+
+// RegisterDefragmentedUtilizationHook allows applications to register
+// a named callback to contribute projected "defragmented" utilization
+// stats, so that the estimators of several subsystems (e.g., disk,
+// memory, cpu) can be layered.  This should be called only during the
+// init()'ialization phase of the process.  Registering a nil hook
+// unregisters the name.
+func RegisterDefragmentedUtilizationHook(name string,
+	hook DefragmentedUtilizationHook) {
+	defragmentedUtilizationHooksM.Lock()
+	if hook != nil {
+		defragmentedUtilizationHooks[name] = hook
+	} else {
+		delete(defragmentedUtilizationHooks, name)
+	}
+	defragmentedUtilizationHooksM.Unlock()
+}
+
+// DefragmentedUtilizationHookNames returns the sorted names of the
+// registered DefragmentedUtilizationHook's.
+func DefragmentedUtilizationHookNames() []string {
+	defragmentedUtilizationHooksM.RLock()
+	rv := make([]string, 0, len(defragmentedUtilizationHooks))
+	for name := range defragmentedUtilizationHooks {
+		rv = append(rv, name)
+	}
+	defragmentedUtilizationHooksM.RUnlock()
+
+	sort.Strings(rv)
+
+	return rv
+}
+
+// mergeDefragmentedUtilization merges the per-node stats of the src
+// into the dst, where a stat of the src replaces the same stat of the
+// dst.
+func mergeDefragmentedUtilization(dst, src service.DefragmentedUtilizationInfo) {
+	for node, stats := range src {
+		if dst[node] == nil {
+			dst[node] = map[string]interface{}{}
+		}
+		for k, v := range stats {
+			dst[node][k] = v
+		}
+	}
+}
+
+// GetDefragmentedUtilization returns the built-in
+// cbgt.CalcDefragmentedUtilization() stats, merged with the stats of
+// the registered DefragmentedUtilizationHook's in the order of their
+// names, so that a later hook overrides a stat of an earlier one.
 func (m *CtlMgr) GetDefragmentedUtilization() (
 	*service.DefragmentedUtilizationInfo, error) {
-	if DefragmentedUtilizationHook != nil {
-		nodeDefsKnown, _, err := cbgt.CfgGetNodeDefs(m.ctl.cfg, cbgt.NODE_DEFS_KNOWN)
+	nodeDefsKnown, _, err := cbgt.CfgGetNodeDefs(m.ctl.cfg, cbgt.NODE_DEFS_KNOWN)
+	if err != nil {
+		return nil, err
+	}
+
+	planPIndexes, _, err := cbgt.CfgGetPlanPIndexes(m.ctl.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	options := m.ctl.optionsMgr
+	if m.ctl.optionsCtl.Manager != nil {
+		options = m.ctl.getManagerOptions()
+	}
+
+	rv := service.DefragmentedUtilizationInfo(
+		cbgt.CalcDefragmentedUtilization(nodeDefsKnown, planPIndexes, options))
+
+	for _, name := range DefragmentedUtilizationHookNames() {
+		defragmentedUtilizationHooksM.RLock()
+		hook := defragmentedUtilizationHooks[name]
+		defragmentedUtilizationHooksM.RUnlock()
+		if hook == nil {
+			continue // Unregistered meanwhile.
+		}
+
+		info, err := hook(nodeDefsKnown)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("ctl/manager: GetDefragmentedUtilization,"+
+				" hook: %s, err: %v", name, err)
+		}
+		if info != nil {
+			mergeDefragmentedUtilization(rv, *info)
 		}
-		return DefragmentedUtilizationHook(nodeDefsKnown)
 	}
 
-	return nil, nil
+	return &rv, nil
 }
 
 // ------------------------------------------------
 
-// PreparePause just updates the task lists with the prepared task
-// type along with some basic validations.
+// HibernationPrepareIndexHook allows applications to register a
+// callback to validate an index definition of a bucket during the
+// prepare phase of a bucket pause, where an error fails the prepare.
+// This should be set only during the init()'ialization phase of the
+// process.
+var HibernationPrepareIndexHook func(task string, indexDef *cbgt.IndexDef) error
+
+var errInvalidRemotePath = fmt.Errorf("invalid remote path")
+
+// A hibernationPrepare tracks the asynchronous prepare phase of a
+// bucket pause or resume, whose progress is reported through the
+// prepared task.
+type hibernationPrepare struct {
+	taskId string
+	stopCh chan struct{} // Closed when the prepared task is canceled.
+	doneCh chan struct{} // Closed when the prepare phase is done.
+	err    error         // Valid only after doneCh is closed.
+}
+
+// A hibernationPrepareStep is a named step of the prepare phase, which
+// has its own progress entry.
+type hibernationPrepareStep struct {
+	name string
+	run  func() error
+}
+
+// PreparePause validates the pause request and adds a prepared task
+// to the task lists, where the per-index validations of the prepare
+// phase run asynchronously, reporting their progress through the
+// prepared task.
 func (m *CtlMgr) PreparePause(params service.PauseParams) (err error) {
 	log.Printf("ctl/manager: PreparePause, params: %v", params)
 
+	defer func() {
+		m.audit("PreparePause", "prepare bucket pause", params, err)
+	}()
+
+	var async bool
+
+	m.mu.Lock()
+	defer func() {
+		m.mu.Unlock()
+		if err == nil && !async {
+			m.ctl.onSuccessfulPrepare(false)
+		}
+	}()
+
+	err = m.checkPrepareConflictsLOCKED("PreparePause")
+	if err != nil {
+		return err
+	}
+
+	indexDefs, _, err := cbgt.CfgGetIndexDefs(m.ctl.cfg)
+	if err != nil {
+		return fmt.Errorf("ctl/manager: failed in the prepare phase for"+
+			" bucket %s: %v", params.Bucket, err)
+	}
+
+	err = m.ctl.optionsCtl.Manager.HibernationPrepareUtilWithPath(cbgt.HIBERNATE_TASK,
+		params.Bucket, params.RemotePath, params.BlobStorageRegion,
+		params.RateLimit, false)
+	if err != nil {
+		return fmt.Errorf("ctl/manager: failed in the prepare phase for"+
+			" bucket %s: %v", params.Bucket, err)
+	}
+
+	var steps []hibernationPrepareStep
+
+	if indexDefs != nil {
+		for _, indexDef := range indexDefs.IndexDefs {
+			if indexDef.SourceName != params.Bucket {
+				continue
+			}
+
+			indexDef := indexDef
+			steps = append(steps, hibernationPrepareStep{
+				name: "index:" + indexDef.Name,
+				run: func() error {
+					return validateIndexForHibernation(cbgt.HIBERNATE_TASK,
+						indexDef)
+				},
+			})
+		}
+	}
+
+	async = m.startHibernationPrepareLOCKED("prepare:"+params.ID,
+		"prepare pause handler", map[string]interface{}{
+			"preparePause": params,
+		}, steps, nil)
+
+	log.Printf("ctl/manager: PreparePause, started, async: %t", async)
+
+	return nil
+}
+
+// PrepareResume validates the resume request and adds a prepared task
+// to the task lists, where the remote path checks of a dry run run
+// asynchronously, reporting their progress through the prepared task.
+func (m *CtlMgr) PrepareResume(params service.ResumeParams) (err error) {
+	log.Printf("ctl/manager: PrepareResume, params: %v", params)
+
+	defer func() {
+		m.audit("PrepareResume", "prepare bucket resume", params, err)
+	}()
+
+	var async bool
+
 	m.mu.Lock()
 	defer func() {
 		m.mu.Unlock()
-		if err == nil {
+		if err == nil && !async {
 			m.ctl.onSuccessfulPrepare(false)
 		}
 	}()
 
+	err = m.checkPrepareConflictsLOCKED("PrepareResume")
+	if err != nil {
+		return err
+	}
+
+	err = m.ctl.optionsCtl.Manager.HibernationPrepareUtilWithPath(cbgt.UNHIBERNATE_TASK,
+		params.Bucket, params.RemotePath, params.BlobStorageRegion,
+		params.RateLimit, params.DryRun)
+	if err != nil {
+		return fmt.Errorf("ctl/manager: failed in the prepare phase for"+
+			" bucket %s: %v", params.Bucket, err)
+	}
+
+	var steps []hibernationPrepareStep
+	var onDone func(task *service.Task, err error)
+
+	if params.DryRun {
+		steps = append(steps, hibernationPrepareStep{
+			name: "remotePath",
+			run: func() error {
+				if !hibernate.CheckIfRemotePathIsValid(params.RemotePath) {
+					return errInvalidRemotePath
+				}
+				return nil
+			},
+		})
+
+		// Task marked as not resumable if the path is invalid.
+		onDone = func(task *service.Task, err error) {
+			if err == errInvalidRemotePath {
+				task.Status = service.TaskStatusCannotResume
+				task.ErrorMessage = err.Error()
+			}
+		}
+	}
+
+	async = m.startHibernationPrepareLOCKED("prepare:"+params.ID,
+		"prepare resume handler", map[string]interface{}{
+			"prepareResume": params,
+		}, steps, onDone)
+
+	log.Printf("ctl/manager: PrepareResume, started, async: %t", async)
+
+	return nil
+}
+
+func (m *CtlMgr) checkPrepareConflictsLOCKED(op string) error {
+	m.expirePreparedTasksLOCKED()
+
 	for _, taskHandle := range m.tasks.taskHandles {
 		if taskHandle.task.Type == service.TaskTypePrepared ||
 			taskHandle.task.Type == service.TaskTypeBucketPause ||
@@ -895,18 +1563,49 @@ func (m *CtlMgr) PreparePause(params service.PauseParams) (err error) {
 			// NOTE: If there's an existing rebalance, preparation,
 			// bucket pause/resume task, even if it's done, then treat
 			// as a conflict, as the caller should cancel them all first.
-			log.Errorf("ctl/manager: PreparePause, conflicts with task type: %s,"+
-				" err: %v", taskHandle.task.Type, service.ErrConflict)
-			err = service.ErrConflict
-			return err
+			log.Errorf("ctl/manager: %s, conflicts with task type: %s,"+
+				" err: %v", op, taskHandle.task.Type, service.ErrConflict)
+			return service.ErrConflict
 		}
 	}
 
-	err = m.ctl.optionsCtl.Manager.HibernationPrepareUtil(cbgt.HIBERNATE_TASK, params.Bucket,
-		params.BlobStorageRegion, params.RateLimit, false)
-	if err != nil {
-		return fmt.Errorf("ctl/manager: failed in the prepare phase for bucket %s: %v",
-			params.Bucket, err)
+	return nil
+}
+
+func validateIndexForHibernation(task string, indexDef *cbgt.IndexDef) error {
+	if pindexImplType, exists := cbgt.PIndexImplTypes[indexDef.Type]; !exists ||
+		pindexImplType == nil {
+		return fmt.Errorf("index: %s, unknown index type: %s",
+			indexDef.Name, indexDef.Type)
+	}
+
+	if HibernationPrepareIndexHook != nil {
+		err := HibernationPrepareIndexHook(task, indexDef)
+		if err != nil {
+			return fmt.Errorf("index: %s, err: %v", indexDef.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// startHibernationPrepareLOCKED adds a running prepared task to the
+// task lists and kicks off its prepare steps, if any, in the
+// background, returning true when it did so.  A prepared task without
+// steps is added as already prepared.  The optional onDone callback may
+// adjust the prepared task once the steps are done.
+func (m *CtlMgr) startHibernationPrepareLOCKED(taskId, description string,
+	extra map[string]interface{}, steps []hibernationPrepareStep,
+	onDone func(task *service.Task, err error)) bool {
+	hp := &hibernationPrepare{
+		taskId: taskId,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+
+	progress := 0.0
+	if len(steps) == 0 {
+		progress = 100.0
 	}
 
 	revNum := m.allocRevNumLOCKED(0)
@@ -918,21 +1617,23 @@ func (m *CtlMgr) PreparePause(params service.PauseParams) (err error) {
 			startTime: time.Now(),
 			task: &service.Task{
 				Rev:              EncodeRev(revNum),
-				ID:               "prepare:" + params.ID,
+				ID:               taskId,
 				Type:             service.TaskTypePrepared,
 				Status:           service.TaskStatusRunning,
 				IsCancelable:     true,
-				Progress:         100.0,
+				Progress:         progress,
 				DetailedProgress: nil,
-				Description:      "prepare pause handler",
+				Description:      description,
 				ErrorMessage:     "",
-				Extra: map[string]interface{}{
-					"preparePause": params,
-				},
+				Extra:            extra,
 			},
-			stop: func() {
-				log.Printf("ctl/manager: stop preparePause: %v",
-					params)
+			stop: func() { // Invoked with m.mu held.
+				log.Printf("ctl/manager: stop %s", taskId)
+
+				close(hp.stopCh)
+				if m.prepare == hp {
+					m.prepare = nil
+				}
 
 				m.ctl.StopHibernationTask()
 			},
@@ -942,97 +1643,122 @@ func (m *CtlMgr) PreparePause(params service.PauseParams) (err error) {
 		s.taskHandles = taskHandlesNext
 	})
 
-	log.Printf("ctl/manager: PreparePause, done")
+	if len(steps) == 0 {
+		return false
+	}
 
-	return nil
-}
+	m.prepare = hp
 
-// PrepareResume just updates the task lists with the prepared task
-// type along with some basic validations.
-func (m *CtlMgr) PrepareResume(params service.ResumeParams) (err error) {
-	log.Printf("ctl/manager: PrepareResume, params: %v", params)
+	go m.runHibernationPrepare(hp, steps, onDone)
 
-	m.mu.Lock()
-	defer func() {
-		m.mu.Unlock()
-		if err == nil {
-			m.ctl.onSuccessfulPrepare(false)
-		}
-	}()
+	return true
+}
 
-	for _, taskHandle := range m.tasks.taskHandles {
-		if taskHandle.task.Type == service.TaskTypePrepared ||
-			taskHandle.task.Type == service.TaskTypeBucketPause ||
-			taskHandle.task.Type == service.TaskTypeBucketResume ||
-			taskHandle.task.Type == service.TaskTypeRebalance {
-			// NOTE: If there's an existing rebalance, preparation,
-			// bucket pause/resume task, even if it's done, then treat
-			// as a conflict, as the caller should cancel them all first.
-			log.Errorf("ctl/manager: PrepareResume, conflicts with task type: %s,"+
-				" err: %v", taskHandle.task.Type, service.ErrConflict)
-			err = service.ErrConflict
-			return err
-		}
+// runHibernationPrepare runs the prepare steps one at a time, where
+// each step's progress entry goes from 0 to 100 when it's done.  The
+// prepare phase is treated as successful only once all of its steps
+// are done.
+func (m *CtlMgr) runHibernationPrepare(hp *hibernationPrepare,
+	steps []hibernationPrepareStep, onDone func(task *service.Task, err error)) {
+	progressEntries := make(map[string]float64, len(steps))
+	for _, step := range steps {
+		progressEntries[step.name] = 0.0
 	}
 
-	revNum := m.allocRevNumLOCKED(0)
+	var err error
 
-	newTaskHandle := &taskHandle{
-		startTime: time.Now(),
-		task: &service.Task{
-			Rev:              EncodeRev(revNum),
-			ID:               "prepare:" + params.ID,
-			Type:             service.TaskTypePrepared,
-			Status:           service.TaskStatusRunning,
-			IsCancelable:     true,
-			Progress:         100.0,
-			DetailedProgress: nil,
-			Description:      "prepare resume handler",
-			ErrorMessage:     "",
-			Extra: map[string]interface{}{
-				"prepareResume": params,
-			},
-		},
-		stop: func() {
-			log.Printf("ctl/manager: stop prepareResume: %v",
-				params)
+	for _, step := range steps {
+		select {
+		case <-hp.stopCh:
+			err = service.ErrCanceled
+		default:
+			err = step.run()
+		}
+		if err != nil {
+			log.Warnf("ctl/manager: prepare, taskId: %s, step: %s, err: %v",
+				hp.taskId, step.name, err)
+			break
+		}
 
-			m.ctl.StopHibernationTask()
-		}}
+		progressEntries[step.name] = 100.0
+
+		m.updateHibernationProgress(hp.taskId, progressEntries, nil, nil)
+	}
 
-	err = m.ctl.optionsCtl.Manager.HibernationPrepareUtil(cbgt.UNHIBERNATE_TASK, params.Bucket,
-		params.BlobStorageRegion, params.RateLimit, params.DryRun)
 	if err != nil {
-		return fmt.Errorf("ctl/manager: failed in the prepare phase for bucket %s: %v",
-			params.Bucket, err)
+		m.ctl.optionsCtl.Manager.ResetBucketTrackedForHibernation()
 	}
 
-	if params.DryRun {
-		// Task marked as failed if the path is invalid.
-		if !hibernate.CheckIfRemotePathIsValidHook(params.RemotePath) {
-			newTaskHandle.task.Status = service.TaskStatusCannotResume
-			newTaskHandle.task.ErrorMessage = "invalid remote path"
-		}
+	m.mu.Lock()
+
+	hp.err = err
+	close(hp.doneCh)
+
+	// The final status is applied directly, rather than through the
+	// taskProgressMailbox, so that it's applied before returning.
+	m.updateTaskLOCKED(hp.taskId, service.TaskTypePrepared,
+		func(task *service.Task) {
+			task.Progress = 100.0
+			if err != nil {
+				task.Status = service.TaskStatusFailed
+				setTaskErrors(task, []error{err})
+			}
+			if onDone != nil {
+				onDone(task, err)
+			}
+		})
+
+	m.mu.Unlock()
+
+	if err == nil {
+		m.ctl.onSuccessfulPrepare(false)
 	}
 
-	taskHandlesNext := append([]*taskHandle(nil),
-		m.tasks.taskHandles...)
-	taskHandlesNext = append(taskHandlesNext, newTaskHandle)
+	log.Printf("ctl/manager: prepare, taskId: %s, done, err: %v",
+		hp.taskId, err)
+}
 
-	m.updateTasksLOCKED(func(s *tasks) {
-		s.taskHandles = taskHandlesNext
-	})
+// waitForHibernationPrepare waits for the prepare phase of the
+// prepared task, if any, to be done, returning its error.
+func (m *CtlMgr) waitForHibernationPrepare() error {
+	m.mu.Lock()
+	hp := m.prepare
+	m.mu.Unlock()
 
-	log.Printf("ctl/manager: PrepareResume, done")
+	if hp == nil {
+		return nil
+	}
+
+	select {
+	case <-hp.doneCh:
+	case <-time.After(m.ctl.hibernationPrepareTimeout()):
+		return fmt.Errorf("ctl/manager: timeout waiting for the prepare"+
+			" phase of task: %s", hp.taskId)
+	}
+
+	if hp.err != nil {
+		return fmt.Errorf("ctl/manager: prepare phase of task: %s failed,"+
+			" err: %v", hp.taskId, hp.err)
+	}
 
 	return nil
 }
 
 // Pause is the starting point for pause operation.
 // It adds pause tasks to the tasks list and updates it.
-func (m *CtlMgr) Pause(params service.PauseParams) error {
+func (m *CtlMgr) Pause(params service.PauseParams) (err error) {
 	log.Printf("ctl/manager: Pause, params: %v", params)
 
+	defer func() {
+		m.audit("Pause", "pause bucket", params, err)
+	}()
+
+	err = m.waitForHibernationPrepare()
+	if err != nil {
+		log.Errorf("ctl/manager: Pause, err: %v", err)
+		return err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -1057,6 +1783,8 @@ func (m *CtlMgr) Pause(params service.PauseParams) error {
 
 	taskHandlesNext = append(taskHandlesNext, th)
 
+	m.prepare = nil
+
 	m.updateTasksLOCKED(func(s *tasks) {
 		s.taskHandles = taskHandlesNext
 	})
@@ -1072,14 +1800,16 @@ func (m *CtlMgr) pauseTaskHandleLOCKED(
 
 	taskId := string(hibernate.OperationType(cbgt.HIBERNATE_TASK)) + ":" + params.ID
 
-	onProgress := func(progressEntries map[string]float64, errs []error) {
-		m.updateHibernationProgress(taskId, progressEntries, errs)
+	onProgress := func(progressEntries map[string]float64,
+		pindexNodeProgress hibernate.PIndexNodeProgress, errs []error) {
+		m.updateHibernationProgress(taskId, progressEntries,
+			pindexNodeProgress, errs)
 	}
 
 	params.RemotePath = string(hibernate.OperationType(cbgt.HIBERNATE_TASK)) + ":" +
 		params.RemotePath
-	err := m.ctl.startHibernation(false, params.Bucket, params.RemotePath,
-		hibernate.OperationType(cbgt.HIBERNATE_TASK), onProgress)
+	_, err := m.ctl.startHibernation(false, params.Bucket, params.RemotePath,
+		nil, hibernate.OperationType(cbgt.HIBERNATE_TASK), onProgress)
 	if err != nil {
 		return nil, err
 	}
@@ -1113,9 +1843,92 @@ func (m *CtlMgr) pauseTaskHandleLOCKED(
 	return th, nil
 }
 
-func (m *CtlMgr) Resume(params service.ResumeParams) error {
+func (m *CtlMgr) Resume(params service.ResumeParams) (err error) {
 	log.Printf("ctl/manager: Resume, params: %v", params)
 
+	defer func() {
+		m.audit("Resume", "resume bucket", params, err)
+	}()
+
+	return m.resume(params, nil)
+}
+
+// ResumeIndexes resumes only the selected indexes of a hibernated
+// bucket, so that a few indexes can be restored quickly without
+// restoring all the bucket's indexes.
+func (m *CtlMgr) ResumeIndexes(params ResumeIndexesParams) (err error) {
+	log.Printf("ctl/manager: ResumeIndexes, params: %v, indexNames: %v",
+		params.ResumeParams, params.IndexNames)
+
+	defer func() {
+		m.audit("Resume", "resume bucket indexes", params, err)
+	}()
+
+	indexNames, err := normalizeIndexNames(params.IndexNames)
+	if err != nil {
+		return err
+	}
+
+	err = m.waitForHibernationPrepare()
+	if err != nil {
+		log.Errorf("ctl/manager: ResumeIndexes, err: %v", err)
+		return err
+	}
+
+	ctx, _ := m.ctl.optionsCtl.Manager.GetHibernationContext()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	err = hibernate.CheckIndexesToResume(
+		m.ctl.optionsCtl.Manager.GetObjStoreClient(), ctx,
+		resumeRemotePath(params.RemotePath), indexNames)
+	if err != nil {
+		log.Errorf("ctl/manager: ResumeIndexes, err: %v", err)
+		return err
+	}
+
+	return m.resume(params.ResumeParams, indexNames)
+}
+
+// normalizeIndexNames returns the sorted, de-duplicated index names of
+// a resume of selected indexes, erroring when there are none.
+func normalizeIndexNames(indexNames []string) ([]string, error) {
+	seen := map[string]bool{}
+	rv := make([]string, 0, len(indexNames))
+	for _, indexName := range indexNames {
+		indexName = strings.TrimSpace(indexName)
+		if indexName == "" {
+			return nil, fmt.Errorf("ctl/manager: ResumeIndexes," +
+				" empty index name")
+		}
+		if !seen[indexName] {
+			seen[indexName] = true
+			rv = append(rv, indexName)
+		}
+	}
+	if len(rv) == 0 {
+		return nil, fmt.Errorf("ctl/manager: ResumeIndexes, no index names")
+	}
+	sort.Strings(rv)
+	return rv, nil
+}
+
+// resumeRemotePath returns the remote path of a resume as given to the
+// hibernation manager, which is prefixed by the operation type.
+func resumeRemotePath(remotePath string) string {
+	return string(hibernate.OperationType(cbgt.UNHIBERNATE_TASK)) + ":" +
+		remotePath
+}
+
+func (m *CtlMgr) resume(params service.ResumeParams,
+	indexNames []string) error {
+	err := m.waitForHibernationPrepare()
+	if err != nil {
+		log.Errorf("ctl/manager: Resume, err: %v", err)
+		return err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -1131,7 +1944,7 @@ func (m *CtlMgr) Resume(params service.ResumeParams) error {
 		}
 	}
 
-	th, err := m.resumeTaskHandleLOCKED(params)
+	th, err := m.resumeTaskHandleLOCKED(params, indexNames)
 	if err != nil {
 		log.Errorf("ctl/manager: Resume, err: %v", err)
 		return err
@@ -1140,6 +1953,8 @@ func (m *CtlMgr) Resume(params service.ResumeParams) error {
 
 	taskHandlesNext = append(taskHandlesNext, th)
 
+	m.prepare = nil
+
 	m.updateTasksLOCKED(func(s *tasks) {
 		s.taskHandles = taskHandlesNext
 	})
@@ -1149,8 +1964,8 @@ func (m *CtlMgr) Resume(params service.ResumeParams) error {
 	return nil
 }
 
-func (m *CtlMgr) resumeTaskHandleLOCKED(
-	params service.ResumeParams) (*taskHandle, error) {
+func (m *CtlMgr) resumeTaskHandleLOCKED(params service.ResumeParams,
+	indexNames []string) (*taskHandle, error) {
 	log.Printf("ctl/manager: resumeTaskHandleLOCKED, params: %v", params)
 
 	taskId := string(hibernate.OperationType(cbgt.UNHIBERNATE_TASK)) + ":" + params.ID
@@ -1180,14 +1995,19 @@ func (m *CtlMgr) resumeTaskHandleLOCKED(
 		},
 	}
 
-	onProgress := func(progressEntries map[string]float64, errs []error) {
-		m.updateHibernationProgress(taskId, progressEntries, errs)
+	if len(indexNames) > 0 {
+		th.task.Extra["indexNames"] = indexNames
 	}
 
-	params.RemotePath = string(hibernate.OperationType(cbgt.UNHIBERNATE_TASK)) + ":" +
-		params.RemotePath
-	err := m.ctl.startHibernation(params.DryRun, params.Bucket, params.RemotePath,
-		hibernate.OperationType(cbgt.UNHIBERNATE_TASK), onProgress)
+	onProgress := func(progressEntries map[string]float64,
+		pindexNodeProgress hibernate.PIndexNodeProgress, errs []error) {
+		m.updateHibernationProgress(taskId, progressEntries,
+			pindexNodeProgress, errs)
+	}
+
+	params.RemotePath = resumeRemotePath(params.RemotePath)
+	_, err := m.ctl.startHibernation(params.DryRun, params.Bucket, params.RemotePath,
+		indexNames, hibernate.OperationType(cbgt.UNHIBERNATE_TASK), onProgress)
 	if err != nil {
 		return nil, err
 	}
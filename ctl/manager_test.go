@@ -0,0 +1,441 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbauth/service"
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/hibernate"
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+)
+
+func testPrepareCtlMgr(t *testing.T, indexNames ...string) *CtlMgr {
+	cfg := cbgt.NewCfgMem()
+
+	indexDefs := cbgt.NewIndexDefs(cbgt.VERSION)
+	for _, indexName := range indexNames {
+		indexDefs.IndexDefs[indexName] = &cbgt.IndexDef{
+			Name:       indexName,
+			Type:       "blackhole",
+			SourceName: "b0",
+		}
+	}
+	_, err := cbgt.CfgSetIndexDefs(cfg, indexDefs, cbgt.CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected CfgSetIndexDefs to work, err: %v", err)
+	}
+
+	mgr := cbgt.NewManager(cbgt.VERSION, cfg, cbgt.NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	return NewCtlMgr(nil, &Ctl{
+		cfg:        cfg,
+		optionsCtl: CtlOptions{Manager: mgr},
+	})
+}
+
+func testPreparedTask(m *CtlMgr, taskId string) *service.Task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, th := range m.tasks.taskHandles {
+		if th.task.ID == taskId {
+			return th.task
+		}
+	}
+	return nil
+}
+
+func TestPreparePauseSyncErr(t *testing.T) {
+	prevClientHook := cbgt.HibernationClientHook
+	cbgt.HibernationClientHook = func(string) (objcli.Client, error) {
+		return nil, fmt.Errorf("no object store")
+	}
+	defer func() { cbgt.HibernationClientHook = prevClientHook }()
+
+	m := testPrepareCtlMgr(t, "i0")
+
+	err := m.PreparePause(service.PauseParams{ID: "p0", Bucket: "b0"})
+	if err == nil {
+		t.Fatalf("expected the prepare to fail")
+	}
+	if testPreparedTask(m, "prepare:p0") != nil {
+		t.Errorf("expected no prepared task")
+	}
+	if m.ctl.revNum != 0 {
+		t.Errorf("expected no successful prepare")
+	}
+}
+
+func TestPreparePauseAsync(t *testing.T) {
+	prevClientHook := cbgt.HibernationClientHook
+	cbgt.HibernationClientHook = func(string) (objcli.Client, error) {
+		return nil, nil
+	}
+	defer func() { cbgt.HibernationClientHook = prevClientHook }()
+
+	releaseCh := make(chan error)
+
+	HibernationPrepareIndexHook = func(task string,
+		indexDef *cbgt.IndexDef) error {
+		return <-releaseCh
+	}
+	defer func() { HibernationPrepareIndexHook = nil }()
+
+	for _, validateErr := range []error{nil, fmt.Errorf("boom")} {
+		m := testPrepareCtlMgr(t, "i0")
+
+		err := m.PreparePause(service.PauseParams{ID: "p0", Bucket: "b0"})
+		if err != nil {
+			t.Fatalf("expected the prepare to start, err: %v", err)
+		}
+
+		task := testPreparedTask(m, "prepare:p0")
+		if task == nil || task.Status != service.TaskStatusRunning ||
+			task.Progress >= 100.0 {
+			t.Fatalf("expected a running prepared task, got: %+v", task)
+		}
+		if m.ctl.revNum != 0 {
+			t.Fatalf("expected no successful prepare before the validations")
+		}
+
+		releaseCh <- validateErr
+
+		err = m.waitForHibernationPrepare()
+		if (err != nil) != (validateErr != nil) {
+			t.Fatalf("expected err: %v, got: %v", validateErr, err)
+		}
+
+		task = testPreparedTask(m, "prepare:p0")
+		m.ctl.m.Lock()
+		revNum := m.ctl.revNum
+		m.ctl.m.Unlock()
+
+		if validateErr == nil {
+			if task.Status != service.TaskStatusRunning ||
+				task.Progress != 100.0 || revNum != 1 {
+				t.Errorf("expected a prepared task, got: %+v, revNum: %d",
+					task, revNum)
+			}
+		} else {
+			if task.Status != service.TaskStatusFailed || revNum != 0 {
+				t.Errorf("expected a failed prepared task, got: %+v,"+
+					" revNum: %d", task, revNum)
+			}
+		}
+	}
+}
+
+func TestPrepareResumeDryRunAsync(t *testing.T) {
+	prevClientHook := cbgt.HibernationClientHook
+	cbgt.HibernationClientHook = func(string) (objcli.Client, error) {
+		return nil, nil
+	}
+	prevPathHook := hibernate.CheckIfRemotePathIsValidHook
+	validCh := make(chan bool)
+	hibernate.CheckIfRemotePathIsValidHook = func(string) bool {
+		return <-validCh
+	}
+	prevMgrTimeout := CtlMgrTimeout
+	CtlMgrTimeout = time.Millisecond
+	defer func() {
+		cbgt.HibernationClientHook = prevClientHook
+		hibernate.CheckIfRemotePathIsValidHook = prevPathHook
+		CtlMgrTimeout = prevMgrTimeout
+	}()
+
+	for _, valid := range []bool{true, false} {
+		m := testPrepareCtlMgr(t, "i0")
+
+		err := m.PrepareResume(service.ResumeParams{ID: "r0", Bucket: "b0",
+			RemotePath: "s3://bkt/r0", DryRun: true})
+		if err != nil {
+			t.Fatalf("expected the prepare to start, err: %v", err)
+		}
+
+		task := testPreparedTask(m, "prepare:r0")
+		if task == nil || task.Status != service.TaskStatusRunning ||
+			task.Progress >= 100.0 {
+			t.Fatalf("expected a running prepared task, got: %+v", task)
+		}
+
+		// The remote path check outlasts the CtlMgrTimeout, which
+		// doesn't apply to the wait for the prepare phase.
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			validCh <- valid
+		}()
+
+		err = m.waitForHibernationPrepare()
+		if (err != nil) == valid {
+			t.Fatalf("expected valid: %t, got err: %v", valid, err)
+		}
+
+		task = testPreparedTask(m, "prepare:r0")
+		if valid {
+			if task.Status != service.TaskStatusRunning ||
+				task.Progress != 100.0 {
+				t.Errorf("expected a prepared task, got: %+v", task)
+			}
+		} else {
+			if task.Status != service.TaskStatusCannotResume ||
+				task.ErrorMessage != errInvalidRemotePath.Error() {
+				t.Errorf("expected a not resumable task, got: %+v", task)
+			}
+		}
+	}
+}
+
+func TestGetIdealPlanDiffsCached(t *testing.T) {
+	m := testPrepareCtlMgr(t, "i0")
+	memberNodes := []CtlNode{{UUID: "n0"}}
+
+	_, _, err := m.ctl.getIdealPlanDiffs(memberNodes)
+	if err != nil {
+		t.Fatalf("expected getIdealPlanDiffs to work, err: %v", err)
+	}
+	key := m.ctl.idealPlanDiffsKey
+	if key == "" {
+		t.Fatalf("expected the diffs to be cached")
+	}
+
+	m.ctl.getIdealPlanDiffs(memberNodes)
+	if m.ctl.idealPlanDiffsKey != key {
+		t.Errorf("expected the cached diffs to be reused")
+	}
+
+	m.ctl.getIdealPlanDiffs(append(memberNodes, CtlNode{UUID: "n1"}))
+	if m.ctl.idealPlanDiffsKey == key {
+		t.Errorf("expected the member nodes to change the cached diffs")
+	}
+}
+
+func TestExpirePreparedTasks(t *testing.T) {
+	m := testPrepareCtlMgr(t)
+	m.ctl.optionsCtl.Manager.SetOption("preparedTaskTTLSecs", "1", false)
+
+	_, err := cbgt.CfgSetNodeDefs(m.ctl.cfg, cbgt.NODE_DEFS_WANTED,
+		cbgt.NewNodeDefs(cbgt.VERSION), cbgt.CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected CfgSetNodeDefs to work, err: %v", err)
+	}
+
+	change := service.TopologyChange{
+		ID:   "c0",
+		Type: service.TopologyChangeTypeRebalance,
+	}
+
+	// ageTask makes the prepared task older than the TTL.
+	ageTask := func() {
+		m.mu.Lock()
+		for _, th := range m.tasks.taskHandles {
+			th.startTime = th.startTime.Add(-2 * time.Second)
+		}
+		m.mu.Unlock()
+	}
+
+	err = m.PrepareTopologyChange(change)
+	if err != nil {
+		t.Fatalf("expected PrepareTopologyChange to work, err: %v", err)
+	}
+
+	// A prepared task within its TTL is kept.
+	taskList, err := m.GetTaskList(nil, nil)
+	if err != nil || len(taskList.Tasks) != 1 ||
+		taskList.Tasks[0].ID != "prepare:c0" {
+		t.Fatalf("expected the prepared task, got: %+v, err: %v",
+			taskList, err)
+	}
+	prepared := taskList.Tasks[0]
+
+	ageTask()
+
+	taskList, err = m.GetTaskList(nil, nil)
+	if err != nil || len(taskList.Tasks) != 0 {
+		t.Fatalf("expected the prepared task to be expired,"+
+			" got: %+v, err: %v", taskList, err)
+	}
+	if !m.wasCanceled(&prepared) {
+		t.Errorf("expected the expired task to be noted as canceled")
+	}
+
+	if err = m.StartTopologyChange(change); err != service.ErrNotFound {
+		t.Fatalf("expected the expired prepare to not start, err: %v", err)
+	}
+
+	// An expired prepare isn't started, even when the task list isn't
+	// polled in between.
+	err = m.PrepareTopologyChange(change)
+	if err != nil {
+		t.Fatalf("expected a re-prepare to work, err: %v", err)
+	}
+
+	ageTask()
+
+	if err = m.StartTopologyChange(change); err != service.ErrNotFound {
+		t.Fatalf("expected the expired prepare to not start, err: %v", err)
+	}
+	if testPreparedTask(m, "prepare:c0") != nil {
+		t.Fatalf("expected the prepared task to be removed")
+	}
+}
+
+func TestGetDefragmentedUtilizationHooks(t *testing.T) {
+	m := testPrepareCtlMgr(t)
+
+	nodeDefs := cbgt.NewNodeDefs(cbgt.VERSION)
+	nodeDefs.NodeDefs["n0"] = &cbgt.NodeDef{UUID: "n0", HostPort: "h0:1"}
+	nodeDefs.NodeDefs["n1"] = &cbgt.NodeDef{UUID: "n1", HostPort: "h1:1"}
+	_, err := cbgt.CfgSetNodeDefs(m.ctl.cfg, cbgt.NODE_DEFS_KNOWN,
+		nodeDefs, cbgt.CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected CfgSetNodeDefs to work, err: %v", err)
+	}
+
+	hook := func(info service.DefragmentedUtilizationInfo,
+		err error) DefragmentedUtilizationHook {
+		return func(nodeDefs *cbgt.NodeDefs) (
+			*service.DefragmentedUtilizationInfo, error) {
+			if len(nodeDefs.NodeDefs) != 2 {
+				return nil, fmt.Errorf("unexpected nodeDefs: %+v", nodeDefs)
+			}
+			if info == nil {
+				return nil, err
+			}
+			return &info, err
+		}
+	}
+
+	// The hooks are merged in name order, whatever their order of
+	// registration, so that "b" overrides the stats of "a".
+	RegisterDefragmentedUtilizationHook("b", hook(
+		service.DefragmentedUtilizationInfo{
+			"h0:1": {"cpu": 2},
+			"h1:1": {"pindexCount": 5.0},
+		}, nil))
+	RegisterDefragmentedUtilizationHook("a", hook(
+		service.DefragmentedUtilizationInfo{
+			"h0:1": {"cpu": 1, "diskBytes": int64(100)},
+			"h2:1": {"cpu": 3},
+		}, nil))
+	RegisterDefragmentedUtilizationHook("c", hook(nil, nil))
+	defer func() {
+		for _, name := range []string{"a", "b", "c"} {
+			RegisterDefragmentedUtilizationHook(name, nil)
+		}
+	}()
+
+	if names := DefragmentedUtilizationHookNames(); !reflect.DeepEqual(names,
+		[]string{"a", "b", "c"}) {
+		t.Fatalf("expected the sorted hook names, got: %v", names)
+	}
+
+	info, err := m.GetDefragmentedUtilization()
+	if err != nil {
+		t.Fatalf("expected GetDefragmentedUtilization to work, err: %v", err)
+	}
+
+	exp := service.DefragmentedUtilizationInfo{
+		"h0:1": {"pindexCount": 0.0, "cpu": 2, "diskBytes": int64(100)},
+		"h1:1": {"pindexCount": 5.0},
+		"h2:1": {"cpu": 3},
+	}
+	if !reflect.DeepEqual(*info, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, *info)
+	}
+
+	// A failing hook fails the whole request.
+	RegisterDefragmentedUtilizationHook("c", hook(nil, fmt.Errorf("boom")))
+	if _, err = m.GetDefragmentedUtilization(); err == nil {
+		t.Errorf("expected a failing hook to fail the request")
+	}
+
+	// Without the hooks, only the built-in stats remain.
+	for _, name := range []string{"a", "b", "c"} {
+		RegisterDefragmentedUtilizationHook(name, nil)
+	}
+	if names := DefragmentedUtilizationHookNames(); len(names) != 0 {
+		t.Fatalf("expected the hooks to be unregistered, got: %v", names)
+	}
+
+	info, err = m.GetDefragmentedUtilization()
+	if err != nil {
+		t.Fatalf("expected GetDefragmentedUtilization to work, err: %v", err)
+	}
+	exp = service.DefragmentedUtilizationInfo{
+		"h0:1": {"pindexCount": 0.0},
+		"h1:1": {"pindexCount": 0.0},
+	}
+	if !reflect.DeepEqual(*info, exp) {
+		t.Errorf("expected the built-in stats: %+v, got: %+v", exp, *info)
+	}
+}
+
+func TestIntManagerOptions(t *testing.T) {
+	m := testPrepareCtlMgr(t)
+	mgr := m.ctl.optionsCtl.Manager
+
+	if m.ctl.mgrTimeout() != CtlMgrTimeout ||
+		m.ctl.minWakeupInterval() != CtlMgrMinWakeupInterval ||
+		m.ctl.hibernationPrepareTimeout() != CtlHibernationPrepareTimeout {
+		t.Fatalf("expected the defaults")
+	}
+
+	m.ctl.optionsCtl.MgrTimeout = 5 * time.Second
+	m.ctl.optionsCtl.ProgressCoalesceInterval = -1
+	if m.ctl.mgrTimeout() != 5*time.Second ||
+		m.ctl.minWakeupInterval() != -1 {
+		t.Fatalf("expected the CtlOptions to override the defaults")
+	}
+
+	// The manager options override the CtlOptions, and are read on
+	// each use, so that they can be changed at runtime.
+	for _, kv := range [][2]string{
+		{"ctlMgrTimeoutSecs", "7"},
+		{"ctlProgressCoalesceIntervalMS", "30"},
+		{"ctlHibernationPrepareTimeoutSecs", "9"},
+	} {
+		mgr.SetOption(kv[0], kv[1], false)
+	}
+	if m.ctl.mgrTimeout() != 7*time.Second ||
+		m.ctl.minWakeupInterval() != 30*time.Millisecond ||
+		m.ctl.hibernationPrepareTimeout() != 9*time.Second {
+		t.Fatalf("expected the manager options to be used")
+	}
+
+	// An invalid or non-positive value is ignored.
+	mgr.SetOption("ctlMgrTimeoutSecs", "x", false)
+	mgr.SetOption("ctlHibernationPrepareTimeoutSecs", "0", false)
+	if m.ctl.mgrTimeout() != 5*time.Second ||
+		m.ctl.hibernationPrepareTimeout() != CtlHibernationPrepareTimeout {
+		t.Fatalf("expected an invalid option to be ignored")
+	}
+
+	// A changed timeout applies to the next long-poll.
+	mgr.SetOption("ctlMgrTimeoutSecs", "1", false)
+
+	taskList, err := m.GetTaskList(nil, nil)
+	if err != nil {
+		t.Fatalf("expected GetTaskList to work, err: %v", err)
+	}
+
+	start := time.Now()
+	_, err = m.GetTaskList(taskList.Rev, nil)
+	if err != nil {
+		t.Fatalf("expected the long-poll to time out, err: %v", err)
+	}
+	if d := time.Since(start); d < time.Second || d > 5*time.Second {
+		t.Errorf("expected the long-poll to take the 1s timeout, took: %v", d)
+	}
+}
@@ -0,0 +1,222 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/cbauth/service"
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+)
+
+// ctlMetrics are the ctl and rebalance metrics, which are kept apart
+// from the process-wide cbgt.MetricsProvider so that they're always
+// available in the Prometheus exposition format.
+type ctlMetrics struct {
+	*cbgt.PrometheusMetricsProvider
+
+	m         sync.Mutex
+	prevTime  time.Time
+	prevBytes map[string]limiterBytes      // Keyed by limiter name.
+	prevTasks map[string]cbgt.MetricLabels // Keyed by "type/status".
+}
+
+// limiterBytes is a sample of the bytes transferred by a limiter.
+type limiterBytes struct {
+	limiter *cbgt.TransferRateLimiter
+	bytes   uint64
+}
+
+func newCtlMetrics() *ctlMetrics {
+	return &ctlMetrics{
+		PrometheusMetricsProvider: cbgt.NewPrometheusMetricsProvider(),
+		prevBytes:                 map[string]limiterBytes{},
+		prevTasks:                 map[string]cbgt.MetricLabels{},
+	}
+}
+
+// ctlMetricsCfg is a Cfg wrapper that observes the latencies of the
+// Cfg operations of the ctl and of its rebalancer.
+type ctlMetricsCfg struct {
+	cbgt.Cfg
+
+	get, set, del cbgt.MetricsHistogram
+}
+
+func newCtlMetricsCfg(cfg cbgt.Cfg, metrics *ctlMetrics) cbgt.Cfg {
+	if cfg == nil {
+		return nil
+	}
+
+	h := func(op string) cbgt.MetricsHistogram {
+		return metrics.Histogram("cbgt_ctl_cfg_op_seconds",
+			cbgt.MetricLabels{"op": op})
+	}
+
+	return &ctlMetricsCfg{Cfg: cfg, get: h("get"), set: h("set"), del: h("del")}
+}
+
+func (c *ctlMetricsCfg) Get(key string, cas uint64) (
+	val []byte, casSuccess uint64, err error) {
+	startTime := time.Now()
+	val, casSuccess, err = c.Cfg.Get(key, cas)
+	c.get.Observe(time.Since(startTime).Seconds())
+	return val, casSuccess, err
+}
+
+func (c *ctlMetricsCfg) Set(key string, val []byte, cas uint64) (
+	casSuccess uint64, err error) {
+	startTime := time.Now()
+	casSuccess, err = c.Cfg.Set(key, val, cas)
+	c.set.Observe(time.Since(startTime).Seconds())
+	return casSuccess, err
+}
+
+func (c *ctlMetricsCfg) Del(key string, cas uint64) error {
+	startTime := time.Now()
+	err := c.Cfg.Del(key, cas)
+	c.del.Observe(time.Since(startTime).Seconds())
+	return err
+}
+
+// ------------------------------------------------
+
+// PublishMetrics refreshes the gauges of the ctl and rebalance
+// metrics, and is invoked before the metrics are written.
+func (m *CtlMgr) PublishMetrics() {
+	metrics := m.ctl.metrics
+
+	m.mu.Lock()
+	tasks := m.getTaskListLOCKED().Tasks
+	m.mu.Unlock()
+
+	m.ctl.m.Lock()
+	r := m.ctl.r
+	changingTopology := m.ctl.ctlChangeTopology != nil
+	movingPartitionsCount := m.ctl.movingPartitionsCount
+	m.ctl.m.Unlock()
+
+	var rebalanceProgress float64
+	taskCounts := map[string]int{}
+	taskLabels := map[string]cbgt.MetricLabels{}
+
+	for _, task := range tasks {
+		if task.Type == service.TaskTypeRebalance &&
+			task.Status == service.TaskStatusRunning {
+			rebalanceProgress = task.Progress
+		}
+
+		k := string(task.Type) + "/" + string(task.Status)
+		taskCounts[k]++
+		taskLabels[k] = cbgt.MetricLabels{
+			"type":   string(task.Type),
+			"status": string(task.Status),
+		}
+	}
+
+	metrics.Gauge("cbgt_ctl_rebalance_progress", nil).Set(rebalanceProgress)
+
+	var activeMoves int
+	if changingTopology && r != nil {
+		activeMoves = r.ActivePartitionMovesCount()
+	}
+	metrics.Gauge("cbgt_ctl_partition_moves_active", nil).
+		Set(float64(activeMoves))
+	metrics.Gauge("cbgt_ctl_partition_moves_total", nil).
+		Set(float64(movingPartitionsCount))
+
+	metrics.m.Lock()
+	defer metrics.m.Unlock()
+
+	// Zero the counts of the tasks that have gone away, as gauges
+	// otherwise keep their last value.
+	for k, labels := range metrics.prevTasks {
+		if _, exists := taskCounts[k]; !exists {
+			metrics.Gauge("cbgt_ctl_tasks", labels).Set(0)
+		}
+	}
+	for k, count := range taskCounts {
+		metrics.Gauge("cbgt_ctl_tasks", taskLabels[k]).Set(float64(count))
+	}
+	metrics.prevTasks = taskLabels
+
+	mgr := m.ctl.optionsCtl.Manager
+	if mgr == nil {
+		return
+	}
+
+	now := time.Now()
+	secs := now.Sub(metrics.prevTime).Seconds()
+
+	for _, x := range []struct {
+		name    string
+		limiter *cbgt.TransferRateLimiter
+	}{
+		{"transfer", mgr.TransferRateLimiter()},
+		{"hibernation", mgr.HibernationRateLimiter()},
+	} {
+		if x.limiter == nil {
+			continue
+		}
+
+		totBytes := atomic.LoadUint64(&x.limiter.TotBytes)
+
+		// A new limiter, as for a new hibernation, counts from 0.
+		prev, exists := metrics.prevBytes[x.name]
+		var prevBytes uint64
+		if prev.limiter == x.limiter {
+			prevBytes = prev.bytes
+		}
+
+		if totBytes > prevBytes {
+			metrics.Counter("cbgt_ctl_"+x.name+"_bytes_total", nil).
+				Add(totBytes - prevBytes)
+		}
+
+		var bytesPerSec float64
+		if exists && secs > 0 {
+			bytesPerSec = float64(totBytes-prevBytes) / secs
+		}
+		metrics.Gauge("cbgt_ctl_"+x.name+"_bytes_per_sec", nil).Set(bytesPerSec)
+
+		metrics.prevBytes[x.name] = limiterBytes{
+			limiter: x.limiter,
+			bytes:   totBytes,
+		}
+	}
+
+	metrics.prevTime = now
+}
+
+// ------------------------------------------------
+
+// CtlMetricsHandler is a REST handler that writes the ctl and
+// rebalance metrics in the Prometheus text exposition format.
+type CtlMetricsHandler struct {
+	m *CtlMgr
+}
+
+func NewCtlMetricsHandler(mgr *CtlMgr) *CtlMetricsHandler {
+	return &CtlMetricsHandler{m: mgr}
+}
+
+func (h *CtlMetricsHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	h.m.PublishMetrics()
+
+	w.Header().Set("Content-Type", h.m.ctl.metrics.ContentType())
+	err := h.m.ctl.metrics.WriteMetrics(w)
+	if err != nil {
+		log.Warnf("ctl/manager: metrics, err: %v", err)
+	}
+}
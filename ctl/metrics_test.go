@@ -0,0 +1,53 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"bytes"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPublishMetricsLimiterBytes(t *testing.T) {
+	m := testPrepareCtlMgr(t)
+	m.ctl.metrics = newCtlMetrics()
+
+	mgr := m.ctl.optionsCtl.Manager
+
+	expectBytesTotal := func(exp string) {
+		m.PublishMetrics()
+
+		var buf bytes.Buffer
+		if err := m.ctl.metrics.WriteMetrics(&buf); err != nil {
+			t.Fatalf("expected no err, err: %v", err)
+		}
+		if !strings.Contains(buf.String(),
+			"\ncbgt_ctl_hibernation_bytes_total "+exp+"\n") {
+			t.Fatalf("expected bytes total: %s, got:\n%s", exp, buf.String())
+		}
+	}
+
+	mgr.PrepareHibernationContext("", 0)
+	atomic.AddUint64(&mgr.HibernationRateLimiter().TotBytes, 100)
+	expectBytesTotal("100")
+
+	atomic.AddUint64(&mgr.HibernationRateLimiter().TotBytes, 20)
+	expectBytesTotal("120")
+
+	// A new limiter that's already past the previous one's bytes.
+	mgr.PrepareHibernationContext("", 0)
+	atomic.AddUint64(&mgr.HibernationRateLimiter().TotBytes, 150)
+	expectBytesTotal("270")
+
+	// And one that's behind them.
+	mgr.PrepareHibernationContext("", 0)
+	atomic.AddUint64(&mgr.HibernationRateLimiter().TotBytes, 10)
+	expectBytesTotal("280")
+}
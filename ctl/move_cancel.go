@@ -0,0 +1,103 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/couchbase/cbauth/service"
+	log "github.com/couchbase/clog"
+
+	"github.com/couchbase/cbgt/rebalance"
+	"github.com/couchbase/cbgt/rest"
+)
+
+// CancelPartitionMove cancels the in-flight moves of a single pindex
+// of the running rebalance, which otherwise continues, leaving the
+// pindex on the nodes that it was on when the rebalance started.  See
+// rebalance.Rebalancer.CancelMove().
+func (m *CtlMgr) CancelPartitionMove(pindex string) (err error) {
+	log.Printf("ctl/manager: CancelPartitionMove, pindex: %s", pindex)
+
+	defer func() {
+		m.audit("CancelPartitionMove", "cancel partition move",
+			map[string]interface{}{"pindex": pindex}, err)
+	}()
+
+	m.ctl.m.Lock()
+	r := m.ctl.r
+	changingTopology := m.ctl.ctlChangeTopology != nil
+	m.ctl.m.Unlock()
+
+	if !changingTopology || r == nil {
+		log.Errorf("ctl/manager: CancelPartitionMove, pindex: %s,"+
+			" no rebalance in progress", pindex)
+		return service.ErrNotFound
+	}
+
+	err = r.CancelMove(pindex)
+	if err != nil {
+		log.Errorf("ctl/manager: CancelPartitionMove, pindex: %s, err: %v",
+			pindex, err)
+		return err
+	}
+
+	log.Printf("ctl/manager: CancelPartitionMove, pindex: %s, done", pindex)
+
+	return nil
+}
+
+// ------------------------------------------------
+
+// CtlCancelPartitionMoveHandler is a REST handler that cancels the
+// in-flight moves of the pindex given by the "pindex" query param,
+// without canceling the whole rebalance.
+type CtlCancelPartitionMoveHandler struct {
+	m *CtlMgr
+}
+
+func NewCtlCancelPartitionMoveHandler(
+	mgr *CtlMgr) *CtlCancelPartitionMoveHandler {
+	return &CtlCancelPartitionMoveHandler{m: mgr}
+}
+
+func (h *CtlCancelPartitionMoveHandler) RESTOpts(opts map[string]string) {
+	opts["param: pindex"] =
+		"required, string, URL query parameter\n\n" +
+			"The name of the pindex whose moves are to be canceled."
+}
+
+func (h *CtlCancelPartitionMoveHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	pindex := req.FormValue("pindex")
+	if pindex == "" {
+		rest.ShowError(w, req, "ctl/manager: pindex param is required",
+			http.StatusBadRequest)
+		return
+	}
+
+	err := h.m.CancelPartitionMove(pindex)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == service.ErrNotFound {
+			code = http.StatusNotFound
+		} else if errors.Is(err, rebalance.ErrorMoveNotCancelable) {
+			code = http.StatusBadRequest
+		}
+		rest.ShowError(w, req, fmt.Sprintf("ctl/manager:"+
+			" could not cancel partition move, err: %v", err), code)
+		return
+	}
+
+	rest.MustEncode(w, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
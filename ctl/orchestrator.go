@@ -0,0 +1,335 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/rest"
+	log "github.com/couchbase/clog"
+)
+
+// CtlOrchestratorKey is the Cfg key that records which node is the
+// current task orchestrator.
+const CtlOrchestratorKey = "ctlOrchestrator"
+
+// CtlOrchestrator is the value of the CtlOrchestratorKey.
+type CtlOrchestrator struct {
+	NodeUUID string    `json:"nodeUUID"`
+	Since    time.Time `json:"since"`
+
+	// Forced is true when the orchestration was transferred or
+	// released by an administrator, see CtlMgr.TransferOrchestrator().
+	Forced bool `json:"forced,omitempty"`
+}
+
+// CfgGetCtlOrchestrator returns the current task orchestrator, which
+// is nil when there's none.
+func CfgGetCtlOrchestrator(cfg cbgt.Cfg) (*CtlOrchestrator, uint64, error) {
+	val, cas, err := cfg.Get(CtlOrchestratorKey, 0)
+	if err != nil || val == nil {
+		return nil, cas, err
+	}
+
+	rv := &CtlOrchestrator{}
+	err = cbgt.UnmarshalJSON(val, rv)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return rv, cas, nil
+}
+
+// CfgSetCtlOrchestrator records the task orchestrator, where a nil
+// orchestrator removes the record.
+func CfgSetCtlOrchestrator(cfg cbgt.Cfg, o *CtlOrchestrator) error {
+	_, cas, err := cfg.Get(CtlOrchestratorKey, 0)
+	if err != nil {
+		return err
+	}
+
+	if o == nil {
+		if cas == 0 {
+			return nil
+		}
+		return cfg.Del(CtlOrchestratorKey, cas)
+	}
+
+	val, err := cbgt.MarshalJSON(o)
+	if err != nil {
+		return err
+	}
+
+	_, err = cfg.Set(CtlOrchestratorKey, val, cas)
+	return err
+}
+
+// ----------------------------------------------------
+
+func (ctl *Ctl) selfUUID() string {
+	if ctl.optionsCtl.Manager == nil {
+		return ""
+	}
+	return ctl.optionsCtl.Manager.UUID()
+}
+
+// recordTaskOrchestrator records this node in the Cfg as the task
+// orchestrator, or removes the record when this node stops being it.
+func (ctl *Ctl) recordTaskOrchestrator(to bool) {
+	self := ctl.selfUUID()
+	if self == "" || ctl.cfg == nil {
+		return
+	}
+
+	var err error
+	if to {
+		err = CfgSetCtlOrchestrator(ctl.cfg,
+			&CtlOrchestrator{NodeUUID: self, Since: time.Now()})
+	} else {
+		var o *CtlOrchestrator
+		o, _, err = CfgGetCtlOrchestrator(ctl.cfg)
+		if err == nil && o != nil && o.NodeUUID == self {
+			err = CfgSetCtlOrchestrator(ctl.cfg, nil)
+		}
+	}
+
+	if err != nil {
+		log.Warnf("ctl: recordTaskOrchestrator, to: %t, err: %v", to, err)
+	}
+}
+
+// CtlOrchestratorForcedTimeout is how long a forced orchestration is
+// kept when no topology change claims it, after which its record is
+// removed, so that it doesn't defer the planning of the index
+// definition changes forever.
+var CtlOrchestratorForcedTimeout = 10 * time.Minute
+
+// orchestratorStale returns why the recorded task orchestrator is
+// stale, or "" when it isn't.  The startup is true when this node has
+// just started, so that it isn't running any topology change.
+func (ctl *Ctl) orchestratorStale(o *CtlOrchestrator, self string,
+	startup bool) string {
+	if startup && o.NodeUUID != "" && o.NodeUUID == self {
+		return "restarted"
+	}
+
+	if o.Forced && time.Since(o.Since) >= CtlOrchestratorForcedTimeout {
+		return "forced timeout"
+	}
+
+	if o.NodeUUID != "" {
+		nodeDefs, _, err := cbgt.CfgGetNodeDefs(ctl.cfg, cbgt.NODE_DEFS_WANTED)
+		if err == nil && nodeDefs != nil && nodeDefs.NodeDefs[o.NodeUUID] == nil {
+			return "node removed"
+		}
+	}
+
+	return ""
+}
+
+// orchestratorChanged follows the task orchestrator recorded in the
+// Cfg, where this node takes over the orchestration when it was
+// transferred to it, and releases the orchestration when another node
+// became the orchestrator, or when its forced orchestration ended.
+// Stale records, such as of a removed or restarted node, or of a
+// forced orchestration that no topology change claimed, are removed.
+func (ctl *Ctl) orchestratorChanged(startup bool) {
+	o, cas, err := CfgGetCtlOrchestrator(ctl.cfg)
+	if err != nil {
+		log.Warnf("ctl: orchestratorChanged, err: %v", err)
+		return
+	}
+
+	self := ctl.selfUUID()
+
+	if o != nil {
+		if reason := ctl.orchestratorStale(o, self, startup); reason != "" {
+			log.Printf("ctl: orchestratorChanged, removing the stale"+
+				" orchestrator: %q, forced: %t, reason: %s",
+				o.NodeUUID, o.Forced, reason)
+
+			err = ctl.cfg.Del(CtlOrchestratorKey, cas)
+			if err != nil {
+				log.Warnf("ctl: orchestratorChanged, del, err: %v", err)
+				return // Retried on the next change of the record.
+			}
+			o = nil
+		} else if o.Forced {
+			// Check again once the forced orchestration times out.
+			time.AfterFunc(CtlOrchestratorForcedTimeout-time.Since(o.Since),
+				func() { ctl.orchestratorChanged(false) })
+		}
+	}
+
+	orchestratorNode := ""
+	if o != nil {
+		orchestratorNode = o.NodeUUID
+	}
+
+	ctl.m.Lock()
+	if ctl.orchestratorNode != orchestratorNode {
+		ctl.orchestratorNode = orchestratorNode
+		ctl.incRevNumLOCKED()
+	}
+	ctl.m.Unlock()
+
+	if self == "" {
+		return
+	}
+
+	if o != nil && o.NodeUUID == self {
+		if o.Forced && !ctl.isTaskOrchestrator() {
+			log.Printf("ctl: orchestratorChanged, took over the orchestration")
+			atomic.StoreUint32(&ctl.orchestrator, 2)
+		}
+		return
+	}
+
+	if !ctl.isTaskOrchestrator() {
+		return
+	}
+
+	if o == nil {
+		// A forced orchestration that no topology change claimed ended.
+		if atomic.CompareAndSwapUint32(&ctl.orchestrator, 2, 0) {
+			log.Printf("ctl: orchestratorChanged, forced orchestration ended")
+		}
+		return
+	}
+
+	log.Printf("ctl: orchestratorChanged, releasing the orchestration,"+
+		" orchestrator: %q, forced: %t", o.NodeUUID, o.Forced)
+
+	// Stop any in-flight topology change without waiting on it, as the
+	// orchestration may have been released because it's wedged.
+	ctl.m.Lock()
+	if ctl.ctlStopCh != nil {
+		close(ctl.ctlStopCh)
+		ctl.ctlStopCh = nil
+	}
+	ctl.m.Unlock()
+
+	atomic.StoreUint32(&ctl.orchestrator, 0)
+
+	// A release is done once the orchestrator has released.
+	if o.NodeUUID == "" {
+		err = ctl.cfg.Del(CtlOrchestratorKey, cas)
+		if err != nil {
+			log.Warnf("ctl: orchestratorChanged, release del, err: %v", err)
+		}
+	}
+}
+
+// ----------------------------------------------------
+
+// ReleaseOrchestrator force-releases the task orchestration, so that
+// a wedged orchestrator node stops its in-flight topology change.
+func (m *CtlMgr) ReleaseOrchestrator() error {
+	return m.forceOrchestrator("")
+}
+
+// TransferOrchestrator forces the task orchestration onto the given
+// member node, which is then the node that the index definition
+// changes are forwarded to during a topology change.
+func (m *CtlMgr) TransferOrchestrator(node string) error {
+	m.ctl.m.Lock()
+	memberNodes := m.ctl.memberNodes
+	m.ctl.m.Unlock()
+
+	for _, memberNode := range memberNodes {
+		if memberNode.UUID == node {
+			return m.forceOrchestrator(node)
+		}
+	}
+
+	return fmt.Errorf("ctl: TransferOrchestrator, node: %s is not a member", node)
+}
+
+func (m *CtlMgr) forceOrchestrator(node string) error {
+	log.Printf("ctl: forceOrchestrator, node: %q", node)
+
+	return CfgSetCtlOrchestrator(m.ctl.cfg, &CtlOrchestrator{
+		NodeUUID: node,
+		Since:    time.Now(),
+		Forced:   true,
+	})
+}
+
+// ------------------------------------------------
+
+// CtlOrchestratorParams are the parameters of a CtlOrchestratorHandler
+// request, where Op is "release" or "transfer".
+type CtlOrchestratorParams struct {
+	Op   string `json:"op"`
+	Node string `json:"node,omitempty"` // The node to transfer to.
+}
+
+// CtlOrchestratorHandler is a REST handler that force-releases or
+// transfers the task orchestration, when the orchestrator is wedged.
+type CtlOrchestratorHandler struct {
+	m *CtlMgr
+}
+
+func NewCtlOrchestratorHandler(mgr *CtlMgr) *CtlOrchestratorHandler {
+	return &CtlOrchestratorHandler{m: mgr}
+}
+
+func (h *CtlOrchestratorHandler) RESTOpts(opts map[string]string) {
+	opts["request body"] =
+		"A JSON object with the op of \"release\" or \"transfer\"," +
+			" and the UUID of the node to transfer the orchestration to"
+}
+
+func (h *CtlOrchestratorHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	var params CtlOrchestratorParams
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err == nil {
+		err = cbgt.UnmarshalJSON(requestBody, &params)
+	}
+	if err == nil {
+		switch params.Op {
+		case "release":
+		case "transfer":
+			if params.Node == "" {
+				err = fmt.Errorf("node is required")
+			}
+		default:
+			err = fmt.Errorf("unknown op: %q", params.Op)
+		}
+	}
+	if err != nil {
+		rest.ShowErrorBody(w, requestBody, fmt.Sprintf("ctl/manager:"+
+			" invalid orchestrator request, err: %v", err),
+			http.StatusBadRequest)
+		return
+	}
+
+	if params.Op == "release" {
+		err = h.m.ReleaseOrchestrator()
+	} else {
+		err = h.m.TransferOrchestrator(params.Node)
+	}
+	if err != nil {
+		rest.ShowErrorBody(w, requestBody, fmt.Sprintf("ctl/manager:"+
+			" could not %s the orchestration, err: %v", params.Op, err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	rest.MustEncode(w, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
@@ -0,0 +1,170 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbgt"
+)
+
+func testOrchestratorCtls(t *testing.T, uuids ...string) []*Ctl {
+	cfg := cbgt.NewCfgMem()
+
+	nodeDefs := cbgt.NewNodeDefs(cbgt.VERSION)
+	for _, uuid := range uuids {
+		nodeDefs.NodeDefs[uuid] = &cbgt.NodeDef{UUID: uuid}
+	}
+	_, err := cbgt.CfgSetNodeDefs(cfg, cbgt.NODE_DEFS_WANTED, nodeDefs,
+		cbgt.CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	var rv []*Ctl
+	for _, uuid := range uuids {
+		mgr := cbgt.NewManager(cbgt.VERSION, cfg, uuid, nil,
+			"", 1, "", "", "", "", nil)
+		rv = append(rv, &Ctl{cfg: cfg, optionsCtl: CtlOptions{Manager: mgr}})
+	}
+	return rv
+}
+
+func testOrchestrator(t *testing.T, cfg cbgt.Cfg) *CtlOrchestrator {
+	o, _, err := CfgGetCtlOrchestrator(cfg)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	return o
+}
+
+func TestOrchestratorTransfer(t *testing.T) {
+	ctls := testOrchestratorCtls(t, "a", "b")
+	a, b := ctls[0], ctls[1]
+	m := NewCtlMgr(nil, a)
+
+	a.setTaskOrchestratorTo(true)
+
+	if err := m.TransferOrchestrator("b"); err == nil {
+		t.Fatalf("expected an err for a node that's not a member")
+	}
+	a.memberNodes = []CtlNode{{UUID: "a"}, {UUID: "b"}}
+	if err := m.TransferOrchestrator("b"); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	a.orchestratorChanged(false)
+	b.orchestratorChanged(false)
+	if a.isTaskOrchestrator() || !b.isTaskOrchestrator() {
+		t.Fatalf("expected the orchestration to be transferred")
+	}
+
+	// A topology change on b claims the forced orchestration, and
+	// removes the record when it's done.
+	b.setTaskOrchestratorTo(true)
+	if o := testOrchestrator(t, b.cfg); o == nil || o.Forced {
+		t.Fatalf("expected b to be the orchestrator, got: %+v", o)
+	}
+	b.setTaskOrchestratorTo(false)
+	if o := testOrchestrator(t, b.cfg); o != nil {
+		t.Fatalf("expected no orchestrator, got: %+v", o)
+	}
+}
+
+func TestOrchestratorForcedTimeout(t *testing.T) {
+	ctls := testOrchestratorCtls(t, "a", "b")
+	b := ctls[1]
+
+	err := CfgSetCtlOrchestrator(b.cfg, &CtlOrchestrator{
+		NodeUUID: "b", Since: time.Now(), Forced: true,
+	})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	b.orchestratorChanged(false)
+	if !b.isTaskOrchestrator() {
+		t.Fatalf("expected b to take over the orchestration")
+	}
+
+	// No topology change claimed the forced orchestration in time.
+	prevTimeout := CtlOrchestratorForcedTimeout
+	CtlOrchestratorForcedTimeout = 0
+	defer func() { CtlOrchestratorForcedTimeout = prevTimeout }()
+
+	b.orchestratorChanged(false)
+	if b.isTaskOrchestrator() {
+		t.Fatalf("expected the forced orchestration to end")
+	}
+	if o := testOrchestrator(t, b.cfg); o != nil {
+		t.Fatalf("expected no orchestrator, got: %+v", o)
+	}
+}
+
+func TestOrchestratorRelease(t *testing.T) {
+	ctls := testOrchestratorCtls(t, "a")
+	a := ctls[0]
+
+	a.setTaskOrchestratorTo(true)
+
+	// A failed topology change is also done with its orchestration.
+	a.setTaskOrchestratorTo(false)
+	if o := testOrchestrator(t, a.cfg); o != nil {
+		t.Fatalf("expected no orchestrator, got: %+v", o)
+	}
+
+	a.setTaskOrchestratorTo(true)
+	stopCh := make(chan struct{})
+	a.ctlStopCh = stopCh
+
+	if err := NewCtlMgr(nil, a).ReleaseOrchestrator(); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	a.orchestratorChanged(false)
+
+	select {
+	case <-stopCh:
+	default:
+		t.Fatalf("expected the in-flight topology change to be stopped")
+	}
+	if a.isTaskOrchestrator() {
+		t.Fatalf("expected the orchestration to be released")
+	}
+	if o := testOrchestrator(t, a.cfg); o != nil {
+		t.Fatalf("expected the release to be removed, got: %+v", o)
+	}
+}
+
+func TestOrchestratorStale(t *testing.T) {
+	ctls := testOrchestratorCtls(t, "a", "b")
+	a, b := ctls[0], ctls[1]
+
+	// The record of a node outlives its restart.
+	a.setTaskOrchestratorTo(true)
+	restarted := &Ctl{cfg: a.cfg, optionsCtl: a.optionsCtl}
+	restarted.orchestratorChanged(true)
+	if restarted.isTaskOrchestrator() {
+		t.Fatalf("expected a restarted node not to be the orchestrator")
+	}
+	if o := testOrchestrator(t, a.cfg); o != nil {
+		t.Fatalf("expected no orchestrator, got: %+v", o)
+	}
+
+	// The record of a node that was removed.
+	err := CfgSetCtlOrchestrator(b.cfg, &CtlOrchestrator{
+		NodeUUID: "gone", Since: time.Now(), Forced: true,
+	})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	b.orchestratorChanged(false)
+	if o := testOrchestrator(t, b.cfg); o != nil {
+		t.Fatalf("expected no orchestrator, got: %+v", o)
+	}
+}
@@ -0,0 +1,134 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"sync"
+)
+
+// A taskProgressMailbox holds the latest progress update of each task
+// for the handleTaskProgress() goroutine, so that posting an update
+// never blocks the rebalancer or the hibernation, and so that an
+// update is never dropped when the goroutine is behind.  Instead, the
+// updates of a task that are posted while the goroutine is behind are
+// coalesced, where the latest progress wins and all the errors are
+// kept.
+type taskProgressMailbox struct {
+	m sync.Mutex // Protects the fields that follow.
+
+	pending map[string]*taskProgress // Keyed by taskId.
+	order   []string                 // The taskIds, in the order posted.
+
+	kickCh chan struct{} // Signaled when there are pending updates.
+}
+
+func newTaskProgressMailbox() *taskProgressMailbox {
+	return &taskProgressMailbox{
+		pending: map[string]*taskProgress{},
+		kickCh:  make(chan struct{}, 1),
+	}
+}
+
+// post coalesces the update with the pending update of its task, if
+// any, and signals the handleTaskProgress() goroutine.
+func (mb *taskProgressMailbox) post(tp taskProgress) {
+	mb.m.Lock()
+
+	prev := mb.pending[tp.taskId]
+	if prev == nil {
+		mb.order = append(mb.order, tp.taskId)
+	}
+	mb.pending[tp.taskId] = coalesceTaskProgress(prev, &tp)
+
+	mb.m.Unlock()
+
+	select {
+	case mb.kickCh <- struct{}{}:
+	default: // The goroutine was already signaled.
+	}
+}
+
+// take returns the pending updates, in the order that their tasks
+// were first posted, and empties the mailbox.
+func (mb *taskProgressMailbox) take() []taskProgress {
+	mb.m.Lock()
+	defer mb.m.Unlock()
+
+	rv := make([]taskProgress, 0, len(mb.order))
+	for _, taskId := range mb.order {
+		rv = append(rv, *mb.pending[taskId])
+	}
+
+	mb.pending = map[string]*taskProgress{}
+	mb.order = nil
+
+	return rv
+}
+
+// coalesceTaskProgress returns the update that has the effect of the
+// prev update, which may be nil, followed by the next update.
+func coalesceTaskProgress(prev, next *taskProgress) *taskProgress {
+	// An update without progress or errors removes its task, which
+	// makes the prev update moot, and which later updates of the
+	// removed task mustn't undo.
+	if prev == nil || isTaskRemoval(next) {
+		return next
+	}
+	if isTaskRemoval(prev) {
+		return prev
+	}
+
+	rv := *next
+
+	if !rv.progressExists && prev.progressExists {
+		rv.progressExists = true
+		rv.progress = prev.progress
+	}
+
+	// The errors are kept, without the errors repeated by senders
+	// that report all their errors so far.
+	if len(prev.errs) > 0 {
+		seen := make(map[string]bool, len(prev.errs)+len(next.errs))
+		rv.errs = nil
+		for _, errs := range [][]error{prev.errs, next.errs} {
+			for _, err := range errs {
+				if !seen[err.Error()] {
+					seen[err.Error()] = true
+					rv.errs = append(rv.errs, err)
+				}
+			}
+		}
+	}
+
+	if rv.errStatus == "" {
+		rv.errStatus = prev.errStatus
+	}
+
+	if prev.extra != nil {
+		extra := make(map[string]interface{}, len(prev.extra)+len(next.extra))
+		for k, v := range prev.extra {
+			extra[k] = v
+		}
+		for k, v := range next.extra {
+			extra[k] = v
+		}
+		rv.extra = extra
+	}
+
+	if rv.detailedProgress == nil {
+		rv.detailedProgress = prev.detailedProgress
+	}
+
+	return &rv
+}
+
+// isTaskRemoval returns true when the update removes its task.
+func isTaskRemoval(tp *taskProgress) bool {
+	return !tp.progressExists && len(tp.errs) == 0
+}
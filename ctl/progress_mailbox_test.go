@@ -0,0 +1,36 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTaskProgressMailboxRemoval(t *testing.T) {
+	mb := newTaskProgressMailbox()
+
+	mb.post(taskProgress{taskId: "t0", progressExists: true, progress: 10})
+	mb.post(taskProgress{taskId: "t0"})
+	mb.post(taskProgress{taskId: "t0", progressExists: true, progress: 20})
+	mb.post(taskProgress{taskId: "t0", errs: []error{fmt.Errorf("boom")}})
+
+	tps := mb.take()
+	if len(tps) != 1 || !isTaskRemoval(&tps[0]) {
+		t.Fatalf("expected the removal to stick, got: %+v", tps)
+	}
+
+	// The removal only sticks while it's pending.
+	mb.post(taskProgress{taskId: "t0", progressExists: true, progress: 30})
+
+	tps = mb.take()
+	if len(tps) != 1 || tps[0].progress != 30 {
+		t.Fatalf("expected the progress, got: %+v", tps)
+	}
+}
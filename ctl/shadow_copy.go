@@ -0,0 +1,135 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/couchbase/cbauth/service"
+	"github.com/couchbase/cbgt"
+)
+
+// TaskTypeShadowCopy is the task type of a shadow copy on this node,
+// whose Extra reports the replication lag, see
+// cbgt.Manager.ShadowCopies().
+const TaskTypeShadowCopy service.TaskType = "task-shadow-copy"
+
+// ShadowCopyTaskInterval is how often the shadow copy tasks are
+// refreshed from the shadow copy statuses.
+var ShadowCopyTaskInterval = 10 * time.Second
+
+// runShadowCopyTasks keeps a task per shadow copy of this node in the
+// task list, until the Ctl is stopped.
+func (m *CtlMgr) runShadowCopyTasks() {
+	mgr := m.ctl.optionsCtl.Manager
+	if mgr == nil {
+		return
+	}
+
+	ticker := time.NewTicker(ShadowCopyTaskInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctl.stopCh:
+			return
+		case <-ticker.C:
+			m.refreshShadowCopyTasks(mgr.ShadowCopies())
+		}
+	}
+}
+
+// refreshShadowCopyTasks adds, updates and removes the shadow copy
+// tasks to match the shadow copy statuses.
+func (m *CtlMgr) refreshShadowCopyTasks(statuses []*cbgt.ShadowCopyStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := make(map[string]*service.Task, len(statuses))
+	for _, s := range statuses {
+		task := shadowCopyTask(s)
+		wanted[task.ID] = task
+	}
+
+	var taskHandlesNext []*taskHandle
+	var changed bool
+
+	for _, th := range m.tasks.taskHandles {
+		if th.task.Type != TaskTypeShadowCopy {
+			taskHandlesNext = append(taskHandlesNext, th)
+			continue
+		}
+
+		task, exists := wanted[th.task.ID]
+		if !exists {
+			changed = true
+			continue
+		}
+		delete(wanted, th.task.ID)
+
+		task.Rev = th.task.Rev
+		if !reflect.DeepEqual(task, th.task) {
+			task.Rev = EncodeRev(m.allocRevNumLOCKED(0))
+			th = &taskHandle{startTime: th.startTime, task: task}
+			changed = true
+		}
+		taskHandlesNext = append(taskHandlesNext, th)
+	}
+
+	for _, s := range statuses {
+		task := wanted["shadow-copy:"+s.Def.Name]
+		if task == nil {
+			continue
+		}
+		task.Rev = EncodeRev(m.allocRevNumLOCKED(0))
+		taskHandlesNext = append(taskHandlesNext,
+			&taskHandle{startTime: time.Now(), task: task})
+		changed = true
+	}
+
+	if changed {
+		m.updateTasksLOCKED(func(s *tasks) {
+			s.taskHandles = taskHandlesNext
+		})
+	}
+}
+
+// shadowCopyTask returns the task of a shadow copy status, without its
+// Rev, where the lag is in seconds.
+func shadowCopyTask(s *cbgt.ShadowCopyStatus) *service.Task {
+	pindexLags := make(map[string]float64, len(s.PIndexes))
+	for name, ps := range s.PIndexes {
+		pindexLags[name] = ps.LagSecs
+	}
+
+	task := &service.Task{
+		ID:               "shadow-copy:" + s.Def.Name,
+		Type:             TaskTypeShadowCopy,
+		Status:           service.TaskStatusRunning,
+		DetailedProgress: map[service.NodeID]float64{},
+		Description:      "shadow copy",
+		Extra: map[string]interface{}{
+			"name":       s.Def.Name,
+			"indexName":  s.Def.IndexName,
+			"role":       s.Def.Role,
+			"status":     s.Status,
+			"lagSecs":    s.LagSecs,
+			"pindexLags": pindexLags,
+		},
+	}
+
+	if s.Status == "failed" {
+		task.Status = service.TaskStatusFailed
+		setTaskErrors(task, []error{errors.New(s.LastErr)})
+	}
+
+	return task
+}
@@ -0,0 +1,69 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"testing"
+
+	"github.com/couchbase/cbauth/service"
+	"github.com/couchbase/cbgt"
+)
+
+func TestRefreshShadowCopyTasks(t *testing.T) {
+	m := NewCtlMgr(nil, nil)
+
+	status := &cbgt.ShadowCopyStatus{
+		Def: &cbgt.ShadowCopyDef{Name: "dr", IndexName: "idx",
+			Role: cbgt.ShadowCopyRoleTarget},
+		Status:  "running",
+		LagSecs: 30,
+		PIndexes: map[string]*cbgt.ShadowCopyPIndexStatus{
+			"p0": {PIndex: "p0", LagSecs: 30},
+		},
+	}
+
+	shadowTask := func() *service.Task {
+		taskList, _ := m.GetTaskList(nil, nil)
+		for i := range taskList.Tasks {
+			if taskList.Tasks[i].ID == "shadow-copy:dr" {
+				return &taskList.Tasks[i]
+			}
+		}
+		return nil
+	}
+
+	m.refreshShadowCopyTasks([]*cbgt.ShadowCopyStatus{status})
+	task := shadowTask()
+	if task == nil || task.Type != TaskTypeShadowCopy ||
+		task.Status != service.TaskStatusRunning ||
+		task.Extra["lagSecs"] != 30.0 {
+		t.Fatalf("expected a running shadow copy task, got: %+v", task)
+	}
+	rev := string(task.Rev)
+
+	// An unchanged status keeps the task as is.
+	m.refreshShadowCopyTasks([]*cbgt.ShadowCopyStatus{status})
+	if task = shadowTask(); string(task.Rev) != rev {
+		t.Fatalf("expected an unchanged task, got: %+v", task)
+	}
+
+	status.Status, status.LastErr, status.LagSecs = "failed", "no manifest", 90
+	m.refreshShadowCopyTasks([]*cbgt.ShadowCopyStatus{status})
+	task = shadowTask()
+	if task.Status != service.TaskStatusFailed ||
+		task.ErrorMessage != "no manifest" ||
+		task.Extra["lagSecs"] != 90.0 || string(task.Rev) == rev {
+		t.Fatalf("expected a failed shadow copy task, got: %+v", task)
+	}
+
+	m.refreshShadowCopyTasks(nil)
+	if task = shadowTask(); task != nil {
+		t.Fatalf("expected the shadow copy task removed, got: %+v", task)
+	}
+}
@@ -0,0 +1,166 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/couchbase/cbauth/service"
+
+	"github.com/couchbase/cbgt/rest"
+)
+
+// TaskListDiff holds the changes of the task list since a revision,
+// which is much smaller than the full task list when there are many
+// simultaneous hibernation and rebalance tasks, of which only a few
+// change at a time.
+type TaskListDiff struct {
+	Rev     service.Revision `json:"rev"`
+	BaseRev service.Revision `json:"baseRev,omitempty"`
+
+	// Full is true when the base revision is unknown or too old to be
+	// diffed against, so the Added tasks are the full task list.
+	Full bool `json:"full,omitempty"`
+
+	Added   []service.Task `json:"added,omitempty"`
+	Updated []service.Task `json:"updated,omitempty"`
+	Removed []string       `json:"removed,omitempty"` // Task IDs.
+}
+
+// DiffTaskLists returns the changes from the prev task list, which may
+// be nil, to the curr task list, sorted by task ID.
+func DiffTaskLists(prev, curr *service.TaskList) *TaskListDiff {
+	rv := &TaskListDiff{Rev: curr.Rev}
+
+	prevTasks := map[string]*service.Task{}
+	if prev != nil {
+		rv.BaseRev = prev.Rev
+		for i := range prev.Tasks {
+			prevTasks[prev.Tasks[i].ID] = &prev.Tasks[i]
+		}
+	} else {
+		rv.Full = true
+	}
+
+	currIDs := map[string]bool{}
+	for _, task := range curr.Tasks {
+		currIDs[task.ID] = true
+
+		prevTask, exists := prevTasks[task.ID]
+		if !exists {
+			rv.Added = append(rv.Added, task)
+		} else if !reflect.DeepEqual(*prevTask, task) {
+			rv.Updated = append(rv.Updated, task)
+		}
+	}
+
+	for id := range prevTasks {
+		if !currIDs[id] {
+			rv.Removed = append(rv.Removed, id)
+		}
+	}
+
+	sort.Slice(rv.Added, func(i, j int) bool {
+		return rv.Added[i].ID < rv.Added[j].ID
+	})
+	sort.Slice(rv.Updated, func(i, j int) bool {
+		return rv.Updated[i].ID < rv.Updated[j].ID
+	})
+	sort.Strings(rv.Removed)
+
+	return rv
+}
+
+// GetTaskListDiff is the diff mode of GetTaskList, which returns only
+// the tasks that were added, updated or removed since the haveTasksRev,
+// with the same long-poll behavior.  The full task list is returned,
+// marked as Full, when the haveTasksRev isn't supplied or is older than
+// the recent revisions that are remembered.
+func (m *CtlMgr) GetTaskListDiff(haveTasksRev service.Revision,
+	cancelCh service.Cancel) (*TaskListDiff, error) {
+	curr, err := m.waitTaskList("GetTaskListDiff", haveTasksRev, cancelCh)
+	if err != nil {
+		return nil, err
+	}
+
+	var prev *service.TaskList
+	if len(haveTasksRev) > 0 {
+		haveTasksRevNum, err := DecodeRev(haveTasksRev)
+		if err != nil {
+			return nil, err
+		}
+
+		if snapshot, exists :=
+			m.tasksBroadcast.snapshotAt(haveTasksRevNum); exists {
+			prev = snapshot.(*service.TaskList)
+		}
+	}
+
+	return DiffTaskLists(prev, curr), nil
+}
+
+// ------------------------------------------------
+
+// CtlTaskListHandler is a REST handler that long-polls the task list,
+// optionally in the diff mode.
+type CtlTaskListHandler struct {
+	m *CtlMgr
+}
+
+func NewCtlTaskListHandler(mgr *CtlMgr) *CtlTaskListHandler {
+	return &CtlTaskListHandler{m: mgr}
+}
+
+func (h *CtlTaskListHandler) RESTOpts(opts map[string]string) {
+	opts["param: rev"] =
+		"optional, string, URL query parameter\n\n" +
+			"The revision of the task list that the caller has, to wait" +
+			" for a change of."
+	opts["param: diff"] =
+		"optional, bool, URL query parameter\n\n" +
+			"When true, only the tasks that were added, updated or" +
+			" removed since the rev are returned."
+}
+
+func (h *CtlTaskListHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	rev := service.Revision(req.FormValue("rev"))
+	if len(rev) > 0 {
+		if _, err := DecodeRev(rev); err != nil {
+			rest.ShowError(w, req, fmt.Sprintf("ctl/manager:"+
+				" invalid rev: %s, err: %v", rev, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	cancelCh := req.Context().Done()
+
+	var rv interface{}
+	var err error
+
+	if req.FormValue("diff") == "true" {
+		rv, err = h.m.GetTaskListDiff(rev, cancelCh)
+	} else {
+		rv, err = h.m.GetTaskList(rev, cancelCh)
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == service.ErrCanceled {
+			code = http.StatusRequestTimeout
+		}
+		rest.ShowError(w, req, fmt.Sprintf("ctl/manager:"+
+			" could not get task list, err: %v", err), code)
+		return
+	}
+
+	rest.MustEncode(w, rv)
+}
@@ -0,0 +1,112 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/cbauth/service"
+)
+
+func TestDiffTaskLists(t *testing.T) {
+	running, failed := service.TaskStatusRunning, service.TaskStatusFailed
+
+	progressed := testTask("a", "1", running)
+	progressed.Progress = 0.5
+
+	tests := []struct {
+		name string
+		prev *service.TaskList
+		curr *service.TaskList
+		exp  *TaskListDiff
+	}{
+		{
+			name: "no prev is a full diff",
+			curr: &service.TaskList{Rev: EncodeRev(2), Tasks: []service.Task{
+				testTask("b", "1", running), testTask("a", "1", running),
+			}},
+			exp: &TaskListDiff{Rev: EncodeRev(2), Full: true,
+				Added: []service.Task{
+					testTask("a", "1", running), testTask("b", "1", running),
+				}},
+		},
+		{
+			name: "unchanged",
+			prev: &service.TaskList{Rev: EncodeRev(1), Tasks: []service.Task{
+				testTask("a", "1", running),
+			}},
+			curr: &service.TaskList{Rev: EncodeRev(2), Tasks: []service.Task{
+				testTask("a", "1", running),
+			}},
+			exp: &TaskListDiff{Rev: EncodeRev(2), BaseRev: EncodeRev(1)},
+		},
+		{
+			name: "added",
+			prev: &service.TaskList{Rev: EncodeRev(1), Tasks: []service.Task{
+				testTask("a", "1", running),
+			}},
+			curr: &service.TaskList{Rev: EncodeRev(2), Tasks: []service.Task{
+				testTask("c", "2", running), testTask("a", "1", running),
+				testTask("b", "2", running),
+			}},
+			exp: &TaskListDiff{Rev: EncodeRev(2), BaseRev: EncodeRev(1),
+				Added: []service.Task{
+					testTask("b", "2", running), testTask("c", "2", running),
+				}},
+		},
+		{
+			name: "removed",
+			prev: &service.TaskList{Rev: EncodeRev(1), Tasks: []service.Task{
+				testTask("c", "1", running), testTask("a", "1", running),
+				testTask("b", "1", running),
+			}},
+			curr: &service.TaskList{Rev: EncodeRev(2), Tasks: []service.Task{
+				testTask("b", "1", running),
+			}},
+			exp: &TaskListDiff{Rev: EncodeRev(2), BaseRev: EncodeRev(1),
+				Removed: []string{"a", "c"}},
+		},
+		{
+			name: "changed",
+			prev: &service.TaskList{Rev: EncodeRev(1), Tasks: []service.Task{
+				testTask("a", "1", running), testTask("b", "1", running),
+				testTask("c", "1", running),
+			}},
+			curr: &service.TaskList{Rev: EncodeRev(2), Tasks: []service.Task{
+				testTask("c", "2", failed), progressed,
+				testTask("b", "1", running),
+			}},
+			exp: &TaskListDiff{Rev: EncodeRev(2), BaseRev: EncodeRev(1),
+				Updated: []service.Task{
+					progressed, testTask("c", "2", failed),
+				}},
+		},
+		{
+			name: "added, removed and changed",
+			prev: &service.TaskList{Rev: EncodeRev(3), Tasks: []service.Task{
+				testTask("a", "1", running), testTask("b", "1", running),
+			}},
+			curr: &service.TaskList{Rev: EncodeRev(5), Tasks: []service.Task{
+				testTask("b", "4", failed), testTask("c", "5", running),
+			}},
+			exp: &TaskListDiff{Rev: EncodeRev(5), BaseRev: EncodeRev(3),
+				Added:   []service.Task{testTask("c", "5", running)},
+				Updated: []service.Task{testTask("b", "4", failed)},
+				Removed: []string{"a"}},
+		},
+	}
+
+	for _, test := range tests {
+		got := DiffTaskLists(test.prev, test.curr)
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("%s: expected: %+v, got: %+v", test.name, test.exp, got)
+		}
+	}
+}
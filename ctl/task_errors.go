@@ -0,0 +1,240 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/couchbase/cbauth/service"
+
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/rebalance"
+)
+
+// The machine-readable codes of the task errors, so that ns_server and
+// support tooling can tell the failures apart without parsing the
+// task's ErrorMessage.
+const (
+	TaskErrorSourceDeleted   = "sourceDeleted"
+	TaskErrorIndexDeleted    = "indexDeleted"
+	TaskErrorDiskFull        = "diskFull"
+	TaskErrorTimeout         = "timeout"
+	TaskErrorCanceled        = "canceled"
+	TaskErrorConflict        = "conflict"
+	TaskErrorNodeUnreachable = "nodeUnreachable"
+	TaskErrorUnknown         = "unknown"
+)
+
+// TASK_ERRORS_EXTRA_KEY is the key of the task's Extra that holds the
+// structured []*TaskError entries of the task's errors, alongside the
+// newline-joined ErrorMessage.
+const TASK_ERRORS_EXTRA_KEY = "errors"
+
+// A TaskError is a structured entry of a task's error.  A TaskError is
+// itself an error, so that code that knows the cause of a failure can
+// report it directly, instead of having it classified.
+type TaskError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Node      string `json:"node,omitempty"`
+	PIndex    string `json:"pindex,omitempty"`
+	Retriable bool   `json:"retriable"`
+}
+
+func (e *TaskError) Error() string {
+	return e.Message
+}
+
+// A TaskErrorClassifier returns the code of an error and whether the
+// operation is worth retrying, or ok of false when it doesn't know the
+// error.
+type TaskErrorClassifier func(err error) (code string, retriable, ok bool)
+
+var taskErrorClassifiersM sync.RWMutex
+
+// taskErrorClassifiers is a registry of the TaskErrorClassifier's,
+// keyed by name.
+var taskErrorClassifiers = map[string]TaskErrorClassifier{}
+
+// RegisterTaskErrorClassifier allows applications to register a named
+// classifier for the errors that they know of, such as the errors of
+// their pindex implementations, which are consulted, in name order,
+// before the built-in classification.  Registering a nil classifier
+// unregisters the name.
+func RegisterTaskErrorClassifier(name string, c TaskErrorClassifier) {
+	taskErrorClassifiersM.Lock()
+	if c != nil {
+		taskErrorClassifiers[name] = c
+	} else {
+		delete(taskErrorClassifiers, name)
+	}
+	taskErrorClassifiersM.Unlock()
+}
+
+var taskErrorPIndexRE = regexp.MustCompile(`\bpindex: ([^\s,]+)`)
+var taskErrorNodeRE = regexp.MustCompile(`\bnode: ([^\s,]+)`)
+
+// ClassifyTaskError returns the structured entry of an error, where
+// the node and pindex are taken from the "node: " and "pindex: " parts
+// of the error's message, when not known otherwise.
+func ClassifyTaskError(err error) *TaskError {
+	if err == nil {
+		return nil
+	}
+
+	var te *TaskError
+	if errors.As(err, &te) {
+		rv := *te
+		if rv.Message == "" {
+			rv.Message = err.Error()
+		}
+		return &rv
+	}
+
+	rv := &TaskError{Message: err.Error()}
+	rv.Code, rv.Retriable = classifyTaskError(err)
+
+	if m := taskErrorPIndexRE.FindStringSubmatch(rv.Message); m != nil {
+		rv.PIndex = m[1]
+	}
+	if m := taskErrorNodeRE.FindStringSubmatch(rv.Message); m != nil {
+		rv.Node = m[1]
+	}
+
+	return rv
+}
+
+// ClassifyTaskErrors returns the structured entries of the errors.
+func ClassifyTaskErrors(errs []error) []*TaskError {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	rv := make([]*TaskError, 0, len(errs))
+	for _, err := range errs {
+		if te := ClassifyTaskError(err); te != nil {
+			rv = append(rv, te)
+		}
+	}
+
+	return rv
+}
+
+func classifyTaskError(err error) (string, bool) {
+	taskErrorClassifiersM.RLock()
+	names := make([]string, 0, len(taskErrorClassifiers))
+	for name := range taskErrorClassifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	classifiers := make([]TaskErrorClassifier, 0, len(names))
+	for _, name := range names {
+		classifiers = append(classifiers, taskErrorClassifiers[name])
+	}
+	taskErrorClassifiersM.RUnlock()
+
+	for _, c := range classifiers {
+		if code, retriable, ok := c(err); ok {
+			return code, retriable
+		}
+	}
+
+	msg := strings.ToLower(err.Error())
+	has := func(substrs ...string) bool {
+		for _, s := range substrs {
+			if strings.Contains(msg, s) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var casErr *cbgt.CfgCASError
+
+	switch {
+	case errors.Is(err, syscall.ENOSPC) ||
+		errors.Is(err, cbgt.ErrDiskHighWaterMark) ||
+		has("no space left on device", "disk full"):
+		return TaskErrorDiskFull, false
+
+	case errors.Is(err, cbgt.ErrCouchbaseMismatchedBucketUUID) ||
+		has("bucket not found", "no such bucket", "bucketnotfound",
+			"mismatched bucketuuid", "mismatched-couchbase-bucket-uuid"):
+		return TaskErrorSourceDeleted, false
+
+	case errors.Is(err, rebalance.ErrorNoIndexDefinitionFound) ||
+		errors.Is(err, cbgt.ErrNoIndexDefs) ||
+		has("no index definition", "missing index"):
+		return TaskErrorIndexDeleted, false
+
+	case errors.Is(err, context.Canceled) ||
+		errors.Is(err, rebalance.ErrorDrainStopped) ||
+		errors.Is(err, cbgt.ErrTransferCanceled) ||
+		has("canceled", "cancelled"):
+		return TaskErrorCanceled, true
+
+	case errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, cbgt.ErrPIndexOpTimeout) ||
+		has("timeout", "timed out"):
+		return TaskErrorTimeout, true
+
+	case errors.As(err, &casErr) ||
+		errors.Is(err, rebalance.ErrorConcurrentPlannerInProgress) ||
+		has("cas mismatch", "concurrent planner"):
+		return TaskErrorConflict, true
+
+	case errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		has("connection refused", "connection reset", "no route to host"):
+		return TaskErrorNodeUnreachable, true
+	}
+
+	return TaskErrorUnknown, false
+}
+
+// setTaskErrors sets the ErrorMessage of a task and the structured
+// entries of its errors, where the task's Extra is replaced rather
+// than modified, as it's shared with the previous revisions of the
+// task.
+func setTaskErrors(task *service.Task, errs []error) {
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	task.ErrorMessage = strings.Join(msgs, "\n")
+
+	tes := ClassifyTaskErrors(errs)
+	if len(tes) == 0 {
+		if _, exists := task.Extra[TASK_ERRORS_EXTRA_KEY]; !exists {
+			return
+		}
+	}
+
+	extraNext := make(map[string]interface{}, len(task.Extra)+1)
+	for k, v := range task.Extra {
+		extraNext[k] = v
+	}
+	if len(tes) > 0 {
+		extraNext[TASK_ERRORS_EXTRA_KEY] = tes
+	} else {
+		delete(extraNext, TASK_ERRORS_EXTRA_KEY)
+	}
+	task.Extra = extraNext
+}
@@ -0,0 +1,173 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/couchbase/cbauth/service"
+
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/rebalance"
+)
+
+func TestClassifyTaskError(t *testing.T) {
+	tests := []struct {
+		err       error
+		code      string
+		retriable bool
+		node      string
+		pindex    string
+	}{
+		{fmt.Errorf("write: %w", syscall.ENOSPC),
+			TaskErrorDiskFull, false, "", ""},
+		{fmt.Errorf("pindex: p0, disk full"),
+			TaskErrorDiskFull, false, "", "p0"},
+		{fmt.Errorf("feed: %w", cbgt.ErrCouchbaseMismatchedBucketUUID),
+			TaskErrorSourceDeleted, false, "", ""},
+		{fmt.Errorf("node: n1, bucket not found"),
+			TaskErrorSourceDeleted, false, "n1", ""},
+		{fmt.Errorf("move: %w", rebalance.ErrorNoIndexDefinitionFound),
+			TaskErrorIndexDeleted, false, "", ""},
+		{fmt.Errorf("download: %w", context.Canceled),
+			TaskErrorCanceled, true, "", ""},
+		{rebalance.ErrorDrainStopped,
+			TaskErrorCanceled, true, "", ""},
+		{fmt.Errorf("wait: %w", context.DeadlineExceeded),
+			TaskErrorTimeout, true, "", ""},
+		{fmt.Errorf("node: n2, pindex: p1, timed out"),
+			TaskErrorTimeout, true, "n2", "p1"},
+		{fmt.Errorf("plan: %w", &cbgt.CfgCASError{}),
+			TaskErrorConflict, true, "", ""},
+		{rebalance.ErrorConcurrentPlannerInProgress,
+			TaskErrorConflict, true, "", ""},
+		{fmt.Errorf("node: n3, dial: %w", syscall.ECONNREFUSED),
+			TaskErrorNodeUnreachable, true, "n3", ""},
+		{fmt.Errorf("something odd"),
+			TaskErrorUnknown, false, "", ""},
+	}
+
+	for _, test := range tests {
+		te := ClassifyTaskError(test.err)
+		exp := &TaskError{
+			Code:      test.code,
+			Message:   test.err.Error(),
+			Node:      test.node,
+			PIndex:    test.pindex,
+			Retriable: test.retriable,
+		}
+		if !reflect.DeepEqual(te, exp) {
+			t.Errorf("err: %v, expected: %+v, got: %+v", test.err, exp, te)
+		}
+	}
+
+	if ClassifyTaskError(nil) != nil {
+		t.Errorf("expected no entry for a nil error")
+	}
+
+	// A TaskError is reported as is, even when it's wrapped.
+	known := &TaskError{Code: TaskErrorConflict, Node: "n4"}
+	te := ClassifyTaskError(fmt.Errorf("start: %w", known))
+	if te == known || te.Code != TaskErrorConflict || te.Node != "n4" ||
+		te.Message != "start: " {
+		t.Errorf("expected a copy of the known error, got: %+v", te)
+	}
+}
+
+func TestClassifyTaskErrorsClassifier(t *testing.T) {
+	errQuota := errors.New("quota exceeded")
+
+	RegisterTaskErrorClassifier("test", func(err error) (string, bool, bool) {
+		if errors.Is(err, errQuota) {
+			return "quota", true, true
+		}
+		return "", false, false
+	})
+	defer RegisterTaskErrorClassifier("test", nil)
+
+	tes := ClassifyTaskErrors([]error{
+		fmt.Errorf("pindex: p0, %w", errQuota),
+		nil,
+		errors.New("timeout"),
+	})
+	if len(tes) != 2 {
+		t.Fatalf("expected 2 entries, got: %+v", tes)
+	}
+	if tes[0].Code != "quota" || !tes[0].Retriable || tes[0].PIndex != "p0" {
+		t.Errorf("expected the registered classification, got: %+v", tes[0])
+	}
+	if tes[1].Code != TaskErrorTimeout {
+		t.Errorf("expected the built-in classification, got: %+v", tes[1])
+	}
+
+	if ClassifyTaskErrors(nil) != nil {
+		t.Errorf("expected no entries for no errors")
+	}
+
+	RegisterTaskErrorClassifier("test", nil)
+	if te := ClassifyTaskError(errQuota); te.Code != TaskErrorUnknown {
+		t.Errorf("expected the unregistered classifier to be unused,"+
+			" got: %+v", te)
+	}
+}
+
+func TestSetTaskErrors(t *testing.T) {
+	extra := map[string]interface{}{"k": "v"}
+	task := &service.Task{ID: "t0", Extra: extra}
+
+	setTaskErrors(task, []error{
+		errors.New("node: n0, connection refused"),
+		errors.New("disk full"),
+	})
+
+	if task.ErrorMessage != "node: n0, connection refused\ndisk full" {
+		t.Errorf("unexpected ErrorMessage: %q", task.ErrorMessage)
+	}
+	tes, ok := task.Extra[TASK_ERRORS_EXTRA_KEY].([]*TaskError)
+	if !ok || len(tes) != 2 ||
+		tes[0].Code != TaskErrorNodeUnreachable || tes[0].Node != "n0" ||
+		tes[1].Code != TaskErrorDiskFull {
+		t.Errorf("unexpected entries: %+v", task.Extra)
+	}
+	if task.Extra["k"] != "v" {
+		t.Errorf("expected the other extras to be kept, got: %+v", task.Extra)
+	}
+
+	// The shared Extra of the previous revision is untouched.
+	if _, exists := extra[TASK_ERRORS_EXTRA_KEY]; exists || len(extra) != 1 {
+		t.Errorf("expected the prev Extra to be unchanged, got: %+v", extra)
+	}
+
+	// Clearing the errors removes the entries.
+	prevExtra := task.Extra
+	setTaskErrors(task, nil)
+	if task.ErrorMessage != "" {
+		t.Errorf("expected no ErrorMessage, got: %q", task.ErrorMessage)
+	}
+	if _, exists := task.Extra[TASK_ERRORS_EXTRA_KEY]; exists ||
+		task.Extra["k"] != "v" {
+		t.Errorf("expected the entries to be removed, got: %+v", task.Extra)
+	}
+	if _, exists := prevExtra[TASK_ERRORS_EXTRA_KEY]; !exists {
+		t.Errorf("expected the prev Extra to be unchanged, got: %+v",
+			prevExtra)
+	}
+
+	// With no errors to set or clear, the Extra isn't copied.
+	task = &service.Task{ID: "t1"}
+	setTaskErrors(task, nil)
+	if task.Extra != nil {
+		t.Errorf("expected no Extra, got: %+v", task.Extra)
+	}
+}
@@ -0,0 +1,257 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/couchbase/cbauth/service"
+	"github.com/couchbase/cbgt/rest"
+	log "github.com/couchbase/clog"
+)
+
+// The kinds of task lifecycle events.
+const (
+	CtlTaskEventCreated   = "created"
+	CtlTaskEventProgress  = "progress"
+	CtlTaskEventFailed    = "failed"
+	CtlTaskEventCompleted = "completed"
+	CtlTaskEventCanceled  = "canceled"
+)
+
+// CtlCanceledTasksMax is the number of the recently canceled or
+// expired tasks that are remembered for the task events.
+var CtlCanceledTasksMax = 100
+
+// A canceledTask is a task revision that was canceled or expired.
+type canceledTask struct {
+	id  string
+	rev string
+}
+
+// CtlTaskEventsKeepAlive is how often a keep-alive comment is sent to
+// an idle task event stream, so that proxies don't close it.
+var CtlTaskEventsKeepAlive = 30 * time.Second
+
+// A CtlTaskEvent is a lifecycle event of a task.
+type CtlTaskEvent struct {
+	Event string       `json:"event"`
+	Time  time.Time    `json:"time"`
+	Task  service.Task `json:"task"`
+}
+
+// noteCanceledTaskLOCKED remembers a task that's removed by a cancel
+// or an expiry.
+func (m *CtlMgr) noteCanceledTaskLOCKED(task *service.Task) {
+	m.canceledTasks = append(m.canceledTasks,
+		canceledTask{id: task.ID, rev: string(task.Rev)})
+	if len(m.canceledTasks) > CtlCanceledTasksMax {
+		m.canceledTasks = append([]canceledTask(nil),
+			m.canceledTasks[len(m.canceledTasks)-CtlCanceledTasksMax:]...)
+	}
+}
+
+// wasCanceled returns true if the task, of its revision, was canceled
+// or expired.
+func (m *CtlMgr) wasCanceled(task *service.Task) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range m.canceledTasks {
+		if c.id == task.ID && c.rev == string(task.Rev) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffTaskEvents returns the lifecycle events that turn the prev
+// tasks into the curr tasks.  A task that goes away without having
+// failed is considered completed, unless the optional canceled func
+// reports it as canceled.
+func diffTaskEvents(prev, curr []service.Task, now time.Time,
+	canceled func(task *service.Task) bool) []CtlTaskEvent {
+	prevTasks := make(map[string]*service.Task, len(prev))
+	for i := range prev {
+		prevTasks[prev[i].ID] = &prev[i]
+	}
+
+	var rv []CtlTaskEvent
+
+	currIDs := make(map[string]bool, len(curr))
+	for _, task := range curr {
+		currIDs[task.ID] = true
+
+		event := ""
+
+		prevTask := prevTasks[task.ID]
+		switch {
+		case prevTask == nil:
+			event = CtlTaskEventCreated
+			if task.Status == service.TaskStatusFailed {
+				event = CtlTaskEventFailed
+			}
+		case task.Status == service.TaskStatusFailed:
+			if prevTask.Status != service.TaskStatusFailed {
+				event = CtlTaskEventFailed
+			}
+		case string(task.Rev) != string(prevTask.Rev):
+			event = CtlTaskEventProgress
+		}
+
+		if event != "" {
+			rv = append(rv, CtlTaskEvent{Event: event, Time: now, Task: task})
+		}
+	}
+
+	for _, task := range prev {
+		if !currIDs[task.ID] && task.Status != service.TaskStatusFailed {
+			event := CtlTaskEventCompleted
+			if canceled != nil && canceled(&task) {
+				event = CtlTaskEventCanceled
+			}
+			rv = append(rv, CtlTaskEvent{Event: event, Time: now, Task: task})
+		}
+	}
+
+	return rv
+}
+
+// waitTasks returns the current tasks once their revision differs
+// from haveRevNum, or ok of false when the stopCh is closed first.
+func (m *CtlMgr) waitTasks(haveRevNum uint64, stopCh <-chan struct{}) (
+	tasks []service.Task, revNum uint64, ok bool) {
+	for {
+		var snapshot interface{}
+		var waitCh chan struct{}
+
+		revNum, snapshot, waitCh = m.tasksBroadcast.current()
+		if revNum != haveRevNum {
+			if hasPreparedTask(snapshot.(*service.TaskList)) {
+				m.expirePreparedTasks()
+				revNum, snapshot, _ = m.tasksBroadcast.current()
+			}
+
+			// The tasks are shared by the waiters, so they're read-only.
+			return snapshot.(*service.TaskList).Tasks, revNum, true
+		}
+
+		select {
+		case <-stopCh:
+			return nil, 0, false
+		case <-waitCh:
+		}
+	}
+}
+
+// ------------------------------------------------
+
+// CtlTaskEventsHandler is a REST handler that streams the task
+// lifecycle events as Server-Sent Events, with the JSON of a
+// CtlTaskEvent as the data of each event.  The tasks that exist when
+// the stream starts are sent as created events.
+type CtlTaskEventsHandler struct {
+	m *CtlMgr
+}
+
+func NewCtlTaskEventsHandler(mgr *CtlMgr) *CtlTaskEventsHandler {
+	return &CtlTaskEventsHandler{m: mgr}
+}
+
+func (h *CtlTaskEventsHandler) RESTOpts(opts map[string]string) {
+	opts["result"] =
+		"A text/event-stream of task events, where each event's type" +
+			" is one of created, progress, failed, completed or canceled."
+}
+
+func (h *CtlTaskEventsHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		rest.ShowError(w, req, "ctl/manager: task events,"+
+			" streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	stopCh := req.Context().Done()
+
+	type tasksRev struct {
+		tasks  []service.Task
+		revNum uint64
+	}
+
+	tasksCh := make(chan tasksRev)
+
+	go func() {
+		// Never a revision, so that the current tasks are sent first.
+		haveRevNum := uint64(math.MaxUint64)
+		for {
+			tasks, revNum, ok := h.m.waitTasks(haveRevNum, stopCh)
+			if !ok {
+				return
+			}
+
+			select {
+			case <-stopCh:
+				return
+			case tasksCh <- tasksRev{tasks: tasks, revNum: revNum}:
+			}
+
+			haveRevNum = revNum
+		}
+	}()
+
+	keepAlive := time.NewTicker(CtlTaskEventsKeepAlive)
+	defer keepAlive.Stop()
+
+	var prev []service.Task
+
+	for {
+		select {
+		case <-stopCh:
+			return
+
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case tr := <-tasksCh:
+			for _, ev := range diffTaskEvents(prev, tr.tasks, time.Now(),
+				h.m.wasCanceled) {
+				data, err := json.Marshal(&ev)
+				if err != nil {
+					log.Warnf("ctl/manager: task events, json, err: %v", err)
+					continue
+				}
+
+				_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n",
+					tr.revNum, ev.Event, data)
+				if err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+
+			prev = tr.tasks
+		}
+	}
+}
@@ -0,0 +1,173 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbauth/service"
+)
+
+func testTask(id, rev string, status service.TaskStatus) service.Task {
+	return service.Task{ID: id, Rev: service.Revision(rev), Status: status}
+}
+
+func TestDiffTaskEvents(t *testing.T) {
+	running, failed := service.TaskStatusRunning, service.TaskStatusFailed
+
+	canceled := func(task *service.Task) bool {
+		return task.ID == "c" && string(task.Rev) == "1"
+	}
+
+	tests := []struct {
+		name       string
+		prev, curr []service.Task
+		exp        map[string]string // Task ID -> event.
+	}{
+		{"no changes",
+			[]service.Task{testTask("a", "1", running)},
+			[]service.Task{testTask("a", "1", running)},
+			map[string]string{}},
+		{"created",
+			nil,
+			[]service.Task{testTask("a", "1", running)},
+			map[string]string{"a": CtlTaskEventCreated}},
+		{"created as failed",
+			nil,
+			[]service.Task{testTask("a", "1", failed)},
+			map[string]string{"a": CtlTaskEventFailed}},
+		{"progress",
+			[]service.Task{testTask("a", "1", running)},
+			[]service.Task{testTask("a", "2", running)},
+			map[string]string{"a": CtlTaskEventProgress}},
+		{"failed once",
+			[]service.Task{testTask("a", "1", failed)},
+			[]service.Task{testTask("a", "2", failed)},
+			map[string]string{}},
+		{"completed",
+			[]service.Task{testTask("a", "1", running)},
+			nil,
+			map[string]string{"a": CtlTaskEventCompleted}},
+		{"failed task removed",
+			[]service.Task{testTask("a", "1", failed)},
+			nil,
+			map[string]string{}},
+		{"canceled",
+			[]service.Task{testTask("c", "1", running),
+				testTask("d", "1", running)},
+			nil,
+			map[string]string{"c": CtlTaskEventCanceled,
+				"d": CtlTaskEventCompleted}},
+		{"canceled in an earlier rev",
+			[]service.Task{testTask("c", "2", running)},
+			nil,
+			map[string]string{"c": CtlTaskEventCompleted}},
+	}
+
+	for _, test := range tests {
+		got := map[string]string{}
+		for _, ev := range diffTaskEvents(test.prev, test.curr,
+			time.Now(), canceled) {
+			got[ev.Task.ID] = ev.Event
+		}
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("test: %s, expected: %v, got: %v",
+				test.name, test.exp, got)
+		}
+	}
+}
+
+func TestCtlTaskEventsHandler(t *testing.T) {
+	m := testPrepareCtlMgr(t)
+
+	addTask := func(id string) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		th := &taskHandle{
+			startTime: time.Now(),
+			task: &service.Task{
+				Rev:          EncodeRev(m.allocRevNumLOCKED(0)),
+				ID:           id,
+				Type:         service.TaskTypeRebalance,
+				Status:       service.TaskStatusRunning,
+				IsCancelable: true,
+			},
+		}
+		taskHandlesNext := append([]*taskHandle(nil), m.tasks.taskHandles...)
+		m.updateTasksLOCKED(func(s *tasks) {
+			s.taskHandles = append(taskHandlesNext, th)
+		})
+	}
+
+	addTask("t0")
+
+	s := httptest.NewServer(NewCtlTaskEventsHandler(m))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatalf("expected no err, err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got: %s", ct)
+	}
+
+	eventsCh := make(chan CtlTaskEvent, 10)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var ev CtlTaskEvent
+			if json.Unmarshal([]byte(data), &ev) == nil {
+				eventsCh <- ev
+			}
+		}
+		close(eventsCh)
+	}()
+
+	expectEvent := func(event, taskId string) {
+		select {
+		case ev := <-eventsCh:
+			if ev.Event != event || ev.Task.ID != taskId {
+				t.Fatalf("expected event: %s of task: %s, got: %s of %s",
+					event, taskId, ev.Event, ev.Task.ID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected event: %s of task: %s", event, taskId)
+		}
+	}
+
+	// The existing tasks are sent as created.
+	expectEvent(CtlTaskEventCreated, "t0")
+
+	if err = m.CancelTask("t0", nil); err != nil {
+		t.Fatalf("expected CancelTask to work, err: %v", err)
+	}
+	expectEvent(CtlTaskEventCanceled, "t0")
+
+	addTask("t1")
+	expectEvent(CtlTaskEventCreated, "t1")
+
+	// An update without progress or errors removes the task.
+	m.handleTaskProgress(taskProgress{taskId: "t1"})
+	expectEvent(CtlTaskEventCompleted, "t1")
+}
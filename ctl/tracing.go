@@ -0,0 +1,99 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"context"
+	"strings"
+
+	"github.com/couchbase/cbauth/service"
+	"github.com/couchbase/cbgt"
+)
+
+// A topologyChangeSpan is the root trace span of a topology change,
+// which lasts from its PrepareTopologyChange until its completion, so
+// that a slow rebalance can be traced on its orchestrating node.
+type topologyChangeSpan struct {
+	changeID string
+	ctx      context.Context
+	span     cbgt.TraceSpan
+}
+
+func startTopologyChangeSpan(
+	change service.TopologyChange) *topologyChangeSpan {
+	ctx, span := cbgt.StartSpan(context.Background(),
+		"cbgt.ctl.TopologyChange", cbgt.TraceAttributes{
+			"changeID":   change.ID,
+			"changeType": string(change.Type),
+		})
+
+	return &topologyChangeSpan{changeID: change.ID, ctx: ctx, span: span}
+}
+
+func (s *topologyChangeSpan) end(outcome string, errs []error) {
+	for _, err := range errs {
+		s.span.RecordError(err)
+	}
+	s.span.SetAttributes(cbgt.TraceAttributes{"outcome": outcome})
+	s.span.End()
+}
+
+// topologyChangeID returns the topology change ID of a prepared or
+// rebalance task ID.
+func topologyChangeID(taskID string) string {
+	for _, prefix := range []string{"prepare:", "rebalance:"} {
+		if strings.HasPrefix(taskID, prefix) {
+			return taskID[len(prefix):]
+		}
+	}
+	return taskID
+}
+
+// trackTopologyChangeSpan tracks the root span of a topology change
+// until it's ended.
+func (m *CtlMgr) trackTopologyChangeSpan(s *topologyChangeSpan) {
+	m.traceM.Lock()
+	if m.topologyChangeSpans == nil {
+		m.topologyChangeSpans = map[string]*topologyChangeSpan{}
+	}
+	m.topologyChangeSpans[s.changeID] = s
+	m.traceM.Unlock()
+}
+
+// topologyChangeSpanFor returns the root span of the topology change,
+// starting and tracking a new root span, with created of true, if the
+// change wasn't prepared by this node.
+func (m *CtlMgr) topologyChangeSpanFor(
+	change service.TopologyChange) (s *topologyChangeSpan, created bool) {
+	m.traceM.Lock()
+	s = m.topologyChangeSpans[change.ID]
+	m.traceM.Unlock()
+
+	if s == nil {
+		s = startTopologyChangeSpan(change)
+		m.trackTopologyChangeSpan(s)
+		created = true
+	}
+
+	return s, created
+}
+
+// endTopologyChangeSpan ends the root span of the topology change,
+// if it's still being tracked.
+func (m *CtlMgr) endTopologyChangeSpan(changeID string,
+	outcome string, errs []error) {
+	m.traceM.Lock()
+	s := m.topologyChangeSpans[changeID]
+	delete(m.topologyChangeSpans, changeID)
+	m.traceM.Unlock()
+
+	if s != nil {
+		s.end(outcome, errs)
+	}
+}
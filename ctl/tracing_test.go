@@ -0,0 +1,126 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package ctl
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/cbauth/service"
+	"github.com/couchbase/cbgt"
+)
+
+type testSpan struct {
+	name   string
+	parent string
+	attrs  cbgt.TraceAttributes
+	ended  bool
+}
+
+type testSpanKey struct{}
+
+// testTracer is a cbgt.Tracer that records its spans.
+type testTracer struct {
+	m     sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string,
+	attrs cbgt.TraceAttributes) (context.Context, cbgt.TraceSpan) {
+	s := &testSpan{name: name, attrs: cbgt.TraceAttributes{}}
+	if parent, ok := ctx.Value(testSpanKey{}).(*testSpan); ok {
+		s.parent = parent.name
+	}
+	for k, v := range attrs {
+		s.attrs[k] = v
+	}
+
+	t.m.Lock()
+	t.spans = append(t.spans, s)
+	t.m.Unlock()
+
+	return context.WithValue(ctx, testSpanKey{}, s), &testTracerSpan{t: t, s: s}
+}
+
+func (t *testTracer) span(name string) *testSpan {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	for _, s := range t.spans {
+		if s.name == name {
+			c := *s
+			return &c
+		}
+	}
+	return nil
+}
+
+type testTracerSpan struct {
+	t *testTracer
+	s *testSpan
+}
+
+func (ts *testTracerSpan) SetAttributes(attrs cbgt.TraceAttributes) {
+	ts.t.m.Lock()
+	for k, v := range attrs {
+		ts.s.attrs[k] = v
+	}
+	ts.t.m.Unlock()
+}
+
+func (ts *testTracerSpan) RecordError(err error) {}
+
+func (ts *testTracerSpan) End() {
+	ts.t.m.Lock()
+	ts.s.ended = true
+	ts.t.m.Unlock()
+}
+
+func TestTopologyChangeSpans(t *testing.T) {
+	tracer := &testTracer{}
+	cbgt.SetTracer(tracer)
+	defer cbgt.SetTracer(nil)
+
+	m := testPrepareCtlMgr(t)
+
+	_, err := cbgt.CfgSetNodeDefs(m.ctl.cfg, cbgt.NODE_DEFS_WANTED,
+		cbgt.NewNodeDefs(cbgt.VERSION), cbgt.CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected CfgSetNodeDefs to work, err: %v", err)
+	}
+
+	err = m.PrepareTopologyChange(service.TopologyChange{
+		ID:   "c0",
+		Type: service.TopologyChangeTypeRebalance,
+	})
+	if err != nil {
+		t.Fatalf("expected PrepareTopologyChange to work, err: %v", err)
+	}
+
+	root := tracer.span("cbgt.ctl.TopologyChange")
+	if root == nil || root.ended || root.attrs["changeID"] != "c0" {
+		t.Fatalf("expected an open root span, got: %+v", root)
+	}
+	prepare := tracer.span("cbgt.ctl.PrepareTopologyChange")
+	if prepare == nil || !prepare.ended ||
+		prepare.parent != "cbgt.ctl.TopologyChange" ||
+		prepare.attrs["taskID"] != "prepare:c0" {
+		t.Fatalf("expected an ended child prepare span, got: %+v", prepare)
+	}
+
+	if err = m.CancelTask("prepare:c0", nil); err != nil {
+		t.Fatalf("expected CancelTask to work, err: %v", err)
+	}
+
+	root = tracer.span("cbgt.ctl.TopologyChange")
+	if !root.ended || root.attrs["outcome"] != "canceled" {
+		t.Fatalf("expected a canceled root span, got: %+v", root)
+	}
+}
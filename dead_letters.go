@@ -0,0 +1,690 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+	"github.com/couchbase/gomemcached"
+)
+
+// The dead letter queues capture the mutations whose DataUpdate or
+// DataDelete a pindex's Dest failed, so that the feeds can move on
+// rather than restart on a poison document, and so that the failed
+// mutations can later be inspected, retried or purged.  A pindex's
+// queue is bounded, where the feeds get the errors of their failed
+// mutations once it's full, as before, rather than losing entries that
+// they have already moved past.  The queue is persisted in the
+// pindex's directory.  The queues are enabled with the
+// deadLetterQueueMaxItems manager option, which may be overridden per
+// index with the "deadLetterQueueMaxItems:"+indexName option.
+
+// PINDEX_DEAD_LETTERS_FILENAME is the file, in a pindex's directory,
+// of the pindex's dead letter queue.
+const PINDEX_DEAD_LETTERS_FILENAME string = "PINDEX_DEAD_LETTERS"
+
+// A DeadLetter is a mutation that a pindex's Dest failed to apply,
+// along with its extras, so that a retry applies it the same way, such
+// as to the same scope and collection.
+type DeadLetter struct {
+	ID        uint64    `json:"id"`
+	Time      time.Time `json:"time"`
+	Partition string    `json:"partition"`
+	Key       string    `json:"key"`
+	Seq       uint64    `json:"seq"`
+	Cas       uint64    `json:"cas"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	Value     []byte    `json:"value,omitempty"`
+
+	// The extras of the mutation, see deadLetterExtras().
+	ExtrasType DestExtrasType `json:"extrasType,omitempty"`
+	Extras     []byte         `json:"extras,omitempty"`
+
+	// Untransformed is true when the Value is yet to be transformed by
+	// the document transformers of the index, as their transform of it
+	// failed, so that a retry transforms it first.
+	Untransformed bool `json:"untransformed,omitempty"`
+
+	// Xattrs is true when the untransformed Value is prefixed by the
+	// xattrs of the document, which aren't transformed.
+	Xattrs bool `json:"xattrs,omitempty"`
+
+	// Err is the error of the most recent attempt.
+	Err      string `json:"err"`
+	Attempts int    `json:"attempts"`
+}
+
+// DeadLetterQueueMaxItems returns the most dead letters that the
+// pindexes of an index keep, where 0 means that their failed mutations
+// aren't captured.
+func DeadLetterQueueMaxItems(options map[string]string,
+	indexName string) int {
+	v, exists := options["deadLetterQueueMaxItems:"+indexName]
+	if !exists {
+		v = options["deadLetterQueueMaxItems"]
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// DeadLettersPersistInterval is how often the changed dead letter
+// queues are persisted, so that a burst of failed mutations is saved
+// with a single write rather than a write per mutation.
+var DeadLettersPersistInterval = time.Second
+
+// A deadLetterQueue holds the dead letters of a pindex.
+type deadLetterQueue struct {
+	mgr      *Manager
+	pindex   string
+	path     string // The file of the queue, or "" when not persisted.
+	maxItems int
+
+	size int32 // The len(letters), for the feeds' fast path.
+
+	// applyM serializes the Dest updates of the queued keys by the
+	// feeds with the retries, so that a retry cannot revert a key.
+	applyM sync.Mutex
+
+	persistM sync.Mutex // Serializes the writes of the file.
+
+	m       sync.Mutex // Protects the fields that follow.
+	nextID  uint64
+	letters []*DeadLetter
+	keys    map[deadLetterKey]int // The number of entries of each key.
+	dirty   bool                  // When the letters need persisting.
+	closed  bool                  // When the pindex is unregistered.
+}
+
+type deadLetterKey struct {
+	partition string
+	key       string
+}
+
+// The dead letter queues of the Dests, keyed by Dest.
+var (
+	deadLetterQueuesActive int32 // Fast path when there are none.
+	deadLetterQueuesM      sync.RWMutex
+	deadLetterQueues       = map[Dest]*deadLetterQueue{}
+)
+
+var deadLetterLogThrottle = LogThrottleFor("dead_letters")
+
+// registerDeadLetters sets up, and loads any persisted entries of, the
+// dead letter queue of a pindex's Dest, if its index captures failed
+// mutations.
+func (mgr *Manager) registerDeadLetters(pindex *PIndex) {
+	maxItems := DeadLetterQueueMaxItems(mgr.Options(), pindex.IndexName)
+	if maxItems <= 0 || pindex.Dest == nil {
+		return
+	}
+
+	q := &deadLetterQueue{
+		mgr:      mgr,
+		pindex:   pindex.Name,
+		maxItems: maxItems,
+		nextID:   1,
+		keys:     map[deadLetterKey]int{},
+	}
+
+	if len(mgr.dataDir) > 0 && len(pindex.Path) > 0 {
+		q.path = pindex.Path + string(os.PathSeparator) +
+			PINDEX_DEAD_LETTERS_FILENAME
+
+		var letters []*DeadLetter
+
+		buf, err := os.ReadFile(q.path)
+		if err == nil {
+			err = json.Unmarshal(buf, &letters)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Warnf("dead_letters: pindex: %s, could not load %s,"+
+				" err: %v", pindex.Name, q.path, err)
+		}
+
+		for _, l := range letters {
+			q.appendLOCKED(l)
+			if l.ID >= q.nextID {
+				q.nextID = l.ID + 1
+			}
+		}
+		q.dirty = false
+	}
+
+	deadLetterQueuesM.Lock()
+	deadLetterQueues[pindex.Dest] = q
+	atomic.StoreInt32(&deadLetterQueuesActive, int32(len(deadLetterQueues)))
+	deadLetterQueuesM.Unlock()
+}
+
+// unregisterDeadLetters removes, after persisting any changes of, the
+// dead letter queue of a pindex's Dest.  The persisted entries are
+// removed along with the pindex's directory.
+func unregisterDeadLetters(pindex *PIndex) {
+	if atomic.LoadInt32(&deadLetterQueuesActive) <= 0 ||
+		pindex.Dest == nil {
+		return
+	}
+
+	deadLetterQueuesM.Lock()
+	q := deadLetterQueues[pindex.Dest]
+	delete(deadLetterQueues, pindex.Dest)
+	atomic.StoreInt32(&deadLetterQueuesActive, int32(len(deadLetterQueues)))
+	deadLetterQueuesM.Unlock()
+
+	if q != nil {
+		q.m.Lock()
+		q.closed = true
+		q.m.Unlock()
+
+		q.persist()
+	}
+}
+
+func deadLetterQueueFor(dest Dest) *deadLetterQueue {
+	if atomic.LoadInt32(&deadLetterQueuesActive) <= 0 {
+		return nil
+	}
+
+	deadLetterQueuesM.RLock()
+	q := deadLetterQueues[dest]
+	deadLetterQueuesM.RUnlock()
+
+	return q
+}
+
+func noopUnlock() {}
+
+// lockDeadLetterKey is invoked by the feeds before they update or
+// delete a key in the dest, and returns the func that the feeds invoke
+// after the following deadLetterMutation().  When the key has queued
+// entries, the update is serialized with the retries of the entries.
+func lockDeadLetterKey(dest Dest, partition string, key []byte) func() {
+	q := deadLetterQueueFor(dest)
+	if q == nil || atomic.LoadInt32(&q.size) <= 0 {
+		return noopUnlock
+	}
+
+	q.m.Lock()
+	queued := q.keys[deadLetterKey{partition, string(key)}] > 0
+	q.m.Unlock()
+
+	if !queued {
+		return noopUnlock
+	}
+
+	q.applyM.Lock()
+	return q.applyM.Unlock
+}
+
+// deadLetterMutation captures a mutation, which a feed delivered to the
+// dest with the extras along with the dest's err, into the dest's dead
+// letter queue, and returns nil when it's captured so that the feed
+// moves on.  The errors of a closed or closing dest aren't captured,
+// and neither are those of a full queue, so that the feed restarts
+// instead.  A mutation that the dest applied supersedes the queued
+// entries of its key.  The feeds invoke it after each DataUpdate and
+// DataDelete.
+func deadLetterMutation(dest Dest, partition string, key []byte,
+	seq uint64, val []byte, cas uint64, deleted bool,
+	extrasType DestExtrasType, extras interface{}, err error) error {
+	q := deadLetterQueueFor(dest)
+	if q == nil {
+		return err
+	}
+
+	if err == nil {
+		if atomic.LoadInt32(&q.size) > 0 {
+			q.m.Lock()
+			k := deadLetterKey{partition, string(key)}
+			if q.keys[k] > 0 {
+				q.filterLOCKED(func(l *DeadLetter) bool {
+					return l.Partition != k.partition || l.Key != k.key
+				})
+			}
+			q.m.Unlock()
+		}
+		return nil
+	}
+
+	if errors.Is(err, ErrDestClosed) {
+		return err
+	}
+
+	l := &DeadLetter{
+		Partition: partition,
+		Key:       string(key),
+		Seq:       seq,
+		Cas:       cas,
+		Deleted:   deleted,
+		Value:     append([]byte(nil), val...),
+		Err:       err.Error(),
+	}
+	l.ExtrasType, l.Extras = deadLetterExtras(extrasType, extras)
+
+	if !q.capture(l) {
+		return err
+	}
+
+	return nil
+}
+
+// captureDeadLetter adds an entry to the dest's dead letter queue, if
+// any, returning an error when the queue didn't take it.
+func captureDeadLetter(dest Dest, l *DeadLetter) error {
+	if q := deadLetterQueueFor(dest); q != nil && !q.capture(l) {
+		return fmt.Errorf("dead_letters: pindex: %s, queue full or closed,"+
+			" err: %s", q.pindex, l.Err)
+	}
+	return nil
+}
+
+// capture adds an entry, returning false when the queue is closed or
+// full.
+func (q *deadLetterQueue) capture(l *DeadLetter) bool {
+	q.m.Lock()
+	if q.closed {
+		q.m.Unlock()
+		return false
+	}
+	if len(q.letters) >= q.maxItems {
+		q.m.Unlock()
+
+		atomic.AddUint64(&q.mgr.stats.TotDeadLettersFull, 1)
+
+		deadLetterLogThrottle.Warnf("dead_letters: pindex: %s, partition: %s,"+
+			" key: %s, seq: %d, queue full, err: %s", q.pindex, l.Partition,
+			log.Tag(log.UserData, l.Key), l.Seq, l.Err)
+
+		return false
+	}
+	l.ID = q.nextID
+	l.Time = time.Now()
+	l.Attempts = 1
+	q.appendLOCKED(l)
+	q.nextID++
+	q.m.Unlock()
+
+	atomic.AddUint64(&q.mgr.stats.TotDeadLetters, 1)
+
+	deadLetterLogThrottle.Warnf("dead_letters: pindex: %s, partition: %s,"+
+		" key: %s, seq: %d, captured, err: %s", q.pindex, l.Partition,
+		log.Tag(log.UserData, l.Key), l.Seq, l.Err)
+
+	return true
+}
+
+// appendLOCKED adds an entry.
+func (q *deadLetterQueue) appendLOCKED(l *DeadLetter) {
+	q.letters = append(q.letters, l)
+	q.keys[deadLetterKey{l.Partition, l.Key}]++
+
+	q.dirty = true
+	atomic.StoreInt32(&q.size, int32(len(q.letters)))
+}
+
+// filterLOCKED keeps only the entries that match keep, returning the
+// number of entries removed.
+func (q *deadLetterQueue) filterLOCKED(keep func(*DeadLetter) bool) int {
+	letters := make([]*DeadLetter, 0, len(q.letters))
+	for _, l := range q.letters {
+		if keep(l) {
+			letters = append(letters, l)
+		} else {
+			q.forgetKeyLOCKED(l)
+		}
+	}
+
+	n := len(q.letters) - len(letters)
+	if n > 0 {
+		q.letters = letters
+		q.dirty = true
+		atomic.StoreInt32(&q.size, int32(len(q.letters)))
+	}
+	return n
+}
+
+func (q *deadLetterQueue) forgetKeyLOCKED(l *DeadLetter) {
+	k := deadLetterKey{l.Partition, l.Key}
+	if q.keys[k]--; q.keys[k] <= 0 {
+		delete(q.keys, k)
+	}
+}
+
+func (q *deadLetterQueue) findLOCKED(id uint64) *DeadLetter {
+	for _, l := range q.letters {
+		if l.ID == id {
+			return l
+		}
+	}
+	return nil
+}
+
+// persist saves the queue's entries if they changed, which is best
+// effort, as the entries are still available in memory.
+func (q *deadLetterQueue) persist() {
+	if q.path == "" {
+		return
+	}
+
+	q.persistM.Lock()
+	defer q.persistM.Unlock()
+
+	q.m.Lock()
+	if !q.dirty {
+		q.m.Unlock()
+		return
+	}
+	buf, err := json.Marshal(q.letters)
+	q.dirty = false
+	q.m.Unlock()
+
+	if err == nil {
+		tmpPath := q.path + ".tmp"
+		err = os.WriteFile(tmpPath, buf, 0600)
+		if err == nil {
+			err = os.Rename(tmpPath, q.path)
+		}
+	}
+	if err != nil {
+		log.Warnf("dead_letters: pindex: %s, could not save %s, err: %v",
+			q.pindex, q.path, err)
+
+		q.m.Lock()
+		q.dirty = true
+		q.m.Unlock()
+	}
+}
+
+// DeadLettersPersistLoop persists the changed dead letter queues of the
+// manager's pindexes, until the manager is stopped.
+func (mgr *Manager) DeadLettersPersistLoop() {
+	ticker := time.NewTicker(DeadLettersPersistInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mgr.stopCh:
+			return
+		case <-ticker.C:
+			mgr.deadLettersPersistOnce()
+		}
+	}
+}
+
+func (mgr *Manager) deadLettersPersistOnce() {
+	if atomic.LoadInt32(&deadLetterQueuesActive) <= 0 {
+		return
+	}
+
+	var queues []*deadLetterQueue
+
+	deadLetterQueuesM.RLock()
+	for _, q := range deadLetterQueues {
+		if q.mgr == mgr {
+			queues = append(queues, q)
+		}
+	}
+	deadLetterQueuesM.RUnlock()
+
+	for _, q := range queues {
+		q.persist()
+	}
+}
+
+// -----------------------------------------------------
+
+func (mgr *Manager) deadLetterQueue(
+	pindexName string) (*PIndex, *deadLetterQueue, error) {
+	_, pindexes := mgr.CurrentMaps()
+	pindex := pindexes[pindexName]
+	if pindex == nil || pindex.Dest == nil {
+		return nil, nil, fmt.Errorf("dead_letters: no pindex: %s", pindexName)
+	}
+
+	q := deadLetterQueueFor(pindex.Dest)
+	if q == nil {
+		return nil, nil, fmt.Errorf("dead_letters: pindex: %s,"+
+			" no dead letter queue, see the deadLetterQueueMaxItems option",
+			pindexName)
+	}
+
+	return pindex, q, nil
+}
+
+// DeadLetters returns the dead letters of a local pindex, oldest
+// first.
+func (mgr *Manager) DeadLetters(pindexName string) ([]*DeadLetter, error) {
+	_, q, err := mgr.deadLetterQueue(pindexName)
+	if err != nil {
+		return nil, err
+	}
+
+	q.m.Lock()
+	rv := make([]*DeadLetter, 0, len(q.letters))
+	for _, l := range q.letters {
+		c := *l
+		rv = append(rv, &c)
+	}
+	q.m.Unlock()
+
+	return rv, nil
+}
+
+// selectLOCKED returns the IDs of the entries with the given IDs, or
+// of all of the entries when there are no IDs.
+func (q *deadLetterQueue) selectLOCKED(ids []uint64) map[uint64]bool {
+	m := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		m[id] = true
+	}
+
+	rv := map[uint64]bool{}
+	for _, l := range q.letters {
+		if len(ids) == 0 || m[l.ID] {
+			rv[l.ID] = true
+		}
+	}
+	return rv
+}
+
+// RetryDeadLetters re-applies the dead letters of a local pindex with
+// the given IDs, or all of them when there are no IDs, to the pindex's
+// Dest, oldest first.  An entry that's applied is removed along with
+// the older entries of its key, while the others are kept with their
+// latest error.  The entries that were superseded by the feeds in the
+// meantime are skipped.
+func (mgr *Manager) RetryDeadLetters(pindexName string,
+	ids []uint64) (retried, failed int, err error) {
+	pindex, q, err := mgr.deadLetterQueue(pindexName)
+	if err != nil {
+		return 0, 0, err
+	}
+	if mgr.IsShadowCopyTarget(pindex.IndexName) {
+		return 0, 0, fmt.Errorf("dead_letters: RetryDeadLetters,"+
+			" pindex: %s, err: %w", pindexName, ErrShadowCopyReadOnly)
+	}
+
+	q.m.Lock()
+	selected := q.selectLOCKED(ids)
+	letters := q.filterCopyLOCKED(selected)
+	q.m.Unlock()
+
+	for _, l := range letters {
+		ok, err := q.retry(pindex.Dest, l)
+		if err != nil {
+			failed++
+		} else if ok {
+			retried++
+		}
+	}
+
+	atomic.AddUint64(&mgr.stats.TotDeadLettersRetried, uint64(retried))
+
+	return retried, failed, nil
+}
+
+// filterCopyLOCKED returns copies of the entries with the given IDs,
+// oldest first.
+func (q *deadLetterQueue) filterCopyLOCKED(
+	ids map[uint64]bool) []*DeadLetter {
+	var rv []*DeadLetter
+	for _, l := range q.letters {
+		if ids[l.ID] {
+			c := *l
+			rv = append(rv, &c)
+		}
+	}
+	return rv
+}
+
+// retry re-applies an entry to the dest, returning false when the
+// entry is no longer queued, such as when a feed superseded it.
+func (q *deadLetterQueue) retry(dest Dest, l *DeadLetter) (bool, error) {
+	q.applyM.Lock()
+	defer q.applyM.Unlock()
+
+	q.m.Lock()
+	current := q.findLOCKED(l.ID) != nil
+	q.m.Unlock()
+
+	if !current {
+		return false, nil
+	}
+
+	var extras interface{} = l.Extras
+	if l.ExtrasType == DEST_EXTRAS_TYPE_GOCBCORE_DCP {
+		var x GocbcoreDCPExtras
+		err := json.Unmarshal(l.Extras, &x)
+		if err != nil {
+			return false, err
+		}
+		extras = x
+	}
+
+	var err error
+
+	key := []byte(l.Key)
+	if l.Deleted {
+		err = destDataDelete(dest, l.Partition, key, l.Seq, l.Cas,
+			l.ExtrasType, extras)
+	} else {
+		val := l.Value
+		if l.Untransformed {
+			val, err = transformMutationXattrs(dest, l.Partition, key, val,
+				l.Xattrs)
+		}
+		if err == nil {
+			err = destDataUpdate(dest, l.Partition, key, l.Seq, val,
+				l.Cas, l.ExtrasType, extras)
+		}
+	}
+
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if err != nil {
+		if curr := q.findLOCKED(l.ID); curr != nil {
+			curr.Err = err.Error()
+			curr.Attempts++
+			q.dirty = true
+		}
+		return false, err
+	}
+
+	q.filterLOCKED(func(o *DeadLetter) bool {
+		return o.Partition != l.Partition || o.Key != l.Key || o.ID > l.ID
+	})
+
+	return true, nil
+}
+
+// deadLetterExtras returns the extras of a mutation in the form that a
+// DeadLetter keeps, which are the extras bytes, or the JSON of the
+// GocbcoreDCPExtras, or the DCP extras of a MCRequest.
+func deadLetterExtras(extrasType DestExtrasType,
+	extras interface{}) (DestExtrasType, []byte) {
+	switch x := extras.(type) {
+	case []byte:
+		return extrasType, append([]byte(nil), x...)
+	case GocbcoreDCPExtras:
+		buf, err := json.Marshal(x)
+		if err == nil {
+			return extrasType, buf
+		}
+	case *gomemcached.MCRequest:
+		if x != nil {
+			return DEST_EXTRAS_TYPE_DCP, append([]byte(nil), x.Extras...)
+		}
+	}
+	return DEST_EXTRAS_TYPE_NIL, nil
+}
+
+// destDataUpdate applies an update to the dest, via its DataUpdateEx
+// for the extras that aren't bytes.
+func destDataUpdate(dest Dest, partition string, key []byte, seq uint64,
+	val []byte, cas uint64, extrasType DestExtrasType,
+	extras interface{}) error {
+	if buf, ok := extras.([]byte); ok || extras == nil {
+		return dest.DataUpdate(partition, key, seq, val, cas,
+			extrasType, buf)
+	}
+	destEx, ok := dest.(DestEx)
+	if !ok {
+		return fmt.Errorf("dead_letters: extras type: %d, needs a DestEx",
+			extrasType)
+	}
+	return destEx.DataUpdateEx(partition, key, seq, val, cas,
+		extrasType, extras)
+}
+
+// destDataDelete applies a deletion to the dest, via its DataDeleteEx
+// for the extras that aren't bytes.
+func destDataDelete(dest Dest, partition string, key []byte, seq uint64,
+	cas uint64, extrasType DestExtrasType, extras interface{}) error {
+	if buf, ok := extras.([]byte); ok || extras == nil {
+		return dest.DataDelete(partition, key, seq, cas, extrasType, buf)
+	}
+	destEx, ok := dest.(DestEx)
+	if !ok {
+		return fmt.Errorf("dead_letters: extras type: %d, needs a DestEx",
+			extrasType)
+	}
+	return destEx.DataDeleteEx(partition, key, seq, cas, extrasType, extras)
+}
+
+// PurgeDeadLetters removes the dead letters of a local pindex with the
+// given IDs, or all of them when there are no IDs, returning the
+// number removed.
+func (mgr *Manager) PurgeDeadLetters(pindexName string,
+	ids []uint64) (int, error) {
+	_, q, err := mgr.deadLetterQueue(pindexName)
+	if err != nil {
+		return 0, err
+	}
+
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	purge := q.selectLOCKED(ids)
+
+	return q.filterLOCKED(func(l *DeadLetter) bool {
+		return !purge[l.ID]
+	}), nil
+}
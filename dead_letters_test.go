@@ -0,0 +1,269 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testFailingDest struct {
+	testHTTPFeedDest
+	failKeys map[string]bool
+	failErr  error // Optional, instead of a "boom" error.
+
+	// Optional, DataUpdate signals its start on the blockCh, then
+	// waits on it.
+	blockCh chan struct{}
+
+	// The extras of the last applied DataUpdate.
+	extrasType DestExtrasType
+	extras     []byte
+}
+
+func (d *testFailingDest) DataUpdate(partition string,
+	key []byte, seq uint64, val []byte, cas uint64,
+	extrasType DestExtrasType, extras []byte) error {
+	if d.blockCh != nil {
+		d.blockCh <- struct{}{}
+		<-d.blockCh
+	}
+	if d.failKeys[string(key)] {
+		if d.failErr != nil {
+			return d.failErr
+		}
+		return errors.New("boom")
+	}
+	d.extrasType, d.extras = extrasType, extras
+	return d.testHTTPFeedDest.DataUpdate(partition, key, seq, val, cas,
+		extrasType, extras)
+}
+
+func TestDeadLetterQueueMaxItems(t *testing.T) {
+	options := map[string]string{
+		"deadLetterQueueMaxItems":    "10",
+		"deadLetterQueueMaxItems:i1": "0",
+		"deadLetterQueueMaxItems:i2": "-1",
+	}
+	if DeadLetterQueueMaxItems(options, "i0") != 10 {
+		t.Errorf("expected the default max items")
+	}
+	if DeadLetterQueueMaxItems(options, "i1") != 0 ||
+		DeadLetterQueueMaxItems(options, "i2") != 0 ||
+		DeadLetterQueueMaxItems(nil, "i0") != 0 {
+		t.Errorf("expected no dead letter queue")
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	dataDir := t.TempDir()
+
+	mgr := NewManagerEx(VERSION, nil, NewUUID(), []string{"pindex"},
+		"", 1, "", "", dataDir, "", nil, map[string]string{
+			"deadLetterQueueMaxItems": "2",
+		})
+
+	path := PIndexPath(dataDir, "p0")
+	if err := os.MkdirAll(path, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := &testFailingDest{failKeys: map[string]bool{
+		"a": true, "b": true, "c": true,
+	}}
+	pindex := &PIndex{Name: "p0", IndexName: "i0", Path: path, Dest: dest}
+	mgr.registerPIndex(pindex)
+
+	feed := NewPrimaryFeed("f0", "i0", BasicPartitionFunc,
+		map[string]Dest{"0": dest})
+	extras := []byte{1, 0, 0, 0, 8, 0, 0, 0} // The scope and collection.
+	for i, key := range []string{"a", "b", "c", "d"} {
+		err := feed.DataUpdate("0", []byte(key), uint64(i+1),
+			[]byte("v"+key), 0, DEST_EXTRAS_TYPE_GOCBCORE_SCOPE_COLLECTION,
+			extras)
+		if (err != nil) != (key == "c") {
+			t.Fatalf("expected the failure to be captured unless the"+
+				" queue is full, key: %s, err: %v", key, err)
+		}
+	}
+
+	// The full queue keeps its entries, and fails the feed instead.
+	deadLetters, err := mgr.DeadLetters("p0")
+	if err != nil || len(deadLetters) != 2 ||
+		deadLetters[0].Key != "a" || deadLetters[1].Key != "b" {
+		t.Fatalf("unexpected dead letters: %+v, err: %v", deadLetters, err)
+	}
+	if mgr.stats.TotDeadLetters != 2 || mgr.stats.TotDeadLettersFull != 1 {
+		t.Fatalf("unexpected stats: %+v", mgr.stats)
+	}
+	if deadLetters[1].ExtrasType != DEST_EXTRAS_TYPE_GOCBCORE_SCOPE_COLLECTION ||
+		!reflect.DeepEqual(deadLetters[1].Extras, extras) {
+		t.Fatalf("expected the extras to be kept, got: %+v", deadLetters[1])
+	}
+
+	// A newer mutation of a key supersedes its entry.
+	delete(dest.failKeys, "a")
+	feed.DataUpdate("0", []byte("a"), 5, []byte("va2"), 0,
+		DEST_EXTRAS_TYPE_NIL, nil)
+
+	deadLetters, _ = mgr.DeadLetters("p0")
+	if len(deadLetters) != 1 || deadLetters[0].Key != "b" {
+		t.Fatalf("expected only b, got: %+v", deadLetters)
+	}
+	id := deadLetters[0].ID
+
+	// The entries are persisted in the background.
+	deadLettersPath := path + string(os.PathSeparator) +
+		PINDEX_DEAD_LETTERS_FILENAME
+	if _, err = os.Stat(deadLettersPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no persisted entries yet, err: %v", err)
+	}
+	mgr.deadLettersPersistOnce()
+	if _, err = os.Stat(deadLettersPath); err != nil {
+		t.Fatalf("expected persisted entries, err: %v", err)
+	}
+
+	// The entries are reloaded with the pindex.
+	mgr.unregisterPIndex("p0", nil)
+	mgr.registerPIndex(pindex)
+
+	deadLetters, _ = mgr.DeadLetters("p0")
+	if len(deadLetters) != 1 || deadLetters[0].ID != id ||
+		string(deadLetters[0].Value) != "vb" {
+		t.Fatalf("expected the persisted entry, got: %+v", deadLetters)
+	}
+
+	retried, failed, err := mgr.RetryDeadLetters("p0", nil)
+	if err != nil || retried != 0 || failed != 1 {
+		t.Fatalf("expected a failed retry, got: %d, %d, err: %v",
+			retried, failed, err)
+	}
+	deadLetters, _ = mgr.DeadLetters("p0")
+	if len(deadLetters) != 1 || deadLetters[0].Attempts != 2 {
+		t.Fatalf("expected another attempt, got: %+v", deadLetters)
+	}
+
+	delete(dest.failKeys, "b")
+	retried, failed, err = mgr.RetryDeadLetters("p0", []uint64{id})
+	if err != nil || retried != 1 || failed != 0 {
+		t.Fatalf("expected a retry, got: %d, %d, err: %v",
+			retried, failed, err)
+	}
+
+	// The retry replays the extras of the mutation.
+	if dest.extrasType != DEST_EXTRAS_TYPE_GOCBCORE_SCOPE_COLLECTION ||
+		!reflect.DeepEqual(dest.extras, extras) {
+		t.Fatalf("expected the extras to be replayed, got: %d, %v",
+			dest.extrasType, dest.extras)
+	}
+
+	exp := []string{"update:d=vd", "update:a=va2", "update:b=vb"}
+	if !reflect.DeepEqual(dest.events, exp) {
+		t.Fatalf("expected: %v, got: %v", exp, dest.events)
+	}
+
+	dest.failKeys["e"] = true
+	feed.DataUpdate("0", []byte("e"), 6, []byte("ve"), 0,
+		DEST_EXTRAS_TYPE_NIL, nil)
+	n, err := mgr.PurgeDeadLetters("p0", nil)
+	if err != nil || n != 1 {
+		t.Fatalf("expected a purge, got: %d, err: %v", n, err)
+	}
+	if deadLetters, _ = mgr.DeadLetters("p0"); len(deadLetters) != 0 {
+		t.Fatalf("expected no dead letters, got: %+v", deadLetters)
+	}
+
+	// The errors of a closed dest aren't captured.
+	dest.failErr = fmt.Errorf("closing, %w", ErrDestClosed)
+	err = feed.DataUpdate("0", []byte("e"), 7, []byte("ve"), 0,
+		DEST_EXTRAS_TYPE_NIL, nil)
+	if !errors.Is(err, ErrDestClosed) {
+		t.Fatalf("expected the closed err, got: %v", err)
+	}
+	if deadLetters, _ = mgr.DeadLetters("p0"); len(deadLetters) != 0 {
+		t.Fatalf("expected no dead letters, got: %+v", deadLetters)
+	}
+
+	mgr.unregisterPIndex("p0", nil)
+	if _, err = mgr.DeadLetters("p0"); err == nil {
+		t.Fatalf("expected no pindex err")
+	}
+}
+
+func TestDeadLettersRetryVsFeed(t *testing.T) {
+	mgr := NewManagerEx(VERSION, nil, NewUUID(), []string{"pindex"},
+		"", 1, "", "", "", "", nil, map[string]string{
+			"deadLetterQueueMaxItems": "10",
+		})
+
+	dest := &testFailingDest{failKeys: map[string]bool{"a": true}}
+	mgr.registerPIndex(&PIndex{Name: "p0", IndexName: "i0", Dest: dest})
+	defer mgr.unregisterPIndex("p0", nil)
+
+	feed := NewPrimaryFeed("f0", "i0", BasicPartitionFunc,
+		map[string]Dest{"0": dest})
+	feed.DataUpdate("0", []byte("a"), 1, []byte("v1"), 0,
+		DEST_EXTRAS_TYPE_NIL, nil)
+	delete(dest.failKeys, "a")
+
+	// The retry of the entry is stuck in the dest while the feed
+	// delivers a newer mutation of the key.
+	dest.blockCh = make(chan struct{})
+
+	retryDoneCh := make(chan struct{})
+	go func() {
+		mgr.RetryDeadLetters("p0", nil)
+		close(retryDoneCh)
+	}()
+	<-dest.blockCh
+
+	feedDoneCh := make(chan struct{})
+	go func() {
+		feed.DataUpdate("0", []byte("a"), 2, []byte("v2"), 0,
+			DEST_EXTRAS_TYPE_NIL, nil)
+		close(feedDoneCh)
+	}()
+
+	select {
+	case <-dest.blockCh:
+		t.Fatalf("expected the feed to wait for the retry")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	dest.blockCh <- struct{}{} // Releases the retry.
+	<-retryDoneCh
+
+	<-dest.blockCh // The feed's update.
+	dest.blockCh <- struct{}{}
+	<-feedDoneCh
+
+	exp := []string{"update:a=v1", "update:a=v2"}
+	if !reflect.DeepEqual(dest.events, exp) {
+		t.Fatalf("expected: %v, got: %v", exp, dest.events)
+	}
+
+	// A retry of an entry that a feed superseded is skipped.
+	dest.blockCh = nil
+	dest.failKeys["b"] = true
+	feed.DataUpdate("0", []byte("b"), 3, []byte("v1"), 0,
+		DEST_EXTRAS_TYPE_NIL, nil)
+	delete(dest.failKeys, "b")
+	feed.DataUpdate("0", []byte("b"), 4, []byte("v2"), 0,
+		DEST_EXTRAS_TYPE_NIL, nil)
+
+	retried, failed, err := mgr.RetryDeadLetters("p0", nil)
+	if err != nil || retried != 0 || failed != 0 {
+		t.Fatalf("expected nothing to retry, got: %d, %d, err: %v",
+			retried, failed, err)
+	}
+}
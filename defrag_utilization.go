@@ -0,0 +1,133 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"encoding/json"
+	"strconv"
+
+	log "github.com/couchbase/clog"
+)
+
+// CalcDefragmentedUtilization returns the projected utilization of the
+// nodes that can hold pindexes, keyed by NodeDef.HostPort, as if the
+// pindexes of the plan, including their replicas, were spread across
+// the nodes in proportion to the node weights.  It's the built-in
+// default when applications don't provide a defragmented utilization
+// hook, where the stats of a node are...
+//
+//   - "pindexCount", the projected number of pindexes.
+//   - "diskBytes", the projected disk usage, when either the
+//     PIndexDiskSizeHook or the NodeDiskCapacityHook is set.
+//   - "memoryQuota", the memory quota of the node, from a "memoryQuota"
+//     in its NodeDef.Extras or else the "ftsMemoryQuota" option, when
+//     either is set.
+func CalcDefragmentedUtilization(nodeDefs *NodeDefs,
+	planPIndexes *PlanPIndexes,
+	options map[string]string) map[string]map[string]interface{} {
+	rv := map[string]map[string]interface{}{}
+	if nodeDefs == nil {
+		return rv
+	}
+
+	nodeUUIDs, nodeWeights, _ := GetNodeWeightsAndHierarchy(nodeDefs)
+
+	var totalWeight int
+	for _, nodeUUID := range nodeUUIDs {
+		totalWeight += nodeWeight(nodeWeights, nodeUUID)
+	}
+	if totalWeight <= 0 {
+		return rv
+	}
+
+	var totalPIndexes int
+	var totalDisk int64
+	var diskKnown bool
+
+	if planPIndexes != nil {
+		for _, planPIndex := range planPIndexes.PlanPIndexes {
+			totalPIndexes += len(planPIndex.Nodes)
+
+			if PIndexDiskSizeHook != nil && len(planPIndex.Nodes) > 0 {
+				size, err := PIndexDiskSizeHook(planPIndex)
+				if err != nil {
+					log.Warnf("defrag_utilization: pindex: %s, size, err: %v",
+						planPIndex.Name, err)
+					continue
+				}
+				totalDisk += size * int64(len(planPIndex.Nodes))
+				diskKnown = true
+			}
+		}
+	}
+
+	if PIndexDiskSizeHook == nil && NodeDiskCapacityHook != nil {
+		for _, nodeUUID := range nodeUUIDs {
+			capacity, err := NodeDiskCapacityHook(nodeDefs.NodeDefs[nodeUUID])
+			if err != nil || capacity == nil {
+				log.Warnf("defrag_utilization: node: %s, capacity, err: %v",
+					nodeUUID, err)
+				continue
+			}
+			totalDisk += capacity.Used
+			diskKnown = true
+		}
+	}
+
+	for _, nodeUUID := range nodeUUIDs {
+		nodeDef := nodeDefs.NodeDefs[nodeUUID]
+		share := float64(nodeWeight(nodeWeights, nodeUUID)) /
+			float64(totalWeight)
+
+		stats := map[string]interface{}{
+			"pindexCount": float64(totalPIndexes) * share,
+		}
+		if diskKnown {
+			stats["diskBytes"] = int64(float64(totalDisk) * share)
+		}
+		if memoryQuota, ok := nodeMemoryQuota(nodeDef, options); ok {
+			stats["memoryQuota"] = memoryQuota
+		}
+
+		rv[nodeDef.HostPort] = stats
+	}
+
+	return rv
+}
+
+func nodeWeight(nodeWeights map[string]int, nodeUUID string) int {
+	if w, exists := nodeWeights[nodeUUID]; exists {
+		return w
+	}
+	return 1 // Like the planner, a missing weight means 1.
+}
+
+// nodeMemoryQuota returns the memory quota of a node, in bytes.
+func nodeMemoryQuota(nodeDef *NodeDef,
+	options map[string]string) (int64, bool) {
+	if nodeDef.Extras != "" {
+		var extras struct {
+			MemoryQuota json.Number `json:"memoryQuota"`
+		}
+		if json.Unmarshal([]byte(nodeDef.Extras), &extras) == nil &&
+			extras.MemoryQuota != "" {
+			if v, err := extras.MemoryQuota.Int64(); err == nil {
+				return v, true
+			}
+		}
+	}
+
+	if s := options["ftsMemoryQuota"]; s != "" {
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return v, true
+		}
+	}
+
+	return 0, false
+}
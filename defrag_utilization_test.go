@@ -0,0 +1,57 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCalcDefragmentedUtilization(t *testing.T) {
+	defer func() { PIndexDiskSizeHook = nil }()
+
+	nodeDefs := NewNodeDefs(VERSION)
+	nodeDefs.NodeDefs["a"] = &NodeDef{UUID: "a", HostPort: "a:8094", Weight: 3,
+		Extras: `{"memoryQuota":"2048"}`}
+	nodeDefs.NodeDefs["b"] = &NodeDef{UUID: "b", HostPort: "b:8094"}
+	nodeDefs.NodeDefs["q"] = &NodeDef{UUID: "q", HostPort: "q:8094",
+		Tags: []string{"queryer"}}
+
+	// All the pindexes are on a, which is fragmented.
+	planPIndexes := NewPlanPIndexes(VERSION)
+	for _, name := range []string{"p0", "p1"} {
+		planPIndexes.PlanPIndexes[name] = &PlanPIndex{Name: name,
+			Nodes: map[string]*PlanPIndexNode{"a": {}, "b": {Priority: 1}}}
+	}
+
+	rv := CalcDefragmentedUtilization(nodeDefs, planPIndexes,
+		map[string]string{"ftsMemoryQuota": "1024"})
+	exp := map[string]map[string]interface{}{
+		"a:8094": {"pindexCount": 3.0, "memoryQuota": int64(2048)},
+		"b:8094": {"pindexCount": 1.0, "memoryQuota": int64(1024)},
+	}
+	if !reflect.DeepEqual(rv, exp) {
+		t.Fatalf("expected: %v, got: %v", exp, rv)
+	}
+
+	PIndexDiskSizeHook = func(planPIndex *PlanPIndex) (int64, error) {
+		return 100, nil
+	}
+
+	rv = CalcDefragmentedUtilization(nodeDefs, planPIndexes, nil)
+	if rv["a:8094"]["diskBytes"] != int64(300) ||
+		rv["b:8094"]["diskBytes"] != int64(100) ||
+		rv["b:8094"]["memoryQuota"] != nil {
+		t.Fatalf("unexpected disk projection: %v", rv)
+	}
+
+	if rv = CalcDefragmentedUtilization(nil, nil, nil); len(rv) != 0 {
+		t.Fatalf("expected no utilization without nodes, got: %v", rv)
+	}
+}
@@ -45,6 +45,15 @@ type IndexDef struct {
 	PlanParams      PlanParams `json:"planParams,omitempty"`
 	HibernationPath string     `json:"hibernationPath,omitempty"`
 
+	// Labels are arbitrary key/value pairs (e.g., "env": "staging"),
+	// which allow for label-scoped operations on indexes.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Version is the schema version of the definition for its index
+	// type, where 0 means a definition from before the versioning.  See
+	// RegisterIndexDefMigration().
+	Version int `json:"version,omitempty"`
+
 	// NOTE: Any auth credentials to access datasource, if any, may be
 	// stored as part of SourceParams.
 }
@@ -64,6 +73,10 @@ type indexDefBase struct {
 	SourceUUID      string     `json:"sourceUUID,omitempty"`
 	PlanParams      PlanParams `json:"planParams,omitempty"`
 	HibernationPath string     `json:"hibernationPath,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	Version int `json:"version,omitempty"`
 }
 
 // A PlanParams holds input parameters to the planner, that control
@@ -84,6 +97,16 @@ type PlanParams struct {
 	// SourcePartitions / IndexPartitions.
 	IndexPartitions int `json:"indexPartitions,omitempty"`
 
+	// MinIndexPartitions and MaxIndexPartitions, when either is
+	// non-zero, let the planner choose the number of index partitions
+	// automatically from the estimated size of the data source (see
+	// SourceSizeEstimatorHook), within these inclusive bounds.  A
+	// MaxIndexPartitions of 0 means no upper bound.  When no size
+	// estimate is available, the planner falls back to
+	// IndexPartitions / MaxPartitionsPerPIndex.
+	MinIndexPartitions int `json:"minIndexPartitions,omitempty"`
+	MaxIndexPartitions int `json:"maxIndexPartitions,omitempty"`
+
 	// NumReplicas controls the number of replicas for a PIndex, over
 	// the first copy.  The first copy is not counted as a replica.
 	// For example, a NumReplicas setting of 2 means there should be a
@@ -121,6 +144,26 @@ type PlanParams struct {
 	// there was no previous plan.  Defaults to false (allow
 	// re-planning).
 	PlanFrozen bool `json:"planFrozen,omitempty"`
+
+	// RebalancePriority orders the indexes that a rebalance moves the
+	// partitions of, where the indexes with higher priorities are
+	// rebalanced first (e.g., so that critical indexes regain their
+	// full replication before bulk indexes).  Defaults to 0.
+	RebalancePriority int `json:"rebalancePriority,omitempty"`
+
+	// PriorityClass is "critical", "normal" or "background", which
+	// orders the index's background work, such as its pindex builds
+	// and rebalance moves, and its shares of the node's resources,
+	// relative to the other indexes.  Defaults to "" (normal).
+	PriorityClass string `json:"priorityClass,omitempty"`
+
+	// DiskQuotaBytes caps the on-disk size of the index, summed over
+	// its pindexes, where 0 means no quota.  DiskQuotaAction is what's
+	// done while the index exceeds its quota, which is "warn",
+	// "pauseIngest" or "rejectMutations", see IndexDiskQuotas.
+	// Defaults to "" (warn).
+	DiskQuotaBytes  int64  `json:"diskQuotaBytes,omitempty"`
+	DiskQuotaAction string `json:"diskQuotaAction,omitempty"`
 }
 
 // A NodePlanParam defines whether a particular node can service a
@@ -150,6 +193,10 @@ type NodeDef struct {
 	Weight      int      `json:"weight"`
 	Extras      string   `json:"extras"`
 
+	// Maintenance is true while the node is in maintenance mode, see
+	// CfgSetNodeMaintenance().
+	Maintenance bool `json:"maintenance,omitempty"`
+
 	m            sync.Mutex
 	extrasParsed map[string]interface{}
 }
@@ -578,31 +625,18 @@ func CopyPlanPIndexes(planPIndexes *PlanPIndexes,
 	return r
 }
 
-// Retrieves PlanPIndexes from a Cfg provider.
+// Retrieves PlanPIndexes from a Cfg provider, dual-reading the plan
+// formats (see PlanFormat), where the CAS is of the legacy plan.
 func CfgGetPlanPIndexes(cfg Cfg) (*PlanPIndexes, uint64, error) {
-	v, cas, err := cfg.Get(PLAN_PINDEXES_KEY, 0)
-	if err != nil {
-		return nil, cas, err
-	}
-	if v == nil {
-		return nil, cas, nil
-	}
-	rv := &PlanPIndexes{}
-	err = UnmarshalJSON(v, rv)
-	if err != nil {
-		return nil, cas, err
-	}
-	return rv, cas, nil
+	rv, cas, _, err := cfgGetPlanPIndexesFormat(cfg)
+	return rv, cas, err
 }
 
-// Updates PlanPIndexes on a Cfg provider.
+// Updates PlanPIndexes on a Cfg provider, dual-writing the current
+// plan format when the cluster supports it (see PlanFormat).
 func CfgSetPlanPIndexes(cfg Cfg, planPIndexes *PlanPIndexes, cas uint64) (
 	uint64, error) {
-	buf, err := MarshalJSON(planPIndexes)
-	if err != nil {
-		return 0, err
-	}
-	return cfg.Set(PLAN_PINDEXES_KEY, buf, cas)
+	return cfgSetPlanPIndexesFormat(cfg, planPIndexes, cas)
 }
 
 // Returns true if both PlanPIndexes are the same, where we ignore any
@@ -651,6 +685,45 @@ func SamePlanPIndex(a, b *PlanPIndex) bool {
 	return true
 }
 
+// CountPlanPIndexesNodeDiffs returns the number of pindex-to-node
+// assignments that differ between the plans a and b, which is roughly
+// the number of partition moves needed to go from a to b, along with
+// the total number of pindex-to-node assignments in b.
+func CountPlanPIndexesNodeDiffs(a, b *PlanPIndexes) (diffs, total int) {
+	var aPlanPIndexes, bPlanPIndexes map[string]*PlanPIndex
+	if a != nil {
+		aPlanPIndexes = a.PlanPIndexes
+	}
+	if b != nil {
+		bPlanPIndexes = b.PlanPIndexes
+	}
+
+	for name, bv := range bPlanPIndexes {
+		total += len(bv.Nodes)
+
+		av, exists := aPlanPIndexes[name]
+		if !exists {
+			diffs += len(bv.Nodes)
+			continue
+		}
+
+		for nodeUUID, bn := range bv.Nodes {
+			an, exists := av.Nodes[nodeUUID]
+			if !exists || an == nil || bn == nil || *an != *bn {
+				diffs++
+			}
+		}
+	}
+
+	for name, av := range aPlanPIndexes {
+		if _, exists := bPlanPIndexes[name]; !exists {
+			diffs += len(av.Nodes)
+		}
+	}
+
+	return diffs, total
+}
+
 // Returns true if both the PIndex meets the PlanPIndex, ignoring UUID.
 func PIndexMatchesPlan(pindex *PIndex, planPIndex *PlanPIndex) bool {
 	same := pindex.Name == planPIndex.Name &&
@@ -112,6 +112,8 @@ func indexDefToBase(indexDef *IndexDef, base *indexDefBase) {
 	base.SourceUUID = indexDef.SourceUUID
 	base.PlanParams = indexDef.PlanParams
 	base.HibernationPath = indexDef.HibernationPath
+	base.Labels = indexDef.Labels
+	base.Version = indexDef.Version
 }
 
 // indexDefFromBase copies non-envelope'able fields from the
@@ -125,6 +127,8 @@ func indexDefFromBase(base *indexDefBase, indexDef *IndexDef) {
 	indexDef.SourceUUID = base.SourceUUID
 	indexDef.PlanParams = base.PlanParams
 	indexDef.HibernationPath = base.HibernationPath
+	indexDef.Labels = base.Labels
+	indexDef.Version = base.Version
 }
 
 // -------------------------------------------------------------------
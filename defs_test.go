@@ -187,6 +187,65 @@ func TestSamePlanPIndexes(t *testing.T) {
 	}
 }
 
+func TestCountPlanPIndexesNodeDiffs(t *testing.T) {
+	a := NewPlanPIndexes("0.0.1")
+	b := NewPlanPIndexes("0.0.1")
+
+	diffs, total := CountPlanPIndexesNodeDiffs(a, b)
+	if diffs != 0 || total != 0 {
+		t.Errorf("expected no diffs, got diffs: %d, total: %d", diffs, total)
+	}
+
+	a.PlanPIndexes["foo"] = &PlanPIndex{
+		Name: "foo",
+		Nodes: map[string]*PlanPIndexNode{
+			"n0": {CanRead: true, CanWrite: true, Priority: 0},
+			"n1": {CanRead: true, CanWrite: true, Priority: 1},
+		},
+	}
+	b.PlanPIndexes["foo"] = &PlanPIndex{
+		Name: "foo",
+		Nodes: map[string]*PlanPIndexNode{
+			"n0": {CanRead: true, CanWrite: true, Priority: 0},
+			"n1": {CanRead: true, CanWrite: true, Priority: 1},
+		},
+	}
+
+	diffs, total = CountPlanPIndexesNodeDiffs(a, b)
+	if diffs != 0 || total != 2 {
+		t.Errorf("expected no diffs, got diffs: %d, total: %d", diffs, total)
+	}
+
+	// Move a replica and swap a priority.
+	b.PlanPIndexes["foo"].Nodes = map[string]*PlanPIndexNode{
+		"n0": {CanRead: true, CanWrite: true, Priority: 1},
+		"n2": {CanRead: true, CanWrite: true, Priority: 0},
+	}
+
+	diffs, total = CountPlanPIndexesNodeDiffs(a, b)
+	if diffs != 2 || total != 2 {
+		t.Errorf("expected 2 diffs, got diffs: %d, total: %d", diffs, total)
+	}
+
+	// A pindex that's only in a counts as diffs.
+	a.PlanPIndexes["bar"] = &PlanPIndex{
+		Name: "bar",
+		Nodes: map[string]*PlanPIndexNode{
+			"n0": {CanRead: true, CanWrite: true},
+		},
+	}
+
+	diffs, total = CountPlanPIndexesNodeDiffs(a, b)
+	if diffs != 3 || total != 2 {
+		t.Errorf("expected 3 diffs, got diffs: %d, total: %d", diffs, total)
+	}
+
+	diffs, total = CountPlanPIndexesNodeDiffs(nil, b)
+	if diffs != 2 || total != 2 {
+		t.Errorf("expected 2 diffs, got diffs: %d, total: %d", diffs, total)
+	}
+}
+
 func TestSamePlanPIndex(t *testing.T) {
 	ppi0 := &PlanPIndex{
 		Name:             "0",
@@ -9,6 +9,7 @@
 package cbgt
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"sync/atomic"
@@ -17,6 +18,11 @@ import (
 	"github.com/rcrowley/go-metrics"
 )
 
+// ErrDestClosed may be returned, or wrapped, by a Dest whose pindex is
+// closed or closing, so that the feeds restart rather than treat the
+// mutation as failed.
+var ErrDestClosed = errors.New("dest closed")
+
 // Dest interface defines the data sink or destination for data that
 // cames from a data-source.  In other words, a data-source (or a Feed
 // instance) is hooked up to one or more Dest instances.  As a Feed
@@ -173,6 +179,32 @@ type DestCollection interface {
 		scopeId, collectionId uint32, seq uint64) error
 }
 
+// DestPersister is an optional interface that a Dest may implement,
+// so that the manager's snapshot scheduler can periodically ask the
+// Dest to snapshot/persist its state (e.g., checkpoints and fsync),
+// rather than each implementation managing its own timers.
+type DestPersister interface {
+	// PersistNow is invoked on the snapshot scheduler's cadence, and
+	// should return once the Dest's state is durable.
+	PersistNow() error
+}
+
+// DestBackfill is an optional interface that a Dest may implement to
+// learn when the feed of a partition is backfilling from the source's
+// disk, such as during an initial index build, versus tailing the
+// source's in-memory changes, so that the Dest can use bulk-load
+// friendly settings during the backfill.
+type DestBackfill interface {
+	// BackfillStart is invoked before the first snapshot of a
+	// partition's backfill.
+	BackfillStart(partition string) error
+
+	// BackfillEnd is invoked before the first in-memory snapshot
+	// that follows a partition's backfill, when the partition has
+	// caught up with the source.
+	BackfillEnd(partition string) error
+}
+
 // DestStats holds the common stats or metrics for a Dest.
 type DestStats struct {
 	TotError uint64
@@ -253,3 +285,12 @@ func BasicPartitionFunc(partition string, key []byte,
 		" partition: %s, dests: %#v", log.Tag(log.UserData, key),
 		partition, dests)
 }
+
+// DestLastProcessed is an optional interface that a Dest may
+// implement to report how far it has processed each of its source
+// partitions, so that query responses can describe their freshness.
+type DestLastProcessed interface {
+	// LastProcessed returns the last processed seq, keyed by source
+	// partition.
+	LastProcessed() map[string]QueryPartitionSeq
+}
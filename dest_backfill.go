@@ -0,0 +1,86 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"sync"
+)
+
+// DCP snapshot marker flags of the source of a snapshot.
+const (
+	DCP_SNAPSHOT_MEMORY = uint32(0x01)
+	DCP_SNAPSHOT_DISK   = uint32(0x02)
+)
+
+// BackfillPhases tracks which partitions of a feed are backfilling, per
+// the snapshots that the feed receives, so as to notify the feed's
+// DestBackfill's of the partitions' phase changes.
+type BackfillPhases struct {
+	m           sync.Mutex
+	backfilling map[string]bool // Keyed by partition.
+}
+
+// NewBackfillPhases returns a ready-to-use BackfillPhases.
+func NewBackfillPhases() *BackfillPhases {
+	return &BackfillPhases{backfilling: map[string]bool{}}
+}
+
+// Backfilling returns true when the partition is backfilling.
+func (p *BackfillPhases) Backfilling(partition string) bool {
+	p.m.Lock()
+	rv := p.backfilling[partition]
+	p.m.Unlock()
+	return rv
+}
+
+// Partitions returns the backfilling partitions.
+func (p *BackfillPhases) Partitions() []string {
+	p.m.Lock()
+	rv := make([]string, 0, len(p.backfilling))
+	for partition := range p.backfilling {
+		rv = append(rv, partition)
+	}
+	p.m.Unlock()
+	return rv
+}
+
+// Snapshot notes the start of a snapshot of a partition, whose source
+// is per the DCP snapshot marker flags, and notifies the dest, when
+// it's a DestBackfill, of the start or end of the partition's
+// backfill.  It's to be invoked before the snapshot's SnapshotStart.
+func (p *BackfillPhases) Snapshot(partition string, dest Dest,
+	snapType uint32) error {
+	disk := snapType&DCP_SNAPSHOT_DISK != 0
+	memory := snapType&DCP_SNAPSHOT_MEMORY != 0
+
+	p.m.Lock()
+	backfilling := p.backfilling[partition]
+	started := disk && !backfilling
+	ended := memory && !disk && backfilling
+	if started {
+		p.backfilling[partition] = true
+	} else if ended {
+		delete(p.backfilling, partition)
+	}
+	p.m.Unlock()
+
+	destBackfill, ok := dest.(DestBackfill)
+	if !ok {
+		return nil
+	}
+
+	if started {
+		return destBackfill.BackfillStart(partition)
+	}
+	if ended {
+		return destBackfill.BackfillEnd(partition)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testBackfillDest struct {
+	TestDest
+	events []string
+}
+
+func (d *testBackfillDest) BackfillStart(partition string) error {
+	d.events = append(d.events, "start:"+partition)
+	return nil
+}
+
+func (d *testBackfillDest) BackfillEnd(partition string) error {
+	d.events = append(d.events, "end:"+partition)
+	return nil
+}
+
+func TestBackfillPhases(t *testing.T) {
+	dest := &testBackfillDest{}
+	phases := NewBackfillPhases()
+
+	for _, s := range []struct {
+		partition string
+		snapType  uint32
+	}{
+		{"0", DCP_SNAPSHOT_DISK},
+		{"0", DCP_SNAPSHOT_DISK}, // Still backfilling.
+		{"1", DCP_SNAPSHOT_MEMORY},
+		{"0", DCP_SNAPSHOT_MEMORY},
+		{"0", DCP_SNAPSHOT_MEMORY}, // Still tailing.
+		{"1", DCP_SNAPSHOT_DISK},   // Backfilling again, as after a rollback.
+	} {
+		err := phases.Snapshot(s.partition, dest, s.snapType)
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+
+	exp := []string{"start:0", "end:0", "start:1"}
+	if !reflect.DeepEqual(dest.events, exp) {
+		t.Fatalf("expected: %v, got: %v", exp, dest.events)
+	}
+	if phases.Backfilling("0") || !phases.Backfilling("1") {
+		t.Fatalf("unexpected phases")
+	}
+
+	// Dests without the interface are left alone.
+	err := phases.Snapshot("2", &TestDest{}, DCP_SNAPSHOT_DISK)
+	if err != nil || !phases.Backfilling("2") {
+		t.Fatalf("expected backfilling, err: %v", err)
+	}
+}
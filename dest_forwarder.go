@@ -78,6 +78,30 @@ func (t *DestForwarder) SnapshotStart(partition string,
 	return dest.SnapshotStart(partition, snapStart, snapEnd)
 }
 
+func (t *DestForwarder) BackfillStart(partition string) error {
+	dest, err := t.DestProvider.Dest(partition)
+	if err != nil {
+		return err
+	}
+	if destBackfill, ok := dest.(DestBackfill); ok {
+		return destBackfill.BackfillStart(partition)
+	}
+
+	return nil
+}
+
+func (t *DestForwarder) BackfillEnd(partition string) error {
+	dest, err := t.DestProvider.Dest(partition)
+	if err != nil {
+		return err
+	}
+	if destBackfill, ok := dest.(DestBackfill); ok {
+		return destBackfill.BackfillEnd(partition)
+	}
+
+	return nil
+}
+
 func (t *DestForwarder) PrepareFeedParams(partition string,
 	params *DCPFeedParams) error {
 	dest, err := t.DestProvider.Dest(partition)
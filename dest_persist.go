@@ -0,0 +1,112 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"hash/crc32"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// DestPersistTickInterval is how often the snapshot scheduler checks
+// whether any pindex is due to be persisted.
+var DestPersistTickInterval = time.Second
+
+// DestPersistInterval returns the snapshot/persist cadence for an
+// index, from the "destPersistIntervalSecs:<indexName>" manager
+// option, or else from the "destPersistIntervalSecs" manager option.
+// A cadence of 0 means the index is not persisted by the scheduler.
+func DestPersistInterval(options map[string]string,
+	indexName string) time.Duration {
+	v, exists := options["destPersistIntervalSecs:"+indexName]
+	if !exists {
+		v = options["destPersistIntervalSecs"]
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// destPersistSlot returns the slot of the cluster-wide schedule that
+// a pindex is in at the given time.  Slots are aligned to the wall
+// clock (so every node agrees on them), and each pindex is offset
+// into its slot by a hash of its name, so that the persists of all
+// the pindexes in the cluster are spread out over the interval
+// instead of happening all at once.
+func destPersistSlot(now time.Time, interval time.Duration,
+	pindexName string) int64 {
+	offset := int64(crc32.ChecksumIEEE([]byte(pindexName))) %
+		int64(interval)
+	return (now.UnixNano() - offset) / int64(interval)
+}
+
+// DestPersistLoop runs the snapshot scheduler, which invokes the
+// PersistNow() of every pindex whose Dest implements DestPersister,
+// on each index's cadence, until the manager is stopped.
+func (mgr *Manager) DestPersistLoop() {
+	ticker := time.NewTicker(DestPersistTickInterval)
+	defer ticker.Stop()
+
+	lastSlots := map[string]int64{} // Keyed by pindex name.
+
+	for {
+		select {
+		case <-mgr.stopCh:
+			return
+		case now := <-ticker.C:
+			lastSlots = mgr.destPersistOnce(now, lastSlots)
+		}
+	}
+}
+
+// destPersistOnce persists the pindexes that have entered a new slot
+// since the last round, and returns the updated slots.
+func (mgr *Manager) destPersistOnce(now time.Time,
+	lastSlots map[string]int64) map[string]int64 {
+	options := mgr.Options()
+	_, pindexes := mgr.CurrentMaps()
+
+	currSlots := make(map[string]int64, len(lastSlots))
+
+	for name, pindex := range pindexes {
+		persister, ok := pindex.Dest.(DestPersister)
+		if !ok {
+			continue
+		}
+
+		interval := DestPersistInterval(options, pindex.IndexName)
+		if interval <= 0 {
+			continue
+		}
+
+		slot := destPersistSlot(now, interval, name)
+		currSlots[name] = slot
+
+		lastSlot, seen := lastSlots[name]
+		if !seen || slot <= lastSlot {
+			continue // Newly seen pindexes wait for their next slot.
+		}
+
+		err := persister.PersistNow()
+		if err != nil {
+			atomic.AddUint64(&mgr.stats.TotDestPersistErr, 1)
+			log.Warnf("dest_persist: pindex: %s, err: %v", name, err)
+			continue
+		}
+
+		atomic.AddUint64(&mgr.stats.TotDestPersist, 1)
+	}
+
+	return currSlots
+}
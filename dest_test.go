@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"io"
 	"testing"
+	"time"
 )
 
 type TestDest struct{}
@@ -231,3 +232,52 @@ func TestDestStatsWriteJSON(t *testing.T) {
 		t.Errorf("expected some m")
 	}
 }
+
+type TestPersisterDest struct {
+	TestDest
+	persists int
+}
+
+func (t *TestPersisterDest) PersistNow() error {
+	t.persists++
+	return nil
+}
+
+func TestDestPersistScheduler(t *testing.T) {
+	options := map[string]string{
+		"destPersistIntervalSecs":     "60",
+		"destPersistIntervalSecs:off": "0",
+	}
+	if DestPersistInterval(options, "idx") != time.Minute {
+		t.Errorf("expected the default persist interval")
+	}
+	if DestPersistInterval(options, "off") != 0 {
+		t.Errorf("expected the per-index persist interval override")
+	}
+
+	mgr := NewManagerEx(VERSION, nil, NewUUID(), nil, "", 1, "", "", "",
+		"", nil, options)
+	dest := &TestPersisterDest{}
+	mgr.pindexes["p0"] = &PIndex{Name: "p0", IndexName: "idx", Dest: dest}
+	mgr.pindexes["p1"] = &PIndex{Name: "p1", IndexName: "off",
+		Dest: &TestPersisterDest{}}
+	mgr.pindexes["p2"] = &PIndex{Name: "p2", IndexName: "idx",
+		Dest: &TestDest{}}
+
+	now := time.Unix(1700000000, 0)
+	slots := mgr.destPersistOnce(now, map[string]int64{})
+	if dest.persists != 0 {
+		t.Errorf("expected no persist for a newly seen pindex")
+	}
+	if len(slots) != 1 {
+		t.Errorf("expected only p0 to be scheduled, got: %v", slots)
+	}
+
+	// Within the same slot, nothing happens; a minute later, the
+	// pindex is in its next slot.
+	slots = mgr.destPersistOnce(now.Add(time.Second), slots)
+	slots = mgr.destPersistOnce(now.Add(time.Minute), slots)
+	if dest.persists != 1 {
+		t.Errorf("expected 1 persist, got: %d", dest.persists)
+	}
+}
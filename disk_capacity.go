@@ -0,0 +1,199 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	log "github.com/couchbase/clog"
+)
+
+// Before a topology change moves any pindexes, the disk usage of each
+// node at the end of the change is projected from the sizes of the
+// pindexes that are to be added to the node, so that the topology
+// change can warn about, or refuse to fill up, the nodes that would go
+// above their disk high-water mark.
+
+// DISK_HIGH_WATER_MARK_OPTION is the manager option that holds the
+// disk high-water mark of the nodes, in percent of their capacity.
+const DISK_HIGH_WATER_MARK_OPTION = "diskHighWaterMarkPercent"
+
+// DISK_HIGH_WATER_MARK_ACTION_OPTION is the manager option that holds
+// what a topology change does when a node would exceed its disk
+// high-water mark, which is "warn" (the default) or "refuse".
+const DISK_HIGH_WATER_MARK_ACTION_OPTION = "diskHighWaterMarkAction"
+
+// DefaultDiskHighWaterMarkPercent is the default disk high-water mark.
+var DefaultDiskHighWaterMarkPercent = 90.0
+
+// ErrDiskHighWaterMark is the error of a topology change that's
+// refused as a node would exceed its disk high-water mark.
+var ErrDiskHighWaterMark = errors.New("disk high-water mark exceeded")
+
+// A NodeDiskCapacity is the disk usage and capacity, in bytes, of a
+// node.
+type NodeDiskCapacity struct {
+	Used  int64 `json:"used"`
+	Total int64 `json:"total"`
+}
+
+// NodeDiskCapacityHook is an optional, pluggable callback that allows
+// applications to report the disk usage and capacity of a node, such
+// as from the cluster manager's stats.  When nil, or when either it or
+// the PIndexDiskSizeHook is nil, the disk usage isn't projected.
+var NodeDiskCapacityHook func(nodeDef *NodeDef) (*NodeDiskCapacity, error)
+
+// PIndexDiskSizeHook is an optional, pluggable callback that allows
+// applications to report the disk size, in bytes, of a pindex.
+var PIndexDiskSizeHook func(planPIndex *PlanPIndex) (int64, error)
+
+// A DiskUsageProjection is the projected disk usage of a node at the
+// end of a topology change.
+type DiskUsageProjection struct {
+	NodeUUID string `json:"nodeUUID"`
+	Used     int64  `json:"used"`
+	Incoming int64  `json:"incoming"` // The sizes of the added pindexes.
+	Total    int64  `json:"total"`
+}
+
+// Percent returns the projected disk usage, in percent of the capacity.
+func (p *DiskUsageProjection) Percent() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return float64(p.Used+p.Incoming) * 100 / float64(p.Total)
+}
+
+// CalcDiskUsageProjections returns the projected disk usage of the
+// nodes of the endPlanPIndexes, keyed by node UUID, where a node's
+// incoming pindexes are those that the endPlanPIndexes assign to it
+// and the begPlanPIndexes didn't.  Returns nil when the hooks aren't
+// set.  The nodes' disk space held by their outgoing pindexes isn't
+// subtracted, as it's only freed once the pindexes have moved.
+func CalcDiskUsageProjections(begPlanPIndexes, endPlanPIndexes *PlanPIndexes,
+	nodeDefs *NodeDefs) map[string]*DiskUsageProjection {
+	if NodeDiskCapacityHook == nil || PIndexDiskSizeHook == nil ||
+		endPlanPIndexes == nil || nodeDefs == nil {
+		return nil
+	}
+
+	rv := map[string]*DiskUsageProjection{}
+
+	for name, planPIndex := range endPlanPIndexes.PlanPIndexes {
+		var begPlanPIndex *PlanPIndex
+		if begPlanPIndexes != nil {
+			begPlanPIndex = begPlanPIndexes.PlanPIndexes[name]
+		}
+
+		var size int64
+		var sized bool
+
+		for nodeUUID := range planPIndex.Nodes {
+			if begPlanPIndex != nil && begPlanPIndex.Nodes[nodeUUID] != nil {
+				continue // Not incoming.
+			}
+
+			p := rv[nodeUUID]
+			if p == nil {
+				nodeDef := nodeDefs.NodeDefs[nodeUUID]
+				if nodeDef == nil {
+					continue
+				}
+				capacity, err := NodeDiskCapacityHook(nodeDef)
+				if err != nil || capacity == nil {
+					log.Warnf("disk_capacity: node: %s, capacity, err: %v",
+						nodeUUID, err)
+					continue
+				}
+				p = &DiskUsageProjection{
+					NodeUUID: nodeUUID,
+					Used:     capacity.Used,
+					Total:    capacity.Total,
+				}
+				rv[nodeUUID] = p
+			}
+
+			if !sized {
+				sized = true
+				// The size of an existing pindex is best known from
+				// its current plan.
+				sizePlanPIndex := planPIndex
+				if begPlanPIndex != nil {
+					sizePlanPIndex = begPlanPIndex
+				}
+				var err error
+				size, err = PIndexDiskSizeHook(sizePlanPIndex)
+				if err != nil {
+					log.Warnf("disk_capacity: pindex: %s, size, err: %v",
+						name, err)
+					size = 0
+				}
+			}
+
+			p.Incoming += size
+		}
+	}
+
+	return rv
+}
+
+// DiskHighWaterMarkPercent returns the disk high-water mark from the
+// manager options.
+func DiskHighWaterMarkPercent(options map[string]string) float64 {
+	v := options[DISK_HIGH_WATER_MARK_OPTION]
+	if v == "" {
+		return DefaultDiskHighWaterMarkPercent
+	}
+	rv, err := strconv.ParseFloat(v, 64)
+	if err != nil || rv <= 0 {
+		log.Warnf("disk_capacity: option: %s, value: %q, err: %v",
+			DISK_HIGH_WATER_MARK_OPTION, v, err)
+		return DefaultDiskHighWaterMarkPercent
+	}
+	return rv
+}
+
+// DiskUsageWarnings returns the warnings for the nodes whose projected
+// disk usage exceeds the high-water mark, keyed by "node:<nodeUUID>",
+// and an error that wraps ErrDiskHighWaterMark when there are any such
+// nodes and the action option is "refuse".
+func DiskUsageWarnings(projections map[string]*DiskUsageProjection,
+	options map[string]string) (map[string][]string, error) {
+	highWaterMark := DiskHighWaterMarkPercent(options)
+
+	var nodes []string
+	rv := map[string][]string{}
+
+	for nodeUUID, p := range projections {
+		if p.Total <= 0 || p.Percent() <= highWaterMark {
+			continue
+		}
+		nodes = append(nodes, nodeUUID)
+		rv["node:"+nodeUUID] = []string{fmt.Sprintf("projected disk usage:"+
+			" %.1f%% (used: %d, incoming: %d, total: %d) exceeds the"+
+			" disk high-water mark: %.1f%%", p.Percent(), p.Used,
+			p.Incoming, p.Total, highWaterMark)}
+	}
+
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(nodes)
+
+	if options[DISK_HIGH_WATER_MARK_ACTION_OPTION] == "refuse" {
+		return rv, fmt.Errorf("disk_capacity: nodes: %v, err: %w",
+			nodes, ErrDiskHighWaterMark)
+	}
+
+	return rv, nil
+}
@@ -0,0 +1,76 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiskUsageWarnings(t *testing.T) {
+	defer func() {
+		NodeDiskCapacityHook = nil
+		PIndexDiskSizeHook = nil
+	}()
+
+	begPlanPIndexes := NewPlanPIndexes(VERSION)
+	begPlanPIndexes.PlanPIndexes["p0"] = &PlanPIndex{Name: "p0",
+		Nodes: map[string]*PlanPIndexNode{"a": {}}}
+	begPlanPIndexes.PlanPIndexes["p1"] = &PlanPIndex{Name: "p1",
+		Nodes: map[string]*PlanPIndexNode{"a": {}}}
+
+	endPlanPIndexes := NewPlanPIndexes(VERSION)
+	endPlanPIndexes.PlanPIndexes["p0"] = &PlanPIndex{Name: "p0",
+		Nodes: map[string]*PlanPIndexNode{"a": {}, "b": {}}}
+	endPlanPIndexes.PlanPIndexes["p1"] = &PlanPIndex{Name: "p1",
+		Nodes: map[string]*PlanPIndexNode{"b": {}}}
+
+	nodeDefs := &NodeDefs{NodeDefs: map[string]*NodeDef{
+		"a": {UUID: "a"},
+		"b": {UUID: "b"},
+	}}
+
+	if p := CalcDiskUsageProjections(begPlanPIndexes, endPlanPIndexes,
+		nodeDefs); p != nil {
+		t.Fatalf("expected no projections without hooks, got: %v", p)
+	}
+
+	NodeDiskCapacityHook = func(nodeDef *NodeDef) (*NodeDiskCapacity, error) {
+		return &NodeDiskCapacity{Used: 50, Total: 100}, nil
+	}
+	PIndexDiskSizeHook = func(planPIndex *PlanPIndex) (int64, error) {
+		return map[string]int64{"p0": 30, "p1": 15}[planPIndex.Name], nil
+	}
+
+	projections := CalcDiskUsageProjections(begPlanPIndexes,
+		endPlanPIndexes, nodeDefs)
+	if len(projections) != 1 || projections["b"] == nil ||
+		projections["b"].Incoming != 45 || projections["b"].Percent() != 95 {
+		t.Fatalf("unexpected projections: %+v", projections)
+	}
+
+	warnings, err := DiskUsageWarnings(projections, nil)
+	if err != nil || len(warnings) != 1 || len(warnings["node:b"]) != 1 {
+		t.Fatalf("expected a warning for b, got: %v, err: %v", warnings, err)
+	}
+
+	warnings, err = DiskUsageWarnings(projections, map[string]string{
+		"diskHighWaterMarkPercent": "95",
+	})
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got: %v, err: %v", warnings, err)
+	}
+
+	warnings, err = DiskUsageWarnings(projections, map[string]string{
+		"diskHighWaterMarkAction": "refuse",
+	})
+	if !errors.Is(err, ErrDiskHighWaterMark) || len(warnings["node:b"]) != 1 {
+		t.Fatalf("expected a refusal, got: %v, err: %v", warnings, err)
+	}
+}
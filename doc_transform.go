@@ -0,0 +1,242 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/couchbase/clog"
+)
+
+// The document transformers rewrite the values of the mutations that
+// the feeds deliver to a pindex's Dest, such as to redact fields, to
+// enrich documents or to convert their format, without forking the
+// Dest implementations.  An index names its chain of transformers in
+// the "docTransformers" field of its index params, which are applied
+// in order.
+
+// A DocTransformer returns the transformed value of a document
+// mutation.  When it returns an error, the feeds delete the document's
+// key from the Dest instead, so that no stale value of the key stays
+// indexed, and the mutation is captured by the Dest's dead letter
+// queue, if any, to be retried.
+type DocTransformer func(indexName, partition string,
+	key, val []byte) ([]byte, error)
+
+// DocTransformers is the registry of the document transformers, keyed
+// by name, which is meant to be populated at init/startup time via
+// RegisterDocTransformer().
+var DocTransformers = map[string]DocTransformer{}
+
+// RegisterDocTransformer registers a named document transformer.
+func RegisterDocTransformer(name string, t DocTransformer) {
+	DocTransformers[name] = t
+}
+
+var docTransformLogThrottle = LogThrottleFor("doc_transform")
+
+// DocTransformParams represents the JSON of the transformer chain in
+// the index params of an index.
+type DocTransformParams struct {
+	DocTransformers []string `json:"docTransformers,omitempty"`
+}
+
+// IndexDocTransformers returns the names of the document transformers
+// of an index's params, where params that aren't a JSON object have no
+// transformers.
+func IndexDocTransformers(indexParams string) []string {
+	var params DocTransformParams
+	if json.Unmarshal([]byte(indexParams), &params) != nil {
+		return nil
+	}
+	return params.DocTransformers
+}
+
+// ValidateDocTransformers checks that the document transformers of an
+// index's params are registered.
+func ValidateDocTransformers(indexParams string) error {
+	for _, name := range IndexDocTransformers(indexParams) {
+		if DocTransformers[name] == nil {
+			return fmt.Errorf("doc_transform: unknown docTransformer: %q",
+				name)
+		}
+	}
+	return nil
+}
+
+// A docTransformChain is the transformer chain of a pindex's Dest.
+type docTransformChain struct {
+	mgr          *Manager
+	indexName    string
+	transformers []DocTransformer
+}
+
+// The transformer chains of the Dests whose indexes have transformers.
+var (
+	docTransformChainsActive int32 // Fast path when there are none.
+	docTransformChainsM      sync.RWMutex
+	docTransformChains       = map[Dest]*docTransformChain{}
+)
+
+// registerDocTransforms sets up the transformer chain of a pindex's
+// Dest, if its index has any document transformers.  A transformer
+// that's not registered fails the start of the pindex, rather than
+// have its documents indexed untransformed.
+func (mgr *Manager) registerDocTransforms(pindex *PIndex) error {
+	names := IndexDocTransformers(pindex.IndexParams)
+	if len(names) == 0 || pindex.Dest == nil {
+		return nil
+	}
+
+	chain := &docTransformChain{mgr: mgr, indexName: pindex.IndexName}
+	for _, name := range names {
+		t := DocTransformers[name]
+		if t == nil {
+			return fmt.Errorf("doc_transform: pindex: %s,"+
+				" unknown docTransformer: %q", pindex.Name, name)
+		}
+		chain.transformers = append(chain.transformers, t)
+	}
+
+	docTransformChainsM.Lock()
+	docTransformChains[pindex.Dest] = chain
+	atomic.StoreInt32(&docTransformChainsActive, int32(len(docTransformChains)))
+	docTransformChainsM.Unlock()
+
+	return nil
+}
+
+// unregisterDocTransforms removes the transformer chain of a pindex's
+// Dest.
+func unregisterDocTransforms(pindex *PIndex) {
+	if atomic.LoadInt32(&docTransformChainsActive) <= 0 ||
+		pindex.Dest == nil {
+		return
+	}
+
+	docTransformChainsM.Lock()
+	delete(docTransformChains, pindex.Dest)
+	atomic.StoreInt32(&docTransformChainsActive, int32(len(docTransformChains)))
+	docTransformChainsM.Unlock()
+}
+
+// transformMutation returns the value of a mutation as transformed by
+// the transformer chain of its dest, or the err of a failed
+// transformer, in which case the feeds delete the key from the dest
+// and then invoke transformFailedMutation().  The feeds invoke it
+// before each DataUpdate.
+func transformMutation(dest Dest, partition string,
+	key, val []byte) ([]byte, error) {
+	chain := docTransformChainFor(dest)
+	if chain == nil {
+		return val, nil
+	}
+
+	return chain.transform(partition, key, val)
+}
+
+// transformMutationXattrs is transformMutation for a value that, when
+// xattrs is true, is prefixed by the xattrs of the document, as DCP
+// delivers it, where only the body of the document is transformed,
+// and the xattrs are kept as they are.
+func transformMutationXattrs(dest Dest, partition string,
+	key, val []byte, xattrs bool) ([]byte, error) {
+	chain := docTransformChainFor(dest)
+	if chain == nil {
+		return val, nil
+	}
+	if !xattrs {
+		return chain.transform(partition, key, val)
+	}
+
+	// The xattrs are prefixed by their 4 byte, big endian length.
+	if len(val) < 4 || int(binary.BigEndian.Uint32(val)) > len(val)-4 {
+		return nil, fmt.Errorf("doc_transform: index: %s,"+
+			" invalid xattrs length", chain.indexName)
+	}
+	n := 4 + int(binary.BigEndian.Uint32(val))
+
+	body, err := chain.transform(partition, key, val[n:])
+	if err != nil {
+		return nil, err
+	}
+
+	rv := make([]byte, 0, n+len(body))
+	return append(append(rv, val[:n]...), body...), nil
+}
+
+// docTransformChainFor returns the transformer chain of a dest, if any.
+func docTransformChainFor(dest Dest) *docTransformChain {
+	if atomic.LoadInt32(&docTransformChainsActive) <= 0 {
+		return nil
+	}
+
+	docTransformChainsM.RLock()
+	chain := docTransformChains[dest]
+	docTransformChainsM.RUnlock()
+
+	return chain
+}
+
+// transform applies the transformers of the chain to a value.
+func (chain *docTransformChain) transform(partition string,
+	key, val []byte) ([]byte, error) {
+	var err error
+	for _, t := range chain.transformers {
+		val, err = t(chain.indexName, partition, key, val)
+		if err != nil {
+			atomic.AddUint64(&chain.mgr.stats.TotDocTransformErr, 1)
+			docTransformLogThrottle.Warnf("doc_transform: index: %s,"+
+				" partition: %s, key: %s, deleted, err: %v", chain.indexName,
+				partition, log.Tag(log.UserData, key), err)
+			return nil, fmt.Errorf("doc_transform: index: %s, err: %v",
+				chain.indexName, err)
+		}
+	}
+
+	atomic.AddUint64(&chain.mgr.stats.TotDocTransform, 1)
+
+	return val, nil
+}
+
+// transformFailedMutation is invoked by the feeds once they deleted
+// the key of a mutation whose transform failed from the dest, with the
+// extras of the mutation, along with the err of that deletion.  The
+// mutation, whose val is as yet untransformed and is prefixed by its
+// xattrs when xattrs is true, is then captured by the dest's dead
+// letter queue, so that it can be retried once its transformers are
+// fixed, where a full queue fails the feed instead.
+func transformFailedMutation(dest Dest, partition string, key []byte,
+	seq uint64, val []byte, xattrs bool, cas uint64,
+	extrasType DestExtrasType, extras interface{},
+	transformErr, err error) error {
+	err = deadLetterMutation(dest, partition, key, seq, nil, cas, true,
+		extrasType, extras, err)
+	if err != nil {
+		return err
+	}
+
+	l := &DeadLetter{
+		Partition:     partition,
+		Key:           string(key),
+		Seq:           seq,
+		Cas:           cas,
+		Value:         append([]byte(nil), val...),
+		Untransformed: true,
+		Xattrs:        xattrs,
+		Err:           transformErr.Error(),
+	}
+	l.ExtrasType, l.Extras = deadLetterExtras(extrasType, extras)
+
+	return captureDeadLetter(dest, l)
+}
@@ -0,0 +1,156 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDocTransformers(t *testing.T) {
+	failing := true
+
+	RegisterDocTransformer("testUpper", func(indexName, partition string,
+		key, val []byte) ([]byte, error) {
+		return bytes.ToUpper(val), nil
+	})
+	RegisterDocTransformer("testRedact", func(indexName, partition string,
+		key, val []byte) ([]byte, error) {
+		if failing && string(key) == "bad" {
+			return nil, errors.New("boom")
+		}
+		return bytes.ReplaceAll(val, []byte("SECRET"), []byte("***")), nil
+	})
+	defer delete(DocTransformers, "testUpper")
+	defer delete(DocTransformers, "testRedact")
+
+	indexParams := `{"docTransformers":["testUpper","testRedact"]}`
+	if err := ValidateDocTransformers(indexParams); err != nil {
+		t.Fatalf("expected valid transformers, err: %v", err)
+	}
+	if ValidateDocTransformers(`{"docTransformers":["nope"]}`) == nil {
+		t.Fatalf("expected an unknown transformer err")
+	}
+	if ValidateDocTransformers(`[]`) != nil || ValidateDocTransformers("") != nil {
+		t.Fatalf("expected params without transformers to be valid")
+	}
+
+	mgr := NewManagerEx(VERSION, nil, NewUUID(), []string{"pindex"},
+		"", 1, "", "", "", "", nil, map[string]string{
+			"deadLetterQueueMaxItems": "10",
+		})
+
+	dest := &testHTTPFeedDest{}
+	pindex := &PIndex{Name: "p0", IndexName: "i0",
+		IndexParams: indexParams, Dest: dest}
+	mgr.registerPIndex(pindex)
+
+	feed := NewPrimaryFeed("f0", "i0", BasicPartitionFunc,
+		map[string]Dest{"0": dest})
+	feed.DataUpdate("0", []byte("k0"), 1, []byte("a secret"), 0,
+		DEST_EXTRAS_TYPE_NIL, nil)
+	feed.DataUpdate("0", []byte("bad"), 2, []byte("x"), 0,
+		DEST_EXTRAS_TYPE_NIL, nil)
+
+	// The key of a failed transform is deleted, so that its stale value
+	// doesn't stay indexed, and the mutation is captured for a retry.
+	exp := []string{"update:k0=A ***", "delete:bad"}
+	if !reflect.DeepEqual(dest.events, exp) {
+		t.Fatalf("expected: %v, got: %v", exp, dest.events)
+	}
+	if mgr.stats.TotDocTransform != 1 || mgr.stats.TotDocTransformErr != 1 {
+		t.Fatalf("unexpected stats: %d, %d",
+			mgr.stats.TotDocTransform, mgr.stats.TotDocTransformErr)
+	}
+
+	deadLetters, _ := mgr.DeadLetters("p0")
+	if len(deadLetters) != 1 || deadLetters[0].Key != "bad" ||
+		!deadLetters[0].Untransformed || string(deadLetters[0].Value) != "x" {
+		t.Fatalf("expected the untransformed mutation, got: %+v", deadLetters)
+	}
+
+	_, failed, _ := mgr.RetryDeadLetters("p0", nil)
+	if failed != 1 {
+		t.Fatalf("expected the retry to fail to transform")
+	}
+
+	failing = false
+	retried, _, _ := mgr.RetryDeadLetters("p0", nil)
+	exp = append(exp, "update:bad=X")
+	if retried != 1 || !reflect.DeepEqual(dest.events, exp) {
+		t.Fatalf("expected the transformed retry: %v, got: %v",
+			exp, dest.events)
+	}
+	failing = true
+
+	mgr.unregisterPIndex("p0", nil)
+	feed.DataUpdate("0", []byte("bad"), 3, []byte("x"), 0,
+		DEST_EXTRAS_TYPE_NIL, nil)
+	if len(dest.events) != 4 {
+		t.Fatalf("expected no transforms once unregistered, got: %v",
+			dest.events)
+	}
+}
+
+func TestDocTransformersUnknown(t *testing.T) {
+	mgr := NewManager(VERSION, nil, NewUUID(), []string{"pindex"},
+		"", 1, "", "", "", "", nil)
+
+	pindex := &PIndex{Name: "p0", IndexName: "i0",
+		IndexParams: `{"docTransformers":["nope"]}`,
+		Dest:        &testHTTPFeedDest{}}
+	if mgr.registerPIndex(pindex) == nil {
+		t.Fatalf("expected an unknown transformer to fail the pindex")
+	}
+	if mgr.GetPIndex("p0") != nil {
+		t.Fatalf("expected the pindex to not be registered")
+	}
+}
+
+func TestDocTransformersXattrs(t *testing.T) {
+	RegisterDocTransformer("testUpper", func(indexName, partition string,
+		key, val []byte) ([]byte, error) {
+		return bytes.ToUpper(val), nil
+	})
+	defer delete(DocTransformers, "testUpper")
+
+	mgr := NewManager(VERSION, nil, NewUUID(), []string{"pindex"},
+		"", 1, "", "", "", "", nil)
+
+	dest := &testHTTPFeedDest{}
+	pindex := &PIndex{Name: "p0", IndexName: "i0",
+		IndexParams: `{"docTransformers":["testUpper"]}`, Dest: dest}
+	if err := mgr.registerPIndex(pindex); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	defer mgr.unregisterPIndex("p0", nil)
+
+	xattrs := []byte{0, 0, 0, 3, 'x', 'y', 'z'}
+	val := append(append([]byte(nil), xattrs...), "body"...)
+
+	rv, err := transformMutationXattrs(dest, "0", []byte("k"), val, true)
+	if err != nil || string(rv) != string(xattrs)+"BODY" {
+		t.Fatalf("expected only the body to be transformed, got: %q, err: %v",
+			rv, err)
+	}
+
+	rv, err = transformMutationXattrs(dest, "0", []byte("k"), val, false)
+	if err != nil || string(rv) != string(bytes.ToUpper(val)) {
+		t.Fatalf("expected the whole value to be transformed, got: %q,"+
+			" err: %v", rv, err)
+	}
+
+	_, err = transformMutationXattrs(dest, "0", []byte("k"),
+		[]byte{0, 0, 0, 9, 'x'}, true)
+	if err == nil {
+		t.Fatalf("expected an invalid xattrs length err")
+	}
+}
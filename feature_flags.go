@@ -0,0 +1,308 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	log "github.com/couchbase/clog"
+)
+
+// Feature flags let risky new behaviors be enabled progressively, such
+// as on a few nodes first, without new builds.  The flags are stored
+// in the Cfg, where a flag has a cluster-wide default value, optional
+// per-node overrides, and an optional cluster compatibility gate,
+// below which the flag is unset.  Each manager caches the values of
+// the flags for its node, which components read via the typed
+// accessors, such as Manager.FeatureFlagBool(), and can watch for
+// changes via Manager.WatchFeatureFlag().
+
+// FEATURE_FLAGS_KEY is the Cfg key of the feature flags.
+const FEATURE_FLAGS_KEY = "featureFlags"
+
+// FeatureFlags is the Cfg value of the feature flags.
+type FeatureFlags struct {
+	UUID        string                  `json:"uuid"`
+	ImplVersion string                  `json:"implVersion"`
+	Flags       map[string]*FeatureFlag `json:"flags"` // Keyed by name.
+}
+
+// A FeatureFlag is the definition of a feature flag.
+type FeatureFlag struct {
+	Description string `json:"description,omitempty"`
+
+	// Default is the value of the flag on the nodes without an
+	// override, where "" means that the flag is unset.
+	Default string `json:"default"`
+
+	// NodeOverrides are the values of the flag on particular nodes,
+	// keyed by node UUID.
+	NodeOverrides map[string]string `json:"nodeOverrides,omitempty"`
+
+	// MinClusterCompatVersion, such as "7.6.0", and MinImplVersion are
+	// the lowest cluster compatibility version and the lowest node
+	// ImplVersion, respectively, at which the flag is set, see
+	// PlanFormat.  When empty, the flag isn't gated.
+	MinClusterCompatVersion string `json:"minClusterCompatVersion,omitempty"`
+	MinImplVersion          string `json:"minImplVersion,omitempty"`
+}
+
+// A FeatureFlagCallback is invoked when the value of a watched feature
+// flag changes on a node, where ok is false when the flag became unset.
+type FeatureFlagCallback func(name, value string, ok bool)
+
+// NewFeatureFlags returns empty feature flags.
+func NewFeatureFlags(version string) *FeatureFlags {
+	return &FeatureFlags{
+		UUID:        NewUUID(),
+		ImplVersion: version,
+		Flags:       map[string]*FeatureFlag{},
+	}
+}
+
+// CfgGetFeatureFlags retrieves the feature flags from a Cfg provider,
+// which are nil when there are none.
+func CfgGetFeatureFlags(cfg Cfg) (*FeatureFlags, uint64, error) {
+	v, cas, err := cfg.Get(FEATURE_FLAGS_KEY, 0)
+	if err != nil {
+		return nil, cas, err
+	}
+	if v == nil {
+		return nil, cas, nil
+	}
+	rv := &FeatureFlags{}
+	err = UnmarshalJSON(v, rv)
+	if err != nil {
+		return nil, cas, err
+	}
+	if rv.Flags == nil {
+		rv.Flags = map[string]*FeatureFlag{}
+	}
+	return rv, cas, nil
+}
+
+// CfgSetFeatureFlags updates the feature flags on a Cfg provider.
+func CfgSetFeatureFlags(cfg Cfg, featureFlags *FeatureFlags,
+	cas uint64) (uint64, error) {
+	buf, err := MarshalJSON(featureFlags)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Set(FEATURE_FLAGS_KEY, buf, cas)
+}
+
+// CfgSetFeatureFlag creates or replaces the definition of a feature
+// flag, or deletes it when the flag is nil.
+func CfgSetFeatureFlag(cfg Cfg, version, name string,
+	flag *FeatureFlag) error {
+	if name == "" {
+		return fmt.Errorf("feature_flags: name is required")
+	}
+
+	if flag != nil && flag.MinClusterCompatVersion != "" {
+		_, err := CompatibilityVersion(flag.MinClusterCompatVersion)
+		if err != nil {
+			return fmt.Errorf("feature_flags: name: %s,"+
+				" minClusterCompatVersion: %q, err: %v",
+				name, flag.MinClusterCompatVersion, err)
+		}
+	}
+
+	return RetryOnCASMismatch(func() error {
+		featureFlags, cas, err := CfgGetFeatureFlags(cfg)
+		if err != nil {
+			return err
+		}
+		if featureFlags == nil {
+			featureFlags = NewFeatureFlags(version)
+		}
+
+		if flag == nil {
+			if featureFlags.Flags[name] == nil {
+				return nil
+			}
+			delete(featureFlags.Flags, name)
+		} else {
+			featureFlags.Flags[name] = flag
+		}
+
+		featureFlags.UUID = NewUUID()
+		featureFlags.ImplVersion = version
+
+		_, err = CfgSetFeatureFlags(cfg, featureFlags, cas)
+		return err
+	}, 100)
+}
+
+// NodeValue returns the value of the flag on a node, ignoring its
+// cluster compatibility gate.
+func (f *FeatureFlag) NodeValue(nodeUUID string) (string, bool) {
+	if v, exists := f.NodeOverrides[nodeUUID]; exists {
+		return v, v != ""
+	}
+	return f.Default, f.Default != ""
+}
+
+// CalcFeatureFlagValues returns the values of the set feature flags
+// on a node, keyed by flag name, skipping the flags whose cluster
+// compatibility gate isn't met.
+func CalcFeatureFlagValues(cfg Cfg, featureFlags *FeatureFlags,
+	nodeUUID string) map[string]string {
+	rv := map[string]string{}
+	if featureFlags == nil {
+		return rv
+	}
+
+	for name, flag := range featureFlags.Flags {
+		v, ok := flag.NodeValue(nodeUUID)
+		if !ok {
+			continue
+		}
+
+		if flag.MinClusterCompatVersion != "" || flag.MinImplVersion != "" {
+			minImplVersion := flag.MinImplVersion
+			if minImplVersion == "" {
+				minImplVersion = "0.0.0"
+			}
+			compatible, err := clusterCompatible(cfg,
+				flag.MinClusterCompatVersion, minImplVersion)
+			if err != nil || !compatible {
+				if err != nil {
+					log.Warnf("feature_flags: name: %s, err: %v", name, err)
+				}
+				continue
+			}
+		}
+
+		rv[name] = v
+	}
+
+	return rv
+}
+
+// RefreshFeatureFlags reloads the values of the feature flags of this
+// node from the Cfg, and invokes the callbacks of the watched flags
+// whose values changed.
+func (mgr *Manager) RefreshFeatureFlags() error {
+	if mgr.cfg == nil {
+		return nil
+	}
+
+	featureFlags, _, err := CfgGetFeatureFlags(mgr.cfg)
+	if err != nil {
+		return err
+	}
+
+	values := CalcFeatureFlagValues(mgr.cfg, featureFlags, mgr.uuid)
+
+	type change struct {
+		name, value string
+		ok          bool
+		callbacks   []FeatureFlagCallback
+	}
+
+	var changes []change
+
+	mgr.featureFlagsM.Lock()
+	prev := mgr.featureFlagValues
+	mgr.featureFlagValues = values
+
+	for name, callbacks := range mgr.featureFlagWatchers {
+		pv, pok := prev[name]
+		v, ok := values[name]
+		if pv != v || pok != ok {
+			changes = append(changes, change{name, v, ok, callbacks})
+		}
+	}
+	mgr.featureFlagsM.Unlock()
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].name < changes[j].name
+	})
+
+	for _, c := range changes {
+		log.Printf("feature_flags: name: %s, value: %q, ok: %t",
+			c.name, c.value, c.ok)
+
+		for _, cb := range c.callbacks {
+			cb(c.name, c.value, c.ok)
+		}
+	}
+
+	return nil
+}
+
+// WatchFeatureFlag registers a callback that's invoked when the value
+// of a feature flag changes on this node.
+func (mgr *Manager) WatchFeatureFlag(name string, cb FeatureFlagCallback) {
+	mgr.featureFlagsM.Lock()
+	if mgr.featureFlagWatchers == nil {
+		mgr.featureFlagWatchers = map[string][]FeatureFlagCallback{}
+	}
+	mgr.featureFlagWatchers[name] = append(mgr.featureFlagWatchers[name], cb)
+	mgr.featureFlagsM.Unlock()
+}
+
+// FeatureFlags returns a copy of the values of the set feature flags
+// on this node, keyed by flag name.
+func (mgr *Manager) FeatureFlags() map[string]string {
+	mgr.featureFlagsM.RLock()
+	rv := make(map[string]string, len(mgr.featureFlagValues))
+	for name, v := range mgr.featureFlagValues {
+		rv[name] = v
+	}
+	mgr.featureFlagsM.RUnlock()
+	return rv
+}
+
+// FeatureFlag returns the value of a feature flag on this node, where
+// ok is false when the flag is unset.
+func (mgr *Manager) FeatureFlag(name string) (value string, ok bool) {
+	mgr.featureFlagsM.RLock()
+	value, ok = mgr.featureFlagValues[name]
+	mgr.featureFlagsM.RUnlock()
+	return value, ok
+}
+
+// FeatureFlagString returns the value of a feature flag on this node,
+// or the defaultValue when the flag is unset.
+func (mgr *Manager) FeatureFlagString(name, defaultValue string) string {
+	if v, ok := mgr.FeatureFlag(name); ok {
+		return v
+	}
+	return defaultValue
+}
+
+// FeatureFlagBool returns the value of a boolean feature flag on this
+// node, or the defaultValue when the flag is unset or not a boolean.
+func (mgr *Manager) FeatureFlagBool(name string, defaultValue bool) bool {
+	if v, ok := mgr.FeatureFlag(name); ok {
+		rv, err := strconv.ParseBool(v)
+		if err == nil {
+			return rv
+		}
+		log.Warnf("feature_flags: name: %s, value: %q, err: %v", name, v, err)
+	}
+	return defaultValue
+}
+
+// FeatureFlagInt returns the value of an integer feature flag on this
+// node, or the defaultValue when the flag is unset or not an integer.
+func (mgr *Manager) FeatureFlagInt(name string, defaultValue int) int {
+	if v, ok := mgr.FeatureFlag(name); ok {
+		rv, err := strconv.Atoi(v)
+		if err == nil {
+			return rv
+		}
+		log.Warnf("feature_flags: name: %s, value: %q, err: %v", name, v, err)
+	}
+	return defaultValue
+}
@@ -0,0 +1,103 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	cfg := NewCfgMem()
+
+	nodeDefs := NewNodeDefs(VERSION)
+	nodeDefs.NodeDefs["a"] = &NodeDef{UUID: "a", ImplVersion: "5.7.0"}
+	nodeDefs.NodeDefs["b"] = &NodeDef{UUID: "b", ImplVersion: "5.7.0"}
+	_, err := CfgSetNodeDefs(cfg, NODE_DEFS_KNOWN, nodeDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	for name, flag := range map[string]*FeatureFlag{
+		"fastFeed":   {Default: "true", NodeOverrides: map[string]string{"b": "false"}},
+		"batchSize":  {Default: "", NodeOverrides: map[string]string{"a": "64"}},
+		"newPlanner": {Default: "true", MinImplVersion: "99.0.0"},
+	} {
+		if err = CfgSetFeatureFlag(cfg, VERSION, name, flag); err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+
+	if err = CfgSetFeatureFlag(cfg, VERSION, "bad",
+		&FeatureFlag{MinClusterCompatVersion: "x"}); err == nil {
+		t.Fatalf("expected an err for a bad minClusterCompatVersion")
+	}
+
+	featureFlags, _, err := CfgGetFeatureFlags(cfg)
+	if err != nil || len(featureFlags.Flags) != 3 {
+		t.Fatalf("expected 3 flags, got: %+v, err: %v", featureFlags, err)
+	}
+
+	exp := map[string]string{"fastFeed": "true", "batchSize": "64"}
+	if v := CalcFeatureFlagValues(cfg, featureFlags, "a"); !reflect.DeepEqual(v, exp) {
+		t.Fatalf("expected: %v, got: %v", exp, v)
+	}
+	exp = map[string]string{"fastFeed": "false"}
+	if v := CalcFeatureFlagValues(cfg, featureFlags, "b"); !reflect.DeepEqual(v, exp) {
+		t.Fatalf("expected: %v, got: %v", exp, v)
+	}
+
+	m := NewManager(VERSION, cfg, "a", nil, "", 1, "", "", "", "", nil)
+
+	var changes []string
+	m.WatchFeatureFlag("newPlanner", func(name, value string, ok bool) {
+		changes = append(changes, name+"="+value)
+	})
+
+	if err = m.RefreshFeatureFlags(); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if !m.FeatureFlagBool("fastFeed", false) ||
+		m.FeatureFlagInt("batchSize", 0) != 64 ||
+		m.FeatureFlagBool("newPlanner", false) ||
+		m.FeatureFlagString("missing", "x") != "x" {
+		t.Fatalf("unexpected values: %v", m.FeatureFlags())
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes of a gated flag, got: %v", changes)
+	}
+
+	// Once all the nodes are upgraded, the gated flag is set.
+	for _, nodeDef := range nodeDefs.NodeDefs {
+		nodeDef.ImplVersion = "99.0.0"
+	}
+	_, err = CfgSetNodeDefs(cfg, NODE_DEFS_KNOWN, nodeDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	if err = m.RefreshFeatureFlags(); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if !m.FeatureFlagBool("newPlanner", false) ||
+		!reflect.DeepEqual(changes, []string{"newPlanner=true"}) {
+		t.Fatalf("expected newPlanner to be set, got: %v", changes)
+	}
+
+	if err = CfgSetFeatureFlag(cfg, VERSION, "newPlanner", nil); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = m.RefreshFeatureFlags(); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if _, ok := m.FeatureFlag("newPlanner"); ok ||
+		!reflect.DeepEqual(changes, []string{"newPlanner=true", "newPlanner="}) {
+		t.Fatalf("expected newPlanner to be unset, got: %v", changes)
+	}
+}
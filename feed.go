@@ -33,6 +33,32 @@ type Feed interface {
 	Stats(io.Writer) error
 }
 
+// FeedLagReporter is an optional interface that a Feed may implement
+// to report its health and how far it lags behind its source.
+type FeedLagReporter interface {
+	Lag() (*FeedLag, error)
+}
+
+// FeedLag describes the health and the lag of a feed.
+type FeedLag struct {
+	// Items of the source not yet received, summed over the partitions.
+	ItemsRemaining uint64 `json:"itemsRemaining"`
+
+	Reconnects uint64 `json:"reconnects"`
+	Rollbacks  uint64 `json:"rollbacks"`
+
+	// Keyed by source partition.
+	Partitions map[string]*FeedPartitionLag `json:"partitions,omitempty"`
+}
+
+// FeedPartitionLag describes how far a feed lags behind a source
+// partition.
+type FeedPartitionLag struct {
+	LastReceivedSeq uint64 `json:"lastReceivedSeq"`
+	HighSeq         uint64 `json:"highSeq"`
+	ItemsRemaining  uint64 `json:"itemsRemaining"`
+}
+
 // Default values for feed parameters.
 const FEED_SLEEP_MAX_MS = 10000
 const FEED_SLEEP_INIT_MS = 100
@@ -51,6 +77,7 @@ type FeedType struct {
 	Stats            FeedStatsFunc            // Optional.
 	PartitionLookUp  FeedPartitionLookUpFunc  // Optional.
 	SourceUUIDLookUp FeedSourceUUIDLookUpFunc // Optional.
+	Sample           FeedSampleFunc           // Optional.
 	Public           bool
 	Description      string
 	StartSample      interface{}
@@ -75,6 +102,12 @@ type FeedPartitionSeqsFunc func(sourceType, sourceName, sourceUUID,
 	sourceParams, server string,
 	options map[string]string) (map[string]UUIDSeq, error)
 
+// Returns a sample of a data source, such as its item count and
+// average document size, for estimating the cost of an index.
+type FeedSampleFunc func(sourceType, sourceName, sourceUUID,
+	sourceParams, server string,
+	options map[string]string) (*SourceSample, error)
+
 // A UUIDSeq associates a UUID (such as from a partition's UUID) with
 // a seq number, with an optional source's sequence number.
 type UUIDSeq struct {
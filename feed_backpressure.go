@@ -0,0 +1,189 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// The feed backpressure keeps a pindex whose persistence lags behind
+// its ingestion, such as during a bulk load, from piling up unpersisted
+// items in memory.  The backpressure loop samples the unpersisted item
+// counts of the pindexes whose Dest implements DestUnpersisted, and
+// while a pindex is over its threshold, the DCP feeds delay each of
+// its mutations, which slows down the feed's DCP flow control buffer
+// acknowledgements, and so the source's sending.  The mutations of the
+// other pindexes aren't delayed.
+
+// DestUnpersisted is an optional interface that a Dest may implement
+// to report how far its persistence lags behind its ingestion.
+type DestUnpersisted interface {
+	// UnpersistedItems returns the number of items that the Dest has
+	// ingested but not yet persisted.
+	UnpersistedItems() uint64
+}
+
+// FeedBackpressureInterval is the time between the samples of the
+// unpersisted item counts of the pindexes.
+var FeedBackpressureInterval = time.Second
+
+// FeedBackpressureMaxWait bounds how long a feed delays a mutation to
+// a lagging pindex, so that the feeds keep making progress, such as
+// for a Dest that only persists at its snapshot boundaries.
+var FeedBackpressureMaxWait = 100 * time.Millisecond
+
+// FeedBackpressureThreshold returns the unpersisted item count above
+// which the feeds of an index are slowed down, from the
+// "feedBackpressureUnpersistedItems:<indexName>" manager option, or
+// else from the "feedBackpressureUnpersistedItems" manager option.  A
+// threshold of 0 means no backpressure.
+func FeedBackpressureThreshold(options map[string]string,
+	indexName string) uint64 {
+	v, exists := options["feedBackpressureUnpersistedItems:"+indexName]
+	if !exists {
+		v = options["feedBackpressureUnpersistedItems"]
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// A laggingDest is a Dest whose unpersisted items are over threshold.
+type laggingDest struct {
+	mgr    *Manager
+	doneCh chan struct{} // Closed when the Dest is no longer lagging.
+}
+
+// The dests whose mutations are delayed by the feeds.
+var (
+	laggingDestsActive int32 // Fast path when there are none.
+	laggingDestsM      sync.RWMutex
+	laggingDests       = map[Dest]*laggingDest{}
+)
+
+// waitFeedBackpressure delays a mutation to a lagging dest, until the
+// dest is no longer lagging, the FeedBackpressureMaxWait elapses or
+// the closeCh is closed.  The DCP feeds invoke it before each
+// DataUpdate and DataDelete.
+func waitFeedBackpressure(dest Dest, closeCh <-chan struct{}) {
+	if atomic.LoadInt32(&laggingDestsActive) <= 0 {
+		return
+	}
+
+	laggingDestsM.RLock()
+	ld := laggingDests[dest]
+	laggingDestsM.RUnlock()
+
+	if ld == nil {
+		return
+	}
+
+	atomic.AddUint64(&ld.mgr.stats.TotFeedBackpressureWait, 1)
+
+	timer := time.NewTimer(FeedBackpressureMaxWait)
+	defer timer.Stop()
+
+	select {
+	case <-closeCh:
+	case <-ld.doneCh:
+	case <-timer.C:
+	}
+}
+
+// setLaggingDests replaces the lagging dests of this manager.
+func (mgr *Manager) setLaggingDests(lagging map[Dest]bool) {
+	laggingDestsM.Lock()
+	defer laggingDestsM.Unlock()
+
+	for dest, ld := range laggingDests {
+		if ld.mgr == mgr && !lagging[dest] {
+			close(ld.doneCh)
+			delete(laggingDests, dest)
+		}
+	}
+	for dest := range lagging {
+		if laggingDests[dest] == nil {
+			laggingDests[dest] = &laggingDest{
+				mgr:    mgr,
+				doneCh: make(chan struct{}),
+			}
+		}
+	}
+
+	atomic.StoreInt32(&laggingDestsActive, int32(len(laggingDests)))
+}
+
+// feedBackpressureOnce samples the unpersisted item counts of this
+// node's pindexes, and returns the names of the lagging pindexes.
+func (mgr *Manager) feedBackpressureOnce() map[string]bool {
+	options := mgr.Options()
+	_, pindexes := mgr.CurrentMaps()
+
+	lagging := map[Dest]bool{}
+	rv := map[string]bool{}
+
+	for name, pindex := range pindexes {
+		du, ok := pindex.Dest.(DestUnpersisted)
+		if !ok {
+			continue
+		}
+
+		threshold := FeedBackpressureThreshold(options, pindex.IndexName)
+		if threshold <= 0 || du.UnpersistedItems() <= threshold {
+			continue
+		}
+
+		lagging[pindex.Dest] = true
+		rv[name] = true
+	}
+
+	mgr.setLaggingDests(lagging)
+
+	return rv
+}
+
+// FeedBackpressureLoop runs the feed backpressure, until the manager
+// is stopped.
+func (mgr *Manager) FeedBackpressureLoop() {
+	ticker := time.NewTicker(FeedBackpressureInterval)
+	defer ticker.Stop()
+
+	var prev map[string]bool
+
+	for {
+		select {
+		case <-mgr.stopCh:
+			mgr.setLaggingDests(nil)
+			return
+		case <-ticker.C:
+		}
+
+		curr := mgr.feedBackpressureOnce()
+		for name := range curr {
+			if !prev[name] {
+				log.Printf("feed_backpressure: pindex: %s, persistence"+
+					" lagging, slowing down its feeds", name)
+			}
+		}
+		for name := range prev {
+			if !curr[name] {
+				log.Printf("feed_backpressure: pindex: %s, persistence"+
+					" caught up", name)
+			}
+		}
+		prev = curr
+	}
+}
@@ -0,0 +1,99 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"testing"
+	"time"
+)
+
+type testUnpersistedDest struct {
+	BlackHole
+	unpersisted uint64
+}
+
+func (t *testUnpersistedDest) UnpersistedItems() uint64 {
+	return t.unpersisted
+}
+
+func TestFeedBackpressureThreshold(t *testing.T) {
+	options := map[string]string{
+		"feedBackpressureUnpersistedItems":    "100",
+		"feedBackpressureUnpersistedItems:i1": "0",
+		"feedBackpressureUnpersistedItems:i2": "bad",
+	}
+	if FeedBackpressureThreshold(options, "i0") != 100 {
+		t.Errorf("expected the default threshold")
+	}
+	if FeedBackpressureThreshold(options, "i1") != 0 ||
+		FeedBackpressureThreshold(options, "i2") != 0 {
+		t.Errorf("expected no backpressure")
+	}
+	if FeedBackpressureThreshold(nil, "i0") != 0 {
+		t.Errorf("expected no backpressure without options")
+	}
+}
+
+func TestFeedBackpressure(t *testing.T) {
+	mgr := NewManagerEx(VERSION, nil, NewUUID(), []string{"pindex"},
+		"", 1, "", "", "", "", nil, map[string]string{
+			"feedBackpressureUnpersistedItems": "100",
+		})
+	defer mgr.setLaggingDests(nil)
+
+	dest0 := &testUnpersistedDest{unpersisted: 500}
+	dest1 := &testUnpersistedDest{unpersisted: 50}
+	dest2 := &BlackHole{}
+	mgr.registerPIndex(&PIndex{Name: "p0", IndexName: "i0", Dest: dest0})
+	mgr.registerPIndex(&PIndex{Name: "p1", IndexName: "i0", Dest: dest1})
+	mgr.registerPIndex(&PIndex{Name: "p2", IndexName: "i0", Dest: dest2})
+
+	lagging := mgr.feedBackpressureOnce()
+	if len(lagging) != 1 || !lagging["p0"] {
+		t.Fatalf("expected only p0 to be lagging, got: %v", lagging)
+	}
+
+	prevMaxWait := FeedBackpressureMaxWait
+	FeedBackpressureMaxWait = time.Minute
+	defer func() { FeedBackpressureMaxWait = prevMaxWait }()
+
+	// The mutations of the other pindexes aren't delayed.
+	waitFeedBackpressure(dest1, nil)
+	waitFeedBackpressure(dest2, nil)
+	if mgr.stats.TotFeedBackpressureWait != 0 {
+		t.Fatalf("expected no waits")
+	}
+
+	closeCh := make(chan struct{})
+	close(closeCh)
+	waitFeedBackpressure(dest0, closeCh)
+
+	waitedCh := make(chan struct{})
+	go func() {
+		waitFeedBackpressure(dest0, nil)
+		close(waitedCh)
+	}()
+
+	select {
+	case <-waitedCh:
+		t.Fatalf("expected the mutation to be delayed")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Once the persistence catches up, the delayed mutation proceeds.
+	dest0.unpersisted = 0
+	if lagging = mgr.feedBackpressureOnce(); len(lagging) != 0 {
+		t.Fatalf("expected no lagging pindexes, got: %v", lagging)
+	}
+	<-waitedCh
+
+	if mgr.stats.TotFeedBackpressureWait != 2 {
+		t.Errorf("expected 2 waits, got: %d", mgr.stats.TotFeedBackpressureWait)
+	}
+}
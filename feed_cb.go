@@ -10,6 +10,7 @@ package cbgt
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -138,6 +139,18 @@ func CBAuthHttpGet(urlStrIn string) (resp *http.Response, err error) {
 	return HttpClient().Get(urlStr)
 }
 
+// CBAuthHttpPost is a couchbase-specific http.Post(), for use in a
+// cbauth'ed environment.
+func CBAuthHttpPost(urlStrIn, contentType string,
+	body io.Reader) (resp *http.Response, err error) {
+	urlStr, err := CBAuthURL(urlStrIn)
+	if err != nil {
+		return nil, err
+	}
+
+	return HttpClient().Post(urlStr, contentType, body)
+}
+
 // CBAuthHttpGetWithClient is a couchbase-specific http.Get() with *http.Client
 // parameterisation, for use in a cbauth'ed environment.
 func CBAuthHttpGetWithClient(urlStrIn string, client HTTPClient) (resp *http.Response,
@@ -34,6 +34,10 @@ var maxEndSeqno = gocbcore.SeqNo(0xffffffffffffffff)
 
 var errBucketUUIDMismatched = fmt.Errorf("mismatched bucketUUID")
 
+// gocbcoreLogThrottle coalesces the stream error storms of flapping
+// feeds.
+var gocbcoreLogThrottle = LogThrottleFor("feed_dcp_gocbcore")
+
 // ----------------------------------------------------------------
 
 var streamID uint64
@@ -493,17 +497,24 @@ type GocbcoreDCPFeed struct {
 	closed            bool
 	shutdownInitiated bool
 	active            map[uint16]bool
+	backfilling       map[uint16]*time.Timer // Streams holding a backfill slot.
+	backfillPhases    *BackfillPhases
 	stats             *DestStats
 	stopAfterReached  map[string]bool // May be nil.
 
 	closeCh chan struct{}
+
+	highSeqnosM  sync.Mutex // See Lag().
+	highSeqnos   map[uint16]uint64
+	highSeqnosAt time.Time
 }
 
 type gocbcoreDCPFeedStats struct {
 	// TODO: Add more stats
-	TotDCPStreamReqs uint64
-	TotDCPStreamEnds uint64
-	TotDCPRollbacks  uint64
+	TotDCPStreamReqs       uint64
+	TotDCPStreamEnds       uint64
+	TotDCPStreamReconnects uint64
+	TotDCPRollbacks        uint64
 
 	TotDCPSnapshotMarkers   uint64
 	TotDCPMutations         uint64
@@ -580,23 +591,25 @@ func newGocbcoreDCPFeed(name, indexName, indexUUID, servers,
 	}
 
 	feed := &GocbcoreDCPFeed{
-		name:       name,
-		indexName:  indexName,
-		indexUUID:  indexUUID,
-		servers:    servers,
-		bucketName: bucketName,
-		bucketUUID: bucketUUID,
-		params:     params,
-		pf:         pf,
-		dests:      dests,
-		disable:    disable,
-		stopAfter:  stopAfter,
-		mgr:        mgr,
-		vbucketIds: vbucketIds,
-		dcpStats:   &gocbcoreDCPFeedStats{},
-		stats:      NewDestStats(),
-		active:     make(map[uint16]bool),
-		closeCh:    make(chan struct{}),
+		name:           name,
+		indexName:      indexName,
+		indexUUID:      indexUUID,
+		servers:        servers,
+		bucketName:     bucketName,
+		bucketUUID:     bucketUUID,
+		params:         params,
+		pf:             pf,
+		dests:          dests,
+		disable:        disable,
+		stopAfter:      stopAfter,
+		mgr:            mgr,
+		vbucketIds:     vbucketIds,
+		dcpStats:       &gocbcoreDCPFeedStats{},
+		stats:          NewDestStats(),
+		active:         make(map[uint16]bool),
+		backfilling:    make(map[uint16]*time.Timer),
+		backfillPhases: NewBackfillPhases(),
+		closeCh:        make(chan struct{}),
 	}
 
 	for partition, dest := range dests {
@@ -608,13 +621,8 @@ func newGocbcoreDCPFeed(name, indexName, indexUUID, servers,
 		}
 	}
 
-	if len(params.Scope) == 0 && len(params.Collections) == 0 {
-		feed.scope = "_default"
-		feed.collections = []string{"_default"}
-	} else {
-		feed.scope = params.Scope
-		feed.collections = params.Collections
-	}
+	feed.scope, feed.collections =
+		feedScopeCollections(params.Scope, params.Collections)
 
 	// sort the vbucketIds list to determine the largest vbucketId
 	sort.Slice(vbucketIds, func(i, j int) bool { return vbucketIds[i] < vbucketIds[j] })
@@ -724,50 +732,77 @@ func (f *GocbcoreDCPFeed) setupStreamOptions(paramsStr string,
 		StreamID: newStreamID(),
 	}
 
-	if len(f.collections) == 0 {
-		// if no collections were specified, set up stream requests for
-		// the entire scope.
-		f.streamOptions.FilterOptions = &gocbcore.OpenStreamFilterOptions{
-			ScopeID: f.scopeID,
+	// Resolve the collection IDs from the same manifest as the scope
+	// ID, so the stream filter is consistent and needs no further
+	// round trips to the data service.
+	f.streamOptions.FilterOptions, f.collectionIDs, err =
+		collectionsStreamFilter(&manifest, f.scopeID, f.scope, f.collections)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// feedScopeCollections returns the scope and the collections that a
+// feed streams from, given the scope and collections of its params.
+// Without either, the feed streams the default collection, and
+// without a scope, the collections are in the default scope.
+func feedScopeCollections(scope string, collections []string) (
+	string, []string) {
+	if len(scope) == 0 && len(collections) == 0 {
+		return "_default", []string{"_default"}
+	}
+
+	if len(scope) == 0 {
+		scope = "_default"
+	}
+
+	var rv []string
+	seen := map[string]bool{}
+	for _, coll := range collections {
+		if !seen[coll] {
+			seen[coll] = true
+			rv = append(rv, coll)
 		}
-	} else {
-		for _, coll := range f.collections {
-			op, err = agent.GetCollectionID(f.scope, coll,
-				gocbcore.GetCollectionIDOptions{},
-				func(res *gocbcore.GetCollectionIDResult, er error) {
-					if er == nil && res == nil {
-						er = fmt.Errorf("collection ID not retrieved")
-					}
+	}
 
-					if er == nil {
-						if res.ManifestID != f.manifestUID {
-							er = fmt.Errorf("manifestID mismatch, %v != %v",
-								res.ManifestID, f.manifestUID)
-						} else {
-							f.collectionIDs =
-								append(f.collectionIDs, res.CollectionID)
-						}
-					}
+	return scope, rv
+}
 
-					signal <- er
-				})
-			if err != nil {
-				return fmt.Errorf("GetCollectionID, collection: %v, err: %v",
-					coll, err)
-			}
+// collectionsStreamFilter returns the DCP stream filter for the given
+// scope and collections, pushing the filtering down to the data
+// service, so that only the mutations of the collections are sent.
+// Without collections, the whole scope is streamed.  The collection
+// IDs are returned in the order of the collections.
+func collectionsStreamFilter(manifest *gocbcore.Manifest, scopeID uint32,
+	scope string, collections []string) (
+	*gocbcore.OpenStreamFilterOptions, []uint32, error) {
+	if len(collections) == 0 {
+		return &gocbcore.OpenStreamFilterOptions{ScopeID: scopeID}, nil, nil
+	}
 
-			err = waitForResponse(signal, f.closeCh, op, GocbcoreStatsTimeout)
-			if err != nil {
-				return fmt.Errorf("failed to get collection ID, err : %v", err)
+	collIDs := map[string]uint32{}
+	for _, manifestScope := range manifest.Scopes {
+		if manifestScope.Name == scope {
+			for _, coll := range manifestScope.Collections {
+				collIDs[coll.Name] = coll.UID
 			}
+			break
 		}
+	}
 
-		f.streamOptions.FilterOptions = &gocbcore.OpenStreamFilterOptions{
-			CollectionIDs: f.collectionIDs,
+	rv := make([]uint32, 0, len(collections))
+	for _, coll := range collections {
+		collID, exists := collIDs[coll]
+		if !exists {
+			return nil, nil, fmt.Errorf("%w, scope: %s, collection: %s",
+				gocbcore.ErrCollectionNotFound, scope, coll)
 		}
+		rv = append(rv, collID)
 	}
 
-	return nil
+	return &gocbcore.OpenStreamFilterOptions{CollectionIDs: rv}, rv, nil
 }
 
 // ----------------------------------------------------------------
@@ -872,6 +907,7 @@ func (f *GocbcoreDCPFeed) closeAllStreamsLOCKED() {
 			f.active[vbId] = false
 			f.remaining.Done()
 		}
+		f.backfillDoneLOCKED(vbId)
 	}
 }
 
@@ -879,6 +915,11 @@ func (f *GocbcoreDCPFeed) Dests() map[string]Dest {
 	return f.dests
 }
 
+// BackfillingPartitions implements the FeedBackfills interface.
+func (f *GocbcoreDCPFeed) BackfillingPartitions() []string {
+	return f.backfillPhases.Partitions()
+}
+
 var prefixAgentDCPStats = []byte(`{"agentDCPStats":`)
 
 func (f *GocbcoreDCPFeed) Stats(w io.Writer) error {
@@ -937,9 +978,23 @@ func (f *GocbcoreDCPFeed) initiateStream(vbId uint16) error {
 
 func (f *GocbcoreDCPFeed) initiateStreamEx(vbId uint16, isNewStream bool,
 	vbuuid gocbcore.VbUUID, seqStart, seqEnd gocbcore.SeqNo) {
+	// A new stream from scratch needs a full backfill, which waits
+	// for its turn as per the node's backfill throttle.
+	throttle := f.backfillThrottle()
+	backfill := isNewStream && seqStart == 0 && throttle.Enabled()
+	if backfill {
+		share := PriorityClassShare(f.mgr.IndexPriorityClass(f.indexName))
+		if err := throttle.AcquireShare(f.closeCh, share); err != nil {
+			return
+		}
+	}
+
 	f.m.Lock()
 	if f.closed {
 		f.m.Unlock()
+		if backfill {
+			throttle.Release()
+		}
 		return
 	}
 	if isNewStream {
@@ -948,6 +1003,24 @@ func (f *GocbcoreDCPFeed) initiateStreamEx(vbId uint16, isNewStream bool,
 			f.active[vbId] = true
 		}
 	}
+	if backfill {
+		if timer, exists := f.backfilling[vbId]; exists {
+			timer.Stop()
+			throttle.Release() // Already holding a slot.
+		}
+
+		// An idle vbucket might never send a snapshot marker, so bound
+		// how long its stream can hold on to a backfill slot.
+		var timer *time.Timer
+		timer = time.AfterFunc(BackfillSlotMaxHold, func() {
+			f.m.Lock()
+			if f.backfilling[vbId] == timer { // Not a stale timer.
+				f.backfillDoneLOCKED(vbId)
+			}
+			f.m.Unlock()
+		})
+		f.backfilling[vbId] = timer
+	}
 	f.m.Unlock()
 
 	dcpStreamAddFlags := memd.DcpStreamAddFlagActiveOnly |
@@ -984,18 +1057,19 @@ func (f *GocbcoreDCPFeed) initiateStreamEx(vbId uint16, isNewStream bool,
 				// rollback will handle this feed closure and setting up of a new feed
 				er = nil
 			} else if errors.Is(er, gocbcore.ErrRequestCanceled) {
+				f.backfillThrottle().NoteBackoff()
 				// request was canceled by FTS, catch error and re-initiate stream request
-				log.Warnf("feed_dcp_gocbcore: [%s] OpenStream for vb: %v, streamOptions: %+v"+
+				gocbcoreLogThrottle.Warnf("feed_dcp_gocbcore: [%s] OpenStream for vb: %v, streamOptions: %+v"+
 					" was canceled, (timeout) will re-initiate the stream request",
 					f.Name(), vbId, f.streamOptions.StreamOptions)
 			} else if errors.Is(er, gocbcore.ErrForcedReconnect) {
 				// request was canceled by GOCBCORE, catch error and re-initate stream request
-				log.Warnf("feed_dcp_gocbcore: [%s] OpenStream for vb: %v, streamOptions: %+v"+
+				gocbcoreLogThrottle.Warnf("feed_dcp_gocbcore: [%s] OpenStream for vb: %v, streamOptions: %+v"+
 					"failed with err: %v, reconnecting ...", f.Name(),
 					vbId, f.streamOptions.StreamOptions, er)
 			} else if er != nil {
 				// unidentified error
-				log.Errorf("feed_dcp_gocbcore: [%s] OpenStream received error for vb: %v, "+
+				gocbcoreLogThrottle.Errorf("feed_dcp_gocbcore: [%s] OpenStream received error for vb: %v, "+
 					" streamOptions: %+v, err: %v", f.Name(), vbId,
 					f.streamOptions.StreamOptions, er)
 				f.complete(vbId)
@@ -1041,6 +1115,9 @@ func (f *GocbcoreDCPFeed) initiateStreamEx(vbId uint16, isNewStream bool,
 	err = waitForResponse(signal, f.closeCh, op, GocbcoreConnectTimeout)
 	if err != nil {
 		if errors.Is(err, gocbcore.ErrTimeout) || errors.Is(err, gocbcore.ErrForcedReconnect) {
+			f.backfillThrottle().NoteBackoff()
+			atomic.AddUint64(&f.dcpStats.TotDCPStreamReconnects, 1)
+
 			// Verify source exists before closing and re-initiating stream request(s).
 			if gone, _, _ := f.checkIfSourceExists(false, true); gone {
 				f.initiateShutdown(fmt.Errorf("feed_dcp_gocbcore: [%s], OpenStream,"+
@@ -1068,7 +1145,7 @@ func (f *GocbcoreDCPFeed) initiateStreamEx(vbId uint16, isNewStream bool,
 					return
 				}
 
-				log.Warnf("feed_dcp_gocbcore: [%s] CloseStream for vb: %v,"+
+				gocbcoreLogThrottle.Warnf("feed_dcp_gocbcore: [%s] CloseStream for vb: %v,"+
 					" streamOptions: %+v, err: %v", f.Name(), vbId,
 					f.streamOptions.StreamOptions, err)
 			}
@@ -1114,7 +1191,7 @@ func (f *GocbcoreDCPFeed) initiateShutdown(err error) {
 // onError is to be invoked in case of errors encountered while
 // processing DCP messages.
 func (f *GocbcoreDCPFeed) onError(notifyMgr bool, err error) error {
-	log.Warnf("feed_dcp_gocbcore: onError, name: %s,"+
+	gocbcoreLogThrottle.Warnf("feed_dcp_gocbcore: onError, name: %s,"+
 		" bucketName: %s, bucketUUID: %s, err: %v",
 		f.Name(), f.bucketName, f.bucketUUID, err)
 
@@ -1211,9 +1288,10 @@ func (f *GocbcoreDCPFeed) getMetaData(vbId uint16) (*metaData, uint64, error) {
 
 func (f *GocbcoreDCPFeed) rollback(vbId uint16, rollbackSeqno uint64) error {
 	// Determine rollbackVbuuid from the failover log.
-	var rollbackVbuuid uint64
-	vbMetaData, _, err := f.getMetaData(vbId)
+	var rollbackVbuuid, vbuuid uint64
+	vbMetaData, lastSeq, err := f.getMetaData(vbId)
 	if err == nil && len(vbMetaData.FailOverLog) > 0 {
+		vbuuid = vbMetaData.FailOverLog[0][0]
 		for j := 0; j < len(vbMetaData.FailOverLog); j++ {
 			if vbMetaData.FailOverLog[j][1] <= rollbackSeqno {
 				rollbackVbuuid = vbMetaData.FailOverLog[j][0]
@@ -1226,6 +1304,10 @@ func (f *GocbcoreDCPFeed) rollback(vbId uint16, rollbackSeqno uint64) error {
 		" rollbackSeqno: %v, rollbackVbuuid: %v",
 		f.Name(), vbId, rollbackSeqno, rollbackVbuuid)
 
+	if rollbackSeqno == 0 && f.mgr != nil {
+		f.mgr.NoteRollbackToZero(f.bucketName)
+	}
+
 	err = Timer(func() error {
 		partition, dest, err :=
 			VBucketIdToPartitionDest(f.pf, f.dests, vbId, nil)
@@ -1247,6 +1329,23 @@ func (f *GocbcoreDCPFeed) rollback(vbId uint16, rollbackSeqno uint64) error {
 		atomic.AddUint64(&f.dcpStats.TotDCPRollbacks, 1)
 	}
 
+	if f.mgr != nil {
+		d := &RollbackDiagnostic{
+			FeedName:   f.Name(),
+			IndexName:  f.indexName,
+			SourceName: f.bucketName,
+			Partition:  strconv.Itoa(int(vbId)),
+			OldUUID:    vbuuid,
+			OldSeq:     lastSeq,
+			NewUUID:    rollbackVbuuid,
+			NewSeq:     rollbackSeqno,
+		}
+		if err != nil {
+			d.Err = err.Error()
+		}
+		f.mgr.AddRollbackDiagnostic(d)
+	}
+
 	return err
 }
 
@@ -1262,11 +1361,44 @@ func (f *GocbcoreDCPFeed) complete(vbId uint16) {
 		f.active[vbId] = false
 		f.remaining.Done()
 	}
+	f.backfillDoneLOCKED(vbId)
 	f.m.Unlock()
 }
 
 // ----------------------------------------------------------------
 
+func (f *GocbcoreDCPFeed) backfillThrottle() *BackfillThrottle {
+	if f.mgr == nil {
+		return nil
+	}
+	return f.mgr.BackfillThrottle()
+}
+
+func (f *GocbcoreDCPFeed) memoryGovernor() *MemoryGovernor {
+	if f.mgr == nil {
+		return nil
+	}
+	return f.mgr.MemoryGovernor()
+}
+
+// backfillDone releases the backfill slot held by a vbucket's stream,
+// if any, such as once the stream has moved on to in-memory snapshots.
+func (f *GocbcoreDCPFeed) backfillDone(vbId uint16) {
+	f.m.Lock()
+	f.backfillDoneLOCKED(vbId)
+	f.m.Unlock()
+}
+
+func (f *GocbcoreDCPFeed) backfillDoneLOCKED(vbId uint16) {
+	if timer, exists := f.backfilling[vbId]; exists {
+		timer.Stop()
+		delete(f.backfilling, vbId)
+		f.backfillThrottle().Release()
+	}
+}
+
+// ----------------------------------------------------------------
+
 // checkStopAfter checks to see if we've already reached the
 // stopAfterReached state for a partition.
 func (f *GocbcoreDCPFeed) checkStopAfter(partition string) bool {
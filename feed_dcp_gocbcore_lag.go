@@ -0,0 +1,137 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/gocbcore/v10"
+	"github.com/couchbase/gocbcore/v10/memd"
+)
+
+// FeedLagRefreshInterval bounds how often a feed fetches the high
+// seqnos of its source's vbuckets for its Lag(), so that frequent
+// stats and metrics requests don't load the data service.
+var FeedLagRefreshInterval = 5 * time.Second
+
+// Lag implements the FeedLagReporter interface, comparing the last
+// received seqno of each of the feed's vbuckets with the vbucket's
+// high seqno, as of the feed's collections when the feed streams
+// specific collections.
+func (f *GocbcoreDCPFeed) Lag() (*FeedLag, error) {
+	highSeqnos, err := f.vbucketHighSeqnos()
+	if err != nil {
+		return nil, err
+	}
+
+	rv := &FeedLag{
+		Reconnects: atomic.LoadUint64(&f.dcpStats.TotDCPStreamReconnects),
+		Rollbacks:  atomic.LoadUint64(&f.dcpStats.TotDCPRollbacks),
+		Partitions: make(map[string]*FeedPartitionLag, len(f.vbucketIds)),
+	}
+
+	for _, vbId := range f.vbucketIds {
+		lag := &FeedPartitionLag{
+			LastReceivedSeq: atomic.LoadUint64(&f.lastReceivedSeqno[vbId]),
+			HighSeq:         highSeqnos[vbId],
+		}
+		if lag.HighSeq > lag.LastReceivedSeq {
+			lag.ItemsRemaining = lag.HighSeq - lag.LastReceivedSeq
+		}
+
+		partition := strconv.Itoa(int(vbId))
+		if vbId < uint16(len(vbucketIdStrings)) {
+			partition = vbucketIdStrings[vbId]
+		}
+
+		rv.Partitions[partition] = lag
+		rv.ItemsRemaining += lag.ItemsRemaining
+	}
+
+	return rv, nil
+}
+
+// vbucketHighSeqnos returns the high seqnos of the source's active
+// vbuckets, fetched at most every FeedLagRefreshInterval.
+func (f *GocbcoreDCPFeed) vbucketHighSeqnos() (map[uint16]uint64, error) {
+	f.highSeqnosM.Lock()
+	defer f.highSeqnosM.Unlock()
+
+	if f.highSeqnos != nil &&
+		time.Since(f.highSeqnosAt) < FeedLagRefreshInterval {
+		return f.highSeqnos, nil
+	}
+
+	if f.agent == nil {
+		return nil, fmt.Errorf("feed_dcp_gocbcore: Lag, no agent")
+	}
+
+	snapshot, err := f.agent.ConfigSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("feed_dcp_gocbcore: Lag,"+
+			" ConfigSnapshot, err: %v", err)
+	}
+
+	numServers, err := snapshot.NumServers()
+	if err != nil {
+		return nil, fmt.Errorf("feed_dcp_gocbcore: Lag,"+
+			" NumServers, err: %v", err)
+	}
+
+	// Without specific collections, such as for a whole scope, the
+	// vbuckets' high seqnos are used.
+	filters := []*gocbcore.GetVbucketSeqnoFilterOptions{nil}
+	if len(f.collectionIDs) > 0 {
+		filters = filters[:0]
+		for _, collID := range f.collectionIDs {
+			filters = append(filters,
+				&gocbcore.GetVbucketSeqnoFilterOptions{CollectionID: collID})
+		}
+	}
+
+	rv := map[uint16]uint64{}
+	signal := make(chan error, 1)
+
+	for serverIdx := 1; serverIdx < numServers+1; serverIdx++ {
+		for _, filter := range filters {
+			op, err := f.agent.GetVbucketSeqnos(serverIdx,
+				memd.VbucketStateActive,
+				gocbcore.GetVbucketSeqnoOptions{FilterOptions: filter},
+				func(entries []gocbcore.VbSeqNoEntry, er error) {
+					if er == nil {
+						for _, entry := range entries {
+							if uint64(entry.SeqNo) > rv[entry.VbID] {
+								rv[entry.VbID] = uint64(entry.SeqNo)
+							}
+						}
+					}
+
+					signal <- er
+				})
+			if err != nil {
+				return nil, fmt.Errorf("feed_dcp_gocbcore: Lag,"+
+					" GetVbucketSeqnos, err: %v", err)
+			}
+
+			err = waitForResponse(signal, f.closeCh, op, GocbcoreStatsTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("feed_dcp_gocbcore: Lag,"+
+					" GetVbucketSeqnos callback, err: %v", err)
+			}
+		}
+	}
+
+	f.highSeqnos = rv
+	f.highSeqnosAt = time.Now()
+
+	return rv, nil
+}
@@ -16,6 +16,7 @@ import (
 
 	log "github.com/couchbase/clog"
 	"github.com/couchbase/gocbcore/v10"
+	"github.com/couchbase/gocbcore/v10/memd"
 )
 
 // DEST_EXTRAS_TYPE_GOCBCORE_DCP represents gocb DCP mutation/deletion metadata
@@ -67,6 +68,12 @@ func (f *GocbcoreDCPFeed) SnapshotMarker(sm gocbcore.DcpSnapshotMarker) {
 			}
 		}
 
+		err = f.backfillPhases.Snapshot(partition, dest,
+			uint32(sm.SnapshotType))
+		if err != nil {
+			return err
+		}
+
 		return dest.SnapshotStart(partition, sm.StartSeqNo, sm.EndSeqNo)
 	}, f.stats.TimerSnapshotStart)
 
@@ -77,6 +84,11 @@ func (f *GocbcoreDCPFeed) SnapshotMarker(sm gocbcore.DcpSnapshotMarker) {
 	}
 
 	atomic.AddUint64(&f.dcpStats.TotDCPSnapshotMarkers, 1)
+
+	if sm.SnapshotType.HasInMemory() {
+		// The stream has caught up with the backfill.
+		f.backfillDone(sm.VbID)
+	}
 }
 
 func (f *GocbcoreDCPFeed) Mutation(m gocbcore.DcpMutation) {
@@ -86,6 +98,12 @@ func (f *GocbcoreDCPFeed) Mutation(m gocbcore.DcpMutation) {
 		return
 	}
 
+	// Hold back the mutation, and so the stream, while the node is
+	// over its memory quota.
+	if err := f.memoryGovernor().Throttle(f.closeCh); err != nil {
+		return // The feed is closing.
+	}
+
 	err := Timer(func() error {
 		partition, dest, err :=
 			VBucketIdToPartitionDest(f.pf, f.dests, m.VbID, m.Key)
@@ -93,26 +111,44 @@ func (f *GocbcoreDCPFeed) Mutation(m gocbcore.DcpMutation) {
 			return err
 		}
 
-		if destEx, ok := dest.(DestEx); ok {
-			extras := GocbcoreDCPExtras{
-				Expiry:   m.Expiry,
-				Flags:    m.Flags,
-				Datatype: m.Datatype,
-			}
-			if f.agent.HasCollectionsSupport() {
-				extras.ScopeId = f.streamOptions.FilterOptions.ScopeID
-				extras.CollectionId = m.CollectionID
-			}
-			err = destEx.DataUpdateEx(partition, m.Key, m.SeqNo, m.Value, m.Cas,
-				DEST_EXTRAS_TYPE_GOCBCORE_DCP, extras)
+		if diskQuotaRejectsMutation(dest) {
+			f.updateStopAfter(partition, m.SeqNo)
+			return nil // See DiskQuotaActionRejectMutations.
+		}
+
+		waitFeedBackpressure(dest, f.closeCh)
+
+		unlock := lockDeadLetterKey(dest, partition, m.Key)
+
+		extrasType, extras := f.destExtras(dest, GocbcoreDCPExtras{
+			Expiry:   m.Expiry,
+			Flags:    m.Flags,
+			Datatype: m.Datatype,
+		}, m.CollectionID)
+
+		xattrs := m.Datatype&uint8(memd.DatatypeFlagXattrs) != 0
+
+		val, transformErr := transformMutationXattrs(dest, partition,
+			m.Key, m.Value, xattrs)
+		if transformErr != nil { // See DocTransformer.
+			err = f.dataDelete(dest, partition, m.Key, m.SeqNo, m.Cas,
+				0, nil, m.CollectionID)
+			sampleMutation(dest, partition, m.Key, m.SeqNo, nil, m.Cas,
+				true, err)
+			err = transformFailedMutation(dest, partition, m.Key, m.SeqNo,
+				m.Value, xattrs, m.Cas, extrasType, extras, transformErr, err)
 		} else {
-			extras := make([]byte, 8) // 8 bytes needed to hold 2 uint32s
-			binary.LittleEndian.PutUint32(extras[0:], f.scopeID)
-			binary.LittleEndian.PutUint32(extras[4:], m.CollectionID)
-			err = dest.DataUpdate(partition, m.Key, m.SeqNo, m.Value, m.Cas,
-				DEST_EXTRAS_TYPE_GOCBCORE_SCOPE_COLLECTION, extras)
+			err = destDataUpdate(dest, partition, m.Key, m.SeqNo, val, m.Cas,
+				extrasType, extras)
+
+			sampleMutation(dest, partition, m.Key, m.SeqNo, val, m.Cas,
+				false, err)
+			err = deadLetterMutation(dest, partition, m.Key, m.SeqNo, val,
+				m.Cas, false, extrasType, extras, err)
 		}
 
+		unlock()
+
 		if err != nil {
 			return fmt.Errorf("name: %s, partition: %s, key: %v, seq: %d, err: %v",
 				f.Name(), partition, log.Tag(log.UserData, m.Key), m.SeqNo, err)
@@ -129,7 +165,7 @@ func (f *GocbcoreDCPFeed) Mutation(m gocbcore.DcpMutation) {
 		return
 	}
 
-	f.lastReceivedSeqno[m.VbID] = m.SeqNo
+	atomic.StoreUint64(&f.lastReceivedSeqno[m.VbID], m.SeqNo)
 
 	atomic.AddUint64(&f.dcpStats.TotDCPMutations, 1)
 }
@@ -148,24 +184,24 @@ func (f *GocbcoreDCPFeed) Deletion(d gocbcore.DcpDeletion) {
 			return err
 		}
 
-		if destEx, ok := dest.(DestEx); ok {
-			extras := GocbcoreDCPExtras{
-				Datatype: d.Datatype,
-				Value:    d.Value,
-			}
-			if f.agent.HasCollectionsSupport() {
-				extras.ScopeId = f.streamOptions.FilterOptions.ScopeID
-				extras.CollectionId = d.CollectionID
-			}
-			err = destEx.DataDeleteEx(partition, d.Key, d.SeqNo, d.Cas,
-				DEST_EXTRAS_TYPE_GOCBCORE_DCP, extras)
-		} else {
-			extras := make([]byte, 8) // 8 bytes needed to hold 2 uint32s
-			binary.LittleEndian.PutUint32(extras[0:], f.scopeID)
-			binary.LittleEndian.PutUint32(extras[4:], d.CollectionID)
-			err = dest.DataDelete(partition, d.Key, d.SeqNo, d.Cas,
-				DEST_EXTRAS_TYPE_GOCBCORE_SCOPE_COLLECTION, extras)
-		}
+		waitFeedBackpressure(dest, f.closeCh)
+
+		unlock := lockDeadLetterKey(dest, partition, d.Key)
+
+		extrasType, extras := f.destExtras(dest, GocbcoreDCPExtras{
+			Datatype: d.Datatype,
+			Value:    d.Value,
+		}, d.CollectionID)
+
+		err = destDataDelete(dest, partition, d.Key, d.SeqNo, d.Cas,
+			extrasType, extras)
+
+		sampleMutation(dest, partition, d.Key, d.SeqNo, d.Value, d.Cas,
+			true, err)
+		err = deadLetterMutation(dest, partition, d.Key, d.SeqNo, nil, d.Cas,
+			true, extrasType, extras, err)
+
+		unlock()
 
 		if err != nil {
 			return fmt.Errorf("name: %s, partition: %s, key: %v, seq: %d, err: %v",
@@ -183,11 +219,43 @@ func (f *GocbcoreDCPFeed) Deletion(d gocbcore.DcpDeletion) {
 		return
 	}
 
-	f.lastReceivedSeqno[d.VbID] = d.SeqNo
+	atomic.StoreUint64(&f.lastReceivedSeqno[d.VbID], d.SeqNo)
 
 	atomic.AddUint64(&f.dcpStats.TotDCPDeletions, 1)
 }
 
+// dataDelete deletes a key from the dest, where the value and its
+// datatype carry the xattrs of the deletion, if any.
+func (f *GocbcoreDCPFeed) dataDelete(dest Dest, partition string,
+	key []byte, seq, cas uint64, datatype uint8, value []byte,
+	collectionID uint32) error {
+	extrasType, extras := f.destExtras(dest, GocbcoreDCPExtras{
+		Datatype: datatype,
+		Value:    value,
+	}, collectionID)
+
+	return destDataDelete(dest, partition, key, seq, cas, extrasType, extras)
+}
+
+// destExtras returns the extras of a mutation or deletion of the
+// collection for the dest, which are the GocbcoreDCPExtras for a
+// DestEx, and otherwise the scope and collection IDs.
+func (f *GocbcoreDCPFeed) destExtras(dest Dest, extras GocbcoreDCPExtras,
+	collectionID uint32) (DestExtrasType, interface{}) {
+	if _, ok := dest.(DestEx); ok {
+		if f.agent.HasCollectionsSupport() {
+			extras.ScopeId = f.streamOptions.FilterOptions.ScopeID
+			extras.CollectionId = collectionID
+		}
+		return DEST_EXTRAS_TYPE_GOCBCORE_DCP, extras
+	}
+
+	buf := make([]byte, 8) // 8 bytes needed to hold 2 uint32s
+	binary.LittleEndian.PutUint32(buf[0:], f.scopeID)
+	binary.LittleEndian.PutUint32(buf[4:], collectionID)
+	return DEST_EXTRAS_TYPE_GOCBCORE_SCOPE_COLLECTION, buf
+}
+
 func (f *GocbcoreDCPFeed) Expiration(e gocbcore.DcpExpiration) {
 	f.Deletion(gocbcore.DcpDeletion{
 		SeqNo:        e.SeqNo,
@@ -210,7 +278,7 @@ func (f *GocbcoreDCPFeed) End(e gocbcore.DcpStreamEnd, err error) {
 	}
 	updateDCPAgentsDetails(f.bucketName, f.bucketUUID, f.name, f.agent, sid, false)
 
-	lastReceivedSeqno := f.lastReceivedSeqno[e.VbID]
+	lastReceivedSeqno := atomic.LoadUint64(&f.lastReceivedSeqno[e.VbID])
 	if err == nil {
 		f.complete(e.VbID)
 		log.Printf("feed_dcp_gocbcore: [%s] DCP stream [%v] ended for vb: %v,"+
@@ -225,6 +293,10 @@ func (f *GocbcoreDCPFeed) End(e gocbcore.DcpStreamEnd, err error) {
 		errors.Is(err, gocbcore.ErrDCPStreamTooSlow) ||
 		errors.Is(err, gocbcore.ErrDCPStreamDisconnected) ||
 		errors.Is(err, gocbcore.ErrForcedReconnect) {
+		if errors.Is(err, gocbcore.ErrDCPStreamTooSlow) {
+			f.backfillThrottle().NoteBackoff()
+		}
+		atomic.AddUint64(&f.dcpStats.TotDCPStreamReconnects, 1)
 		log.Printf("feed_dcp_gocbcore: [%s] DCP stream [%v] for vb: %v, closed due to"+
 			" `%s`, last seq: %v, reconnecting ...",
 			f.Name(), e.StreamID, e.VbID, err.Error(), lastReceivedSeqno)
@@ -283,7 +355,7 @@ func (f *GocbcoreDCPFeed) CreateCollection(c gocbcore.DcpCollectionCreation) {
 		return
 	}
 
-	f.lastReceivedSeqno[c.VbID] = c.SeqNo
+	atomic.StoreUint64(&f.lastReceivedSeqno[c.VbID], c.SeqNo)
 
 	atomic.AddUint64(&f.dcpStats.TotDCPCreateCollections, 1)
 }
@@ -374,7 +446,7 @@ func (f *GocbcoreDCPFeed) SeqNoAdvanced(s gocbcore.DcpSeqNoAdvanced) {
 		return
 	}
 
-	f.lastReceivedSeqno[s.VbID] = s.SeqNo
+	atomic.StoreUint64(&f.lastReceivedSeqno[s.VbID], s.SeqNo)
 
 	atomic.AddUint64(&f.dcpStats.TotDCPSeqNoAdvanceds, 1)
 }
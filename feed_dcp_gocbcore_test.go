@@ -0,0 +1,74 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/gocbcore/v10"
+)
+
+func TestFeedScopeCollections(t *testing.T) {
+	tests := []struct {
+		scope       string
+		collections []string
+		expScope    string
+		expColls    []string
+	}{
+		{"", nil, "_default", []string{"_default"}},
+		{"s0", nil, "s0", nil},
+		{"", []string{"c0"}, "_default", []string{"c0"}},
+		{"s0", []string{"c0", "c1", "c0"}, "s0", []string{"c0", "c1"}},
+	}
+	for i, test := range tests {
+		scope, colls := feedScopeCollections(test.scope, test.collections)
+		if scope != test.expScope || !reflect.DeepEqual(colls, test.expColls) {
+			t.Errorf("test: %d, got scope: %s, collections: %v",
+				i, scope, colls)
+		}
+	}
+}
+
+func TestCollectionsStreamFilter(t *testing.T) {
+	manifest := &gocbcore.Manifest{
+		UID: 7,
+		Scopes: []gocbcore.ManifestScope{
+			{UID: 0, Name: "_default", Collections: []gocbcore.ManifestCollection{
+				{UID: 0, Name: "_default"},
+			}},
+			{UID: 8, Name: "s0", Collections: []gocbcore.ManifestCollection{
+				{UID: 9, Name: "c0"},
+				{UID: 10, Name: "c1"},
+			}},
+		},
+	}
+
+	filter, ids, err := collectionsStreamFilter(manifest, 8, "s0", nil)
+	if err != nil || filter.ScopeID != 8 || filter.CollectionIDs != nil ||
+		ids != nil {
+		t.Fatalf("expected a scope filter, got: %+v, ids: %v, err: %v",
+			filter, ids, err)
+	}
+
+	filter, ids, err = collectionsStreamFilter(manifest, 8, "s0",
+		[]string{"c1", "c0"})
+	if err != nil || !reflect.DeepEqual(filter.CollectionIDs, []uint32{10, 9}) ||
+		!reflect.DeepEqual(ids, []uint32{10, 9}) {
+		t.Fatalf("expected a collections filter, got: %+v, ids: %v, err: %v",
+			filter, ids, err)
+	}
+
+	_, _, err = collectionsStreamFilter(manifest, 0, "_default",
+		[]string{"c0"})
+	if !errors.Is(err, gocbcore.ErrCollectionNotFound) {
+		t.Fatalf("expected a collection not found err, got: %v", err)
+	}
+}
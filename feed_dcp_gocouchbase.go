@@ -151,6 +151,7 @@ type DCPFeed struct {
 	disable    bool
 	stopAfter  map[string]UUIDSeq // May be nil.
 	bds        cbdatasource.BucketDataSource
+	phases     *BackfillPhases
 	mgr        *Manager
 	auth       couchbase.AuthHandler
 
@@ -272,6 +273,7 @@ func NewDCPFeed(name, indexName, url, poolName,
 		mgr:        mgr,
 		auth:       auth,
 		stats:      NewDestStats(),
+		phases:     NewBackfillPhases(),
 	}
 
 	feed.bds, err = cbdatasource.NewBucketDataSource(
@@ -318,6 +320,11 @@ func (t *DCPFeed) Dests() map[string]Dest {
 	return t.dests
 }
 
+// BackfillingPartitions implements the FeedBackfills interface.
+func (t *DCPFeed) BackfillingPartitions() []string {
+	return t.phases.Partitions()
+}
+
 var prefixBucketDataSourceStats = []byte(`{"bucketDataSourceStats":`)
 var prefixDestStats = []byte(`,"destStats":`)
 
@@ -410,14 +417,42 @@ func (r *DCPFeed) DataUpdate(vbucketId uint16, key []byte, seq uint64,
 			return err
 		}
 
-		if destEx, ok := dest.(DestEx); ok {
-			err = destEx.DataUpdateEx(partition, key, seq, req.Body,
-				req.Cas, DEST_EXTRAS_TYPE_MCREQUEST, req)
+		if diskQuotaRejectsMutation(dest) {
+			r.updateStopAfter(partition, seq)
+			return nil // See DiskQuotaActionRejectMutations.
+		}
+
+		waitFeedBackpressure(dest, nil)
+
+		unlock := lockDeadLetterKey(dest, partition, key)
+
+		val, transformErr := transformMutation(dest, partition, key, req.Body)
+		if transformErr != nil { // See DocTransformer.
+			err = r.dataDelete(dest, partition, key, seq, req)
+			sampleMutation(dest, partition, key, seq, nil, req.Cas,
+				true, err)
+			err = transformFailedMutation(dest, partition, key, seq,
+				req.Body, false, req.Cas, DEST_EXTRAS_TYPE_MCREQUEST, req,
+				transformErr, err)
 		} else {
-			err = dest.DataUpdate(partition, key, seq, req.Body,
-				req.Cas, DEST_EXTRAS_TYPE_DCP, req.Extras)
+			req.Body = val
+
+			if destEx, ok := dest.(DestEx); ok {
+				err = destEx.DataUpdateEx(partition, key, seq, req.Body,
+					req.Cas, DEST_EXTRAS_TYPE_MCREQUEST, req)
+			} else {
+				err = dest.DataUpdate(partition, key, seq, req.Body,
+					req.Cas, DEST_EXTRAS_TYPE_DCP, req.Extras)
+			}
+
+			sampleMutation(dest, partition, key, seq, req.Body, req.Cas,
+				false, err)
+			err = deadLetterMutation(dest, partition, key, seq, req.Body,
+				req.Cas, false, DEST_EXTRAS_TYPE_MCREQUEST, req, err)
 		}
 
+		unlock()
+
 		if err != nil {
 			return fmt.Errorf("feed_dcp_gocouchbase: DataUpdate,"+
 				" name: %s, partition: %s, key: %v, seq: %d, err: %v",
@@ -440,13 +475,18 @@ func (r *DCPFeed) DataDelete(vbucketId uint16, key []byte, seq uint64,
 			return err
 		}
 
-		if destEx, ok := dest.(DestEx); ok {
-			err = destEx.DataDeleteEx(partition, key, seq,
-				req.Cas, DEST_EXTRAS_TYPE_MCREQUEST, req)
-		} else {
-			err = dest.DataDelete(partition, key, seq,
-				req.Cas, DEST_EXTRAS_TYPE_DCP, req.Extras)
-		}
+		waitFeedBackpressure(dest, nil)
+
+		unlock := lockDeadLetterKey(dest, partition, key)
+
+		err = r.dataDelete(dest, partition, key, seq, req)
+
+		sampleMutation(dest, partition, key, seq, nil, req.Cas,
+			true, err)
+		err = deadLetterMutation(dest, partition, key, seq, nil, req.Cas,
+			true, DEST_EXTRAS_TYPE_MCREQUEST, req, err)
+
+		unlock()
 
 		if err != nil {
 			return fmt.Errorf("feed_dcp_gocouchbase: DataDelete,"+
@@ -461,6 +501,17 @@ func (r *DCPFeed) DataDelete(vbucketId uint16, key []byte, seq uint64,
 	}, r.stats.TimerDataDelete)
 }
 
+// dataDelete deletes a key from the dest, with the extras of the req.
+func (r *DCPFeed) dataDelete(dest Dest, partition string, key []byte,
+	seq uint64, req *gomemcached.MCRequest) error {
+	if destEx, ok := dest.(DestEx); ok {
+		return destEx.DataDeleteEx(partition, key, seq,
+			req.Cas, DEST_EXTRAS_TYPE_MCREQUEST, req)
+	}
+	return dest.DataDelete(partition, key, seq,
+		req.Cas, DEST_EXTRAS_TYPE_DCP, req.Extras)
+}
+
 func (r *DCPFeed) SnapshotStart(vbucketId uint16,
 	snapStart, snapEnd uint64, snapType uint32) error {
 	return Timer(func() error {
@@ -478,6 +529,11 @@ func (r *DCPFeed) SnapshotStart(vbucketId uint16,
 			}
 		}
 
+		err = r.phases.Snapshot(partition, dest, snapType)
+		if err != nil {
+			return err
+		}
+
 		return dest.SnapshotStart(partition, snapStart, snapEnd)
 	}, r.stats.TimerSnapshotStart)
 }
@@ -16,6 +16,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -63,8 +64,13 @@ func init() {
 // poor-man's approach instead of properly tracking sequence numbers.
 // That has implications such as whenever a FilesFeed (re-)starts
 // (e.g., the process restarts), the FilesFeed will re-emits all files
-// and then track the max modification timestamp going forwards as it
-// regularly polls for file changes.
+// and then track the changed files going forwards as it regularly
+// polls, emitting deletions for the files that have disappeared.
+// Files deleted while the FilesFeed isn't running aren't deleted.
+//
+// The documents are keyed by their path relative to the source name,
+// which is also hashed for the partition of a document, so that the
+// partition assignments are stable across nodes and dataDirs.
 type FilesFeed struct {
 	mgr        *Manager
 	name       string
@@ -206,7 +212,10 @@ func (t *FilesFeed) Start() error {
 			seqs[partition] = uint64(initTimeMicroSecs)
 		}
 
-		var prevStartTime time.Time
+		// The previously emitted files of the local partitions, keyed
+		// by path relative to the source name, to detect the changed
+		// and the deleted files.
+		known := map[string]*FileState{}
 
 		ExponentialBackoffLoop(t.Name(),
 			func() int {
@@ -222,24 +231,21 @@ func (t *FilesFeed) Start() error {
 
 				h := crc32.NewIEEE()
 
-				startTime := time.Now()
-
-				progress := false
-
-				paths, err := FilesFindMatches(t.mgr.DataDir(),
-					t.sourceName, t.params.RegExps, prevStartTime,
-					t.params.MaxFileSize)
+				files, err := FilesScan(t.mgr.DataDir(), t.sourceName,
+					t.params.RegExps, t.params.MaxFileSize)
 				if err != nil {
-					log.Warnf("feed_files, FilesFindMatches, err: %v", err)
+					log.Warnf("feed_files, FilesScan, err: %v", err)
 					return -1
 				}
 
+				changes := FilesChanges(known, files)
+
 				seqDeltaMax := uint64(0)
 
 				seqEnds := map[string]uint64{}
 
-				for _, path := range paths {
-					partition := FilesPathToPartition(h, partitions, path)
+				for _, relPath := range changes {
+					partition := FilesPathToPartition(h, partitions, relPath)
 
 					if t.dests[partition] == nil {
 						continue
@@ -262,14 +268,16 @@ func (t *FilesFeed) Start() error {
 
 				snapshotSent := map[string]bool{}
 
-				for _, path := range paths {
+				progress := false
+
+				for _, relPath := range changes {
 					select {
 					case <-closeCh:
 						return -1
 					default:
 					}
 
-					partition := FilesPathToPartition(h, partitions, path)
+					partition := FilesPathToPartition(h, partitions, relPath)
 
 					dest := t.dests[partition]
 					if dest == nil {
@@ -279,24 +287,29 @@ func (t *FilesFeed) Start() error {
 					seqCur := seqs[partition]
 					seqs[partition] = seqCur + 1
 
-					buf, err := os.ReadFile(path)
-					if err != nil {
-						log.Warnf("feed_files: read file,"+
-							" name: %s, path: %s, err: %v",
-							t.Name(), path, err)
-						continue
-					}
+					file := files[relPath]
 
-					jbuf, err := MarshalJSON(FileDoc{
-						Name:     filepath.Base(path),
-						Path:     path,
-						Contents: string(buf),
-					})
-					if err != nil {
-						log.Warnf("feed_files: json marshal file,"+
-							" name: %s, path: %s, err: %v",
-							t.Name(), path, err)
-						continue
+					var jbuf []byte
+					if file != nil {
+						buf, err := os.ReadFile(file.Path)
+						if err != nil {
+							log.Warnf("feed_files: read file,"+
+								" name: %s, path: %s, err: %v",
+								t.Name(), relPath, err)
+							continue
+						}
+
+						jbuf, err = MarshalJSON(FileDoc{
+							Name:     filepath.Base(relPath),
+							Path:     relPath,
+							Contents: string(buf),
+						})
+						if err != nil {
+							log.Warnf("feed_files: json marshal file,"+
+								" name: %s, path: %s, err: %v",
+								t.Name(), relPath, err)
+							continue
+						}
 					}
 
 					if !snapshotSent[partition] {
@@ -313,23 +326,64 @@ func (t *FilesFeed) Start() error {
 						snapshotSent[partition] = true
 					}
 
-					pathBuf := []byte(path)
+					key := []byte(relPath)
+
+					if file == nil {
+						unlock := lockDeadLetterKey(dest, partition, key)
+						err = dest.DataDelete(partition, key, seqCur,
+							0, DEST_EXTRAS_TYPE_NIL, nil)
+						sampleMutation(dest, partition, key, seqCur,
+							nil, 0, true, err)
+						err = deadLetterMutation(dest, partition, key, seqCur,
+							nil, 0, true, DEST_EXTRAS_TYPE_NIL, nil, err)
+						unlock()
+						if err != nil {
+							log.Warnf("feed_files: DataDelete,"+
+								" name: %s, path: %s, partition: %s,"+
+								" seqCur: %d, err: %v", t.Name(), relPath,
+								partition, seqCur, err)
+							return -1
+						}
 
-					err = dest.DataUpdate(partition, pathBuf, seqCur,
-						jbuf, 0, DEST_EXTRAS_TYPE_NIL, nil)
-					if err != nil {
-						log.Warnf("feed_files: DataUpdate,"+
-							" name: %s, path: %s, partition: %s,"+
-							" seqCur: %d, err: %v", t.Name(), path,
-							partition, seqCur, err)
-						return -1
+						delete(known, relPath)
+					} else {
+						if !diskQuotaRejectsMutation(dest) {
+							unlock := lockDeadLetterKey(dest, partition, key)
+							val, transformErr := transformMutation(dest,
+								partition, key, jbuf)
+							if transformErr != nil { // See DocTransformer.
+								err = dest.DataDelete(partition, key, seqCur,
+									0, DEST_EXTRAS_TYPE_NIL, nil)
+								sampleMutation(dest, partition, key, seqCur,
+									nil, 0, true, err)
+								err = transformFailedMutation(dest, partition,
+									key, seqCur, jbuf, false, 0,
+									DEST_EXTRAS_TYPE_NIL, nil, transformErr, err)
+							} else {
+								err = dest.DataUpdate(partition, key, seqCur,
+									val, 0, DEST_EXTRAS_TYPE_NIL, nil)
+								sampleMutation(dest, partition, key, seqCur,
+									val, 0, false, err)
+								err = deadLetterMutation(dest, partition, key,
+									seqCur, val, 0, false, DEST_EXTRAS_TYPE_NIL,
+									nil, err)
+							}
+							unlock()
+							if err != nil {
+								log.Warnf("feed_files: DataUpdate,"+
+									" name: %s, path: %s, partition: %s,"+
+									" seqCur: %d, err: %v", t.Name(), relPath,
+									partition, seqCur, err)
+								return -1
+							}
+						}
+
+						known[relPath] = file
 					}
 
 					progress = true
 				}
 
-				prevStartTime = startTime
-
 				// NOTE: We may need to sleep a certain amount in case
 				// there were tons of file updates/mutations, and we
 				// want to reduce the window of potentially repeating
@@ -402,6 +456,64 @@ func FilesFeedPartitions(sourceType, sourceName, sourceUUID, sourceParams,
 
 // -----------------------------------------------------
 
+// A FileState is a file found by FilesScan.
+type FileState struct {
+	Path    string // The full path.
+	ModTime time.Time
+	Size    int64
+}
+
+// FilesScan finds the leaf files in a subdirectory tree like
+// FilesFindMatches, keyed by their slash-separated paths relative to
+// the source name.
+func FilesScan(dataDir, sourceName string,
+	regExps []string, maxSize int64) (map[string]*FileState, error) {
+	rv := map[string]*FileState{}
+
+	walkPath, err := filesWalkMatches(dataDir, sourceName, regExps,
+		maxSize, func(path string, fi os.FileInfo) {
+			rv[path] = &FileState{
+				Path:    path,
+				ModTime: fi.ModTime(),
+				Size:    fi.Size(),
+			}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	for path, file := range rv {
+		relPath, err := filepath.Rel(walkPath, path)
+		if err != nil {
+			return nil, err
+		}
+		delete(rv, path)
+		rv[filepath.ToSlash(relPath)] = file
+	}
+
+	return rv, nil
+}
+
+// FilesChanges returns the sorted relative paths of the files that
+// were created, modified or deleted, compared to the known files.
+func FilesChanges(known, files map[string]*FileState) []string {
+	var rv []string
+	for relPath, file := range files {
+		prev := known[relPath]
+		if prev == nil || !prev.ModTime.Equal(file.ModTime) ||
+			prev.Size != file.Size {
+			rv = append(rv, relPath)
+		}
+	}
+	for relPath := range known {
+		if files[relPath] == nil {
+			rv = append(rv, relPath)
+		}
+	}
+	sort.Strings(rv)
+	return rv
+}
+
 // FilesFindMatches finds all leaf file paths in a subdirectory tree
 // that match any in an optional array of regExps (regular expression
 // strings).  If regExps is nil, though, then all leaf file paths are
@@ -414,26 +526,44 @@ func FilesFeedPartitions(sourceType, sourceName, sourceUUID, sourceParams,
 func FilesFindMatches(dataDir, sourceName string,
 	regExps []string, modTimeGTE time.Time, maxSize int64) (
 	[]string, error) {
+	var pathsOk []string
+
+	_, err := filesWalkMatches(dataDir, sourceName, regExps, maxSize,
+		func(path string, fi os.FileInfo) {
+			if !fi.ModTime().Before(modTimeGTE) {
+				pathsOk = append(pathsOk, path)
+			}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return pathsOk, nil
+}
+
+// filesWalkMatches invokes the visit callback for the leaf files of a
+// source name's subdirectory tree that match the regExps and maxSize,
+// and returns the walked path.
+func filesWalkMatches(dataDir, sourceName string,
+	regExps []string, maxSize int64,
+	visit func(path string, fi os.FileInfo)) (string, error) {
 	walkPath, err := filepath.EvalSymlinks(dataDir +
 		string(os.PathSeparator) + "files" +
 		string(os.PathSeparator) + sourceName)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	pathsOk := []string(nil)
-
 	err = filepath.Walk(walkPath,
 		func(path string, fi os.FileInfo, err error) error {
 			if err != nil ||
 				fi.IsDir() ||
-				fi.ModTime().Before(modTimeGTE) ||
 				(maxSize > 0 && fi.Size() > maxSize) {
 				return nil
 			}
 
 			if len(regExps) <= 0 {
-				pathsOk = append(pathsOk, path)
+				visit(path, fi)
 				return nil
 			}
 
@@ -445,7 +575,7 @@ func FilesFindMatches(dataDir, sourceName string,
 						reStr, path, err)
 				}
 				if matched {
-					pathsOk = append(pathsOk, path)
+					visit(path, fi)
 					return nil
 				}
 			}
@@ -453,10 +583,10 @@ func FilesFindMatches(dataDir, sourceName string,
 			return nil
 		})
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	return pathsOk, nil
+	return walkPath, nil
 }
 
 // FilesPathToPartition hashes a file path to a partition.
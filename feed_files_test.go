@@ -12,6 +12,7 @@ import (
 	"bytes"
 	"hash/crc32"
 	"os"
+	"reflect"
 	"sort"
 	"testing"
 	"time"
@@ -164,6 +165,53 @@ func TestFilesFindMatches(t *testing.T) {
 	}
 }
 
+func TestFilesScanChanges(t *testing.T) {
+	testDir, _ := os.MkdirTemp("tmp", "test")
+	defer os.RemoveAll(testDir)
+	dir := testDir + string(os.PathSeparator) + "files" +
+		string(os.PathSeparator) + "foo" + string(os.PathSeparator) + "sub"
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatalf("mkdirall error")
+	}
+
+	hiPath := dir + string(os.PathSeparator) + "hi.txt"
+	byePath := dir + string(os.PathSeparator) + "bye.txt"
+	os.WriteFile(hiPath, []byte("hello world"), 0600)
+	os.WriteFile(byePath, []byte("goodbye world"), 0600)
+
+	files, err := FilesScan(testDir, "foo", nil, 0)
+	if err != nil || len(files) != 2 ||
+		files["sub/hi.txt"] == nil || files["sub/hi.txt"].Path != hiPath {
+		t.Fatalf("unexpected files: %v, err: %v", files, err)
+	}
+
+	known := map[string]*FileState{}
+	changes := FilesChanges(known, files)
+	if !reflect.DeepEqual(changes, []string{"sub/bye.txt", "sub/hi.txt"}) {
+		t.Fatalf("expected created files, got: %v", changes)
+	}
+	for relPath, file := range files {
+		known[relPath] = file
+	}
+
+	if changes = FilesChanges(known, files); len(changes) != 0 {
+		t.Fatalf("expected no changes, got: %v", changes)
+	}
+
+	os.WriteFile(hiPath, []byte("hello again world"), 0600)
+	os.Remove(byePath)
+
+	files, err = FilesScan(testDir, "foo", nil, 0)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	changes = FilesChanges(known, files)
+	if !reflect.DeepEqual(changes, []string{"sub/bye.txt", "sub/hi.txt"}) ||
+		files["sub/bye.txt"] != nil {
+		t.Fatalf("expected an updated and a deleted file, got: %v", changes)
+	}
+}
+
 func TestFilesFeedPartitions(t *testing.T) {
 	sourceType := ""
 	sourceName := ""
@@ -0,0 +1,416 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// SOURCE_HTTP is the source type of the feeds whose mutations are
+// pushed by external systems to a REST endpoint, instead of being
+// streamed from a data source via DCP.
+const SOURCE_HTTP = "http"
+
+// HTTPFeedTokenHeader is the request header that carries the
+// HTTPSourceParams.AuthToken of an index, when the index has one.
+const HTTPFeedTokenHeader = "X-Cbgt-Feed-Token"
+
+// ErrHTTPFeedUnauthorized is returned when a pushed batch doesn't
+// carry the auth token of the index.
+var ErrHTTPFeedUnauthorized = errors.New("feed_http: unauthorized")
+
+// ErrHTTPFeedClosed is returned when a batch is pushed to a closed or
+// disabled feed.
+var ErrHTTPFeedClosed = errors.New("feed_http: feed closed")
+
+func init() {
+	RegisterFeedType(SOURCE_HTTP, &FeedType{
+		Start:           StartHTTPFeed,
+		Partitions:      HTTPFeedPartitions,
+		PartitionLookUp: HTTPFeedPartitionLookUp,
+		Public:          true,
+		Description: "general/" + SOURCE_HTTP +
+			" - documents pushed in batches to the index's REST endpoint",
+		StartSample: &HTTPSourceParams{NumPartitions: 1},
+		StartSampleDocs: map[string]string{
+			"numPartitions": "The number of source partitions, where" +
+				" each document key is hashed to one of the partitions.",
+			"authToken": "Optional, when set the pushed batches must" +
+				" carry this token in the " + HTTPFeedTokenHeader +
+				" request header.",
+		},
+	})
+}
+
+// HTTPSourceParams represents the JSON expected as the sourceParams
+// for an HTTPFeed.
+type HTTPSourceParams struct {
+	NumPartitions int    `json:"numPartitions"`
+	AuthToken     string `json:"authToken,omitempty"`
+}
+
+// An HTTPMutation is a document mutation, or a deletion, of a batch
+// that's pushed to an HTTPFeed.  The seqs of the mutations of a
+// partition are expected to increase, where the mutations whose seqs
+// were already applied are skipped, so that a batch can be retried.
+type HTTPMutation struct {
+	Key    string          `json:"key"`
+	Seq    uint64          `json:"seq"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Delete bool            `json:"delete,omitempty"`
+}
+
+// An HTTPMutationBatch is the JSON of a batch of mutations that's
+// pushed to an HTTPFeed.
+type HTTPMutationBatch struct {
+	Mutations []HTTPMutation `json:"mutations"`
+}
+
+// An HTTPMutationBatchResult reports how a pushed batch was applied.
+type HTTPMutationBatchResult struct {
+	Applied int `json:"applied"`
+	Skipped int `json:"skipped"` // Mutations whose seqs were already applied.
+
+	// Keys of the mutations whose partitions aren't on this node,
+	// which should be pushed to the nodes of the partitions instead,
+	// as reported by the index's pindexLookup endpoint.
+	NotLocal []string `json:"notLocal,omitempty"`
+}
+
+// HTTPFeed is a Feed interface implementation whose mutations are
+// pushed in batches by external systems, via the REST endpoint of the
+// index, instead of being streamed from a data source.  Each document
+// key is hashed to one of the HTTPSourceParams.NumPartitions source
+// partitions, and a batch is applied to the local partitions of the
+// feed.
+type HTTPFeed struct {
+	name      string
+	indexName string
+	params    *HTTPSourceParams
+	dests     map[string]Dest
+	disable   bool
+
+	m        sync.Mutex // Serializes the applying of the batches.
+	closed   bool
+	lastSeqs map[string]uint64 // Keyed by partition.
+
+	TotBatches       uint64
+	TotBatchErrs     uint64
+	TotMutations     uint64
+	TotSkipped       uint64
+	TotUnauthorized  uint64
+	TotNotLocalItems uint64
+}
+
+// StartHTTPFeed starts an HTTPFeed and is the callback function
+// registered at init/startup time.
+func StartHTTPFeed(mgr *Manager, feedName, indexName, indexUUID,
+	sourceType, sourceName, sourceUUID, params string,
+	dests map[string]Dest) error {
+	feed, err := NewHTTPFeed(feedName, indexName, params, dests,
+		mgr.tagsMap != nil && !mgr.tagsMap["feed"])
+	if err != nil {
+		return fmt.Errorf("feed_http: NewHTTPFeed,"+
+			" feedName: %s, err: %v", feedName, err)
+	}
+	err = feed.Start()
+	if err != nil {
+		return fmt.Errorf("feed_http: could not start,"+
+			" feedName: %s, err: %v", feedName, err)
+	}
+	err = mgr.registerFeed(feed)
+	if err != nil {
+		feed.Close()
+		return err
+	}
+	return nil
+}
+
+// NewHTTPFeed creates a ready-to-be-started HTTPFeed.
+func NewHTTPFeed(name, indexName, paramsStr string,
+	dests map[string]Dest, disable bool) (*HTTPFeed, error) {
+	params, err := parseHTTPSourceParams(paramsStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HTTPFeed{
+		name:      name,
+		indexName: indexName,
+		params:    params,
+		dests:     dests,
+		disable:   disable,
+		lastSeqs:  map[string]uint64{},
+	}, nil
+}
+
+func parseHTTPSourceParams(sourceParams string) (*HTTPSourceParams, error) {
+	params := &HTTPSourceParams{}
+	if sourceParams != "" {
+		err := UnmarshalJSON([]byte(sourceParams), params)
+		if err != nil {
+			return nil, fmt.Errorf("feed_http: could not parse"+
+				" sourceParams: %s, err: %v", sourceParams, err)
+		}
+	}
+	if params.NumPartitions <= 0 {
+		params.NumPartitions = 1
+	}
+	return params, nil
+}
+
+func (t *HTTPFeed) Name() string {
+	return t.name
+}
+
+func (t *HTTPFeed) IndexName() string {
+	return t.indexName
+}
+
+func (t *HTTPFeed) Start() error {
+	return nil
+}
+
+func (t *HTTPFeed) Close() error {
+	t.m.Lock()
+	t.closed = true
+	t.m.Unlock()
+
+	return nil
+}
+
+func (t *HTTPFeed) Dests() map[string]Dest {
+	return t.dests
+}
+
+func (t *HTTPFeed) Stats(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `{"TotBatches":%d,"TotBatchErrs":%d,`+
+		`"TotMutations":%d,"TotSkipped":%d,"TotUnauthorized":%d,`+
+		`"TotNotLocalItems":%d}`,
+		atomic.LoadUint64(&t.TotBatches),
+		atomic.LoadUint64(&t.TotBatchErrs),
+		atomic.LoadUint64(&t.TotMutations),
+		atomic.LoadUint64(&t.TotSkipped),
+		atomic.LoadUint64(&t.TotUnauthorized),
+		atomic.LoadUint64(&t.TotNotLocalItems))
+	return err
+}
+
+// Authorize checks the token of a pushed batch against the auth token
+// of the feed's index, if any.
+func (t *HTTPFeed) Authorize(token string) error {
+	if t.params.AuthToken == "" ||
+		subtle.ConstantTimeCompare([]byte(token),
+			[]byte(t.params.AuthToken)) == 1 {
+		return nil
+	}
+	atomic.AddUint64(&t.TotUnauthorized, 1)
+	return ErrHTTPFeedUnauthorized
+}
+
+// Apply applies a pushed batch of mutations to the feed's local
+// partitions.  A batch's mutations of a partition are applied as one
+// snapshot, where the mutations whose seqs don't exceed the last
+// applied seq of the partition are skipped.
+func (t *HTTPFeed) Apply(batch *HTTPMutationBatch) (
+	*HTTPMutationBatchResult, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.closed || t.disable {
+		return nil, ErrHTTPFeedClosed
+	}
+
+	atomic.AddUint64(&t.TotBatches, 1)
+
+	rv := &HTTPMutationBatchResult{}
+
+	var partitions []string
+	byPartition := map[string][]*HTTPMutation{}
+
+	for i := range batch.Mutations {
+		m := &batch.Mutations[i]
+		if m.Key == "" {
+			atomic.AddUint64(&t.TotBatchErrs, 1)
+			return nil, fmt.Errorf("feed_http: mutation: %d, missing key", i)
+		}
+
+		partition := HTTPFeedPartition(m.Key, t.params.NumPartitions)
+		if _, exists := t.dests[partition]; !exists {
+			rv.NotLocal = append(rv.NotLocal, m.Key)
+			continue
+		}
+
+		if byPartition[partition] == nil {
+			partitions = append(partitions, partition)
+		}
+		byPartition[partition] = append(byPartition[partition], m)
+	}
+
+	atomic.AddUint64(&t.TotNotLocalItems, uint64(len(rv.NotLocal)))
+
+	for _, partition := range partitions {
+		applied, skipped, err := t.applyPartition(partition,
+			byPartition[partition])
+		rv.Applied += applied
+		rv.Skipped += skipped
+		if err != nil {
+			atomic.AddUint64(&t.TotBatchErrs, 1)
+			return rv, err
+		}
+	}
+
+	atomic.AddUint64(&t.TotMutations, uint64(rv.Applied))
+	atomic.AddUint64(&t.TotSkipped, uint64(rv.Skipped))
+
+	return rv, nil
+}
+
+func (t *HTTPFeed) applyPartition(partition string,
+	mutations []*HTTPMutation) (applied, skipped int, err error) {
+	dest := t.dests[partition]
+
+	lastSeq, exists := t.lastSeqs[partition]
+	if !exists {
+		_, lastSeq, err = dest.OpaqueGet(partition)
+		if err != nil {
+			return 0, 0, fmt.Errorf("feed_http: OpaqueGet,"+
+				" partition: %s, err: %v", partition, err)
+		}
+	}
+
+	var snapStart, snapEnd uint64
+	for _, m := range mutations {
+		if m.Seq <= lastSeq {
+			continue
+		}
+		if snapStart == 0 {
+			snapStart = m.Seq
+		}
+		if m.Seq > snapEnd {
+			snapEnd = m.Seq
+		}
+	}
+
+	if snapEnd > 0 {
+		err = dest.SnapshotStart(partition, snapStart, snapEnd)
+		if err != nil {
+			return 0, 0, fmt.Errorf("feed_http: SnapshotStart,"+
+				" partition: %s, err: %v", partition, err)
+		}
+	}
+
+	for _, m := range mutations {
+		if m.Seq <= lastSeq {
+			skipped++
+			continue
+		}
+
+		key := []byte(m.Key)
+
+		unlock := lockDeadLetterKey(dest, partition, key)
+
+		if m.Delete {
+			err = dest.DataDelete(partition, key, m.Seq, 0,
+				DEST_EXTRAS_TYPE_NIL, nil)
+			sampleMutation(dest, partition, key, m.Seq, nil, 0, true, err)
+			err = deadLetterMutation(dest, partition, key, m.Seq, nil, 0,
+				true, DEST_EXTRAS_TYPE_NIL, nil, err)
+		} else if !diskQuotaRejectsMutation(dest) {
+			val, transformErr := transformMutation(dest, partition, key,
+				m.Value)
+			if transformErr != nil { // See DocTransformer.
+				err = dest.DataDelete(partition, key, m.Seq, 0,
+					DEST_EXTRAS_TYPE_NIL, nil)
+				sampleMutation(dest, partition, key, m.Seq, nil, 0, true, err)
+				err = transformFailedMutation(dest, partition, key, m.Seq,
+					m.Value, false, 0, DEST_EXTRAS_TYPE_NIL, nil, transformErr, err)
+			} else {
+				err = dest.DataUpdate(partition, key, m.Seq, val, 0,
+					DEST_EXTRAS_TYPE_NIL, nil)
+				sampleMutation(dest, partition, key, m.Seq, val, 0, false, err)
+				err = deadLetterMutation(dest, partition, key, m.Seq, val, 0,
+					false, DEST_EXTRAS_TYPE_NIL, nil, err)
+			}
+		}
+
+		unlock()
+
+		if err != nil {
+			return applied, skipped, fmt.Errorf("feed_http: partition: %s,"+
+				" seq: %d, err: %v", partition, m.Seq, err)
+		}
+
+		lastSeq = m.Seq
+		t.lastSeqs[partition] = lastSeq
+		applied++
+	}
+
+	t.lastSeqs[partition] = lastSeq
+
+	return applied, skipped, nil
+}
+
+// -----------------------------------------------------
+
+// HTTPFeedPartition returns the source partition of a document key.
+func HTTPFeedPartition(key string, numPartitions int) string {
+	if numPartitions <= 1 {
+		return "0"
+	}
+	return strconv.Itoa(int(crc32.ChecksumIEEE([]byte(key)) %
+		uint32(numPartitions)))
+}
+
+// HTTPFeedPartitions returns the partitions, controlled by
+// HTTPSourceParams.NumPartitions, for an HTTPFeed instance.
+func HTTPFeedPartitions(sourceType, sourceName, sourceUUID, sourceParams,
+	server string, options map[string]string) ([]string, error) {
+	params, err := parseHTTPSourceParams(sourceParams)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]string, params.NumPartitions)
+	for i := range rv {
+		rv[i] = strconv.Itoa(i)
+	}
+	return rv, nil
+}
+
+// HTTPFeedPartitionLookUp returns the source partition of a document
+// id, for the pindexLookup of the pushed documents.
+func HTTPFeedPartitionLookUp(docID, server string, sourceDetails *IndexDef,
+	req *http.Request) (string, error) {
+	params, err := parseHTTPSourceParams(sourceDetails.SourceParams)
+	if err != nil {
+		return "", err
+	}
+	return HTTPFeedPartition(docID, params.NumPartitions), nil
+}
+
+// HTTPFeedForIndex returns this node's HTTPFeed of an index, or nil.
+func (mgr *Manager) HTTPFeedForIndex(indexName string) *HTTPFeed {
+	feeds, _ := mgr.CurrentMaps()
+	for _, feed := range feeds {
+		if httpFeed, ok := feed.(*HTTPFeed); ok &&
+			httpFeed.IndexName() == indexName {
+			return httpFeed
+		}
+	}
+	return nil
+}
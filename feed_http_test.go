@@ -0,0 +1,129 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testHTTPFeedDest struct {
+	TestDest
+	lastSeq uint64
+	events  []string
+}
+
+func (d *testHTTPFeedDest) OpaqueGet(partition string) (
+	[]byte, uint64, error) {
+	return nil, d.lastSeq, nil
+}
+
+func (d *testHTTPFeedDest) SnapshotStart(partition string,
+	snapStart, snapEnd uint64) error {
+	d.events = append(d.events, "snapshot")
+	return nil
+}
+
+func (d *testHTTPFeedDest) DataUpdate(partition string,
+	key []byte, seq uint64, val []byte, cas uint64,
+	extrasType DestExtrasType, extras []byte) error {
+	d.events = append(d.events, "update:"+string(key)+"="+string(val))
+	return nil
+}
+
+func (d *testHTTPFeedDest) DataDelete(partition string,
+	key []byte, seq uint64, cas uint64,
+	extrasType DestExtrasType, extras []byte) error {
+	d.events = append(d.events, "delete:"+string(key))
+	return nil
+}
+
+func TestHTTPFeedPartitions(t *testing.T) {
+	partitions, err := HTTPFeedPartitions(SOURCE_HTTP, "", "",
+		`{"numPartitions":3}`, "", nil)
+	if err != nil || !reflect.DeepEqual(partitions, []string{"0", "1", "2"}) {
+		t.Fatalf("unexpected partitions: %v, err: %v", partitions, err)
+	}
+
+	partitions, err = HTTPFeedPartitions(SOURCE_HTTP, "", "", "", "", nil)
+	if err != nil || !reflect.DeepEqual(partitions, []string{"0"}) {
+		t.Fatalf("expected a single partition, got: %v, err: %v",
+			partitions, err)
+	}
+
+	partition, err := HTTPFeedPartitionLookUp("k0", "",
+		&IndexDef{SourceParams: `{"numPartitions":3}`}, nil)
+	if err != nil || partition != HTTPFeedPartition("k0", 3) {
+		t.Fatalf("unexpected partition: %s, err: %v", partition, err)
+	}
+}
+
+func TestHTTPFeedApply(t *testing.T) {
+	// Find keys of both partitions, where only partition "0" is local.
+	var localKey, otherKey string
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if HTTPFeedPartition(key, 2) == "0" {
+			localKey = key
+		} else {
+			otherKey = key
+		}
+	}
+
+	dest := &testHTTPFeedDest{lastSeq: 1}
+	feed, err := NewHTTPFeed("f", "i", `{"numPartitions":2,"authToken":"s"}`,
+		map[string]Dest{"0": dest}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if !errors.Is(feed.Authorize("bad"), ErrHTTPFeedUnauthorized) ||
+		feed.Authorize("s") != nil {
+		t.Fatalf("expected only the auth token to be authorized")
+	}
+
+	batch := &HTTPMutationBatch{Mutations: []HTTPMutation{
+		{Key: localKey, Seq: 1, Value: []byte(`"old"`)}, // Already applied.
+		{Key: localKey, Seq: 2, Value: []byte(`"new"`)},
+		{Key: otherKey, Seq: 3, Value: []byte(`"x"`)},
+		{Key: localKey, Seq: 4, Delete: true},
+	}}
+
+	result, err := feed.Apply(batch)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result.Applied != 2 || result.Skipped != 1 ||
+		!reflect.DeepEqual(result.NotLocal, []string{otherKey}) {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+
+	exp := []string{"snapshot", "update:" + localKey + `="new"`,
+		"delete:" + localKey}
+	if !reflect.DeepEqual(dest.events, exp) {
+		t.Fatalf("expected: %v, got: %v", exp, dest.events)
+	}
+
+	// A retried batch is skipped.
+	result, err = feed.Apply(batch)
+	if err != nil || result.Applied != 0 || result.Skipped != 3 {
+		t.Fatalf("expected a skipped batch, got: %#v, err: %v", result, err)
+	}
+
+	if _, err = feed.Apply(&HTTPMutationBatch{
+		Mutations: []HTTPMutation{{Seq: 5}},
+	}); err == nil {
+		t.Fatalf("expected an err for a missing key")
+	}
+
+	feed.Close()
+	if _, err = feed.Apply(batch); !errors.Is(err, ErrHTTPFeedClosed) {
+		t.Fatalf("expected a closed feed, got: %v", err)
+	}
+}
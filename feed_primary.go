@@ -120,7 +120,22 @@ func (t *PrimaryFeed) DataUpdate(partition string,
 	if err != nil {
 		return fmt.Errorf("feed_primary: PrimaryFeed pf, err: %v", err)
 	}
-	return dest.DataUpdate(partition, key, seq, val, cas, extrasType, extras)
+	if diskQuotaRejectsMutation(dest) {
+		return nil // See DiskQuotaActionRejectMutations.
+	}
+	unlock := lockDeadLetterKey(dest, partition, key)
+	defer unlock()
+	valT, transformErr := transformMutation(dest, partition, key, val)
+	if transformErr != nil { // See DocTransformer.
+		err = dest.DataDelete(partition, key, seq, cas, extrasType, extras)
+		sampleMutation(dest, partition, key, seq, nil, cas, true, err)
+		return transformFailedMutation(dest, partition, key, seq, val,
+			false, cas, extrasType, extras, transformErr, err)
+	}
+	err = dest.DataUpdate(partition, key, seq, valT, cas, extrasType, extras)
+	sampleMutation(dest, partition, key, seq, valT, cas, false, err)
+	return deadLetterMutation(dest, partition, key, seq, valT, cas, false,
+		extrasType, extras, err)
 }
 
 func (t *PrimaryFeed) DataDelete(partition string,
@@ -131,7 +146,12 @@ func (t *PrimaryFeed) DataDelete(partition string,
 	if err != nil {
 		return fmt.Errorf("feed_primary: PrimaryFeed pf, err: %v", err)
 	}
-	return dest.DataDelete(partition, key, seq, cas, extrasType, extras)
+	unlock := lockDeadLetterKey(dest, partition, key)
+	defer unlock()
+	err = dest.DataDelete(partition, key, seq, cas, extrasType, extras)
+	sampleMutation(dest, partition, key, seq, nil, cas, true, err)
+	return deadLetterMutation(dest, partition, key, seq, nil, cas, true,
+		extrasType, extras, err)
 }
 
 func (t *PrimaryFeed) SnapshotStart(partition string,
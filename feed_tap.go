@@ -236,14 +236,39 @@ loop:
 				return 1, err
 			}
 
-			if req.Opcode == memcached.TapMutation {
+			if req.Opcode == memcached.TapMutation &&
+				!diskQuotaRejectsMutation(dest) {
 				// TODO: TAP feed, what about flags, expiration, etc?
-				err = dest.DataUpdate(partition, req.Key, 0, req.Value,
-					req.Cas, DEST_EXTRAS_TYPE_NIL, nil)
+				unlock := lockDeadLetterKey(dest, partition, req.Key)
+				val, transformErr := transformMutation(dest, partition,
+					req.Key, req.Value)
+				if transformErr != nil { // See DocTransformer.
+					err = dest.DataDelete(partition, req.Key, 0,
+						req.Cas, DEST_EXTRAS_TYPE_NIL, nil)
+					sampleMutation(dest, partition, req.Key, 0, nil,
+						req.Cas, true, err)
+					err = transformFailedMutation(dest, partition, req.Key,
+						0, req.Value, false, req.Cas, DEST_EXTRAS_TYPE_NIL,
+						nil, transformErr, err)
+				} else {
+					err = dest.DataUpdate(partition, req.Key, 0, val,
+						req.Cas, DEST_EXTRAS_TYPE_NIL, nil)
+					sampleMutation(dest, partition, req.Key, 0, val,
+						req.Cas, false, err)
+					err = deadLetterMutation(dest, partition, req.Key, 0,
+						val, req.Cas, false, DEST_EXTRAS_TYPE_NIL, nil, err)
+				}
+				unlock()
 			} else if req.Opcode == memcached.TapDeletion {
 				// TODO: TAP feed, what about flags, expiration, etc?
+				unlock := lockDeadLetterKey(dest, partition, req.Key)
 				err = dest.DataDelete(partition, req.Key, 0,
 					req.Cas, DEST_EXTRAS_TYPE_NIL, nil)
+				sampleMutation(dest, partition, req.Key, 0, nil,
+					req.Cas, true, err)
+				err = deadLetterMutation(dest, partition, req.Key, 0, nil,
+					req.Cas, true, DEST_EXTRAS_TYPE_NIL, nil, err)
+				unlock()
 			}
 			if err != nil {
 				return 1, err
@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type ErrorOnlyFeed struct {
@@ -568,3 +569,61 @@ func TestCouchbaseSourceVBucketLookUp(t *testing.T) {
 		}
 	}
 }
+
+func TestBackfillThrottle(t *testing.T) {
+	if NewBackfillThrottle(0, 0).Enabled() {
+		t.Errorf("expected a throttle with no max to be disabled")
+	}
+
+	bt := NewBackfillThrottle(1, 4)
+	for i := 0; i < 4; i++ {
+		if err := bt.Acquire(nil); err != nil {
+			t.Fatalf("expected acquire to work, err: %v", err)
+		}
+	}
+
+	closeCh := make(chan struct{})
+	close(closeCh)
+	if err := bt.Acquire(closeCh); err != ErrBackfillThrottleClosed {
+		t.Errorf("expected ErrBackfillThrottleClosed, got: %v", err)
+	}
+
+	acquiredCh := make(chan error)
+	go func() {
+		acquiredCh <- bt.Acquire(nil)
+	}()
+	bt.Release()
+	select {
+	case err := <-acquiredCh:
+		if err != nil {
+			t.Errorf("expected acquire after release to work, err: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a waiter to be woken up by a release")
+	}
+
+	// Backoffs observed by the feeds halve the limit.
+	bt.NoteBackoff()
+	bt.Adjust(nil, 0)
+	if bt.Limit() != 2 {
+		t.Errorf("expected limit 2, got: %d", bt.Limit())
+	}
+
+	// Latency beyond the budget halves the limit, down to the min.
+	for i := 0; i < 3; i++ {
+		bt.Adjust(&BackfillImpactSample{OpLatency: time.Second},
+			100*time.Millisecond)
+	}
+	if bt.Limit() != 1 {
+		t.Errorf("expected limit 1, got: %d", bt.Limit())
+	}
+
+	// No impact increases the limit, up to the max.
+	for i := 0; i < 10; i++ {
+		bt.Adjust(&BackfillImpactSample{OpLatency: time.Millisecond},
+			100*time.Millisecond)
+	}
+	if bt.Limit() != 4 {
+		t.Errorf("expected limit 4, got: %d", bt.Limit())
+	}
+}
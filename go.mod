@@ -13,7 +13,9 @@ require (
 	github.com/couchbase/tools-common/cloud v1.0.0
 	github.com/couchbase/tools-common/fs v1.0.0
 	github.com/elazarl/go-bindata-assetfs v1.0.0
+	github.com/golang/snappy v0.0.4
 	github.com/gorilla/mux v1.8.0
+	github.com/klauspost/compress v1.17.11
 	github.com/rcrowley/go-metrics v0.0.0-20201227073835-cf1acfcdf475
 	golang.org/x/net v0.17.0
 )
@@ -24,7 +26,6 @@ require (
 	github.com/couchbase/tools-common/types v1.0.0 // indirect
 	github.com/couchbase/tools-common/utils v1.0.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/golang/snappy v0.0.4 // indirect
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
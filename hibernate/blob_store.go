@@ -0,0 +1,63 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package hibernate
+
+import (
+	"context"
+
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+)
+
+// The S3 remote paths continue to be handled by the pre-existing hooks
+// (e.g., GetRemoteBucketAndPathHook), while the remote paths of the
+// schemes that the application registered (e.g., az:// and gs://) are
+// handled by their cbgt.BlobStore.
+func useBlobStore(remotePath string) bool {
+	scheme := cbgt.RemotePathScheme(remotePath)
+	if scheme == "" || scheme == cbgt.S3BlobStore.Scheme() {
+		return false
+	}
+	_, err := cbgt.BlobStoreForRemotePath(remotePath)
+	return err == nil
+}
+
+func getRemoteBucketAndPath(remotePath string) (string, string, error) {
+	if !useBlobStore(remotePath) {
+		return GetRemoteBucketAndPathHook(remotePath)
+	}
+	_, bucket, key, err := cbgt.ParseBlobStoreRemotePath(remotePath)
+	return bucket, key, err
+}
+
+func downloadMetadata(remotePath string, client objcli.Client,
+	ctx context.Context, bucket, key string) ([]byte, error) {
+	if !useBlobStore(remotePath) {
+		return DownloadMetadataHook(client, ctx, bucket, key)
+	}
+	return cbgt.BlobStoreGet(ctx, client, bucket, key)
+}
+
+func uploadMetadata(remotePath string, client objcli.Client,
+	ctx context.Context, bucket, key string, data []byte) error {
+	if !useBlobStore(remotePath) {
+		return UploadMetadataHook(client, ctx, bucket, key, data)
+	}
+	return cbgt.BlobStorePut(ctx, client, bucket, key, data)
+}
+
+// CheckIfRemotePathIsValid returns true if the remote path follows the
+// format expected by the object store of its scheme.
+func CheckIfRemotePathIsValid(remotePath string) bool {
+	if !useBlobStore(remotePath) {
+		return CheckIfRemotePathIsValidHook(remotePath)
+	}
+	_, _, _, err := cbgt.ParseBlobStoreRemotePath(remotePath)
+	return err == nil
+}
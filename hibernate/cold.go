@@ -0,0 +1,63 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package hibernate
+
+import (
+	"context"
+
+	"github.com/couchbase/cbgt"
+)
+
+// OpenColdBucket makes the hibernated indexes of a paused bucket
+// queryable in the cold query mode of the manager, without resuming
+// the bucket, by downloading only the index metadata from the remote
+// path, see cbgt.Manager.OpenColdIndexes().
+func OpenColdBucket(mgr *cbgt.Manager, bucket, remotePath,
+	region string) (*cbgt.IndexDefs, error) {
+	if mgr.GetOption(cbgt.HIBERNATION_COLD_QUERY_OPTION) != "true" {
+		return nil, cbgt.ErrHibernationColdQueryDisabled
+	}
+
+	// The cold reads are not rate limited, as a query waits for them.
+	client, err := mgr.NewHibernationClient(remotePath, region,
+		cbgt.NewTransferRateLimiter(0))
+	if err != nil {
+		return nil, err
+	}
+
+	remoteBucket, remoteKey, err := getRemoteBucketAndPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	_, indexDefsKey, _, err := getBucketAndMetadataPaths(remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := downloadMetadata(remotePath, client, context.Background(),
+		remoteBucket, indexDefsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	indexDefs := new(cbgt.IndexDefs)
+	err = cbgt.UnmarshalJSON(data, indexDefs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = mgr.OpenColdIndexes(bucket, remotePath, client, remoteBucket,
+		remoteKey, indexDefs)
+	if err != nil {
+		return nil, err
+	}
+
+	return indexDefs, nil
+}
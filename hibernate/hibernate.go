@@ -70,8 +70,31 @@ type HibernationProgress struct {
 
 	// Map of pindex -> transfer progress in range of 0 to 1.
 	TransferProgress map[string]float64
+
+	// Optional map of pindex -> node UUID -> bytes transferred, so
+	// that a slow pindex or node can be told apart.
+	PIndexNodeProgress PIndexNodeProgress
 }
 
+// TransferBytes is the progress of the transfer of a pindex by a node.
+type TransferBytes struct {
+	BytesDone  uint64 `json:"bytesDone"`
+	BytesTotal uint64 `json:"bytesTotal"`
+
+	// Progress in range of 0 to 1, for when the bytes are unknown.
+	Progress float64 `json:"progress"`
+}
+
+// PIndexNodeProgress maps pindex -> node UUID -> TransferBytes.
+type PIndexNodeProgress map[string]map[string]TransferBytes
+
+// HibernationProgressFunc is the callback of the progress of a
+// pause/resume, where progressEntries maps index -> progress and the
+// optional pindexNodeProgress breaks the progress down by pindex and
+// node.
+type HibernationProgressFunc func(progressEntries map[string]float64,
+	pindexNodeProgress PIndexNodeProgress, errs []error)
+
 type HibernationOptions struct {
 	BucketName string
 	SourceType string
@@ -94,6 +117,11 @@ type HibernationOptions struct {
 	Manager *cbgt.Manager
 
 	DryRun bool
+
+	// IndexNames optionally limits a resume to the named indexes of
+	// the hibernated bucket, where the other indexes remain in the
+	// remote path for a later resume.
+	IndexNames []string
 }
 
 type HibernationLogFunc func(format string, v ...interface{})
@@ -118,6 +146,7 @@ type Manager struct {
 
 	m                   sync.Mutex
 	transferProgress    map[string]float64 // pindex -> pause/resume progress
+	transferBytes       PIndexNodeProgress
 	stopCh              chan struct{}
 	ctlDeferPlanSetFunc func()
 
@@ -147,7 +176,7 @@ func (hm *Manager) checkIfIndexMetadataExists() (bool, error) {
 		return false, fmt.Errorf("hibernate: failed to get object store client")
 	}
 
-	bkt, prefix, err := GetRemoteBucketAndPathHook(hm.options.ArchiveLocation)
+	bkt, prefix, err := getRemoteBucketAndPath(hm.options.ArchiveLocation)
 	if err != nil {
 		return false, err
 	}
@@ -175,7 +204,8 @@ func (hm *Manager) downloadIndexMetadata() (*cbgt.IndexDefs, error) {
 
 	ctx, _ := hm.options.Manager.GetHibernationContext()
 
-	data, err := DownloadMetadataHook(client, ctx, bucket, key)
+	data, err := downloadMetadata(hm.options.ArchiveLocation, client, ctx,
+		bucket, key)
 	if err != nil {
 		return nil, err
 	}
@@ -188,11 +218,66 @@ func (hm *Manager) downloadIndexMetadata() (*cbgt.IndexDefs, error) {
 	return indexDefs, err
 }
 
-// This function returns the S3 bucket and path for index metadata and
-// source partitions.
-// The remote path is of the form: s3://<s3-bucket-name>/<key>
+// ErrIndexNotHibernated is matched (see errors.Is) by the errors of
+// resuming indexes that aren't in the hibernated indexes.
+var ErrIndexNotHibernated = errors.New("hibernate: index is not in the" +
+	" hibernated indexes")
+
+// selectIndexesToResume returns the hibernated index definitions of
+// the named indexes, erroring if any aren't hibernated.
+func selectIndexesToResume(indexDefs *cbgt.IndexDefs,
+	indexNames []string) (*cbgt.IndexDefs, error) {
+	rv := cbgt.NewIndexDefs(indexDefs.ImplVersion)
+	rv.UUID = indexDefs.UUID
+
+	for _, indexName := range indexNames {
+		indexDef, exists := indexDefs.IndexDefs[indexName]
+		if !exists {
+			return nil, fmt.Errorf("%w, index: %s",
+				ErrIndexNotHibernated, indexName)
+		}
+		rv.IndexDefs[indexName] = indexDef
+	}
+
+	return rv, nil
+}
+
+// CheckIndexesToResume returns an error matching ErrIndexNotHibernated
+// when any of the named indexes aren't in the index metadata of the
+// remote path, so that a resume of selected indexes can be rejected
+// before it starts.
+func CheckIndexesToResume(client objcli.Client, ctx context.Context,
+	remotePath string, indexNames []string) error {
+	if client == nil {
+		return fmt.Errorf("hibernate: failed to get object store client")
+	}
+
+	bucket, key, _, err := getBucketAndMetadataPaths(remotePath)
+	if err != nil {
+		return err
+	}
+
+	data, err := downloadMetadata(remotePath, client, ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	indexDefs := new(cbgt.IndexDefs)
+	err = cbgt.UnmarshalJSON(data, indexDefs)
+	if err != nil {
+		return err
+	}
+
+	_, err = selectIndexesToResume(indexDefs, indexNames)
+	return err
+}
+
+// This function returns the remote bucket and path for index metadata
+// and source partitions.
+// The remote path is of the form: <scheme>://<bucket-name>/<key>, where
+// the scheme is s3 or that of a registered cbgt.BlobStore.
 func getBucketAndMetadataPaths(remotePath string) (string, string, string, error) {
-	bucket, key, err := GetRemoteBucketAndPathHook(remotePath)
+	bucket, key, err := getRemoteBucketAndPath(remotePath)
 	if err != nil {
 		return "", "", "", err
 	}
@@ -230,6 +315,11 @@ func Start(version string, cfg cbgt.Cfg, server string,
 			return nil, err
 		}
 	} else if hibernationType == OperationType(cbgt.UNHIBERNATE_TASK) {
+		// A resumed bucket's indexes are served live, rather than cold.
+		if options.Manager != nil && !options.DryRun {
+			options.Manager.CloseColdIndexes(options.BucketName)
+		}
+
 		// Checking if the metadata file exists in the path since it will
 		// be downloaded during resume.
 		exists, err := hm.checkIfIndexMetadataExists()
@@ -242,6 +332,14 @@ func Start(version string, cfg cbgt.Cfg, server string,
 				return nil, err
 			}
 
+			if len(options.IndexNames) > 0 {
+				indexDefsToHibernate, err =
+					selectIndexesToResume(indexDefsToHibernate, options.IndexNames)
+				if err != nil {
+					return nil, err
+				}
+			}
+
 			for _, index := range indexDefsToHibernate.IndexDefs {
 				hm.options.SourceType = index.SourceType
 				break
@@ -287,6 +385,7 @@ func Start(version string, cfg cbgt.Cfg, server string,
 	hm.stopCh = make(chan struct{})
 	hm.progressCh = make(chan HibernationProgress)
 	hm.transferProgress = transferProgress
+	hm.transferBytes = make(PIndexNodeProgress)
 
 	go hm.runMonitor()
 
@@ -372,8 +471,8 @@ func (hm *Manager) startResumeBucketStateTracker() {
 	status, err := BucketStateTrackerHook(hm.options.Manager, cbgt.UNHIBERNATE_TASK,
 		hm.options.BucketName)
 
-	hm.options.Manager.ResetBucketTrackedForHibernation()
-	hm.options.Manager.UnregisterBucketTracker()
+	hm.options.Manager.ResetBucketTrackedForHibernationFor(hm.options.BucketName)
+	hm.options.Manager.UnregisterBucketTrackerFor(hm.options.BucketName)
 
 	if err != nil {
 		log.Errorf("hibernate: resume: error tracking bucket %s: %v",
@@ -403,8 +502,8 @@ func (hm *Manager) startPauseBucketStateTracker() {
 	status, err := BucketStateTrackerHook(hm.options.Manager, cbgt.HIBERNATE_TASK,
 		hm.options.BucketName)
 
-	hm.options.Manager.ResetBucketTrackedForHibernation()
-	hm.options.Manager.UnregisterBucketTracker()
+	hm.options.Manager.ResetBucketTrackedForHibernationFor(hm.options.BucketName)
+	hm.options.Manager.UnregisterBucketTrackerFor(hm.options.BucketName)
 
 	if err != nil {
 		log.Errorf("hibernate: pause: error tracking bucket %s: %v",
@@ -423,8 +522,33 @@ func (hm *Manager) startPauseBucketStateTracker() {
 	} else if status == -1 {
 		log.Errorf("hibernate: hibernation has failed, undoing pause changes for bucket %s.",
 			hm.options.BucketName)
+		remotePath := hm.options.ArchiveLocation
 		hm.resetHibernationPaths()
+		hm.gcRemotePath(remotePath)
+	}
+}
+
+// gcRemotePath removes the partial uploads of a failed or canceled
+// pause from its remote path.
+func (hm *Manager) gcRemotePath(remotePath string) {
+	if _, err := cbgt.BlobStoreForRemotePath(remotePath); err != nil {
+		return
+	}
+
+	client := hm.options.Manager.GetObjStoreClient()
+	if client == nil {
+		return
+	}
+
+	rv, err := hm.options.Manager.GCHibernationArtifacts(context.Background(),
+		client, remotePath, false)
+	if err != nil {
+		log.Warnf("hibernate: gc of remote path: %s, err: %v", remotePath, err)
+		return
 	}
+
+	log.Printf("hibernate: gc of remote path: %s, removed: %d",
+		remotePath, rv.Removed)
 }
 
 func (hm *Manager) runHibernateIndexes() {
@@ -529,7 +653,8 @@ func (hm *Manager) uploadMetadata() error {
 		return err
 	}
 
-	err = UploadMetadataHook(client, ctx, bucket, indexUploadPath, data)
+	err = uploadMetadata(hm.options.ArchiveLocation, client, ctx, bucket,
+		indexUploadPath, data)
 	if err != nil {
 		return err
 	}
@@ -558,7 +683,8 @@ func (hm *Manager) uploadMetadata() error {
 		return nil
 	}
 
-	return UploadMetadataHook(client, ctx, bucket, sourcePartitionsUploadPath, data)
+	return uploadMetadata(hm.options.ArchiveLocation, client, ctx, bucket,
+		sourcePartitionsUploadPath, data)
 }
 
 func (hm *Manager) UpdateIndexParams(indexDef *cbgt.IndexDef, uuid string) {
@@ -595,7 +721,8 @@ func (hm *Manager) downloadSourcePartitionsMetadata() (*sourceMetadata, error) {
 
 	ctx, _ := hm.options.Manager.GetHibernationContext()
 
-	data, err := DownloadMetadataHook(client, ctx, bucket, key)
+	data, err := downloadMetadata(hm.options.ArchiveLocation, client, ctx,
+		bucket, key)
 	if err != nil {
 		return nil, err
 	}
@@ -797,6 +924,7 @@ MONITOR_LOOP:
 							indexCount[indexName] += 1
 						}
 					}
+					pindexNodeProgress := hm.transferBytes.copy()
 					hm.m.Unlock()
 
 					for k := range indexProgress {
@@ -804,7 +932,10 @@ MONITOR_LOOP:
 					}
 
 					if len(indexProgress) > 0 {
-						hm.progressCh <- HibernationProgress{TransferProgress: indexProgress}
+						hm.progressCh <- HibernationProgress{
+							TransferProgress:   indexProgress,
+							PIndexNodeProgress: pindexNodeProgress,
+						}
 					}
 
 					for _, index := range hm.indexDefsToHibernate.IndexDefs {
@@ -840,6 +971,48 @@ MONITOR_LOOP:
 
 // --------------------------------------------------------
 
+func (p PIndexNodeProgress) copy() PIndexNodeProgress {
+	rv := make(PIndexNodeProgress, len(p))
+	for pindex, nodes := range p {
+		rv[pindex] = make(map[string]TransferBytes, len(nodes))
+		for node, tb := range nodes {
+			rv[pindex][node] = tb
+		}
+	}
+	return rv
+}
+
+// NodeProgress rolls the progress up by node, by the bytes when
+// they're known and else by the average progress of the node's
+// pindexes, in range of 0 to 1.
+func (p PIndexNodeProgress) NodeProgress() map[string]float64 {
+	bytesDone := map[string]uint64{}
+	bytesTotal := map[string]uint64{}
+	progress := map[string]float64{}
+	count := map[string]int{}
+
+	for _, nodes := range p {
+		for node, tb := range nodes {
+			bytesDone[node] += tb.BytesDone
+			bytesTotal[node] += tb.BytesTotal
+			progress[node] += tb.Progress
+			count[node]++
+		}
+	}
+
+	rv := make(map[string]float64, len(count))
+	for node, n := range count {
+		if bytesTotal[node] > 0 {
+			rv[node] = float64(bytesDone[node]) / float64(bytesTotal[node])
+		} else {
+			rv[node] = progress[node] / float64(n)
+		}
+	}
+	return rv
+}
+
+// --------------------------------------------------------
+
 // This function returns a map which maps the pindex name to the node UUID
 // of the node with the active partition(a pindex with 1 or more replicas can
 // have replica partitions on multiple nodes).
@@ -937,8 +1110,12 @@ func (hm *Manager) runMonitor() {
 						CopyStats struct {
 							TransferProgress         float64 `json:"TransferProgress"`
 							TotalCopyPartitionErrors int32   `json:"TotCopyPartitionErrors"`
+							// Optional, when the index type tracks the bytes.
+							BytesDone  uint64 `json:"TransferBytesDone"`
+							BytesTotal uint64 `json:"TransferBytesTotal"`
 						} `json:"copyPartitionStats"`
 					} `json:"pindexes"`
+					ChecksumErrors []*cbgt.HibernationChecksumError `json:"hibernationChecksumErrors"`
 				}{}
 
 				err := cbgt.UnmarshalJSON(s.Data, &m)
@@ -951,6 +1128,19 @@ func (hm *Manager) runMonitor() {
 					continue
 				}
 
+				// A corrupted download fails the resume, rather than
+				// loading a damaged index.
+				if len(m.ChecksumErrors) > 0 {
+					hm.Logf("hibernate: runMonitor, checksum errors on node %s,"+
+						" errs: %v", s.UUID, m.ChecksumErrors)
+
+					hm.progressCh <- HibernationProgress{Error: fmt.Errorf(
+						"hibernate: runMonitor, node: %s, err: %w",
+						s.UUID, m.ChecksumErrors[0])}
+					hm.Stop() // Stop the hibernate.
+					continue
+				}
+
 				for pindex, stats := range m.Status {
 					indexName, err := hm.options.Manager.GetIndexNameForPIndex(pindex)
 					if err != nil {
@@ -992,6 +1182,14 @@ func (hm *Manager) runMonitor() {
 
 					hm.m.Lock()
 					hm.transferProgress[s.UUID+":"+pindex] = float64(stats.CopyStats.TransferProgress)
+					if hm.transferBytes[pindex] == nil {
+						hm.transferBytes[pindex] = make(map[string]TransferBytes)
+					}
+					hm.transferBytes[pindex][s.UUID] = TransferBytes{
+						BytesDone:  stats.CopyStats.BytesDone,
+						BytesTotal: stats.CopyStats.BytesTotal,
+						Progress:   stats.CopyStats.TransferProgress,
+					}
 					hm.m.Unlock()
 				}
 			}
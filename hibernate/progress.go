@@ -8,8 +8,7 @@
 
 package hibernate
 
-func (hm *Manager) ReportProgress(
-	onProgress func(progressEntries map[string]float64, errs []error)) error {
+func (hm *Manager) ReportProgress(onProgress HibernationProgressFunc) error {
 	var firstError error
 	for progress := range hm.progressCh {
 		if progress.Error != nil {
@@ -19,12 +18,13 @@ func (hm *Manager) ReportProgress(
 				firstError = progress.Error
 			}
 
-			onProgress(progress.TransferProgress, []error{progress.Error})
+			onProgress(progress.TransferProgress, progress.PIndexNodeProgress,
+				[]error{progress.Error})
 			hm.Stop()
 			continue
 		}
 
-		onProgress(progress.TransferProgress, nil)
+		onProgress(progress.TransferProgress, progress.PIndexNodeProgress, nil)
 
 		// TransferProgress contains pindexes which belong to the list of indexes to be
 		// hibernated.
@@ -0,0 +1,279 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"time"
+)
+
+// A hibernation batch pauses or resumes several buckets under a single
+// parent task, in an order that respects the dependencies between the
+// buckets, such as to resume the buckets holding metadata first.  The
+// batch is persisted in the Cfg, so that an interrupted batch can be
+// resumed, where the buckets that are already done are skipped.
+
+// HIBERNATION_BATCH_KEY is the Cfg key of the hibernation batch.
+const HIBERNATION_BATCH_KEY = "hibernationBatch"
+
+// The statuses of a bucket of a hibernation batch.
+const (
+	HibernationBatchPending = "pending"
+	HibernationBatchRunning = "running"
+	HibernationBatchDone    = "done"
+	HibernationBatchFailed  = "failed"
+	HibernationBatchSkipped = "skipped"
+)
+
+// A HibernationBatch is the Cfg value of a hibernation batch.
+type HibernationBatch struct {
+	ID   string `json:"id"`
+	Task string `json:"task"` // HIBERNATE_TASK or UNHIBERNATE_TASK.
+
+	// MaxConcurrency is the upper limit of the buckets that are
+	// hibernated at the same time, where <= 0 means 1.
+	MaxConcurrency int `json:"maxConcurrency,omitempty"`
+
+	// ContinueOnError means that the buckets that don't depend on a
+	// failed bucket are still hibernated, rather than being skipped.
+	ContinueOnError bool `json:"continueOnError,omitempty"`
+
+	Buckets []*HibernationBatchBucket `json:"buckets"` // In their order.
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// A HibernationBatchBucket is a bucket of a hibernation batch, which
+// is tracked as a sub-task of the batch's task.
+type HibernationBatchBucket struct {
+	Bucket     string   `json:"bucket"`
+	RemotePath string   `json:"remotePath"`
+	Region     string   `json:"region,omitempty"`
+	RateLimit  uint64   `json:"rateLimit,omitempty"`
+	DryRun     bool     `json:"dryRun,omitempty"`
+	IndexNames []string `json:"indexNames,omitempty"` // For a resume.
+
+	// DependsOn are the buckets of the batch that have to be done
+	// before this bucket is started.
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	Status   string  `json:"status"`
+	Progress float64 `json:"progress"` // In the range of 0 to 1.
+	Error    string  `json:"error,omitempty"`
+}
+
+// Validate checks that the buckets of the batch are unique, and that
+// their dependencies are known buckets of the batch without cycles.
+func (b *HibernationBatch) Validate() error {
+	if len(b.Buckets) == 0 {
+		return fmt.Errorf("hibernation_batch: no buckets")
+	}
+
+	byName := map[string]*HibernationBatchBucket{}
+	for _, bb := range b.Buckets {
+		if bb.Bucket == "" || byName[bb.Bucket] != nil {
+			return fmt.Errorf("hibernation_batch: empty or duplicate"+
+				" bucket: %q", bb.Bucket)
+		}
+		byName[bb.Bucket] = bb
+	}
+
+	for _, bb := range b.Buckets {
+		for _, dep := range bb.DependsOn {
+			if byName[dep] == nil {
+				return fmt.Errorf("hibernation_batch: bucket: %s,"+
+					" depends on an unknown bucket: %q", bb.Bucket, dep)
+			}
+		}
+	}
+
+	// Depth-first search for cycles, where 1 is visiting and 2 is
+	// visited.
+	marks := map[string]int{}
+
+	var visit func(bucket string, path []string) error
+	visit = func(bucket string, path []string) error {
+		switch marks[bucket] {
+		case 1:
+			return fmt.Errorf("hibernation_batch: dependency cycle: %v",
+				append(path, bucket))
+		case 2:
+			return nil
+		}
+		marks[bucket] = 1
+		for _, dep := range byName[bucket].DependsOn {
+			if err := visit(dep, append(path, bucket)); err != nil {
+				return err
+			}
+		}
+		marks[bucket] = 2
+		return nil
+	}
+
+	for _, bb := range b.Buckets {
+		if err := visit(bb.Bucket, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *HibernationBatch) bucketStatuses() map[string]string {
+	rv := make(map[string]string, len(b.Buckets))
+	for _, bb := range b.Buckets {
+		rv[bb.Bucket] = bb.Status
+	}
+	return rv
+}
+
+// SkipBlocked marks the pending buckets that can no longer be started
+// as skipped, which are the buckets that depend on a failed or skipped
+// bucket, or all the pending buckets after a failure unless the batch
+// continues on errors.  Returns the newly skipped buckets.
+func (b *HibernationBatch) SkipBlocked() []string {
+	var rv []string
+
+	for changed := true; changed; {
+		changed = false
+
+		statuses := b.bucketStatuses()
+
+		failed := false
+		for _, status := range statuses {
+			if status == HibernationBatchFailed {
+				failed = true
+			}
+		}
+
+		for _, bb := range b.Buckets {
+			if bb.Status != HibernationBatchPending {
+				continue
+			}
+
+			blocked := failed && !b.ContinueOnError
+			for _, dep := range bb.DependsOn {
+				if statuses[dep] == HibernationBatchFailed ||
+					statuses[dep] == HibernationBatchSkipped {
+					blocked = true
+				}
+			}
+
+			if blocked {
+				bb.Status = HibernationBatchSkipped
+				rv = append(rv, bb.Bucket)
+				changed = true
+			}
+		}
+	}
+
+	return rv
+}
+
+// Next returns the pending buckets, in their order, whose dependencies
+// are done, up to the MaxConcurrency of the batch less the buckets that
+// are already running.
+func (b *HibernationBatch) Next() []*HibernationBatchBucket {
+	statuses := b.bucketStatuses()
+
+	limit := b.MaxConcurrency
+	if limit <= 0 {
+		limit = 1
+	}
+	for _, status := range statuses {
+		if status == HibernationBatchRunning {
+			limit--
+		}
+	}
+
+	var rv []*HibernationBatchBucket
+
+	for _, bb := range b.Buckets {
+		if len(rv) >= limit {
+			break
+		}
+		if bb.Status != HibernationBatchPending {
+			continue
+		}
+
+		ready := true
+		for _, dep := range bb.DependsOn {
+			if statuses[dep] != HibernationBatchDone {
+				ready = false
+				break
+			}
+		}
+
+		if ready {
+			rv = append(rv, bb)
+		}
+	}
+
+	return rv
+}
+
+// Finished returns true when none of the buckets are pending or
+// running.
+func (b *HibernationBatch) Finished() bool {
+	for _, bb := range b.Buckets {
+		if bb.Status == HibernationBatchPending ||
+			bb.Status == HibernationBatchRunning {
+			return false
+		}
+	}
+	return true
+}
+
+// ResetForResume prepares an interrupted batch to be resumed, where
+// the buckets that aren't done are hibernated again from the start.
+func (b *HibernationBatch) ResetForResume() {
+	for _, bb := range b.Buckets {
+		if bb.Status != HibernationBatchDone {
+			bb.Status = HibernationBatchPending
+			bb.Progress = 0
+			bb.Error = ""
+		}
+	}
+}
+
+// CfgGetHibernationBatch retrieves the hibernation batch from a Cfg
+// provider, which is nil when there's none.
+func CfgGetHibernationBatch(cfg Cfg) (*HibernationBatch, uint64, error) {
+	v, cas, err := cfg.Get(HIBERNATION_BATCH_KEY, 0)
+	if err != nil {
+		return nil, cas, err
+	}
+	if v == nil {
+		return nil, cas, nil
+	}
+	rv := &HibernationBatch{}
+	err = UnmarshalJSON(v, rv)
+	if err != nil {
+		return nil, cas, err
+	}
+	return rv, cas, nil
+}
+
+// CfgSetHibernationBatch updates the hibernation batch on a Cfg
+// provider, where CFG_CAS_FORCE overwrites the current batch.
+func CfgSetHibernationBatch(cfg Cfg, batch *HibernationBatch,
+	cas uint64) (uint64, error) {
+	buf, err := MarshalJSON(batch)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Set(HIBERNATION_BATCH_KEY, buf, cas)
+}
+
+// CfgDelHibernationBatch removes the hibernation batch from a Cfg
+// provider.
+func CfgDelHibernationBatch(cfg Cfg) error {
+	return cfg.Del(HIBERNATION_BATCH_KEY, 0)
+}
@@ -0,0 +1,185 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testHibernationBatch(deps map[string][]string,
+	buckets ...string) *HibernationBatch {
+	b := &HibernationBatch{ID: "b0", Task: UNHIBERNATE_TASK}
+	for _, bucket := range buckets {
+		b.Buckets = append(b.Buckets, &HibernationBatchBucket{
+			Bucket:    bucket,
+			DependsOn: deps[bucket],
+			Status:    HibernationBatchPending,
+		})
+	}
+	return b
+}
+
+func nextHibernationBatchBuckets(b *HibernationBatch) []string {
+	var rv []string
+	for _, bb := range b.Next() {
+		rv = append(rv, bb.Bucket)
+	}
+	return rv
+}
+
+func TestHibernationBatchValidate(t *testing.T) {
+	tests := []struct {
+		deps    map[string][]string
+		buckets []string
+		expErr  bool
+	}{
+		{nil, nil, true},
+		{nil, []string{"a", "b"}, false},
+		{nil, []string{"a", "a"}, true},
+		{nil, []string{""}, true},
+		{map[string][]string{"b": {"a"}}, []string{"a", "b"}, false},
+		{map[string][]string{"b": {"x"}}, []string{"a", "b"}, true},
+		{map[string][]string{"a": {"a"}}, []string{"a"}, true},
+		{map[string][]string{"a": {"c"}, "b": {"a"}, "c": {"b"}},
+			[]string{"a", "b", "c"}, true},
+		{map[string][]string{"b": {"a"}, "c": {"a", "b"}},
+			[]string{"c", "b", "a"}, false},
+	}
+
+	for i, test := range tests {
+		err := testHibernationBatch(test.deps, test.buckets...).Validate()
+		if (err != nil) != test.expErr {
+			t.Errorf("test: %d, expErr: %v, got err: %v", i, test.expErr, err)
+		}
+	}
+}
+
+func TestHibernationBatchNext(t *testing.T) {
+	// The metadata bucket has to be resumed before the others.
+	b := testHibernationBatch(map[string][]string{
+		"a": {"meta"},
+		"b": {"meta"},
+	}, "a", "b", "meta", "c")
+	b.MaxConcurrency = 2
+
+	if got := nextHibernationBatchBuckets(b); !reflect.DeepEqual(got,
+		[]string{"meta", "c"}) {
+		t.Errorf("expected meta and c, got: %v", got)
+	}
+
+	b.Buckets[2].Status = HibernationBatchRunning
+	b.Buckets[3].Status = HibernationBatchRunning
+	if got := nextHibernationBatchBuckets(b); len(got) != 0 {
+		t.Errorf("expected none at the max concurrency, got: %v", got)
+	}
+
+	b.Buckets[2].Status = HibernationBatchDone
+	if got := nextHibernationBatchBuckets(b); !reflect.DeepEqual(got,
+		[]string{"a"}) {
+		t.Errorf("expected a, got: %v", got)
+	}
+
+	b.MaxConcurrency = 0
+	b.Buckets[3].Status = HibernationBatchDone
+	if got := nextHibernationBatchBuckets(b); !reflect.DeepEqual(got,
+		[]string{"a"}) {
+		t.Errorf("expected only a with the default concurrency, got: %v", got)
+	}
+
+	if b.Finished() {
+		t.Errorf("expected not finished")
+	}
+	b.Buckets[0].Status = HibernationBatchDone
+	b.Buckets[1].Status = HibernationBatchDone
+	if !b.Finished() {
+		t.Errorf("expected finished")
+	}
+}
+
+func TestHibernationBatchSkipBlocked(t *testing.T) {
+	deps := map[string][]string{"b": {"a"}, "c": {"b"}}
+
+	b := testHibernationBatch(deps, "a", "b", "c", "d")
+	b.ContinueOnError = true
+	b.Buckets[0].Status = HibernationBatchFailed
+
+	skipped := b.SkipBlocked()
+	if !reflect.DeepEqual(skipped, []string{"b", "c"}) {
+		t.Errorf("expected the transitive dependents skipped, got: %v",
+			skipped)
+	}
+	if got := nextHibernationBatchBuckets(b); !reflect.DeepEqual(got,
+		[]string{"d"}) {
+		t.Errorf("expected d to continue, got: %v", got)
+	}
+
+	b = testHibernationBatch(deps, "a", "b", "c", "d")
+	b.Buckets[0].Status = HibernationBatchFailed
+
+	skipped = b.SkipBlocked()
+	if !reflect.DeepEqual(skipped, []string{"b", "c", "d"}) {
+		t.Errorf("expected all pending skipped, got: %v", skipped)
+	}
+	if !b.Finished() {
+		t.Errorf("expected finished")
+	}
+}
+
+func TestHibernationBatchResume(t *testing.T) {
+	cfg := NewCfgMem()
+
+	b, _, err := CfgGetHibernationBatch(cfg)
+	if err != nil || b != nil {
+		t.Fatalf("expected no batch, got: %+v, err: %v", b, err)
+	}
+
+	b = testHibernationBatch(map[string][]string{"b": {"a"}},
+		"a", "b", "c")
+	b.Buckets[0].Status = HibernationBatchDone
+	b.Buckets[0].Progress = 1.0
+	b.Buckets[1].Status = HibernationBatchRunning
+	b.Buckets[1].Progress = 0.5
+	b.Buckets[2].Status = HibernationBatchSkipped
+
+	_, err = CfgSetHibernationBatch(cfg, b, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	b, _, err = CfgGetHibernationBatch(cfg)
+	if err != nil || b == nil || b.ID != "b0" || len(b.Buckets) != 3 {
+		t.Fatalf("expected the batch, got: %+v, err: %v", b, err)
+	}
+
+	b.ResetForResume()
+
+	if b.Buckets[0].Status != HibernationBatchDone ||
+		b.Buckets[0].Progress != 1.0 {
+		t.Errorf("expected a to stay done, got: %+v", b.Buckets[0])
+	}
+	for _, bb := range b.Buckets[1:] {
+		if bb.Status != HibernationBatchPending || bb.Progress != 0 {
+			t.Errorf("expected pending, got: %+v", bb)
+		}
+	}
+	if got := nextHibernationBatchBuckets(b); !reflect.DeepEqual(got,
+		[]string{"b"}) {
+		t.Errorf("expected b, got: %v", got)
+	}
+
+	err = CfgDelHibernationBatch(cfg)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	b, _, err = CfgGetHibernationBatch(cfg)
+	if err != nil || b != nil {
+		t.Errorf("expected no batch, got: %+v, err: %v", b, err)
+	}
+}
@@ -0,0 +1,576 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/couchbase/clog"
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+// HIBERNATION_CHECKSUMS_DIR is the directory, under a hibernation's
+// remote path, of the checksum manifests written by the nodes at
+// pause time, with one manifest per node.
+const HIBERNATION_CHECKSUMS_DIR = "checksums"
+
+// ErrHibernationChecksum is matched (see errors.Is) by the errors of
+// hibernated objects that fail checksum verification on resume.
+var ErrHibernationChecksum = fmt.Errorf("hibernation_checksum: checksum mismatch")
+
+// A HibernationChecksumError describes a hibernated object that failed
+// checksum verification.
+type HibernationChecksumError struct {
+	Key      string `json:"key"`
+	Segment  int    `json:"segment"` // Of the object's checksum segments.
+	Expected string `json:"expected"`
+	Got      string `json:"got"`
+}
+
+func (e *HibernationChecksumError) Error() string {
+	return fmt.Sprintf("hibernation_checksum: corrupted object, key: %s,"+
+		" segment: %d, expected sha256: %s, got: %s",
+		e.Key, e.Segment, e.Expected, e.Got)
+}
+
+func (e *HibernationChecksumError) Unwrap() error {
+	return ErrHibernationChecksum
+}
+
+// A HibernationChecksumSegment is the SHA-256 checksum of a contiguous
+// segment of an object, where an object has a segment per append or
+// per multipart upload part.
+type HibernationChecksumSegment struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// HibernationChecksum are the checksums of a hibernated object.
+type HibernationChecksum struct {
+	Segments []HibernationChecksumSegment `json:"segments"`
+	Time     time.Time                    `json:"time"`
+}
+
+// HibernationChecksumManifest are the checksums of the objects that
+// a node uploaded, keyed by object key.
+type HibernationChecksumManifest struct {
+	NodeUUID string                          `json:"nodeUUID"`
+	Objects  map[string]*HibernationChecksum `json:"objects"`
+}
+
+// ------------------------------------------------------------------------
+
+// ChecksumObjStoreClient records the SHA-256 checksums of uploaded
+// objects in a checksum manifest, and verifies downloaded objects
+// against the checksum manifests of all the nodes.  A download that
+// fails verification returns a HibernationChecksumError at the end of
+// its body, and the error is remembered for reporting.  Objects without
+// checksums, such as those of a pause that predates checksums, and
+// byte range downloads are not verified.
+type ChecksumObjStoreClient struct {
+	objcli.Client
+
+	bucket         string
+	manifestPrefix string // Ex: "some/path/checksums/".
+	nodeUUID       string
+
+	m        sync.Mutex
+	own      *HibernationChecksumManifest // Of this node's uploads.
+	all      map[string]*HibernationChecksum
+	loaded   bool
+	parts    map[string]map[string]HibernationChecksumSegment // Keyed by upload id, part id.
+	tainted  map[string]bool                                  // Upload id's with part copies.
+	errs     []*HibernationChecksumError
+	dirty    int        // Records since the last Flush.
+	manifest sync.Mutex // Serializes the manifest uploads.
+}
+
+// NewChecksumObjStoreClient returns a client whose checksum manifests
+// are under the keyPrefix of the bucket.
+func NewChecksumObjStoreClient(client objcli.Client,
+	bucket, keyPrefix, nodeUUID string) *ChecksumObjStoreClient {
+	return &ChecksumObjStoreClient{
+		Client:         client,
+		bucket:         bucket,
+		manifestPrefix: keyPrefix + "/" + HIBERNATION_CHECKSUMS_DIR + "/",
+		nodeUUID:       nodeUUID,
+		own: &HibernationChecksumManifest{
+			NodeUUID: nodeUUID,
+			Objects:  map[string]*HibernationChecksum{},
+		},
+		all:     map[string]*HibernationChecksum{},
+		parts:   map[string]map[string]HibernationChecksumSegment{},
+		tainted: map[string]bool{},
+	}
+}
+
+// Errors returns the checksum verification failures so far.
+func (c *ChecksumObjStoreClient) Errors() []*HibernationChecksumError {
+	c.m.Lock()
+	rv := append([]*HibernationChecksumError(nil), c.errs...)
+	c.m.Unlock()
+	return rv
+}
+
+func (c *ChecksumObjStoreClient) isManifest(key string) bool {
+	return strings.HasPrefix(key, c.manifestPrefix)
+}
+
+func checksumSegment(r io.ReadSeeker) (HibernationChecksumSegment, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return HibernationChecksumSegment{}, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return HibernationChecksumSegment{}, err
+	}
+	return HibernationChecksumSegment{
+		Size: n, SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// record updates the checksums of an uploaded object, where a nil
+// segments removes the object's checksums.  The checksums are only
+// kept in memory until the next Flush, as uploading the whole manifest
+// per object would be quadratic in the number of objects.
+func (c *ChecksumObjStoreClient) record(key string,
+	segments []HibernationChecksumSegment, appended bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if segments == nil {
+		delete(c.own.Objects, key)
+		delete(c.all, key)
+	} else {
+		if appended {
+			if prev := c.own.Objects[key]; prev != nil {
+				segments = append(append([]HibernationChecksumSegment(nil),
+					prev.Segments...), segments...)
+			}
+		}
+		cs := &HibernationChecksum{Segments: segments, Time: time.Now()}
+		c.own.Objects[key] = cs
+		c.all[key] = cs
+	}
+	c.dirty++
+}
+
+// Flush uploads this node's manifest when there are checksums recorded
+// since the last Flush, such as once per uploaded pindex and at the end
+// of a pause.  An object whose checksums aren't flushed is left
+// unverified on resume.
+func (c *ChecksumObjStoreClient) Flush(ctx context.Context) error {
+	c.manifest.Lock()
+	defer c.manifest.Unlock()
+
+	c.m.Lock()
+	dirty := c.dirty
+	if dirty == 0 {
+		c.m.Unlock()
+		return nil
+	}
+	data, err := MarshalJSON(c.own)
+	c.m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = c.Client.PutObject(ctx, c.bucket,
+		c.manifestPrefix+c.nodeUUID+".json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	c.dirty -= dirty
+	c.m.Unlock()
+
+	return nil
+}
+
+// load merges the checksum manifests of all the nodes, where the
+// newest checksums of an object win, as the manifests of a previous
+// pause to the same remote path may remain.
+func (c *ChecksumObjStoreClient) load(ctx context.Context) error {
+	c.m.Lock()
+	loaded := c.loaded
+	c.m.Unlock()
+	if loaded {
+		return nil
+	}
+
+	var manifests []*HibernationChecksumManifest
+
+	err := c.Client.IterateObjects(ctx, c.bucket, c.manifestPrefix, "",
+		nil, nil, func(attrs *objval.ObjectAttrs) error {
+			data, err := BlobStoreGet(ctx, c.Client, c.bucket, attrs.Key)
+			if err != nil {
+				return err
+			}
+			m := &HibernationChecksumManifest{}
+			err = UnmarshalJSON(data, m)
+			if err != nil {
+				return fmt.Errorf("hibernation_checksum: manifest: %s,"+
+					" err: %v", attrs.Key, err)
+			}
+			manifests = append(manifests, m)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, m := range manifests {
+		for key, cs := range m.Objects {
+			if prev := c.all[key]; prev == nil || prev.Time.Before(cs.Time) {
+				c.all[key] = cs
+			}
+		}
+	}
+	c.loaded = true
+
+	return nil
+}
+
+// ------------------------------------------------------------------------
+
+// verifyReader verifies the checksum segments of an object as it's
+// read, returning a HibernationChecksumError instead of the io.EOF
+// when the object is corrupted.
+type verifyReader struct {
+	c        *ChecksumObjStoreClient
+	key      string
+	body     io.ReadCloser
+	segments []HibernationChecksumSegment
+	i        int // Index of the current segment.
+	left     int64
+	h        hash.Hash
+	err      error
+}
+
+func (r *verifyReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.body.Read(p)
+
+	b := p[:n]
+	for len(b) > 0 && r.err == nil {
+		if r.i >= len(r.segments) {
+			r.fail(r.i, "", fmt.Sprintf("%d extra bytes", len(b)))
+			break
+		}
+		k := int64(len(b))
+		if k > r.left {
+			k = r.left
+		}
+		r.h.Write(b[:k])
+		b = b[k:]
+		r.left -= k
+		if r.left == 0 {
+			r.endSegment()
+		}
+	}
+
+	if err == io.EOF && r.err == nil {
+		if r.i < len(r.segments) {
+			r.fail(r.i, r.segments[r.i].SHA256, "truncated")
+		}
+	}
+
+	if r.err != nil {
+		return n, r.err
+	}
+	return n, err
+}
+
+func (r *verifyReader) endSegment() {
+	got := hex.EncodeToString(r.h.Sum(nil))
+	if got != r.segments[r.i].SHA256 {
+		r.fail(r.i, r.segments[r.i].SHA256, got)
+		return
+	}
+	r.i++
+	r.h.Reset()
+	if r.i < len(r.segments) {
+		r.left = r.segments[r.i].Size
+		if r.left == 0 {
+			r.endSegment()
+		}
+	}
+}
+
+func (r *verifyReader) fail(segment int, expected, got string) {
+	err := &HibernationChecksumError{
+		Key: r.key, Segment: segment, Expected: expected, Got: got,
+	}
+	r.err = err
+
+	log.Errorf("%v", err)
+
+	r.c.m.Lock()
+	r.c.errs = append(r.c.errs, err)
+	r.c.m.Unlock()
+}
+
+func (r *verifyReader) Close() error {
+	return r.body.Close()
+}
+
+// ------------------------------------------------------------------------
+
+// GetObject downloads an object, verifying its checksums as it's
+// read.  A byte range of an object with checksums is applied to the
+// verified stream of the whole object, as the checksums are of whole
+// segments, so that ranged reads, such as of a resumed download, are
+// verified too.
+func (c *ChecksumObjStoreClient) GetObject(ctx context.Context,
+	bucket, key string, br *objval.ByteRange) (*objval.Object, error) {
+	if bucket != c.bucket || c.isManifest(key) {
+		return c.Client.GetObject(ctx, bucket, key, br)
+	}
+
+	err := c.load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("hibernation_checksum: load manifests,"+
+			" err: %v", err)
+	}
+
+	c.m.Lock()
+	cs := c.all[key]
+	c.m.Unlock()
+
+	if cs == nil {
+		return c.Client.GetObject(ctx, bucket, key, br)
+	}
+
+	obj, err := c.Client.GetObject(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	vr := &verifyReader{
+		c: c, key: key, body: obj.Body, segments: cs.Segments, h: sha256.New(),
+	}
+	if len(cs.Segments) > 0 {
+		vr.left = cs.Segments[0].Size
+		if vr.left == 0 {
+			vr.endSegment()
+		}
+	}
+	obj.Body = vr
+
+	if br != nil {
+		_, err = io.CopyN(io.Discard, vr, br.Start)
+		if err != nil {
+			vr.Close()
+			return nil, fmt.Errorf("hibernation_checksum: byte range,"+
+				" key: %s, err: %w", key, err)
+		}
+
+		var r io.Reader = vr
+		if br.End != 0 {
+			r = io.LimitReader(vr, br.End-br.Start+1)
+		}
+		obj.Body = rangeReadCloser{Reader: r, Closer: vr}
+	}
+
+	return obj, nil
+}
+
+func (c *ChecksumObjStoreClient) PutObject(ctx context.Context,
+	bucket, key string, body io.ReadSeeker) error {
+	if bucket != c.bucket || c.isManifest(key) {
+		return c.Client.PutObject(ctx, bucket, key, body)
+	}
+
+	seg, err := checksumSegment(body)
+	if err != nil {
+		return err
+	}
+
+	err = c.Client.PutObject(ctx, bucket, key, body)
+	if err != nil {
+		return err
+	}
+
+	c.record(key, []HibernationChecksumSegment{seg}, false)
+
+	return nil
+}
+
+func (c *ChecksumObjStoreClient) AppendToObject(ctx context.Context,
+	bucket, key string, data io.ReadSeeker) error {
+	if bucket != c.bucket || c.isManifest(key) {
+		return c.Client.AppendToObject(ctx, bucket, key, data)
+	}
+
+	seg, err := checksumSegment(data)
+	if err != nil {
+		return err
+	}
+
+	err = c.Client.AppendToObject(ctx, bucket, key, data)
+	if err != nil {
+		return err
+	}
+
+	c.record(key, []HibernationChecksumSegment{seg}, true)
+
+	return nil
+}
+
+func (c *ChecksumObjStoreClient) UploadPart(ctx context.Context,
+	bucket, id, key string, number int, body io.ReadSeeker) (objval.Part, error) {
+	seg, err := checksumSegment(body)
+	if err != nil {
+		return objval.Part{}, err
+	}
+
+	part, err := c.Client.UploadPart(ctx, bucket, id, key, number, body)
+	if err != nil {
+		return part, err
+	}
+
+	c.m.Lock()
+	if c.parts[id] == nil {
+		c.parts[id] = map[string]HibernationChecksumSegment{}
+	}
+	c.parts[id][part.ID] = seg
+	c.m.Unlock()
+
+	return part, nil
+}
+
+// UploadPartCopy leaves the multipart upload without checksums, as the
+// copied data isn't seen by the client.
+func (c *ChecksumObjStoreClient) UploadPartCopy(ctx context.Context,
+	bucket, id, dst, src string, number int,
+	br *objval.ByteRange) (objval.Part, error) {
+	c.m.Lock()
+	c.tainted[id] = true
+	c.m.Unlock()
+
+	return c.Client.UploadPartCopy(ctx, bucket, id, dst, src, number, br)
+}
+
+func (c *ChecksumObjStoreClient) CompleteMultipartUpload(ctx context.Context,
+	bucket, id, key string, parts ...objval.Part) error {
+	err := c.Client.CompleteMultipartUpload(ctx, bucket, id, key, parts...)
+	if err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	segments := make([]HibernationChecksumSegment, 0, len(parts))
+	for _, part := range parts {
+		seg, exists := c.parts[id][part.ID]
+		if !exists {
+			segments = nil
+			break
+		}
+		segments = append(segments, seg)
+	}
+	if c.tainted[id] {
+		segments = nil
+	}
+	delete(c.parts, id)
+	delete(c.tainted, id)
+	c.m.Unlock()
+
+	if bucket != c.bucket || c.isManifest(key) {
+		return nil
+	}
+
+	c.record(key, segments, false)
+
+	return nil
+}
+
+func (c *ChecksumObjStoreClient) AbortMultipartUpload(ctx context.Context,
+	bucket, id, key string) error {
+	c.m.Lock()
+	delete(c.parts, id)
+	delete(c.tainted, id)
+	c.m.Unlock()
+
+	return c.Client.AbortMultipartUpload(ctx, bucket, id, key)
+}
+
+// ------------------------------------------------------------------------
+
+// checksumHibernationClient wraps the client with a
+// ChecksumObjStoreClient, whose manifests are under the remote path.
+// Remote paths without a registered BlobStore are left unverified.
+func (mgr *Manager) checksumHibernationClient(remotePath string,
+	client objcli.Client) objcli.Client {
+	if client == nil {
+		return nil
+	}
+
+	_, bucket, keyPrefix, err := ParseBlobStoreRemotePath(remotePath)
+	if err != nil {
+		log.Warnf("hibernation_checksum: no checksums, remotePath: %s,"+
+			" err: %v", remotePath, err)
+		return client
+	}
+
+	return NewChecksumObjStoreClient(client, bucket, keyPrefix, mgr.uuid)
+}
+
+// FlushHibernationChecksums uploads this node's checksum manifest of
+// the current hibernation's uploads, and is meant to be called by the
+// HibernatePartitionsHook as each pindex finishes its upload.  The
+// manifest is also flushed after the HibernatePartitionsHook returns
+// and when the hibernation's buckets stop being tracked.
+func (mgr *Manager) FlushHibernationChecksums() error {
+	c, ok := mgr.GetObjStoreClient().(*ChecksumObjStoreClient)
+	if !ok {
+		return nil
+	}
+
+	ctx, _ := mgr.GetHibernationContext()
+	if ctx == nil || ctx.Err() != nil {
+		// The manifest outlives a canceled hibernation, as the
+		// uploads so far may be resumed.
+		ctx = context.Background()
+	}
+
+	err := c.Flush(ctx)
+	if err != nil {
+		log.Warnf("hibernation_checksum: flush manifest, err: %v", err)
+	}
+
+	return err
+}
+
+// HibernationChecksumErrors returns the checksum verification failures
+// of the current hibernation's downloads.
+func (mgr *Manager) HibernationChecksumErrors() []*HibernationChecksumError {
+	if c, ok := mgr.GetObjStoreClient().(*ChecksumObjStoreClient); ok {
+		return c.Errors()
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+func TestChecksumObjStoreClient(t *testing.T) {
+	ctx := context.Background()
+
+	inner := objcli.NewTestClient(t, objval.ProviderAWS)
+
+	get := func(c objcli.Client, k string) ([]byte, error) {
+		obj, err := c.GetObject(ctx, "bkt", k, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Body.Close()
+		return io.ReadAll(obj.Body)
+	}
+
+	// The pausing node.
+	pc := NewChecksumObjStoreClient(inner, "bkt", "dir", "node-a")
+
+	err := pc.PutObject(ctx, "bkt", "dir/a", bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	err = pc.AppendToObject(ctx, "bkt", "dir/a", bytes.NewReader([]byte(" world")))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	id, err := pc.CreateMultipartUpload(ctx, "bkt", "dir/b")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	var parts []objval.Part
+	for i, data := range []string{"part-1", "part-2"} {
+		part, err := pc.UploadPart(ctx, "bkt", id, "dir/b", i+1,
+			bytes.NewReader([]byte(data)))
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+		parts = append(parts, part)
+	}
+	err = pc.CompleteMultipartUpload(ctx, "bkt", id, "dir/b", parts...)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	// Unchecksummed objects, such as those of older pauses, pass.
+	err = inner.PutObject(ctx, "bkt", "dir/c", bytes.NewReader([]byte("old")))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	// The checksums are only uploaded when flushed.
+	n := 0
+	err = inner.IterateObjects(ctx, "bkt", "dir/"+HIBERNATION_CHECKSUMS_DIR+"/",
+		"", nil, nil, func(*objval.ObjectAttrs) error { n++; return nil })
+	if err != nil || n != 0 {
+		t.Fatalf("expected no manifest before flush, n: %d, err: %v", n, err)
+	}
+	if err = pc.Flush(ctx); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	// The resuming node verifies with the pausing node's manifest.
+	rc := NewChecksumObjStoreClient(inner, "bkt", "dir", "node-b")
+
+	for k, exp := range map[string]string{
+		"dir/a": "hello world",
+		"dir/b": "part-1part-2",
+		"dir/c": "old",
+	} {
+		got, err := get(rc, k)
+		if err != nil || string(got) != exp {
+			t.Fatalf("key: %s, expected: %s, got: %s, err: %v", k, exp, got, err)
+		}
+	}
+
+	// Corrupt, truncate and extend the objects.
+	for _, kd := range [][2]string{
+		{"dir/a", "hellO world"},
+		{"dir/b", "part-1part-"},
+	} {
+		k, data := kd[0], kd[1]
+		err = inner.PutObject(ctx, "bkt", k, bytes.NewReader([]byte(data)))
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+
+		_, err = get(rc, k)
+		if !errors.Is(err, ErrHibernationChecksum) {
+			t.Fatalf("key: %s, expected checksum err, got: %v", k, err)
+		}
+
+		var cerr *HibernationChecksumError
+		if !errors.As(err, &cerr) || cerr.Key != k {
+			t.Fatalf("key: %s, expected precise err, got: %v", k, err)
+		}
+	}
+
+	if errs := rc.Errors(); len(errs) != 2 ||
+		errs[0].Segment != 0 || errs[1].Segment != 1 {
+		t.Fatalf("expected 2 errs, got: %+v", errs)
+	}
+
+	// Byte ranges are verified too, such as of a resumed download.
+	for _, br := range []*objval.ByteRange{{Start: 0, End: 4}, {Start: 6}} {
+		obj, err := rc.GetObject(ctx, "bkt", "dir/a", br)
+		if err == nil {
+			_, err = io.ReadAll(obj.Body)
+			obj.Body.Close()
+		}
+		if !errors.Is(err, ErrHibernationChecksum) {
+			t.Fatalf("byte range: %+v, expected checksum err, got: %v", br, err)
+		}
+	}
+
+	err = inner.PutObject(ctx, "bkt", "dir/b", bytes.NewReader([]byte("part-1part-2")))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	obj, err := rc.GetObject(ctx, "bkt", "dir/b", &objval.ByteRange{Start: 6})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	got, err := io.ReadAll(obj.Body)
+	obj.Body.Close()
+	if err != nil || string(got) != "part-2" {
+		t.Fatalf("expected byte range, got: %s, err: %v", got, err)
+	}
+
+	// A later upload of the same key supersedes the older checksums.
+	pc2 := NewChecksumObjStoreClient(inner, "bkt", "dir", "node-c")
+	err = pc2.PutObject(ctx, "bkt", "dir/a", bytes.NewReader([]byte("again")))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err = pc2.Flush(ctx); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	rc2 := NewChecksumObjStoreClient(inner, "bkt", "dir", "node-d")
+	got, err = get(rc2, "dir/a")
+	if err != nil || string(got) != "again" {
+		t.Fatalf("expected the newest checksums, got: %s, err: %v", got, err)
+	}
+}
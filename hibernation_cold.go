@@ -0,0 +1,532 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	log "github.com/couchbase/clog"
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+// The cold query mode lets the indexes of a paused bucket still answer
+// queries, without a full resume, by streaming the pindex data that a
+// query needs on demand from the hibernation remote path into a
+// bounded local cache.  The cold indexes are read-only, and are only
+// queryable when their pindex implementation supports the QueryCold()
+// of its PIndexImplType, as the layout of the pindex data in the remote
+// path is specific to the pindex implementation.
+
+// HIBERNATION_COLD_QUERY_OPTION is the manager option that enables the
+// cold query mode when "true".
+const HIBERNATION_COLD_QUERY_OPTION = "hibernationColdQuery"
+
+// HIBERNATION_COLD_CACHE_BYTES_OPTION is the manager option that holds
+// the upper limit of the bytes of the local cache of the cold indexes.
+const HIBERNATION_COLD_CACHE_BYTES_OPTION = "hibernationColdCacheBytes"
+
+// DefaultHibernationColdCacheBytes is the default upper limit of the
+// bytes of the local cache of the cold indexes.
+var DefaultHibernationColdCacheBytes = int64(1 << 30)
+
+// HIBERNATION_COLD_DIR is the subdirectory of the data dir that holds
+// the local cache of the cold indexes.
+const HIBERNATION_COLD_DIR = "hibernation-cold"
+
+// ErrHibernationColdQueryDisabled is returned when the cold query mode
+// isn't enabled.
+var ErrHibernationColdQueryDisabled = errors.New("hibernation cold query disabled")
+
+// ErrHibernationColdQueryNotSupported is returned when the pindex
+// implementation of a cold index doesn't support cold queries.
+var ErrHibernationColdQueryNotSupported = errors.New("hibernation cold query not supported")
+
+// ErrHibernationColdObjectTooLarge is returned when a remote object
+// doesn't fit into the local cache of the cold indexes.
+var ErrHibernationColdObjectTooLarge = errors.New("hibernation cold object too large")
+
+// ------------------------------------------------------------------------
+
+// HibernationColdCacheStats are the stats of a HibernationColdCache.
+type HibernationColdCacheStats struct {
+	MaxBytes  int64  `json:"maxBytes"`
+	Bytes     int64  `json:"bytes"`
+	Objects   int    `json:"objects"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// A HibernationColdCache is a bounded on-disk cache of remote objects,
+// which evicts the least recently used objects that aren't in use.
+// The objects in use are never evicted, so the cache may temporarily
+// exceed its max bytes.
+type HibernationColdCache struct {
+	dir      string
+	maxBytes int64
+
+	m       sync.Mutex // Protects the fields that follow.
+	entries map[string]*hibernationColdEntry
+	lru     *list.List // Of *hibernationColdEntry, most recent first.
+	stats   HibernationColdCacheStats
+}
+
+type hibernationColdEntry struct {
+	key   string
+	path  string
+	size  int64
+	refs  int
+	elem  *list.Element // Nil until downloaded.
+	err   error
+	ready chan struct{} // Closed when downloaded.
+}
+
+// NewHibernationColdCache returns a cache of at most maxBytes of remote
+// objects, which are stored in the dir.
+func NewHibernationColdCache(dir string, maxBytes int64) (
+	*HibernationColdCache, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("hibernation_cold: could not make dir: %s,"+
+			" err: %v", dir, err)
+	}
+
+	return &HibernationColdCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  map[string]*hibernationColdEntry{},
+		lru:      list.New(),
+	}, nil
+}
+
+// Get returns the local path of the remote object at the bucket and
+// key, downloading it when it's not cached, along with a func that has
+// to be called once the local file is no longer used.
+func (c *HibernationColdCache) Get(ctx context.Context, client objcli.Client,
+	bucket, key string) (string, func(), error) {
+	cacheKey := bucket + "/" + key
+
+	c.m.Lock()
+	e, exists := c.entries[cacheKey]
+	if exists {
+		e.refs++
+		c.stats.Hits++
+		c.m.Unlock()
+
+		<-e.ready // Another caller may still be downloading it.
+
+		c.m.Lock()
+		if e.err != nil {
+			e.refs--
+			c.m.Unlock()
+			return "", nil, e.err
+		}
+		c.lru.MoveToFront(e.elem)
+		c.m.Unlock()
+
+		return e.path, c.releaseFunc(e), nil
+	}
+
+	sum := sha256.Sum256([]byte(cacheKey))
+	e = &hibernationColdEntry{
+		key:   cacheKey,
+		path:  filepath.Join(c.dir, hex.EncodeToString(sum[:])),
+		refs:  1,
+		ready: make(chan struct{}),
+	}
+	c.entries[cacheKey] = e
+	c.stats.Misses++
+	c.m.Unlock()
+
+	size, err := c.download(ctx, client, bucket, key, e.path)
+
+	c.m.Lock()
+	if err == nil && size > c.maxBytes {
+		os.Remove(e.path)
+		err = fmt.Errorf("hibernation_cold: key: %s, size: %d,"+
+			" maxBytes: %d, err: %w", cacheKey, size, c.maxBytes,
+			ErrHibernationColdObjectTooLarge)
+	}
+	if err != nil {
+		e.err = err
+		e.refs--
+		delete(c.entries, cacheKey)
+	} else {
+		e.size = size
+		e.elem = c.lru.PushFront(e)
+		c.stats.Bytes += size
+		c.evictLOCKED()
+	}
+	close(e.ready)
+	c.m.Unlock()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return e.path, c.releaseFunc(e), nil
+}
+
+func (c *HibernationColdCache) download(ctx context.Context,
+	client objcli.Client, bucket, key, path string) (int64, error) {
+	attrs, err := client.GetObjectAttrs(ctx, bucket, key)
+	if err != nil {
+		return 0, fmt.Errorf("hibernation_cold: get attrs, bucket: %s,"+
+			" key: %s, err: %v", bucket, key, err)
+	}
+
+	// The size of the attrs might be of a compressed or encrypted
+	// object, so the download is of an unknown size, and it's only
+	// resumed from a previous download of the same object.
+	sourceID := attrs.ETag
+	if attrs.LastModified != nil {
+		sourceID += "/" + attrs.LastModified.UTC().String()
+	}
+
+	src := &objStoreReaderAt{
+		ctx:    ctx,
+		client: client,
+		bucket: bucket,
+		key:    key,
+		limit:  c.maxBytes + 1, // Stops the download of a too large object.
+	}
+	defer src.Close()
+
+	// An interrupted download, such as of a canceled query, is resumed
+	// by the next download of the object.
+	downloadPath := path + ".download"
+
+	err = ResumableCopy(src, -1, downloadPath, ResumableCopyOptions{
+		SourceID: sourceID,
+		CancelCh: ctx.Done(),
+	})
+	if err == nil {
+		err = os.Rename(downloadPath, path)
+	}
+	if err != nil {
+		if ctx.Err() == nil { // Only a canceled download is resumed.
+			os.Remove(downloadPath)
+			os.Remove(downloadPath + TRANSFER_CHECKPOINT_SUFFIX)
+		}
+		return 0, fmt.Errorf("hibernation_cold: download, bucket: %s,"+
+			" key: %s, err: %w", bucket, key, err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+// An objStoreReaderAt reads a remote object through a single ranged
+// stream, which is only re-opened when a read isn't at the offset that
+// the previous read ended at.
+type objStoreReaderAt struct {
+	ctx    context.Context
+	client objcli.Client
+	bucket string
+	key    string
+	limit  int64 // Reads at or past the limit return io.EOF.
+
+	body io.ReadCloser // Nil until a read.
+	pos  int64         // The offset of the body.
+}
+
+func (r *objStoreReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.limit {
+		return 0, io.EOF
+	}
+	limited := int64(len(p)) > r.limit-off
+	if limited {
+		p = p[:r.limit-off]
+	}
+
+	if r.body == nil || r.pos != off {
+		r.Close()
+
+		// A read from the start is of the whole object.
+		var br *objval.ByteRange
+		if off > 0 {
+			br = &objval.ByteRange{Start: off}
+		}
+
+		obj, err := r.client.GetObject(r.ctx, r.bucket, r.key, br)
+		if err != nil {
+			return 0, err
+		}
+		r.body = obj.Body
+		r.pos = off
+	}
+
+	n, err := io.ReadFull(r.body, p)
+	r.pos += int64(n)
+	if err == io.ErrUnexpectedEOF || (err == nil && limited) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (r *objStoreReaderAt) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+func (c *HibernationColdCache) releaseFunc(e *hibernationColdEntry) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			c.m.Lock()
+			e.refs--
+			c.evictLOCKED()
+			c.m.Unlock()
+		})
+	}
+}
+
+// evictLOCKED evicts the least recently used objects that aren't in
+// use until the cache fits its max bytes.
+func (c *HibernationColdCache) evictLOCKED() {
+	for elem := c.lru.Back(); elem != nil && c.stats.Bytes > c.maxBytes; {
+		prev := elem.Prev()
+
+		e := elem.Value.(*hibernationColdEntry)
+		if e.refs <= 0 {
+			c.lru.Remove(elem)
+			delete(c.entries, e.key)
+			c.stats.Bytes -= e.size
+			c.stats.Evictions++
+
+			os.Remove(e.path)
+		}
+
+		elem = prev
+	}
+}
+
+// Stats returns the current stats of the cache.
+func (c *HibernationColdCache) Stats() HibernationColdCacheStats {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	rv := c.stats
+	rv.MaxBytes = c.maxBytes
+	rv.Objects = c.lru.Len()
+
+	return rv
+}
+
+// ------------------------------------------------------------------------
+
+// A ColdIndex is a read-only index of a paused bucket, whose pindex
+// data remains in the hibernation remote path.
+type ColdIndex struct {
+	Bucket     string    `json:"bucket"`
+	RemotePath string    `json:"remotePath"`
+	IndexDef   *IndexDef `json:"indexDef"`
+
+	client       objcli.Client
+	remoteBucket string // The bucket (or container) of the remote path.
+	remoteKey    string // The key prefix of the remote path.
+	cache        *HibernationColdCache
+}
+
+// Fetch returns the local path of the object at the key relative to
+// the remote path of the cold index, streaming it into the local cache
+// when it's not cached, along with a func that has to be called once
+// the local file is no longer used.
+func (ci *ColdIndex) Fetch(ctx context.Context, key string) (
+	string, func(), error) {
+	return ci.cache.Get(ctx, ci.client, ci.remoteBucket,
+		ci.remoteKey+"/"+key)
+}
+
+// coldCacheLOCKED returns the local cache of the cold indexes, making
+// it on first use.
+func (mgr *Manager) coldCacheLOCKED() (*HibernationColdCache, error) {
+	if mgr.coldCache != nil {
+		return mgr.coldCache, nil
+	}
+
+	maxBytes := DefaultHibernationColdCacheBytes
+	if v := mgr.GetOption(HIBERNATION_COLD_CACHE_BYTES_OPTION); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("hibernation_cold: option: %s,"+
+				" invalid value: %q", HIBERNATION_COLD_CACHE_BYTES_OPTION, v)
+		}
+		maxBytes = n
+	}
+
+	dir := filepath.Join(mgr.dataDir, HIBERNATION_COLD_DIR)
+
+	// Any cached objects from a previous process are stale.
+	os.RemoveAll(dir)
+
+	cache, err := NewHibernationColdCache(dir, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	mgr.coldCache = cache
+
+	return cache, nil
+}
+
+// OpenColdIndexes makes the hibernated indexes of a paused bucket
+// queryable in the cold query mode, where the client, remote bucket
+// and remote key are of the bucket's hibernation remote path.  The
+// indexes must not be live.
+func (mgr *Manager) OpenColdIndexes(bucket, remotePath string,
+	client objcli.Client, remoteBucket, remoteKey string,
+	indexDefs *IndexDefs) error {
+	if mgr.GetOption(HIBERNATION_COLD_QUERY_OPTION) != "true" {
+		return ErrHibernationColdQueryDisabled
+	}
+
+	liveIndexDefs, _, err := CfgGetIndexDefs(mgr.cfg)
+	if err != nil {
+		return err
+	}
+
+	mgr.coldIndexesM.Lock()
+	defer mgr.coldIndexesM.Unlock()
+
+	for indexName := range indexDefs.IndexDefs {
+		if liveIndexDefs != nil && liveIndexDefs.IndexDefs[indexName] != nil {
+			return fmt.Errorf("hibernation_cold: bucket: %s, index: %s,"+
+				" is live", bucket, indexName)
+		}
+		if ci := mgr.coldIndexes[indexName]; ci != nil && ci.Bucket != bucket {
+			return fmt.Errorf("hibernation_cold: bucket: %s, index: %s,"+
+				" is a cold index of bucket: %s", bucket, indexName, ci.Bucket)
+		}
+	}
+
+	cache, err := mgr.coldCacheLOCKED()
+	if err != nil {
+		return err
+	}
+
+	if mgr.coldIndexes == nil {
+		mgr.coldIndexes = map[string]*ColdIndex{}
+	}
+
+	for indexName, indexDef := range indexDefs.IndexDefs {
+		mgr.coldIndexes[indexName] = &ColdIndex{
+			Bucket:       bucket,
+			RemotePath:   remotePath,
+			IndexDef:     indexDef,
+			client:       client,
+			remoteBucket: remoteBucket,
+			remoteKey:    remoteKey,
+			cache:        cache,
+		}
+	}
+
+	log.Printf("hibernation_cold: opened bucket: %s, remotePath: %s,"+
+		" indexes: %d", bucket, remotePath, len(indexDefs.IndexDefs))
+
+	return nil
+}
+
+// CloseColdIndexes stops the cold queries of the indexes of a bucket,
+// such as when the bucket is resumed.
+func (mgr *Manager) CloseColdIndexes(bucket string) {
+	mgr.coldIndexesM.Lock()
+	defer mgr.coldIndexesM.Unlock()
+
+	var closed int
+	for indexName, ci := range mgr.coldIndexes {
+		if ci.Bucket == bucket {
+			delete(mgr.coldIndexes, indexName)
+			closed++
+		}
+	}
+
+	if closed > 0 {
+		log.Printf("hibernation_cold: closed bucket: %s, indexes: %d",
+			bucket, closed)
+	}
+}
+
+// ColdIndex returns the cold index of the name, or nil.
+func (mgr *Manager) ColdIndex(indexName string) *ColdIndex {
+	mgr.coldIndexesM.Lock()
+	defer mgr.coldIndexesM.Unlock()
+
+	return mgr.coldIndexes[indexName]
+}
+
+// ColdIndexes returns the cold indexes, sorted by name.
+func (mgr *Manager) ColdIndexes() []*ColdIndex {
+	mgr.coldIndexesM.Lock()
+	defer mgr.coldIndexesM.Unlock()
+
+	rv := make([]*ColdIndex, 0, len(mgr.coldIndexes))
+	for _, ci := range mgr.coldIndexes {
+		rv = append(rv, ci)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].IndexDef.Name < rv[j].IndexDef.Name
+	})
+
+	return rv
+}
+
+// ColdCacheStats returns the stats of the local cache of the cold
+// indexes, or nil when there's no cache yet.
+func (mgr *Manager) ColdCacheStats() *HibernationColdCacheStats {
+	mgr.coldIndexesM.Lock()
+	cache := mgr.coldCache
+	mgr.coldIndexesM.Unlock()
+
+	if cache == nil {
+		return nil
+	}
+
+	stats := cache.Stats()
+
+	return &stats
+}
+
+// QueryColdIndex queries a cold index with the QueryCold() of its
+// PIndexImplType.
+func (mgr *Manager) QueryColdIndex(indexName string,
+	req []byte, res io.Writer) error {
+	ci := mgr.ColdIndex(indexName)
+	if ci == nil {
+		return fmt.Errorf("hibernation_cold: no cold index: %s", indexName)
+	}
+
+	t := PIndexImplTypes[ci.IndexDef.Type]
+	if t == nil || t.QueryCold == nil {
+		return ErrHibernationColdQueryNotSupported
+	}
+
+	return t.QueryCold(mgr, ci, req, res)
+}
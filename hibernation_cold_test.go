@@ -0,0 +1,216 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+func TestHibernationColdCache(t *testing.T) {
+	ctx := context.Background()
+
+	client := objcli.NewTestClient(t, objval.ProviderAWS)
+	for _, k := range []string{"a", "b", "c"} {
+		err := client.PutObject(ctx, "bkt", k,
+			bytes.NewReader(bytes.Repeat([]byte(k), 40)))
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+	err := client.PutObject(ctx, "bkt", "big",
+		bytes.NewReader(make([]byte, 101)))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	c, err := NewHibernationColdCache(t.TempDir(), 100)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	pathA, releaseA, err := c.Get(ctx, client, "bkt", "a")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if data, _ := os.ReadFile(pathA); string(data) !=
+		string(bytes.Repeat([]byte("a"), 40)) {
+		t.Fatalf("unexpected data: %q", data)
+	}
+
+	_, releaseB, err := c.Get(ctx, client, "bkt", "b")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	releaseB()
+
+	// The a is in use, so the b is evicted for the c.
+	_, releaseC, err := c.Get(ctx, client, "bkt", "c")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	releaseC()
+
+	if _, err = os.Stat(pathA); err != nil {
+		t.Errorf("expected the a in use to remain, err: %v", err)
+	}
+
+	stats := c.Stats()
+	if stats.Objects != 2 || stats.Bytes != 80 || stats.Misses != 3 ||
+		stats.Hits != 0 || stats.Evictions != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	_, releaseA2, err := c.Get(ctx, client, "bkt", "a")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	releaseA2()
+	releaseA()
+	releaseA() // Idempotent.
+
+	if stats = c.Stats(); stats.Hits != 1 || stats.Objects != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	_, _, err = c.Get(ctx, client, "bkt", "big")
+	if !errors.Is(err, ErrHibernationColdObjectTooLarge) {
+		t.Errorf("expected a too large err, got: %v", err)
+	}
+
+	_, _, err = c.Get(ctx, client, "bkt", "missing")
+	if err == nil {
+		t.Errorf("expected an err for a missing object")
+	}
+
+	if stats = c.Stats(); stats.Objects != 2 || stats.Bytes != 80 {
+		t.Errorf("unexpected stats after errs: %+v", stats)
+	}
+
+	// A canceled download is resumed by the next download.
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, err = c.Get(canceledCtx, client, "bkt", "b")
+	if !errors.Is(err, ErrTransferCanceled) {
+		t.Fatalf("expected a canceled err, got: %v", err)
+	}
+
+	pathB, releaseB, err := c.Get(ctx, client, "bkt", "b")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	releaseB()
+	if data, _ := os.ReadFile(pathB); string(data) !=
+		string(bytes.Repeat([]byte("b"), 40)) {
+		t.Fatalf("unexpected data: %q", data)
+	}
+}
+
+func TestQueryColdIndex(t *testing.T) {
+	defer delete(PIndexImplTypes, "testCold")
+
+	RegisterPIndexImplType("testCold", &PIndexImplType{
+		QueryCold: func(mgr *Manager, coldIndex *ColdIndex,
+			req []byte, res io.Writer) error {
+			path, release, err := coldIndex.Fetch(context.Background(),
+				string(req))
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			_, err = res.Write(data)
+			return err
+		},
+	})
+
+	ctx := context.Background()
+
+	client := objcli.NewTestClient(t, objval.ProviderAWS)
+	err := client.PutObject(ctx, "bkt", "paused/i0/seg0",
+		bytes.NewReader([]byte("hits")))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	cfg := NewCfgMem()
+	mgr := NewManagerEx(VERSION, cfg, NewUUID(), nil, "", 1, "", "",
+		t.TempDir(), "", nil, nil)
+
+	indexDefs := NewIndexDefs(VERSION)
+	indexDefs.IndexDefs["i0"] = &IndexDef{Name: "i0", Type: "testCold"}
+	indexDefs.IndexDefs["i1"] = &IndexDef{Name: "i1", Type: "blackhole"}
+
+	err = mgr.OpenColdIndexes("b0", "s3://bkt/paused", client, "bkt",
+		"paused", indexDefs)
+	if err != ErrHibernationColdQueryDisabled {
+		t.Fatalf("expected a disabled err, got: %v", err)
+	}
+
+	mgr.SetOptions(map[string]string{HIBERNATION_COLD_QUERY_OPTION: "true"})
+
+	err = mgr.OpenColdIndexes("b0", "s3://bkt/paused", client, "bkt",
+		"paused", indexDefs)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	if cis := mgr.ColdIndexes(); len(cis) != 2 || cis[0].Bucket != "b0" {
+		t.Fatalf("unexpected cold indexes: %+v", cis)
+	}
+
+	var res bytes.Buffer
+	err = mgr.QueryColdIndex("i0", []byte("i0/seg0"), &res)
+	if err != nil || res.String() != "hits" {
+		t.Fatalf("unexpected res: %q, err: %v", res.String(), err)
+	}
+	if stats := mgr.ColdCacheStats(); stats == nil || stats.Objects != 1 {
+		t.Errorf("unexpected cache stats: %+v", stats)
+	}
+
+	err = mgr.QueryColdIndex("i1", nil, &res)
+	if err != ErrHibernationColdQueryNotSupported {
+		t.Errorf("expected an unsupported err, got: %v", err)
+	}
+
+	// The same indexes can't be cold for another bucket.
+	err = mgr.OpenColdIndexes("b1", "s3://bkt/other", client, "bkt",
+		"other", indexDefs)
+	if err == nil {
+		t.Errorf("expected an err for the indexes of another bucket")
+	}
+
+	mgr.CloseColdIndexes("b0")
+
+	if err = mgr.QueryColdIndex("i0", nil, &res); err == nil {
+		t.Errorf("expected an err after close")
+	}
+
+	// Live indexes aren't opened as cold.
+	_, err = CfgSetIndexDefs(cfg, indexDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	err = mgr.OpenColdIndexes("b0", "s3://bkt/paused", client, "bkt",
+		"paused", indexDefs)
+	if err == nil {
+		t.Errorf("expected an err for live indexes")
+	}
+}
@@ -0,0 +1,464 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/couchbase/clog"
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// HIBERNATION_COMPRESSION_OPTION is the manager option that holds the
+// name of the compressor (e.g., "snappy", "gzip" or "zstd") of the
+// data that's uploaded during a pause, where "" or "none" means no
+// compression.
+const HIBERNATION_COMPRESSION_OPTION = "hibernationCompression"
+
+// A HibernationCompressor is a streaming compression codec for the
+// hibernated data.
+type HibernationCompressor struct {
+	Name      string
+	NewWriter func(w io.Writer) io.WriteCloser
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var hibernationCompressorsM sync.Mutex
+
+// Keyed by compressor name.
+var hibernationCompressors = map[string]*HibernationCompressor{}
+
+// RegisterHibernationCompressor allows applications to register
+// additional compressors, such as lz4.
+func RegisterHibernationCompressor(c *HibernationCompressor) {
+	hibernationCompressorsM.Lock()
+	hibernationCompressors[c.Name] = c
+	hibernationCompressorsM.Unlock()
+}
+
+// GetHibernationCompressor returns the registered compressor of the
+// given name, or nil.
+func GetHibernationCompressor(name string) *HibernationCompressor {
+	hibernationCompressorsM.Lock()
+	defer hibernationCompressorsM.Unlock()
+	return hibernationCompressors[name]
+}
+
+func init() {
+	RegisterHibernationCompressor(&HibernationCompressor{
+		Name: "snappy",
+		NewWriter: func(w io.Writer) io.WriteCloser {
+			return snappy.NewBufferedWriter(w)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(snappy.NewReader(r)), nil
+		},
+	})
+
+	RegisterHibernationCompressor(&HibernationCompressor{
+		Name: "gzip",
+		NewWriter: func(w io.Writer) io.WriteCloser {
+			return gzip.NewWriter(w)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	})
+
+	RegisterHibernationCompressor(&HibernationCompressor{
+		Name: "zstd",
+		NewWriter: func(w io.Writer) io.WriteCloser {
+			// Only fails on invalid options.
+			enc, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+			return enc
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		},
+	})
+}
+
+// Each upload (e.g., each part of a multipart upload) of a compressed
+// object is a segment of the hibernationCompressionMagic, the length
+// of the compressor name, the compressor name and the big-endian
+// length of the compressed data, followed by the compressed data.  The
+// objects that don't start with the magic are uncompressed, such as
+// those of buckets that were paused without compression.
+var hibernationCompressionMagic = []byte("\x00CBGTZ\x00")
+
+// HibernationCompressionStats are the cumulative byte counts of a
+// compressing client's uploads.
+type HibernationCompressionStats struct {
+	Compressor        string  `json:"compressor"`
+	UncompressedBytes uint64  `json:"uncompressedBytes"`
+	CompressedBytes   uint64  `json:"compressedBytes"`
+	Ratio             float64 `json:"ratio"` // Uncompressed / compressed.
+}
+
+// A CompressedObjStoreClient is an object store client that compresses
+// the uploaded objects and transparently decompresses the downloaded
+// objects.  The object sizes reported by the object store (e.g., by
+// GetObjectAttrs) are of the compressed objects.
+type CompressedObjStoreClient struct {
+	objcli.Client
+
+	compressor *HibernationCompressor
+
+	uncompressedBytes uint64 // Atomic.
+	compressedBytes   uint64 // Atomic.
+}
+
+// NewCompressedObjStoreClient returns a client that compresses the
+// objects of the given client with the named compressor.
+func NewCompressedObjStoreClient(client objcli.Client,
+	compressorName string) (*CompressedObjStoreClient, error) {
+	c := GetHibernationCompressor(compressorName)
+	if c == nil {
+		return nil, fmt.Errorf("hibernation_compression: unknown"+
+			" compressor: %s", compressorName)
+	}
+	if len(c.Name) > 255 {
+		return nil, fmt.Errorf("hibernation_compression: compressor name"+
+			" too long: %s", c.Name)
+	}
+
+	return &CompressedObjStoreClient{Client: client, compressor: c}, nil
+}
+
+// Stats returns the compression stats of the client's uploads.
+func (c *CompressedObjStoreClient) Stats() *HibernationCompressionStats {
+	rv := &HibernationCompressionStats{
+		Compressor:        c.compressor.Name,
+		UncompressedBytes: atomic.LoadUint64(&c.uncompressedBytes),
+		CompressedBytes:   atomic.LoadUint64(&c.compressedBytes),
+	}
+	if rv.CompressedBytes > 0 {
+		rv.Ratio = float64(rv.UncompressedBytes) / float64(rv.CompressedBytes)
+	}
+	return rv
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += uint64(n)
+	return n, err
+}
+
+// compress writes a segment of the compressed body into a temp file,
+// as the segment header needs the compressed length.  The returned
+// cleanup func closes and removes the temp file.
+func (c *CompressedObjStoreClient) compress(body io.Reader) (
+	*os.File, func(), error) {
+	f, err := os.CreateTemp("", "cbgt-hibernation-cmp-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("hibernation_compression: temp file,"+
+			" err: %v", err)
+	}
+
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	name := c.compressor.Name
+
+	headerLen := int64(len(hibernationCompressionMagic) + 1 + len(name) + 8)
+
+	cr := &countingReader{r: body}
+
+	_, err = f.Seek(headerLen, io.SeekStart)
+	if err == nil {
+		w := c.compressor.NewWriter(f)
+		_, err = io.Copy(w, cr)
+		if err2 := w.Close(); err == nil {
+			err = err2
+		}
+	}
+
+	var end int64
+	if err == nil {
+		end, err = f.Seek(0, io.SeekCurrent)
+	}
+
+	if err == nil {
+		header := make([]byte, 0, headerLen)
+		header = append(header, hibernationCompressionMagic...)
+		header = append(header, byte(len(name)))
+		header = append(header, name...)
+		header = binary.BigEndian.AppendUint64(header, uint64(end-headerLen))
+
+		_, err = f.WriteAt(header, 0)
+	}
+
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("hibernation_compression: compress,"+
+			" err: %v", err)
+	}
+
+	atomic.AddUint64(&c.uncompressedBytes, cr.n)
+	atomic.AddUint64(&c.compressedBytes, uint64(end))
+
+	return f, cleanup, nil
+}
+
+// ------------------------------------------------------------------------
+
+// decompressReader streams the decompressed segments of an object.
+type decompressReader struct {
+	body io.ReadCloser
+	br   *bufio.Reader
+	cur  io.ReadCloser // Decompressor of the current segment.
+	rest *io.LimitedReader
+}
+
+func newDecompressReader(body io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(body)
+
+	// Objects without the magic were uploaded without compression.
+	magic, err := br.Peek(len(hibernationCompressionMagic))
+	if err != nil || !bytes.Equal(magic, hibernationCompressionMagic) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, body}, nil
+	}
+
+	return &decompressReader{body: body, br: br}, nil
+}
+
+func (r *decompressReader) nextSegment() error {
+	header := make([]byte, len(hibernationCompressionMagic)+1)
+	_, err := io.ReadFull(r.br, header)
+	if err != nil {
+		return err // Including io.EOF at the end of the last segment.
+	}
+	if !bytes.Equal(header[:len(hibernationCompressionMagic)],
+		hibernationCompressionMagic) {
+		return fmt.Errorf("hibernation_compression: corrupt segment header")
+	}
+
+	rest := make([]byte, int(header[len(header)-1])+8)
+	_, err = io.ReadFull(r.br, rest)
+	if err != nil {
+		return fmt.Errorf("hibernation_compression: truncated segment"+
+			" header, err: %v", err)
+	}
+
+	name := string(rest[:len(rest)-8])
+	c := GetHibernationCompressor(name)
+	if c == nil {
+		return fmt.Errorf("hibernation_compression: unknown"+
+			" compressor: %s", name)
+	}
+
+	r.rest = &io.LimitedReader{
+		R: r.br,
+		N: int64(binary.BigEndian.Uint64(rest[len(rest)-8:])),
+	}
+
+	r.cur, err = c.NewReader(r.rest)
+	if err != nil {
+		return fmt.Errorf("hibernation_compression: compressor: %s,"+
+			" err: %v", name, err)
+	}
+
+	return nil
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			err := r.nextSegment()
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if r.rest.N > 0 {
+				return n, fmt.Errorf("hibernation_compression: %d trailing"+
+					" bytes in segment", r.rest.N)
+			}
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *decompressReader) Close() error {
+	if r.cur != nil {
+		r.cur.Close()
+	}
+	return r.body.Close()
+}
+
+// ------------------------------------------------------------------------
+
+// GetObject downloads and decompresses an object, where a byte range is
+// applied to the decompressed object, as the compressed data doesn't
+// map onto uncompressed offsets.
+func (c *CompressedObjStoreClient) GetObject(ctx context.Context,
+	bucket, key string, br *objval.ByteRange) (*objval.Object, error) {
+	obj, err := c.Client.GetObject(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dr, err := newDecompressReader(obj.Body)
+	if err != nil {
+		obj.Body.Close()
+		return nil, err
+	}
+	obj.Body = dr
+
+	if br != nil {
+		_, err = io.CopyN(io.Discard, dr, br.Start)
+		if err != nil {
+			dr.Close()
+			return nil, fmt.Errorf("hibernation_compression: byte range,"+
+				" key: %s, err: %v", key, err)
+		}
+
+		var r io.Reader = dr
+		if br.End != 0 {
+			r = io.LimitReader(dr, br.End-br.Start+1)
+		}
+		obj.Body = rangeReadCloser{Reader: r, Closer: dr}
+	}
+
+	return obj, nil
+}
+
+func (c *CompressedObjStoreClient) PutObject(ctx context.Context,
+	bucket, key string, body io.ReadSeeker) error {
+	f, cleanup, err := c.compress(body)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return c.Client.PutObject(ctx, bucket, key, f)
+}
+
+func (c *CompressedObjStoreClient) AppendToObject(ctx context.Context,
+	bucket, key string, data io.ReadSeeker) error {
+	f, cleanup, err := c.compress(data)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return c.Client.AppendToObject(ctx, bucket, key, f)
+}
+
+func (c *CompressedObjStoreClient) UploadPart(ctx context.Context,
+	bucket, id, key string, number int, body io.ReadSeeker) (objval.Part, error) {
+	f, cleanup, err := c.compress(body)
+	if err != nil {
+		return objval.Part{}, err
+	}
+	defer cleanup()
+
+	return c.Client.UploadPart(ctx, bucket, id, key, number, f)
+}
+
+// UploadPartCopy only supports copying entire objects, since a byte
+// range of a compressed object would split its segments.
+func (c *CompressedObjStoreClient) UploadPartCopy(ctx context.Context,
+	bucket, id, dst, src string, number int,
+	br *objval.ByteRange) (objval.Part, error) {
+	if br != nil {
+		return objval.Part{}, fmt.Errorf("hibernation_compression:" +
+			" byte range part copies are unsupported")
+	}
+
+	return c.Client.UploadPartCopy(ctx, bucket, id, dst, src, number, nil)
+}
+
+// ------------------------------------------------------------------------
+
+// compressHibernationClient wraps the client with a
+// CompressedObjStoreClient when the hibernationCompression option
+// names a compressor.  Downloads are always decompressed, as the
+// objects of a resume may have been compressed by a pause with a
+// different configuration.
+func (mgr *Manager) compressHibernationClient(
+	client objcli.Client) (objcli.Client, error) {
+	if client == nil {
+		return nil, nil
+	}
+
+	name := mgr.GetOption(HIBERNATION_COMPRESSION_OPTION)
+	if name == "" || name == "none" {
+		return &uploadUncompressedClient{Client: client}, nil
+	}
+
+	log.Printf("hibernation_compression: compressing hibernated data,"+
+		" compressor: %s", name)
+
+	return NewCompressedObjStoreClient(client, name)
+}
+
+// uploadUncompressedClient uploads objects as they are, but still
+// decompresses any compressed objects that are downloaded.
+type uploadUncompressedClient struct {
+	objcli.Client
+}
+
+func (c *uploadUncompressedClient) GetObject(ctx context.Context,
+	bucket, key string, br *objval.ByteRange) (*objval.Object, error) {
+	cc := &CompressedObjStoreClient{Client: c.Client}
+	return cc.GetObject(ctx, bucket, key, br)
+}
+
+// HibernationCompressionStats returns the compression stats of the
+// current hibernation's uploads, or nil when they're not compressed.
+func (mgr *Manager) HibernationCompressionStats() *HibernationCompressionStats {
+	client := mgr.GetObjStoreClient()
+	if c, ok := client.(*ChecksumObjStoreClient); ok {
+		client = c.Client
+	}
+	if c, ok := client.(*CompressedObjStoreClient); ok {
+		return c.Stats()
+	}
+	return nil
+}
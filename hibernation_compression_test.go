@@ -0,0 +1,105 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+func TestCompressedObjStoreClient(t *testing.T) {
+	ctx := context.Background()
+
+	get := func(c objcli.Client, k string, br *objval.ByteRange) ([]byte, error) {
+		obj, err := c.GetObject(ctx, "bkt", k, br)
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Body.Close()
+		return io.ReadAll(obj.Body)
+	}
+
+	plain := bytes.Repeat([]byte("index files compress well. "), 1000)
+
+	for _, name := range []string{"snappy", "gzip", "zstd"} {
+		inner := objcli.NewTestClient(t, objval.ProviderAWS)
+
+		c, err := NewCompressedObjStoreClient(inner, name)
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+
+		err = c.PutObject(ctx, "bkt", "k", bytes.NewReader(plain))
+		if err != nil {
+			t.Fatalf("compressor: %s, expected no err, got: %v", name, err)
+		}
+
+		raw, _ := get(inner, "k", nil)
+		if len(raw) >= len(plain)/4 {
+			t.Errorf("compressor: %s, expected compression, got: %d of %d",
+				name, len(raw), len(plain))
+		}
+
+		got, err := get(c, "k", nil)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("compressor: %s, expected round trip, err: %v", name, err)
+		}
+
+		// Appended segments are decompressed as a concatenation.
+		err = c.AppendToObject(ctx, "bkt", "k", bytes.NewReader([]byte("!")))
+		if err != nil {
+			t.Fatalf("compressor: %s, expected no err, got: %v", name, err)
+		}
+		got, err = get(c, "k", &objval.ByteRange{Start: int64(len(plain) - 3)})
+		if err != nil || string(got) != "l. !" {
+			t.Fatalf("compressor: %s, expected byte range, got: %q, err: %v",
+				name, got, err)
+		}
+
+		stats := c.Stats()
+		if stats.Compressor != name || stats.Ratio <= 1 ||
+			stats.UncompressedBytes != uint64(len(plain)+1) {
+			t.Errorf("compressor: %s, unexpected stats: %+v", name, stats)
+		}
+
+		// Uncompressed objects are downloaded as they are.
+		err = inner.PutObject(ctx, "bkt", "plain", bytes.NewReader(plain))
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+		got, err = get(c, "plain", nil)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("compressor: %s, expected passthrough, err: %v", name, err)
+		}
+	}
+
+	_, err := NewCompressedObjStoreClient(nil, "unknown")
+	if err == nil {
+		t.Errorf("expected err on an unknown compressor")
+	}
+
+	// Compression composes with encryption.
+	inner := objcli.NewTestClient(t, objval.ProviderAWS)
+	enc, _ := NewEncryptedObjStoreClient(inner, bytes.Repeat([]byte{1}, 32))
+	c, _ := NewCompressedObjStoreClient(enc, "snappy")
+
+	err = c.PutObject(ctx, "bkt", "k", bytes.NewReader(plain))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	got, err := get(c, "k", nil)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("expected compressed and encrypted round trip, err: %v", err)
+	}
+}
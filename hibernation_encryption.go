@@ -0,0 +1,607 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/couchbase/clog"
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objerr"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+// HIBERNATION_ENCRYPTION_KEY_FILE_OPTION is the manager option that
+// holds the path of a file with the AES-256 key (32 raw bytes, or
+// their base64 encoding) used to encrypt the hibernated data.  The key
+// itself is purposefully not a manager option, as the options are
+// logged and persisted in the Cfg.
+const HIBERNATION_ENCRYPTION_KEY_FILE_OPTION = "hibernationEncryptionKeyFile"
+
+// HibernationEncryptionKeyHook is an optional, pluggable callback that
+// allows applications to provide the AES-256 data key for hibernation,
+// such as a data key that's unwrapped by a KMS (envelope encryption).
+// When it returns a nil key, the hibernationEncryptionKeyFile option
+// is used instead.
+var HibernationEncryptionKeyHook func(mgr *Manager) ([]byte, error)
+
+// HibernationEncryptionFrameSize is the max number of plaintext bytes
+// of each encrypted frame of an object.
+var HibernationEncryptionFrameSize = 1024 * 1024
+
+// Each frame of an encrypted object is a header of the
+// hibernationEncryptionMagic, a flags byte, and the big-endian length
+// of the sealed data, part number and frame number, followed by a
+// nonce and the sealed data.  The header and the object key are the
+// additional authenticated data of the frame, so that frames can't be
+// reordered, or spliced between parts or objects.  The frames of each
+// part are numbered from 0, where the last frame of each part is
+// flagged as final, and the last frame of the object is also flagged
+// as last, so that truncated objects are detected.  The part number
+// of a single upload is 0, and those of a multipart upload go from 1.
+var hibernationEncryptionMagic = []byte("CBE")
+
+const hibernationEncryptionHeaderLen = 16
+const hibernationEncryptionFlagFinal = byte(0x01)
+const hibernationEncryptionFlagLast = byte(0x02)
+
+// ErrHibernationDecrypt is returned when hibernated data cannot be
+// decrypted, such as when it's unencrypted or was encrypted with a
+// different key.
+var ErrHibernationDecrypt = fmt.Errorf("hibernation_encryption: decrypt failed")
+
+// An EncryptedObjStoreClient is an object store client that encrypts
+// the uploaded objects with AES-256-GCM before they leave the node,
+// and decrypts the downloaded objects, so that plaintext index data is
+// never stored in the remote object store.  The object sizes reported
+// by the object store (e.g., by GetObjectAttrs) are of the encrypted
+// objects.
+type EncryptedObjStoreClient struct {
+	objcli.Client
+
+	aead cipher.AEAD
+
+	m       sync.Mutex
+	uploads map[string]*encryptedUpload // Keyed by multipart upload id.
+}
+
+// An encryptedUpload tracks the highest numbered part of a multipart
+// upload, whose plaintext is kept so that the part can be uploaded
+// again as the last part of the object when the upload is completed.
+type encryptedUpload struct {
+	number int
+	plain  string // The path of the part's plaintext temp file.
+}
+
+// NewEncryptedObjStoreClient returns a client that encrypts the objects
+// of the given client with the 32 byte AES-256 key.
+func NewEncryptedObjStoreClient(client objcli.Client,
+	key []byte) (*EncryptedObjStoreClient, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("hibernation_encryption: key must be 32 bytes"+
+			" for AES-256, got: %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("hibernation_encryption: cipher, err: %v", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("hibernation_encryption: gcm, err: %v", err)
+	}
+
+	return &EncryptedObjStoreClient{
+		Client:  client,
+		aead:    aead,
+		uploads: map[string]*encryptedUpload{},
+	}, nil
+}
+
+// encrypt writes the encrypted frames of a part of the object key into
+// a temp file, so that large pindex files needn't be held in memory.
+// The returned cleanup func closes and removes the temp file.
+func (c *EncryptedObjStoreClient) encrypt(body io.Reader, key string,
+	part int, last bool) (*os.File, func(), error) {
+	f, err := os.CreateTemp("", "cbgt-hibernation-enc-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("hibernation_encryption: temp file,"+
+			" err: %v", err)
+	}
+
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	err = c.encryptTo(f, body, key, part, last)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("hibernation_encryption: encrypt,"+
+			" err: %v", err)
+	}
+
+	return f, cleanup, nil
+}
+
+func (c *EncryptedObjStoreClient) encryptTo(w io.Writer, body io.Reader,
+	key string, part int, last bool) error {
+	br := bufio.NewReaderSize(body, HibernationEncryptionFrameSize)
+	plain := make([]byte, HibernationEncryptionFrameSize)
+	nonce := make([]byte, c.aead.NonceSize())
+
+	for frame := uint32(0); ; frame++ {
+		n, err := io.ReadFull(br, plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		var flags byte
+		if _, err = br.Peek(1); err == io.EOF {
+			flags = hibernationEncryptionFlagFinal
+			if last {
+				flags |= hibernationEncryptionFlagLast
+			}
+		} else if err != nil {
+			return err
+		}
+
+		_, err = rand.Read(nonce)
+		if err != nil {
+			return err
+		}
+
+		header := make([]byte, hibernationEncryptionHeaderLen)
+		copy(header, hibernationEncryptionMagic)
+		header[3] = flags
+		binary.BigEndian.PutUint32(header[4:],
+			uint32(len(nonce)+n+c.aead.Overhead()))
+		binary.BigEndian.PutUint32(header[8:], uint32(part))
+		binary.BigEndian.PutUint32(header[12:], frame)
+
+		sealed := c.aead.Seal(nil, nonce, plain[:n],
+			hibernationEncryptionAD(header, key))
+
+		for _, b := range [][]byte{header, nonce, sealed} {
+			_, err = w.Write(b)
+			if err != nil {
+				return err
+			}
+		}
+
+		if flags&hibernationEncryptionFlagFinal != 0 {
+			return nil
+		}
+	}
+}
+
+// ------------------------------------------------------------------------
+
+// hibernationEncryptionAD returns the additional authenticated data of
+// a frame of the object key.
+func hibernationEncryptionAD(header []byte, key string) []byte {
+	ad := make([]byte, 0, len(header)+len(key))
+	ad = append(ad, header...)
+	return append(ad, key...)
+}
+
+// decryptReader streams the plaintext of an encrypted object, checking
+// that its frames are those of the object key in their order.
+type decryptReader struct {
+	body  io.ReadCloser
+	aead  cipher.AEAD
+	key   string
+	plain []byte // Decrypted, but not yet read.
+	read  bool   // True once a frame was read.
+	flags byte   // Of the last frame read.
+	part  uint32 // Of the last frame read.
+	frame uint32 // Of the last frame read.
+	err   error
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.readFrame()
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *decryptReader) readFrame() error {
+	header := make([]byte, hibernationEncryptionHeaderLen)
+	_, err := io.ReadFull(r.body, header)
+	if err == io.EOF && r.flags&hibernationEncryptionFlagLast != 0 {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("%w: truncated, err: %v", ErrHibernationDecrypt, err)
+	}
+	if r.flags&hibernationEncryptionFlagLast != 0 {
+		return fmt.Errorf("%w: data after the last frame", ErrHibernationDecrypt)
+	}
+
+	if !bytes.Equal(header[:3], hibernationEncryptionMagic) {
+		return fmt.Errorf("%w: not encrypted", ErrHibernationDecrypt)
+	}
+
+	// The frames are numbered from 0 in each part, and the parts are
+	// numbered from 0 or 1 in turn.
+	part := binary.BigEndian.Uint32(header[8:])
+	frame := binary.BigEndian.Uint32(header[12:])
+
+	expPart, expFrame := r.part, r.frame+1
+	if !r.read {
+		expPart, expFrame = part, 0
+		if part > 1 {
+			expPart = 1
+		}
+	} else if r.flags&hibernationEncryptionFlagFinal != 0 {
+		expPart, expFrame = r.part+1, 0
+	}
+	if part != expPart || frame != expFrame {
+		return fmt.Errorf("%w: out of order frame, part: %d, frame: %d,"+
+			" expected part: %d, frame: %d", ErrHibernationDecrypt,
+			part, frame, expPart, expFrame)
+	}
+
+	sealedLen := int(binary.BigEndian.Uint32(header[4:]))
+	if sealedLen < r.aead.NonceSize()+r.aead.Overhead() ||
+		sealedLen > r.aead.NonceSize()+r.aead.Overhead()+
+			HibernationEncryptionFrameSize {
+		return fmt.Errorf("%w: invalid frame length: %d",
+			ErrHibernationDecrypt, sealedLen)
+	}
+
+	sealed := make([]byte, sealedLen)
+	_, err = io.ReadFull(r.body, sealed)
+	if err != nil {
+		return fmt.Errorf("%w: truncated, err: %v", ErrHibernationDecrypt, err)
+	}
+
+	nonce, sealed := sealed[:r.aead.NonceSize()], sealed[r.aead.NonceSize():]
+
+	r.plain, err = r.aead.Open(sealed[:0], nonce, sealed,
+		hibernationEncryptionAD(header, r.key))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrHibernationDecrypt, err)
+	}
+
+	r.read, r.flags, r.part, r.frame = true, header[3], part, frame
+
+	return nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.body.Close()
+}
+
+// rangeReadCloser limits the reads of a body to a byte range.
+type rangeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ------------------------------------------------------------------------
+
+// GetObject downloads and decrypts an object, where a byte range is
+// applied to the decrypted object, as the encrypted frames don't map
+// onto plaintext offsets.
+func (c *EncryptedObjStoreClient) GetObject(ctx context.Context,
+	bucket, key string, br *objval.ByteRange) (*objval.Object, error) {
+	obj, err := c.Client.GetObject(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dr := &decryptReader{body: obj.Body, aead: c.aead, key: key}
+	obj.Body = dr
+
+	if br != nil {
+		_, err = io.CopyN(io.Discard, dr, br.Start)
+		if err != nil {
+			dr.Close()
+			return nil, fmt.Errorf("hibernation_encryption: byte range,"+
+				" key: %s, err: %v", key, err)
+		}
+
+		var r io.Reader = dr
+		if br.End != 0 {
+			r = io.LimitReader(dr, br.End-br.Start+1)
+		}
+		obj.Body = rangeReadCloser{Reader: r, Closer: dr}
+	}
+
+	return obj, nil
+}
+
+func (c *EncryptedObjStoreClient) PutObject(ctx context.Context,
+	bucket, key string, body io.ReadSeeker) error {
+	f, cleanup, err := c.encrypt(body, key, 0, true)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return c.Client.PutObject(ctx, bucket, key, f)
+}
+
+// AppendToObject uploads the object again, encrypted with the data
+// appended to its decrypted content, as the frames of the appended
+// data can't follow the last frame of the object.
+func (c *EncryptedObjStoreClient) AppendToObject(ctx context.Context,
+	bucket, key string, data io.ReadSeeker) error {
+	obj, err := c.GetObject(ctx, bucket, key, nil)
+	if objerr.IsNotFoundError(err) {
+		return c.PutObject(ctx, bucket, key, data)
+	}
+	if err != nil {
+		return err
+	}
+
+	f, cleanup, err := c.encrypt(io.MultiReader(obj.Body, data), key, 0, true)
+	obj.Body.Close()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return c.Client.PutObject(ctx, bucket, key, f)
+}
+
+// UploadPart uploads an encrypted part, where the plaintext of the
+// highest numbered part so far is kept until the upload is completed
+// or aborted, see CompleteMultipartUpload.
+func (c *EncryptedObjStoreClient) UploadPart(ctx context.Context,
+	bucket, id, key string, number int, body io.ReadSeeker) (objval.Part, error) {
+	if number < 1 {
+		return objval.Part{}, fmt.Errorf("hibernation_encryption:"+
+			" invalid part number: %d", number)
+	}
+
+	err := c.keepPart(id, number, body)
+	if err != nil {
+		return objval.Part{}, err
+	}
+
+	_, err = body.Seek(0, io.SeekStart)
+	if err != nil {
+		return objval.Part{}, err
+	}
+
+	f, cleanup, err := c.encrypt(body, key, number, false)
+	if err != nil {
+		return objval.Part{}, err
+	}
+	defer cleanup()
+
+	return c.Client.UploadPart(ctx, bucket, id, key, number, f)
+}
+
+// keepPart keeps the plaintext of the part in a temp file when it's
+// the highest numbered part of the upload so far.
+func (c *EncryptedObjStoreClient) keepPart(id string, number int,
+	body io.Reader) error {
+	c.m.Lock()
+	u := c.uploads[id]
+	c.m.Unlock()
+	if u != nil && u.number > number {
+		return nil
+	}
+
+	f, err := os.CreateTemp("", "cbgt-hibernation-part-")
+	if err != nil {
+		return fmt.Errorf("hibernation_encryption: temp file, err: %v", err)
+	}
+	_, err = io.Copy(f, body)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("hibernation_encryption: keep part: %d,"+
+			" err: %v", number, err)
+	}
+
+	c.m.Lock()
+	u = c.uploads[id]
+	if u == nil || u.number < number {
+		if u != nil {
+			os.Remove(u.plain)
+		}
+		c.uploads[id] = &encryptedUpload{number: number, plain: f.Name()}
+	} else {
+		os.Remove(f.Name())
+	}
+	c.m.Unlock()
+
+	return nil
+}
+
+// takeUpload removes and returns the tracking of a multipart upload.
+func (c *EncryptedObjStoreClient) takeUpload(id string) *encryptedUpload {
+	c.m.Lock()
+	u := c.uploads[id]
+	delete(c.uploads, id)
+	c.m.Unlock()
+
+	return u
+}
+
+// UploadPartCopy only supports copying entire objects, which are
+// decrypted and encrypted again as the part, as the frames of an
+// object are bound to its key.
+func (c *EncryptedObjStoreClient) UploadPartCopy(ctx context.Context,
+	bucket, id, dst, src string, number int,
+	br *objval.ByteRange) (objval.Part, error) {
+	if br != nil {
+		return objval.Part{}, fmt.Errorf("hibernation_encryption:" +
+			" byte range part copies are unsupported")
+	}
+
+	obj, err := c.GetObject(ctx, bucket, src, nil)
+	if err != nil {
+		return objval.Part{}, err
+	}
+
+	f, err := os.CreateTemp("", "cbgt-hibernation-copy-")
+	if err != nil {
+		obj.Body.Close()
+		return objval.Part{}, fmt.Errorf("hibernation_encryption:"+
+			" temp file, err: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	_, err = io.Copy(f, obj.Body)
+	obj.Body.Close()
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return objval.Part{}, fmt.Errorf("hibernation_encryption: copy,"+
+			" src: %s, err: %v", src, err)
+	}
+
+	return c.UploadPart(ctx, bucket, id, dst, number, f)
+}
+
+// CompleteMultipartUpload uploads the highest numbered part again,
+// with its last frame flagged as the last of the object, before
+// completing the upload.
+func (c *EncryptedObjStoreClient) CompleteMultipartUpload(ctx context.Context,
+	bucket, id, key string, parts ...objval.Part) error {
+	u := c.takeUpload(id)
+	if u != nil {
+		defer os.Remove(u.plain)
+	}
+
+	last := -1
+	for i, part := range parts {
+		if last < 0 || part.Number > parts[last].Number {
+			last = i
+		}
+	}
+	if last < 0 {
+		return c.Client.CompleteMultipartUpload(ctx, bucket, id, key, parts...)
+	}
+	if u == nil || u.number != parts[last].Number {
+		return fmt.Errorf("hibernation_encryption: no plaintext of the last"+
+			" part: %d, key: %s", parts[last].Number, key)
+	}
+
+	plain, err := os.Open(u.plain)
+	if err != nil {
+		return fmt.Errorf("hibernation_encryption: last part, err: %v", err)
+	}
+	f, cleanup, err := c.encrypt(plain, key, u.number, true)
+	plain.Close()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	part, err := c.Client.UploadPart(ctx, bucket, id, key, u.number, f)
+	if err != nil {
+		return err
+	}
+
+	parts = append([]objval.Part(nil), parts...)
+	parts[last] = part
+
+	return c.Client.CompleteMultipartUpload(ctx, bucket, id, key, parts...)
+}
+
+func (c *EncryptedObjStoreClient) AbortMultipartUpload(ctx context.Context,
+	bucket, id, key string) error {
+	if u := c.takeUpload(id); u != nil {
+		os.Remove(u.plain)
+	}
+
+	return c.Client.AbortMultipartUpload(ctx, bucket, id, key)
+}
+
+// ------------------------------------------------------------------------
+
+// hibernationEncryptionKey returns the configured AES-256 key for
+// hibernation, or nil when hibernated data isn't to be encrypted.
+func (mgr *Manager) hibernationEncryptionKey() ([]byte, error) {
+	if HibernationEncryptionKeyHook != nil {
+		key, err := HibernationEncryptionKeyHook(mgr)
+		if err != nil {
+			return nil, fmt.Errorf("hibernation_encryption: key hook,"+
+				" err: %v", err)
+		}
+		if key != nil {
+			return key, nil
+		}
+	}
+
+	keyFile := mgr.GetOption(HIBERNATION_ENCRYPTION_KEY_FILE_OPTION)
+	if keyFile == "" {
+		return nil, nil
+	}
+
+	buf, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("hibernation_encryption: read key file: %s,"+
+			" err: %v", keyFile, err)
+	}
+
+	if len(buf) == 32 {
+		return buf, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return nil, fmt.Errorf("hibernation_encryption: key file: %s,"+
+			" neither 32 raw bytes nor base64, err: %v", keyFile, err)
+	}
+
+	return key, nil
+}
+
+// encryptHibernationClient wraps the client with an
+// EncryptedObjStoreClient when a hibernation encryption key is
+// configured.
+func (mgr *Manager) encryptHibernationClient(
+	client objcli.Client) (objcli.Client, error) {
+	if client == nil {
+		return nil, nil
+	}
+
+	key, err := mgr.hibernationEncryptionKey()
+	if err != nil || key == nil {
+		return client, err
+	}
+
+	log.Printf("hibernation_encryption: encrypting hibernated data")
+
+	return NewEncryptedObjStoreClient(client, key)
+}
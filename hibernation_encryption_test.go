@@ -0,0 +1,245 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+func TestEncryptedObjStoreClient(t *testing.T) {
+	prevFrameSize := HibernationEncryptionFrameSize
+	HibernationEncryptionFrameSize = 10
+	defer func() { HibernationEncryptionFrameSize = prevFrameSize }()
+
+	ctx := context.Background()
+	inner := objcli.NewTestClient(t, objval.ProviderAWS)
+
+	key := bytes.Repeat([]byte{0x42}, 32)
+	c, err := NewEncryptedObjStoreClient(inner, key)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	_, err = NewEncryptedObjStoreClient(inner, key[:16])
+	if err == nil {
+		t.Fatalf("expected err on a non AES-256 key")
+	}
+
+	get := func(c objcli.Client, k string, br *objval.ByteRange) ([]byte, error) {
+		obj, err := c.GetObject(ctx, "bkt", k, br)
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Body.Close()
+		return io.ReadAll(obj.Body)
+	}
+
+	plain := []byte("the quick brown fox jumps over the lazy dog")
+
+	for _, data := range [][]byte{plain, plain[:10], {}} {
+		err = c.PutObject(ctx, "bkt", "k", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+
+		raw, _ := get(inner, "k", nil)
+		if len(data) > 0 && bytes.Contains(raw, data) {
+			t.Fatalf("expected the stored object to be encrypted")
+		}
+
+		got, err := get(c, "k", nil)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("expected: %q, got: %q, err: %v", data, got, err)
+		}
+	}
+
+	err = c.PutObject(ctx, "bkt", "k", bytes.NewReader(plain))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	got, err := get(c, "k", &objval.ByteRange{Start: 4, End: 14})
+	if err != nil || string(got) != "quick brown" {
+		t.Fatalf("expected byte range, got: %q, err: %v", got, err)
+	}
+
+	// Appends are decrypted as a concatenation.
+	err = c.AppendToObject(ctx, "bkt", "k", bytes.NewReader([]byte("!")))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	got, err = get(c, "k", nil)
+	if err != nil || string(got) != string(plain)+"!" {
+		t.Fatalf("expected appended data, got: %q, err: %v", got, err)
+	}
+
+	// A different key, unencrypted or truncated objects fail to decrypt.
+	other, _ := NewEncryptedObjStoreClient(inner,
+		bytes.Repeat([]byte{0x24}, 32))
+	_, err = get(other, "k", nil)
+	if !errors.Is(err, ErrHibernationDecrypt) {
+		t.Fatalf("expected decrypt err with another key, got: %v", err)
+	}
+
+	err = inner.PutObject(ctx, "bkt", "plain", bytes.NewReader(plain))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	_, err = get(c, "plain", nil)
+	if !errors.Is(err, ErrHibernationDecrypt) {
+		t.Fatalf("expected decrypt err for plaintext, got: %v", err)
+	}
+
+	raw, _ := get(inner, "k", nil)
+	err = inner.PutObject(ctx, "bkt", "truncated",
+		bytes.NewReader(raw[:len(raw)/2]))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	_, err = get(c, "truncated", nil)
+	if !errors.Is(err, ErrHibernationDecrypt) {
+		t.Fatalf("expected decrypt err for truncated object, got: %v", err)
+	}
+}
+
+// testEncryptedFrames splits an encrypted object into its frames.
+func testEncryptedFrames(raw []byte) [][]byte {
+	var frames [][]byte
+	for len(raw) > 0 {
+		n := hibernationEncryptionHeaderLen +
+			int(binary.BigEndian.Uint32(raw[4:8]))
+		frames = append(frames, raw[:n])
+		raw = raw[n:]
+	}
+	return frames
+}
+
+func TestEncryptedObjStoreClientTampering(t *testing.T) {
+	prevFrameSize := HibernationEncryptionFrameSize
+	HibernationEncryptionFrameSize = 10
+	defer func() { HibernationEncryptionFrameSize = prevFrameSize }()
+
+	ctx := context.Background()
+	inner := objcli.NewTestClient(t, objval.ProviderAWS)
+
+	c, err := NewEncryptedObjStoreClient(inner, bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	get := func(k string) ([]byte, error) {
+		obj, err := c.GetObject(ctx, "bkt", k, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Body.Close()
+		return io.ReadAll(obj.Body)
+	}
+
+	raw := func(k string) []byte {
+		obj, err := inner.GetObject(ctx, "bkt", k, nil)
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+		defer obj.Body.Close()
+		b, _ := io.ReadAll(obj.Body)
+		return b
+	}
+
+	plain := []byte("the quick brown fox jumps over the lazy dog")
+
+	// A multipart upload, with parts uploaded out of order.
+	id, err := c.CreateMultipartUpload(ctx, "bkt", "mp")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	chunks := [][]byte{plain[:20], plain[20:35], plain[35:]}
+	parts := make([]objval.Part, len(chunks))
+	for _, i := range []int{2, 0, 1} {
+		parts[i], err = c.UploadPart(ctx, "bkt", id, "mp", i+1,
+			bytes.NewReader(chunks[i]))
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+	err = c.CompleteMultipartUpload(ctx, "bkt", id, "mp", parts...)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	got, err := get("mp")
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("expected: %q, got: %q, err: %v", plain, got, err)
+	}
+	if len(c.uploads) != 0 {
+		t.Errorf("expected the kept parts to be removed")
+	}
+
+	err = c.PutObject(ctx, "bkt", "k", bytes.NewReader(plain))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	err = c.PutObject(ctx, "bkt", "k2", bytes.NewReader(plain))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	frames := testEncryptedFrames(raw("k"))
+	mpFrames := testEncryptedFrames(raw("mp"))
+
+	join := func(frames ...[]byte) []byte { return bytes.Join(frames, nil) }
+
+	for name, tampered := range map[string][]byte{
+		"reordered": join(append([][]byte{frames[1], frames[0]},
+			frames[2:]...)...),
+		"truncated frame":  join(frames[:len(frames)-1]...),
+		"dropped frame":    join(append([][]byte{frames[0]}, frames[2:]...)...),
+		"duplicated frame": join(append([][]byte{frames[0]}, frames...)...),
+		"trailing frame":   join(append(frames, frames[len(frames)-1])...),
+		// The first 2 frames are part 1, the next 2 are part 2.
+		"truncated parts": join(mpFrames[:4]...),
+		"reordered parts": join(append(append(append([][]byte{},
+			mpFrames[2:4]...), mpFrames[:2]...), mpFrames[4:]...)...),
+		"dropped part": join(append(append([][]byte{},
+			mpFrames[:2]...), mpFrames[4:]...)...),
+		// The frames of k2 are bound to k2, not k.
+		"spliced": raw("k2"),
+	} {
+		err = inner.PutObject(ctx, "bkt", "k", bytes.NewReader(tampered))
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+		got, err = get("k")
+		if !errors.Is(err, ErrHibernationDecrypt) {
+			t.Errorf("%s: expected decrypt err, got: %q, err: %v",
+				name, got, err)
+		}
+	}
+
+	// An aborted upload removes its kept part.
+	id, err = c.CreateMultipartUpload(ctx, "bkt", "ab")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	_, err = c.UploadPart(ctx, "bkt", id, "ab", 1, bytes.NewReader(plain))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	err = c.AbortMultipartUpload(ctx, "bkt", id, "ab")
+	if err != nil || len(c.uploads) != 0 {
+		t.Errorf("expected the kept part to be removed, err: %v", err)
+	}
+}
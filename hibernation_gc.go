@@ -0,0 +1,203 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/couchbase/clog"
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+// A HibernationGCResult describes a garbage collection of the orphaned
+// artifacts of a hibernation remote path.
+type HibernationGCResult struct {
+	RemotePath string `json:"remotePath"`
+	DryRun     bool   `json:"dryRun"`
+
+	// Scanned is the number of objects under the remote path.
+	Scanned int `json:"scanned"`
+
+	// Orphaned are the keys of the objects whose uploads never
+	// completed, which are removed unless it's a dry run.
+	Orphaned []string `json:"orphaned"`
+	Removed  int      `json:"removed"`
+}
+
+// GCHibernationRemotePath removes the orphaned artifacts, such as the
+// partial uploads of a canceled pause, of a remote path that's not in
+// use by any index.  See GCHibernationArtifacts.
+func (mgr *Manager) GCHibernationRemotePath(remotePath, region string,
+	dryRun bool) (*HibernationGCResult, error) {
+	bs, bucket, keyPrefix, err := ParseBlobStoreRemotePath(remotePath)
+	if err != nil {
+		return nil, NewBadRequestError("hibernation_gc: %v", err)
+	}
+
+	inUse, err := mgr.hibernationRemotePathInUse(bucket, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if inUse != "" {
+		return nil, NewBadRequestError("hibernation_gc: remotePath: %s,"+
+			" is in use by index: %s", remotePath, inUse)
+	}
+
+	client, err := bs.NewClient(region)
+	if err != nil {
+		return nil, fmt.Errorf("hibernation_gc: unable to get object store"+
+			" client: %v", err)
+	}
+
+	// The checksum manifests are read as they were written, so
+	// through the same encryption and compression as the uploads.
+	client, err = mgr.encryptHibernationClient(client)
+	if err != nil {
+		return nil, err
+	}
+	client, err = mgr.compressHibernationClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return mgr.GCHibernationArtifacts(context.Background(), client,
+		remotePath, dryRun)
+}
+
+// hibernationRemotePathInUse returns the name of an index whose
+// hibernation path is the bucket and key prefix, as it's being paused
+// or resumed, or "" when there's none.
+func (mgr *Manager) hibernationRemotePathInUse(bucket, keyPrefix string) (
+	string, error) {
+	if mgr.cfg == nil {
+		return "", nil
+	}
+
+	indexDefs, _, err := CfgGetIndexDefs(mgr.cfg)
+	if err != nil || indexDefs == nil {
+		return "", err
+	}
+
+	for _, indexDef := range indexDefs.IndexDefs {
+		if indexDef.HibernationPath == "" {
+			continue
+		}
+		_, b, k, err := ParseBlobStoreRemotePath(indexDef.HibernationPath)
+		if err == nil && b == bucket && k == keyPrefix {
+			return indexDef.Name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// GCHibernationArtifacts removes the objects under a remote path whose
+// uploads never completed, as found by comparing the objects against
+// the checksum manifests of the nodes, which record every completed
+// upload including the index metadata.  A remote path without checksum
+// manifests is left alone, as its complete and incomplete uploads
+// can't be told apart.  Multipart uploads that were never completed
+// aren't listed as objects, so they're left to the object store's
+// lifecycle rules.
+func (mgr *Manager) GCHibernationArtifacts(ctx context.Context,
+	client objcli.Client, remotePath string, dryRun bool) (
+	*HibernationGCResult, error) {
+	if client == nil {
+		return nil, fmt.Errorf("hibernation_gc: no object store client")
+	}
+
+	_, bucket, keyPrefix, err := ParseBlobStoreRemotePath(remotePath)
+	if err != nil {
+		return nil, NewBadRequestError("hibernation_gc: %v", err)
+	}
+
+	if c, ok := client.(*ChecksumObjStoreClient); ok {
+		// This node's completed uploads may not be flushed yet.
+		err = c.Flush(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("hibernation_gc: flush manifest,"+
+				" err: %v", err)
+		}
+	}
+
+	manifestPrefix := keyPrefix + "/" + HIBERNATION_CHECKSUMS_DIR + "/"
+
+	completed := map[string]bool{}
+	numManifests := 0
+
+	err = client.IterateObjects(ctx, bucket, manifestPrefix, "",
+		nil, nil, func(attrs *objval.ObjectAttrs) error {
+			data, err := BlobStoreGet(ctx, client, bucket, attrs.Key)
+			if err != nil {
+				return err
+			}
+			m := &HibernationChecksumManifest{}
+			err = UnmarshalJSON(data, m)
+			if err != nil {
+				return fmt.Errorf("hibernation_gc: manifest: %s,"+
+					" err: %v", attrs.Key, err)
+			}
+			for key := range m.Objects {
+				completed[key] = true
+			}
+			numManifests++
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	if numManifests == 0 {
+		return nil, NewBadRequestError("hibernation_gc: remotePath: %s,"+
+			" has no checksum manifests", remotePath)
+	}
+
+	rv := &HibernationGCResult{
+		RemotePath: remotePath,
+		DryRun:     dryRun,
+		Orphaned:   []string{},
+	}
+
+	err = client.IterateObjects(ctx, bucket, keyPrefix+"/", "",
+		nil, nil, func(attrs *objval.ObjectAttrs) error {
+			rv.Scanned++
+			if !strings.HasPrefix(attrs.Key, manifestPrefix) &&
+				!completed[attrs.Key] {
+				rv.Orphaned = append(rv.Orphaned, attrs.Key)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(rv.Orphaned)
+
+	if dryRun || len(rv.Orphaned) == 0 {
+		return rv, nil
+	}
+
+	err = client.DeleteObjects(ctx, bucket, rv.Orphaned...)
+	if err != nil {
+		return nil, fmt.Errorf("hibernation_gc: remotePath: %s,"+
+			" delete err: %v", remotePath, err)
+	}
+	rv.Removed = len(rv.Orphaned)
+
+	atomic.AddUint64(&mgr.stats.TotHibernationGCRemoved, uint64(rv.Removed))
+
+	log.Printf("hibernation_gc: remotePath: %s, removed %d orphaned"+
+		" objects of %d", remotePath, rv.Removed, rv.Scanned)
+
+	return rv, nil
+}
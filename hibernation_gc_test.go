@@ -0,0 +1,107 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+func TestGCHibernationRemotePath(t *testing.T) {
+	ctx := context.Background()
+
+	client := objcli.NewTestClient(t, objval.ProviderAWS)
+
+	prevHook := S3BlobStore.ClientHook
+	S3BlobStore.ClientHook = func(region string) (objcli.Client, error) {
+		return client, nil
+	}
+	defer func() { S3BlobStore.ClientHook = prevHook }()
+
+	cfg := NewCfgMem()
+	mgr := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	remotePath := "s3://bkt/paused"
+
+	put := func(c objcli.Client, key string) {
+		err := c.PutObject(ctx, "bkt", key, bytes.NewReader([]byte(key)))
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+
+	// Nothing is removed without checksum manifests.
+	put(client, "paused/p0/partial")
+	_, err := mgr.GCHibernationRemotePath(remotePath, "", false)
+	if err == nil {
+		t.Fatalf("expected an err without manifests")
+	}
+
+	// The completed uploads are recorded in the manifests.
+	checksummed := NewChecksumObjStoreClient(client, "bkt", "paused", "n0")
+	put(checksummed, "paused/index-metadata")
+	put(checksummed, "paused/p0/done")
+	if err = checksummed.Flush(ctx); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	put(client, "paused/p1/partial")
+	put(client, "other/p0/partial")
+
+	rv, err := mgr.GCHibernationRemotePath(remotePath, "", true)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	exp := []string{"paused/p0/partial", "paused/p1/partial"}
+	if !reflect.DeepEqual(rv.Orphaned, exp) || rv.Removed != 0 ||
+		rv.Scanned != 5 {
+		t.Fatalf("unexpected dry run: %+v", rv)
+	}
+
+	// The remote path of an index that's being paused is in use.
+	indexDefs := NewIndexDefs(VERSION)
+	indexDefs.IndexDefs["idx"] = &IndexDef{
+		Name: "idx", HibernationPath: "hibernate:" + remotePath,
+	}
+	cas, err := CfgSetIndexDefs(cfg, indexDefs, 0)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if _, err = mgr.GCHibernationRemotePath(remotePath, "", false); err == nil {
+		t.Fatalf("expected an err for a remote path in use")
+	}
+	indexDefs.IndexDefs["idx"].HibernationPath = ""
+	_, err = CfgSetIndexDefs(cfg, indexDefs, cas)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	rv, err = mgr.GCHibernationRemotePath(remotePath, "", false)
+	if err != nil || rv.Removed != 2 {
+		t.Fatalf("expected removed orphans, got: %+v, err: %v", rv, err)
+	}
+
+	for key, exists := range map[string]bool{
+		"paused/index-metadata": true,
+		"paused/p0/done":        true,
+		"paused/p0/partial":     false,
+		"paused/p1/partial":     false,
+		"other/p0/partial":      true,
+	} {
+		_, err := client.GetObjectAttrs(ctx, "bkt", key)
+		if (err == nil) != exists {
+			t.Fatalf("key: %s, expected exists: %v, err: %v", key, exists, err)
+		}
+	}
+}
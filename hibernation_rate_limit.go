@@ -0,0 +1,175 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	log "github.com/couchbase/clog"
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+// HIBERNATION_RATE_LIMIT_OPTION is the cluster option that holds the
+// JSON HibernationRateLimitOverride of the in-flight pause/resume,
+// which changes the rate limit that was given at prepare time on all
+// the nodes participating in the transfers.
+const HIBERNATION_RATE_LIMIT_OPTION = "hibernationRateLimit"
+
+// A HibernationRateLimitOverride changes the rate limit, in bytes/sec,
+// of the hibernation of the buckets that were tracked for hibernation
+// when it was set, where a RateLimit of 0 means unlimited.
+type HibernationRateLimitOverride struct {
+	BucketTaskKeys string `json:"bucketTaskKeys"`
+	RateLimit      uint64 `json:"rateLimit"`
+}
+
+// HibernationRateLimiterContextKey is the key of the hibernation
+// context's value of the *TransferRateLimiter of the hibernation
+// transfers, whose rate follows any changes to the rate limit.  The
+// "rateLimit" value of the context only holds the prepare time rate.
+const HibernationRateLimiterContextKey = "rateLimiter"
+
+// A RateLimitedObjStoreClient is an object store client whose uploads
+// and downloads are limited by a TransferRateLimiter.
+type RateLimitedObjStoreClient struct {
+	objcli.Client
+
+	limiter *TransferRateLimiter
+}
+
+// NewRateLimitedObjStoreClient returns a client whose transfers are
+// limited by the limiter.
+func NewRateLimitedObjStoreClient(client objcli.Client,
+	limiter *TransferRateLimiter) *RateLimitedObjStoreClient {
+	return &RateLimitedObjStoreClient{Client: client, limiter: limiter}
+}
+
+type rateLimitedReadSeeker struct {
+	io.ReadSeeker
+	r io.Reader
+}
+
+func (r *rateLimitedReadSeeker) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (c *RateLimitedObjStoreClient) readSeeker(ctx context.Context,
+	body io.ReadSeeker) io.ReadSeeker {
+	return &rateLimitedReadSeeker{
+		ReadSeeker: body,
+		r:          c.limiter.Reader(body, ctx.Done()),
+	}
+}
+
+func (c *RateLimitedObjStoreClient) GetObject(ctx context.Context,
+	bucket, key string, br *objval.ByteRange) (*objval.Object, error) {
+	obj, err := c.Client.GetObject(ctx, bucket, key, br)
+	if err != nil {
+		return nil, err
+	}
+
+	obj.Body = rangeReadCloser{
+		Reader: c.limiter.Reader(obj.Body, ctx.Done()),
+		Closer: obj.Body,
+	}
+
+	return obj, nil
+}
+
+func (c *RateLimitedObjStoreClient) PutObject(ctx context.Context,
+	bucket, key string, body io.ReadSeeker) error {
+	return c.Client.PutObject(ctx, bucket, key, c.readSeeker(ctx, body))
+}
+
+func (c *RateLimitedObjStoreClient) AppendToObject(ctx context.Context,
+	bucket, key string, data io.ReadSeeker) error {
+	return c.Client.AppendToObject(ctx, bucket, key, c.readSeeker(ctx, data))
+}
+
+func (c *RateLimitedObjStoreClient) UploadPart(ctx context.Context,
+	bucket, id, key string, number int, body io.ReadSeeker) (objval.Part, error) {
+	return c.Client.UploadPart(ctx, bucket, id, key, number,
+		c.readSeeker(ctx, body))
+}
+
+// ------------------------------------------------------------------------
+
+// HibernationRateLimiter returns the limiter of the current
+// hibernation's transfers, or nil when there's none.
+func (mgr *Manager) HibernationRateLimiter() *TransferRateLimiter {
+	return mgr.hibernationLimiter
+}
+
+// HibernationRateLimit returns the current rate limit, in bytes/sec,
+// of the hibernation transfers, where 0 means unlimited.
+func (mgr *Manager) HibernationRateLimit() uint64 {
+	limiter := mgr.hibernationLimiter
+	if limiter == nil || limiter.Rate() <= 0 {
+		return 0
+	}
+	return uint64(limiter.Rate())
+}
+
+// SetHibernationRateLimit changes the rate limit, in bytes/sec, of the
+// in-flight pause/resume on all its nodes, as each node applies the
+// change on its refresh of the cluster options.
+func (mgr *Manager) SetHibernationRateLimit(rateLimit uint64) error {
+	bucketTaskKeys := mgr.GetOption(bucketInHibernationKey)
+	if bucketTaskKeys == "" || bucketTaskKeys == NoBucketInHibernation {
+		return fmt.Errorf("manager: no bucket in hibernation")
+	}
+
+	buf, err := MarshalJSON(&HibernationRateLimitOverride{
+		BucketTaskKeys: bucketTaskKeys,
+		RateLimit:      rateLimit,
+	})
+	if err != nil {
+		return err
+	}
+
+	return mgr.SetOption(HIBERNATION_RATE_LIMIT_OPTION, string(buf), true)
+}
+
+// refreshHibernationRateLimit applies the current value of the
+// hibernationRateLimit option to the hibernation rate limiter, when
+// the override is for the buckets that are tracked for hibernation.
+func (mgr *Manager) refreshHibernationRateLimit(options map[string]string) {
+	limiter := mgr.hibernationLimiter
+	if limiter == nil {
+		return
+	}
+
+	v := options[HIBERNATION_RATE_LIMIT_OPTION]
+	if v == "" {
+		return
+	}
+
+	var override HibernationRateLimitOverride
+	err := UnmarshalJSON([]byte(v), &override)
+	if err != nil {
+		log.Warnf("manager: invalid option: %s, err: %v",
+			HIBERNATION_RATE_LIMIT_OPTION, err)
+		return
+	}
+
+	if override.BucketTaskKeys == "" ||
+		override.BucketTaskKeys != options[bucketInHibernationKey] {
+		return
+	}
+
+	rate := int64(override.RateLimit)
+	if limiter.Rate() != rate {
+		log.Printf("manager: hibernation rate limit set to %d bytes/sec,"+
+			" buckets: %s", rate, override.BucketTaskKeys)
+		limiter.SetRate(rate)
+	}
+}
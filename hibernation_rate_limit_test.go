@@ -0,0 +1,158 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+// testMetaKvLikeCfg is a CfgMem whose writes with a 0 CAS are
+// unconditional, as with the CfgMetaKv.
+type testMetaKvLikeCfg struct {
+	*CfgMem
+}
+
+func (c *testMetaKvLikeCfg) Set(key string, val []byte,
+	cas uint64) (uint64, error) {
+	if cas == 0 {
+		cas = CFG_CAS_FORCE
+	}
+	return c.CfgMem.Set(key, val, cas)
+}
+
+func TestHibernationRateLimit(t *testing.T) {
+	cfg := &testMetaKvLikeCfg{CfgMem: NewCfgMem()}
+
+	// The leader and another node participating in the transfers.
+	leader := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+	other := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	if err := leader.SetHibernationRateLimit(10); err == nil {
+		t.Fatalf("expected an err without a hibernation")
+	}
+
+	prepare := func(rateLimit uint64) {
+		for _, mgr := range []*Manager{leader, other} {
+			mgr.setHibernationContext(rateLimit)
+		}
+		err := leader.MarkBucketForHibernation(HIBERNATE_TASK + ":b1")
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+		other.RefreshOptions()
+	}
+
+	check := func(exp uint64) {
+		for _, mgr := range []*Manager{leader, other} {
+			if got := mgr.HibernationRateLimit(); got != exp {
+				t.Fatalf("expected rate limit: %d, got: %d", exp, got)
+			}
+			ctx, _ := mgr.GetHibernationContext()
+			if ctx.Value(HibernationRateLimiterContextKey) !=
+				mgr.HibernationRateLimiter() {
+				t.Fatalf("expected the limiter in the context")
+			}
+		}
+	}
+
+	prepare(100)
+	check(100)
+
+	// The change of the in-flight hibernation reaches all its nodes.
+	if err := leader.SetHibernationRateLimit(500); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	other.RefreshOptions()
+	check(500)
+
+	if err := leader.SetHibernationRateLimit(0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	other.RefreshOptions()
+	check(0)
+
+	// The change doesn't outlive its hibernation.
+	if err := leader.ResetBucketTrackedForHibernation(); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	other.RefreshOptions()
+
+	prepare(100)
+	leader.RefreshOptions()
+	check(100)
+}
+
+func TestRateLimitedObjStoreClient(t *testing.T) {
+	ctx := context.Background()
+
+	limiter := NewTransferRateLimiter(0)
+	c := NewRateLimitedObjStoreClient(
+		objcli.NewTestClient(t, objval.ProviderAWS), limiter)
+
+	data := []byte("hello world")
+	err := c.PutObject(ctx, "bkt", "k", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	obj, err := c.GetObject(ctx, "bkt", "k", nil)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	got, err := io.ReadAll(obj.Body)
+	obj.Body.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("expected: %s, got: %s, err: %v", data, got, err)
+	}
+
+	// Both the upload and the download went through the limiter.
+	if got := atomic.LoadUint64(&limiter.TotBytes); got != 2*uint64(len(data)) {
+		t.Fatalf("expected limited bytes: %d, got: %d", 2*len(data), got)
+	}
+}
+
+func TestHibernationClientTransferRateLimit(t *testing.T) {
+	prevClientHook := HibernationClientHook
+	HibernationClientHook = func(string) (objcli.Client, error) {
+		return objcli.NewTestClient(t, objval.ProviderAWS), nil
+	}
+	defer func() { HibernationClientHook = prevClientHook }()
+
+	mgr := NewManager(VERSION, NewCfgMem(), NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	if err := mgr.PrepareHibernationContext("", 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	data := []byte("hello world")
+	err := mgr.GetObjStoreClient().PutObject(context.Background(),
+		"bkt", "k", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	// The upload went through both the hibernation's and the node's
+	// transfer limiters.
+	for _, limiter := range []*TransferRateLimiter{
+		mgr.HibernationRateLimiter(), mgr.TransferRateLimiter()} {
+		if atomic.LoadUint64(&limiter.TotBytes) == 0 {
+			t.Fatalf("expected limited bytes")
+		}
+	}
+}
@@ -0,0 +1,173 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync/atomic"
+
+	log "github.com/couchbase/clog"
+)
+
+// The values of the "indexDedup" manager option, which controls what
+// happens when a new index definition is functionally identical to an
+// existing index definition.
+const (
+	// The default, where duplicates aren't looked for.
+	IndexDedupOff = "off"
+
+	// Strict mode, where a duplicate is only warned about and is
+	// still created.
+	IndexDedupWarn = "warn"
+
+	// The existing index is returned instead of creating a duplicate.
+	IndexDedupReuse = "reuse"
+
+	// An alias to the existing index, as built by the
+	// IndexDedupAliasFunc, is created instead of a duplicate.
+	IndexDedupAlias = "alias"
+)
+
+// IndexDedupAliasFunc, when non-nil, returns the lightweight alias
+// index definition that's created in place of the indexDef that's a
+// duplicate of the existing index definition, for the "alias" mode
+// of the "indexDedup" manager option.  When nil, the "alias" mode
+// behaves like the "reuse" mode.
+var IndexDedupAliasFunc func(mgr *Manager,
+	indexDef, existing *IndexDef) (*IndexDef, error)
+
+// IndexDefsEquivalent returns true when the index definitions would
+// build the same index, meaning that they have the same type, source,
+// params and partitioning, regardless of their names, UUIDs or labels.
+func IndexDefsEquivalent(a, b *IndexDef) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	if a.Type != b.Type ||
+		a.SourceType != b.SourceType ||
+		a.SourceName != b.SourceName {
+		return false
+	}
+
+	if a.SourceUUID != "" && b.SourceUUID != "" &&
+		a.SourceUUID != b.SourceUUID {
+		return false
+	}
+
+	if a.PlanParams.MaxPartitionsPerPIndex != b.PlanParams.MaxPartitionsPerPIndex ||
+		a.PlanParams.IndexPartitions != b.PlanParams.IndexPartitions ||
+		a.PlanParams.NumReplicas != b.PlanParams.NumReplicas {
+		return false
+	}
+
+	return jsonEquivalent(a.Params, b.Params) &&
+		jsonEquivalent(a.SourceParams, b.SourceParams)
+}
+
+// jsonEquivalent compares two JSON strings irrespective of their
+// formatting and key order, where an empty string is the same as a
+// JSON null, as seen in the index definitions read from the Cfg.
+func jsonEquivalent(a, b string) bool {
+	if a == "" {
+		a = "null"
+	}
+	if b == "" {
+		b = "null"
+	}
+	if a == b {
+		return true
+	}
+
+	var av, bv interface{}
+	if json.Unmarshal([]byte(a), &av) != nil ||
+		json.Unmarshal([]byte(b), &bv) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(av, bv)
+}
+
+// FindEquivalentIndexDef returns the first, by name, of the index
+// definitions that's equivalent to the indexDef, other than the
+// indexDef itself, or nil when there's none.
+func FindEquivalentIndexDef(indexDefs *IndexDefs,
+	indexDef *IndexDef) *IndexDef {
+	if indexDefs == nil || indexDef == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(indexDefs.IndexDefs))
+	for name := range indexDefs.IndexDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == indexDef.Name {
+			continue
+		}
+
+		if IndexDefsEquivalent(indexDefs.IndexDefs[name], indexDef) {
+			return indexDefs.IndexDefs[name]
+		}
+	}
+
+	return nil
+}
+
+// dedupIndexDef applies the "indexDedup" manager option to a new
+// indexDef.  It returns the existing index definition when that's to
+// be used instead, or else the index definition to be created, which
+// is either the indexDef or an alias to its duplicate.
+func (mgr *Manager) dedupIndexDef(indexDefs *IndexDefs,
+	indexDef *IndexDef) (*IndexDef, *IndexDef, error) {
+	mode := mgr.GetOption("indexDedup")
+	if mode == "" || mode == IndexDedupOff {
+		return nil, indexDef, nil
+	}
+
+	existing := FindEquivalentIndexDef(indexDefs, indexDef)
+	if existing == nil {
+		return nil, indexDef, nil
+	}
+
+	atomic.AddUint64(&mgr.stats.TotCreateIndexDedup, 1)
+
+	switch mode {
+	case IndexDedupWarn:
+		log.Warnf("index_dedup: indexName: %s is a duplicate of"+
+			" indexName: %s, indexUUID: %s", indexDef.Name,
+			existing.Name, existing.UUID)
+
+		return nil, indexDef, nil
+
+	case IndexDedupAlias:
+		if IndexDedupAliasFunc != nil {
+			aliasDef, err := IndexDedupAliasFunc(mgr, indexDef, existing)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			log.Printf("index_dedup: indexName: %s is a duplicate of"+
+				" indexName: %s, creating an alias of type: %s",
+				indexDef.Name, existing.Name, aliasDef.Type)
+
+			return nil, aliasDef, nil
+		}
+	}
+
+	log.Printf("index_dedup: indexName: %s is a duplicate of"+
+		" indexName: %s, reusing indexUUID: %s", indexDef.Name,
+		existing.Name, existing.UUID)
+
+	return existing, nil, nil
+}
@@ -0,0 +1,138 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIndexDefsEquivalent(t *testing.T) {
+	a := &IndexDef{
+		Type:       "blackhole",
+		Name:       "a",
+		UUID:       "aaa",
+		Params:     `{"x":1,"y":[1,2]}`,
+		SourceType: "nil",
+		SourceName: "s",
+	}
+
+	b := *a
+	b.Name = "b"
+	b.UUID = "bbb"
+	b.Params = `{ "y": [1, 2], "x": 1 }`
+	b.Labels = map[string]string{"team": "x"}
+
+	if !IndexDefsEquivalent(a, &b) {
+		t.Errorf("expected equivalent")
+	}
+
+	c := b
+	c.PlanParams.IndexPartitions = 6
+	if IndexDefsEquivalent(a, &c) {
+		t.Errorf("expected different partitioning to not be equivalent")
+	}
+
+	c = b
+	c.Params = `{"x":2,"y":[1,2]}`
+	if IndexDefsEquivalent(a, &c) {
+		t.Errorf("expected different params to not be equivalent")
+	}
+
+	c = b
+	c.SourceName = "other"
+	if IndexDefsEquivalent(a, &c) {
+		t.Errorf("expected different source to not be equivalent")
+	}
+
+	indexDefs := NewIndexDefs(VERSION)
+	indexDefs.IndexDefs["a"] = a
+	if FindEquivalentIndexDef(indexDefs, a) != nil {
+		t.Errorf("expected the index itself to be skipped")
+	}
+	if FindEquivalentIndexDef(indexDefs, &b) != a {
+		t.Errorf("expected to find a")
+	}
+}
+
+func TestCreateIndexDedup(t *testing.T) {
+	emptyDir, _ := os.MkdirTemp("./tmp", "test")
+	defer os.RemoveAll(emptyDir)
+
+	defer func() { IndexDedupAliasFunc = nil }()
+
+	IndexDedupAliasFunc = func(mgr *Manager,
+		indexDef, existing *IndexDef) (*IndexDef, error) {
+		return &IndexDef{
+			Type:       "blackhole",
+			Name:       indexDef.Name,
+			Params:     `{"alias":"` + existing.Name + `"}`,
+			SourceType: "nil",
+		}, nil
+	}
+
+	tests := []struct {
+		mode       string
+		expName    string
+		expCreated bool
+		expParams  string
+	}{
+		{"", "idx1", true, `{"x":1}`},
+		{IndexDedupOff, "idx1", true, `{"x":1}`},
+		{IndexDedupWarn, "idx1", true, `{"x":1}`},
+		{IndexDedupReuse, "idx0", false, ""},
+		{IndexDedupAlias, "idx1", true, `{"alias":"idx0"}`},
+	}
+
+	for _, test := range tests {
+		cfg := NewCfgMem()
+		mgr := NewManagerEx(VERSION, cfg, NewUUID(), nil, "", 1, "", ":1000",
+			emptyDir, "some-datasource", nil,
+			map[string]string{"indexDedup": test.mode})
+		if err := mgr.Start("wanted"); err != nil {
+			t.Fatalf("expected start ok, err: %v", err)
+		}
+
+		create := func(name string) (string, string, error) {
+			return mgr.CreateIndexEx(&CreateIndexPayload{
+				SourceType:  "nil",
+				IndexType:   "blackhole",
+				IndexName:   name,
+				IndexParams: `{"x":1}`,
+			})
+		}
+
+		_, uuid0, err := create("idx0")
+		if err != nil {
+			t.Fatalf("mode: %q, expected create ok, err: %v", test.mode, err)
+		}
+
+		name, uuid, err := create("idx1")
+		if err != nil || name != test.expName {
+			t.Errorf("mode: %q, expected: %s, got: %s, err: %v",
+				test.mode, test.expName, name, err)
+		}
+		if !test.expCreated && uuid != uuid0 {
+			t.Errorf("mode: %q, expected the uuid of idx0", test.mode)
+		}
+
+		indexDefs, _, _ := CfgGetIndexDefs(cfg)
+		indexDef := indexDefs.IndexDefs["idx1"]
+		if (indexDef != nil) != test.expCreated {
+			t.Errorf("mode: %q, expected created: %t, got: %+v",
+				test.mode, test.expCreated, indexDef)
+		}
+		if indexDef != nil && indexDef.Params != test.expParams {
+			t.Errorf("mode: %q, expected params: %s, got: %s",
+				test.mode, test.expParams, indexDef.Params)
+		}
+
+		mgr.Stop()
+	}
+}
@@ -0,0 +1,242 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/couchbase/clog"
+)
+
+// The index definition versioning allows the applications to change
+// the schema of their index definitions between releases, without
+// hand-rolling the upgrades of the definitions that are already in the
+// Cfg.
+//
+// An application registers a migration per schema version of its index
+// type, where the definitions are created at the newest version of
+// their index type.  The older definitions are migrated lazily, as a
+// manager refreshes its cached index definitions, and are then written
+// back to the Cfg.  A migration is applied only once all the nodes of
+// the cluster understand its resulting version, as the older nodes
+// might otherwise misread the migrated definitions.
+
+// An IndexDefMigration upgrades an index definition of an index type
+// from the FromVersion to the FromVersion+1.
+type IndexDefMigration struct {
+	FromVersion int
+	Description string
+
+	// MinImplVersion is the lowest node ImplVersion (see VERSION)
+	// that understands the migrated definitions.
+	MinImplVersion string
+
+	// MinClusterCompatVersion is the lowest cluster compatibility
+	// version, as reported by a Cfg that's a VersionReader, at which
+	// all the nodes understand the migrated definitions.
+	MinClusterCompatVersion string
+
+	// Migrate upgrades a shallow copy of the index definition in
+	// place, so it must replace rather than modify the definition's
+	// maps, and the Version is bumped afterwards.  The UUID is kept,
+	// as the index's partitions would otherwise be rebuilt.
+	Migrate func(indexDef *IndexDef) error
+}
+
+// IndexDefMigrationCompatHook allows applications to override the
+// check of whether all the nodes of the cluster support the result of
+// an index definition migration.
+var IndexDefMigrationCompatHook func(cfg Cfg, indexType string,
+	m *IndexDefMigration) (bool, error)
+
+var indexDefMigrationsM sync.RWMutex
+
+// indexDefMigrations are the registered migrations, keyed by index
+// type, and ordered by their FromVersion.
+var indexDefMigrations = map[string][]*IndexDefMigration{}
+
+// RegisterIndexDefMigration registers the migration of the index
+// definitions of an index type from the migration's FromVersion, where
+// the migrations of an index type must start from version 0 and have
+// no gaps.
+func RegisterIndexDefMigration(indexType string, m *IndexDefMigration) error {
+	if m == nil || m.Migrate == nil || m.FromVersion < 0 {
+		return fmt.Errorf("index_def_migrate: invalid migration,"+
+			" indexType: %s", indexType)
+	}
+
+	indexDefMigrationsM.Lock()
+	defer indexDefMigrationsM.Unlock()
+
+	migrations := indexDefMigrations[indexType]
+	if m.FromVersion != len(migrations) {
+		return fmt.Errorf("index_def_migrate: indexType: %s,"+
+			" migration from version: %d, expected from version: %d",
+			indexType, m.FromVersion, len(migrations))
+	}
+
+	indexDefMigrations[indexType] = append(migrations, m)
+
+	return nil
+}
+
+// IndexDefVersion returns the newest version of the index definitions
+// of an index type, which is the version of its new definitions.
+func IndexDefVersion(indexType string) int {
+	indexDefMigrationsM.RLock()
+	rv := len(indexDefMigrations[indexType])
+	indexDefMigrationsM.RUnlock()
+	return rv
+}
+
+// indexDefMigrationsFrom returns the migrations of the index type from
+// the version to the newest version.
+func indexDefMigrationsFrom(indexType string,
+	version int) []*IndexDefMigration {
+	indexDefMigrationsM.RLock()
+	defer indexDefMigrationsM.RUnlock()
+
+	migrations := indexDefMigrations[indexType]
+	if version < 0 || version >= len(migrations) {
+		return nil
+	}
+
+	return migrations[version:]
+}
+
+// MigrateIndexDefs migrates the definitions of the indexDefs that are
+// older than their index type's newest version, as far as the cluster
+// supports, replacing them with their migrated copies.  The names of
+// the migrated definitions are returned, sorted.  A definition whose
+// migration fails is left at its last successfully migrated version.
+func MigrateIndexDefs(cfg Cfg, indexDefs *IndexDefs) ([]string, error) {
+	if indexDefs == nil {
+		return nil, nil
+	}
+
+	var rv []string
+
+	supported := map[*IndexDefMigration]bool{}
+
+	for name, indexDef := range indexDefs.IndexDefs {
+		migrations := indexDefMigrationsFrom(indexDef.Type, indexDef.Version)
+		if len(migrations) == 0 {
+			continue
+		}
+
+		migrated := indexDef
+
+		for _, m := range migrations {
+			ok, checked := supported[m]
+			if !checked {
+				var err error
+				ok, err = indexDefMigrationSupported(cfg, indexDef.Type, m)
+				if err != nil {
+					return nil, err
+				}
+				supported[m] = ok
+			}
+			if !ok {
+				break
+			}
+
+			d := *migrated
+
+			err := m.Migrate(&d)
+			if err != nil {
+				log.Warnf("index_def_migrate: indexName: %s, indexType: %s,"+
+					" from version: %d, err: %v",
+					name, indexDef.Type, m.FromVersion, err)
+				break
+			}
+
+			d.UUID = indexDef.UUID
+			d.Version = m.FromVersion + 1
+			migrated = &d
+		}
+
+		if migrated != indexDef {
+			indexDefs.IndexDefs[name] = migrated
+			rv = append(rv, name)
+		}
+	}
+
+	sort.Strings(rv)
+
+	return rv, nil
+}
+
+func indexDefMigrationSupported(cfg Cfg, indexType string,
+	m *IndexDefMigration) (bool, error) {
+	if IndexDefMigrationCompatHook != nil {
+		return IndexDefMigrationCompatHook(cfg, indexType, m)
+	}
+
+	return clusterCompatible(cfg, m.MinClusterCompatVersion,
+		m.MinImplVersion)
+}
+
+// ------------------------------------------------------------------------
+
+// migrateIndexDefs migrates the manager's freshly read indexDefs in
+// place, and writes the migrated definitions back to the Cfg in the
+// background, so that the migrations run once per cluster.
+func (mgr *Manager) migrateIndexDefs(indexDefs *IndexDefs) {
+	migrated, err := MigrateIndexDefs(mgr.cfg, indexDefs)
+	if err != nil {
+		log.Warnf("index_def_migrate: MigrateIndexDefs, err: %v", err)
+		return
+	}
+	if len(migrated) == 0 {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&mgr.indexDefsMigrating, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&mgr.indexDefsMigrating, 0)
+
+		err := mgr.writeBackIndexDefMigrations()
+		if err != nil {
+			log.Warnf("index_def_migrate: write back, err: %v", err)
+		}
+	}()
+}
+
+// writeBackIndexDefMigrations writes the migrated index definitions
+// back to the Cfg, where a racing write back by another node leaves
+// nothing more to migrate.
+func (mgr *Manager) writeBackIndexDefMigrations() error {
+	return CfgRetryOnCAS("indexDefMigrate", 100, func() error {
+		indexDefs, cas, err := CfgGetIndexDefs(mgr.cfg)
+		if err != nil {
+			return err
+		}
+
+		migrated, err := MigrateIndexDefs(mgr.cfg, indexDefs)
+		if err != nil || len(migrated) == 0 {
+			return err
+		}
+
+		_, err = CfgSetIndexDefs(mgr.cfg, indexDefs, cas)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("index_def_migrate: migrated index definitions: %v",
+			migrated)
+
+		return nil
+	})
+}
@@ -0,0 +1,109 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIndexDefMigrations(t *testing.T) {
+	indexDefMigrationsM.Lock()
+	prevMigrations := indexDefMigrations
+	indexDefMigrations = map[string][]*IndexDefMigration{}
+	indexDefMigrationsM.Unlock()
+
+	prevHook := IndexDefMigrationCompatHook
+	supportedVersion := 1
+	IndexDefMigrationCompatHook = func(cfg Cfg, indexType string,
+		m *IndexDefMigration) (bool, error) {
+		return m.FromVersion < supportedVersion, nil
+	}
+
+	defer func() {
+		indexDefMigrationsM.Lock()
+		indexDefMigrations = prevMigrations
+		indexDefMigrationsM.Unlock()
+		IndexDefMigrationCompatHook = prevHook
+	}()
+
+	err := RegisterIndexDefMigration("t", &IndexDefMigration{
+		FromVersion: 1,
+		Migrate:     func(indexDef *IndexDef) error { return nil },
+	})
+	if err == nil {
+		t.Fatalf("expected err on a migration with a gap")
+	}
+
+	for _, params := range []string{`{"v":1}`, `{"v":2}`} {
+		params := params
+		err = RegisterIndexDefMigration("t", &IndexDefMigration{
+			FromVersion: IndexDefVersion("t"),
+			Migrate: func(indexDef *IndexDef) error {
+				indexDef.Params = params
+				indexDef.UUID = "changed"
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+	if IndexDefVersion("t") != 2 || IndexDefVersion("other") != 0 {
+		t.Fatalf("unexpected versions")
+	}
+
+	cfg := NewCfgMem()
+
+	indexDefs := NewIndexDefs(VERSION)
+	indexDefs.IndexDefs["a"] = &IndexDef{Type: "t", Name: "a", UUID: "u0"}
+	indexDefs.IndexDefs["b"] = &IndexDef{Type: "other", Name: "b", UUID: "u1"}
+	if _, err = CfgSetIndexDefs(cfg, indexDefs, 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	waitVersion := func(version int) {
+		for i := 0; i < 500; i++ {
+			indexDefs, _, _ := CfgGetIndexDefs(cfg)
+			a := indexDefs.IndexDefs["a"]
+			if a.Version == version {
+				if a.UUID != "u0" || indexDefs.IndexDefs["b"].Version != 0 {
+					t.Fatalf("unexpected written back defs: %#v, %#v",
+						a, indexDefs.IndexDefs["b"])
+				}
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected written back version: %d", version)
+	}
+
+	mgr := NewManager(VERSION, cfg, NewUUID(), nil, "", 1, "", "",
+		"", "", nil)
+
+	// Only the migrations that the cluster supports are applied.
+	_, indexDefsByName, err := mgr.GetIndexDefs(true)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if a := indexDefsByName["a"]; a.Version != 1 || a.Params != `{"v":1}` ||
+		a.UUID != "u0" {
+		t.Fatalf("expected a migrated def, got: %#v", a)
+	}
+	waitVersion(1)
+
+	supportedVersion = 2
+	mgr.GetIndexDefs(true)
+	waitVersion(2)
+
+	indexDefs, _, _ = CfgGetIndexDefs(cfg)
+	if indexDefs.IndexDefs["a"].Params != `{"v":2}` {
+		t.Errorf("expected migrated params, got: %#v", indexDefs.IndexDefs["a"])
+	}
+}
@@ -0,0 +1,129 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// An IndexDefValidationError is a structured error of a validator of
+// the index definitions, which the REST API returns as is.
+type IndexDefValidationError struct {
+	Validator string `json:"validator"`
+
+	// Field is the invalid field of the index definition, if any,
+	// like "name", "params" or "sourceName".
+	Field string `json:"field,omitempty"`
+
+	// Code is a machine readable reason, if any, like "reservedName".
+	Code string `json:"code,omitempty"`
+
+	Message string `json:"message"`
+}
+
+func (e *IndexDefValidationError) Error() string {
+	if e.Field != "" {
+		return e.Validator + ": " + e.Field + ": " + e.Message
+	}
+	return e.Validator + ": " + e.Message
+}
+
+// IndexDefValidationErrors are the errors of all the validators that
+// rejected an index definition.
+type IndexDefValidationErrors []*IndexDefValidationError
+
+func (errs IndexDefValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return "invalid index definition: " + strings.Join(msgs, "; ")
+}
+
+// An IndexDefValidator checks an index definition that's being created
+// or updated, where the prevIndexDef is nil on a creation.  Its error
+// may be an *IndexDefValidationError or IndexDefValidationErrors, whose
+// Validator defaults to the registered name, and other errors are
+// treated as the Message of an IndexDefValidationError.
+type IndexDefValidator func(mgr *Manager,
+	indexDef, prevIndexDef *IndexDef) error
+
+var indexDefValidatorsM sync.RWMutex
+
+// indexDefValidators is a registry of the IndexDefValidator's, keyed
+// by name.
+var indexDefValidators = map[string]IndexDefValidator{}
+
+// RegisterIndexDefValidator allows applications to register a named
+// validator of the index definitions, which runs on their creations
+// and updates, such as via the REST API.  The validators run in the
+// order of their names.  Registering a nil validator unregisters the
+// name.
+func RegisterIndexDefValidator(name string, v IndexDefValidator) {
+	indexDefValidatorsM.Lock()
+	if v != nil {
+		indexDefValidators[name] = v
+	} else {
+		delete(indexDefValidators, name)
+	}
+	indexDefValidatorsM.Unlock()
+}
+
+// ValidateIndexDef runs all the registered validators on the index
+// definition, returning the IndexDefValidationErrors of the validators
+// that rejected it, or nil.
+func ValidateIndexDef(mgr *Manager, indexDef, prevIndexDef *IndexDef) error {
+	indexDefValidatorsM.RLock()
+	names := make([]string, 0, len(indexDefValidators))
+	for name := range indexDefValidators {
+		names = append(names, name)
+	}
+	validators := make(map[string]IndexDefValidator, len(names))
+	for _, name := range names {
+		validators[name] = indexDefValidators[name]
+	}
+	indexDefValidatorsM.RUnlock()
+
+	sort.Strings(names)
+
+	var rv IndexDefValidationErrors
+
+	for _, name := range names {
+		err := validators[name](mgr, indexDef, prevIndexDef)
+		if err == nil {
+			continue
+		}
+
+		var verrs IndexDefValidationErrors
+		var verr *IndexDefValidationError
+		switch {
+		case errors.As(err, &verrs):
+		case errors.As(err, &verr):
+			verrs = IndexDefValidationErrors{verr}
+		default:
+			verrs = IndexDefValidationErrors{{Message: err.Error()}}
+		}
+
+		for _, verr := range verrs {
+			if verr.Validator == "" {
+				verr.Validator = name
+			}
+			rv = append(rv, verr)
+		}
+	}
+
+	if len(rv) > 0 {
+		return rv
+	}
+
+	return nil
+}
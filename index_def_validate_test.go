@@ -0,0 +1,125 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestIndexDefValidators(t *testing.T) {
+	indexDefValidatorsM.Lock()
+	prevValidators := indexDefValidators
+	indexDefValidators = map[string]IndexDefValidator{}
+	indexDefValidatorsM.Unlock()
+
+	defer func() {
+		indexDefValidatorsM.Lock()
+		indexDefValidators = prevValidators
+		indexDefValidatorsM.Unlock()
+	}()
+
+	if err := ValidateIndexDef(nil, &IndexDef{}, nil); err != nil {
+		t.Fatalf("expected no err without validators, got: %v", err)
+	}
+
+	var prevs []*IndexDef
+
+	RegisterIndexDefValidator("b-names", func(mgr *Manager,
+		indexDef, prevIndexDef *IndexDef) error {
+		prevs = append(prevs, prevIndexDef)
+		if indexDef.Name == "bad" {
+			return &IndexDefValidationError{
+				Field: "name", Code: "reservedName", Message: "reserved",
+			}
+		}
+		return nil
+	})
+	RegisterIndexDefValidator("a-params", func(mgr *Manager,
+		indexDef, prevIndexDef *IndexDef) error {
+		if indexDef.Params == "bad" {
+			return fmt.Errorf("bad params")
+		}
+		return nil
+	})
+	RegisterIndexDefValidator("c-removed", func(mgr *Manager,
+		indexDef, prevIndexDef *IndexDef) error {
+		return fmt.Errorf("should have been unregistered")
+	})
+	RegisterIndexDefValidator("c-removed", nil)
+
+	if err := ValidateIndexDef(nil, &IndexDef{Name: "ok"}, nil); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	err := ValidateIndexDef(nil, &IndexDef{Name: "bad", Params: "bad"}, nil)
+
+	var verrs IndexDefValidationErrors
+	if !errors.As(err, &verrs) || len(verrs) != 2 {
+		t.Fatalf("expected 2 validation errors, got: %v", err)
+	}
+	if verrs[0].Validator != "a-params" || verrs[0].Message != "bad params" {
+		t.Errorf("unexpected first err: %#v", verrs[0])
+	}
+	if verrs[1].Validator != "b-names" || verrs[1].Field != "name" ||
+		verrs[1].Code != "reservedName" {
+		t.Errorf("unexpected second err: %#v", verrs[1])
+	}
+
+	// The validators also run on the manager's index creations and
+	// updates, where the updates see the previous index definition.
+	prevDataSourceUUID := DataSourceUUID
+	DataSourceUUID = func(sourceType, sourceName, sourceParams, server string,
+		options map[string]string) (string, error) {
+		return "123", nil
+	}
+
+	emptyDir, _ := os.MkdirTemp("./tmp", "test")
+	defer func() {
+		DataSourceUUID = prevDataSourceUUID
+		os.RemoveAll(emptyDir)
+	}()
+
+	cfg := NewCfgMem()
+	m := NewManager(VERSION, cfg, NewUUID(), nil, "", 1, "", ":1000",
+		emptyDir, "some-datasource", nil)
+	if err = m.Start("wanted"); err != nil {
+		t.Fatalf("expected Manager.Start() to work, err: %v", err)
+	}
+	defer m.Stop()
+
+	err = m.CreateIndex("primary", "default", "123", "",
+		"blackhole", "bad", "", PlanParams{}, "")
+	if !errors.As(err, &verrs) || len(verrs) != 1 {
+		t.Fatalf("expected validation err on create, got: %v", err)
+	}
+
+	prevs = nil
+
+	err = m.CreateIndex("primary", "default", "123", "",
+		"blackhole", "foo", "", PlanParams{}, "")
+	if err != nil {
+		t.Fatalf("expected CreateIndex() to work, err: %v", err)
+	}
+	if len(prevs) != 1 || prevs[0] != nil {
+		t.Errorf("expected no prev index def on create, got: %v", prevs)
+	}
+
+	indexDefs, _, _ := CfgGetIndexDefs(cfg)
+	err = m.CreateIndex("primary", "default", "123", "",
+		"blackhole", "foo", "", PlanParams{}, indexDefs.IndexDefs["foo"].UUID)
+	if err != nil {
+		t.Fatalf("expected update to work, err: %v", err)
+	}
+	if len(prevs) != 2 || prevs[1] == nil || prevs[1].Name != "foo" {
+		t.Errorf("expected prev index def on update, got: %v", prevs)
+	}
+}
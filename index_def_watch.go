@@ -0,0 +1,166 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"sort"
+	"sync"
+)
+
+// The types of the IndexDefChange's.
+const (
+	INDEX_DEF_CREATED = "created"
+	INDEX_DEF_UPDATED = "updated"
+	INDEX_DEF_DELETED = "deleted"
+)
+
+// An IndexDefChange is a change of an index definition, as seen by a
+// manager when it refreshes its cached index definitions.  The OldDef
+// is nil when the index was created, and the NewDef is nil when the
+// index was deleted.  An index that's deleted and re-created with the
+// same name in between the refreshes is seen as updated.
+//
+// The OldDef and NewDef are shared with the manager's cache, and must
+// not be modified.
+type IndexDefChange struct {
+	Type   string
+	Name   string
+	OldDef *IndexDef
+	NewDef *IndexDef
+}
+
+// An IndexDefChangeCallback is invoked on the changes of the index
+// definitions, see Manager.WatchIndexDefs().
+type IndexDefChangeCallback func(change *IndexDefChange)
+
+// indexDefWatchers are the IndexDefChangeCallback's of a manager,
+// which are invoked one change at a time, in the order of the changes,
+// from a goroutine that runs while there are queued changes, so that
+// the callbacks may safely use the manager.
+type indexDefWatchers struct {
+	m         sync.Mutex
+	nextID    uint64
+	callbacks map[uint64]IndexDefChangeCallback // Keyed by watch ID.
+	queue     []*IndexDefChange
+	running   bool
+}
+
+// WatchIndexDefs registers a callback that's invoked on every creation,
+// update and deletion of an index definition, as the manager refreshes
+// its cached index definitions, such as on the Cfg events.  The index
+// definitions of the manager's first load are not notified, as the
+// application can use GetIndexDefs() for those.  The returned func
+// unregisters the callback.
+func (mgr *Manager) WatchIndexDefs(cb IndexDefChangeCallback) func() {
+	w := &mgr.indexDefWatchers
+
+	w.m.Lock()
+	if w.callbacks == nil {
+		w.callbacks = map[uint64]IndexDefChangeCallback{}
+	}
+	w.nextID++
+	id := w.nextID
+	w.callbacks[id] = cb
+	w.m.Unlock()
+
+	return func() {
+		w.m.Lock()
+		delete(w.callbacks, id)
+		w.m.Unlock()
+	}
+}
+
+// notify queues the changes for the callbacks, and should be invoked
+// in the order of the changes.
+func (w *indexDefWatchers) notify(changes []*IndexDefChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	w.m.Lock()
+	if len(w.callbacks) > 0 {
+		w.queue = append(w.queue, changes...)
+		if !w.running {
+			w.running = true
+			go w.run()
+		}
+	}
+	w.m.Unlock()
+}
+
+func (w *indexDefWatchers) run() {
+	for {
+		w.m.Lock()
+		if len(w.queue) == 0 {
+			w.running = false
+			w.m.Unlock()
+			return
+		}
+
+		change := w.queue[0]
+		w.queue[0] = nil
+		w.queue = w.queue[1:]
+
+		ids := make([]uint64, 0, len(w.callbacks))
+		for id := range w.callbacks {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		callbacks := make([]IndexDefChangeCallback, len(ids))
+		for i, id := range ids {
+			callbacks[i] = w.callbacks[id]
+		}
+		w.m.Unlock()
+
+		for _, cb := range callbacks {
+			cb(change)
+		}
+	}
+}
+
+// CalcIndexDefChanges returns the changes from the prev to the next
+// index definitions, sorted by index name, where either may be nil.
+func CalcIndexDefChanges(prev, next *IndexDefs) []*IndexDefChange {
+	var prevDefs, nextDefs map[string]*IndexDef
+	if prev != nil {
+		prevDefs = prev.IndexDefs
+	}
+	if next != nil {
+		nextDefs = next.IndexDefs
+	}
+
+	var rv []*IndexDefChange
+
+	for name, nextDef := range nextDefs {
+		prevDef := prevDefs[name]
+		if prevDef == nil {
+			rv = append(rv, &IndexDefChange{
+				Type: INDEX_DEF_CREATED, Name: name, NewDef: nextDef,
+			})
+		} else if prevDef.UUID != nextDef.UUID {
+			rv = append(rv, &IndexDefChange{
+				Type: INDEX_DEF_UPDATED, Name: name,
+				OldDef: prevDef, NewDef: nextDef,
+			})
+		}
+	}
+
+	for name, prevDef := range prevDefs {
+		if nextDefs[name] == nil {
+			rv = append(rv, &IndexDefChange{
+				Type: INDEX_DEF_DELETED, Name: name, OldDef: prevDef,
+			})
+		}
+	}
+
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Name < rv[j].Name })
+
+	return rv
+}
@@ -0,0 +1,78 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchIndexDefs(t *testing.T) {
+	cfg := NewCfgMem()
+	mgr := NewManager(VERSION, cfg, NewUUID(), nil, "", 1, "", "",
+		"", "", nil)
+
+	ch := make(chan *IndexDefChange, 10)
+	unwatch := mgr.WatchIndexDefs(func(change *IndexDefChange) {
+		ch <- change
+	})
+
+	// The names are each followed by their UUID.
+	setIndexDefs := func(nameUUIDs ...string) {
+		indexDefs := NewIndexDefs(VERSION)
+		for i := 0; i < len(nameUUIDs); i += 2 {
+			indexDefs.IndexDefs[nameUUIDs[i]] =
+				&IndexDef{Name: nameUUIDs[i], UUID: nameUUIDs[i+1]}
+		}
+		if _, err := CfgSetIndexDefs(cfg, indexDefs, CFG_CAS_FORCE); err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+		mgr.GetIndexDefs(true)
+	}
+
+	expect := func(exps ...string) {
+		for i := 0; i < len(exps); i += 2 {
+			select {
+			case change := <-ch:
+				if change.Type != exps[i] || change.Name != exps[i+1] {
+					t.Fatalf("expected: %s %s, got: %#v",
+						exps[i], exps[i+1], change)
+				}
+				if (change.Type == INDEX_DEF_CREATED) != (change.OldDef == nil) ||
+					(change.Type == INDEX_DEF_DELETED) != (change.NewDef == nil) {
+					t.Fatalf("unexpected defs of: %#v", change)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("expected: %s %s", exps[i], exps[i+1])
+			}
+		}
+		select {
+		case change := <-ch:
+			t.Fatalf("unexpected change: %#v", change)
+		default:
+		}
+	}
+
+	setIndexDefs("a", "u0") // The first load isn't notified.
+	expect()
+
+	setIndexDefs("a", "u0", "b", "u0")
+	expect(INDEX_DEF_CREATED, "b")
+
+	setIndexDefs("b", "u1", "c", "u0")
+	expect(INDEX_DEF_DELETED, "a", INDEX_DEF_UPDATED, "b",
+		INDEX_DEF_CREATED, "c")
+
+	mgr.GetIndexDefs(true) // No changes.
+
+	unwatch()
+	setIndexDefs()
+	time.Sleep(10 * time.Millisecond)
+	expect()
+}
@@ -0,0 +1,464 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// The index disk quotas cap the on-disk size of an index, as the sum
+// of the sizes of its pindexes reported by the PIndexDiskSizeHook, via
+// the DiskQuotaBytes of its PlanParams.  The planner nodes check the
+// quotas periodically and record the indexes that exceed their quotas
+// in the Cfg, so that every node sees them, where the DiskQuotaAction
+// of an index decides what's done while it's over its quota.  Only the
+// elected planner node runs the checks, see Manager.isPlannerLeader().
+
+// The actions taken on an index that exceeds its disk quota.
+const (
+	// DiskQuotaActionWarn only raises a warning, and is the default.
+	DiskQuotaActionWarn = "warn"
+
+	// DiskQuotaActionPauseIngest also pauses the ingestion of the
+	// index, see Manager.PauseIndexIngest(), and resumes it once the
+	// index is back within its quota.
+	DiskQuotaActionPauseIngest = "pauseIngest"
+
+	// DiskQuotaActionRejectMutations also has the feeds drop the new
+	// mutations of the index, while still applying its deletions, so
+	// that the index keeps up with its source but misses the rejected
+	// mutations until it's rebuilt.  The index is therefore recorded
+	// in the RebuildNeeded of the IndexDiskQuotas, which outlives its
+	// exceeded quota until the index is rebuilt or deleted.
+	DiskQuotaActionRejectMutations = "rejectMutations"
+)
+
+// INDEX_DISK_QUOTAS_KEY is the Cfg key of the indexes that exceed
+// their disk quotas.
+const INDEX_DISK_QUOTAS_KEY = "indexDiskQuotas"
+
+// IndexDiskQuotaEventID is the system event ID of an index that was
+// flagged as exceeding its disk quota.
+const IndexDiskQuotaEventID uint32 = 3079
+
+// IndexDiskQuotaCheckInterval is the time between the disk quota
+// checks of the planner nodes, and between the refreshes of the
+// rejected mutations of the pindex nodes.
+var IndexDiskQuotaCheckInterval = time.Minute
+
+// IndexDiskQuotas is the Cfg value of the indexes that exceed their
+// disk quotas.
+type IndexDiskQuotas struct {
+	UUID     string                             `json:"uuid"`
+	Exceeded map[string]*IndexDiskQuotaExceeded `json:"exceeded"` // Keyed by index name.
+
+	// RebuildNeeded are the UUIDs, keyed by index name, of the indexes
+	// that might have missed mutations due to their
+	// DiskQuotaActionRejectMutations, and so need to be rebuilt.
+	RebuildNeeded map[string]string `json:"rebuildNeeded,omitempty"`
+}
+
+// An IndexDiskQuotaExceeded is an index whose on-disk size exceeds its
+// disk quota.
+type IndexDiskQuotaExceeded struct {
+	IndexName  string `json:"indexName"`
+	IndexUUID  string `json:"indexUUID"`
+	QuotaBytes int64  `json:"quotaBytes"`
+	SizeBytes  int64  `json:"sizeBytes"`
+	Action     string `json:"action"`
+
+	// IngestPaused is true when the ingestion of the index was paused
+	// by its DiskQuotaActionPauseIngest, so that it's resumed once the
+	// index is back within its quota.
+	IngestPaused bool `json:"ingestPaused,omitempty"`
+
+	ExceededAt time.Time `json:"exceededAt"`
+}
+
+// ValidateDiskQuota returns an error for an invalid disk quota of the
+// plan params of an index.
+func ValidateDiskQuota(planParams PlanParams) error {
+	if planParams.DiskQuotaBytes < 0 {
+		return fmt.Errorf("index_disk_quota: invalid diskQuotaBytes: %d",
+			planParams.DiskQuotaBytes)
+	}
+
+	switch planParams.DiskQuotaAction {
+	case "", DiskQuotaActionWarn, DiskQuotaActionPauseIngest,
+		DiskQuotaActionRejectMutations:
+		return nil
+	}
+
+	return fmt.Errorf("index_disk_quota: unknown diskQuotaAction: %q,"+
+		" expected %q, %q or %q", planParams.DiskQuotaAction,
+		DiskQuotaActionWarn, DiskQuotaActionPauseIngest,
+		DiskQuotaActionRejectMutations)
+}
+
+// CfgGetIndexDiskQuotas retrieves the indexes that exceed their disk
+// quotas from a Cfg provider.
+func CfgGetIndexDiskQuotas(cfg Cfg) (*IndexDiskQuotas, uint64, error) {
+	v, cas, err := cfg.Get(INDEX_DISK_QUOTAS_KEY, 0)
+	if err != nil {
+		return nil, cas, err
+	}
+	if v == nil {
+		return nil, cas, nil
+	}
+	rv := &IndexDiskQuotas{}
+	err = UnmarshalJSON(v, rv)
+	if err != nil {
+		return nil, cas, err
+	}
+	return rv, cas, nil
+}
+
+// CfgSetIndexDiskQuotas updates the indexes that exceed their disk
+// quotas on a Cfg provider.
+func CfgSetIndexDiskQuotas(cfg Cfg, quotas *IndexDiskQuotas,
+	cas uint64) (uint64, error) {
+	buf, err := MarshalJSON(quotas)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Set(INDEX_DISK_QUOTAS_KEY, buf, cas)
+}
+
+// IndexDiskQuotaMessages returns the cluster warnings of the indexes
+// that exceed their disk quotas, sorted, such as for the messages of
+// the service topology.
+func IndexDiskQuotaMessages(quotas *IndexDiskQuotas) []string {
+	if quotas == nil {
+		return nil
+	}
+
+	var rv []string
+	for _, e := range quotas.Exceeded {
+		rv = append(rv, fmt.Sprintf("warning: index: %q exceeds its disk"+
+			" quota, size: %d bytes, quota: %d bytes, action: %s",
+			e.IndexName, e.SizeBytes, e.QuotaBytes, e.Action))
+	}
+	for indexName := range quotas.RebuildNeeded {
+		rv = append(rv, fmt.Sprintf("warning: index: %q rejected mutations"+
+			" while exceeding its disk quota, and needs to be rebuilt",
+			indexName))
+	}
+	sort.Strings(rv)
+
+	return rv
+}
+
+// ------------------------------------------------------------------------
+
+// CheckIndexDiskQuotas records the indexes that exceed their disk
+// quotas in the Cfg, and takes their disk quota actions, where a
+// system event is published when an index is first flagged.  The
+// indexes that are flagged are returned, sorted by name.
+func (mgr *Manager) CheckIndexDiskQuotas() ([]*IndexDiskQuotaExceeded, error) {
+	if mgr.cfg == nil {
+		return nil, nil
+	}
+
+	indexDefs, _, err := CfgGetIndexDefs(mgr.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	planPIndexes, _, err := CfgGetPlanPIndexes(mgr.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	prev, _, err := CfgGetIndexDiskQuotas(mgr.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	curr := map[string]*IndexDiskQuotaExceeded{}
+	rebuildNeeded := map[string]string{}
+
+	// Keep the indexes that need to be rebuilt until they're rebuilt,
+	// as a rebuild gives the index a new UUID, or deleted.
+	if prev != nil && indexDefs != nil {
+		for indexName, indexUUID := range prev.RebuildNeeded {
+			indexDef := indexDefs.IndexDefs[indexName]
+			if indexDef != nil && indexDef.UUID == indexUUID {
+				rebuildNeeded[indexName] = indexUUID
+			}
+		}
+	}
+
+	if indexDefs != nil && planPIndexes != nil && PIndexDiskSizeHook != nil {
+		sizes := map[string]int64{}
+
+		for _, planPIndex := range planPIndexes.PlanPIndexes {
+			indexDef := indexDefs.IndexDefs[planPIndex.IndexName]
+			if indexDef == nil || indexDef.UUID != planPIndex.IndexUUID ||
+				indexDef.PlanParams.DiskQuotaBytes <= 0 {
+				continue
+			}
+
+			size, err := PIndexDiskSizeHook(planPIndex)
+			if err != nil {
+				log.Warnf("index_disk_quota: pindex: %s, err: %v",
+					planPIndex.Name, err)
+				continue
+			}
+
+			sizes[planPIndex.IndexName] += size
+		}
+
+		for indexName, size := range sizes {
+			indexDef := indexDefs.IndexDefs[indexName]
+			if size <= indexDef.PlanParams.DiskQuotaBytes {
+				continue
+			}
+
+			e := &IndexDiskQuotaExceeded{
+				IndexName:  indexName,
+				IndexUUID:  indexDef.UUID,
+				QuotaBytes: indexDef.PlanParams.DiskQuotaBytes,
+				SizeBytes:  size,
+				Action:     indexDef.PlanParams.DiskQuotaAction,
+				ExceededAt: time.Now(),
+			}
+			if e.Action == "" {
+				e.Action = DiskQuotaActionWarn
+			}
+
+			var p *IndexDiskQuotaExceeded
+			if prev != nil {
+				p = prev.Exceeded[indexName]
+			}
+			if p != nil && p.IndexUUID == e.IndexUUID {
+				e.ExceededAt = p.ExceededAt
+				e.IngestPaused = p.IngestPaused
+			} else {
+				publishIndexDiskQuotaEvent(e)
+			}
+
+			if e.Action == DiskQuotaActionPauseIngest && !e.IngestPaused &&
+				!IndexDefIngestPaused(indexDef) {
+				err = mgr.PauseIndexIngest(indexName, e.IndexUUID)
+				if err != nil {
+					log.Warnf("index_disk_quota: pause, indexName: %s,"+
+						" err: %v", indexName, err)
+				} else {
+					e.IngestPaused = true
+				}
+			}
+
+			if e.Action == DiskQuotaActionRejectMutations {
+				rebuildNeeded[indexName] = e.IndexUUID
+			}
+
+			curr[indexName] = e
+		}
+	}
+	if len(rebuildNeeded) == 0 {
+		rebuildNeeded = nil
+	}
+
+	// Resume the ingestion that was paused by a quota that's no longer
+	// exceeded, or whose action was changed.
+	if prev != nil {
+		for indexName, p := range prev.Exceeded {
+			if !p.IngestPaused {
+				continue
+			}
+			if e := curr[indexName]; e != nil &&
+				e.Action == DiskQuotaActionPauseIngest {
+				continue
+			}
+
+			err = mgr.ResumeIndexIngest(indexName, p.IndexUUID)
+			if err != nil {
+				log.Warnf("index_disk_quota: resume, indexName: %s,"+
+					" err: %v", indexName, err)
+				if e := curr[indexName]; e != nil && e.IndexUUID == p.IndexUUID {
+					e.IngestPaused = true // Retry on the next check.
+				}
+			} else {
+				log.Printf("index_disk_quota: resumed, indexName: %s",
+					indexName)
+			}
+		}
+	}
+
+	if prev == nil && (len(curr) > 0 || len(rebuildNeeded) > 0) ||
+		prev != nil && (!reflect.DeepEqual(prev.Exceeded, curr) ||
+			!reflect.DeepEqual(prev.RebuildNeeded, rebuildNeeded)) {
+		err = CfgRetryOnCAS("indexDiskQuotas", 100, func() error {
+			_, cas, err := CfgGetIndexDiskQuotas(mgr.cfg)
+			if err != nil {
+				return err
+			}
+			_, err = CfgSetIndexDiskQuotas(mgr.cfg, &IndexDiskQuotas{
+				UUID:          NewUUID(),
+				Exceeded:      curr,
+				RebuildNeeded: rebuildNeeded,
+			}, cas)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rv := make([]*IndexDiskQuotaExceeded, 0, len(curr))
+	for _, e := range curr {
+		rv = append(rv, e)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].IndexName < rv[j].IndexName
+	})
+
+	return rv, nil
+}
+
+func publishIndexDiskQuotaEvent(e *IndexDiskQuotaExceeded) {
+	ev := NewSystemEvent(IndexDiskQuotaEventID, "warn",
+		"Index exceeds its disk quota",
+		map[string]interface{}{
+			"indexName":  e.IndexName,
+			"indexUUID":  e.IndexUUID,
+			"quotaBytes": e.QuotaBytes,
+			"sizeBytes":  e.SizeBytes,
+			"action":     e.Action,
+		})
+	if ev == nil {
+		return // The system event listener isn't started.
+	}
+
+	err := PublishSystemEvent(ev)
+	if err != nil {
+		log.Warnf("index_disk_quota: publish event, indexName: %s, err: %v",
+			e.IndexName, err)
+	}
+}
+
+// ------------------------------------------------------------------------
+
+// The dests whose new mutations are rejected by the feeds, as their
+// indexes exceed their disk quotas with DiskQuotaActionRejectMutations.
+var (
+	diskQuotaRejectsActive int32 // Fast path when there are none.
+	diskQuotaRejectsM      sync.RWMutex
+	diskQuotaRejects       = map[Dest]*Manager{}
+)
+
+// diskQuotaRejectsMutation returns true when the feeds must drop a new
+// mutation to the dest.  The feeds invoke it before each DataUpdate.
+func diskQuotaRejectsMutation(dest Dest) bool {
+	if atomic.LoadInt32(&diskQuotaRejectsActive) <= 0 {
+		return false
+	}
+
+	diskQuotaRejectsM.RLock()
+	mgr := diskQuotaRejects[dest]
+	diskQuotaRejectsM.RUnlock()
+
+	if mgr == nil {
+		return false
+	}
+
+	atomic.AddUint64(&mgr.stats.TotDiskQuotaRejectedMutations, 1)
+
+	return true
+}
+
+// refreshDiskQuotaRejects updates the dests of this node's pindexes
+// whose new mutations are rejected, from the indexes that exceed their
+// disk quotas in the Cfg.
+func (mgr *Manager) refreshDiskQuotaRejects() error {
+	quotas, _, err := CfgGetIndexDiskQuotas(mgr.cfg)
+	if err != nil {
+		return err
+	}
+
+	rejects := map[Dest]bool{}
+
+	if quotas != nil {
+		_, pindexes := mgr.CurrentMaps()
+		for _, pindex := range pindexes {
+			e := quotas.Exceeded[pindex.IndexName]
+			if e != nil && e.IndexUUID == pindex.IndexUUID &&
+				e.Action == DiskQuotaActionRejectMutations &&
+				pindex.Dest != nil {
+				rejects[pindex.Dest] = true
+			}
+		}
+	}
+
+	mgr.setDiskQuotaRejects(rejects)
+
+	return nil
+}
+
+// setDiskQuotaRejects replaces the rejected dests of this manager.
+func (mgr *Manager) setDiskQuotaRejects(rejects map[Dest]bool) {
+	diskQuotaRejectsM.Lock()
+	defer diskQuotaRejectsM.Unlock()
+
+	for dest, m := range diskQuotaRejects {
+		if m == mgr && !rejects[dest] {
+			delete(diskQuotaRejects, dest)
+		}
+	}
+	for dest := range rejects {
+		diskQuotaRejects[dest] = mgr
+	}
+
+	atomic.StoreInt32(&diskQuotaRejectsActive, int32(len(diskQuotaRejects)))
+}
+
+// IndexDiskQuotaLoop periodically checks the index disk quotas on the
+// elected planner node, and refreshes the rejected mutations on the
+// pindex nodes, until the manager is stopped.
+func (mgr *Manager) IndexDiskQuotaLoop() {
+	planner := mgr.tagsMap == nil || mgr.tagsMap["planner"]
+	pindex := mgr.tagsMap == nil || mgr.tagsMap["pindex"]
+
+	ticker := time.NewTicker(IndexDiskQuotaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mgr.stopCh:
+			mgr.setDiskQuotaRejects(nil)
+			return
+		case <-ticker.C:
+		}
+
+		if mgr.cfg == nil {
+			continue
+		}
+
+		if planner && mgr.isPlannerLeader() {
+			_, err := mgr.CheckIndexDiskQuotas()
+			if err != nil {
+				log.Warnf("index_disk_quota: check, err: %v", err)
+			}
+		}
+
+		if pindex {
+			err := mgr.refreshDiskQuotaRejects()
+			if err != nil {
+				log.Warnf("index_disk_quota: refresh, err: %v", err)
+			}
+		}
+	}
+}
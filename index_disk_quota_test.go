@@ -0,0 +1,198 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateDiskQuota(t *testing.T) {
+	for _, pp := range []PlanParams{
+		{},
+		{DiskQuotaBytes: 100},
+		{DiskQuotaBytes: 100, DiskQuotaAction: DiskQuotaActionPauseIngest},
+	} {
+		if err := ValidateDiskQuota(pp); err != nil {
+			t.Errorf("expected no err, pp: %+v, err: %v", pp, err)
+		}
+	}
+	for _, pp := range []PlanParams{
+		{DiskQuotaBytes: -1},
+		{DiskQuotaBytes: 100, DiskQuotaAction: "delete"},
+	} {
+		if err := ValidateDiskQuota(pp); err == nil {
+			t.Errorf("expected err, pp: %+v", pp)
+		}
+	}
+}
+
+func TestCheckIndexDiskQuotas(t *testing.T) {
+	cfg := NewCfgMem()
+
+	indexDefs := NewIndexDefs(VERSION)
+	indexDefs.IndexDefs["i0"] = &IndexDef{
+		Name: "i0", UUID: "u0",
+		PlanParams: PlanParams{
+			DiskQuotaBytes:  100,
+			DiskQuotaAction: DiskQuotaActionPauseIngest,
+		},
+	}
+	indexDefs.IndexDefs["i1"] = &IndexDef{
+		Name: "i1", UUID: "u1",
+		PlanParams: PlanParams{
+			DiskQuotaBytes:  100,
+			DiskQuotaAction: DiskQuotaActionRejectMutations,
+		},
+	}
+	indexDefs.IndexDefs["i2"] = &IndexDef{Name: "i2", UUID: "u2"}
+	_, err := CfgSetIndexDefs(cfg, indexDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	planPIndexes := NewPlanPIndexes(VERSION)
+	for name, p := range map[string]*PlanPIndex{
+		"p0": {IndexName: "i0", IndexUUID: "u0"},
+		"p1": {IndexName: "i0", IndexUUID: "u0"},
+		"p2": {IndexName: "i1", IndexUUID: "u1"},
+		"p3": {IndexName: "i2", IndexUUID: "u2"},
+	} {
+		p.Name = name
+		planPIndexes.PlanPIndexes[name] = p
+	}
+	_, err = CfgSetPlanPIndexes(cfg, planPIndexes, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	sizes := map[string]int64{"p0": 60, "p1": 60, "p2": 150, "p3": 1000}
+
+	prevHook := PIndexDiskSizeHook
+	defer func() { PIndexDiskSizeHook = prevHook }()
+	PIndexDiskSizeHook = func(planPIndex *PlanPIndex) (int64, error) {
+		return sizes[planPIndex.Name], nil
+	}
+
+	// Without the planner tag, the ingest controls don't kick a
+	// planner loop that isn't running.
+	mgr := NewManager(VERSION, cfg, NewUUID(), []string{"pindex"},
+		"", 1, "", "", "", "", nil)
+	defer mgr.setDiskQuotaRejects(nil)
+
+	exceeded, err := mgr.CheckIndexDiskQuotas()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(exceeded) != 2 ||
+		exceeded[0].IndexName != "i0" || exceeded[0].SizeBytes != 120 ||
+		!exceeded[0].IngestPaused ||
+		exceeded[1].IndexName != "i1" || exceeded[1].IngestPaused {
+		t.Fatalf("unexpected exceeded: %#v", exceeded)
+	}
+
+	indexDefs, _, _ = CfgGetIndexDefs(cfg)
+	if !IndexDefIngestPaused(indexDefs.IndexDefs["i0"]) ||
+		indexDefs.IndexDefs["i0"].UUID != "u0" {
+		t.Fatalf("expected i0 ingest paused, got: %#v",
+			indexDefs.IndexDefs["i0"])
+	}
+
+	quotas, _, err := CfgGetIndexDiskQuotas(cfg)
+	if err != nil || quotas == nil || len(quotas.Exceeded) != 2 {
+		t.Fatalf("expected the exceeded quotas in the cfg, got: %#v,"+
+			" err: %v", quotas, err)
+	}
+	if quotas.RebuildNeeded["i1"] != "u1" || len(quotas.RebuildNeeded) != 1 {
+		t.Fatalf("expected i1 to need a rebuild, got: %v",
+			quotas.RebuildNeeded)
+	}
+	msgs := IndexDiskQuotaMessages(quotas)
+	if len(msgs) != 3 || !strings.Contains(msgs[0], `"i0"`) ||
+		!strings.Contains(msgs[1], "rejectMutations") ||
+		!strings.Contains(msgs[2], "rebuilt") {
+		t.Errorf("unexpected messages: %v", msgs)
+	}
+
+	// The new mutations of the local pindexes of i1 are rejected.
+	dest1, dest2 := &BlackHole{}, &BlackHole{}
+	mgr.registerPIndex(&PIndex{Name: "p2", IndexName: "i1",
+		IndexUUID: "u1", Dest: dest1})
+	mgr.registerPIndex(&PIndex{Name: "p3", IndexName: "i2",
+		IndexUUID: "u2", Dest: dest2})
+
+	if err = mgr.refreshDiskQuotaRejects(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !diskQuotaRejectsMutation(dest1) || diskQuotaRejectsMutation(dest2) {
+		t.Fatalf("expected only the mutations of i1 to be rejected")
+	}
+
+	// Back within the quotas, the ingestion is resumed and the
+	// mutations are accepted.
+	sizes["p0"], sizes["p2"] = 10, 10
+
+	exceeded, err = mgr.CheckIndexDiskQuotas()
+	if err != nil || len(exceeded) != 0 {
+		t.Fatalf("expected no exceeded, got: %#v, err: %v", exceeded, err)
+	}
+
+	indexDefs, _, _ = CfgGetIndexDefs(cfg)
+	if IndexDefIngestPaused(indexDefs.IndexDefs["i0"]) {
+		t.Fatalf("expected i0 ingest resumed")
+	}
+
+	if err = mgr.refreshDiskQuotaRejects(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if diskQuotaRejectsMutation(dest1) {
+		t.Fatalf("expected the mutations of i1 to be accepted")
+	}
+
+	// The rejected mutations are still missing until i1 is rebuilt.
+	quotas, _, _ = CfgGetIndexDiskQuotas(cfg)
+	if quotas == nil || quotas.RebuildNeeded["i1"] != "u1" {
+		t.Fatalf("expected i1 to still need a rebuild, got: %#v", quotas)
+	}
+
+	indexDefs, _, _ = CfgGetIndexDefs(cfg)
+	indexDefs.IndexDefs["i1"].UUID = "u1-rebuilt"
+	_, err = CfgSetIndexDefs(cfg, indexDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err = mgr.CheckIndexDiskQuotas(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	quotas, _, _ = CfgGetIndexDiskQuotas(cfg)
+	if quotas == nil || len(quotas.RebuildNeeded) != 0 {
+		t.Fatalf("expected no rebuild needed, got: %#v", quotas)
+	}
+}
+
+func TestIsPlannerLeader(t *testing.T) {
+	cfg := NewCfgMem()
+
+	nodeDefs := NewNodeDefs(VERSION)
+	nodeDefs.NodeDefs["a"] = &NodeDef{UUID: "a", Tags: []string{"pindex"}}
+	nodeDefs.NodeDefs["b"] = &NodeDef{UUID: "b", Tags: []string{"planner"}}
+	nodeDefs.NodeDefs["c"] = &NodeDef{UUID: "c"}
+	_, err := CfgSetNodeDefs(cfg, NODE_DEFS_WANTED, nodeDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for uuid, exp := range map[string]bool{"a": false, "b": true, "c": false} {
+		mgr := NewManager(VERSION, cfg, uuid, nil,
+			"", 1, "", "", "", "", nil)
+		if got := mgr.isPlannerLeader(); got != exp {
+			t.Errorf("uuid: %s, expected leader: %t, got: %t", uuid, exp, got)
+		}
+	}
+}
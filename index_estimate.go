@@ -0,0 +1,200 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+)
+
+// IndexEstimateSizeRatio is the default ratio of the size of an index
+// to the size of its source, for index types without an EstimateSize.
+var IndexEstimateSizeRatio = 1.0
+
+// IndexEstimateMemoryRatio is the ratio of the memory needs of the
+// pindexes of a node to their size.
+var IndexEstimateMemoryRatio = 0.1
+
+// IndexEstimateBuildBytesPerSec is the rate, in index bytes/sec, at
+// which a node is estimated to build its pindexes.
+var IndexEstimateBuildBytesPerSec = 20.0 * 1024 * 1024
+
+// A SourceSample describes the data of a source, as sampled by the
+// Sample func of its FeedType.
+type SourceSample struct {
+	ItemCount  uint64 `json:"itemCount"`
+	AvgDocSize uint64 `json:"avgDocSize"` // In bytes.
+}
+
+// An IndexEstimate is the estimated cost of an index definition,
+// before the index is created.
+type IndexEstimate struct {
+	IndexName string        `json:"indexName"`
+	Sample    *SourceSample `json:"sample"`
+
+	// SizeBytes is the estimated size of a single copy of the index,
+	// where TotalSizeBytes also includes the replicas.
+	SizeBytes      uint64 `json:"sizeBytes"`
+	TotalSizeBytes uint64 `json:"totalSizeBytes"`
+
+	NumPIndexes int `json:"numPIndexes"`
+	NumReplicas int `json:"numReplicas"`
+
+	// BuildTimeSecs is the estimated time of the initial build, as
+	// the nodes build their pindexes concurrently.
+	BuildTimeSecs float64 `json:"buildTimeSecs"`
+
+	Nodes map[string]*NodeEstimate `json:"nodes"` // Keyed by node UUID.
+}
+
+// A NodeEstimate is the estimated resource needs of a node for an
+// index, per the planned placement of the index's pindexes.
+type NodeEstimate struct {
+	HostPort        string  `json:"hostPort"`
+	PIndexes        int     `json:"pindexes"`
+	ReplicaPIndexes int     `json:"replicaPIndexes"`
+	DiskBytes       uint64  `json:"diskBytes"`
+	MemoryBytes     uint64  `json:"memoryBytes"`
+	BuildTimeSecs   float64 `json:"buildTimeSecs"`
+}
+
+// DataSourceSample returns a sample of a data source, when its feed
+// type supports sampling.
+func DataSourceSample(sourceType, sourceName, sourceUUID, sourceParams,
+	server string, options map[string]string) (*SourceSample, error) {
+	feedType, exists := FeedTypes[sourceType]
+	if !exists || feedType == nil {
+		return nil, fmt.Errorf("feed: DataSourceSample"+
+			" unknown sourceType: %s", sourceType)
+	}
+	if feedType.Sample == nil {
+		return nil, fmt.Errorf("feed: DataSourceSample"+
+			" unsupported by sourceType: %s", sourceType)
+	}
+
+	return feedType.Sample(sourceType, sourceName, sourceUUID,
+		sourceParams, server, options)
+}
+
+// EstimateIndex estimates the size, build time and per-node resource
+// needs of an index definition without creating the index, by
+// sampling its source and planning its pindexes against the current
+// nodes and indexes.
+func (mgr *Manager) EstimateIndex(indexDef *IndexDef) (*IndexEstimate, error) {
+	if indexDef == nil || indexDef.Name == "" {
+		return nil, NewBadRequestError("index_estimate: indexName is required")
+	}
+
+	pindexImplType, exists := PIndexImplTypes[indexDef.Type]
+	if !exists || pindexImplType == nil {
+		return nil, NewBadRequestError("index_estimate: unknown"+
+			" indexType: %s", indexDef.Type)
+	}
+
+	if pindexImplType.Validate != nil {
+		err := pindexImplType.Validate(indexDef.Type, indexDef.Name,
+			indexDef.Params)
+		if err != nil {
+			return nil, NewBadRequestError("index_estimate: invalid,"+
+				" err: %v", err)
+		}
+	}
+
+	sample, err := DataSourceSample(indexDef.SourceType, indexDef.SourceName,
+		indexDef.SourceUUID, indexDef.SourceParams, mgr.server, mgr.Options())
+	if err != nil {
+		return nil, NewBadRequestError("index_estimate: could not sample"+
+			" source, sourceName: %s, err: %v", indexDef.SourceName, err)
+	}
+
+	var sizeBytes uint64
+	if pindexImplType.EstimateSize != nil {
+		sizeBytes = pindexImplType.EstimateSize(indexDef, sample)
+	} else {
+		sizeBytes = uint64(float64(sample.ItemCount*sample.AvgDocSize) *
+			IndexEstimateSizeRatio)
+	}
+
+	// Plan the index along with the existing indexes, without saving
+	// the plan, to learn the placement of its pindexes.
+	indexDefs, nodeDefs, planPIndexesPrev, _, err :=
+		PlannerGetPlan(mgr.cfg, mgr.version, "")
+	if err != nil {
+		return nil, fmt.Errorf("index_estimate: could not get plan,"+
+			" err: %v", err)
+	}
+
+	estimateDef := *indexDef
+	estimateDef.UUID = NewUUID()
+	indexDefs.IndexDefs[estimateDef.Name] = &estimateDef
+
+	planPIndexes, err := CalcPlan("", indexDefs, nodeDefs,
+		planPIndexesPrev, mgr.version, mgr.server, mgr.Options(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("index_estimate: could not plan,"+
+			" err: %v", err)
+	}
+
+	rv := &IndexEstimate{
+		IndexName:   indexDef.Name,
+		Sample:      sample,
+		SizeBytes:   sizeBytes,
+		NumReplicas: estimateDef.PlanParams.NumReplicas,
+		Nodes:       map[string]*NodeEstimate{},
+	}
+
+	for _, planPIndex := range planPIndexes.PlanPIndexes {
+		if planPIndex.IndexName == estimateDef.Name &&
+			planPIndex.IndexUUID == estimateDef.UUID {
+			rv.NumPIndexes++
+		}
+	}
+	if rv.NumPIndexes <= 0 {
+		return rv, nil
+	}
+
+	pindexBytes := sizeBytes / uint64(rv.NumPIndexes)
+
+	for _, planPIndex := range planPIndexes.PlanPIndexes {
+		if planPIndex.IndexName != estimateDef.Name ||
+			planPIndex.IndexUUID != estimateDef.UUID {
+			continue
+		}
+
+		for nodeUUID, planPIndexNode := range planPIndex.Nodes {
+			ne := rv.Nodes[nodeUUID]
+			if ne == nil {
+				ne = &NodeEstimate{}
+				if nodeDef := nodeDefs.NodeDefs[nodeUUID]; nodeDef != nil {
+					ne.HostPort = nodeDef.HostPort
+				}
+				rv.Nodes[nodeUUID] = ne
+			}
+
+			if planPIndexNode.Priority <= 0 {
+				ne.PIndexes++
+			} else {
+				ne.ReplicaPIndexes++
+			}
+			ne.DiskBytes += pindexBytes
+			rv.TotalSizeBytes += pindexBytes
+		}
+	}
+
+	for _, ne := range rv.Nodes {
+		ne.MemoryBytes = uint64(float64(ne.DiskBytes) * IndexEstimateMemoryRatio)
+		if IndexEstimateBuildBytesPerSec > 0 {
+			ne.BuildTimeSecs = float64(ne.DiskBytes) / IndexEstimateBuildBytesPerSec
+		}
+		if ne.BuildTimeSecs > rv.BuildTimeSecs {
+			rv.BuildTimeSecs = ne.BuildTimeSecs
+		}
+	}
+
+	return rv, nil
+}
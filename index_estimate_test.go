@@ -0,0 +1,87 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"testing"
+)
+
+func TestEstimateIndex(t *testing.T) {
+	RegisterFeedType("estimateTest", &FeedType{
+		Partitions: func(sourceType, sourceName, sourceUUID, sourceParams,
+			server string, options map[string]string) ([]string, error) {
+			return []string{"0", "1", "2", "3"}, nil
+		},
+		Sample: func(sourceType, sourceName, sourceUUID, sourceParams,
+			server string, options map[string]string) (*SourceSample, error) {
+			return &SourceSample{ItemCount: 1000, AvgDocSize: 100}, nil
+		},
+	})
+	defer delete(FeedTypes, "estimateTest")
+
+	cfg := NewCfgMem()
+	mgr := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	nodeDefs := NewNodeDefs(VERSION)
+	for _, uuid := range []string{"n0", "n1"} {
+		nodeDefs.NodeDefs[uuid] = &NodeDef{
+			UUID: uuid, HostPort: uuid + ":8094", ImplVersion: VERSION,
+		}
+	}
+	_, err := CfgSetNodeDefs(cfg, NODE_DEFS_WANTED, nodeDefs, 0)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	indexDef := &IndexDef{
+		Type:       "blackhole",
+		Name:       "idx",
+		SourceType: "estimateTest",
+		SourceName: "src",
+		PlanParams: PlanParams{MaxPartitionsPerPIndex: 1, NumReplicas: 1},
+	}
+
+	estimate, err := mgr.EstimateIndex(indexDef)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	if estimate.SizeBytes != 100000 || estimate.TotalSizeBytes != 200000 ||
+		estimate.NumPIndexes != 4 || len(estimate.Nodes) != 2 {
+		t.Fatalf("unexpected estimate: %+v", estimate)
+	}
+
+	var buildTimeSecs float64
+	for uuid, ne := range estimate.Nodes {
+		if ne.HostPort != uuid+":8094" ||
+			ne.PIndexes+ne.ReplicaPIndexes != 4 ||
+			ne.DiskBytes != 100000 || ne.MemoryBytes == 0 {
+			t.Fatalf("unexpected node: %s, estimate: %+v", uuid, ne)
+		}
+		if ne.BuildTimeSecs > buildTimeSecs {
+			buildTimeSecs = ne.BuildTimeSecs
+		}
+	}
+	if estimate.BuildTimeSecs != buildTimeSecs || buildTimeSecs <= 0 {
+		t.Fatalf("unexpected build time: %v", estimate.BuildTimeSecs)
+	}
+
+	// Nothing is created.
+	indexDefs, _, _ := CfgGetIndexDefs(cfg)
+	if indexDefs != nil && len(indexDefs.IndexDefs) != 0 {
+		t.Fatalf("expected no index defs, got: %+v", indexDefs)
+	}
+
+	// Sources without a sampler can't be estimated.
+	indexDef.SourceType = "primary"
+	if _, err = mgr.EstimateIndex(indexDef); err == nil {
+		t.Fatalf("expected an err without a sampler")
+	}
+}
@@ -0,0 +1,223 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// INDEX_LABEL_KEY_REGEXP is the pattern of the keys of index labels.
+const INDEX_LABEL_KEY_REGEXP = `^[A-Za-z][0-9A-Za-z_.\-/]*$`
+
+var indexLabelKeyRE = regexp.MustCompile(INDEX_LABEL_KEY_REGEXP)
+
+// MaxIndexLabels is the max number of labels of an index.
+var MaxIndexLabels = 64
+
+// MaxIndexLabelLen is the max length of an index label key or value.
+var MaxIndexLabelLen = 256
+
+// ValidateIndexLabels returns an error if the labels are invalid.
+func ValidateIndexLabels(labels map[string]string) error {
+	if len(labels) > MaxIndexLabels {
+		return fmt.Errorf("index_labels: too many labels: %d, max: %d",
+			len(labels), MaxIndexLabels)
+	}
+	for k, v := range labels {
+		if len(k) > MaxIndexLabelLen || !indexLabelKeyRE.MatchString(k) {
+			return fmt.Errorf("index_labels: invalid label key: %q", k)
+		}
+		if len(v) > MaxIndexLabelLen || strings.ContainsAny(v, ",=!") {
+			return fmt.Errorf("index_labels: invalid label value: %q,"+
+				" key: %s", v, k)
+		}
+	}
+	return nil
+}
+
+// A LabelRequirement is a single term of a LabelSelector.
+type LabelRequirement struct {
+	Key string
+	Op  string // One of "=", "!=" or "" (the key exists).
+	Val string
+}
+
+// A LabelSelector selects indexes by their labels, where all of its
+// requirements must match.  An empty LabelSelector matches all indexes.
+type LabelSelector []LabelRequirement
+
+// ParseLabelSelector parses a comma separated selector of the form
+// "k1=v1,k2!=v2,k3", where a bare key requires the label to exist.
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var rv LabelSelector
+
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var req LabelRequirement
+		if i := strings.Index(term, "!="); i >= 0 {
+			req = LabelRequirement{Key: term[:i], Op: "!=", Val: term[i+2:]}
+		} else if i := strings.Index(term, "="); i >= 0 {
+			req = LabelRequirement{Key: term[:i], Op: "=", Val: term[i+1:]}
+		} else {
+			req = LabelRequirement{Key: term}
+		}
+
+		req.Key = strings.TrimSpace(req.Key)
+		req.Val = strings.TrimSpace(req.Val)
+		if !indexLabelKeyRE.MatchString(req.Key) {
+			return nil, fmt.Errorf("index_labels: invalid selector: %q,"+
+				" term: %q", s, term)
+		}
+
+		rv = append(rv, req)
+	}
+
+	return rv, nil
+}
+
+// Matches returns true if the labels meet all the requirements.
+func (sel LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range sel {
+		v, exists := labels[req.Key]
+		switch req.Op {
+		case "=":
+			if !exists || v != req.Val {
+				return false
+			}
+		case "!=":
+			if exists && v == req.Val {
+				return false
+			}
+		default:
+			if !exists {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (sel LabelSelector) String() string {
+	terms := make([]string, 0, len(sel))
+	for _, req := range sel {
+		terms = append(terms, req.Key+req.Op+req.Val)
+	}
+	return strings.Join(terms, ",")
+}
+
+// FilterIndexDefsByLabels returns the names of the index definitions
+// that match the selector, sorted by name.
+func FilterIndexDefsByLabels(indexDefs *IndexDefs,
+	sel LabelSelector) []string {
+	var rv []string
+	if indexDefs != nil {
+		for name, indexDef := range indexDefs.IndexDefs {
+			if sel.Matches(indexDef.Labels) {
+				rv = append(rv, name)
+			}
+		}
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// ------------------------------------------------------------------------
+
+// SetIndexLabels replaces the labels of an index.  As the labels do not
+// affect the index partitions, the index's UUID remains unchanged.
+func (mgr *Manager) SetIndexLabels(indexName, indexUUID string,
+	labels map[string]string) error {
+	err := ValidateIndexLabels(labels)
+	if err != nil {
+		return NewBadRequestError("manager_api: SetIndexLabels, err: %v", err)
+	}
+
+	err = RetryOnCASMismatch(func() error {
+		indexDefs, cas, err := CfgGetIndexDefs(mgr.cfg)
+		if err != nil {
+			return err
+		}
+		if indexDefs == nil {
+			return fmt.Errorf("manager_api: no indexes,"+
+				" set labels, indexName: %s", indexName)
+		}
+		if VersionGTE(mgr.version, indexDefs.ImplVersion) == false {
+			return fmt.Errorf("manager_api: set labels,"+
+				" indexName: %s,"+
+				" indexDefs.ImplVersion: %s > mgr.version: %s",
+				indexName, indexDefs.ImplVersion, mgr.version)
+		}
+		indexDef, exists := indexDefs.IndexDefs[indexName]
+		if !exists || indexDef == nil {
+			return fmt.Errorf("manager_api: no index to set labels,"+
+				" indexName: %s", indexName)
+		}
+		if indexUUID != "" && indexDef.UUID != indexUUID {
+			return fmt.Errorf("manager_api: index.UUID mismatched")
+		}
+
+		if len(labels) > 0 {
+			indexDef.Labels = labels
+		} else {
+			indexDef.Labels = nil
+		}
+		indexDefs.UUID = NewUUID()
+
+		_, err = CfgSetIndexDefs(mgr.cfg, indexDefs, cas)
+		return err
+	}, 100)
+	if err != nil {
+		return fmt.Errorf("manager_api: could not save indexDefs,"+
+			" err: %v", err)
+	}
+
+	mgr.GetIndexDefs(true)
+
+	return nil
+}
+
+// IndexControlByLabels applies the index controls (see IndexControl)
+// to all the indexes that match the selector, returning the names of
+// the indexes that were changed.  The indexes are changed one at a
+// time, so on an error, the indexes before the failed index remain
+// changed.
+func (mgr *Manager) IndexControlByLabels(sel LabelSelector,
+	readOp, writeOp, planFreezeOp string) ([]string, error) {
+	if len(sel) == 0 {
+		return nil, NewBadRequestError("manager_api: IndexControlByLabels," +
+			" a label selector is required")
+	}
+
+	indexDefs, _, err := CfgGetIndexDefs(mgr.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv []string
+	for _, indexName := range FilterIndexDefsByLabels(indexDefs, sel) {
+		err = mgr.IndexControl(indexName, "", readOp, writeOp, planFreezeOp)
+		if err != nil {
+			return rv, fmt.Errorf("manager_api: IndexControlByLabels,"+
+				" selector: %s, indexName: %s, err: %v", sel, indexName, err)
+		}
+		rv = append(rv, indexName)
+	}
+
+	atomic.AddUint64(&mgr.stats.TotIndexControlByLabels, 1)
+
+	return rv, nil
+}
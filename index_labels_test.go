@@ -0,0 +1,135 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	labels := map[string]string{"env": "staging", "tenant": "tenantA"}
+
+	tests := []struct {
+		sel     string
+		matches bool
+		expErr  bool
+	}{
+		{"", true, false},
+		{"env=staging", true, false},
+		{"env=staging, tenant=tenantA", true, false},
+		{"env=prod", false, false},
+		{"env!=prod", true, false},
+		{"env!=staging", false, false},
+		{"tenant", true, false},
+		{"team", false, false},
+		{"team!=x", true, false},
+		{"=staging", false, true},
+		{"env=staging,!x", false, true},
+	}
+
+	for i, test := range tests {
+		sel, err := ParseLabelSelector(test.sel)
+		if (err != nil) != test.expErr {
+			t.Errorf("test: %d, sel: %q, expErr: %v, err: %v",
+				i, test.sel, test.expErr, err)
+			continue
+		}
+		if err == nil && sel.Matches(labels) != test.matches {
+			t.Errorf("test: %d, sel: %q, expected matches: %v",
+				i, test.sel, test.matches)
+		}
+	}
+
+	if ValidateIndexLabels(labels) != nil {
+		t.Errorf("expected valid labels")
+	}
+	if ValidateIndexLabels(map[string]string{"a": "x,y"}) == nil ||
+		ValidateIndexLabels(map[string]string{"1a": "x"}) == nil {
+		t.Errorf("expected invalid labels")
+	}
+}
+
+func TestIndexDefLabelsJSON(t *testing.T) {
+	def := &IndexDef{
+		Type:   "blackhole",
+		Name:   "i0",
+		Params: "{}",
+		Labels: map[string]string{"env": "staging"},
+	}
+
+	buf, err := MarshalJSON(def)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	var def2 IndexDef
+	err = UnmarshalJSON(buf, &def2)
+	if err != nil || !reflect.DeepEqual(def2.Labels, def.Labels) {
+		t.Errorf("expected labels to round trip, got: %+v, err: %v",
+			def2.Labels, err)
+	}
+}
+
+func TestManagerIndexLabels(t *testing.T) {
+	cfg := NewCfgMem()
+	m := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	indexDefs := NewIndexDefs(VERSION)
+	for _, name := range []string{"a", "b", "c"} {
+		indexDefs.IndexDefs[name] = &IndexDef{
+			Type: "blackhole", Name: name, UUID: name + "-uuid",
+		}
+	}
+	_, err := CfgSetIndexDefs(cfg, indexDefs, 0)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	for _, name := range []string{"a", "c"} {
+		err = m.SetIndexLabels(name, "", map[string]string{"env": "staging"})
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+
+	err = m.SetIndexLabels("b", "wrong-uuid", map[string]string{"env": "x"})
+	if err == nil {
+		t.Errorf("expected err on mismatched indexUUID")
+	}
+	err = m.SetIndexLabels("b", "", map[string]string{"env": "x,y"})
+	if err == nil {
+		t.Errorf("expected err on invalid labels")
+	}
+
+	sel, _ := ParseLabelSelector("env=staging")
+	indexNames, err := m.IndexControlByLabels(sel, "", "pause", "")
+	if err != nil || !reflect.DeepEqual(indexNames, []string{"a", "c"}) {
+		t.Fatalf("expected a and c, got: %v, err: %v", indexNames, err)
+	}
+
+	indexDefs, _, _ = CfgGetIndexDefs(cfg)
+	for name, def := range indexDefs.IndexDefs {
+		npp := def.PlanParams.NodePlanParams[""][""]
+		paused := npp != nil && !npp.CanWrite
+		if paused != (name != "b") {
+			t.Errorf("index: %s, unexpected ingest state, paused: %v",
+				name, paused)
+		}
+		if name == "a" && def.Labels["env"] != "staging" {
+			t.Errorf("expected labels to remain after index control")
+		}
+	}
+
+	_, err = m.IndexControlByLabels(nil, "", "pause", "")
+	if err == nil {
+		t.Errorf("expected err on empty selector")
+	}
+}
@@ -0,0 +1,349 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"strconv"
+
+	log "github.com/couchbase/clog"
+)
+
+// A rolling index rebuild changes the definition of an index without
+// taking the index offline.  A shadow index is built from the new
+// definition alongside the original index, which keeps serving in the
+// meantime.  Once the shadow index has caught up with the original
+// index, the original index definition is atomically swapped, in a
+// single Cfg write, for an alias to the shadow index, so that the
+// index name then serves from the rebuilt index.  The orchestration of
+// a rebuild as a task is done by the ctl package.
+
+// IndexRebuildAliasFunc returns the alias index definition that
+// replaces the original indexDef at the end of its rebuild, and which
+// forwards to the rebuilt shadowDef.  The Name and UUID of the
+// returned alias are assigned by the swap.  Rebuilds are unsupported
+// while it's nil, as the swap needs an application specific alias.
+var IndexRebuildAliasFunc func(mgr *Manager,
+	indexDef, shadowDef *IndexDef) (*IndexDef, error)
+
+// DefaultIndexRebuildMaxSeqGap is the default number of seqs, summed
+// over the source partitions, by which a shadow index may be behind
+// its original index to be considered caught up, which can be
+// overridden by the "indexRebuildMaxSeqGap" manager option.
+var DefaultIndexRebuildMaxSeqGap = uint64(0)
+
+// IndexRebuildProgress is how far a shadow index has caught up with
+// its original index.
+type IndexRebuildProgress struct {
+	IndexName  string `json:"indexName"`
+	ShadowName string `json:"shadowName"`
+
+	// Progress ranges from 0.0 to 1.0, averaged over the source
+	// partitions of the original index.
+	Progress float64 `json:"progress"`
+
+	// SeqGap is the sum, over the source partitions, of the seqs that
+	// the original index has processed and the shadow index has not.
+	SeqGap uint64 `json:"seqGap"`
+
+	// CaughtUp is true when the shadow index is fully planned and its
+	// SeqGap is within the max seq gap, so it can be swapped in.
+	CaughtUp bool `json:"caughtUp"`
+
+	// Errors are the nodes whose seqs couldn't be retrieved, keyed by
+	// node UUID, while which the shadow index is not CaughtUp.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// IndexRebuildShadowName returns a new name for the shadow index of a
+// rebuild of an index.
+func IndexRebuildShadowName(indexName string) string {
+	return indexName + "_rebuild_" + NewUUID()
+}
+
+// StartIndexRebuild creates the shadow index of a rebuild of an index
+// from the newDef, whose empty fields default to those of the original
+// index definition.  It returns the original index definition, whose
+// UUID must be passed to SwapIndexRebuild(), and the shadow index name.
+func (mgr *Manager) StartIndexRebuild(indexName string,
+	newDef *IndexDef) (*IndexDef, string, error) {
+	shadowName := IndexRebuildShadowName(indexName)
+
+	indexDef, err := mgr.StartIndexRebuildEx(indexName, shadowName, newDef)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return indexDef, shadowName, nil
+}
+
+// StartIndexRebuildEx is StartIndexRebuild with the shadow index name
+// chosen by the caller, such as to record the rebuild before its
+// shadow index exists.
+func (mgr *Manager) StartIndexRebuildEx(indexName, shadowName string,
+	newDef *IndexDef) (*IndexDef, error) {
+	if IndexRebuildAliasFunc == nil {
+		return nil, NewBadRequestError("index_rebuild: rebuilds are" +
+			" unsupported without an IndexRebuildAliasFunc")
+	}
+
+	indexDefs, _, err := CfgGetIndexDefs(mgr.cfg)
+	if err != nil {
+		return nil, NewInternalServerError("index_rebuild:"+
+			" CfgGetIndexDefs err: %v", err)
+	}
+
+	var indexDef *IndexDef
+	if indexDefs != nil {
+		indexDef = indexDefs.IndexDefs[indexName]
+	}
+	if indexDef == nil {
+		return nil, NewBadRequestError("index_rebuild: no index,"+
+			" indexName: %s", indexName)
+	}
+
+	// Index aliases, such as of an index that was already rebuilt,
+	// have no data to rebuild.
+	pindexImplType := PIndexImplTypes[indexDef.Type]
+	if pindexImplType == nil || pindexImplType.New == nil {
+		return nil, NewBadRequestError("index_rebuild: index type: %s"+
+			" can't be rebuilt, indexName: %s", indexDef.Type, indexName)
+	}
+
+	d := *indexDef
+	if newDef != nil {
+		if newDef.Type != "" {
+			d.Type = newDef.Type
+		}
+		if newDef.Params != "" {
+			d.Params = newDef.Params
+		}
+		if newDef.SourceType != "" {
+			d.SourceType = newDef.SourceType
+		}
+		if newDef.SourceName != "" {
+			d.SourceName = newDef.SourceName
+			d.SourceUUID = newDef.SourceUUID
+		}
+		if newDef.SourceParams != "" {
+			d.SourceParams = newDef.SourceParams
+		}
+		if !reflect.DeepEqual(newDef.PlanParams, PlanParams{}) {
+			d.PlanParams = newDef.PlanParams
+		}
+		if newDef.Labels != nil {
+			d.Labels = newDef.Labels
+		}
+	}
+
+	// The shadow index is equivalent to the original index when the
+	// newDef changes nothing, so it's never deduplicated.
+	_, _, err = mgr.CreateIndexEx(&CreateIndexPayload{
+		SourceType:   d.SourceType,
+		SourceName:   d.SourceName,
+		SourceUUID:   d.SourceUUID,
+		SourceParams: d.SourceParams,
+		IndexType:    d.Type,
+		IndexName:    shadowName,
+		IndexParams:  d.Params,
+		PlanParams:   d.PlanParams,
+		Labels:       d.Labels,
+		SkipDedup:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("index_rebuild: started, indexName: %s, indexUUID: %s,"+
+		" shadowName: %s", indexName, indexDef.UUID, shadowName)
+
+	return indexDef, nil
+}
+
+// IndexRebuildProgress returns how far the shadow index of a rebuild
+// has caught up with its original index, as reported by the pindexes
+// that implement DestLastProcessed.
+func (mgr *Manager) IndexRebuildProgress(indexName,
+	shadowName string) (*IndexRebuildProgress, error) {
+	rv := &IndexRebuildProgress{
+		IndexName:  indexName,
+		ShadowName: shadowName,
+	}
+
+	planPIndexes, _, err := CfgGetPlanPIndexes(mgr.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeDefs, _, err := CfgGetNodeDefs(mgr.cfg, NODE_DEFS_WANTED)
+	if err != nil {
+		return nil, err
+	}
+
+	if planPIndexes == nil || nodeDefs == nil {
+		return rv, nil
+	}
+
+	wantFetcher := mgr.newLastProcessedFetcher(nodeDefs, indexName)
+	wantSeqs, _ := indexPrimarySeqs(wantFetcher, planPIndexes, indexName)
+
+	haveFetcher := mgr.newLastProcessedFetcher(nodeDefs, shadowName)
+	haveSeqs, planned := indexPrimarySeqs(haveFetcher, planPIndexes, shadowName)
+
+	rv.Progress, rv.SeqGap = CalcIndexRebuildProgress(wantSeqs, haveSeqs)
+
+	for _, errs := range []map[string]string{
+		wantFetcher.errs, haveFetcher.errs,
+	} {
+		for nodeUUID, err := range errs {
+			if rv.Errors == nil {
+				rv.Errors = map[string]string{}
+			}
+			rv.Errors[nodeUUID] = err
+		}
+	}
+
+	maxSeqGap := DefaultIndexRebuildMaxSeqGap
+	if v, err := strconv.ParseUint(
+		mgr.GetOption("indexRebuildMaxSeqGap"), 10, 64); err == nil {
+		maxSeqGap = v
+	}
+
+	rv.CaughtUp = planned && len(rv.Errors) == 0 && rv.SeqGap <= maxSeqGap
+
+	return rv, nil
+}
+
+// indexPrimarySeqs returns the last processed seqs of the primary
+// pindexes of an index, keyed by source partition, and whether every
+// plan pindex of the index has a primary node.
+func indexPrimarySeqs(f *lastProcessedFetcher, planPIndexes *PlanPIndexes,
+	indexName string) (map[string]QueryPartitionSeq, bool) {
+	rv := map[string]QueryPartitionSeq{}
+
+	planned := false
+
+	for _, planPIndex := range planPIndexes.PlanPIndexes {
+		if planPIndex.IndexName != indexName {
+			continue
+		}
+
+		var primary string
+		for nodeUUID, node := range planPIndex.Nodes {
+			if node != nil && node.Priority <= 0 &&
+				f.nodeDefs.NodeDefs[nodeUUID] != nil {
+				primary = nodeUUID
+				break
+			}
+		}
+		if primary == "" {
+			return rv, false
+		}
+
+		planned = true
+
+		seqs, _ := f.seqsOf(primary, planPIndex.Name)
+		for partition, seq := range seqs {
+			rv[partition] = seq
+		}
+	}
+
+	return rv, planned
+}
+
+// CalcIndexRebuildProgress returns how far the haveSeqs of a shadow
+// index have caught up with the wantSeqs of its original index, where
+// both are keyed by source partition, as a progress from 0.0 to 1.0
+// that's averaged over the source partitions, and as a seq gap.
+func CalcIndexRebuildProgress(wantSeqs,
+	haveSeqs map[string]QueryPartitionSeq) (progress float64, seqGap uint64) {
+	if len(wantSeqs) == 0 {
+		return 1.0, 0
+	}
+
+	var tot float64
+
+	for partition, want := range wantSeqs {
+		have := haveSeqs[partition]
+		if have.Seq >= want.Seq {
+			tot += 1.0
+			continue
+		}
+
+		seqGap += want.Seq - have.Seq
+		tot += float64(have.Seq) / float64(want.Seq)
+	}
+
+	return tot / float64(len(wantSeqs)), seqGap
+}
+
+// SwapIndexRebuild swaps the original index definition of a rebuild,
+// which must still have the indexUUID, for an alias to the shadow
+// index, as built by the IndexRebuildAliasFunc, in a single Cfg write.
+func (mgr *Manager) SwapIndexRebuild(indexName, indexUUID,
+	shadowName string) error {
+	if IndexRebuildAliasFunc == nil {
+		return NewBadRequestError("index_rebuild: rebuilds are" +
+			" unsupported without an IndexRebuildAliasFunc")
+	}
+
+	err := CfgRetryOnCAS("indexRebuild", 100, func() error {
+		indexDefs, cas, err := CfgGetIndexDefs(mgr.cfg)
+		if err != nil {
+			return err
+		}
+		if indexDefs == nil {
+			return NewBadRequestError("index_rebuild: no indexes,"+
+				" indexName: %s", indexName)
+		}
+		if !VersionGTE(mgr.version, indexDefs.ImplVersion) {
+			return NewInternalServerError("index_rebuild: could not swap,"+
+				" indexDefs.ImplVersion: %s > mgr.version: %s",
+				indexDefs.ImplVersion, mgr.version)
+		}
+
+		indexDef := indexDefs.IndexDefs[indexName]
+		if indexDef == nil || indexDef.UUID != indexUUID {
+			return NewBadRequestError("index_rebuild: index was changed"+
+				" or deleted during its rebuild, indexName: %s", indexName)
+		}
+
+		shadowDef := indexDefs.IndexDefs[shadowName]
+		if shadowDef == nil {
+			return NewBadRequestError("index_rebuild: shadow index was"+
+				" deleted, shadowName: %s", shadowName)
+		}
+
+		alias, err := IndexRebuildAliasFunc(mgr, indexDef, shadowDef)
+		if err != nil {
+			return err
+		}
+
+		aliasDef := *alias
+		aliasDef.Name = indexName
+		aliasDef.UUID = NewUUID()
+
+		indexDefs.UUID = NewUUID()
+		indexDefs.IndexDefs[indexName] = &aliasDef
+		indexDefs.ImplVersion = CfgGetVersion(mgr.cfg)
+
+		_, err = CfgSetIndexDefs(mgr.cfg, indexDefs, cas)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("index_rebuild: swapped, indexName: %s, shadowName: %s",
+		indexName, shadowName)
+
+	mgr.PlannerKick("api/SwapIndexRebuild, indexName: " + indexName)
+
+	return nil
+}
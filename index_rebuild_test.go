@@ -0,0 +1,163 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalcIndexRebuildProgress(t *testing.T) {
+	tests := []struct {
+		want, have  map[string]QueryPartitionSeq
+		expProgress float64
+		expSeqGap   uint64
+	}{
+		{nil, nil, 1.0, 0},
+		{
+			map[string]QueryPartitionSeq{"0": {Seq: 10}, "1": {Seq: 20}},
+			nil,
+			0.0, 30,
+		},
+		{
+			map[string]QueryPartitionSeq{"0": {Seq: 10}, "1": {Seq: 20}},
+			map[string]QueryPartitionSeq{"0": {Seq: 12}, "1": {Seq: 10}},
+			0.75, 10,
+		},
+	}
+
+	for i, test := range tests {
+		progress, seqGap := CalcIndexRebuildProgress(test.want, test.have)
+		if progress != test.expProgress || seqGap != test.expSeqGap {
+			t.Errorf("test: %d, expected: %f, %d, got: %f, %d",
+				i, test.expProgress, test.expSeqGap, progress, seqGap)
+		}
+	}
+}
+
+func TestIndexRebuild(t *testing.T) {
+	prevDataSourceUUID := DataSourceUUID
+	DataSourceUUID = func(sourceType, sourceName, sourceParams, server string,
+		options map[string]string) (string, error) {
+		return "123", nil
+	}
+
+	prevAliasFunc := IndexRebuildAliasFunc
+	IndexRebuildAliasFunc = nil
+
+	emptyDir, _ := os.MkdirTemp("./tmp", "test")
+	defer func() {
+		DataSourceUUID = prevDataSourceUUID
+		IndexRebuildAliasFunc = prevAliasFunc
+		os.RemoveAll(emptyDir)
+	}()
+
+	cfg := NewCfgMem()
+	m := NewManager(VERSION, cfg, NewUUID(), nil, "", 1, "", ":1000",
+		emptyDir, "some-datasource", nil)
+	if err := m.Start("wanted"); err != nil {
+		t.Fatalf("expected Manager.Start() to work, err: %v", err)
+	}
+	defer m.Stop()
+
+	err := m.CreateIndex("primary", "default", "123", "",
+		"blackhole", "foo", "", PlanParams{}, "")
+	if err != nil {
+		t.Fatalf("expected CreateIndex() to work, err: %v", err)
+	}
+
+	if _, _, err = m.StartIndexRebuild("foo", nil); err == nil {
+		t.Fatalf("expected err without an IndexRebuildAliasFunc")
+	}
+
+	IndexRebuildAliasFunc = func(mgr *Manager,
+		indexDef, shadowDef *IndexDef) (*IndexDef, error) {
+		return &IndexDef{
+			Type:   "alias",
+			Params: `{"target":"` + shadowDef.Name + `"}`,
+		}, nil
+	}
+
+	if _, _, err = m.StartIndexRebuild("missing", nil); err == nil {
+		t.Fatalf("expected err on a missing index")
+	}
+
+	// The shadow of an unchanged definition isn't deduplicated.
+	options := map[string]string{}
+	for k, v := range m.Options() {
+		options[k] = v
+	}
+	options["indexDedup"] = IndexDedupReuse
+	if err = m.SetOptions(options); err != nil {
+		t.Fatalf("expected SetOptions() to work, err: %v", err)
+	}
+	_, sameName, err := m.StartIndexRebuild("foo", nil)
+	if err != nil {
+		t.Fatalf("expected StartIndexRebuild() to work, err: %v", err)
+	}
+	indexDefs, _, _ := CfgGetIndexDefs(cfg)
+	if same := indexDefs.IndexDefs[sameName]; same == nil ||
+		same.UUID == indexDefs.IndexDefs["foo"].UUID {
+		t.Fatalf("expected a separate shadow index, got: %#v", same)
+	}
+	if _, err = m.DeleteIndexEx(sameName, ""); err != nil {
+		t.Fatalf("expected DeleteIndexEx() to work, err: %v", err)
+	}
+
+	indexDef, shadowName, err := m.StartIndexRebuild("foo",
+		&IndexDef{Params: `{"v":2}`})
+	if err != nil {
+		t.Fatalf("expected StartIndexRebuild() to work, err: %v", err)
+	}
+	if !strings.HasPrefix(shadowName, "foo_rebuild_") {
+		t.Errorf("unexpected shadowName: %s", shadowName)
+	}
+
+	var p *IndexRebuildProgress
+	for i := 0; i < 100; i++ {
+		p, err = m.IndexRebuildProgress("foo", shadowName)
+		if err != nil {
+			t.Fatalf("expected IndexRebuildProgress() to work, err: %v", err)
+		}
+		if p.CaughtUp {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !p.CaughtUp || p.Progress != 1.0 {
+		t.Fatalf("expected caught up, got: %#v", p)
+	}
+
+	err = m.SwapIndexRebuild("foo", "wrong-uuid", shadowName)
+	if err == nil {
+		t.Fatalf("expected err on swap of a changed index")
+	}
+
+	err = m.SwapIndexRebuild("foo", indexDef.UUID, shadowName)
+	if err != nil {
+		t.Fatalf("expected SwapIndexRebuild() to work, err: %v", err)
+	}
+
+	indexDefs, _, _ = CfgGetIndexDefs(cfg)
+	foo := indexDefs.IndexDefs["foo"]
+	if foo == nil || foo.Type != "alias" || foo.UUID == indexDef.UUID ||
+		!strings.Contains(foo.Params, shadowName) {
+		t.Errorf("expected foo to be an alias, got: %#v", foo)
+	}
+	shadow := indexDefs.IndexDefs[shadowName]
+	if shadow == nil || shadow.Params != `{"v":2}` {
+		t.Errorf("expected the shadow index, got: %#v", shadow)
+	}
+
+	if _, _, err = m.StartIndexRebuild("foo", nil); err == nil {
+		t.Errorf("expected err on a rebuild of an alias")
+	}
+}
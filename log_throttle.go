@@ -0,0 +1,267 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// LOG_THROTTLE_OPTION is the manager option that holds the JSON
+// configuration of the log throttles, keyed by component (e.g.,
+// "feed_dcp_gocbcore"), where the "default" entry applies to the
+// components that aren't listed, for example...
+//
+//	{"default": {"intervalMS": 60000, "maxPerInterval": 100},
+//	 "janitor": {"disabled": true}}
+const LOG_THROTTLE_OPTION = "logThrottle"
+
+// A LogThrottleConfig configures the log throttle of a component.
+type LogThrottleConfig struct {
+	// IntervalMS is the window over which identical messages are
+	// coalesced into a single "repeated N times" summary.
+	IntervalMS int `json:"intervalMS"`
+
+	// MaxPerInterval caps the number of distinct messages logged by
+	// the component per interval, where <= 0 means no cap.
+	MaxPerInterval int `json:"maxPerInterval"`
+
+	Disabled bool `json:"disabled"`
+}
+
+// DefaultLogThrottleConfig is the log throttle configuration of the
+// components without a configuration of their own.
+var DefaultLogThrottleConfig = LogThrottleConfig{
+	IntervalMS:     60000,
+	MaxPerInterval: 200,
+}
+
+// A LogThrottle rate limits the error and warning logging of a
+// component, such as a flapping feed that would otherwise emit the
+// same error line thousands of times per minute.  The first
+// occurrence of a message in an interval is logged, while its repeats
+// are coalesced into a summary that's logged at the end of the
+// interval.  Throttling is bypassed when the log level is debug.
+type LogThrottle struct {
+	component string
+	logger    LogThrottleLogger
+
+	m           sync.Mutex
+	config      LogThrottleConfig
+	windowStart time.Time
+	emitted     int                     // Distinct messages this window.
+	capped      int                     // Messages dropped by the cap.
+	msgs        map[string]*throttleMsg // Keyed by formatted message.
+	timer       *time.Timer             // Flushes the summaries.
+}
+
+// A LogThrottleLogger is where a log throttle writes its messages,
+// which is the clog logger of the process by default.
+type LogThrottleLogger interface {
+	Errorf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Printf(format string, args ...interface{})
+}
+
+type clogLogger struct{}
+
+func (clogLogger) Errorf(format string, args ...interface{}) {
+	log.Errorf(format, args...)
+}
+
+func (clogLogger) Warnf(format string, args ...interface{}) {
+	log.Warnf(format, args...)
+}
+
+func (clogLogger) Printf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+type throttleMsg struct {
+	logf    func(format string, args ...interface{})
+	repeats int
+}
+
+var logThrottlesM sync.Mutex
+var logThrottles = map[string]*LogThrottle{}            // Keyed by component.
+var logThrottleConfigs = map[string]LogThrottleConfig{} // Keyed by component.
+
+// LogThrottleFor returns the shared log throttle of a component.
+func LogThrottleFor(component string) *LogThrottle {
+	logThrottlesM.Lock()
+	defer logThrottlesM.Unlock()
+
+	t, exists := logThrottles[component]
+	if !exists {
+		t = newLogThrottleLOCKED(component, clogLogger{})
+		logThrottles[component] = t
+	}
+	return t
+}
+
+func newLogThrottleLOCKED(component string,
+	logger LogThrottleLogger) *LogThrottle {
+	return &LogThrottle{
+		component: component,
+		logger:    logger,
+		config:    logThrottleConfigLOCKED(component),
+		msgs:      map[string]*throttleMsg{},
+	}
+}
+
+func logThrottleConfigLOCKED(component string) LogThrottleConfig {
+	if c, exists := logThrottleConfigs[component]; exists {
+		return c
+	}
+	if c, exists := logThrottleConfigs["default"]; exists {
+		return c
+	}
+	return DefaultLogThrottleConfig
+}
+
+// ConfigureLogThrottles (re-)configures the log throttles from the
+// logThrottle option of the given manager options.
+func ConfigureLogThrottles(options map[string]string) error {
+	configs := map[string]LogThrottleConfig{}
+	if v := options[LOG_THROTTLE_OPTION]; v != "" {
+		err := UnmarshalJSON([]byte(v), &configs)
+		if err != nil {
+			return fmt.Errorf("log_throttle: invalid option: %s, err: %v",
+				LOG_THROTTLE_OPTION, err)
+		}
+	}
+
+	logThrottlesM.Lock()
+	logThrottleConfigs = configs
+	throttles := make([]*LogThrottle, 0, len(logThrottles))
+	for _, t := range logThrottles {
+		throttles = append(throttles, t)
+	}
+	logThrottlesM.Unlock()
+
+	for _, t := range throttles {
+		logThrottlesM.Lock()
+		config := logThrottleConfigLOCKED(t.component)
+		logThrottlesM.Unlock()
+
+		t.Flush()
+
+		t.m.Lock()
+		t.config = config
+		t.m.Unlock()
+	}
+
+	return nil
+}
+
+// Errorf logs an error message, subject to throttling.
+func (t *LogThrottle) Errorf(format string, args ...interface{}) {
+	t.logf(t.logger.Errorf, format, args...)
+}
+
+// Warnf logs a warning message, subject to throttling.
+func (t *LogThrottle) Warnf(format string, args ...interface{}) {
+	t.logf(t.logger.Warnf, format, args...)
+}
+
+// Printf logs a message, subject to throttling.
+func (t *LogThrottle) Printf(format string, args ...interface{}) {
+	t.logf(t.logger.Printf, format, args...)
+}
+
+func (t *LogThrottle) logf(logf func(format string, args ...interface{}),
+	format string, args ...interface{}) {
+	t.m.Lock()
+
+	if t.config.Disabled || t.config.IntervalMS <= 0 ||
+		log.GetLevel() <= log.LevelDebug {
+		t.m.Unlock()
+		logf(format, args...)
+		return
+	}
+
+	interval := time.Duration(t.config.IntervalMS) * time.Millisecond
+
+	now := time.Now()
+	if now.Sub(t.windowStart) >= interval {
+		t.flushLOCKED()
+		t.windowStart = now
+	}
+
+	msg := fmt.Sprintf(format, args...)
+
+	if tm, exists := t.msgs[msg]; exists {
+		tm.repeats++
+		t.armTimerLOCKED(interval - now.Sub(t.windowStart))
+		t.m.Unlock()
+		return
+	}
+
+	if t.config.MaxPerInterval > 0 && t.emitted >= t.config.MaxPerInterval {
+		t.capped++
+		t.armTimerLOCKED(interval - now.Sub(t.windowStart))
+		t.m.Unlock()
+		return
+	}
+
+	t.msgs[msg] = &throttleMsg{logf: logf}
+	t.emitted++
+	t.m.Unlock()
+
+	logf("%s", msg)
+}
+
+func (t *LogThrottle) armTimerLOCKED(d time.Duration) {
+	if t.timer == nil {
+		t.timer = time.AfterFunc(d, t.Flush)
+	}
+}
+
+// Flush logs the summaries of the messages that were suppressed in the
+// current interval and starts a new interval.
+func (t *LogThrottle) Flush() {
+	t.m.Lock()
+	t.flushLOCKED()
+	t.windowStart = time.Now()
+	t.m.Unlock()
+}
+
+func (t *LogThrottle) flushLOCKED() {
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+
+	for msg, tm := range t.msgs {
+		if tm.repeats > 0 {
+			tm.logf("%s (repeated %d times)", msg, tm.repeats)
+		}
+	}
+
+	if t.capped > 0 {
+		t.logger.Warnf("%s: log throttle, dropped %d messages over the cap: %d",
+			t.component, t.capped, t.config.MaxPerInterval)
+	}
+
+	t.msgs = map[string]*throttleMsg{}
+	t.emitted = 0
+	t.capped = 0
+}
+
+// refreshLogThrottles applies the current value of the logThrottle
+// option to the log throttles.
+func (mgr *Manager) refreshLogThrottles(options map[string]string) {
+	err := ConfigureLogThrottles(options)
+	if err != nil {
+		log.Warnf("manager: %v", err)
+	}
+}
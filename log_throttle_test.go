@@ -0,0 +1,115 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/couchbase/clog"
+)
+
+// A testLogThrottleLogger records the lines of a log throttle.
+type testLogThrottleLogger struct {
+	m   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *testLogThrottleLogger) Errorf(format string, args ...interface{}) {
+	l.Printf(format, args...)
+}
+
+func (l *testLogThrottleLogger) Warnf(format string, args ...interface{}) {
+	l.Printf(format, args...)
+}
+
+func (l *testLogThrottleLogger) Printf(format string, args ...interface{}) {
+	l.m.Lock()
+	fmt.Fprintf(&l.buf, format+"\n", args...)
+	l.m.Unlock()
+}
+
+func (l *testLogThrottleLogger) String() string {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.buf.String()
+}
+
+func (l *testLogThrottleLogger) Reset() {
+	l.m.Lock()
+	l.buf.Reset()
+	l.m.Unlock()
+}
+
+func TestLogThrottle(t *testing.T) {
+	buf := &testLogThrottleLogger{}
+
+	logThrottlesM.Lock()
+	logThrottles["testLogThrottle"] =
+		newLogThrottleLOCKED("testLogThrottle", buf)
+	logThrottlesM.Unlock()
+
+	defer func() {
+		logThrottlesM.Lock()
+		delete(logThrottles, "testLogThrottle")
+		logThrottlesM.Unlock()
+	}()
+	defer ConfigureLogThrottles(nil)
+
+	err := ConfigureLogThrottles(map[string]string{
+		LOG_THROTTLE_OPTION: `{"testLogThrottle":` +
+			`{"intervalMS": 3600000, "maxPerInterval": 2}}`,
+	})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	lt := LogThrottleFor("testLogThrottle")
+	for i := 0; i < 5; i++ {
+		lt.Warnf("testLogThrottle: flapping, err: %s", "boom")
+	}
+	lt.Warnf("testLogThrottle: second")
+	lt.Warnf("testLogThrottle: third")
+	lt.Warnf("testLogThrottle: fourth")
+
+	out := buf.String()
+	if strings.Count(out, "flapping, err: boom") != 1 ||
+		!strings.Contains(out, "testLogThrottle: second") ||
+		strings.Contains(out, "testLogThrottle: third") {
+		t.Fatalf("unexpected throttled output: %s", out)
+	}
+
+	lt.Flush()
+
+	out = buf.String()
+	if !strings.Contains(out, "flapping, err: boom (repeated 4 times)") ||
+		!strings.Contains(out, "dropped 2 messages over the cap: 2") {
+		t.Fatalf("expected summaries, got: %s", out)
+	}
+
+	// Debug mode bypasses the throttle.
+	prevLevel := log.GetLevel()
+	log.SetLevel(log.LevelDebug)
+	defer log.SetLevel(prevLevel)
+
+	buf.Reset()
+	lt.Warnf("testLogThrottle: debug")
+	lt.Warnf("testLogThrottle: debug")
+	if strings.Count(buf.String(), "testLogThrottle: debug") != 2 {
+		t.Fatalf("expected no throttling in debug, got: %s", buf.String())
+	}
+
+	err = ConfigureLogThrottles(map[string]string{LOG_THROTTLE_OPTION: "{"})
+	if err == nil {
+		t.Fatalf("expected err on invalid option")
+	}
+}
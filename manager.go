@@ -51,6 +51,9 @@ type Manager struct {
 	startTime time.Time
 	version   string // See VERSION.
 	cfg       Cfg
+	cfgErr    error           // Of the Cfg's tenant prefix, if any.
+	cfgPrefix string          // The Cfg's tenant prefix, if any.
+	cfgStats  CfgStats        // Of the Cfg's Get/Set/Del operations.
 	uuid      string          // Unique to every Manager instance.
 	tags      []string        // The tags at Manager start.
 	tagsMap   map[string]bool // The tags at Manager start, performance opt.
@@ -61,6 +64,7 @@ type Manager struct {
 	dataDir   string
 	server    string // The default datasource that will be indexed.
 	stopCh    chan struct{}
+	stopOnce  sync.Once     // Closes the stopCh.
 	plannerCh chan *workReq // Kicks planner that there's more work.
 	janitorCh chan *workReq // Kicks janitor that there's more work.
 	meh       ManagerEventHandlers
@@ -73,6 +77,7 @@ type Manager struct {
 	bootingPIndexes        map[string]bool    // booting flag
 	lastNodeDefs           map[string]*NodeDefs
 	lastIndexDefs          *IndexDefs
+	lastIndexDefsLoaded    bool // True after the first load of lastIndexDefs.
 	lastIndexDefsByName    map[string]*IndexDef
 	lastPlanPIndexes       *PlanPIndexes
 	lastPlanPIndexesByName map[string][]*PlanPIndex
@@ -87,17 +92,68 @@ type Manager struct {
 	eventsMutex sync.RWMutex
 	events      *list.List
 
+	changeReportsMutex sync.RWMutex
+	changeReports      *list.List // Of *ChangeReport, oldest first.
+
 	peh PlannerEventHandlerCallback
 
+	indexDefWatchers indexDefWatchers // See WatchIndexDefs().
+
+	indexDefsMigrating int32 // 1 while migrated index defs are written back.
+
 	stablePlanPIndexesMutex sync.RWMutex // Protects the local stable plan access.
 
+	transferLimiter  *TransferRateLimiter // Limits partition file transfers.
+	backfillThrottle *BackfillThrottle    // Limits concurrent DCP backfills.
+	queryThrottle    *QueryThrottle       // Limits the queries of pindexes.
+	memoryGovernor   *MemoryGovernor      // Limits the memory of pindexes.
+
+	metricsM    sync.Mutex
+	metricsPrev ManagerStats // Of the previous PublishMetrics().
+
 	// The below fields are related to hibernationa and optional.
 	objStoreClient           objcli.Client
+	hibernationLimiter       *TransferRateLimiter // Limits hibernation transfers.
 	hibernationCtx           context.Context
 	hibernationCancel        context.CancelFunc
 	bucketInHibernationMutex sync.RWMutex
-	bucketInHibernation      string
+	bucketsInHibernation     map[string]bool // Buckets being tracked.
 	bucketScopeInfoTracker   *BucketScopeInfoTracker
+
+	quarantinedPIndexesM sync.Mutex
+	quarantinedPIndexes  map[string]*QuarantinedPIndex // Keyed by pindex name.
+
+	pindexOpTimeoutsM sync.Mutex
+	pindexOpTimeouts  map[string]int // Keyed by pindex name.
+
+	rollbacks rollbackTracker // See the rollbackToZeroPolicy option.
+
+	shadowCopiesM sync.Mutex
+	shadowCopies  map[string]*ShadowCopyStatus // Keyed by shadow copy name.
+
+	compactionsM sync.Mutex
+	compactions  map[string]*CompactionTask // Keyed by pindex name.
+
+	queryMirrors queryMirrorState
+
+	alertsM       sync.Mutex
+	alerts        map[string]*Alert    // Keyed by alert name/subject.
+	feedDownSince map[string]time.Time // Keyed by pindex name.
+
+	partitionSizesM  sync.Mutex
+	oversizedIndexes map[string]*OversizedIndex // Keyed by index name.
+
+	activitySeq uint64
+	activitiesM sync.Mutex
+	activities  map[uint64]*Activity // Keyed by activity seq.
+
+	coldIndexesM sync.Mutex
+	coldIndexes  map[string]*ColdIndex // Keyed by index name.
+	coldCache    *HibernationColdCache // Made on first use.
+
+	featureFlagsM       sync.RWMutex
+	featureFlagValues   map[string]string                // Keyed by flag name.
+	featureFlagWatchers map[string][]FeatureFlagCallback // Keyed by flag name.
 }
 
 func (mgr *Manager) GetHibernationContext() (context.Context, context.CancelFunc) {
@@ -105,9 +161,12 @@ func (mgr *Manager) GetHibernationContext() (context.Context, context.CancelFunc
 }
 
 func (mgr *Manager) setHibernationContext(rateLimit uint64) {
+	mgr.hibernationLimiter = NewTransferRateLimiter(int64(rateLimit))
 	mgr.hibernationCtx = context.Background()
 	mgr.hibernationCtx, mgr.hibernationCancel = context.WithCancel(mgr.hibernationCtx)
 	mgr.hibernationCtx = context.WithValue(mgr.hibernationCtx, "rateLimit", rateLimit)
+	mgr.hibernationCtx = context.WithValue(mgr.hibernationCtx,
+		HibernationRateLimiterContextKey, mgr.hibernationLimiter)
 }
 
 func (mgr *Manager) GetObjStoreClient() objcli.Client {
@@ -125,6 +184,11 @@ func (mgr *Manager) setObjStoreClient(client objcli.Client) {
 const bucketInHibernationKey = "bucketInHibernation"
 const NoBucketInHibernation = "$"
 
+// bucketTaskKeysSep separates the "task:bucket" keys in the value of
+// the bucketInHibernationKey option when several buckets are tracked
+// for hibernation, such as during a multi-bucket pause/resume.
+const bucketTaskKeysSep = ","
+
 func (mgr *Manager) MarkBucketForHibernation(bucketTaskKey string) error {
 	if mgr.GetOption(bucketInHibernationKey) == bucketTaskKey {
 		return nil
@@ -133,50 +197,107 @@ func (mgr *Manager) MarkBucketForHibernation(bucketTaskKey string) error {
 	return mgr.SetOption(bucketInHibernationKey, bucketTaskKey, true)
 }
 
+// MarkBucketsForHibernation tracks several buckets for hibernation at
+// once, where each bucketTaskKey has the form of "task:bucket".
+func (mgr *Manager) MarkBucketsForHibernation(bucketTaskKeys []string) error {
+	if len(bucketTaskKeys) == 0 {
+		return mgr.ResetBucketTrackedForHibernation()
+	}
+
+	return mgr.MarkBucketForHibernation(
+		strings.Join(bucketTaskKeys, bucketTaskKeysSep))
+}
+
 func (mgr *Manager) ResetBucketTrackedForHibernation() error {
 	if mgr.GetOption(bucketInHibernationKey) == NoBucketInHibernation {
 		return nil
 	}
 
+	// Any rate limit override ends with the hibernation, where an
+	// empty override is used as the empty cluster options are ignored.
+	mgr.SetOption(HIBERNATION_RATE_LIMIT_OPTION, "{}", false)
+
 	return mgr.SetOption(bucketInHibernationKey, NoBucketInHibernation, true)
 }
 
+// ResetBucketTrackedForHibernationFor stops tracking only the given
+// bucket for hibernation, leaving any other tracked buckets as is.
+func (mgr *Manager) ResetBucketTrackedForHibernationFor(bucket string) error {
+	v := mgr.GetOption(bucketInHibernationKey)
+	if v == "" || v == NoBucketInHibernation {
+		return nil
+	}
+
+	var keep []string
+	for _, bucketTaskKey := range strings.Split(v, bucketTaskKeysSep) {
+		split := strings.SplitN(bucketTaskKey, ":", 2)
+		if len(split) == 2 && split[1] == bucket {
+			continue
+		}
+		keep = append(keep, bucketTaskKey)
+	}
+
+	return mgr.MarkBucketsForHibernation(keep)
+}
+
 func (mgr *Manager) IsBucketBeingHibernated(bucket string) bool {
 	if bucket == "" {
 		return false
 	}
 
 	mgr.bucketInHibernationMutex.RLock()
-	bucketInHibernation := mgr.bucketInHibernation
+	tracked := mgr.bucketsInHibernation[bucket]
 	mgr.bucketInHibernationMutex.RUnlock()
 
-	return bucketInHibernation == bucket
+	return tracked
 }
 
 func (mgr *Manager) RegisterHibernationBucketTracker(bucket string) {
 	mgr.bucketInHibernationMutex.Lock()
 	defer mgr.bucketInHibernationMutex.Unlock()
 
-	if mgr.bucketInHibernation == bucket {
+	if mgr.bucketsInHibernation[bucket] {
 		return
 	}
 
-	mgr.bucketInHibernation = bucket
+	if mgr.bucketsInHibernation == nil {
+		mgr.bucketsInHibernation = map[string]bool{}
+	}
+	mgr.bucketsInHibernation[bucket] = true
 
 	atomic.AddUint64(&mgr.stats.TotRegisterHibernationBucketTracker, 1)
 }
 
+// UnregisterBucketTracker stops tracking all buckets.
 func (mgr *Manager) UnregisterBucketTracker() {
 	mgr.bucketInHibernationMutex.Lock()
-	defer mgr.bucketInHibernationMutex.Unlock()
+	if len(mgr.bucketsInHibernation) == 0 {
+		mgr.bucketInHibernationMutex.Unlock()
+		return
+	}
+
+	mgr.bucketsInHibernation = nil
+
+	atomic.AddUint64(&mgr.stats.TotUnregisterHibernationBucketTracker, 1)
+	mgr.bucketInHibernationMutex.Unlock()
+
+	mgr.FlushHibernationChecksums()
+}
 
-	if mgr.bucketInHibernation == NoBucketInHibernation {
+// UnregisterBucketTrackerFor stops tracking only the given bucket.
+func (mgr *Manager) UnregisterBucketTrackerFor(bucket string) {
+	mgr.bucketInHibernationMutex.Lock()
+	if !mgr.bucketsInHibernation[bucket] {
+		mgr.bucketInHibernationMutex.Unlock()
 		return
 	}
 
-	mgr.bucketInHibernation = NoBucketInHibernation
+	delete(mgr.bucketsInHibernation, bucket)
 
 	atomic.AddUint64(&mgr.stats.TotUnregisterHibernationBucketTracker, 1)
+	mgr.bucketInHibernationMutex.Unlock()
+
+	mgr.FlushHibernationChecksums()
 }
 
 // Sets options in manager and optionally persists them as cluster options
@@ -185,7 +306,30 @@ func (mgr *Manager) SetOption(key, value string, cfgSet bool) error {
 	mgr.optionsMutex.Lock()
 	defer mgr.optionsMutex.Unlock()
 
-	mgr.options[key] = value
+	// The options map is copied on write, as the callers of Options()
+	// read the returned map without the lock.
+	options := make(map[string]string, len(mgr.options)+1)
+	for k, v := range mgr.options {
+		options[k] = v
+	}
+	options[key] = value
+	mgr.options = options
+
+	if key == TRANSFER_RATE_LIMIT_OPTION {
+		mgr.refreshTransferRateLimit(mgr.options)
+	}
+
+	if key == LOG_THROTTLE_OPTION {
+		mgr.refreshLogThrottles(mgr.options)
+	}
+
+	if key == QUERY_RATE_LIMITS_OPTION {
+		mgr.refreshQueryRateLimits(mgr.options)
+	}
+
+	if key == HIBERNATION_RATE_LIMIT_OPTION {
+		mgr.refreshHibernationRateLimit(mgr.options)
+	}
 
 	if !cfgSet {
 		return nil
@@ -237,7 +381,10 @@ type ManagerStats struct {
 	TotRegisterPIndex   uint64
 	TotUnregisterPIndex uint64
 
-	TotLoadDataDir uint64
+	TotLoadDataDir       uint64
+	TotPIndexQuarantined uint64
+	TotPIndexOpTimeout   uint64
+	TotWarmPIndex        uint64
 
 	TotSaveNodeDef       uint64
 	TotSaveNodeDefNil    uint64
@@ -247,12 +394,32 @@ type ManagerStats struct {
 	TotSaveNodeDefSame   uint64
 	TotSaveNodeDefOk     uint64
 
-	TotCreateIndex    uint64
-	TotCreateIndexOk  uint64
-	TotDeleteIndex    uint64
-	TotDeleteIndexOk  uint64
-	TotIndexControl   uint64
-	TotIndexControlOk uint64
+	TotCreateIndex      uint64
+	TotCreateIndexOk    uint64
+	TotCreateIndexDedup uint64
+	TotDeleteIndex      uint64
+	TotDeleteIndexOk    uint64
+	TotUndeleteIndex    uint64
+	TotUndeleteIndexOk  uint64
+	TotIndexControl     uint64
+	TotIndexControlOk   uint64
+
+	TotIndexControlByLabels uint64
+
+	TotShadowCopySyncErr uint64
+
+	TotCompactionOk  uint64
+	TotCompactionErr uint64
+
+	TotDiskQuotaRejectedMutations uint64
+
+	TotQueryThrottled uint64
+
+	TotIndexTrashed     uint64
+	TotIndexTrashPurged uint64
+	TotPIndexTombstoned uint64
+
+	TotHibernationGCRemoved uint64
 
 	TotDeleteIndexBySource    uint64
 	TotDeleteIndexBySourceErr uint64
@@ -267,6 +434,7 @@ type ManagerStats struct {
 	TotPlannerKick              uint64
 	TotPlannerKickStart         uint64
 	TotPlannerKickChanged       uint64
+	TotPlannerKickBatched       uint64
 	TotPlannerKickErr           uint64
 	TotPlannerKickOk            uint64
 	TotPlannerUnknownErr        uint64
@@ -298,6 +466,24 @@ type ManagerStats struct {
 
 	TotRegisterHibernationBucketTracker   uint64
 	TotUnregisterHibernationBucketTracker uint64
+
+	TotDestPersist    uint64
+	TotDestPersistErr uint64
+
+	TotFeedBackpressureWait uint64
+
+	TotRollbackToZero    uint64
+	TotRollbackQuiesced  uint64
+	TotRollbackStaggered uint64
+
+	TotRollbackDiagnostics uint64
+
+	TotDocTransform    uint64
+	TotDocTransformErr uint64
+
+	TotDeadLetters        uint64
+	TotDeadLettersFull    uint64
+	TotDeadLettersRetried uint64
 }
 
 // ClusterOptions stores the configurable cluster-level
@@ -331,6 +517,9 @@ type ClusterOptions struct {
 	ResourceUnderUtilizationWaterMark  string `json:"resourceUnderUtilizationWaterMark"`
 	BucketInHibernation                string `json:"bucketInHibernation"`
 	HibernationSourcePartitions        string `json:"hibernationSourcePartitions"`
+	TransferRateLimitBytesPerSec       string `json:"transferRateLimitBytesPerSec"`
+	QueryRateLimits                    string `json:"queryRateLimits"`
+	HibernationRateLimit               string `json:"hibernationRateLimit"`
 }
 
 var ErrNoIndexDefs = errors.New("no index definitions found")
@@ -367,10 +556,19 @@ func NewManagerEx(version string, cfg Cfg, uuid string, tags []string,
 		options = map[string]string{}
 	}
 
-	return &Manager{
+	// The manager's Cfg keys are those of its tenant, if any, and an
+	// invalid tenant prefix is returned by Start().
+	var cfgErr error
+	keyPrefix := options[CFG_KEY_PREFIX_OPTION]
+	if keyPrefix != "" && cfg != nil {
+		cfg, cfgErr = NewCfgPrefixed(cfg, keyPrefix)
+	}
+
+	mgr := &Manager{
 		startTime:              time.Now(),
 		version:                version,
-		cfg:                    cfg,
+		cfgErr:                 cfgErr,
+		cfgPrefix:              keyPrefix,
 		uuid:                   uuid,
 		tags:                   tags,
 		tagsMap:                StringsToMap(tags),
@@ -389,25 +587,42 @@ func NewManagerEx(version string, cfg Cfg, uuid string, tags []string,
 		janitorCh:              make(chan *workReq),
 		meh:                    meh,
 		events:                 list.New(),
+		changeReports:          list.New(),
 		bucketScopeInfoTracker: initBucketScopeInfoTracker(server),
+		transferLimiter:        newTransferRateLimiterFromOptions(options),
+		backfillThrottle:       newBackfillThrottleFromOptions(options),
+		queryThrottle:          newQueryThrottleFromOptions(options),
+		memoryGovernor:         NewMemoryGovernor(),
 
 		lastNodeDefs: make(map[string]*NodeDefs),
 	}
+
+	mgr.cfg = NewCfgMetrics(cfg, &mgr.cfgStats)
+
+	return mgr
 }
 
+// Stop stops the manager's background goroutines, and may be invoked
+// more than once, such as by concurrent shutdowns.
 func (mgr *Manager) Stop() {
-	close(mgr.stopCh)
+	mgr.stopOnce.Do(func() { close(mgr.stopCh) })
 }
 
 // Start will start and register a Manager instance with its
 // configured Cfg system, based on the register parameter.  See
 // Manager.Register().
 func (mgr *Manager) Start(register string) error {
+	if mgr.cfgErr != nil {
+		return mgr.cfgErr
+	}
+
 	err := mgr.Register(register)
 	if err != nil {
 		return err
 	}
 
+	mgr.refreshLogThrottles(mgr.Options())
+
 	if mgr.tagsMap == nil || mgr.tagsMap["pindex"] {
 		mldd := mgr.options["managerLoadDataDir"]
 		if mldd == "" || mldd == "true" {
@@ -429,6 +644,30 @@ func (mgr *Manager) Start(register string) error {
 		go mgr.JanitorKick("start")
 	}
 
+	if mgr.backfillThrottle.Enabled() {
+		go mgr.BackfillThrottleLoop()
+	}
+
+	if mgr.tagsMap == nil || mgr.tagsMap["pindex"] {
+		go mgr.DestPersistLoop()
+		go mgr.FeedBackpressureLoop()
+		go mgr.DeadLettersPersistLoop()
+		go mgr.ShadowCopyLoop()
+		go mgr.CompactionLoop()
+		go mgr.MemoryGovernorLoop()
+		go mgr.IndexTrashLoop()
+		go mgr.AlertLoop()
+	}
+
+	if mgr.tagsMap == nil || mgr.tagsMap["planner"] {
+		go mgr.ClusterSummaryLoop()
+		go mgr.PartitionSizeLoop()
+	}
+
+	if mgr.tagsMap == nil || mgr.tagsMap["planner"] || mgr.tagsMap["pindex"] {
+		go mgr.IndexDiskQuotaLoop()
+	}
+
 	return mgr.StartCfg()
 }
 
@@ -474,6 +713,16 @@ func (mgr *Manager) StartCfg() error {
 		mgr.GetLastRebalanceStatus(true)
 	})
 
+	// Routine to update the feature flags, whose cluster compatibility
+	// gates may also change with the node definitions.
+	mgr.RefreshFeatureFlags()
+	mgr.cfgObserver(componentFeatureFlags, func(e *CfgEvent) {
+		err := mgr.RefreshFeatureFlags()
+		if err != nil {
+			log.Warnf("manager: RefreshFeatureFlags, err: %v", err)
+		}
+	})
+
 	return nil
 }
 
@@ -551,7 +800,10 @@ func (mgr *Manager) SaveNodeDef(kind string, force bool) error {
 		Extras:      mgr.extras,
 	}
 
-	for {
+	// Retry if it was a CAS mismatch, as perhaps multiple nodes are all
+	// racing to register themselves, such as in a full datacenter power
+	// restart, where the jitter of the retries spreads them out.
+	err := CfgRetryOnCAS("manager", 0, func() error {
 		nodeDefs, cas, err := CfgGetNodeDefs(mgr.cfg, kind)
 		if err != nil {
 			atomic.AddUint64(&mgr.stats.TotSaveNodeDefGetErr, 1)
@@ -561,10 +813,13 @@ func (mgr *Manager) SaveNodeDef(kind string, force bool) error {
 			nodeDefs = NewNodeDefs(mgr.version)
 		}
 		nodeDefPrev, exists := nodeDefs.NodeDefs[mgr.uuid]
+		if exists {
+			// The maintenance mode outlives the node's restarts.
+			nodeDef.Maintenance = nodeDefPrev.Maintenance
+		}
 		if exists && !force {
 			if reflect.DeepEqual(nodeDefPrev, nodeDef) {
 				atomic.AddUint64(&mgr.stats.TotSaveNodeDefSame, 1)
-				atomic.AddUint64(&mgr.stats.TotSaveNodeDefOk, 1)
 				return nil // No changes, so leave the existing nodeDef.
 			}
 		}
@@ -578,16 +833,15 @@ func (mgr *Manager) SaveNodeDef(kind string, force bool) error {
 		_, err = CfgSetNodeDefs(mgr.cfg, kind, nodeDefs, cas)
 		if err != nil {
 			if _, ok := err.(*CfgCASError); ok {
-				// Retry if it was a CAS mismatch, as perhaps
-				// multiple nodes are all racing to register themselves,
-				// such as in a full datacenter power restart.
 				atomic.AddUint64(&mgr.stats.TotSaveNodeDefRetry, 1)
-				continue
+			} else {
+				atomic.AddUint64(&mgr.stats.TotSaveNodeDefSetErr, 1)
 			}
-			atomic.AddUint64(&mgr.stats.TotSaveNodeDefSetErr, 1)
-			return err
 		}
-		break
+		return err
+	})
+	if err != nil {
+		return err
 	}
 	atomic.AddUint64(&mgr.stats.TotSaveNodeDefOk, 1)
 	return nil
@@ -602,21 +856,12 @@ func (mgr *Manager) RemoveNodeDef(kind string) error {
 		return nil // Occurs during testing.
 	}
 
-	for {
-		err := CfgRemoveNodeDef(mgr.cfg, kind, mgr.uuid, CfgGetVersion(mgr.cfg))
-		if err != nil {
-			if _, ok := err.(*CfgCASError); ok {
-				// Retry if it was a CAS mismatch, as perhaps multiple
-				// nodes are racing to register/unregister themselves,
-				// such as in a full cluster power restart.
-				continue
-			}
-			return err
-		}
-		break
-	}
-
-	return nil
+	// Retry if it was a CAS mismatch, as perhaps multiple nodes are
+	// racing to register/unregister themselves, such as in a full
+	// cluster power restart.
+	return CfgRetryOnCAS("manager", 0, func() error {
+		return CfgRemoveNodeDef(mgr.cfg, kind, mgr.uuid, CfgGetVersion(mgr.cfg))
+	})
 }
 
 // bootingPIndexes maintains the loading status of pindexes
@@ -705,16 +950,19 @@ func (mgr *Manager) LoadDataDir() error {
 					continue
 				}
 				// we have already validated the pindex paths, hence feeding directly
-				pindex, err := OpenPIndex(mgr, req.path)
+				pindex, err := mgr.openPIndexWatched(req.pindexName, "", req.path)
 				if err != nil {
-					if strings.Contains(err.Error(), panicCallStack) {
-						log.Printf("manager: OpenPIndex error,"+
-							" cleaning up and trying NewPIndex,"+
-							" path: %s, err: %v", req.path, err)
-						os.RemoveAll(req.path)
+					log.Errorf("manager: could not open pindex path: %s,"+
+						" quarantining, err: %v", req.path, err)
+					errQ := mgr.quarantinePIndex(req.pindexName, req.path, err)
+					if errQ != nil {
+						log.Errorf("%v", errQ)
+						if strings.Contains(err.Error(), panicCallStack) {
+							os.RemoveAll(req.path)
+						}
 					} else {
-						log.Errorf("manager: could not open pindex path: %s, err: %v",
-							req.path, err)
+						// Have the janitor rebuild the pindex afresh.
+						mgr.janitorCh <- &workReq{op: WORK_KICK}
 					}
 				} else {
 					mgr.registerPIndex(pindex)
@@ -798,12 +1046,19 @@ func (mgr *Manager) registerPIndex(pindex *PIndex) error {
 			pindex.Name)
 	}
 
+	err := mgr.registerDocTransforms(pindex)
+	if err != nil {
+		return err
+	}
+
 	pindexes := mgr.copyPIndexesLOCKED()
 	pindexes[pindex.Name] = pindex
 	mgr.pindexes = pindexes
 	atomic.AddUint64(&mgr.stats.TotRegisterPIndex, 1)
 	mgr.coveringCache = nil
 
+	mgr.registerDeadLetters(pindex)
+
 	if mgr.meh != nil {
 		mgr.meh.OnRegisterPIndex(pindex)
 	}
@@ -834,6 +1089,13 @@ func (mgr *Manager) unregisterPIndex(name string, pindexToMatch *PIndex) *PIndex
 		atomic.AddUint64(&mgr.stats.TotUnregisterPIndex, 1)
 		mgr.coveringCache = nil
 
+		if mgr.queryThrottle != nil {
+			mgr.queryThrottle.Forget(name)
+		}
+
+		unregisterDocTransforms(pindex)
+		unregisterDeadLetters(pindex)
+
 		if mgr.meh != nil {
 			mgr.meh.OnUnregisterPIndex(pindex)
 		}
@@ -966,7 +1228,13 @@ func (mgr *Manager) GetIndexDefs(refresh bool) (lastIndexDefs *IndexDefs,
 			mgr.m.Unlock()
 			return nil, nil, err
 		}
+		mgr.migrateIndexDefs(lastIndexDefs)
+		if mgr.lastIndexDefsLoaded {
+			mgr.indexDefWatchers.notify(
+				CalcIndexDefChanges(mgr.lastIndexDefs, lastIndexDefs))
+		}
 		mgr.lastIndexDefs = lastIndexDefs
+		mgr.lastIndexDefsLoaded = true
 		atomic.AddUint64(&mgr.stats.TotRefreshLastIndexDefs, 1)
 
 		lastIndexDefsByName = make(map[string]*IndexDef)
@@ -1043,14 +1311,24 @@ var HibernationClientHook = func(string) (objcli.Client, error) {
 }
 
 // This function does the groundwork/preparation for hibernation tasks.
+// The object store client is of the HibernationClientHook, see
+// HibernationPrepareUtilWithPath() for the BlobStore of a remote path.
 func (mgr *Manager) HibernationPrepareUtil(task, bucket, remoteStorageRegion string,
 	rateLimit uint64, dryRun bool) error {
-	mgr.setHibernationContext(rateLimit)
-	objStoreClient, err := HibernationClientHook(remoteStorageRegion)
+	return mgr.HibernationPrepareUtilWithPath(task, bucket, "",
+		remoteStorageRegion, rateLimit, dryRun)
+}
+
+// HibernationPrepareUtilWithPath is HibernationPrepareUtil() with the
+// remote path of the hibernation, which selects the BlobStore of the
+// object store client and where the checksum manifests are kept.
+func (mgr *Manager) HibernationPrepareUtilWithPath(task, bucket, remotePath,
+	remoteStorageRegion string, rateLimit uint64, dryRun bool) error {
+	err := mgr.PrepareHibernationContextWithPath(remotePath,
+		remoteStorageRegion, rateLimit)
 	if err != nil {
-		return fmt.Errorf("manager: unable to get object store client: %v", err)
+		return err
 	}
-	mgr.setObjStoreClient(objStoreClient)
 
 	// Does not require bucket/task to be tracked during dry run
 	if !dryRun {
@@ -1061,6 +1339,80 @@ func (mgr *Manager) HibernationPrepareUtil(task, bucket, remoteStorageRegion str
 	return nil
 }
 
+// PrepareHibernationContext sets up the context and the object store
+// client for the transfers of the next bucket to be hibernated, where
+// the client is of the HibernationClientHook.
+func (mgr *Manager) PrepareHibernationContext(remoteStorageRegion string,
+	rateLimit uint64) error {
+	return mgr.PrepareHibernationContextWithPath("", remoteStorageRegion,
+		rateLimit)
+}
+
+// PrepareHibernationContextWithPath is PrepareHibernationContext()
+// with the remote path of the hibernation, where the client is of the
+// BlobStore selected by the remote path's scheme.  Remote paths
+// without a registered scheme use the HibernationClientHook.
+func (mgr *Manager) PrepareHibernationContextWithPath(remotePath,
+	remoteStorageRegion string, rateLimit uint64) error {
+	mgr.setHibernationContext(rateLimit)
+
+	// The hibernated pindex files are also partition file transfers of
+	// the node, so the node's transfer rate limit applies to them too.
+	objStoreClient, err := mgr.NewHibernationClient(remotePath,
+		remoteStorageRegion, mgr.hibernationLimiter, mgr.transferLimiter)
+	if err != nil {
+		return err
+	}
+	mgr.setObjStoreClient(objStoreClient)
+
+	return nil
+}
+
+// NewHibernationClient returns an object store client for the remote
+// path, whose transfers are limited by each of the limiters, and which
+// encrypts, compresses and checksums the hibernated data as configured
+// by the manager options.
+func (mgr *Manager) NewHibernationClient(remotePath,
+	remoteStorageRegion string, limiters ...*TransferRateLimiter) (
+	objcli.Client, error) {
+	clientHook := HibernationClientHook
+	if bs, err := BlobStoreForRemotePath(remotePath); err == nil {
+		clientHook = bs.NewClient
+	}
+
+	objStoreClient, err := clientHook(remoteStorageRegion)
+	if err != nil {
+		return nil, fmt.Errorf("manager: unable to get object store client: %v", err)
+	}
+
+	// Limit the transfers as seen on the wire, which are also the
+	// node's hibernation transfer activities.
+	if objStoreClient != nil {
+		for _, limiter := range limiters {
+			if limiter != nil {
+				objStoreClient = NewRateLimitedObjStoreClient(objStoreClient,
+					limiter)
+			}
+		}
+		objStoreClient = &activityObjStoreClient{Client: objStoreClient, mgr: mgr}
+	}
+
+	objStoreClient, err = mgr.encryptHibernationClient(objStoreClient)
+	if err != nil {
+		return nil, fmt.Errorf("manager: unable to encrypt object store client: %v", err)
+	}
+
+	// Compress before encrypting, as encrypted data doesn't compress.
+	objStoreClient, err = mgr.compressHibernationClient(objStoreClient)
+	if err != nil {
+		return nil, fmt.Errorf("manager: unable to compress object store client: %v", err)
+	}
+
+	// Checksum the data as seen by the pindexes, so that resume
+	// verifies the end to end result of the above.
+	return mgr.checksumHibernationClient(remotePath, objStoreClient), nil
+}
+
 func (mgr *Manager) CheckIfIndexesCanBeAdded(indexDefs *IndexDefs) error {
 	if LimitIndexDefHook != nil {
 		for _, index := range indexDefs.IndexDefs {
@@ -1379,6 +1731,13 @@ func (mgr *Manager) Cfg() Cfg {
 	return mgr.cfg
 }
 
+// CfgKeyPrefix returns the tenant prefix of the manager's Cfg keys,
+// if any, in which case the orchestration of the manager's cluster,
+// such as by the ctl, must use the manager's Cfg().
+func (mgr *Manager) CfgKeyPrefix() string {
+	return mgr.cfgPrefix
+}
+
 // Returns the UUID (the "node UUID") of a Manager.
 func (mgr *Manager) UUID() string {
 	return mgr.uuid
@@ -1484,6 +1843,29 @@ func (mgr *Manager) GetOption(key string) string {
 	return val
 }
 
+// AuthSecretOptions are the manager options that hold the secrets of
+// the REST authentication, which are never logged, or shown by the
+// REST API.
+var AuthSecretOptions = []string{"restAuthTokens", "restAuthJWTSecret"}
+
+// RedactedOptionValue replaces the values of the AuthSecretOptions.
+const RedactedOptionValue = "<redacted>"
+
+// RedactAuthSecretOptions returns a copy of the manager options with
+// the values of the AuthSecretOptions redacted.
+func RedactAuthSecretOptions(options map[string]string) map[string]string {
+	rv := make(map[string]string, len(options))
+	for k, v := range options {
+		rv[k] = v
+	}
+	for _, k := range AuthSecretOptions {
+		if _, exists := rv[k]; exists {
+			rv[k] = RedactedOptionValue
+		}
+	}
+	return rv
+}
+
 // RefreshOptions updates the local managerOptions cache
 func (mgr *Manager) RefreshOptions() error {
 	mo, _, err := CfgGetClusterOptions(mgr.cfg)
@@ -1507,8 +1889,13 @@ func (mgr *Manager) RefreshOptions() error {
 		}
 	}
 	mgr.options = newOptions
-	log.Printf("manager: RefreshOptions: %+v finished", mgr.options)
+	log.Printf("manager: RefreshOptions: %+v finished",
+		RedactAuthSecretOptions(newOptions))
 	mgr.optionsMutex.Unlock()
+	mgr.refreshTransferRateLimit(newOptions)
+	mgr.refreshLogThrottles(newOptions)
+	mgr.refreshQueryRateLimits(newOptions)
+	mgr.refreshHibernationRateLimit(newOptions)
 	// invoke any manager option refresh callbacks.
 	if mgr.meh != nil {
 		mgr.meh.OnRefreshManagerOptions(newOptions)
@@ -1540,6 +1927,10 @@ func (mgr *Manager) SetOptions(options map[string]string) error {
 	mgr.options = options
 	atomic.AddUint64(&mgr.stats.TotSetOptions, 1)
 	mgr.optionsMutex.Unlock()
+	mgr.refreshTransferRateLimit(options)
+	mgr.refreshLogThrottles(options)
+	mgr.refreshQueryRateLimits(options)
+	mgr.refreshHibernationRateLimit(options)
 	return nil
 }
 
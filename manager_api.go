@@ -93,6 +93,12 @@ type CreateIndexPayload struct {
 	PlanParams    PlanParams
 	PrevIndexUUID string
 	ScopedPrefix  string
+	Labels        map[string]string
+
+	// SkipDedup, when true, means the index is created even when it's
+	// equivalent to an existing index, such as the shadow index of a
+	// rebuild, see the "indexDedup" manager option.
+	SkipDedup bool
 }
 
 // Enforcing a maximum index name length of 209;
@@ -148,6 +154,11 @@ func (mgr *Manager) CreateIndexEx(payload *CreateIndexPayload) (string, string,
 			" than %v characters", MaxIndexNameLength)
 	}
 
+	if err := ValidateIndexLabels(payload.Labels); err != nil {
+		return adjustedIndexName, "", NewBadRequestError("manager_api: CreateIndex,"+
+			" indexName: %s, err: %v", payload.IndexName, err)
+	}
+
 	indexDef := &IndexDef{
 		Type:         payload.IndexType,
 		Name:         adjustedIndexName,
@@ -157,6 +168,8 @@ func (mgr *Manager) CreateIndexEx(payload *CreateIndexPayload) (string, string,
 		SourceUUID:   payload.SourceUUID,
 		SourceParams: payload.SourceParams,
 		PlanParams:   payload.PlanParams,
+		Labels:       payload.Labels,
+		Version:      IndexDefVersion(payload.IndexType),
 	}
 
 	pindexImplType, exists := PIndexImplTypes[payload.IndexType]
@@ -236,6 +249,49 @@ func (mgr *Manager) CreateIndexEx(payload *CreateIndexPayload) (string, string,
 			" '%v', but request for '%v'", maxReplicasAllowed, payload.PlanParams.NumReplicas)
 	}
 
+	if payload.PlanParams.MinIndexPartitions < 0 ||
+		payload.PlanParams.MaxIndexPartitions < 0 ||
+		(payload.PlanParams.MaxIndexPartitions > 0 &&
+			payload.PlanParams.MinIndexPartitions >
+				payload.PlanParams.MaxIndexPartitions) {
+		return adjustedIndexName, "", NewBadRequestError("manager_api: CreateIndex failed,"+
+			" invalid minIndexPartitions: %d, maxIndexPartitions: %d",
+			payload.PlanParams.MinIndexPartitions,
+			payload.PlanParams.MaxIndexPartitions)
+	}
+
+	if err := ValidatePriorityClass(payload.PlanParams.PriorityClass); err != nil {
+		return adjustedIndexName, "", NewBadRequestError("manager_api: CreateIndex failed,"+
+			" err: %v", err)
+	}
+
+	if err := ValidateDiskQuota(payload.PlanParams); err != nil {
+		return adjustedIndexName, "", NewBadRequestError("manager_api: CreateIndex failed,"+
+			" err: %v", err)
+	}
+
+	if err := ValidateDocTransformers(payload.IndexParams); err != nil {
+		return adjustedIndexName, "", NewBadRequestError("manager_api: CreateIndex failed,"+
+			" err: %v", err)
+	}
+
+	// Run the application's registered validators, if any.
+	var prevIndexDef *IndexDef
+	if payload.PrevIndexUUID != "" {
+		indexDefs, _, err := CfgGetIndexDefs(mgr.cfg)
+		if err != nil {
+			return adjustedIndexName, "", NewInternalServerError("manager_api: CreateIndex failed, "+
+				"CfgGetIndexDefs err: %v", err)
+		}
+		if indexDefs != nil {
+			prevIndexDef = indexDefs.IndexDefs[payload.IndexName]
+		}
+	}
+	if err := ValidateIndexDef(mgr, indexDef, prevIndexDef); err != nil {
+		return adjustedIndexName, "", fmt.Errorf("manager_api: CreateIndex failed,"+
+			" err: %w", err)
+	}
+
 	nodeDefs, _, err := CfgGetNodeDefs(mgr.cfg, NODE_DEFS_KNOWN)
 	if err != nil {
 		return adjustedIndexName, "", NewInternalServerError("manager_api: CreateIndex failed, "+
@@ -249,6 +305,10 @@ func (mgr *Manager) CreateIndexEx(payload *CreateIndexPayload) (string, string,
 
 	version := CfgGetVersion(mgr.cfg)
 
+	newIndexDef := indexDef
+
+	var dedupIndexDef *IndexDef // Existing index used instead, if any.
+
 	indexCreateFunc := func() error {
 		indexDefs, cas, err := CfgGetIndexDefs(mgr.cfg)
 		if err != nil {
@@ -270,6 +330,18 @@ func (mgr *Manager) CreateIndexEx(payload *CreateIndexPayload) (string, string,
 					" an index with the same name already exists: %s",
 					payload.IndexName)
 			}
+
+			if !payload.SkipDedup {
+				dedupIndexDef, indexDef, err =
+					mgr.dedupIndexDef(indexDefs, newIndexDef)
+				if err != nil {
+					return NewBadRequestError("manager_api: index dedup,"+
+						" indexName: %s, err: %v", payload.IndexName, err)
+				}
+				if dedupIndexDef != nil {
+					return nil
+				}
+			}
 		} else if payload.PrevIndexUUID == "*" {
 			if exists && prevIndex != nil {
 				payload.PrevIndexUUID = prevIndex.UUID
@@ -318,6 +390,13 @@ func (mgr *Manager) CreateIndexEx(payload *CreateIndexPayload) (string, string,
 			" err: %v", err)
 	}
 
+	if dedupIndexDef != nil {
+		atomic.AddUint64(&mgr.stats.TotCreateIndexOk, 1)
+		return dedupIndexDef.Name, dedupIndexDef.UUID, nil
+	}
+
+	payload.IndexType = indexDef.Type
+
 	mgr.refreshIndexDefsWithTimeout(cfgRefreshWaitExpiry)
 
 	mgr.PlannerKick("api/CreateIndex, indexName: " + payload.IndexName)
@@ -387,6 +466,7 @@ func (mgr *Manager) DeleteIndexEx(indexName, indexUUID string) (
 
 	var indexDef *IndexDef
 	var exists bool
+	var trashed bool
 
 	indexDeleteFunc := func() error {
 		indexDefs, cas, err := CfgGetIndexDefs(mgr.cfg)
@@ -412,6 +492,15 @@ func (mgr *Manager) DeleteIndexEx(indexName, indexUUID string) (
 				" indexName: %s", indexName)
 		}
 
+		// The index is trashed before its definition is deleted, so
+		// that its pindexes are tombstoned instead of removed.
+		if !trashed {
+			trashed, err = mgr.trashIndexDef(indexDef)
+			if err != nil {
+				return err
+			}
+		}
+
 		// Associated couchbase.Bucket instances and gocbcore.Agent/DCPAgent
 		// instances that are used for stats are closed by the ctl routine.
 
@@ -428,6 +517,9 @@ func (mgr *Manager) DeleteIndexEx(indexName, indexUUID string) (
 
 	err := RetryOnCASMismatch(indexDeleteFunc, 100)
 	if err != nil {
+		if trashed {
+			mgr.untrashIndexDef(indexDef.UUID)
+		}
 		return "", fmt.Errorf("manager_api: could not save indexDefs,"+
 			" err: %v", err)
 	}
@@ -493,23 +585,8 @@ func (mgr *Manager) IndexControl(indexName, indexUUID, readOp, writeOp,
 		indexDef.UUID = newIndexUUID
 		indexDefs.UUID = newIndexUUID
 
-		if indexDef.PlanParams.NodePlanParams == nil {
-			indexDef.PlanParams.NodePlanParams =
-				map[string]map[string]*NodePlanParam{}
-		}
-		if indexDef.PlanParams.NodePlanParams[""] == nil {
-			indexDef.PlanParams.NodePlanParams[""] =
-				map[string]*NodePlanParam{}
-		}
-		if indexDef.PlanParams.NodePlanParams[""][""] == nil {
-			indexDef.PlanParams.NodePlanParams[""][""] = &NodePlanParam{
-				CanRead:  true,
-				CanWrite: true,
-			}
-		}
-
 		// TODO: Allow for node UUID and planPIndex.Name inputs.
-		npp := indexDef.PlanParams.NodePlanParams[""][""]
+		npp := indexDefNodePlanParam(indexDef)
 		if readOp != "" {
 			if readOp == "allow" || readOp == "resume" {
 				npp.CanRead = true
@@ -547,6 +624,116 @@ func (mgr *Manager) IndexControl(indexName, indexUUID, readOp, writeOp,
 	return nil
 }
 
+// indexDefNodePlanParam returns the NodePlanParam of an index
+// definition that applies to all its nodes and pindexes, adding a
+// default one that allows reads and writes if needed.
+func indexDefNodePlanParam(indexDef *IndexDef) *NodePlanParam {
+	if indexDef.PlanParams.NodePlanParams == nil {
+		indexDef.PlanParams.NodePlanParams =
+			map[string]map[string]*NodePlanParam{}
+	}
+	if indexDef.PlanParams.NodePlanParams[""] == nil {
+		indexDef.PlanParams.NodePlanParams[""] =
+			map[string]*NodePlanParam{}
+	}
+	if indexDef.PlanParams.NodePlanParams[""][""] == nil {
+		indexDef.PlanParams.NodePlanParams[""][""] = &NodePlanParam{
+			CanRead:  true,
+			CanWrite: true,
+		}
+	}
+	return indexDef.PlanParams.NodePlanParams[""][""]
+}
+
+// PauseIndexIngest pauses the ingestion of the source mutations of an
+// index, while its pindexes stay loaded and queryable, such as to
+// freeze a misbehaving index.  Unlike the write op of IndexControl(),
+// the index UUID is kept, so that the pindexes aren't rebuilt or
+// restarted, and the janitors just stop their feeds.  The paused state
+// is persisted in the index definition's NodePlanParams.  A non-""
+// indexUUID must match the index's UUID.
+func (mgr *Manager) PauseIndexIngest(indexName, indexUUID string) error {
+	return mgr.setIndexIngest(indexName, indexUUID, false)
+}
+
+// ResumeIndexIngest resumes the ingestion of an index that was paused
+// by PauseIndexIngest() or by the write op of IndexControl(), where
+// the pindexes continue from their last processed seqs.
+func (mgr *Manager) ResumeIndexIngest(indexName, indexUUID string) error {
+	return mgr.setIndexIngest(indexName, indexUUID, true)
+}
+
+// IndexDefIngestPaused returns true when the ingestion of all the
+// pindexes of an index definition is paused.
+func IndexDefIngestPaused(indexDef *IndexDef) bool {
+	npp := GetNodePlanParam(indexDef.PlanParams.NodePlanParams, "", "", "")
+	return npp != nil && !npp.CanWrite
+}
+
+func (mgr *Manager) setIndexIngest(indexName, indexUUID string,
+	canWrite bool) error {
+	atomic.AddUint64(&mgr.stats.TotIndexControl, 1)
+
+	changed := false
+
+	err := CfgRetryOnCAS("indexIngest", 100, func() error {
+		indexDefs, cas, err := CfgGetIndexDefs(mgr.cfg)
+		if err != nil {
+			return err
+		}
+		if indexDefs == nil {
+			return NewBadRequestError("manager_api: no indexes,"+
+				" index ingest control, indexName: %s", indexName)
+		}
+		if !VersionGTE(mgr.version, indexDefs.ImplVersion) {
+			return NewInternalServerError("manager_api: index ingest control,"+
+				" indexName: %s, indexDefs.ImplVersion: %s > mgr.version: %s",
+				indexName, indexDefs.ImplVersion, mgr.version)
+		}
+		indexDef := indexDefs.IndexDefs[indexName]
+		if indexDef == nil {
+			return NewBadRequestError("manager_api: no index to ingest"+
+				" control, indexName: %s", indexName)
+		}
+		if indexUUID != "" && indexDef.UUID != indexUUID {
+			return NewBadRequestError("manager_api: index.UUID mismatched")
+		}
+
+		changed = false
+		if IndexDefIngestPaused(indexDef) == !canWrite {
+			return nil // Already paused or resumed.
+		}
+
+		npp := indexDefNodePlanParam(indexDef)
+		npp.CanWrite = canWrite
+		if npp.CanRead && npp.CanWrite {
+			delete(indexDef.PlanParams.NodePlanParams[""], "")
+		}
+
+		// Only the index defs UUID is refreshed, for the planners to
+		// re-run, as a new index UUID would rebuild the pindexes.
+		indexDefs.UUID = NewUUID()
+
+		_, err = CfgSetIndexDefs(mgr.cfg, indexDefs, cas)
+		changed = err == nil
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("manager_api: could not save indexDefs,"+
+			" err: %w", err)
+	}
+
+	if changed {
+		log.Printf("manager_api: index ingest control, indexName: %s,"+
+			" paused: %t", indexName, !canWrite)
+
+		mgr.PlannerKick("api/IndexIngestControl, indexName: " + indexName)
+	}
+
+	atomic.AddUint64(&mgr.stats.TotIndexControlOk, 1)
+	return nil
+}
+
 // BumpIndexDefs bumps the uuid of the index defs, to force planners
 // and other downstream tasks to re-run.
 func (mgr *Manager) BumpIndexDefs(indexDefsUUID string) error {
@@ -0,0 +1,137 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"time"
+)
+
+// MANAGER_MAX_CHANGE_REPORTS limits the number of change reports
+// tracked by a Manager.
+const MANAGER_MAX_CHANGE_REPORTS = 100
+
+// A ChangeReport records what a node changed when its planner or
+// janitor reacted to a change, such as a Cfg change, and why, which
+// helps to answer questions like "why did my index restart at 3am?".
+type ChangeReport struct {
+	Time      time.Time `json:"time"`
+	Component string    `json:"component"` // "planner" or "janitor".
+
+	// Reason is why the planner or janitor ran, such as the Cfg key
+	// and CAS that changed.
+	Reason string `json:"reason"`
+
+	PlanUUID string `json:"planUUID,omitempty"`
+	PlanCAS  uint64 `json:"planCAS,omitempty"`
+
+	PlanChanged bool `json:"planChanged,omitempty"` // From the planner.
+
+	PIndexesAdded      []string `json:"pindexesAdded,omitempty"`
+	PIndexesRemoved    []string `json:"pindexesRemoved,omitempty"`
+	PIndexesRestarted  []string `json:"pindexesRestarted,omitempty"`
+	PIndexesHibernated []string `json:"pindexesHibernated,omitempty"`
+
+	FeedsAdded   []string `json:"feedsAdded,omitempty"`
+	FeedsRemoved []string `json:"feedsRemoved,omitempty"`
+
+	Errs []string `json:"errs,omitempty"`
+
+	// Repeats counts the consecutive runs after the first that only
+	// had the same errors, the last of which was at LastRepeat, see
+	// addChangeReport().
+	Repeats    int        `json:"repeats,omitempty"`
+	LastRepeat *time.Time `json:"lastRepeat,omitempty"`
+}
+
+// IsEmpty returns true when the report has no changes or errors.
+func (r *ChangeReport) IsEmpty() bool {
+	return !r.hasChanges() && len(r.Errs) == 0
+}
+
+// hasChanges returns true when the report has any changes.
+func (r *ChangeReport) hasChanges() bool {
+	return r.PlanChanged ||
+		len(r.PIndexesAdded) > 0 ||
+		len(r.PIndexesRemoved) > 0 ||
+		len(r.PIndexesRestarted) > 0 ||
+		len(r.PIndexesHibernated) > 0 ||
+		len(r.FeedsAdded) > 0 ||
+		len(r.FeedsRemoved) > 0
+}
+
+// hasOnlyErrs returns true when the report has errors but no changes.
+func (r *ChangeReport) hasOnlyErrs() bool {
+	return len(r.Errs) > 0 && !r.hasChanges()
+}
+
+// repeats returns true when the report only has the same errors as the
+// prev report of the same component and plan.
+func (r *ChangeReport) repeats(prev *ChangeReport) bool {
+	if !r.hasOnlyErrs() || !prev.hasOnlyErrs() ||
+		r.Component != prev.Component || r.PlanUUID != prev.PlanUUID ||
+		len(r.Errs) != len(prev.Errs) {
+		return false
+	}
+	for i := range r.Errs {
+		if r.Errs[i] != prev.Errs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// addChangeReport tracks a change report, unless it's empty, evicting
+// the oldest reports beyond the MANAGER_MAX_CHANGE_REPORTS.  A report
+// that only repeats the errors of the newest report, such as of a
+// janitor that keeps failing the same way, is counted by the newest
+// report rather than tracked, so that it doesn't flood out the other
+// reports.
+func (mgr *Manager) addChangeReport(r *ChangeReport) {
+	if r == nil || r.IsEmpty() {
+		return
+	}
+
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	mgr.changeReportsMutex.Lock()
+	if p := mgr.changeReports.Back(); p != nil {
+		prev := p.Value.(*ChangeReport)
+		if r.repeats(prev) {
+			// Replaced rather than updated, as the reports returned by
+			// ChangeReports() are shared.
+			next := *prev
+			next.Repeats++
+			next.LastRepeat = &r.Time
+			p.Value = &next
+			mgr.changeReportsMutex.Unlock()
+			return
+		}
+	}
+	for mgr.changeReports.Len() >= MANAGER_MAX_CHANGE_REPORTS {
+		mgr.changeReports.Remove(mgr.changeReports.Front())
+	}
+	mgr.changeReports.PushBack(r)
+	mgr.changeReportsMutex.Unlock()
+}
+
+// ChangeReports returns the tracked change reports of the node, from
+// oldest to newest.
+func (mgr *Manager) ChangeReports() []*ChangeReport {
+	mgr.changeReportsMutex.RLock()
+	defer mgr.changeReportsMutex.RUnlock()
+
+	rv := make([]*ChangeReport, 0, mgr.changeReports.Len())
+	for p := mgr.changeReports.Front(); p != nil; p = p.Next() {
+		rv = append(rv, p.Value.(*ChangeReport))
+	}
+
+	return rv
+}
@@ -0,0 +1,319 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// The compaction scheduler compacts the pindexes of this node whose
+// PIndexImplType implements Compact(), within a daily compaction
+// window, so that the applications don't each need to schedule their
+// own compactions.  Each pindex is compacted at most once per
+// compaction interval, the least recently compacted pindexes first,
+// with at most the max concurrent compactions running at a time.  The
+// compactions that are still running when the window closes are
+// stopped, and are retried in the next window.
+
+// COMPACTION_WINDOW_OPTION is the manager option that holds the daily
+// compaction window, in the local time of the node, as "HH:MM-HH:MM",
+// where an end before the start wraps past midnight, and an empty
+// value disables the compaction scheduler.
+const COMPACTION_WINDOW_OPTION = "compactionWindow"
+
+// COMPACTION_MAX_CONCURRENT_OPTION is the manager option that holds
+// the max number of concurrent compactions on this node.
+const COMPACTION_MAX_CONCURRENT_OPTION = "compactionMaxConcurrent"
+
+// COMPACTION_INTERVAL_SECS_OPTION is the manager option that holds the
+// min number of seconds between the compactions of a pindex.
+const COMPACTION_INTERVAL_SECS_OPTION = "compactionIntervalSecs"
+
+// CompactionTickInterval is how often the compaction loop checks the
+// compaction window and starts the compactions that are due.
+var CompactionTickInterval = time.Minute
+
+// DefaultCompactionMaxConcurrent is the default max number of
+// concurrent compactions on a node.
+var DefaultCompactionMaxConcurrent = 1
+
+// DefaultCompactionIntervalSecs is the default min number of seconds
+// between the compactions of a pindex.
+var DefaultCompactionIntervalSecs = 24 * 60 * 60
+
+// The statuses of a CompactionTask.
+const (
+	CompactionStatusRunning = "running"
+	CompactionStatusDone    = "done"
+	CompactionStatusFailed  = "failed"
+	CompactionStatusStopped = "stopped" // When the window closed.
+)
+
+// A CompactionWindow is a daily window of time, as offsets from
+// midnight, where an End before the Start wraps past midnight, and an
+// End equal to the Start is the whole day.
+type CompactionWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseCompactionWindow parses a compaction window of the form
+// "HH:MM-HH:MM", returning nil for an empty string.
+func ParseCompactionWindow(s string) (*CompactionWindow, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("manager_compaction: invalid compaction"+
+			" window: %q, expected HH:MM-HH:MM", s)
+	}
+
+	var offsets [2]time.Duration
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("manager_compaction: invalid compaction"+
+				" window: %q, err: %v", s, err)
+		}
+		offsets[i] = time.Duration(t.Hour())*time.Hour +
+			time.Duration(t.Minute())*time.Minute
+	}
+
+	return &CompactionWindow{Start: offsets[0], End: offsets[1]}, nil
+}
+
+// Contains returns whether the time t, in its own location, is within
+// the compaction window.
+func (w *CompactionWindow) Contains(t time.Time) bool {
+	if w.Start == w.End {
+		return true
+	}
+
+	offset := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+
+	return offset >= w.Start || offset < w.End
+}
+
+// A CompactionTask is the latest scheduled compaction of a pindex on
+// this node.
+type CompactionTask struct {
+	PIndex    string    `json:"pindex"`
+	IndexName string    `json:"indexName"`
+	Status    string    `json:"status"`
+	Progress  float64   `json:"progress"` // From 0.0 to 1.0.
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt,omitempty"`
+	Err       string    `json:"err,omitempty"`
+
+	stopCh chan struct{} // Closed to stop a running compaction.
+}
+
+// CompactionTasks returns the latest scheduled compactions of the
+// pindexes of this node, sorted by pindex name.
+func (mgr *Manager) CompactionTasks() []*CompactionTask {
+	mgr.compactionsM.Lock()
+	defer mgr.compactionsM.Unlock()
+
+	rv := make([]*CompactionTask, 0, len(mgr.compactions))
+	for _, t := range mgr.compactions {
+		c := *t // Copy.
+		rv = append(rv, &c)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].PIndex < rv[j].PIndex
+	})
+	return rv
+}
+
+// ------------------------------------------------------------------------
+
+// CompactionLoop runs the scheduled compactions of this node, until
+// the manager is stopped.
+func (mgr *Manager) CompactionLoop() {
+	ticker := time.NewTicker(CompactionTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mgr.stopCh:
+			mgr.stopCompactions()
+			return
+		case now := <-ticker.C:
+			mgr.compactionOnce(now)
+		}
+	}
+}
+
+// compactionOnce stops the running compactions when outside of the
+// compaction window, or else starts the compactions that are due.
+func (mgr *Manager) compactionOnce(now time.Time) {
+	window, err := ParseCompactionWindow(mgr.GetOption(COMPACTION_WINDOW_OPTION))
+	if err != nil {
+		log.Warnf("%v", err)
+	}
+	if window == nil || !window.Contains(now) {
+		mgr.stopCompactions()
+		return
+	}
+
+	maxConcurrent := DefaultCompactionMaxConcurrent
+	if v, err := strconv.Atoi(
+		mgr.GetOption(COMPACTION_MAX_CONCURRENT_OPTION)); err == nil && v > 0 {
+		maxConcurrent = v
+	}
+
+	intervalSecs := DefaultCompactionIntervalSecs
+	if v, err := strconv.Atoi(
+		mgr.GetOption(COMPACTION_INTERVAL_SECS_OPTION)); err == nil && v >= 0 {
+		intervalSecs = v
+	}
+	interval := time.Duration(intervalSecs) * time.Second
+
+	_, pindexes := mgr.CurrentMaps()
+
+	mgr.compactionsM.Lock()
+	defer mgr.compactionsM.Unlock()
+
+	if mgr.compactions == nil {
+		mgr.compactions = map[string]*CompactionTask{}
+	}
+
+	running := 0
+	for name, t := range mgr.compactions {
+		if t.Status == CompactionStatusRunning {
+			running++
+		} else if pindexes[name] == nil {
+			delete(mgr.compactions, name) // The pindex was removed.
+		}
+	}
+
+	var due []*PIndex
+	for name, pindex := range pindexes {
+		pindexImplType := PIndexImplTypes[pindex.IndexType]
+		if pindexImplType == nil || pindexImplType.Compact == nil {
+			continue
+		}
+
+		t := mgr.compactions[name]
+		if t == nil || t.Status == CompactionStatusStopped ||
+			(t.Status != CompactionStatusRunning &&
+				now.Sub(t.StartedAt) >= interval) {
+			due = append(due, pindex)
+		}
+	}
+
+	// The least recently compacted pindexes go first.
+	sort.Slice(due, func(i, j int) bool {
+		ti, tj := mgr.compactions[due[i].Name], mgr.compactions[due[j].Name]
+		if ti == nil || tj == nil {
+			if ti == nil && tj == nil {
+				return due[i].Name < due[j].Name
+			}
+			return ti == nil
+		}
+		if !ti.StartedAt.Equal(tj.StartedAt) {
+			return ti.StartedAt.Before(tj.StartedAt)
+		}
+		return due[i].Name < due[j].Name
+	})
+
+	for _, pindex := range due {
+		if running >= maxConcurrent {
+			break
+		}
+		running++
+
+		t := &CompactionTask{
+			PIndex:    pindex.Name,
+			IndexName: pindex.IndexName,
+			Status:    CompactionStatusRunning,
+			StartedAt: now,
+			stopCh:    make(chan struct{}),
+		}
+		mgr.compactions[pindex.Name] = t
+
+		go mgr.runCompaction(pindex, t)
+	}
+}
+
+// runCompaction compacts a pindex, and updates its compaction task.
+func (mgr *Manager) runCompaction(pindex *PIndex, t *CompactionTask) {
+	log.Printf("manager_compaction: compacting, pindex: %s", pindex.Name)
+
+	done := mgr.StartActivity(ACTIVITY_COMPACTION, pindex.Name,
+		pindex.IndexName, "")
+	defer done()
+
+	err := PIndexImplTypes[pindex.IndexType].Compact(pindex, t.stopCh,
+		func(progress float64) {
+			mgr.compactionsM.Lock()
+			t.Progress = progress
+			mgr.compactionsM.Unlock()
+		})
+
+	mgr.compactionsM.Lock()
+	defer mgr.compactionsM.Unlock()
+
+	t.EndedAt = time.Now()
+
+	select {
+	case <-t.stopCh:
+		t.Status = CompactionStatusStopped
+		log.Printf("manager_compaction: stopped, pindex: %s", pindex.Name)
+		return
+	default:
+	}
+
+	if err != nil {
+		t.Status = CompactionStatusFailed
+		t.Err = err.Error()
+		atomic.AddUint64(&mgr.stats.TotCompactionErr, 1)
+		log.Warnf("manager_compaction: pindex: %s, err: %v", pindex.Name, err)
+		return
+	}
+
+	t.Status = CompactionStatusDone
+	t.Progress = 1.0
+	atomic.AddUint64(&mgr.stats.TotCompactionOk, 1)
+	log.Printf("manager_compaction: compacted, pindex: %s, took: %s",
+		pindex.Name, t.EndedAt.Sub(t.StartedAt))
+}
+
+// stopCompactions stops the running compactions.
+func (mgr *Manager) stopCompactions() {
+	mgr.compactionsM.Lock()
+	defer mgr.compactionsM.Unlock()
+
+	for _, t := range mgr.compactions {
+		if t.Status != CompactionStatusRunning {
+			continue
+		}
+		select {
+		case <-t.stopCh:
+		default:
+			close(t.stopCh)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCompactionWindow(t *testing.T) {
+	if w, err := ParseCompactionWindow(""); w != nil || err != nil {
+		t.Fatalf("expected no window, got: %v, %v", w, err)
+	}
+	for _, s := range []string{"1:00", "01:00-", "25:00-01:00", "a-b"} {
+		if _, err := ParseCompactionWindow(s); err == nil {
+			t.Errorf("expected err on window: %q", s)
+		}
+	}
+
+	at := func(hhmm string) time.Time {
+		tm, _ := time.Parse("15:04", hhmm)
+		return tm
+	}
+
+	tests := []struct {
+		window string
+		at     string
+		exp    bool
+	}{
+		{"01:00-05:00", "00:59", false},
+		{"01:00-05:00", "01:00", true},
+		{"01:00-05:00", "04:59", true},
+		{"01:00-05:00", "05:00", false},
+		{"22:00-02:00", "23:30", true},
+		{"22:00-02:00", "01:30", true},
+		{"22:00-02:00", "12:00", false},
+		{"03:00-03:00", "12:00", true},
+	}
+
+	for _, test := range tests {
+		w, err := ParseCompactionWindow(test.window)
+		if err != nil {
+			t.Fatalf("window: %s, err: %v", test.window, err)
+		}
+		if w.Contains(at(test.at)) != test.exp {
+			t.Errorf("window: %s, at: %s, expected: %t",
+				test.window, test.at, test.exp)
+		}
+	}
+}
+
+func TestCompactionScheduler(t *testing.T) {
+	releaseCh := make(chan struct{})
+	compactedCh := make(chan string, 10)
+
+	PIndexImplTypes["testCompaction"] = &PIndexImplType{
+		Compact: func(pindex *PIndex, stopCh <-chan struct{},
+			progress func(float64)) error {
+			progress(0.5)
+			select {
+			case <-stopCh:
+				return fmt.Errorf("stopped")
+			case <-releaseCh:
+			}
+			compactedCh <- pindex.Name
+			if pindex.Name == "p2" {
+				return fmt.Errorf("boom")
+			}
+			return nil
+		},
+	}
+	defer delete(PIndexImplTypes, "testCompaction")
+
+	mgr := NewManagerEx(VERSION, nil, NewUUID(), nil,
+		"", 1, "", "", "", "", nil, map[string]string{
+			COMPACTION_WINDOW_OPTION:         "00:00-00:00",
+			COMPACTION_MAX_CONCURRENT_OPTION: "2",
+		})
+	for _, name := range []string{"p0", "p1", "p2"} {
+		mgr.registerPIndex(&PIndex{Name: name, IndexName: "idx",
+			IndexType: "testCompaction"})
+	}
+	mgr.registerPIndex(&PIndex{Name: "other", IndexType: "blackhole"})
+
+	waitFor := func(cond func([]*CompactionTask) bool) []*CompactionTask {
+		for i := 0; i < 100; i++ {
+			tasks := mgr.CompactionTasks()
+			if cond(tasks) {
+				return tasks
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("timed out, tasks: %#v", mgr.CompactionTasks())
+		return nil
+	}
+
+	count := func(tasks []*CompactionTask, status string) (rv int) {
+		for _, task := range tasks {
+			if task.Status == status {
+				rv++
+			}
+		}
+		return rv
+	}
+
+	now := time.Now()
+
+	mgr.compactionOnce(now)
+
+	tasks := waitFor(func(tasks []*CompactionTask) bool {
+		return len(tasks) == 2 && tasks[0].Progress == 0.5 &&
+			tasks[1].Progress == 0.5
+	})
+	if tasks[0].PIndex != "p0" || tasks[1].PIndex != "p1" ||
+		count(tasks, CompactionStatusRunning) != 2 {
+		t.Fatalf("expected p0 and p1 running, got: %#v", tasks)
+	}
+	if n := len(mgr.LocalActivities().Activities); n != 2 {
+		t.Errorf("expected 2 compaction activities, got: %d", n)
+	}
+
+	// Closing the window stops the running compactions, which are
+	// retried in the next window.
+	mgr.options[COMPACTION_WINDOW_OPTION] = ""
+	mgr.compactionOnce(now)
+
+	waitFor(func(tasks []*CompactionTask) bool {
+		return count(tasks, CompactionStatusStopped) == 2
+	})
+
+	mgr.options[COMPACTION_MAX_CONCURRENT_OPTION] = "3"
+	mgr.options[COMPACTION_WINDOW_OPTION] = "00:00-00:00"
+	mgr.compactionOnce(now)
+
+	close(releaseCh)
+
+	tasks = waitFor(func(tasks []*CompactionTask) bool {
+		return len(tasks) == 3 && count(tasks, CompactionStatusRunning) == 0
+	})
+	if tasks[0].Status != CompactionStatusDone || tasks[0].Progress != 1.0 ||
+		tasks[1].Status != CompactionStatusDone ||
+		tasks[2].Status != CompactionStatusFailed || tasks[2].Err != "boom" {
+		t.Fatalf("unexpected tasks: %#v", tasks)
+	}
+	if len(compactedCh) != 3 {
+		t.Errorf("expected 3 compactions, got: %d", len(compactedCh))
+	}
+
+	// Nothing is due again within the compaction interval.
+	mgr.compactionOnce(now.Add(time.Hour))
+	if n := count(mgr.CompactionTasks(), CompactionStatusRunning); n != 0 {
+		t.Errorf("expected no compactions, got: %d", n)
+	}
+}
@@ -10,6 +10,7 @@ package cbgt
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"math"
 	"os"
@@ -132,7 +133,8 @@ func (mgr *Manager) partiallyRollbackPIndex(pindex *PIndex) error {
 func (mgr *Manager) JanitorLoop() {
 	mgr.cfgObserver(componentJanitor, func(cfgEvent *CfgEvent) {
 		atomic.AddUint64(&mgr.stats.TotJanitorSubscriptionEvent, 1)
-		mgr.JanitorKick("cfg changed, key: " + cfgEvent.Key)
+		mgr.JanitorKick(fmt.Sprintf("cfg changed, key: %s, cas: %d",
+			cfgEvent.Key, cfgEvent.CAS))
 	})
 
 	for {
@@ -150,7 +152,10 @@ func (mgr *Manager) JanitorLoop() {
 
 			if m.op == WORK_KICK {
 				atomic.AddUint64(&mgr.stats.TotJanitorKickStart, 1)
-				err = mgr.JanitorOnce(m.msg)
+				err = MetricsTime(GetMetricsProvider().Histogram(
+					"cbgt_janitor_seconds", nil), func() error {
+					return mgr.JanitorOnce(m.msg)
+				})
 				if err != nil {
 					// Keep looping as perhaps it's a transient issue.
 					// TODO: Perhaps need a rescheduled janitor kick.
@@ -508,6 +513,10 @@ func (mgr *Manager) hibernatePIndex(req []*pindexHibernateReq) []error {
 				errs = append(errs, hibErrs...)
 			}
 		}
+
+		// Flushed whether or not the uploads failed, so that the
+		// checksums of the uploads so far are kept.
+		mgr.FlushHibernationChecksums()
 	}
 
 	return errs
@@ -525,7 +534,7 @@ func (mgr *Manager) JanitorOnce(reason string) error {
 	// because instead some planner will see that & update the plan;
 	// then relevant janitors will react by closing pindexes & feeds.
 
-	planPIndexes, _, err := CfgGetPlanPIndexes(mgr.cfg)
+	planPIndexes, planCAS, err := CfgGetPlanPIndexes(mgr.cfg)
 	if err != nil {
 		return fmt.Errorf("janitor: skipped on CfgGetPlanPIndexes err: %v", err)
 	}
@@ -582,28 +591,31 @@ func (mgr *Manager) JanitorOnce(reason string) error {
 	errs = append(errs, mgr.pindexesStop(pindexesToRemove)...)
 	// Then, (re-)create pindexes that we're missing.
 	// batching the start, aiming to expedite the
-	// whole JanitorOnce call
+	// whole JanitorOnce call, where the pindexes of the
+	// higher priority classes are started first.
+	SortPlanPIndexesByPriorityClass(planPIndexesToAdd, mgr.IndexPriorityClass)
 	errs = append(errs, mgr.pindexesStart(planPIndexesToAdd)...)
 
 	var currFeeds map[string]Feed
 	currFeeds, currPIndexes = mgr.CurrentMaps()
 
-	hibernationTask, hibernationBucket, hibernationSourceType :=
-		mgr.findHibernationBucketsToMonitor()
-
-	if hibernationTask == UNHIBERNATE_TASK {
-		log.Printf("janitor: bucket to track for unhibernation: %s", hibernationBucket)
-		mgr.trackResumeBucketState(hibernationBucket, hibernationSourceType)
-	}
+	for _, hb := range mgr.findHibernationBucketsToMonitor() {
+		if hb.task == UNHIBERNATE_TASK {
+			log.Printf("janitor: bucket to track for unhibernation: %s", hb.bucket)
+			mgr.trackResumeBucketState(hb.bucket, hb.sourceType)
+		}
 
-	if hibernationTask == HIBERNATE_TASK {
-		log.Printf("janitor: bucket to track for hibernation: %s", hibernationBucket)
-		mgr.trackPauseBucketState(hibernationBucket, hibernationSourceType)
+		if hb.task == HIBERNATE_TASK {
+			log.Printf("janitor: bucket to track for hibernation: %s", hb.bucket)
+			mgr.trackPauseBucketState(hb.bucket, hb.sourceType)
+		}
 	}
 
+	// The pindexes of the shadow copy targets are read-only, so their
+	// feeds are removed.
 	addFeeds, removeFeeds :=
-		CalcFeedsDelta(mgr.uuid, planPIndexes, currFeeds, currPIndexes,
-			feedAllotment)
+		CalcFeedsDelta(mgr.uuid, planPIndexes, currFeeds,
+			mgr.withoutShadowCopyTargets(currPIndexes), feedAllotment)
 
 	// filter out non-ready feeds.
 	addFeeds = filterFeedable(mgr, addFeeds)
@@ -637,6 +649,44 @@ func (mgr *Manager) JanitorOnce(reason string) error {
 		}
 	}
 
+	report := &ChangeReport{
+		Component: "janitor",
+		Reason:    reason,
+		PlanUUID:  planPIndexes.UUID,
+		PlanCAS:   planCAS,
+	}
+	for _, ppi := range planPIndexesToAdd {
+		report.PIndexesAdded = append(report.PIndexesAdded, ppi.Name)
+	}
+	for _, pi := range pindexesToRemove {
+		report.PIndexesRemoved = append(report.PIndexesRemoved, pi.Name)
+	}
+	for _, pi := range pindexesToRestart {
+		if pi.pindex != nil {
+			report.PIndexesRestarted = append(report.PIndexesRestarted,
+				pi.pindex.Name)
+		}
+	}
+	for _, pi := range pindexesToHibernate {
+		if pi != nil && pi.pindex != nil {
+			report.PIndexesHibernated = append(report.PIndexesHibernated,
+				pi.pindex.Name)
+		}
+	}
+	for _, removeFeed := range removeFeeds {
+		report.FeedsRemoved = append(report.FeedsRemoved, removeFeed.Name())
+	}
+	for _, targetPIndexes := range addFeeds {
+		if len(targetPIndexes) > 0 {
+			report.FeedsAdded = append(report.FeedsAdded,
+				FeedNameForPIndex(targetPIndexes[0], feedAllotment))
+		}
+	}
+	for _, err := range errs {
+		report.Errs = append(report.Errs, err.Error())
+	}
+	mgr.addChangeReport(report)
+
 	if len(errs) > 0 {
 		var s []string
 		for i, err := range errs {
@@ -1135,44 +1185,83 @@ func CalcPIndexesDelta(mgrUUID string,
 // --------------------------------------------------------
 
 func (mgr *Manager) GetHibernationBucketAndTask() (string, string) {
-	// Assuming that only one bucket can be tracked at a time, since only 1 bucket
-	// can be paused/resumed at a time.
+	// When several buckets are tracked, such as during a multi-bucket
+	// pause/resume, this returns only the first of them.
+	bucketTasks := mgr.GetHibernationBucketsAndTasks()
+	if len(bucketTasks) == 0 {
+		return "", ""
+	}
+
+	return bucketTasks[0][1], bucketTasks[0][0]
+}
+
+// GetHibernationBucketsAndTasks returns the [task, bucket] pairs of
+// all the buckets that are tracked for hibernation.
+func (mgr *Manager) GetHibernationBucketsAndTasks() [][2]string {
 	bucketTaskInHibernation := mgr.GetOption(bucketInHibernationKey)
 	if bucketTaskInHibernation == NoBucketInHibernation {
 		log.Printf("janitor: no hibernation bucket to track right now")
 		// Removing any remaining trackers
 		mgr.UnregisterBucketTracker()
-		return "", ""
+		return nil
 	}
-	split := strings.SplitN(bucketTaskInHibernation, ":", 2)
-	if len(split) < 2 {
-		return "", ""
+
+	var rv [][2]string
+	for _, bucketTaskKey := range strings.Split(bucketTaskInHibernation,
+		bucketTaskKeysSep) {
+		split := strings.SplitN(bucketTaskKey, ":", 2)
+		if len(split) < 2 {
+			continue
+		}
+		rv = append(rv, [2]string{split[0], split[1]})
 	}
-	hibernationTask, bucketInHibernation := split[0], split[1]
 
-	return bucketInHibernation, hibernationTask
+	return rv
 }
 
-// This function returns the hibernation task type and the hibernating bucket
-// and source type if it isn't being tracked.
+// A hibernationBucketToMonitor is a hibernating bucket whose state
+// needs to be tracked.
+type hibernationBucketToMonitor struct {
+	task       string
+	bucket     string
+	sourceType string
+}
+
+// This function returns the hibernation task type, the hibernating bucket
+// and source type of each hibernating bucket that isn't being tracked.
 // 'Tracking' these buckets involves starting routines which track change in
 // these buckets' states.
-func (mgr *Manager) findHibernationBucketsToMonitor() (string, string, string) {
-	bucketInHibernation, hibernationTask := mgr.GetHibernationBucketAndTask()
+func (mgr *Manager) findHibernationBucketsToMonitor() []hibernationBucketToMonitor {
+	bucketTasks := mgr.GetHibernationBucketsAndTasks()
+	if len(bucketTasks) == 0 {
+		return nil
+	}
 
-	currBucketBeingTracked := mgr.bucketInHibernation
+	var rv []hibernationBucketToMonitor
 
 	// If a bucket is there in cluster options, it has to be tracked
 	_, currPIndexes := mgr.CurrentMaps()
-	for _, pindex := range currPIndexes {
+	for _, bucketTask := range bucketTasks {
+		hibernationTask, bucketInHibernation := bucketTask[0], bucketTask[1]
+
 		// Track the bucket if not already being tracked.
-		if pindex.SourceName == bucketInHibernation &&
-			currBucketBeingTracked != bucketInHibernation {
-			return hibernationTask, bucketInHibernation, pindex.SourceType
+		if mgr.IsBucketBeingHibernated(bucketInHibernation) {
+			continue
+		}
+
+		for _, pindex := range currPIndexes {
+			if pindex.SourceName == bucketInHibernation {
+				rv = append(rv, hibernationBucketToMonitor{
+					task:       hibernationTask,
+					bucket:     bucketInHibernation,
+					sourceType: pindex.SourceType,
+				})
+				break
+			}
 		}
 	}
 
-	return "", "", ""
+	return rv
 }
 
 // --------------------------------------------------------
@@ -1310,12 +1399,30 @@ func (mgr *Manager) startPIndex(planPIndex *PlanPIndex) error {
 	var pindex *PIndex
 	var err error
 
+	// Delay the opening while the node is over its memory quota, where
+	// the governor kicks the janitor to retry once memory frees up.
+	err = mgr.memoryGovernor.Admit(mgr.stopCh, MemoryGovernorAdmitTimeout)
+	if err != nil {
+		return fmt.Errorf("janitor: startPIndex, name: %s, err: %w",
+			planPIndex.Name, err)
+	}
+
 	path := mgr.PIndexPath(planPIndex.Name)
 	// First, try reading the path with OpenPIndex().  An
-	// existing path might happen during a case of rollback.
+	// existing path might happen during a case of rollback, or
+	// from the tombstoned files of an undeleted index.
 	_, err = os.Stat(path)
+	if err != nil && mgr.restoreTrashedPIndex(planPIndex.Name, path) {
+		_, err = os.Stat(path)
+	}
 	if err == nil {
-		pindex, err = OpenPIndex(mgr, path)
+		pindex, err = mgr.openPIndexWatched(planPIndex.Name,
+			planPIndex.IndexType, path)
+		if errors.Is(err, ErrPIndexOpTimeout) {
+			// Leave the files of the hung pindex alone, as the watchdog
+			// quarantines them after repeated timeouts.
+			return err
+		}
 		if err != nil {
 			log.Errorf("janitor: startPIndex, OpenPIndex error,"+
 				" cleaning up and trying NewPIndex,"+
@@ -1397,11 +1504,15 @@ func (mgr *Manager) stopPIndex(pindex *PIndex, remove bool) error {
 
 	if remove {
 		atomic.AddUint64(&mgr.stats.TotJanitorRemovePIndex, 1)
+
+		if pindex.Path != "" && mgr.trashedIndexKeepsData(pindex.IndexUUID) {
+			return mgr.tombstonePIndex(pindex)
+		}
 	} else {
 		atomic.AddUint64(&mgr.stats.TotJanitorClosePIndex, 1)
 	}
 
-	return pindex.Close(remove)
+	return mgr.closePIndexWatched(pindex, remove)
 }
 
 // --------------------------------------------------------
@@ -13,7 +13,9 @@ import (
 	"hash/crc32"
 	"io"
 	"math"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -125,11 +127,40 @@ func (mgr *Manager) PlannerKick(msg string) {
 	}
 }
 
+// isPlannerLeader returns true when this node is the elected planner
+// node of the cluster-wide periodic checks, which is the wanted planner
+// node of the lowest UUID, so that each check runs once per cluster
+// rather than once per planner node.
+func (mgr *Manager) isPlannerLeader() bool {
+	if mgr.cfg == nil {
+		return false
+	}
+
+	nodeDefs, _, err := CfgGetNodeDefs(mgr.cfg, NODE_DEFS_WANTED)
+	if err != nil || nodeDefs == nil {
+		return false
+	}
+
+	leader := ""
+	for uuid, nodeDef := range nodeDefs.NodeDefs {
+		if len(nodeDef.Tags) > 0 &&
+			!StringsToMap(nodeDef.Tags)["planner"] {
+			continue
+		}
+		if leader == "" || uuid < leader {
+			leader = uuid
+		}
+	}
+
+	return leader == mgr.uuid
+}
+
 // PlannerLoop is the main loop for the planner.
 func (mgr *Manager) PlannerLoop() {
 	mgr.cfgObserver(componentPlanner, func(cfgEvent *CfgEvent) {
 		atomic.AddUint64(&mgr.stats.TotPlannerSubscriptionEvent, 1)
-		mgr.PlannerKick("cfg changed, key: " + cfgEvent.Key)
+		mgr.PlannerKick(fmt.Sprintf("cfg changed, key: %s, cas: %d",
+			cfgEvent.Key, cfgEvent.CAS))
 	})
 
 	for {
@@ -139,48 +170,81 @@ func (mgr *Manager) PlannerLoop() {
 			return
 
 		case m := <-mgr.plannerCh:
-			atomic.AddUint64(&mgr.stats.TotPlannerOpStart, 1)
+			// A burst of kicks is batched into a single planning, and
+			// so into at most a single write of the plans.
+			reqs := []*workReq{m}
+			if m.op == WORK_KICK {
+				reqs = mgr.plannerBatch(m)
+			}
 
-			log.Printf("planner: awakes, op: %v, msg: %s", m.op, m.msg)
+			kicked := false
 
-			var err error
+			for _, m := range reqs {
+				atomic.AddUint64(&mgr.stats.TotPlannerOpStart, 1)
 
-			if m.op == WORK_KICK {
-				atomic.AddUint64(&mgr.stats.TotPlannerKickStart, 1)
-				changed, err2 := mgr.PlannerOnce(m.msg)
-				if err2 != nil {
-					log.Warnf("planner: PlannerOnce, err: %v", err2)
-					atomic.AddUint64(&mgr.stats.TotPlannerKickErr, 1)
-					// Keep looping as perhaps it's a transient issue.
-				} else {
-					if changed {
-						atomic.AddUint64(&mgr.stats.TotPlannerKickChanged, 1)
-						mgr.JanitorKick("the plans have changed")
+				log.Printf("planner: awakes, op: %v, msg: %s", m.op, m.msg)
+
+				var err error
+
+				if m.op == WORK_KICK {
+					if !kicked {
+						kicked = true
+						mgr.plannerKickOnce(m.msg, len(reqs))
 					}
-					atomic.AddUint64(&mgr.stats.TotPlannerKickOk, 1)
+				} else if m.op == WORK_NOOP {
+					atomic.AddUint64(&mgr.stats.TotPlannerNOOPOk, 1)
+				} else {
+					err = fmt.Errorf("planner: unknown op: %s, m: %#v", m.op, m)
+					atomic.AddUint64(&mgr.stats.TotPlannerUnknownErr, 1)
 				}
-			} else if m.op == WORK_NOOP {
-				atomic.AddUint64(&mgr.stats.TotPlannerNOOPOk, 1)
-			} else {
-				err = fmt.Errorf("planner: unknown op: %s, m: %#v", m.op, m)
-				atomic.AddUint64(&mgr.stats.TotPlannerUnknownErr, 1)
-			}
 
-			atomic.AddUint64(&mgr.stats.TotPlannerOpRes, 1)
+				atomic.AddUint64(&mgr.stats.TotPlannerOpRes, 1)
 
-			if m.resCh != nil {
-				if err != nil {
-					atomic.AddUint64(&mgr.stats.TotPlannerOpErr, 1)
-					m.resCh <- err
+				if m.resCh != nil {
+					if err != nil {
+						atomic.AddUint64(&mgr.stats.TotPlannerOpErr, 1)
+						m.resCh <- err
+					}
+					close(m.resCh)
 				}
-				close(m.resCh)
-			}
 
-			atomic.AddUint64(&mgr.stats.TotPlannerOpDone, 1)
+				atomic.AddUint64(&mgr.stats.TotPlannerOpDone, 1)
+			}
 		}
 	}
 }
 
+// plannerKickOnce runs the planner once for a kick, or for a batch of
+// numReqs requests that start with the kick.
+func (mgr *Manager) plannerKickOnce(msg string, numReqs int) {
+	atomic.AddUint64(&mgr.stats.TotPlannerKickStart, 1)
+
+	reason := msg
+	if numReqs > 1 {
+		reason = fmt.Sprintf("%s, batched with %d more requests",
+			msg, numReqs-1)
+	}
+
+	var changed bool
+	err := MetricsTime(GetMetricsProvider().Histogram(
+		"cbgt_planner_seconds", nil), func() (err error) {
+		changed, err = mgr.PlannerOnce(reason)
+		return err
+	})
+	if err != nil {
+		log.Warnf("planner: PlannerOnce, err: %v", err)
+		atomic.AddUint64(&mgr.stats.TotPlannerKickErr, 1)
+		// Keep looping as perhaps it's a transient issue.
+		return
+	}
+
+	if changed {
+		atomic.AddUint64(&mgr.stats.TotPlannerKickChanged, 1)
+		mgr.JanitorKick("the plans have changed")
+	}
+	atomic.AddUint64(&mgr.stats.TotPlannerKickOk, 1)
+}
+
 // PlannerOnce is the main body of a PlannerLoop.
 func (mgr *Manager) PlannerOnce(reason string) (bool, error) {
 	log.Printf("planner: once, reason: %s", reason)
@@ -189,8 +253,20 @@ func (mgr *Manager) PlannerOnce(reason string) (bool, error) {
 		return false, fmt.Errorf("planner: skipped due to nil cfg")
 	}
 
-	return Plan(mgr.cfg, mgr.version, mgr.uuid, mgr.server,
+	changed, err := Plan(mgr.cfg, mgr.version, mgr.uuid, mgr.server,
 		mgr.Options(), nil)
+
+	report := &ChangeReport{
+		Component:   "planner",
+		Reason:      reason,
+		PlanChanged: changed,
+	}
+	if err != nil {
+		report.Errs = []string{err.Error()}
+	}
+	mgr.addChangeReport(report)
+
+	return changed, err
 }
 
 // A PlannerFilter callback func should return true if the plans for
@@ -214,6 +290,11 @@ func SetLastComputedPlanTime(plan time.Time) {
 	lastComputedPlanTime = plan
 }
 
+// PlannerCASMaxTries is the max number of tries of the planner to save
+// a new plan when it conflicts with a concurrent planner, where the
+// plan is recalculated from the latest Cfg on every try.
+var PlannerCASMaxTries = 10
+
 // Plan runs the planner once.
 func Plan(cfg Cfg, version, uuid, server string, options map[string]string,
 	plannerFilter PlannerFilter) (bool, error) {
@@ -222,10 +303,31 @@ func Plan(cfg Cfg, version, uuid, server string, options map[string]string,
 	// planning in the next iteration.
 	SetLastComputedPlanTime(time.Now())
 
+	var changed bool
+	var cas uint64
+	err := CfgRetryOnCAS("planner", PlannerCASMaxTries, func() (err error) {
+		changed, cas, err = planOnce(cfg, version, uuid, server, options,
+			plannerFilter)
+		return err
+	})
+	if _, ok := err.(*CfgCASError); ok {
+		return false, fmt.Errorf("planner: could not save new plan,"+
+			" perhaps a concurrent planner won, cas: %d, err: %v",
+			cas, err)
+	}
+
+	return changed, err
+}
+
+// planOnce calculates and saves a new plan, returning the CfgCASError
+// as is when the plan was concurrently changed.
+func planOnce(cfg Cfg, version, uuid, server string,
+	options map[string]string, plannerFilter PlannerFilter) (
+	bool, uint64, error) {
 	indexDefs, nodeDefs, planPIndexesPrev, cas, err :=
 		PlannerGetPlan(cfg, version, uuid)
 	if err != nil {
-		return false, err
+		return false, 0, err
 	}
 
 	// Not setting the last computed plan time here since that leaves a window,
@@ -243,21 +345,24 @@ func Plan(cfg Cfg, version, uuid, server string, options map[string]string,
 	planPIndexes, err := CalcPlan("", indexDefs, nodeDefs,
 		planPIndexesPrev, version, server, options, plannerFilter)
 	if err != nil {
-		return false, fmt.Errorf("planner: CalcPlan, err: %v", err)
+		return false, cas, fmt.Errorf("planner: CalcPlan, err: %v", err)
 	}
 
 	if SamePlanPIndexes(planPIndexes, planPIndexesPrev) {
-		return false, nil
+		return false, cas, nil
 	}
 
 	_, err = CfgSetPlanPIndexes(cfg, planPIndexes, cas)
 	if err != nil {
-		return false, fmt.Errorf("planner: could not save new plan,"+
+		if _, ok := err.(*CfgCASError); ok {
+			return false, cas, err
+		}
+		return false, cas, fmt.Errorf("planner: could not save new plan,"+
 			" perhaps a concurrent planner won, cas: %d, err: %v",
 			cas, err)
 	}
 
-	return true, nil
+	return true, cas, nil
 }
 
 // PlannerGetPlan retrieves plan related info from the Cfg.
@@ -395,6 +500,10 @@ func CalcPlan(mode string, indexDefs *IndexDefs, nodeDefs *NodeDefs,
 		plannerHook = NoopPlannerHook
 	}
 
+	// Plans that are only compared against, and not stored, can skip
+	// the recording of their placement traces.
+	tracing := options["disablePlacementTraces"] != "true"
+
 	var nodeUUIDsAll []string
 	var nodeUUIDsToAdd []string
 	var nodeUUIDsToRemove []string
@@ -500,6 +609,12 @@ func CalcPlan(mode string, indexDefs *IndexDefs, nodeDefs *NodeDefs,
 		return planPIndexes, err
 	}
 
+	// Treat the nodes' reserved resource headroom as unavailable.
+	headroomNodeWeights := ApplyResourceHeadroom(nodeDefs, nodeUUIDsAll,
+		nodeWeights, options)
+
+	nodesInMaintenance := NodesInMaintenance(nodeDefs)
+
 	// Examine every indexDef, ordered by name for stability...
 	var indexDefNames []string
 	for indexDefName := range indexDefs.IndexDefs {
@@ -522,6 +637,9 @@ func CalcPlan(mode string, indexDefs *IndexDefs, nodeDefs *NodeDefs,
 		// If the plan is frozen, CasePlanFrozen clones the previous
 		// plan for this index.
 		if CasePlanFrozen(indexDef, planPIndexesPrev, planPIndexes) {
+			if tracing {
+				traceFrozenPlacements(indexDef, planPIndexes)
+			}
 			continue
 		}
 
@@ -541,6 +659,11 @@ func CalcPlan(mode string, indexDefs *IndexDefs, nodeDefs *NodeDefs,
 			continue
 		}
 
+		// Size the index partitions from the data source, if the
+		// indexDef asks for it via its Min/MaxIndexPartitions bounds.
+		indexDef = sizeIndexDefPartitions(indexDef, server, options,
+			planPIndexesPrev)
+
 		// Split each indexDef into 1 or more PlanPIndexes.
 		planPIndexesForIndex, err2 := SplitIndexDefIntoPlanPIndexes(
 			indexDef, server, options, planPIndexes)
@@ -562,7 +685,33 @@ func CalcPlan(mode string, indexDefs *IndexDefs, nodeDefs *NodeDefs,
 		indexDef = pho.IndexDef
 		planPIndexesForIndex = pho.PlanPIndexesForIndex
 
-		adjustedWeights := nodeWeights
+		// An update of the index that didn't change its partitioning
+		// keeps the pindexes on their previous nodes.
+		if CaseIndexUpdateSticky(indexDef, planPIndexesForIndex,
+			planPIndexesPrev, nodeUUIDsAll, nodeUUIDsToRemove, options) {
+			if tracing {
+				traceStickyPlacements(indexDef, planPIndexesForIndex)
+			}
+
+			planPIndexes.Warnings[indexDef.Name] = []string{}
+
+			_, _, err = plannerHookCall("indexDef.balanced",
+				indexDef, planPIndexesForIndex)
+			if err != nil {
+				return planPIndexes, err
+			}
+
+			continue
+		}
+
+		var adjustments []string
+
+		adjustedWeights := headroomNodeWeights
+		if !reflect.DeepEqual(headroomNodeWeights, nodeWeights) {
+			adjustments = append(adjustments, fmt.Sprintf("the node"+
+				" weights were reduced by the nodes' resource headroom,"+
+				" from: %v, to: %v", nodeWeights, headroomNodeWeights))
+		}
 		// override the node weights for single partitioned index to
 		// favour balanced partition assignments.
 		if len(planPIndexesForIndex) == 1 {
@@ -571,15 +720,40 @@ func CalcPlan(mode string, indexDefs *IndexDefs, nodeDefs *NodeDefs,
 				enabled == "false" {
 				adjustedWeights = NormaliseNodeWeights(nodeWeights,
 					planPIndexesPrev, len(planPIndexesPrev.PlanPIndexes))
+				adjustments = append(adjustments, fmt.Sprintf("the node"+
+					" weights were normalised by the nodes' existing"+
+					" partitions for a single partition index, to: %v",
+					adjustedWeights))
+			}
+		}
+
+		// The pindexes of a new index aren't assigned to the nodes
+		// in maintenance mode.
+		nodeUUIDsForIndex := maintenanceNodesForNewIndex(indexDef,
+			planPIndexesPrev, nodeUUIDsAll, nodesInMaintenance)
+		nodeUUIDsToAddForIndex := nodeUUIDsToAdd
+		excluded := map[string]string{}
+		if len(nodeUUIDsForIndex) < len(nodeUUIDsAll) {
+			nodeUUIDsToAddForIndex = StringsRemoveStrings(nodeUUIDsToAdd,
+				nodesInMaintenance)
+			for _, nodeUUID := range StringsRemoveStrings(nodeUUIDsAll,
+				nodeUUIDsForIndex) {
+				excluded[nodeUUID] = "in maintenance mode, which doesn't" +
+					" take the pindexes of new indexes"
 			}
 		}
 
 		// Once we have a 1 or more PlanPIndexes for an IndexDef, use
 		// blance to assign the PlanPIndexes to nodes.
-		warnings := BlancePlanPIndexes(mode, indexDef,
+		warnings := blancePlanPIndexes(mode, indexDef,
 			planPIndexesForIndex, existingPlans,
-			nodeUUIDsAll, nodeUUIDsToAdd, nodeUUIDsToRemove,
-			adjustedWeights, nodeHierarchy, false)
+			nodeUUIDsForIndex, nodeUUIDsToAddForIndex, nodeUUIDsToRemove,
+			adjustedWeights, nodeHierarchy, false, tracing)
+
+		if tracing {
+			annotatePlacementTraces(planPIndexesForIndex, excluded,
+				adjustments)
+		}
 
 		planPIndexes.Warnings[indexDef.Name] = []string{}
 
@@ -818,6 +992,152 @@ func groupSourcePartitionsIntoPlanPIndexes(indexPartitions, maxPartitionsPerPInd
 
 // --------------------------------------------------------
 
+// A SourceSizeEstimate is the approximate size of an index's data
+// source, as reported by the SourceSizeEstimatorHook.
+type SourceSizeEstimate struct {
+	ItemCount uint64 // Number of items/documents in the source.
+	DiskSize  uint64 // Bytes used by the source.
+}
+
+// SourceSizeEstimatorHook is an optional, pluggable callback that
+// allows applications to estimate the size of an index's data source
+// (bucket, scope or collection), so that the planner can choose the
+// number of index partitions for indexDefs that have
+// PlanParams.MinIndexPartitions/MaxIndexPartitions bounds.  When nil,
+// the planner does not size index partitions automatically.
+var SourceSizeEstimatorHook func(indexDef *IndexDef, server string,
+	options map[string]string) (*SourceSizeEstimate, error)
+
+// DefaultTargetItemsPerIndexPartition is the default number of source
+// items the planner aims for per automatically sized index partition,
+// which can be overridden by the "targetItemsPerIndexPartition"
+// manager option.
+var DefaultTargetItemsPerIndexPartition = 10000000
+
+// DefaultTargetBytesPerIndexPartition is the default number of source
+// bytes the planner aims for per automatically sized index partition,
+// which can be overridden by the "targetBytesPerIndexPartition"
+// manager option.
+var DefaultTargetBytesPerIndexPartition = int64(10 * 1024 * 1024 * 1024)
+
+// CalcIndexPartitions returns the number of index partitions to use
+// for an indexDef, based on the estimated size of its data source and
+// clamped to the indexDef's Min/MaxIndexPartitions bounds.  A return
+// value of 0 means the planner should fall back to the indexDef's
+// static IndexPartitions / MaxPartitionsPerPIndex settings.
+//
+// To avoid needlessly reshuffling partitions as the source grows, the
+// partition count of an existing plan for the very same indexDef
+// (same UUID) is kept, so a new count only takes effect whenever the
+// index definition is updated.
+func CalcIndexPartitions(indexDef *IndexDef, server string,
+	options map[string]string, planPIndexesPrev *PlanPIndexes) (int, error) {
+	minParts := indexDef.PlanParams.MinIndexPartitions
+	maxParts := indexDef.PlanParams.MaxIndexPartitions
+	if minParts <= 0 && maxParts <= 0 {
+		return 0, nil
+	}
+
+	clamp := func(n int) int {
+		if n < minParts {
+			n = minParts
+		}
+		if maxParts > 0 && n > maxParts {
+			n = maxParts
+		}
+		if n < 1 {
+			n = 1
+		}
+		return n
+	}
+
+	if planPIndexesPrev != nil {
+		var prevParts int
+		for _, planPIndex := range planPIndexesPrev.PlanPIndexes {
+			if planPIndex.IndexName == indexDef.Name &&
+				planPIndex.IndexUUID == indexDef.UUID {
+				prevParts++
+			}
+		}
+		if prevParts > 0 {
+			return clamp(prevParts), nil
+		}
+	}
+
+	if SourceSizeEstimatorHook == nil {
+		return 0, nil
+	}
+
+	estimate, err := SourceSizeEstimatorHook(indexDef, server, options)
+	if err != nil {
+		return 0, fmt.Errorf("planner: could not estimate source size,"+
+			" indexDef.Name: %s, err: %v", indexDef.Name, err)
+	}
+	if estimate == nil {
+		return 0, nil
+	}
+
+	targetItems := DefaultTargetItemsPerIndexPartition
+	if v, found := ParseOptionsInt(options, "targetItemsPerIndexPartition"); found {
+		targetItems = v
+	}
+
+	// The target bytes can exceed the int range of 32-bit builds.
+	targetBytes := DefaultTargetBytesPerIndexPartition
+	if v := options["targetBytesPerIndexPartition"]; v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			targetBytes = n
+		} else {
+			log.Warnf("planner: targetBytesPerIndexPartition parse, err: %v",
+				err)
+		}
+	}
+
+	n := 1
+	if targetItems > 0 {
+		byItems := int(math.Ceil(float64(estimate.ItemCount) / float64(targetItems)))
+		if byItems > n {
+			n = byItems
+		}
+	}
+	if targetBytes > 0 {
+		byBytes := int(math.Ceil(float64(estimate.DiskSize) / float64(targetBytes)))
+		if byBytes > n {
+			n = byBytes
+		}
+	}
+
+	return clamp(n), nil
+}
+
+// sizeIndexDefPartitions returns the indexDef to be split by the
+// planner, which is a copy of the given indexDef with an automatically
+// computed IndexPartitions, if applicable, or else the indexDef as-is.
+func sizeIndexDefPartitions(indexDef *IndexDef, server string,
+	options map[string]string, planPIndexesPrev *PlanPIndexes) *IndexDef {
+	indexPartitions, err := CalcIndexPartitions(indexDef, server,
+		options, planPIndexesPrev)
+	if err != nil {
+		log.Warnf("%v", err)
+		return indexDef
+	}
+	if indexPartitions <= 0 ||
+		indexPartitions == indexDef.PlanParams.IndexPartitions {
+		return indexDef
+	}
+
+	log.Printf("planner: indexDef.Name: %s, sized to indexPartitions: %d",
+		indexDef.Name, indexPartitions)
+
+	indexDefCopy := *indexDef
+	indexDefCopy.PlanParams.IndexPartitions = indexPartitions
+
+	return &indexDefCopy
+}
+
+// --------------------------------------------------------
+
 // BlancePlanPIndexes invokes the blance library's generic
 // PlanNextMap() algorithm to create a new pindex layout plan.
 func BlancePlanPIndexes(mode string,
@@ -830,6 +1150,23 @@ func BlancePlanPIndexes(mode string,
 	nodeWeights map[string]int,
 	nodeHierarchy map[string]string,
 	skipExistingPartitions bool) map[string][]string {
+	return blancePlanPIndexes(mode, indexDef, planPIndexesForIndex,
+		planPIndexesPrev, nodeUUIDsAll, nodeUUIDsToAdd, nodeUUIDsToRemove,
+		nodeWeights, nodeHierarchy, skipExistingPartitions, true)
+}
+
+// blancePlanPIndexes is BlancePlanPIndexes, where tracing is false to
+// skip the recording of the placement traces.
+func blancePlanPIndexes(mode string,
+	indexDef *IndexDef,
+	planPIndexesForIndex map[string]*PlanPIndex,
+	planPIndexesPrev *PlanPIndexes,
+	nodeUUIDsAll []string,
+	nodeUUIDsToAdd []string,
+	nodeUUIDsToRemove []string,
+	nodeWeights map[string]int,
+	nodeHierarchy map[string]string,
+	skipExistingPartitions bool, tracing bool) map[string][]string {
 	model, modelConstraints := BlancePartitionModel(indexDef)
 
 	// First, reconstruct previous blance map from planPIndexesPrev.
@@ -943,6 +1280,14 @@ func BlancePlanPIndexes(mode string,
 		}
 	}
 
+	if tracing {
+		tracePlacements(mode, indexDef, planPIndexesForIndex, model,
+			blancePrevMap, blanceNextMap,
+			nodeUUIDsAllForIndex, nodeUUIDsToAdd, nodeUUIDsToRemove,
+			nodeWeights, nodeHierarchy, stateStickiness,
+			skipExistingPartitions, warnings)
+	}
+
 	return warnings
 }
 
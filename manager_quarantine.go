@@ -0,0 +1,135 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// PINDEX_QUARANTINE_SUFFIX is the suffix of a pindex directory that
+// was moved aside because it could not be opened during startup.
+// Quarantined directories are not loaded, so the janitor rebuilds a
+// fresh pindex in their place, while the files remain available for
+// diagnosis.
+const PINDEX_QUARANTINE_SUFFIX = ".quarantined"
+
+// MaxQuarantinedPIndexDirs bounds the number of quarantined pindex
+// directories that are kept in the dataDir, where the oldest ones are
+// removed first.
+var MaxQuarantinedPIndexDirs = 10
+
+// A QuarantinedPIndex describes a pindex that was quarantined.
+type QuarantinedPIndex struct {
+	Name string    `json:"name"`
+	Path string    `json:"path"` // The path of the quarantined files.
+	Err  string    `json:"err"`
+	Time time.Time `json:"time"`
+}
+
+// quarantinePIndex moves aside the files of a pindex that could not be
+// opened, so that the janitor can rebuild the pindex from scratch
+// instead of failing on it again.
+func (mgr *Manager) quarantinePIndex(pindexName, path string,
+	openErr error) error {
+	quarantinePath := fmt.Sprintf("%s.%d%s",
+		path, time.Now().UnixNano(), PINDEX_QUARANTINE_SUFFIX)
+
+	err := os.Rename(path, quarantinePath)
+	if err != nil {
+		return fmt.Errorf("manager: could not quarantine pindex: %s,"+
+			" path: %s, err: %v", pindexName, path, err)
+	}
+
+	atomic.AddUint64(&mgr.stats.TotPIndexQuarantined, 1)
+
+	log.Warnf("manager: quarantined pindex: %s, path: %s, openErr: %v",
+		pindexName, quarantinePath, openErr)
+
+	errMsg := ""
+	if openErr != nil {
+		errMsg = openErr.Error()
+	}
+
+	mgr.quarantinedPIndexesM.Lock()
+	if mgr.quarantinedPIndexes == nil {
+		mgr.quarantinedPIndexes = map[string]*QuarantinedPIndex{}
+	}
+	mgr.quarantinedPIndexes[pindexName] = &QuarantinedPIndex{
+		Name: pindexName,
+		Path: quarantinePath,
+		Err:  errMsg,
+		Time: time.Now(),
+	}
+	mgr.quarantinedPIndexesM.Unlock()
+
+	mgr.pruneQuarantinedPIndexDirs(MaxQuarantinedPIndexDirs)
+
+	return nil
+}
+
+// QuarantinedPIndexes returns the pindexes that were quarantined
+// since the manager started, sorted by name.
+func (mgr *Manager) QuarantinedPIndexes() []*QuarantinedPIndex {
+	mgr.quarantinedPIndexesM.Lock()
+	rv := make([]*QuarantinedPIndex, 0, len(mgr.quarantinedPIndexes))
+	for _, qp := range mgr.quarantinedPIndexes {
+		qpCopy := *qp
+		rv = append(rv, &qpCopy)
+	}
+	mgr.quarantinedPIndexesM.Unlock()
+
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Name < rv[j].Name })
+
+	return rv
+}
+
+// pruneQuarantinedPIndexDirs removes the oldest quarantined pindex
+// directories beyond maxDirs.
+func (mgr *Manager) pruneQuarantinedPIndexDirs(maxDirs int) {
+	dirEntries, err := os.ReadDir(mgr.dataDir)
+	if err != nil {
+		return
+	}
+
+	var paths []string
+	modTimes := map[string]time.Time{}
+	for _, dirEntry := range dirEntries {
+		if !strings.HasSuffix(dirEntry.Name(), PINDEX_QUARANTINE_SUFFIX) {
+			continue
+		}
+		fi, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(mgr.dataDir, dirEntry.Name())
+		paths = append(paths, path)
+		modTimes[path] = fi.ModTime()
+	}
+
+	if len(paths) <= maxDirs {
+		return
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return modTimes[paths[i]].Before(modTimes[paths[j]])
+	})
+
+	for _, path := range paths[:len(paths)-maxDirs] {
+		log.Printf("manager: removing old quarantined pindex path: %s", path)
+		os.RemoveAll(path)
+	}
+}
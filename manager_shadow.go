@@ -0,0 +1,700 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+)
+
+// A shadow copy keeps a read-only copy of an index on a second cluster
+// for disaster recovery.  The nodes of the source cluster periodically
+// ship snapshots of their primary pindexes (a full snapshot, followed
+// by incremental changes) into a remote object store, using the same
+// object formats as hibernation (i.e., the same blob stores,
+// compression and encryption).  The nodes of the target cluster apply
+// the shipped snapshots to their pindexes of the shadow index, which
+// are matched to the source pindexes by their source partitions.  The
+// shadow index is read-only on the target cluster, where the janitor
+// doesn't feed its pindexes.
+
+// DestShadowSource is an optional interface that a Dest may implement
+// to allow its pindex to be the source of a shadow copy.
+type DestShadowSource interface {
+	// ShadowSnapshot writes a snapshot of the pindex into the w, which
+	// is a full snapshot when the sinceSeq is 0, or else only the
+	// changes since the sinceSeq.  It returns the seq that the snapshot
+	// is consistent with, which is the sinceSeq when there are no
+	// changes.
+	ShadowSnapshot(w io.Writer, sinceSeq uint64) (seq uint64, err error)
+}
+
+// DestShadowTarget is an optional interface that a Dest may implement
+// to allow its pindex to be the target of a shadow copy.
+type DestShadowTarget interface {
+	// ApplyShadowSnapshot applies a snapshot written by a
+	// DestShadowSource, where a full snapshot replaces all the data of
+	// the pindex.
+	ApplyShadowSnapshot(r io.Reader, full bool, seq uint64) error
+}
+
+// SHADOW_COPY_DEFS_KEY is the key used for Cfg access.
+const SHADOW_COPY_DEFS_KEY = "shadowCopyDefs"
+
+// The roles of a cluster in a shadow copy.
+const (
+	ShadowCopyRoleSource = "source"
+	ShadowCopyRoleTarget = "target"
+)
+
+// ShadowCopyTickInterval is how often the shadow copy loop checks
+// whether any shadow copy is due to be synced.
+var ShadowCopyTickInterval = 5 * time.Second
+
+// DefaultShadowCopyIntervalSecs is the default sync interval of a
+// shadow copy.
+var DefaultShadowCopyIntervalSecs = 60
+
+// DefaultShadowCopyFullEvery is the default number of incremental
+// snapshots after which the source ships a full snapshot, which bounds
+// the number of snapshots that a target needs to catch up.
+var DefaultShadowCopyFullEvery = 100
+
+// A ShadowCopyDef defines a shadow copy of an index.  Both clusters
+// have a ShadowCopyDef of the same RemotePath, where the source
+// cluster's Role is "source" and the target cluster's Role is "target".
+type ShadowCopyDef struct {
+	Name         string `json:"name"`
+	IndexName    string `json:"indexName"`
+	Role         string `json:"role"`
+	RemotePath   string `json:"remotePath"` // Ex: "s3://bucket/dr".
+	Region       string `json:"region,omitempty"`
+	IntervalSecs int    `json:"intervalSecs,omitempty"`
+	FullEvery    int    `json:"fullEvery,omitempty"`
+}
+
+// ShadowCopyDefs are the shadow copies of a cluster.
+type ShadowCopyDefs struct {
+	UUID         string                    `json:"uuid"`
+	ShadowCopies map[string]*ShadowCopyDef `json:"shadowCopies"`
+}
+
+// A ShadowManifest lists the snapshots of a source pindex that are
+// shipped into the remote object store, starting with a full snapshot.
+type ShadowManifest struct {
+	IndexName        string           `json:"indexName"`
+	IndexUUID        string           `json:"indexUUID"`
+	SourcePartitions string           `json:"sourcePartitions"`
+	Segments         []*ShadowSegment `json:"segments"`
+	UpdatedAt        time.Time        `json:"updatedAt"` // Of the last sync.
+}
+
+// A ShadowSegment is a snapshot in a ShadowManifest.
+type ShadowSegment struct {
+	Key  string    `json:"key"`
+	Seq  uint64    `json:"seq"`
+	Full bool      `json:"full"`
+	Time time.Time `json:"time"`
+}
+
+// ShadowCopyPIndexStatus is the sync status of a pindex of a shadow
+// copy on this node.
+type ShadowCopyPIndexStatus struct {
+	PIndex   string    `json:"pindex"`
+	Seq      uint64    `json:"seq"`
+	LastSync time.Time `json:"lastSync"`
+	LagSecs  float64   `json:"lagSecs"`
+	Err      string    `json:"err,omitempty"`
+
+	// The source time as of which the pindex is current.
+	currentAt time.Time
+}
+
+// ShadowCopyStatus is the status of a shadow copy on this node, which
+// is managed as a task with lag reporting.
+type ShadowCopyStatus struct {
+	Def      *ShadowCopyDef                     `json:"def"`
+	Status   string                             `json:"status"`  // "running", "waiting" or "failed".
+	LagSecs  float64                            `json:"lagSecs"` // Max of the pindexes.
+	PIndexes map[string]*ShadowCopyPIndexStatus `json:"pindexes"`
+	LastErr  string                             `json:"lastErr,omitempty"`
+
+	lastRun time.Time
+}
+
+// ------------------------------------------------------------------------
+
+// CfgGetShadowCopyDefs returns the shadow copy definitions from a Cfg
+// provider.
+func CfgGetShadowCopyDefs(cfg Cfg) (*ShadowCopyDefs, uint64, error) {
+	v, cas, err := cfg.Get(SHADOW_COPY_DEFS_KEY, 0)
+	if err != nil {
+		return nil, cas, err
+	}
+	if v == nil {
+		return nil, cas, nil
+	}
+	rv := &ShadowCopyDefs{}
+	err = UnmarshalJSON(v, rv)
+	if err != nil {
+		return nil, cas, err
+	}
+	return rv, cas, nil
+}
+
+// CfgSetShadowCopyDefs updates the shadow copy definitions on a Cfg
+// provider.
+func CfgSetShadowCopyDefs(cfg Cfg, defs *ShadowCopyDefs,
+	cas uint64) (uint64, error) {
+	buf, err := MarshalJSON(defs)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Set(SHADOW_COPY_DEFS_KEY, buf, cas)
+}
+
+// SetShadowCopy creates or replaces a shadow copy definition.
+func (mgr *Manager) SetShadowCopy(def *ShadowCopyDef) error {
+	if def == nil || def.Name == "" || def.IndexName == "" {
+		return NewBadRequestError("manager_shadow: name and indexName" +
+			" are required")
+	}
+	if def.Role != ShadowCopyRoleSource && def.Role != ShadowCopyRoleTarget {
+		return NewBadRequestError("manager_shadow: invalid role: %q", def.Role)
+	}
+	if _, _, _, err := ParseBlobStoreRemotePath(def.RemotePath); err != nil {
+		return NewBadRequestError("manager_shadow: %v", err)
+	}
+
+	err := RetryOnCASMismatch(func() error {
+		defs, cas, err := CfgGetShadowCopyDefs(mgr.cfg)
+		if err != nil {
+			return err
+		}
+		if defs == nil {
+			defs = &ShadowCopyDefs{ShadowCopies: map[string]*ShadowCopyDef{}}
+		}
+		defs.UUID = NewUUID()
+		defs.ShadowCopies[def.Name] = def
+
+		_, err = CfgSetShadowCopyDefs(mgr.cfg, defs, cas)
+		return err
+	}, 100)
+	if err != nil {
+		return fmt.Errorf("manager_shadow: could not save shadow copy: %s,"+
+			" err: %v", def.Name, err)
+	}
+
+	return nil
+}
+
+// DeleteShadowCopy deletes a shadow copy definition, which stops its
+// syncing, but leaves the shipped snapshots in the remote object store.
+func (mgr *Manager) DeleteShadowCopy(name string) error {
+	err := RetryOnCASMismatch(func() error {
+		defs, cas, err := CfgGetShadowCopyDefs(mgr.cfg)
+		if err != nil {
+			return err
+		}
+		if defs == nil || defs.ShadowCopies[name] == nil {
+			return NewBadRequestError("manager_shadow: no shadow copy: %s",
+				name)
+		}
+		defs.UUID = NewUUID()
+		delete(defs.ShadowCopies, name)
+
+		_, err = CfgSetShadowCopyDefs(mgr.cfg, defs, cas)
+		return err
+	}, 100)
+	if err != nil {
+		return err
+	}
+
+	mgr.shadowCopiesM.Lock()
+	delete(mgr.shadowCopies, name)
+	mgr.shadowCopiesM.Unlock()
+
+	return nil
+}
+
+// ErrShadowCopyReadOnly is returned for a write to an index that's the
+// target of a shadow copy, which only changes by its applied snapshots.
+var ErrShadowCopyReadOnly = errors.New("manager_shadow: the index is" +
+	" the read-only target of a shadow copy")
+
+// IsShadowCopyTarget returns true if the index is the target of a
+// shadow copy on this cluster.
+func (mgr *Manager) IsShadowCopyTarget(indexName string) bool {
+	return mgr.shadowCopyTargets()[indexName]
+}
+
+// shadowCopyTargets returns the names of the indexes that are the
+// targets of the shadow copies on this cluster.
+func (mgr *Manager) shadowCopyTargets() map[string]bool {
+	if mgr.cfg == nil {
+		return nil
+	}
+	defs, _, err := CfgGetShadowCopyDefs(mgr.cfg)
+	if err != nil || defs == nil {
+		return nil
+	}
+
+	var rv map[string]bool
+	for _, def := range defs.ShadowCopies {
+		if def.Role == ShadowCopyRoleTarget {
+			if rv == nil {
+				rv = map[string]bool{}
+			}
+			rv[def.IndexName] = true
+		}
+	}
+	return rv
+}
+
+// withoutShadowCopyTargets returns the pindexes that aren't of the
+// shadow copy targets, as the pindexes of the targets aren't fed.
+func (mgr *Manager) withoutShadowCopyTargets(
+	pindexes map[string]*PIndex) map[string]*PIndex {
+	targets := mgr.shadowCopyTargets()
+	if len(targets) == 0 {
+		return pindexes
+	}
+
+	rv := make(map[string]*PIndex, len(pindexes))
+	for name, pindex := range pindexes {
+		if !targets[pindex.IndexName] {
+			rv[name] = pindex
+		}
+	}
+	return rv
+}
+
+// ShadowCopies returns the statuses of the shadow copies on this node,
+// sorted by name.
+func (mgr *Manager) ShadowCopies() []*ShadowCopyStatus {
+	mgr.shadowCopiesM.Lock()
+	defer mgr.shadowCopiesM.Unlock()
+
+	rv := make([]*ShadowCopyStatus, 0, len(mgr.shadowCopies))
+	for _, s := range mgr.shadowCopies {
+		c := *s // Copy.
+		c.PIndexes = make(map[string]*ShadowCopyPIndexStatus, len(s.PIndexes))
+		for k, ps := range s.PIndexes {
+			psCopy := *ps
+			c.PIndexes[k] = &psCopy
+		}
+		rv = append(rv, &c)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].Def.Name < rv[j].Def.Name
+	})
+	return rv
+}
+
+// ------------------------------------------------------------------------
+
+// ShadowCopyLoop syncs the shadow copies of this node on their
+// intervals, until the manager is stopped.
+func (mgr *Manager) ShadowCopyLoop() {
+	ticker := time.NewTicker(ShadowCopyTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mgr.stopCh:
+			return
+		case now := <-ticker.C:
+			mgr.shadowCopyOnce(now)
+		}
+	}
+}
+
+// shadowCopyOnce syncs the shadow copies that are due.
+func (mgr *Manager) shadowCopyOnce(now time.Time) {
+	defs, _, err := CfgGetShadowCopyDefs(mgr.cfg)
+	if err != nil || defs == nil {
+		return
+	}
+
+	for _, def := range defs.ShadowCopies {
+		intervalSecs := def.IntervalSecs
+		if intervalSecs <= 0 {
+			intervalSecs = DefaultShadowCopyIntervalSecs
+		}
+
+		mgr.shadowCopiesM.Lock()
+		if mgr.shadowCopies == nil {
+			mgr.shadowCopies = map[string]*ShadowCopyStatus{}
+		}
+		s := mgr.shadowCopies[def.Name]
+		if s == nil || *s.Def != *def {
+			s = &ShadowCopyStatus{
+				Def:      def,
+				Status:   "waiting",
+				PIndexes: map[string]*ShadowCopyPIndexStatus{},
+			}
+			mgr.shadowCopies[def.Name] = s
+		}
+		due := now.Sub(s.lastRun) >= time.Duration(intervalSecs)*time.Second
+		if due {
+			s.lastRun = now
+		}
+		mgr.shadowCopiesM.Unlock()
+
+		if due {
+			mgr.syncShadowCopy(def)
+		}
+	}
+}
+
+// syncShadowCopy ships or applies the snapshots of the local pindexes
+// of a shadow copy, and updates its status.
+func (mgr *Manager) syncShadowCopy(def *ShadowCopyDef) {
+	_, bucket, prefix, err := ParseBlobStoreRemotePath(def.RemotePath)
+
+	var client objcli.Client
+	if err == nil {
+		client, err = mgr.newShadowCopyClient(def)
+	}
+
+	results := map[string]*ShadowCopyPIndexStatus{}
+
+	if err == nil {
+		_, pindexes := mgr.CurrentMaps()
+		for name, pindex := range pindexes {
+			if pindex.IndexName != def.IndexName {
+				continue
+			}
+
+			keyPrefix := prefix + "/" + def.IndexName + "/" +
+				crc32Hex(pindex.SourcePartitions)
+
+			var ps *ShadowCopyPIndexStatus
+			if def.Role == ShadowCopyRoleSource {
+				ps = mgr.shipShadowPIndex(def, client, bucket, keyPrefix, pindex)
+			} else {
+				ps = mgr.applyShadowPIndex(client, bucket, keyPrefix, pindex)
+			}
+			if ps != nil {
+				results[name] = ps
+			}
+		}
+	}
+
+	now := time.Now()
+
+	mgr.shadowCopiesM.Lock()
+	defer mgr.shadowCopiesM.Unlock()
+
+	s := mgr.shadowCopies[def.Name]
+	if s == nil {
+		return // Deleted meanwhile.
+	}
+
+	s.LastErr = ""
+	if err != nil {
+		s.LastErr = err.Error()
+	}
+
+	pindexesNext := map[string]*ShadowCopyPIndexStatus{}
+	for name, ps := range results {
+		prev := s.PIndexes[name]
+		if ps.Err != "" && prev != nil {
+			// Keep the progress of the last successful sync.
+			ps.Seq, ps.LastSync, ps.currentAt =
+				prev.Seq, prev.LastSync, prev.currentAt
+		}
+		pindexesNext[name] = ps
+	}
+	s.PIndexes = pindexesNext
+
+	s.LagSecs = 0
+	s.Status = "running"
+	for _, ps := range s.PIndexes {
+		if !ps.currentAt.IsZero() {
+			ps.LagSecs = now.Sub(ps.currentAt).Seconds()
+		}
+		if ps.LagSecs > s.LagSecs {
+			s.LagSecs = ps.LagSecs
+		}
+		if ps.Err != "" {
+			s.Status = "failed"
+			s.LastErr = ps.Err
+		}
+	}
+	if s.LastErr != "" {
+		s.Status = "failed"
+		atomic.AddUint64(&mgr.stats.TotShadowCopySyncErr, 1)
+		log.Warnf("manager_shadow: shadow copy: %s, err: %s",
+			def.Name, s.LastErr)
+	} else if len(s.PIndexes) == 0 {
+		s.Status = "waiting"
+	}
+}
+
+// newShadowCopyClient returns an object store client of the shadow
+// copy's remote path, which compresses and encrypts like hibernation.
+func (mgr *Manager) newShadowCopyClient(
+	def *ShadowCopyDef) (objcli.Client, error) {
+	bs, err := BlobStoreForRemotePath(def.RemotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := bs.NewClient(def.Region)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, fmt.Errorf("manager_shadow: no object store client")
+	}
+
+	client, err = mgr.encryptHibernationClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return mgr.compressHibernationClient(client)
+}
+
+// crc32Hex returns the hex crc32 of the source partitions of a pindex,
+// which names the pindex's snapshots independent of the cluster.
+func crc32Hex(sourcePartitions string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(sourcePartitions)))
+}
+
+func getShadowManifest(ctx context.Context, client objcli.Client,
+	bucket, keyPrefix string) (*ShadowManifest, error) {
+	data, err := BlobStoreGet(ctx, client, bucket, keyPrefix+"/manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	rv := &ShadowManifest{}
+	err = UnmarshalJSON(data, rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// shipShadowPIndex ships the changes of a source pindex since the last
+// shipped snapshot, where only the node with the primary of the pindex
+// ships it.  It returns nil when the pindex isn't shipped by this node.
+func (mgr *Manager) shipShadowPIndex(def *ShadowCopyDef, client objcli.Client,
+	bucket, keyPrefix string, pindex *PIndex) *ShadowCopyPIndexStatus {
+	source, ok := pindex.Dest.(DestShadowSource)
+	if !ok || !mgr.isPrimaryPIndex(pindex.Name) {
+		return nil
+	}
+
+	ps := &ShadowCopyPIndexStatus{PIndex: pindex.Name}
+
+	err := func() error {
+		ctx := context.Background()
+
+		manifest, err := getShadowManifest(ctx, client, bucket, keyPrefix)
+		if err != nil || manifest.IndexUUID != pindex.IndexUUID {
+			// A missing manifest, or one of a previous incarnation of the
+			// index, means a full snapshot.
+			manifest = &ShadowManifest{
+				IndexName:        pindex.IndexName,
+				IndexUUID:        pindex.IndexUUID,
+				SourcePartitions: pindex.SourcePartitions,
+			}
+		}
+
+		fullEvery := def.FullEvery
+		if fullEvery <= 0 {
+			fullEvery = DefaultShadowCopyFullEvery
+		}
+
+		var sinceSeq uint64
+		if n := len(manifest.Segments); n > 0 && n <= fullEvery {
+			sinceSeq = manifest.Segments[n-1].Seq
+		}
+
+		f, err := os.CreateTemp("", "cbgt-shadow-")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+
+		seq, err := source.ShadowSnapshot(f, sinceSeq)
+		if err != nil {
+			return fmt.Errorf("snapshot, err: %v", err)
+		}
+
+		now := time.Now()
+
+		var obsolete []string
+
+		if sinceSeq == 0 || seq != sinceSeq {
+			_, err = f.Seek(0, io.SeekStart)
+			if err != nil {
+				return err
+			}
+
+			full := sinceSeq == 0
+			kind := "delta"
+			if full {
+				kind = "full"
+				for _, seg := range manifest.Segments {
+					obsolete = append(obsolete, seg.Key)
+				}
+				manifest.Segments = nil
+			}
+
+			key := fmt.Sprintf("%s/%020d-%s-%d", keyPrefix, seq, kind,
+				now.UnixNano())
+
+			err = client.PutObject(ctx, bucket, key, f)
+			if err != nil {
+				return fmt.Errorf("upload: %s, err: %v", key, err)
+			}
+
+			manifest.Segments = append(manifest.Segments, &ShadowSegment{
+				Key: key, Seq: seq, Full: full, Time: now,
+			})
+		}
+
+		// The manifest is updated even without changes, as its
+		// UpdatedAt tells the targets how current the source is.
+		manifest.UpdatedAt = now
+
+		data, err := MarshalJSON(manifest)
+		if err != nil {
+			return err
+		}
+		err = BlobStorePut(ctx, client, bucket, keyPrefix+"/manifest.json", data)
+		if err != nil {
+			return err
+		}
+
+		if len(obsolete) > 0 {
+			err = client.DeleteObjects(ctx, bucket, obsolete...)
+			if err != nil {
+				log.Warnf("manager_shadow: pindex: %s, delete obsolete"+
+					" snapshots, err: %v", pindex.Name, err)
+			}
+		}
+
+		ps.Seq = seq
+		ps.LastSync = now
+		ps.currentAt = now
+
+		return nil
+	}()
+	if err != nil {
+		ps.Err = fmt.Sprintf("pindex: %s, ship, err: %v", pindex.Name, err)
+	}
+
+	return ps
+}
+
+// applyShadowPIndex applies the shipped snapshots to a target pindex,
+// starting from the full snapshot when the pindex hasn't applied any
+// of the manifest's snapshots.  It returns nil when the pindex isn't a
+// shadow copy target.
+func (mgr *Manager) applyShadowPIndex(client objcli.Client,
+	bucket, keyPrefix string, pindex *PIndex) *ShadowCopyPIndexStatus {
+	target, ok := pindex.Dest.(DestShadowTarget)
+	if !ok {
+		return nil
+	}
+
+	mgr.shadowCopiesM.Lock()
+	var prev ShadowCopyPIndexStatus
+	for _, s := range mgr.shadowCopies {
+		if ps := s.PIndexes[pindex.Name]; ps != nil {
+			prev = *ps
+		}
+	}
+	mgr.shadowCopiesM.Unlock()
+
+	ps := &ShadowCopyPIndexStatus{
+		PIndex:    pindex.Name,
+		Seq:       prev.Seq,
+		LastSync:  prev.LastSync,
+		currentAt: prev.currentAt,
+	}
+
+	err := func() error {
+		ctx := context.Background()
+
+		manifest, err := getShadowManifest(ctx, client, bucket, keyPrefix)
+		if err != nil {
+			return fmt.Errorf("manifest, err: %v", err)
+		}
+
+		next := 0 // Index of the next segment to apply.
+		for i, seg := range manifest.Segments {
+			if ps.Seq != 0 && seg.Seq == ps.Seq {
+				next = i + 1
+			}
+		}
+
+		for _, seg := range manifest.Segments[next:] {
+			obj, err := client.GetObject(ctx, bucket, seg.Key, nil)
+			if err != nil {
+				return fmt.Errorf("download: %s, err: %v", seg.Key, err)
+			}
+
+			err = target.ApplyShadowSnapshot(obj.Body, seg.Full, seg.Seq)
+			obj.Body.Close()
+			if err != nil {
+				return fmt.Errorf("apply: %s, err: %v", seg.Key, err)
+			}
+
+			ps.Seq = seg.Seq
+			ps.currentAt = seg.Time
+		}
+
+		if n := len(manifest.Segments); n > 0 &&
+			manifest.Segments[n-1].Seq == ps.Seq {
+			ps.currentAt = manifest.UpdatedAt
+		}
+
+		ps.LastSync = time.Now()
+
+		return nil
+	}()
+	if err != nil {
+		ps.Err = fmt.Sprintf("pindex: %s, apply, err: %v", pindex.Name, err)
+	}
+
+	return ps
+}
+
+// isPrimaryPIndex returns true if this node has the primary of the
+// pindex in the current plan.
+func (mgr *Manager) isPrimaryPIndex(pindexName string) bool {
+	planPIndexes, _, err := mgr.GetPlanPIndexes(false)
+	if err != nil || planPIndexes == nil {
+		return false
+	}
+	planPIndex, exists := planPIndexes.PlanPIndexes[pindexName]
+	if !exists || planPIndex == nil {
+		return false
+	}
+	node := planPIndex.Nodes[mgr.uuid]
+	return node != nil && node.Priority <= 0
+}
@@ -0,0 +1,206 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/tools-common/cloud/objstore/objcli"
+	"github.com/couchbase/tools-common/cloud/objstore/objval"
+)
+
+// TestShadowDest is a shadow copy source and target, whose data is a
+// log of entries, and whose seq is the number of entries.
+type TestShadowDest struct {
+	TestDest
+	entries []string
+}
+
+func (t *TestShadowDest) ShadowSnapshot(w io.Writer,
+	sinceSeq uint64) (uint64, error) {
+	for _, e := range t.entries[sinceSeq:] {
+		io.WriteString(w, e+"\n")
+	}
+	return uint64(len(t.entries)), nil
+}
+
+func (t *TestShadowDest) ApplyShadowSnapshot(r io.Reader,
+	full bool, seq uint64) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if full {
+		t.entries = nil
+	}
+	t.entries = append(t.entries, strings.Fields(string(b))...)
+	return nil
+}
+
+func TestShadowCopy(t *testing.T) {
+	client := objcli.NewTestClient(t, objval.ProviderAWS)
+
+	prevHook := S3BlobStore.ClientHook
+	S3BlobStore.ClientHook = func(region string) (objcli.Client, error) {
+		return client, nil
+	}
+	defer func() { S3BlobStore.ClientHook = prevHook }()
+
+	newMgr := func(role string, dest *TestShadowDest) *Manager {
+		cfg := NewCfgMem()
+		mgr := NewManager(VERSION, cfg, NewUUID(), nil,
+			"", 1, "", "", "", "", nil)
+
+		planPIndexes := NewPlanPIndexes(VERSION)
+		planPIndexes.PlanPIndexes["p0"] = &PlanPIndex{
+			Name:      "p0",
+			IndexName: "idx",
+			Nodes: map[string]*PlanPIndexNode{
+				mgr.uuid: {CanRead: true, CanWrite: true, Priority: 0},
+			},
+		}
+		_, err := CfgSetPlanPIndexes(cfg, planPIndexes, 0)
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+
+		mgr.pindexes["p0"] = &PIndex{
+			Name:             "p0",
+			IndexName:        "idx",
+			IndexUUID:        "idx-uuid",
+			SourcePartitions: "0,1",
+			Dest:             dest,
+		}
+
+		err = mgr.SetShadowCopy(&ShadowCopyDef{
+			Name: "dr", IndexName: "idx", Role: role,
+			RemotePath: "s3://bkt/dr", FullEvery: 2,
+		})
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+		return mgr
+	}
+
+	src := &TestShadowDest{entries: []string{"a", "b"}}
+	dst := &TestShadowDest{}
+
+	srcMgr := newMgr(ShadowCopyRoleSource, src)
+	dstMgr := newMgr(ShadowCopyRoleTarget, dst)
+
+	// The target has nothing to apply before the source ships.
+	dstMgr.shadowCopyOnce(dstMgr.startTime)
+	statuses := dstMgr.ShadowCopies()
+	if len(statuses) != 1 || statuses[0].Status != "failed" {
+		t.Fatalf("expected a failed status, got: %+v", statuses)
+	}
+
+	sync := func(exp string) {
+		srcMgr.syncShadowCopy(srcMgr.ShadowCopies()[0].Def)
+		dstMgr.syncShadowCopy(dstMgr.ShadowCopies()[0].Def)
+
+		if got := strings.Join(dst.entries, ""); got != exp {
+			t.Fatalf("expected: %s, got: %s", exp, got)
+		}
+		for _, mgr := range []*Manager{srcMgr, dstMgr} {
+			s := mgr.ShadowCopies()[0]
+			if s.Status != "running" || s.PIndexes["p0"] == nil ||
+				s.PIndexes["p0"].Seq != uint64(len(src.entries)) {
+				t.Fatalf("expected a running status, got: %+v, %+v",
+					s, s.PIndexes["p0"])
+			}
+		}
+	}
+
+	srcMgr.shadowCopyOnce(srcMgr.startTime) // Registers the status.
+
+	sync("ab")
+
+	src.entries = append(src.entries, "c")
+	sync("abc")
+
+	src.entries = append(src.entries, "d")
+	sync("abcd")
+
+	// Beyond the FullEvery deltas, a full snapshot replaces the deltas.
+	src.entries = append(src.entries, "e")
+	dst.entries = []string{"x"}
+	sync("abcde")
+
+	manifest, err := getShadowManifest(context.Background(), client, "bkt",
+		"dr/idx/"+crc32Hex("0,1"))
+	if err != nil || len(manifest.Segments) != 1 ||
+		!manifest.Segments[0].Full {
+		t.Fatalf("expected a single full segment, got: %+v, err: %v",
+			manifest, err)
+	}
+
+	err = dstMgr.DeleteShadowCopy("dr")
+	if err != nil || len(dstMgr.ShadowCopies()) != 0 {
+		t.Fatalf("expected the shadow copy deleted, err: %v", err)
+	}
+}
+
+func TestShadowCopyTargetReadOnly(t *testing.T) {
+	cfg := NewCfgMem()
+	mgr := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	planPIndexes := NewPlanPIndexes(VERSION)
+	for _, indexName := range []string{"idx", "other"} {
+		planPIndexes.PlanPIndexes["p-"+indexName] = &PlanPIndex{
+			Name:      "p-" + indexName,
+			IndexName: indexName,
+			Nodes: map[string]*PlanPIndexNode{
+				mgr.uuid: {CanRead: true, CanWrite: true},
+			},
+		}
+		mgr.pindexes["p-"+indexName] = &PIndex{
+			Name:             "p-" + indexName,
+			IndexName:        indexName,
+			SourcePartitions: "0",
+			Dest:             &TestDest{},
+		}
+	}
+
+	feedName := FeedNameForPIndex(mgr.pindexes["p-idx"], "")
+	currFeeds := map[string]Feed{
+		feedName: NewPrimaryFeed(feedName, "idx", BasicPartitionFunc,
+			map[string]Dest{"0": mgr.pindexes["p-idx"].Dest}),
+	}
+
+	addFeeds, removeFeeds := CalcFeedsDelta(mgr.uuid, planPIndexes,
+		currFeeds, mgr.withoutShadowCopyTargets(mgr.pindexes), "")
+	if len(addFeeds) != 1 || len(removeFeeds) != 0 {
+		t.Fatalf("expected only the other feed added, got: %v, %v",
+			addFeeds, removeFeeds)
+	}
+
+	err := mgr.SetShadowCopy(&ShadowCopyDef{
+		Name: "dr", IndexName: "idx", Role: ShadowCopyRoleTarget,
+		RemotePath: "s3://bkt/dr",
+	})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if !mgr.IsShadowCopyTarget("idx") || mgr.IsShadowCopyTarget("other") {
+		t.Fatalf("expected only idx to be a shadow copy target")
+	}
+
+	addFeeds, removeFeeds = CalcFeedsDelta(mgr.uuid, planPIndexes,
+		currFeeds, mgr.withoutShadowCopyTargets(mgr.pindexes), "")
+	if len(addFeeds) != 1 || addFeeds[0][0].IndexName != "other" ||
+		len(removeFeeds) != 1 || removeFeeds[0].Name() != feedName {
+		t.Fatalf("expected the feed of the target removed, got: %v, %v",
+			addFeeds, removeFeeds)
+	}
+}
@@ -0,0 +1,83 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// DefaultShutdownDeadline is the default time allowed for a graceful
+// shutdown, which can be overridden by the "shutdownDeadlineSecs"
+// manager option.
+var DefaultShutdownDeadline = 30 * time.Second
+
+// ShutdownDeadline returns the time allowed for a graceful shutdown.
+func (mgr *Manager) ShutdownDeadline() time.Duration {
+	if v, found := ParseOptionsInt(mgr.Options(), "shutdownDeadlineSecs"); found && v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return DefaultShutdownDeadline
+}
+
+// Shutdown stops the manager's planner and janitor, then closes every
+// feed and then every pindex (without removing any files), so that a
+// routine process restart doesn't lead to long replays or rollbacks.
+// Any Dest that implements DestPersister is asked to persist before
+// it's closed.  An error is returned if the feeds and pindexes could
+// not all be closed within the deadline.
+func (mgr *Manager) Shutdown(deadline time.Duration) error {
+	mgr.Stop()
+
+	doneCh := make(chan error, 1)
+
+	go func() {
+		var firstErr error
+
+		feeds, pindexes := mgr.CurrentMaps()
+
+		for _, feed := range feeds {
+			err := mgr.stopFeed(feed)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		for _, pindex := range pindexes {
+			if persister, ok := pindex.Dest.(DestPersister); ok {
+				err := persister.PersistNow()
+				if err != nil {
+					log.Warnf("manager: Shutdown, persist pindex: %s, err: %v",
+						pindex.Name, err)
+				}
+			}
+
+			err := mgr.stopPIndex(pindex, false)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		doneCh <- firstErr
+	}()
+
+	select {
+	case err := <-doneCh:
+		if err != nil {
+			return fmt.Errorf("manager: Shutdown, err: %v", err)
+		}
+		log.Printf("manager: Shutdown, closed all feeds and pindexes")
+		return nil
+
+	case <-time.After(deadline):
+		return fmt.Errorf("manager: Shutdown, deadline exceeded: %v", deadline)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"os"
 	"reflect"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -745,6 +747,217 @@ func TestManagerClosePIndex(t *testing.T) {
 	}
 }
 
+func TestManagerQuarantinePIndex(t *testing.T) {
+	emptyDir, _ := os.MkdirTemp("./tmp", "test")
+	defer os.RemoveAll(emptyDir)
+
+	badPath := emptyDir + string(os.PathSeparator) + "bad" + pindexPathSuffix
+	if err := os.MkdirAll(badPath, 0700); err != nil {
+		t.Fatalf("expected mkdir to work, err: %v", err)
+	}
+
+	m := NewManager(VERSION, NewCfgMem(), NewUUID(), nil,
+		"", 1, "", "", emptyDir, "", nil)
+	if err := m.Start("wanted"); err != nil {
+		t.Fatalf("expected Manager.Start() to work, err: %v", err)
+	}
+	defer m.Stop()
+
+	var qps []*QuarantinedPIndex
+	for i := 0; i < 100 && len(qps) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		qps = m.QuarantinedPIndexes()
+	}
+	if len(qps) != 1 || qps[0].Name != "bad" || qps[0].Err == "" {
+		t.Fatalf("expected the bad pindex to be quarantined, got: %+v", qps)
+	}
+	if _, err := os.Stat(badPath); !os.IsNotExist(err) {
+		t.Errorf("expected the bad pindex path to be moved, err: %v", err)
+	}
+	if _, err := os.Stat(qps[0].Path); err != nil {
+		t.Errorf("expected the quarantined path to exist, err: %v", err)
+	}
+	if _, ok := m.ParsePIndexPath(qps[0].Path); ok {
+		t.Errorf("expected the quarantined path to not be a pindex path")
+	}
+	if v := atomic.LoadUint64(&m.stats.TotPIndexQuarantined); v != 1 {
+		t.Errorf("expected TotPIndexQuarantined of 1, got: %d", v)
+	}
+
+	m.pruneQuarantinedPIndexDirs(0)
+	if _, err := os.Stat(qps[0].Path); !os.IsNotExist(err) {
+		t.Errorf("expected the quarantined path to be pruned, err: %v", err)
+	}
+}
+
+func TestManagerMultiBucketHibernationTracking(t *testing.T) {
+	cfg := NewCfgMem()
+	m := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	err := m.MarkBucketsForHibernation([]string{
+		HIBERNATE_TASK + ":b0", HIBERNATE_TASK + ":b1"})
+	if err != nil {
+		t.Fatalf("expected MarkBucketsForHibernation to work, err: %v", err)
+	}
+
+	bucketTasks := m.GetHibernationBucketsAndTasks()
+	if len(bucketTasks) != 2 ||
+		bucketTasks[0] != [2]string{HIBERNATE_TASK, "b0"} ||
+		bucketTasks[1] != [2]string{HIBERNATE_TASK, "b1"} {
+		t.Errorf("wrong bucket tasks: %v", bucketTasks)
+	}
+
+	bucket, task := m.GetHibernationBucketAndTask()
+	if bucket != "b0" || task != HIBERNATE_TASK {
+		t.Errorf("wrong first bucket: %s, task: %s", bucket, task)
+	}
+
+	m.RegisterHibernationBucketTracker("b0")
+	m.RegisterHibernationBucketTracker("b1")
+	if !m.IsBucketBeingHibernated("b0") || !m.IsBucketBeingHibernated("b1") {
+		t.Errorf("expected both buckets to be tracked")
+	}
+
+	// The CfgMem only allows the cluster options to be created once,
+	// but the options of the manager are still updated.
+	m.UnregisterBucketTrackerFor("b0")
+	m.ResetBucketTrackedForHibernationFor("b0")
+	if m.IsBucketBeingHibernated("b0") || !m.IsBucketBeingHibernated("b1") {
+		t.Errorf("expected only b1 to be tracked")
+	}
+
+	bucketTasks = m.GetHibernationBucketsAndTasks()
+	if len(bucketTasks) != 1 || bucketTasks[0][1] != "b1" {
+		t.Errorf("wrong bucket tasks: %v", bucketTasks)
+	}
+
+	m.ResetBucketTrackedForHibernationFor("b1")
+	if v := m.GetOption(bucketInHibernationKey); v != NoBucketInHibernation {
+		t.Errorf("expected no bucket in hibernation, got: %s", v)
+	}
+
+	// Reading the reset option also drops any remaining trackers.
+	if bucketTasks = m.GetHibernationBucketsAndTasks(); len(bucketTasks) != 0 {
+		t.Errorf("expected no bucket tasks, got: %v", bucketTasks)
+	}
+	if m.IsBucketBeingHibernated("b1") {
+		t.Errorf("expected b1 to not be tracked")
+	}
+}
+
+func TestManagerChangeReports(t *testing.T) {
+	m := NewManager(VERSION, nil, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	m.addChangeReport(&ChangeReport{Component: "janitor", Reason: "noop"})
+	if len(m.ChangeReports()) != 0 {
+		t.Errorf("expected empty change reports to be skipped")
+	}
+
+	for i := 0; i < MANAGER_MAX_CHANGE_REPORTS+5; i++ {
+		m.addChangeReport(&ChangeReport{
+			Component:     "janitor",
+			Reason:        fmt.Sprintf("cfg changed, key: planPIndexes, cas: %d", i),
+			PIndexesAdded: []string{"p0"},
+		})
+	}
+
+	reports := m.ChangeReports()
+	if len(reports) != MANAGER_MAX_CHANGE_REPORTS {
+		t.Fatalf("expected %d change reports, got: %d",
+			MANAGER_MAX_CHANGE_REPORTS, len(reports))
+	}
+	if reports[0].Reason != "cfg changed, key: planPIndexes, cas: 5" ||
+		reports[0].Time.IsZero() {
+		t.Errorf("expected the oldest reports to be evicted, got: %+v",
+			reports[0])
+	}
+
+	// The consecutive reports of the same errors are counted, rather
+	// than evicting the other reports.
+	for i := 0; i < MANAGER_MAX_CHANGE_REPORTS; i++ {
+		m.addChangeReport(&ChangeReport{
+			Component: "janitor",
+			Reason:    fmt.Sprintf("cfg changed, cas: %d", i),
+			Errs:      []string{"janitor: adding feed, err: down"},
+		})
+	}
+
+	reports = m.ChangeReports()
+	last := reports[len(reports)-1]
+	if reports[1].Reason != "cfg changed, key: planPIndexes, cas: 7" ||
+		last.Reason != "cfg changed, cas: 0" ||
+		last.Repeats != MANAGER_MAX_CHANGE_REPORTS-1 ||
+		last.LastRepeat == nil || last.LastRepeat.Before(last.Time) {
+		t.Fatalf("expected the repeated errs to be counted, got: %+v", last)
+	}
+
+	// Other errors and changes are tracked again.
+	m.addChangeReport(&ChangeReport{
+		Component: "janitor", Errs: []string{"janitor: other err"},
+	})
+	m.addChangeReport(&ChangeReport{
+		Component: "janitor", Errs: []string{"janitor: other err"},
+		PIndexesAdded: []string{"p1"},
+	})
+	reports = m.ChangeReports()
+	if len(reports[len(reports)-2].Errs) != 1 ||
+		reports[len(reports)-2].Repeats != 0 ||
+		len(reports[len(reports)-1].PIndexesAdded) != 1 {
+		t.Errorf("expected other errs to be tracked, got: %+v",
+			reports[len(reports)-2:])
+	}
+}
+
+func TestManagerShutdown(t *testing.T) {
+	emptyDir, _ := os.MkdirTemp("./tmp", "test")
+	defer os.RemoveAll(emptyDir)
+	m := NewManager(VERSION, nil, NewUUID(),
+		nil, "", 1, "", "", emptyDir, "", nil)
+	m.Start("wanted")
+	p, err := NewPIndex(m, "p0", "uuid", "blackhole",
+		"indexName", "indexUUID", "",
+		"sourceType", "sourceName", "sourceUUID",
+		"", "sourcePartitions",
+		m.PIndexPath("p0"))
+	if err != nil {
+		t.Errorf("error creating pindex: %v", err)
+	}
+	m.registerPIndex(p)
+
+	err = m.Shutdown(m.ShutdownDeadline())
+	if err != nil {
+		t.Errorf("expected Shutdown() to work, err: %v", err)
+	}
+	feeds, pindexes := m.CurrentMaps()
+	if len(feeds) != 0 || len(pindexes) != 0 {
+		t.Errorf("wrong counts for current feeds (%d) & pindexes (%d)",
+			len(feeds), len(pindexes))
+	}
+	if _, err = os.Stat(m.PIndexPath("p0")); err != nil {
+		t.Errorf("expected the pindex files to be kept, err: %v", err)
+	}
+
+	// A repeated Shutdown() is harmless.
+	if err = m.Shutdown(time.Second); err != nil {
+		t.Errorf("expected repeated Shutdown() to work, err: %v", err)
+	}
+
+	// So are concurrent shutdowns.
+	m = NewManager(VERSION, nil, NewUUID(),
+		nil, "", 1, "", "", emptyDir, "", nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Shutdown(time.Second)
+		}()
+	}
+	wg.Wait()
+}
+
 func TestManagerRemovePIndex(t *testing.T) {
 	emptyDir, _ := os.MkdirTemp("./tmp", "test")
 	defer os.RemoveAll(emptyDir)
@@ -1715,3 +1928,210 @@ func TestGroupSourcePartitionsIntoPlanPIndexes(t *testing.T) {
 		}
 	}
 }
+
+func TestCalcIndexPartitions(t *testing.T) {
+	defer func() {
+		SourceSizeEstimatorHook = nil
+	}()
+
+	options := map[string]string{
+		"targetItemsPerIndexPartition": "1000",
+		"targetBytesPerIndexPartition": "1000000",
+	}
+
+	tests := []struct {
+		minParts, maxParts int
+		estimate           *SourceSizeEstimate
+		prevParts          int
+		expected           int
+	}{
+		{ // no bounds, so no automatic sizing
+			estimate: &SourceSizeEstimate{ItemCount: 5000},
+			expected: 0,
+		},
+		{ // no estimator
+			minParts: 1, maxParts: 10,
+			expected: 0,
+		},
+		{ // sized by item count
+			minParts: 1, maxParts: 10,
+			estimate: &SourceSizeEstimate{ItemCount: 4500},
+			expected: 5,
+		},
+		{ // sized by disk size, which is the larger ask
+			minParts: 1, maxParts: 10,
+			estimate: &SourceSizeEstimate{ItemCount: 10, DiskSize: 7000000},
+			expected: 7,
+		},
+		{ // clamped to max
+			minParts: 1, maxParts: 10,
+			estimate: &SourceSizeEstimate{ItemCount: 100000},
+			expected: 10,
+		},
+		{ // clamped to min
+			minParts: 3, maxParts: 10,
+			estimate: &SourceSizeEstimate{},
+			expected: 3,
+		},
+		{ // no max bound
+			minParts: 2,
+			estimate: &SourceSizeEstimate{ItemCount: 100000},
+			expected: 100,
+		},
+		{ // sticks to the previous plan of the same indexDef
+			minParts: 1, maxParts: 10,
+			estimate:  &SourceSizeEstimate{ItemCount: 100000},
+			prevParts: 4,
+			expected:  4,
+		},
+	}
+
+	for i, test := range tests {
+		estimate := test.estimate
+		SourceSizeEstimatorHook = nil
+		if estimate != nil {
+			SourceSizeEstimatorHook = func(indexDef *IndexDef, server string,
+				options map[string]string) (*SourceSizeEstimate, error) {
+				return estimate, nil
+			}
+		}
+
+		indexDef := &IndexDef{
+			Name: "idx",
+			UUID: "idxUUID",
+			PlanParams: PlanParams{
+				MinIndexPartitions: test.minParts,
+				MaxIndexPartitions: test.maxParts,
+			},
+		}
+
+		planPIndexesPrev := NewPlanPIndexes("")
+		for j := 0; j < test.prevParts; j++ {
+			name := fmt.Sprintf("idx_%d", j)
+			planPIndexesPrev.PlanPIndexes[name] = &PlanPIndex{
+				Name:      name,
+				IndexName: "idx",
+				IndexUUID: "idxUUID",
+			}
+		}
+
+		n, err := CalcIndexPartitions(indexDef, "", options, planPIndexesPrev)
+		if err != nil {
+			t.Fatalf("[%d] expected no err, got: %v", i, err)
+		}
+		if n != test.expected {
+			t.Errorf("[%d] expected %d index partitions, got %d",
+				i, test.expected, n)
+		}
+	}
+
+	SourceSizeEstimatorHook = func(indexDef *IndexDef, server string,
+		options map[string]string) (*SourceSizeEstimate, error) {
+		return nil, fmt.Errorf("estimate failed")
+	}
+	indexDef := &IndexDef{
+		Name:       "idx",
+		PlanParams: PlanParams{MinIndexPartitions: 1},
+	}
+	if _, err := CalcIndexPartitions(indexDef, "", options, nil); err == nil {
+		t.Errorf("expected err from a failing estimator")
+	}
+	if sizeIndexDefPartitions(indexDef, "", options, nil) != indexDef {
+		t.Errorf("expected the indexDef as-is from a failing estimator")
+	}
+}
+
+func TestPauseIndexIngest(t *testing.T) {
+	prevDataSourceUUID := DataSourceUUID
+	DataSourceUUID = func(sourceType, sourceName, sourceParams, server string,
+		options map[string]string) (string, error) {
+		return "123", nil
+	}
+
+	emptyDir, _ := os.MkdirTemp("./tmp", "test")
+	defer func() {
+		DataSourceUUID = prevDataSourceUUID
+		os.RemoveAll(emptyDir)
+	}()
+
+	cfg := NewCfgMem()
+	m := NewManager(VERSION, cfg, NewUUID(), nil, "", 1, "", ":1000",
+		emptyDir, "some-datasource", nil)
+	if err := m.Start("wanted"); err != nil {
+		t.Fatalf("expected Manager.Start() to work, err: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.PauseIndexIngest("foo", ""); err == nil {
+		t.Errorf("expected err on a missing index")
+	}
+
+	err := m.CreateIndex("primary", "default", "123", "",
+		"blackhole", "foo", "", PlanParams{}, "")
+	if err != nil {
+		t.Fatalf("expected CreateIndex() to work, err: %v", err)
+	}
+
+	waitMaps := func(numFeeds int) map[string]*PIndex {
+		for i := 0; i < 200; i++ {
+			m.Kick("test")
+			feeds, pindexes := m.CurrentMaps()
+			if len(feeds) == numFeeds && len(pindexes) == 1 {
+				return pindexes
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected %d feeds and 1 pindex", numFeeds)
+		return nil
+	}
+
+	pindexes := waitMaps(1)
+
+	indexDefs, _, _ := CfgGetIndexDefs(cfg)
+	indexUUID := indexDefs.IndexDefs["foo"].UUID
+
+	if err = m.PauseIndexIngest("foo", "wrong-uuid"); err == nil {
+		t.Errorf("expected err on a mismatched index UUID")
+	}
+	if err = m.PauseIndexIngest("foo", indexUUID); err != nil {
+		t.Fatalf("expected PauseIndexIngest() to work, err: %v", err)
+	}
+
+	indexDefs, _, _ = CfgGetIndexDefs(cfg)
+	foo := indexDefs.IndexDefs["foo"]
+	if foo.UUID != indexUUID || !IndexDefIngestPaused(foo) {
+		t.Errorf("expected a paused index with the same UUID, got: %#v", foo)
+	}
+
+	// The pindex stays loaded, while its feed is stopped.
+	pausedPIndexes := waitMaps(0)
+	if !reflect.DeepEqual(keysOf(pindexes), keysOf(pausedPIndexes)) {
+		t.Errorf("expected the same pindexes, got: %v, paused: %v",
+			keysOf(pindexes), keysOf(pausedPIndexes))
+	}
+
+	if err = m.ResumeIndexIngest("foo", ""); err != nil {
+		t.Fatalf("expected ResumeIndexIngest() to work, err: %v", err)
+	}
+
+	indexDefs, _, _ = CfgGetIndexDefs(cfg)
+	foo = indexDefs.IndexDefs["foo"]
+	if foo.UUID != indexUUID || IndexDefIngestPaused(foo) ||
+		len(foo.PlanParams.NodePlanParams[""]) != 0 {
+		t.Errorf("expected a resumed index with the same UUID, got: %#v", foo)
+	}
+
+	resumedPIndexes := waitMaps(1)
+	if !reflect.DeepEqual(keysOf(pindexes), keysOf(resumedPIndexes)) {
+		t.Errorf("expected the same pindexes, got: %v, resumed: %v",
+			keysOf(pindexes), keysOf(resumedPIndexes))
+	}
+}
+
+func keysOf(pindexes map[string]*PIndex) map[string]bool {
+	rv := map[string]bool{}
+	for name := range pindexes {
+		rv[name] = true
+	}
+	return rv
+}
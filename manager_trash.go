@@ -0,0 +1,428 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// The index trash keeps the definitions of deleted indexes for a
+// retention window, during which a deleted index can be undeleted.
+// Optionally, the files of the deleted index's pindexes are also kept
+// as tombstones, so that an undeleted index that's re-planned onto the
+// same nodes reopens its pindexes instead of rebuilding them.
+
+// INDEX_TRASH_KEY is the Cfg key of the index trash.
+const INDEX_TRASH_KEY = "indexTrash"
+
+// INDEX_TRASH_RETENTION_OPTION is the manager option that holds the
+// number of seconds that deleted indexes are kept in the index trash,
+// where an empty or zero value disables the index trash.
+const INDEX_TRASH_RETENTION_OPTION = "indexTrashRetentionSecs"
+
+// INDEX_TRASH_KEEP_DATA_OPTION is the manager option that, when
+// "true", keeps the pindex files of the indexes in the index trash.
+const INDEX_TRASH_KEEP_DATA_OPTION = "indexTrashKeepData"
+
+// PINDEX_TRASH_SUFFIX is the suffix of the tombstoned directory of a
+// pindex of an index in the index trash.
+const PINDEX_TRASH_SUFFIX = ".trashed"
+
+// IndexTrashTickInterval is how often the expired entries of the index
+// trash and their tombstoned pindexes are purged.
+var IndexTrashTickInterval = time.Minute
+
+// IndexTrash is the Cfg value of the deleted indexes that can still be
+// undeleted.
+type IndexTrash struct {
+	UUID    string                      `json:"uuid"`
+	Entries map[string]*IndexTrashEntry `json:"entries"` // Keyed by index UUID.
+}
+
+// An IndexTrashEntry is a deleted index in the index trash.
+type IndexTrashEntry struct {
+	IndexDef  *IndexDef `json:"indexDef"`
+	DeletedAt time.Time `json:"deletedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	KeepData  bool      `json:"keepData"`
+}
+
+// CfgGetIndexTrash retrieves the index trash from a Cfg provider.
+func CfgGetIndexTrash(cfg Cfg) (*IndexTrash, uint64, error) {
+	v, cas, err := cfg.Get(INDEX_TRASH_KEY, 0)
+	if err != nil {
+		return nil, cas, err
+	}
+	if v == nil {
+		return nil, cas, nil
+	}
+	rv := &IndexTrash{}
+	err = UnmarshalJSON(v, rv)
+	if err != nil {
+		return nil, cas, err
+	}
+	return rv, cas, nil
+}
+
+// CfgSetIndexTrash updates the index trash on a Cfg provider.
+func CfgSetIndexTrash(cfg Cfg, trash *IndexTrash,
+	cas uint64) (uint64, error) {
+	buf, err := MarshalJSON(trash)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Set(INDEX_TRASH_KEY, buf, cas)
+}
+
+// updateIndexTrash applies the update to the index trash in the Cfg,
+// retrying on CAS mismatches.
+func (mgr *Manager) updateIndexTrash(update func(*IndexTrash) error) error {
+	return RetryOnCASMismatch(func() error {
+		trash, cas, err := CfgGetIndexTrash(mgr.cfg)
+		if err != nil {
+			return err
+		}
+		if trash == nil {
+			trash = &IndexTrash{}
+		}
+		if trash.Entries == nil {
+			trash.Entries = map[string]*IndexTrashEntry{}
+		}
+		err = update(trash)
+		if err != nil {
+			return err
+		}
+		trash.UUID = NewUUID()
+
+		_, err = CfgSetIndexTrash(mgr.cfg, trash, cas)
+		return err
+	}, 100)
+}
+
+// indexTrashRetention returns the retention window of the index trash,
+// which is zero when the index trash is disabled.
+func (mgr *Manager) indexTrashRetention() time.Duration {
+	v := mgr.GetOption(INDEX_TRASH_RETENTION_OPTION)
+	if v == "" {
+		return 0
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// trashIndexDef adds an index definition that's being deleted to the
+// index trash, when the index trash is enabled.
+func (mgr *Manager) trashIndexDef(indexDef *IndexDef) (bool, error) {
+	retention := mgr.indexTrashRetention()
+	if retention <= 0 || indexDef == nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	entry := &IndexTrashEntry{
+		IndexDef:  indexDef,
+		DeletedAt: now,
+		ExpiresAt: now.Add(retention),
+		KeepData:  mgr.GetOption(INDEX_TRASH_KEEP_DATA_OPTION) == "true",
+	}
+
+	err := mgr.updateIndexTrash(func(trash *IndexTrash) error {
+		trash.Entries[indexDef.UUID] = entry
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("manager_trash: could not trash index: %s,"+
+			" err: %v", indexDef.Name, err)
+	}
+
+	atomic.AddUint64(&mgr.stats.TotIndexTrashed, 1)
+
+	return true, nil
+}
+
+// untrashIndexDef removes an index from the index trash.
+func (mgr *Manager) untrashIndexDef(indexUUID string) error {
+	return mgr.updateIndexTrash(func(trash *IndexTrash) error {
+		delete(trash.Entries, indexUUID)
+		return nil
+	})
+}
+
+// IndexTrash returns the unexpired entries of the index trash, sorted
+// by their deletion time, most recent first.
+func (mgr *Manager) IndexTrash() ([]*IndexTrashEntry, error) {
+	trash, _, err := CfgGetIndexTrash(mgr.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := []*IndexTrashEntry{}
+	if trash == nil {
+		return rv, nil
+	}
+
+	now := time.Now()
+	for _, entry := range trash.Entries {
+		if entry.IndexDef != nil && now.Before(entry.ExpiresAt) {
+			rv = append(rv, entry)
+		}
+	}
+
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].DeletedAt.After(rv[j].DeletedAt)
+	})
+
+	return rv, nil
+}
+
+// UndeleteIndex restores a deleted index from the index trash, with the
+// same index UUID.  When the indexUUID is empty, the most recently
+// deleted index of that name is restored.
+func (mgr *Manager) UndeleteIndex(indexName, indexUUID string) (
+	*IndexDef, error) {
+	atomic.AddUint64(&mgr.stats.TotUndeleteIndex, 1)
+
+	entries, err := mgr.IndexTrash()
+	if err != nil {
+		return nil, fmt.Errorf("manager_trash: could not get index trash,"+
+			" err: %v", err)
+	}
+
+	var entry *IndexTrashEntry
+	for _, e := range entries {
+		if e.IndexDef.Name == indexName &&
+			(indexUUID == "" || e.IndexDef.UUID == indexUUID) {
+			entry = e
+			break
+		}
+	}
+	if entry == nil {
+		return nil, NewBadRequestError("manager_trash: no deleted index"+
+			" to undelete, indexName: %s, indexUUID: %s", indexName, indexUUID)
+	}
+
+	indexDef := entry.IndexDef
+
+	err = RetryOnCASMismatch(func() error {
+		indexDefs, cas, err := CfgGetIndexDefs(mgr.cfg)
+		if err != nil {
+			return err
+		}
+		if indexDefs == nil {
+			indexDefs = NewIndexDefs(CfgGetVersion(mgr.cfg))
+		}
+		if VersionGTE(mgr.version, indexDefs.ImplVersion) == false {
+			return NewInternalServerError("manager_trash: could not undelete"+
+				" index, indexDefs.ImplVersion: %s > mgr.version: %s",
+				indexDefs.ImplVersion, mgr.version)
+		}
+		if _, exists := indexDefs.IndexDefs[indexName]; exists {
+			return NewBadRequestError("manager_trash: cannot undelete,"+
+				" an index already exists, indexName: %s", indexName)
+		}
+
+		indexDefs.UUID = NewUUID()
+		indexDefs.IndexDefs[indexName] = indexDef
+		indexDefs.ImplVersion = CfgGetVersion(mgr.cfg)
+
+		_, err = CfgSetIndexDefs(mgr.cfg, indexDefs, cas)
+		return err
+	}, 100)
+	if err != nil {
+		return nil, fmt.Errorf("manager_trash: could not save indexDefs,"+
+			" err: %w", err)
+	}
+
+	err = mgr.untrashIndexDef(indexDef.UUID)
+	if err != nil {
+		log.Warnf("manager_trash: could not untrash index: %s, err: %v",
+			indexName, err)
+	}
+
+	mgr.refreshIndexDefsWithTimeout(cfgRefreshWaitExpiry)
+
+	mgr.PlannerKick("api/UndeleteIndex, indexName: " + indexName)
+	atomic.AddUint64(&mgr.stats.TotUndeleteIndexOk, 1)
+
+	log.Printf("manager_trash: index definition undeleted,"+
+		" indexType: %s, indexName: %s, indexUUID: %s",
+		indexDef.Type, indexDef.Name, indexDef.UUID)
+
+	return indexDef, nil
+}
+
+// ------------------------------------------------------------------------
+
+// pindexTrashPath returns the path of the tombstoned files of a pindex.
+func pindexTrashPath(path string) string {
+	return path + PINDEX_TRASH_SUFFIX
+}
+
+// trashedIndexKeepsData returns true when the index of the UUID is in
+// the index trash and its pindex files are to be kept.
+func (mgr *Manager) trashedIndexKeepsData(indexUUID string) bool {
+	if mgr.cfg == nil {
+		return false
+	}
+	trash, _, err := CfgGetIndexTrash(mgr.cfg)
+	if err != nil || trash == nil {
+		return false
+	}
+	entry, exists := trash.Entries[indexUUID]
+	return exists && entry.KeepData && time.Now().Before(entry.ExpiresAt)
+}
+
+// tombstonePIndex closes a pindex without removing its files, and
+// moves its files aside for a later undelete.
+func (mgr *Manager) tombstonePIndex(pindex *PIndex) error {
+	err := pindex.Close(false)
+	if err != nil {
+		return err
+	}
+
+	trashPath := pindexTrashPath(pindex.Path)
+	os.RemoveAll(trashPath)
+
+	err = os.Rename(pindex.Path, trashPath)
+	if err != nil {
+		log.Warnf("manager_trash: could not tombstone pindex: %s,"+
+			" removing it, err: %v", pindex.Name, err)
+		return os.RemoveAll(pindex.Path)
+	}
+
+	atomic.AddUint64(&mgr.stats.TotPIndexTombstoned, 1)
+
+	log.Printf("manager_trash: tombstoned pindex: %s, path: %s",
+		pindex.Name, trashPath)
+
+	return nil
+}
+
+// restoreTrashedPIndex moves the tombstoned files of a pindex back to
+// the pindex path, returning true when there were tombstoned files.
+func (mgr *Manager) restoreTrashedPIndex(name, path string) bool {
+	trashPath := pindexTrashPath(path)
+	if _, err := os.Stat(trashPath); err != nil {
+		return false
+	}
+
+	err := os.Rename(trashPath, path)
+	if err != nil {
+		log.Warnf("manager_trash: could not restore pindex: %s, err: %v",
+			name, err)
+		return false
+	}
+
+	log.Printf("manager_trash: restored pindex: %s, path: %s", name, path)
+
+	return true
+}
+
+// ------------------------------------------------------------------------
+
+// IndexTrashLoop purges the expired entries of the index trash, along
+// with the tombstoned pindexes that are no longer needed.
+func (mgr *Manager) IndexTrashLoop() {
+	ticker := time.NewTicker(IndexTrashTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mgr.stopCh:
+			return
+		case now := <-ticker.C:
+			mgr.purgeIndexTrash(now)
+		}
+	}
+}
+
+// purgeIndexTrash removes the entries of the index trash that expired
+// by the time, and the tombstoned pindex directories whose index is no
+// longer in the index trash.
+func (mgr *Manager) purgeIndexTrash(now time.Time) {
+	if mgr.cfg == nil {
+		return
+	}
+	trash, _, err := CfgGetIndexTrash(mgr.cfg)
+	if err != nil {
+		log.Warnf("manager_trash: purge, err: %v", err)
+		return
+	}
+
+	var expired []string
+	if trash != nil {
+		for indexUUID, entry := range trash.Entries {
+			if !now.Before(entry.ExpiresAt) {
+				expired = append(expired, indexUUID)
+			}
+		}
+	}
+
+	if len(expired) > 0 {
+		err = mgr.updateIndexTrash(func(trash *IndexTrash) error {
+			for _, indexUUID := range expired {
+				delete(trash.Entries, indexUUID)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Warnf("manager_trash: purge, err: %v", err)
+			return
+		}
+
+		atomic.AddUint64(&mgr.stats.TotIndexTrashPurged, uint64(len(expired)))
+
+		trash, _, err = CfgGetIndexTrash(mgr.cfg)
+		if err != nil {
+			return
+		}
+	}
+
+	dirEntries, err := os.ReadDir(mgr.dataDir)
+	if err != nil {
+		return
+	}
+
+	for _, dirEntry := range dirEntries {
+		if !strings.HasSuffix(dirEntry.Name(), PINDEX_TRASH_SUFFIX) {
+			continue
+		}
+
+		// A pindex name embeds the UUID of its index.
+		kept := false
+		if trash != nil {
+			for indexUUID, entry := range trash.Entries {
+				if entry.KeepData && now.Before(entry.ExpiresAt) &&
+					strings.Contains(dirEntry.Name(), "_"+indexUUID+"_") {
+					kept = true
+					break
+				}
+			}
+		}
+		if kept {
+			continue
+		}
+
+		path := filepath.Join(mgr.dataDir, dirEntry.Name())
+		log.Printf("manager_trash: removing tombstoned pindex path: %s", path)
+		os.RemoveAll(path)
+	}
+}
@@ -0,0 +1,139 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManagerIndexTrash(t *testing.T) {
+	prevDataSourceUUID := DataSourceUUID
+	DataSourceUUID = func(sourceType, sourceName, sourceParams, server string,
+		options map[string]string) (string, error) {
+		return "123", nil
+	}
+
+	emptyDir, _ := os.MkdirTemp("./tmp", "test")
+	defer func() {
+		DataSourceUUID = prevDataSourceUUID
+		os.RemoveAll(emptyDir)
+	}()
+
+	cfg := NewCfgMem()
+	m := NewManager(VERSION, cfg, NewUUID(), nil, "", 1, "", ":1000",
+		emptyDir, "some-datasource", nil)
+	if err := m.Start("wanted"); err != nil {
+		t.Fatalf("expected Manager.Start() to work, err: %v", err)
+	}
+	defer m.Stop()
+
+	m.SetOption(INDEX_TRASH_RETENTION_OPTION, "3600", false)
+	m.SetOption(INDEX_TRASH_KEEP_DATA_OPTION, "true", false)
+
+	waitPIndexes := func(exp int) map[string]*PIndex {
+		for i := 0; i < 100; i++ {
+			m.PlannerNOOP("test")
+			m.JanitorNOOP("test")
+			_, pindexes := m.CurrentMaps()
+			if len(pindexes) == exp {
+				return pindexes
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("expected %d pindexes", exp)
+		return nil
+	}
+
+	if err := m.CreateIndex("primary", "default", "123", "",
+		"blackhole", "foo", "", PlanParams{}, ""); err != nil {
+		t.Fatalf("expected CreateIndex() to work, err: %v", err)
+	}
+
+	var pindex *PIndex
+	for _, pindex = range waitPIndexes(1) {
+	}
+
+	if err := m.DeleteIndex("foo"); err != nil {
+		t.Fatalf("expected DeleteIndex() to work, err: %v", err)
+	}
+	waitPIndexes(0)
+
+	entries, err := m.IndexTrash()
+	if err != nil || len(entries) != 1 ||
+		entries[0].IndexDef.UUID != pindex.IndexUUID || !entries[0].KeepData {
+		t.Fatalf("expected a trashed index, got: %+v, err: %v", entries, err)
+	}
+
+	trashPath := pindexTrashPath(pindex.Path)
+	if _, err = os.Stat(trashPath); err != nil {
+		t.Fatalf("expected a tombstoned pindex, err: %v", err)
+	}
+
+	// An index of the same name blocks the undelete.
+	if err = m.CreateIndex("primary", "default", "123", "",
+		"blackhole", "foo", "", PlanParams{}, ""); err != nil {
+		t.Fatalf("expected CreateIndex() to work, err: %v", err)
+	}
+	if _, err = m.UndeleteIndex("foo", ""); err == nil {
+		t.Fatalf("expected UndeleteIndex() to fail")
+	}
+
+	// The recreated index isn't trashed while its data isn't kept.
+	m.SetOption(INDEX_TRASH_KEEP_DATA_OPTION, "false", false)
+	if err = m.DeleteIndex("foo"); err != nil {
+		t.Fatalf("expected DeleteIndex() to work, err: %v", err)
+	}
+	waitPIndexes(0)
+
+	if _, err = m.UndeleteIndex("foo", "not-a-uuid"); err == nil {
+		t.Fatalf("expected UndeleteIndex() with a wrong UUID to fail")
+	}
+
+	indexDef, err := m.UndeleteIndex("foo", pindex.IndexUUID)
+	if err != nil || indexDef.UUID != pindex.IndexUUID {
+		t.Fatalf("expected UndeleteIndex() to work, got: %+v, err: %v",
+			indexDef, err)
+	}
+
+	// The tombstoned pindex is reopened instead of rebuilt.
+	var restored *PIndex
+	for _, restored = range waitPIndexes(1) {
+	}
+	if restored.Name != pindex.Name || restored.UUID != pindex.UUID {
+		t.Fatalf("expected the restored pindex, got: %+v", restored)
+	}
+	if _, err = os.Stat(trashPath); err == nil {
+		t.Fatalf("expected no tombstoned pindex")
+	}
+
+	entries, _ = m.IndexTrash()
+	if len(entries) != 1 || entries[0].IndexDef.UUID == pindex.IndexUUID {
+		t.Fatalf("expected only the recreated index trashed, got: %+v",
+			entries)
+	}
+
+	// Expired entries and their tombstones are purged.
+	stale := filepath.Join(emptyDir, "bar_x_00000000.pindex"+PINDEX_TRASH_SUFFIX)
+	os.MkdirAll(stale, 0700)
+
+	m.purgeIndexTrash(time.Now().Add(2 * time.Hour))
+
+	if entries, _ = m.IndexTrash(); len(entries) != 0 {
+		t.Fatalf("expected the index trash purged, got: %+v", entries)
+	}
+	if _, err = os.Stat(stale); err == nil {
+		t.Fatalf("expected the stale tombstone removed")
+	}
+	if _, err = m.UndeleteIndex("foo", ""); err == nil {
+		t.Fatalf("expected UndeleteIndex() to fail")
+	}
+}
@@ -0,0 +1,286 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// The memory governor keeps the memory used by a node's pindexes
+// within a quota.  It periodically sums up the memory estimates of
+// the pindexes, as reported by their PIndexImplType's MemoryEstimate()
+// hook, and while the sum exceeds the quota it throttles the DCP
+// feeds, by holding back their mutations, and delays the openings of
+// new pindexes.
+
+// MEMORY_QUOTA_OPTION is the manager option that holds the memory
+// quota, in bytes, of the pindexes of a node, where 0 or empty
+// disables the memory governor.
+const MEMORY_QUOTA_OPTION = "pindexMemoryQuota"
+
+// MemoryGovernorInterval is the time between the memory governor's
+// refreshes of the pindexes' memory estimates.
+var MemoryGovernorInterval = 5 * time.Second
+
+// MemoryGovernorAdmitTimeout bounds how long the opening of a pindex
+// waits for memory before it's failed, where the janitor retries the
+// failed openings once the node is back within its memory quota.
+var MemoryGovernorAdmitTimeout = 30 * time.Second
+
+// ErrMemoryQuotaExceeded is returned when the opening of a pindex
+// can't be admitted within the node's memory quota.
+var ErrMemoryQuotaExceeded = errors.New("memory_governor: memory quota exceeded")
+
+// A MemoryGovernor tracks the memory estimates of a node's pindexes
+// against a memory quota, for admission control.
+type MemoryGovernor struct {
+	over int32 // 1 while the used memory exceeds the quota, atomic.
+
+	m         sync.Mutex
+	quota     uint64 // A quota of 0 means unlimited / disabled.
+	used      uint64
+	estimates map[string]uint64 // Keyed by pindex name.
+	waitCh    chan struct{}     // Closed and replaced when memory frees up.
+	rejected  bool              // Whether any admission was rejected.
+
+	TotThrottles     uint64
+	TotAdmitWaits    uint64
+	TotAdmitRejected uint64
+}
+
+// NewMemoryGovernor returns a MemoryGovernor without a quota.
+func NewMemoryGovernor() *MemoryGovernor {
+	return &MemoryGovernor{
+		estimates: map[string]uint64{},
+		waitCh:    make(chan struct{}),
+	}
+}
+
+// Quota returns the memory quota, where 0 means unlimited.
+func (g *MemoryGovernor) Quota() uint64 {
+	g.m.Lock()
+	quota := g.quota
+	g.m.Unlock()
+	return quota
+}
+
+// Used returns the sum of the memory estimates of the pindexes.
+func (g *MemoryGovernor) Used() uint64 {
+	g.m.Lock()
+	used := g.used
+	g.m.Unlock()
+	return used
+}
+
+// Estimates returns a copy of the memory estimates of the pindexes,
+// keyed by pindex name.
+func (g *MemoryGovernor) Estimates() map[string]uint64 {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	rv := make(map[string]uint64, len(g.estimates))
+	for name, estimate := range g.estimates {
+		rv[name] = estimate
+	}
+	return rv
+}
+
+// Update replaces the quota and the memory estimates of the pindexes,
+// and returns true when the governor is back within its quota after
+// having rejected any admissions, which are then worth retrying.
+func (g *MemoryGovernor) Update(quota uint64,
+	estimates map[string]uint64) (retryAdmissions bool) {
+	var used uint64
+	for _, estimate := range estimates {
+		used += estimate
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	g.quota = quota
+	g.used = used
+	g.estimates = estimates
+
+	if g.quota > 0 && g.used > g.quota {
+		atomic.StoreInt32(&g.over, 1)
+		return false
+	}
+
+	atomic.StoreInt32(&g.over, 0)
+	g.wakeWaitersLOCKED()
+
+	retryAdmissions = g.rejected
+	g.rejected = false
+
+	return retryAdmissions
+}
+
+func (g *MemoryGovernor) wakeWaitersLOCKED() {
+	close(g.waitCh)
+	g.waitCh = make(chan struct{})
+}
+
+// Throttle blocks while the used memory exceeds the quota, or until
+// the closeCh is closed.
+func (g *MemoryGovernor) Throttle(closeCh <-chan struct{}) error {
+	if g == nil || atomic.LoadInt32(&g.over) == 0 {
+		return nil
+	}
+
+	atomic.AddUint64(&g.TotThrottles, 1)
+
+	for {
+		g.m.Lock()
+		if atomic.LoadInt32(&g.over) == 0 {
+			g.m.Unlock()
+			return nil
+		}
+		waitCh := g.waitCh
+		g.m.Unlock()
+
+		select {
+		case <-closeCh:
+			return ErrMemoryQuotaExceeded
+		case <-waitCh:
+		}
+	}
+}
+
+// Admit waits, up to the timeout or until the closeCh is closed, for
+// the used memory plus the average memory estimate of the pindexes,
+// as the estimate of a new pindex, to be within the quota.
+func (g *MemoryGovernor) Admit(closeCh <-chan struct{},
+	timeout time.Duration) error {
+	if g == nil {
+		return nil
+	}
+
+	var timeoutCh <-chan time.Time
+	var waited bool
+
+	for {
+		g.m.Lock()
+		var estimate uint64
+		if len(g.estimates) > 0 {
+			estimate = g.used / uint64(len(g.estimates))
+		}
+		if g.quota == 0 || g.used+estimate <= g.quota {
+			g.m.Unlock()
+			return nil
+		}
+		waitCh := g.waitCh
+		g.m.Unlock()
+
+		if !waited {
+			waited = true
+			atomic.AddUint64(&g.TotAdmitWaits, 1)
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			timeoutCh = timer.C
+		}
+
+		select {
+		case <-closeCh:
+			return ErrMemoryQuotaExceeded
+		case <-timeoutCh:
+			atomic.AddUint64(&g.TotAdmitRejected, 1)
+			g.m.Lock()
+			g.rejected = true
+			g.m.Unlock()
+			return ErrMemoryQuotaExceeded
+		case <-waitCh:
+		}
+	}
+}
+
+// ------------------------------------------------------------------------
+
+// MemoryGovernor returns the node's memory governor, which is enabled
+// by the "pindexMemoryQuota" manager option.
+func (mgr *Manager) MemoryGovernor() *MemoryGovernor {
+	return mgr.memoryGovernor
+}
+
+// memoryQuota returns the memory quota of the pindexes of this node,
+// which is 0 when the memory governor is disabled.
+func (mgr *Manager) memoryQuota() uint64 {
+	v := mgr.GetOption(MEMORY_QUOTA_OPTION)
+	if v == "" {
+		return 0
+	}
+
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		log.Warnf("memory_governor: option: %s, v: %q, err: %v",
+			MEMORY_QUOTA_OPTION, v, err)
+		return 0
+	}
+
+	return n
+}
+
+// refreshMemoryGovernor updates the memory governor with the current
+// memory quota and memory estimates of the pindexes of this node.
+func (mgr *Manager) refreshMemoryGovernor() {
+	estimates := map[string]uint64{}
+
+	quota := mgr.memoryQuota()
+	if quota > 0 {
+		_, pindexes := mgr.CurrentMaps()
+		for name, pindex := range pindexes {
+			pindexImplType := PIndexImplTypes[pindex.IndexType]
+			if pindexImplType == nil || pindexImplType.MemoryEstimate == nil {
+				continue
+			}
+
+			estimate, err := pindexImplType.MemoryEstimate(pindex)
+			if err != nil {
+				log.Warnf("memory_governor: pindex: %s, err: %v", name, err)
+				continue
+			}
+
+			estimates[name] = estimate
+		}
+	}
+
+	wasOver := atomic.LoadInt32(&mgr.memoryGovernor.over) != 0
+
+	if mgr.memoryGovernor.Update(quota, estimates) {
+		go mgr.JanitorKick("memory_governor: within memory quota")
+	}
+
+	if over := atomic.LoadInt32(&mgr.memoryGovernor.over) != 0; over != wasOver {
+		log.Printf("memory_governor: over quota: %t, used: %d, quota: %d",
+			over, mgr.memoryGovernor.Used(), quota)
+	}
+}
+
+// MemoryGovernorLoop periodically refreshes the memory governor, until
+// the manager is stopped.
+func (mgr *Manager) MemoryGovernorLoop() {
+	ticker := time.NewTicker(MemoryGovernorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mgr.stopCh:
+			mgr.memoryGovernor.Update(0, nil) // Releases any waiters.
+			return
+		case <-ticker.C:
+			mgr.refreshMemoryGovernor()
+		}
+	}
+}
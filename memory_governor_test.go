@@ -0,0 +1,123 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryGovernor(t *testing.T) {
+	var nilGovernor *MemoryGovernor
+	if nilGovernor.Throttle(nil) != nil || nilGovernor.Admit(nil, 0) != nil {
+		t.Fatalf("expected a nil governor to admit everything")
+	}
+
+	g := NewMemoryGovernor()
+	if g.Throttle(nil) != nil || g.Admit(nil, time.Millisecond) != nil {
+		t.Fatalf("expected a governor without a quota to admit everything")
+	}
+
+	// The average estimate of 40 still fits within the quota.
+	g.Update(100, map[string]uint64{"p0": 30, "p1": 30})
+	if g.Used() != 60 || g.Admit(nil, time.Millisecond) != nil {
+		t.Fatalf("expected an admission within the quota")
+	}
+
+	g.Update(100, map[string]uint64{"p0": 50, "p1": 30})
+	err := g.Admit(nil, time.Millisecond)
+	if !errors.Is(err, ErrMemoryQuotaExceeded) || g.TotAdmitRejected != 1 {
+		t.Fatalf("expected a rejected admission, got: %v", err)
+	}
+
+	g.Update(100, map[string]uint64{"p0": 80, "p1": 30})
+
+	closeCh := make(chan struct{})
+	close(closeCh)
+	if g.Throttle(closeCh) == nil {
+		t.Fatalf("expected a throttle over the quota")
+	}
+
+	throttledCh := make(chan error)
+	go func() { throttledCh <- g.Throttle(nil) }()
+	admittedCh := make(chan error)
+	go func() { admittedCh <- g.Admit(nil, time.Minute) }()
+
+	select {
+	case <-throttledCh:
+		t.Fatalf("expected the throttle to wait")
+	case <-admittedCh:
+		t.Fatalf("expected the admission to wait")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Back within the quota, the rejected admissions are worth a retry.
+	if !g.Update(100, map[string]uint64{"p0": 10, "p1": 30}) {
+		t.Fatalf("expected a retry of the rejected admissions")
+	}
+	if err = <-throttledCh; err != nil {
+		t.Fatalf("expected the throttle to be released, err: %v", err)
+	}
+	if err = <-admittedCh; err != nil {
+		t.Fatalf("expected the admission to be released, err: %v", err)
+	}
+	if g.Update(100, map[string]uint64{"p0": 10}) {
+		t.Fatalf("expected no retry without rejected admissions")
+	}
+}
+
+func TestRefreshMemoryGovernor(t *testing.T) {
+	PIndexImplTypes["testMemory"] = &PIndexImplType{
+		MemoryEstimate: func(pindex *PIndex) (uint64, error) {
+			if pindex.Name == "bad" {
+				return 0, errors.New("boom")
+			}
+			return 100, nil
+		},
+	}
+	defer delete(PIndexImplTypes, "testMemory")
+
+	mgr := NewManagerEx(VERSION, nil, NewUUID(), []string{"pindex"},
+		"", 1, "", "", "", "", nil, map[string]string{
+			MEMORY_QUOTA_OPTION: "150",
+		})
+	for _, name := range []string{"p0", "p1", "bad"} {
+		mgr.registerPIndex(&PIndex{Name: name, IndexType: "testMemory"})
+	}
+	mgr.registerPIndex(&PIndex{Name: "other", IndexType: "blackhole"})
+
+	mgr.refreshMemoryGovernor()
+
+	g := mgr.MemoryGovernor()
+	if g.Used() != 200 || g.Quota() != 150 || len(g.Estimates()) != 2 {
+		t.Fatalf("unexpected governor, used: %d, quota: %d, estimates: %v",
+			g.Used(), g.Quota(), g.Estimates())
+	}
+
+	a := mgr.LocalActivities()
+	if a.MemoryUsed != 200 || a.MemoryQuota != 150 {
+		t.Errorf("unexpected activities: %#v", a)
+	}
+
+	prevTimeout := MemoryGovernorAdmitTimeout
+	MemoryGovernorAdmitTimeout = time.Millisecond
+	defer func() { MemoryGovernorAdmitTimeout = prevTimeout }()
+
+	err := mgr.startPIndex(&PlanPIndex{Name: "p2", IndexType: "testMemory"})
+	if !errors.Is(err, ErrMemoryQuotaExceeded) {
+		t.Errorf("expected the opening to be delayed, got: %v", err)
+	}
+
+	mgr.options[MEMORY_QUOTA_OPTION] = ""
+	mgr.refreshMemoryGovernor()
+	if g.Used() != 0 || g.Quota() != 0 || g.Throttle(nil) != nil {
+		t.Errorf("expected a disabled governor")
+	}
+}
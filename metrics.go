@@ -0,0 +1,508 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// MetricLabels are the optional labels of a metric, such as
+// {"index": "beer-sample"}.
+type MetricLabels map[string]string
+
+// A MetricsCounter is a monotonically increasing metric.
+type MetricsCounter interface {
+	Add(delta uint64)
+}
+
+// A MetricsGauge is a metric whose value goes up and down.
+type MetricsGauge interface {
+	Set(v float64)
+}
+
+// A MetricsHistogram tracks the distribution of observed values, such
+// as latencies in seconds.
+type MetricsHistogram interface {
+	Observe(v float64)
+}
+
+// A MetricsProvider is a pluggable metrics backend, which cbgt reports
+// its counters, gauges and histograms to, so that embedding
+// applications can wire in their telemetry stack of choice.  The
+// metrics of the same name and labels are the same metric.
+type MetricsProvider interface {
+	Counter(name string, labels MetricLabels) MetricsCounter
+	Gauge(name string, labels MetricLabels) MetricsGauge
+	Histogram(name string, labels MetricLabels) MetricsHistogram
+}
+
+// MetricsWriter is an optional interface that a MetricsProvider may
+// implement to write out its metrics, such as for a REST endpoint.
+type MetricsWriter interface {
+	// ContentType is the MIME type of the written metrics.
+	ContentType() string
+
+	WriteMetrics(w io.Writer) error
+}
+
+var metricsProvider atomic.Value // Of metricsProviderHolder.
+
+type metricsProviderHolder struct {
+	p MetricsProvider
+}
+
+func init() {
+	SetMetricsProvider(NewJSONMetricsProvider())
+}
+
+// SetMetricsProvider replaces the process-wide MetricsProvider, where a
+// nil provider means a NoopMetricsProvider.  The provider is best set
+// once at startup, as metrics reported to the previous provider stay
+// with it.
+func SetMetricsProvider(p MetricsProvider) {
+	if p == nil {
+		p = NoopMetricsProvider{}
+	}
+	metricsProvider.Store(metricsProviderHolder{p: p})
+}
+
+// GetMetricsProvider returns the process-wide MetricsProvider, which
+// defaults to a JSONMetricsProvider.
+func GetMetricsProvider() MetricsProvider {
+	return metricsProvider.Load().(metricsProviderHolder).p
+}
+
+// MetricsTime invokes f and observes its duration, in seconds, in the
+// histogram.
+func MetricsTime(h MetricsHistogram, f func() error) error {
+	startTime := time.Now()
+	err := f()
+	h.Observe(time.Since(startTime).Seconds())
+	return err
+}
+
+// ------------------------------------------------------------------------
+
+// NoopMetricsProvider is a MetricsProvider that drops all metrics.
+type NoopMetricsProvider struct{}
+
+type noopMetric struct{}
+
+func (noopMetric) Add(delta uint64)  {}
+func (noopMetric) Set(v float64)     {}
+func (noopMetric) Observe(v float64) {}
+
+func (NoopMetricsProvider) Counter(name string,
+	labels MetricLabels) MetricsCounter {
+	return noopMetric{}
+}
+
+func (NoopMetricsProvider) Gauge(name string,
+	labels MetricLabels) MetricsGauge {
+	return noopMetric{}
+}
+
+func (NoopMetricsProvider) Histogram(name string,
+	labels MetricLabels) MetricsHistogram {
+	return noopMetric{}
+}
+
+// ------------------------------------------------------------------------
+
+// MetricsHistogramBuckets are the upper bounds of the buckets of the
+// histograms of the built-in providers, suited to latencies in seconds.
+var MetricsHistogramBuckets = []float64{
+	.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60,
+}
+
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+// A metricSeries is a metric of a name and labels in a MetricsRegistry.
+type metricSeries struct {
+	name   string
+	labels MetricLabels
+	kind   string
+
+	m       sync.Mutex // Protects the fields that follow.
+	value   float64    // Of a counter or gauge.
+	count   uint64     // Of a histogram's observations.
+	sum     float64
+	min     float64
+	max     float64
+	buckets []uint64 // Cumulative counts, per MetricsHistogramBuckets.
+}
+
+func (s *metricSeries) Add(delta uint64) {
+	s.m.Lock()
+	s.value += float64(delta)
+	s.m.Unlock()
+}
+
+func (s *metricSeries) Set(v float64) {
+	s.m.Lock()
+	s.value = v
+	s.m.Unlock()
+}
+
+func (s *metricSeries) Observe(v float64) {
+	s.m.Lock()
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+	for i, le := range MetricsHistogramBuckets {
+		if i < len(s.buckets) && v <= le {
+			s.buckets[i]++
+		}
+	}
+	s.m.Unlock()
+}
+
+// A MetricsRegistry keeps metrics in memory, and is the basis of the
+// built-in JSON and Prometheus providers.
+type MetricsRegistry struct {
+	m      sync.Mutex
+	series map[string]*metricSeries // Keyed by metricSeriesKey().
+}
+
+// NewMetricsRegistry returns a ready-to-use MetricsRegistry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{series: map[string]*metricSeries{}}
+}
+
+func metricSeriesKey(name string, labels MetricLabels) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(strconv.Quote(labels[k]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func (r *MetricsRegistry) get(name string, labels MetricLabels,
+	kind string) *metricSeries {
+	key := metricSeriesKey(name, labels)
+
+	r.m.Lock()
+	s := r.series[key]
+	if s == nil {
+		s = &metricSeries{name: name, kind: kind}
+		if len(labels) > 0 {
+			s.labels = make(MetricLabels, len(labels))
+			for k, v := range labels {
+				s.labels[k] = v
+			}
+		}
+		if kind == metricHistogram {
+			s.buckets = make([]uint64, len(MetricsHistogramBuckets))
+		}
+		r.series[key] = s
+	}
+	r.m.Unlock()
+
+	return s
+}
+
+func (r *MetricsRegistry) Counter(name string,
+	labels MetricLabels) MetricsCounter {
+	return r.get(name, labels, metricCounter)
+}
+
+func (r *MetricsRegistry) Gauge(name string,
+	labels MetricLabels) MetricsGauge {
+	return r.get(name, labels, metricGauge)
+}
+
+func (r *MetricsRegistry) Histogram(name string,
+	labels MetricLabels) MetricsHistogram {
+	return r.get(name, labels, metricHistogram)
+}
+
+// sorted returns the registry's series sorted by key.
+func (r *MetricsRegistry) sorted() ([]string, []*metricSeries) {
+	r.m.Lock()
+	keys := make([]string, 0, len(r.series))
+	for key := range r.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	rv := make([]*metricSeries, len(keys))
+	for i, key := range keys {
+		rv[i] = r.series[key]
+	}
+	r.m.Unlock()
+
+	return keys, rv
+}
+
+// ------------------------------------------------------------------------
+
+// JSONMetricsProvider is the default MetricsProvider, which writes its
+// metrics as a JSON object keyed by metric name and labels, in the
+// manner of the JSON stats of the REST API.
+type JSONMetricsProvider struct {
+	*MetricsRegistry
+}
+
+// NewJSONMetricsProvider returns a ready-to-use JSONMetricsProvider.
+func NewJSONMetricsProvider() *JSONMetricsProvider {
+	return &JSONMetricsProvider{MetricsRegistry: NewMetricsRegistry()}
+}
+
+func (p *JSONMetricsProvider) ContentType() string {
+	return "application/json"
+}
+
+func (p *JSONMetricsProvider) WriteMetrics(w io.Writer) error {
+	keys, series := p.sorted()
+
+	var buf bytes.Buffer
+	buf.Write(JsonOpenBrace)
+	for i, s := range series {
+		if i > 0 {
+			buf.Write(JsonComma)
+		}
+		k, _ := json.Marshal(keys[i])
+		buf.Write(k)
+		buf.WriteByte(':')
+
+		s.m.Lock()
+		if s.kind == metricHistogram {
+			mean := 0.0
+			if s.count > 0 {
+				mean = s.sum / float64(s.count)
+			}
+			fmt.Fprintf(&buf, `{"count":%d,"sum":%s,"min":%s,"max":%s,"mean":%s}`,
+				s.count, formatMetricValue(s.sum), formatMetricValue(s.min),
+				formatMetricValue(s.max), formatMetricValue(mean))
+		} else {
+			buf.WriteString(formatMetricValue(s.value))
+		}
+		s.m.Unlock()
+	}
+	buf.Write(JsonCloseBrace)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ------------------------------------------------------------------------
+
+// PrometheusMetricsProvider is a MetricsProvider that writes its
+// metrics in the Prometheus text exposition format.
+type PrometheusMetricsProvider struct {
+	*MetricsRegistry
+}
+
+// NewPrometheusMetricsProvider returns a ready-to-use
+// PrometheusMetricsProvider.
+func NewPrometheusMetricsProvider() *PrometheusMetricsProvider {
+	return &PrometheusMetricsProvider{MetricsRegistry: NewMetricsRegistry()}
+}
+
+func (p *PrometheusMetricsProvider) ContentType() string {
+	return "text/plain; version=0.0.4"
+}
+
+func (p *PrometheusMetricsProvider) WriteMetrics(w io.Writer) error {
+	_, series := p.sorted()
+
+	// Group the series by name, as the exposition format requires.
+	byName := map[string][]*metricSeries{}
+	var names []string
+	for _, s := range series {
+		name := PrometheusMetricName(s.name)
+		if byName[name] == nil {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], s)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, byName[name][0].kind)
+
+		for _, s := range byName[name] {
+			s.m.Lock()
+			if s.kind == metricHistogram {
+				for i, le := range MetricsHistogramBuckets {
+					fmt.Fprintf(&buf, "%s_bucket%s %d\n", name,
+						prometheusLabels(s.labels, "le", formatMetricValue(le)),
+						s.buckets[i])
+				}
+				fmt.Fprintf(&buf, "%s_bucket%s %d\n", name,
+					prometheusLabels(s.labels, "le", "+Inf"), s.count)
+				fmt.Fprintf(&buf, "%s_sum%s %s\n", name,
+					prometheusLabels(s.labels, "", ""), formatMetricValue(s.sum))
+				fmt.Fprintf(&buf, "%s_count%s %d\n", name,
+					prometheusLabels(s.labels, "", ""), s.count)
+			} else {
+				fmt.Fprintf(&buf, "%s%s %s\n", name,
+					prometheusLabels(s.labels, "", ""), formatMetricValue(s.value))
+			}
+			s.m.Unlock()
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// PrometheusMetricName returns the name with the characters that
+// aren't valid in a Prometheus metric name replaced by '_'.
+func PrometheusMetricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' || (r < unicode.MaxASCII &&
+			(unicode.IsLetter(r) || unicode.IsDigit(r))) {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func prometheusLabels(labels MetricLabels, extraK, extraV string) string {
+	if len(labels) == 0 && extraK == "" {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, PrometheusMetricName(k)+"="+
+			strconv.Quote(labels[k]))
+	}
+	if extraK != "" {
+		parts = append(parts, extraK+"="+strconv.Quote(extraV))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatMetricValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	if math.IsInf(v, -1) {
+		return "-Inf"
+	}
+	if math.IsNaN(v) {
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ------------------------------------------------------------------------
+
+// PublishMetrics reports the manager's stats to the MetricsProvider,
+// as counters named "cbgt_manager_" plus the snake cased stat name,
+// by the increase of each stat since the previous publish.  It's
+// typically invoked before the provider's metrics are written.
+func (mgr *Manager) PublishMetrics() {
+	p := GetMetricsProvider()
+
+	var curr ManagerStats
+	mgr.StatsCopyTo(&curr)
+
+	mgr.metricsM.Lock()
+	prev := mgr.metricsPrev
+	mgr.metricsPrev = curr
+	mgr.metricsM.Unlock()
+
+	currv := reflect.ValueOf(curr)
+	prevv := reflect.ValueOf(prev)
+	for i := 0; i < currv.NumField(); i++ {
+		c, ok := currv.Field(i).Interface().(uint64)
+		if !ok {
+			continue
+		}
+		pv := prevv.Field(i).Interface().(uint64)
+		if c > pv {
+			p.Counter("cbgt_manager_"+
+				snakeCase(currv.Type().Field(i).Name), nil).Add(c - pv)
+		}
+	}
+
+	feeds, pindexes := mgr.CurrentMaps()
+	p.Gauge("cbgt_manager_pindexes", nil).Set(float64(len(pindexes)))
+
+	for feedName, feed := range feeds {
+		lr, ok := feed.(FeedLagReporter)
+		if !ok {
+			continue
+		}
+		lag, err := lr.Lag()
+		if err != nil {
+			continue // The feed may be (re-)starting.
+		}
+		labels := MetricLabels{"index": feed.IndexName(), "feed": feedName}
+		p.Gauge("cbgt_feed_items_remaining", labels).
+			Set(float64(lag.ItemsRemaining))
+		p.Gauge("cbgt_feed_reconnects", labels).Set(float64(lag.Reconnects))
+		p.Gauge("cbgt_feed_rollbacks", labels).Set(float64(lag.Rollbacks))
+	}
+}
+
+// snakeCase converts a CamelCase name to snake_case.
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,153 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestJSONMetricsProvider(t *testing.T) {
+	p := NewJSONMetricsProvider()
+
+	p.Counter("reqs", MetricLabels{"index": "a"}).Add(2)
+	p.Counter("reqs", MetricLabels{"index": "a"}).Add(3)
+	p.Gauge("pindexes", nil).Set(7)
+	h := p.Histogram("latency", nil)
+	h.Observe(1)
+	h.Observe(3)
+
+	var buf bytes.Buffer
+	if err := p.WriteMetrics(&buf); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("expected json, got: %s, err: %v", buf.String(), err)
+	}
+	if m[`reqs{index="a"}`] != 5.0 || m["pindexes"] != 7.0 {
+		t.Fatalf("unexpected metrics: %s", buf.String())
+	}
+	lat, _ := m["latency"].(map[string]interface{})
+	if lat["count"] != 2.0 || lat["mean"] != 2.0 || lat["max"] != 3.0 {
+		t.Fatalf("unexpected histogram: %s", buf.String())
+	}
+}
+
+func TestPrometheusMetricsProvider(t *testing.T) {
+	p := NewPrometheusMetricsProvider()
+
+	p.Counter("cbgt.reqs", MetricLabels{"index": "a"}).Add(2)
+	p.Counter("cbgt.reqs", MetricLabels{"index": "b"}).Add(1)
+	p.Histogram("cbgt_latency", nil).Observe(0.02)
+
+	var buf bytes.Buffer
+	if err := p.WriteMetrics(&buf); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	out := buf.String()
+
+	for _, exp := range []string{
+		"# TYPE cbgt_reqs counter\n" +
+			"cbgt_reqs{index=\"a\"} 2\n" +
+			"cbgt_reqs{index=\"b\"} 1\n",
+		"# TYPE cbgt_latency histogram\n",
+		"cbgt_latency_bucket{le=\"0.01\"} 0\n",
+		"cbgt_latency_bucket{le=\"0.025\"} 1\n",
+		"cbgt_latency_bucket{le=\"+Inf\"} 1\n",
+		"cbgt_latency_sum 0.02\n",
+		"cbgt_latency_count 1\n",
+	} {
+		if !strings.Contains(out, exp) {
+			t.Fatalf("expected: %q, in: %s", exp, out)
+		}
+	}
+}
+
+func TestManagerPublishMetrics(t *testing.T) {
+	p := NewJSONMetricsProvider()
+	SetMetricsProvider(p)
+	defer SetMetricsProvider(NewJSONMetricsProvider())
+
+	mgr := NewManager(VERSION, nil, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	atomic.AddUint64(&mgr.stats.TotCreateIndex, 3)
+	mgr.PublishMetrics()
+	atomic.AddUint64(&mgr.stats.TotCreateIndex, 2)
+	mgr.PublishMetrics()
+
+	var buf bytes.Buffer
+	p.WriteMetrics(&buf)
+
+	var m map[string]interface{}
+	json.Unmarshal(buf.Bytes(), &m)
+	if m["cbgt_manager_tot_create_index"] != 5.0 ||
+		m["cbgt_manager_pindexes"] != 0.0 {
+		t.Fatalf("unexpected metrics: %s", buf.String())
+	}
+
+	// The no-op provider drops everything.
+	SetMetricsProvider(nil)
+	if _, ok := GetMetricsProvider().(NoopMetricsProvider); !ok {
+		t.Fatalf("expected a no-op provider")
+	}
+	mgr.PublishMetrics()
+}
+
+type testLagFeed struct {
+	*PrimaryFeed
+}
+
+func (t *testLagFeed) Lag() (*FeedLag, error) {
+	return &FeedLag{ItemsRemaining: 42, Reconnects: 2, Rollbacks: 1}, nil
+}
+
+func TestManagerPublishFeedLagMetrics(t *testing.T) {
+	p := NewJSONMetricsProvider()
+	SetMetricsProvider(p)
+	defer SetMetricsProvider(NewJSONMetricsProvider())
+
+	mgr := NewManager(VERSION, nil, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+	mgr.registerFeed(&testLagFeed{NewPrimaryFeed("f0", "i0",
+		BasicPartitionFunc, nil)})
+	mgr.registerFeed(NewPrimaryFeed("f1", "i1", BasicPartitionFunc, nil))
+
+	mgr.PublishMetrics()
+
+	var buf bytes.Buffer
+	p.WriteMetrics(&buf)
+
+	var m map[string]interface{}
+	json.Unmarshal(buf.Bytes(), &m)
+	labels := `{feed="f0",index="i0"}`
+	if m["cbgt_feed_items_remaining"+labels] != 42.0 ||
+		m["cbgt_feed_reconnects"+labels] != 2.0 ||
+		m["cbgt_feed_rollbacks"+labels] != 1.0 ||
+		m[`cbgt_feed_items_remaining{feed="f1",index="i1"}`] != nil {
+		t.Fatalf("unexpected metrics: %s", buf.String())
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for in, exp := range map[string]string{
+		"TotCreateIndex":   "tot_create_index",
+		"TotPIndexesReady": "tot_p_indexes_ready",
+		"TotCfgSetErr":     "tot_cfg_set_err",
+	} {
+		if got := snakeCase(in); got != exp {
+			t.Fatalf("in: %s, expected: %s, got: %s", in, exp, got)
+		}
+	}
+}
@@ -138,28 +138,14 @@ func NewUUIDV4() string {
 	return string(buf[:])
 }
 
+// RetryOnCASMismatch invokes the task until it returns something other
+// than a CfgCASError, for up to retrycount tries, where a retrycount
+// <= 0 means unlimited tries.  See CfgRetryOnCAS.
 func RetryOnCASMismatch(task func() error, retrycount int) error {
-	var err error
-
-	tries := 0
-	for {
-		tries += 1
-		// A negative retry count indicates infinite retries.
-		if retrycount > 0 && tries > retrycount {
-			return NewInternalServerError("RetryOnCASMismatch: too many tries")
-		}
-
-		err = task()
-		if err != nil {
-			if _, ok := err.(*CfgCASError); ok {
-				log.Printf("misc: retrying due to cas mismatch")
-				continue
-			}
-			return err
-		}
-		break
+	err := CfgRetryOnCAS("misc", retrycount, task)
+	if _, ok := err.(*CfgCASError); ok {
+		return NewInternalServerError("RetryOnCASMismatch: too many tries")
 	}
-
 	return err
 }
 
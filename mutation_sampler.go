@@ -0,0 +1,268 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The mutation samplers tap the mutations that the feeds deliver to a
+// pindex's Dest, so that questions like "why isn't this document
+// indexed" can be answered on a live node without a debugger.  As the
+// samples may expose document keys and values, the samplers have to be
+// enabled with the MUTATION_SAMPLING_OPTION manager option.
+
+// MUTATION_SAMPLING_OPTION is the manager option that, when "true",
+// allows the mutation samplers to be started.
+const MUTATION_SAMPLING_OPTION = "enableMutationSampling"
+
+// MutationSamplerMaxDuration is the longest that a mutation sampler
+// may run, which is also its default duration.
+var MutationSamplerMaxDuration = 5 * time.Minute
+
+// MutationSamplerMaxValueBytes is the most bytes of a value that a
+// sample includes, when the values are requested.
+var MutationSamplerMaxValueBytes = 4096
+
+// MutationSamplerBufferSize is the number of samples that are buffered
+// for a slow reader, after which the samples are dropped rather than
+// slowing down the feed.
+var MutationSamplerBufferSize = 1000
+
+// ErrMutationSamplingDisabled is returned when a mutation sampler is
+// started without the MUTATION_SAMPLING_OPTION.
+var ErrMutationSamplingDisabled = fmt.Errorf("mutation sampling disabled")
+
+// A MutationSample is a mutation that a feed delivered to a pindex.
+type MutationSample struct {
+	Time      time.Time `json:"time"`
+	PIndex    string    `json:"pindex"`
+	Partition string    `json:"partition"`
+	Key       string    `json:"key"`
+	Seq       uint64    `json:"seq"`
+	Cas       uint64    `json:"cas"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	ValueLen  int       `json:"valueLen"`
+	Value     []byte    `json:"value,omitempty"` // Possibly truncated.
+
+	// Err is the error that the Dest returned for the mutation.
+	Err string `json:"err,omitempty"`
+}
+
+// MutationSamplerOptions select the mutations that are sampled.
+type MutationSamplerOptions struct {
+	// Rate is the fraction, from 0 to 1, of the matching mutations
+	// that are sampled, where 0 means all of them.
+	Rate float64
+
+	// Optional, the key prefix and partition of the sampled mutations.
+	KeyPrefix string
+	Partition string
+
+	// IncludeValues includes the (truncated) values in the samples.
+	IncludeValues bool
+
+	// Duration is how long the sampler runs, which is capped by, and
+	// defaults to, the MutationSamplerMaxDuration.
+	Duration time.Duration
+
+	// MaxSamples stops the sampler after that many samples, when
+	// positive.
+	MaxSamples int
+}
+
+// A MutationSampler delivers the samples of a pindex's mutations,
+// until it's stopped or it runs out its duration or samples.
+type MutationSampler struct {
+	pindex  string
+	dest    Dest
+	options MutationSamplerOptions
+
+	samplesCh chan *MutationSample
+
+	m        sync.Mutex // Protects the fields that follow.
+	samples  int
+	dropped  uint64
+	stopped  bool
+	stopTime *time.Timer
+}
+
+// The active mutation samplers, keyed by the Dest that they tap.
+var mutationSamplersM sync.RWMutex
+var mutationSamplers = map[Dest][]*MutationSampler{}
+
+// mutationSamplersActive is the number of active mutation samplers, so
+// that the feeds skip the samplers cheaply when there are none.
+var mutationSamplersActive int32
+
+// StartMutationSampler starts sampling the mutations of a local
+// pindex, whose samples are read from the sampler's Samples().
+func (mgr *Manager) StartMutationSampler(pindexName string,
+	options MutationSamplerOptions) (*MutationSampler, error) {
+	if mgr.GetOption(MUTATION_SAMPLING_OPTION) != "true" {
+		return nil, ErrMutationSamplingDisabled
+	}
+
+	if options.Rate < 0 || options.Rate > 1 {
+		return nil, fmt.Errorf("mutation_sampler: invalid rate: %v",
+			options.Rate)
+	}
+
+	if options.Duration <= 0 || options.Duration > MutationSamplerMaxDuration {
+		options.Duration = MutationSamplerMaxDuration
+	}
+
+	_, pindexes := mgr.CurrentMaps()
+	pindex := pindexes[pindexName]
+	if pindex == nil || pindex.Dest == nil {
+		return nil, fmt.Errorf("mutation_sampler: no pindex: %s", pindexName)
+	}
+
+	s := &MutationSampler{
+		pindex:    pindexName,
+		dest:      pindex.Dest,
+		options:   options,
+		samplesCh: make(chan *MutationSample, MutationSamplerBufferSize),
+	}
+
+	mutationSamplersM.Lock()
+	mutationSamplers[s.dest] = append(mutationSamplers[s.dest], s)
+	atomic.AddInt32(&mutationSamplersActive, 1)
+	mutationSamplersM.Unlock()
+
+	s.m.Lock()
+	s.stopTime = time.AfterFunc(options.Duration, s.Stop)
+	s.m.Unlock()
+
+	return s, nil
+}
+
+// Samples returns the channel of the samples, which is closed when the
+// sampler stops.
+func (s *MutationSampler) Samples() <-chan *MutationSample {
+	return s.samplesCh
+}
+
+// Dropped returns the number of samples that were dropped, as they
+// weren't read quickly enough.
+func (s *MutationSampler) Dropped() uint64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.dropped
+}
+
+// Stop stops the sampler, which is idempotent.
+func (s *MutationSampler) Stop() {
+	mutationSamplersM.Lock()
+	samplers := mutationSamplers[s.dest]
+	for i, x := range samplers {
+		if x == s {
+			samplers = append(samplers[:i:i], samplers[i+1:]...)
+			atomic.AddInt32(&mutationSamplersActive, -1)
+			break
+		}
+	}
+	if len(samplers) > 0 {
+		mutationSamplers[s.dest] = samplers
+	} else {
+		delete(mutationSamplers, s.dest)
+	}
+	mutationSamplersM.Unlock()
+
+	s.m.Lock()
+	if !s.stopped {
+		s.stopped = true
+		if s.stopTime != nil {
+			s.stopTime.Stop()
+		}
+		close(s.samplesCh)
+	}
+	s.m.Unlock()
+}
+
+// offer delivers a mutation to the sampler, if it's selected, returning
+// true when the sampler ran out of samples and should be stopped.
+func (s *MutationSampler) offer(partition string, key []byte, seq uint64,
+	val []byte, cas uint64, deleted bool, err error) bool {
+	if s.options.Partition != "" && s.options.Partition != partition {
+		return false
+	}
+	if s.options.KeyPrefix != "" &&
+		!strings.HasPrefix(string(key), s.options.KeyPrefix) {
+		return false
+	}
+	if s.options.Rate > 0 && s.options.Rate < 1 &&
+		rand.Float64() >= s.options.Rate {
+		return false
+	}
+
+	sample := &MutationSample{
+		Time:      time.Now(),
+		PIndex:    s.pindex,
+		Partition: partition,
+		Key:       string(key),
+		Seq:       seq,
+		Cas:       cas,
+		Deleted:   deleted,
+		ValueLen:  len(val),
+	}
+	if s.options.IncludeValues && len(val) > 0 {
+		n := len(val)
+		if n > MutationSamplerMaxValueBytes {
+			n = MutationSamplerMaxValueBytes
+		}
+		sample.Value = append([]byte(nil), val[:n]...)
+	}
+	if err != nil {
+		sample.Err = err.Error()
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.stopped ||
+		(s.options.MaxSamples > 0 && s.samples >= s.options.MaxSamples) {
+		return false
+	}
+
+	select {
+	case s.samplesCh <- sample:
+		s.samples++
+	default:
+		s.dropped++
+	}
+
+	return s.options.MaxSamples > 0 && s.samples >= s.options.MaxSamples
+}
+
+// sampleMutation offers a mutation, which a feed delivered to the dest
+// along with the dest's err, to the dest's mutation samplers.  The
+// feeds invoke it after each DataUpdate and DataDelete.
+func sampleMutation(dest Dest, partition string, key []byte, seq uint64,
+	val []byte, cas uint64, deleted bool, err error) {
+	if atomic.LoadInt32(&mutationSamplersActive) <= 0 {
+		return
+	}
+
+	mutationSamplersM.RLock()
+	samplers := mutationSamplers[dest]
+	mutationSamplersM.RUnlock()
+
+	for _, s := range samplers {
+		if s.offer(partition, key, seq, val, cas, deleted, err) {
+			go s.Stop()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"testing"
+)
+
+func TestMutationSampler(t *testing.T) {
+	cfg := NewCfgMem()
+	mgr := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	dest := &TestDest{}
+	mgr.registerPIndex(&PIndex{Name: "p0", IndexName: "i0", Dest: dest})
+
+	_, err := mgr.StartMutationSampler("p0", MutationSamplerOptions{})
+	if err != ErrMutationSamplingDisabled {
+		t.Fatalf("expected disabled, err: %v", err)
+	}
+
+	mgr.options = map[string]string{MUTATION_SAMPLING_OPTION: "true"}
+
+	_, err = mgr.StartMutationSampler("not-a-pindex", MutationSamplerOptions{})
+	if err == nil {
+		t.Fatalf("expected err on unknown pindex")
+	}
+
+	_, err = mgr.StartMutationSampler("p0", MutationSamplerOptions{Rate: 2})
+	if err == nil {
+		t.Fatalf("expected err on invalid rate")
+	}
+
+	s, err := mgr.StartMutationSampler("p0", MutationSamplerOptions{
+		KeyPrefix:     "a",
+		IncludeValues: true,
+		MaxSamples:    2,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	feed := NewPrimaryFeed("f0", "i0", BasicPartitionFunc,
+		map[string]Dest{"": dest})
+
+	feed.DataUpdate("0", []byte("b1"), 1, []byte("{}"), 0, DEST_EXTRAS_TYPE_NIL, nil)
+	feed.DataUpdate("0", []byte("a1"), 2, []byte("{}"), 0, DEST_EXTRAS_TYPE_NIL, nil)
+	feed.DataDelete("0", []byte("a2"), 3, 0, DEST_EXTRAS_TYPE_NIL, nil)
+	feed.DataUpdate("0", []byte("a3"), 4, []byte("{}"), 0, DEST_EXTRAS_TYPE_NIL, nil)
+
+	var samples []*MutationSample
+	for sample := range s.Samples() {
+		samples = append(samples, sample)
+	}
+
+	if len(samples) != 2 {
+		t.Fatalf("expected 2 samples, got: %d", len(samples))
+	}
+	if samples[0].Key != "a1" || samples[0].Seq != 2 ||
+		string(samples[0].Value) != "{}" || samples[0].Deleted {
+		t.Errorf("unexpected sample: %+v", samples[0])
+	}
+	if samples[1].Key != "a2" || !samples[1].Deleted {
+		t.Errorf("unexpected sample: %+v", samples[1])
+	}
+
+	s.Stop() // Idempotent.
+
+	if mutationSamplersActive != 0 || len(mutationSamplers) != 0 {
+		t.Errorf("expected no active samplers")
+	}
+}
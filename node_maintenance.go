@@ -0,0 +1,132 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/couchbase/clog"
+)
+
+// A node in maintenance mode (e.g., for an OS patching window) stays
+// in the cluster and keeps its pindexes, but the planner doesn't
+// assign the pindexes of new indexes to it and the query
+// scatter-gather prefers the other nodes for the pindexes that it
+// shares with them.  See NodeDef.Maintenance.
+
+// nodeMaintenancePriorityPenalty is added to the plan priority of the
+// nodes in maintenance mode when choosing the node that serves a
+// pindex, so that they're only chosen when no other node will do.
+const nodeMaintenancePriorityPenalty = 1 << 20
+
+// NodesInMaintenance returns the sorted UUIDs of the nodes that are in
+// maintenance mode.
+func NodesInMaintenance(nodeDefs *NodeDefs) []string {
+	if nodeDefs == nil {
+		return nil
+	}
+
+	var rv []string
+	for nodeUUID, nodeDef := range nodeDefs.NodeDefs {
+		if nodeDef != nil && nodeDef.Maintenance {
+			rv = append(rv, nodeUUID)
+		}
+	}
+	sort.Strings(rv)
+
+	return rv
+}
+
+// CfgSetNodeMaintenance sets or clears the maintenance mode of the
+// wanted node with the given UUID.
+func CfgSetNodeMaintenance(cfg Cfg, nodeUUID string,
+	maintenance bool) error {
+	for {
+		nodeDefs, cas, err := CfgGetNodeDefs(cfg, NODE_DEFS_WANTED)
+		if err != nil {
+			return err
+		}
+
+		var nodeDef *NodeDef
+		if nodeDefs != nil {
+			nodeDef = nodeDefs.NodeDefs[nodeUUID]
+		}
+		if nodeDef == nil {
+			return fmt.Errorf("node_maintenance: no wanted node: %s",
+				nodeUUID)
+		}
+
+		if nodeDef.Maintenance == maintenance {
+			return nil
+		}
+
+		nodeDef.Maintenance = maintenance
+		nodeDefs.UUID = NewUUID()
+
+		_, err = CfgSetNodeDefs(cfg, NODE_DEFS_WANTED, nodeDefs, cas)
+		if err != nil {
+			if _, ok := err.(*CfgCASError); ok {
+				continue
+			}
+			return err
+		}
+
+		return nil
+	}
+}
+
+// SetNodeMaintenance sets or clears the maintenance mode of a node,
+// where a nodeUUID of "" means this node.
+func (mgr *Manager) SetNodeMaintenance(nodeUUID string,
+	maintenance bool) error {
+	if nodeUUID == "" {
+		nodeUUID = mgr.uuid
+	}
+
+	err := CfgSetNodeMaintenance(mgr.cfg, nodeUUID, maintenance)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("node_maintenance: node: %s, maintenance: %t",
+		nodeUUID, maintenance)
+
+	// Refresh the cached nodeDefs, so that queries see the change.
+	_, err = mgr.GetNodeDefs(NODE_DEFS_WANTED, true)
+
+	return err
+}
+
+// maintenanceNodesForNewIndex returns the nodes that the pindexes of
+// an index can be assigned to, which leaves out the nodes in
+// maintenance mode when the index is new, unless that would leave no
+// nodes at all.
+func maintenanceNodesForNewIndex(indexDef *IndexDef,
+	planPIndexesPrev *PlanPIndexes, nodeUUIDs []string,
+	nodesInMaintenance []string) []string {
+	if len(nodesInMaintenance) == 0 {
+		return nodeUUIDs
+	}
+
+	if planPIndexesPrev != nil {
+		for _, planPIndex := range planPIndexesPrev.PlanPIndexes {
+			if planPIndex.IndexName == indexDef.Name {
+				return nodeUUIDs // The index isn't new.
+			}
+		}
+	}
+
+	rv := StringsRemoveStrings(nodeUUIDs, nodesInMaintenance)
+	if len(rv) == 0 {
+		return nodeUUIDs
+	}
+
+	return rv
+}
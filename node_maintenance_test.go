@@ -0,0 +1,92 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNodeMaintenance(t *testing.T) {
+	emptyDir, _ := os.MkdirTemp("./tmp", "test")
+	defer os.RemoveAll(emptyDir)
+
+	cfg := NewCfgMem()
+
+	if err := CfgSetNodeMaintenance(cfg, "missing", true); err == nil {
+		t.Fatalf("expected an err for a missing node")
+	}
+
+	m := NewManager(VERSION, cfg, NewUUID(), nil, "", 1, "", ":1000",
+		emptyDir, "some-datasource", nil)
+	if err := m.Start("wanted"); err != nil {
+		t.Fatalf("expected Manager.Start() to work, err: %v", err)
+	}
+	defer m.Stop()
+
+	if err := m.SetNodeMaintenance("", true); err != nil {
+		t.Fatalf("expected SetNodeMaintenance() to work, err: %v", err)
+	}
+
+	nodeDefs, _, err := CfgGetNodeDefs(cfg, NODE_DEFS_WANTED)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if exp := []string{m.UUID()}; !reflect.DeepEqual(NodesInMaintenance(nodeDefs), exp) {
+		t.Fatalf("expected: %v, got: %v", exp, NodesInMaintenance(nodeDefs))
+	}
+
+	// The maintenance mode outlives a re-registration of the node.
+	if err = m.SaveNodeDef(NODE_DEFS_WANTED, true); err != nil {
+		t.Fatalf("expected SaveNodeDef() to work, err: %v", err)
+	}
+	nodeDefs, _, _ = CfgGetNodeDefs(cfg, NODE_DEFS_WANTED)
+	if !nodeDefs.NodeDefs[m.UUID()].Maintenance {
+		t.Fatalf("expected the node to stay in maintenance mode")
+	}
+
+	if err = m.SetNodeMaintenance(m.UUID(), false); err != nil {
+		t.Fatalf("expected SetNodeMaintenance() to work, err: %v", err)
+	}
+	nodeDefs, _, _ = CfgGetNodeDefs(cfg, NODE_DEFS_WANTED)
+	if len(NodesInMaintenance(nodeDefs)) != 0 {
+		t.Fatalf("expected no nodes in maintenance mode")
+	}
+}
+
+func TestMaintenanceNodesForNewIndex(t *testing.T) {
+	indexDef := &IndexDef{Name: "idx"}
+	nodeUUIDs := []string{"a", "b", "c"}
+
+	rv := maintenanceNodesForNewIndex(indexDef, nil, nodeUUIDs, nil)
+	if !reflect.DeepEqual(rv, nodeUUIDs) {
+		t.Fatalf("expected all nodes, got: %v", rv)
+	}
+
+	rv = maintenanceNodesForNewIndex(indexDef, nil, nodeUUIDs, []string{"b"})
+	if exp := []string{"a", "c"}; !reflect.DeepEqual(rv, exp) {
+		t.Fatalf("expected: %v, got: %v", exp, rv)
+	}
+
+	// All nodes in maintenance mode falls back to all nodes.
+	rv = maintenanceNodesForNewIndex(indexDef, nil, nodeUUIDs, nodeUUIDs)
+	if !reflect.DeepEqual(rv, nodeUUIDs) {
+		t.Fatalf("expected all nodes, got: %v", rv)
+	}
+
+	// An existing index keeps its nodes.
+	planPIndexesPrev := NewPlanPIndexes(VERSION)
+	planPIndexesPrev.PlanPIndexes["p0"] = &PlanPIndex{Name: "p0", IndexName: "idx"}
+	rv = maintenanceNodesForNewIndex(indexDef, planPIndexesPrev, nodeUUIDs,
+		[]string{"b"})
+	if !reflect.DeepEqual(rv, nodeUUIDs) {
+		t.Fatalf("expected all nodes, got: %v", rv)
+	}
+}
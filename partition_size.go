@@ -0,0 +1,430 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// The partition size checks track the on-disk size of the pindexes, so
+// that an index whose partitions grow beyond the configured maximum is
+// flagged, and optionally split into more partitions, before its
+// partitions become unmanageably large to move, rebuild or compact.
+
+// MAX_PARTITION_SIZE_OPTION is the manager option that holds the
+// maximum on-disk size, in bytes, of a pindex, where 0 or empty
+// disables the partition size checks.
+const MAX_PARTITION_SIZE_OPTION = "maxPartitionSizeBytes"
+
+// PARTITION_SIZE_CHECK_OPTION is the manager option that holds the
+// number of seconds between the partition size checks, where a
+// negative value disables the periodic checks.
+const PARTITION_SIZE_CHECK_OPTION = "partitionSizeCheckIntervalSecs"
+
+// AUTO_SPLIT_PARTITIONS_OPTION is the manager option that, when
+// "true", has the partition size checks split the flagged indexes into
+// their recommended number of partitions.
+const AUTO_SPLIT_PARTITIONS_OPTION = "autoSplitPartitions"
+
+// PartitionSizeCheckInterval is the default time between the partition
+// size checks.
+var PartitionSizeCheckInterval = 5 * time.Minute
+
+// OversizedPartitionEventID is the system event ID of an index whose
+// partitions were flagged as oversized.
+const OversizedPartitionEventID uint32 = 3078
+
+// An OversizedIndex is an index that has pindexes whose on-disk size
+// exceeds the maximum partition size.
+type OversizedIndex struct {
+	IndexName string `json:"indexName"`
+	IndexUUID string `json:"indexUUID"`
+
+	MaxPartitionSize int64 `json:"maxPartitionSize"`
+	TotalSize        int64 `json:"totalSize"`
+
+	// OversizedPIndexes are the sizes of the oversized pindexes, keyed
+	// by pindex name.
+	OversizedPIndexes map[string]int64 `json:"oversizedPIndexes"`
+
+	Partitions            int `json:"partitions"`
+	RecommendedPartitions int `json:"recommendedPartitions"`
+
+	// SplitScheduled is true when the index was split into its
+	// recommended number of partitions, see the autoSplitPartitions
+	// option.
+	SplitScheduled bool `json:"splitScheduled,omitempty"`
+
+	FlaggedAt time.Time `json:"flaggedAt"`
+}
+
+// SplitIndexPartitionsHook is the pluggable callback that splits an
+// index into the given number of partitions, when the
+// autoSplitPartitions option is enabled.  The default implementation
+// replans the index with the new number of partitions, see
+// Manager.SplitIndexPartitions, which rebuilds its pindexes.
+var SplitIndexPartitionsHook = func(mgr *Manager, indexName,
+	indexUUID string, indexPartitions int) error {
+	return mgr.SplitIndexPartitions(indexName, indexUUID, indexPartitions)
+}
+
+// CalcRecommendedIndexPartitions returns the number of partitions that
+// an index should be split into so that none of its partitions exceed
+// the maxPartitionSize, given the current number of partitions, the
+// largest and total size of its partitions, and the number of source
+// partitions, which bounds the number of partitions when positive.
+func CalcRecommendedIndexPartitions(partitions int, largest, total,
+	maxPartitionSize int64, sourcePartitions int) int {
+	if partitions < 1 {
+		partitions = 1
+	}
+	if maxPartitionSize <= 0 {
+		return partitions
+	}
+
+	// The source partitions are grouped evenly, so the largest
+	// partition has to be split at least by its excess factor.
+	factor := int(math.Ceil(float64(largest) / float64(maxPartitionSize)))
+	if factor < 1 {
+		factor = 1
+	}
+
+	rv := partitions * factor
+	if n := int(math.Ceil(float64(total) / float64(maxPartitionSize))); n > rv {
+		rv = n
+	}
+	if sourcePartitions > 0 && rv > sourcePartitions {
+		rv = sourcePartitions
+	}
+	if rv < partitions {
+		rv = partitions
+	}
+
+	return rv
+}
+
+// maxPartitionSize returns the maximum on-disk size of a pindex, which
+// is 0 when the partition size checks are disabled.
+func (mgr *Manager) maxPartitionSize() int64 {
+	v := mgr.GetOption(MAX_PARTITION_SIZE_OPTION)
+	if v == "" {
+		return 0
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		log.Warnf("partition_size: option: %s, v: %q, err: %v",
+			MAX_PARTITION_SIZE_OPTION, v, err)
+		return 0
+	}
+
+	return n
+}
+
+// CheckPartitionSizes flags the indexes whose pindexes exceed the
+// maximum partition size, and splits them when the autoSplitPartitions
+// option is enabled.  A system event is published when an index is
+// first flagged.  The indexes that are flagged are returned, sorted by
+// name.
+func (mgr *Manager) CheckPartitionSizes() ([]*OversizedIndex, error) {
+	maxSize := mgr.maxPartitionSize()
+	if maxSize <= 0 || PIndexDiskSizeHook == nil || mgr.cfg == nil {
+		mgr.partitionSizesM.Lock()
+		mgr.oversizedIndexes = nil
+		mgr.partitionSizesM.Unlock()
+		return nil, nil
+	}
+
+	indexDefs, _, err := CfgGetIndexDefs(mgr.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	planPIndexes, _, err := CfgGetPlanPIndexes(mgr.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if indexDefs == nil || planPIndexes == nil {
+		mgr.partitionSizesM.Lock()
+		mgr.oversizedIndexes = nil
+		mgr.partitionSizesM.Unlock()
+		return nil, nil
+	}
+
+	type indexSizes struct {
+		partitions       int
+		sourcePartitions int
+		largest          int64
+		total            int64
+		oversized        map[string]int64
+	}
+
+	sizes := map[string]*indexSizes{}
+
+	for _, planPIndex := range planPIndexes.PlanPIndexes {
+		indexDef := indexDefs.IndexDefs[planPIndex.IndexName]
+		if indexDef == nil || indexDef.UUID != planPIndex.IndexUUID {
+			continue // The pindex is being replanned.
+		}
+
+		s := sizes[planPIndex.IndexName]
+		if s == nil {
+			s = &indexSizes{oversized: map[string]int64{}}
+			sizes[planPIndex.IndexName] = s
+		}
+
+		s.partitions++
+		if planPIndex.SourcePartitions != "" {
+			s.sourcePartitions +=
+				len(strings.Split(planPIndex.SourcePartitions, ","))
+		}
+
+		size, err := PIndexDiskSizeHook(planPIndex)
+		if err != nil {
+			log.Warnf("partition_size: pindex: %s, err: %v",
+				planPIndex.Name, err)
+			continue
+		}
+
+		s.total += size
+		if size > s.largest {
+			s.largest = size
+		}
+		if size > maxSize {
+			s.oversized[planPIndex.Name] = size
+		}
+	}
+
+	autoSplit := mgr.GetOption(AUTO_SPLIT_PARTITIONS_OPTION) == "true"
+
+	mgr.partitionSizesM.Lock()
+	prev := mgr.oversizedIndexes
+	mgr.partitionSizesM.Unlock()
+
+	curr := map[string]*OversizedIndex{}
+
+	for indexName, s := range sizes {
+		if len(s.oversized) == 0 {
+			continue
+		}
+
+		indexDef := indexDefs.IndexDefs[indexName]
+
+		oi := &OversizedIndex{
+			IndexName:         indexName,
+			IndexUUID:         indexDef.UUID,
+			MaxPartitionSize:  maxSize,
+			TotalSize:         s.total,
+			OversizedPIndexes: s.oversized,
+			Partitions:        s.partitions,
+			RecommendedPartitions: CalcRecommendedIndexPartitions(
+				s.partitions, s.largest, s.total, maxSize, s.sourcePartitions),
+			FlaggedAt: time.Now(),
+		}
+
+		if max := indexDef.PlanParams.MaxIndexPartitions; max > 0 &&
+			oi.RecommendedPartitions > max {
+			oi.RecommendedPartitions = max
+			if oi.RecommendedPartitions < oi.Partitions {
+				oi.RecommendedPartitions = oi.Partitions
+			}
+		}
+
+		p := prev[indexName]
+		if p != nil && p.IndexUUID == oi.IndexUUID {
+			oi.FlaggedAt = p.FlaggedAt
+			oi.SplitScheduled = p.SplitScheduled
+		} else {
+			publishOversizedIndexEvent(oi)
+		}
+
+		if autoSplit && !oi.SplitScheduled &&
+			oi.RecommendedPartitions > oi.Partitions &&
+			SplitIndexPartitionsHook != nil {
+			err = SplitIndexPartitionsHook(mgr, indexName, oi.IndexUUID,
+				oi.RecommendedPartitions)
+			if err != nil {
+				log.Warnf("partition_size: split, indexName: %s,"+
+					" indexPartitions: %d, err: %v",
+					indexName, oi.RecommendedPartitions, err)
+			} else {
+				log.Printf("partition_size: split, indexName: %s,"+
+					" indexPartitions: %d -> %d",
+					indexName, oi.Partitions, oi.RecommendedPartitions)
+				oi.SplitScheduled = true
+			}
+		}
+
+		curr[indexName] = oi
+	}
+
+	mgr.partitionSizesM.Lock()
+	mgr.oversizedIndexes = curr
+	mgr.partitionSizesM.Unlock()
+
+	return sortedOversizedIndexes(curr), nil
+}
+
+// OversizedIndexes returns the indexes that were flagged by the last
+// partition size check, sorted by name.
+func (mgr *Manager) OversizedIndexes() []*OversizedIndex {
+	mgr.partitionSizesM.Lock()
+	defer mgr.partitionSizesM.Unlock()
+
+	return sortedOversizedIndexes(mgr.oversizedIndexes)
+}
+
+func sortedOversizedIndexes(m map[string]*OversizedIndex) []*OversizedIndex {
+	rv := make([]*OversizedIndex, 0, len(m))
+	for _, oi := range m {
+		c := *oi
+		rv = append(rv, &c)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].IndexName < rv[j].IndexName
+	})
+
+	return rv
+}
+
+func publishOversizedIndexEvent(oi *OversizedIndex) {
+	ev := NewSystemEvent(OversizedPartitionEventID, "warn",
+		"Index partitions exceed the maximum partition size",
+		map[string]interface{}{
+			"indexName":             oi.IndexName,
+			"indexUUID":             oi.IndexUUID,
+			"maxPartitionSize":      oi.MaxPartitionSize,
+			"oversizedPIndexes":     oi.OversizedPIndexes,
+			"partitions":            oi.Partitions,
+			"recommendedPartitions": oi.RecommendedPartitions,
+		})
+	if ev == nil {
+		return // The system event listener isn't started.
+	}
+
+	err := PublishSystemEvent(ev)
+	if err != nil {
+		log.Warnf("partition_size: publish event, indexName: %s, err: %v",
+			oi.IndexName, err)
+	}
+}
+
+// SplitIndexPartitions replans an index with the given number of
+// partitions, which changes the index's UUID and so rebuilds its
+// pindexes.  An indexUUID of "" matches any index UUID.
+func (mgr *Manager) SplitIndexPartitions(indexName, indexUUID string,
+	indexPartitions int) error {
+	if indexPartitions < 1 {
+		return NewBadRequestError("manager_api: SplitIndexPartitions,"+
+			" invalid indexPartitions: %d", indexPartitions)
+	}
+
+	err := RetryOnCASMismatch(func() error {
+		indexDefs, cas, err := CfgGetIndexDefs(mgr.cfg)
+		if err != nil {
+			return err
+		}
+		if indexDefs == nil {
+			return fmt.Errorf("manager_api: no indexes,"+
+				" split, indexName: %s", indexName)
+		}
+		if VersionGTE(mgr.version, indexDefs.ImplVersion) == false {
+			return fmt.Errorf("manager_api: split,"+
+				" indexName: %s,"+
+				" indexDefs.ImplVersion: %s > mgr.version: %s",
+				indexName, indexDefs.ImplVersion, mgr.version)
+		}
+		indexDef, exists := indexDefs.IndexDefs[indexName]
+		if !exists || indexDef == nil {
+			return fmt.Errorf("manager_api: no index to split,"+
+				" indexName: %s", indexName)
+		}
+		if indexUUID != "" && indexDef.UUID != indexUUID {
+			return fmt.Errorf("manager_api: index.UUID mismatched")
+		}
+
+		indexDef.PlanParams.IndexPartitions = indexPartitions
+		indexDef.PlanParams.MaxPartitionsPerPIndex = 0
+
+		// Keep an automatically sized index from being sized back down.
+		if indexDef.PlanParams.MinIndexPartitions > 0 ||
+			indexDef.PlanParams.MaxIndexPartitions > 0 {
+			if indexDef.PlanParams.MinIndexPartitions < indexPartitions {
+				indexDef.PlanParams.MinIndexPartitions = indexPartitions
+			}
+		}
+
+		// refresh the UUID as we are updating the indexDef
+		newIndexUUID := NewUUID()
+		indexDef.UUID = newIndexUUID
+		indexDefs.UUID = newIndexUUID
+
+		_, err = CfgSetIndexDefs(mgr.cfg, indexDefs, cas)
+		return err
+	}, 100)
+	if err != nil {
+		return fmt.Errorf("manager_api: could not save indexDefs,"+
+			" err: %v", err)
+	}
+
+	mgr.GetIndexDefs(true)
+
+	return nil
+}
+
+// partitionSizeCheckInterval returns the time between the partition
+// size checks, which is negative when they're disabled.
+func (mgr *Manager) partitionSizeCheckInterval() time.Duration {
+	v := mgr.GetOption(PARTITION_SIZE_CHECK_OPTION)
+	if v == "" {
+		return PartitionSizeCheckInterval
+	}
+
+	secs, err := strconv.Atoi(v)
+	if err != nil {
+		log.Warnf("partition_size: option: %s, err: %v",
+			PARTITION_SIZE_CHECK_OPTION, err)
+		return PartitionSizeCheckInterval
+	}
+	if secs == 0 {
+		return PartitionSizeCheckInterval
+	}
+
+	return time.Duration(secs) * time.Second
+}
+
+// PartitionSizeLoop periodically checks the partition sizes.
+func (mgr *Manager) PartitionSizeLoop() {
+	for {
+		interval := mgr.partitionSizeCheckInterval()
+		if interval > 0 && mgr.cfg != nil {
+			_, err := mgr.CheckPartitionSizes()
+			if err != nil {
+				log.Warnf("partition_size: check, err: %v", err)
+			}
+		} else {
+			// Check again later whether the checks were enabled.
+			interval = PartitionSizeCheckInterval
+		}
+
+		select {
+		case <-mgr.stopCh:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
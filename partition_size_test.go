@@ -0,0 +1,127 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"testing"
+)
+
+func TestCalcRecommendedIndexPartitions(t *testing.T) {
+	tests := []struct {
+		partitions       int
+		largest, total   int64
+		maxSize          int64
+		sourcePartitions int
+		expected         int
+	}{
+		{1, 50, 50, 100, 1024, 1},
+		{1, 250, 250, 100, 1024, 3},
+		{4, 150, 400, 100, 1024, 8},
+		{4, 150, 900, 100, 1024, 9},
+		{4, 500, 900, 100, 6, 6},
+		{4, 500, 900, 100, 2, 4},
+		{4, 500, 900, 0, 1024, 4},
+	}
+
+	for i, test := range tests {
+		got := CalcRecommendedIndexPartitions(test.partitions,
+			test.largest, test.total, test.maxSize, test.sourcePartitions)
+		if got != test.expected {
+			t.Errorf("i: %d, test: %+v, got: %d", i, test, got)
+		}
+	}
+}
+
+func TestCheckPartitionSizes(t *testing.T) {
+	cfg := NewCfgMem()
+
+	indexDefs := NewIndexDefs(VERSION)
+	indexDefs.IndexDefs["i0"] = &IndexDef{
+		Name: "i0", UUID: "u0",
+		PlanParams: PlanParams{IndexPartitions: 2},
+	}
+	indexDefs.IndexDefs["i1"] = &IndexDef{Name: "i1", UUID: "u1"}
+	_, err := CfgSetIndexDefs(cfg, indexDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	planPIndexes := NewPlanPIndexes(VERSION)
+	for name, p := range map[string]*PlanPIndex{
+		"p0": {IndexName: "i0", IndexUUID: "u0", SourcePartitions: "0,1,2,3"},
+		"p1": {IndexName: "i0", IndexUUID: "u0", SourcePartitions: "4,5,6,7"},
+		"p2": {IndexName: "i1", IndexUUID: "u1", SourcePartitions: "0"},
+	} {
+		p.Name = name
+		planPIndexes.PlanPIndexes[name] = p
+	}
+	_, err = CfgSetPlanPIndexes(cfg, planPIndexes, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	sizes := map[string]int64{"p0": 250, "p1": 50, "p2": 10}
+
+	prevHook := PIndexDiskSizeHook
+	defer func() { PIndexDiskSizeHook = prevHook }()
+	PIndexDiskSizeHook = func(planPIndex *PlanPIndex) (int64, error) {
+		return sizes[planPIndex.Name], nil
+	}
+
+	mgr := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	ois, err := mgr.CheckPartitionSizes()
+	if err != nil || len(ois) != 0 {
+		t.Fatalf("expected no checks without the option, got: %#v, err: %v",
+			ois, err)
+	}
+
+	mgr.options = map[string]string{MAX_PARTITION_SIZE_OPTION: "100"}
+
+	ois, err = mgr.CheckPartitionSizes()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(ois) != 1 || ois[0].IndexName != "i0" ||
+		ois[0].OversizedPIndexes["p0"] != 250 ||
+		ois[0].Partitions != 2 || ois[0].RecommendedPartitions != 6 ||
+		ois[0].SplitScheduled {
+		t.Fatalf("unexpected oversized indexes: %#v", ois)
+	}
+
+	flaggedAt := ois[0].FlaggedAt
+
+	mgr.options = map[string]string{
+		MAX_PARTITION_SIZE_OPTION:    "100",
+		AUTO_SPLIT_PARTITIONS_OPTION: "true",
+	}
+
+	ois, err = mgr.CheckPartitionSizes()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(ois) != 1 || !ois[0].SplitScheduled ||
+		!ois[0].FlaggedAt.Equal(flaggedAt) {
+		t.Fatalf("expected the split to be scheduled, got: %#v", ois)
+	}
+
+	indexDefs, _, _ = CfgGetIndexDefs(cfg)
+	indexDef := indexDefs.IndexDefs["i0"]
+	if indexDef.UUID == "u0" || indexDef.PlanParams.IndexPartitions != 6 {
+		t.Fatalf("expected the index to be replanned, got: %#v", indexDef)
+	}
+
+	// The pindexes of the previous index UUID are being replanned.
+	ois, err = mgr.CheckPartitionSizes()
+	if err != nil || len(ois) != 0 || len(mgr.OversizedIndexes()) != 0 {
+		t.Fatalf("expected no oversized indexes, got: %#v, err: %v",
+			ois, err)
+	}
+}
@@ -133,7 +133,7 @@ func createNewPIndex(mgr *Manager, name, uuid, indexType, indexName, indexUUID,
 
 	rollback := func() {
 		log.Printf("pindex: rollbackPIndex starts for pindex: %s", pindex.Name)
-		go mgr.JanitorRollbackKick("rollback:"+pindex.Name, pindex)
+		mgr.scheduleRollback(pindex)
 	}
 
 	params := IndexPrepParams{SourceName: sourceName, IndexName: indexName,
@@ -235,7 +235,7 @@ func OpenPIndex(mgr *Manager, path string) (pindex *PIndex, err error) {
 
 	rollback := func() {
 		log.Printf("pindex: rollbackPIndex starts for pindex: %s", pindex.Name)
-		go mgr.JanitorRollbackKick("rollback:"+pindex.Name, pindex)
+		mgr.scheduleRollback(pindex)
 	}
 
 	defer func() {
@@ -520,17 +520,23 @@ func (mgr *Manager) ClassifyPIndexes(indexName, indexUUID string,
 			// node does pindexes and it is wanted
 			if nodeDef, ok := nodeDoesPIndexes(nodeUUID); ok &&
 				planPIndexFilter(planPIndexNode) {
-				if planPIndexNode.Priority < lowestNodePriority {
+				priority := planPIndexNode.Priority
+				if nodeDef.Maintenance {
+					// deprioritize the nodes in maintenance mode
+					priority += nodeMaintenancePriorityPenalty
+				}
+
+				if priority < lowestNodePriority {
 					// candidate node has lower priority
 					if !nodeLocal || (nodeLocal && nodeLocalOK) {
 						lowestNode = nodeDef
-						lowestNodePriority = planPIndexNode.Priority
+						lowestNodePriority = priority
 					}
-				} else if planPIndexNode.Priority == lowestNodePriority {
+				} else if priority == lowestNodePriority {
 					if nodeLocal && nodeLocalOK {
 						// same priority, but prefer local nodes
 						lowestNode = nodeDef
-						lowestNodePriority = planPIndexNode.Priority
+						lowestNodePriority = priority
 					}
 				}
 			}
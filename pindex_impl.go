@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -123,6 +124,46 @@ type PIndexImplType struct {
 	// on the index.
 	SubmitTaskRequest func(mgr *Manager, indexName,
 		indexUUID string, req []byte) (*TaskRequestStatus, error)
+
+	// Optional, invoked by the manager when it wants to estimate the
+	// size in bytes of a single copy of an index, before the index is
+	// created, from a sample of its source.
+	EstimateSize func(indexDef *IndexDef, sample *SourceSample) uint64
+
+	// Optional, the upper limits for the opening and the closing of a
+	// pindex of this type, where 0 means PIndexOpenTimeout or
+	// PIndexCloseTimeout, and a negative value means no limit.
+	OpenTimeout  time.Duration
+	CloseTimeout time.Duration
+
+	// Optional, invoked by the manager on the source node of a
+	// partition move to describe the hot in-memory state of a pindex,
+	// such as its recently accessed segments or keys, in a format
+	// that's opaque to cbgt, see Manager.PIndexHotState().
+	HotState func(pindex *PIndex) ([]byte, error)
+
+	// Optional, invoked by the manager on the destination node of a
+	// partition move to pre-warm a pindex from the HotState() of the
+	// source node's copy, before the pindex takes traffic.
+	Warm func(pindex *PIndex, hotState []byte) error
+
+	// Optional, invoked by the manager when it wants to query a cold
+	// index of a paused bucket, whose pindex data is read on demand
+	// with the ColdIndex's Fetch(), see Manager.QueryColdIndex().
+	QueryCold func(mgr *Manager, coldIndex *ColdIndex,
+		req []byte, res io.Writer) error
+
+	// Optional, invoked by the manager's compaction scheduler to
+	// compact a pindex within the compaction window, reporting its
+	// progress from 0.0 to 1.0, and returning early when the stopCh
+	// is closed, see Manager.CompactionLoop().
+	Compact func(pindex *PIndex, stopCh <-chan struct{},
+		progress func(float64)) error
+
+	// Optional, invoked by the manager's memory governor to estimate
+	// the memory in bytes that's used by a pindex, which is checked
+	// against the node's memory quota, see Manager.MemoryGovernor().
+	MemoryEstimate func(pindex *PIndex) (uint64, error)
 }
 
 type Feedable interface {
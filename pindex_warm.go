@@ -0,0 +1,73 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// The warm cache phase of a partition move lets the destination node
+// pre-warm its copy of a pindex from the hot in-memory state of the
+// source node's copy, so that the latencies don't spike once the
+// destination's copy takes traffic.  The hot state is only shipped
+// when the pindex implementation supports both the HotState() and the
+// Warm() of its PIndexImplType.
+
+// ErrPIndexWarmNotSupported is returned when the pindex implementation
+// doesn't support the warm cache phase of partition moves.
+var ErrPIndexWarmNotSupported = errors.New("pindex warm not supported")
+
+// pindexWarmImplType returns the PIndexImplType of a pindex that
+// supports the warm cache phase of partition moves.
+func (mgr *Manager) pindexWarmImplType(pindexName string) (
+	*PIndex, *PIndexImplType, error) {
+	pindex := mgr.GetPIndex(pindexName)
+	if pindex == nil {
+		return nil, nil, fmt.Errorf("pindex_warm: no pindex: %s", pindexName)
+	}
+
+	t := PIndexImplTypes[pindex.IndexType]
+	if t == nil || t.HotState == nil || t.Warm == nil {
+		return nil, nil, ErrPIndexWarmNotSupported
+	}
+
+	return pindex, t, nil
+}
+
+// PIndexHotState returns the description of the hot in-memory state
+// of a local pindex, for shipping to the destination node of a
+// partition move.
+func (mgr *Manager) PIndexHotState(pindexName string) ([]byte, error) {
+	pindex, t, err := mgr.pindexWarmImplType(pindexName)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.HotState(pindex)
+}
+
+// WarmPIndex pre-warms a local pindex from the hot state of another
+// copy of the pindex, see PIndexHotState().
+func (mgr *Manager) WarmPIndex(pindexName string, hotState []byte) error {
+	pindex, t, err := mgr.pindexWarmImplType(pindexName)
+	if err != nil {
+		return err
+	}
+
+	err = t.Warm(pindex, hotState)
+	if err != nil {
+		return err
+	}
+
+	atomic.AddUint64(&mgr.stats.TotWarmPIndex, 1)
+
+	return nil
+}
@@ -0,0 +1,53 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWarmPIndex(t *testing.T) {
+	defer delete(PIndexImplTypes, "testWarm")
+
+	var warmed []byte
+	RegisterPIndexImplType("testWarm", &PIndexImplType{
+		HotState: func(pindex *PIndex) ([]byte, error) {
+			return []byte("hot:" + pindex.Name), nil
+		},
+		Warm: func(pindex *PIndex, hotState []byte) error {
+			warmed = hotState
+			return nil
+		},
+	})
+
+	mgr := &Manager{pindexes: map[string]*PIndex{
+		"p0": {Name: "p0", IndexType: "testWarm"},
+		"p1": {Name: "p1", IndexType: "blackhole"},
+	}}
+
+	hotState, err := mgr.PIndexHotState("p0")
+	if err != nil || string(hotState) != "hot:p0" {
+		t.Fatalf("unexpected hotState: %q, err: %v", hotState, err)
+	}
+
+	if err = mgr.WarmPIndex("p0", hotState); err != nil ||
+		string(warmed) != "hot:p0" || mgr.stats.TotWarmPIndex != 1 {
+		t.Fatalf("expected p0 to be warmed, got: %q, err: %v", warmed, err)
+	}
+
+	if _, err = mgr.PIndexHotState("p1"); !errors.Is(err,
+		ErrPIndexWarmNotSupported) {
+		t.Fatalf("expected an unsupported err, got: %v", err)
+	}
+
+	if err = mgr.WarmPIndex("unknown", nil); err == nil {
+		t.Fatalf("expected an err for an unknown pindex")
+	}
+}
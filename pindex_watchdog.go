@@ -0,0 +1,234 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// PIndexOpenTimeout is the default upper limit for opening a pindex,
+// unless overridden by the PIndexImplType.OpenTimeout, where a
+// negative value means no limit.
+var PIndexOpenTimeout = 10 * time.Minute
+
+// PIndexCloseTimeout is the default upper limit for closing a pindex,
+// unless overridden by the PIndexImplType.CloseTimeout, where a
+// negative value means no limit.
+var PIndexCloseTimeout = 10 * time.Minute
+
+// PIndexOpMaxTimeouts is the number of consecutive open or close
+// timeouts of a pindex after which the pindex is quarantined.
+var PIndexOpMaxTimeouts = 3
+
+// ErrPIndexOpTimeout is returned when the opening or the closing of a
+// pindex takes longer than its timeout.  The operation itself is left
+// running in the background, as it can't be interrupted.
+var ErrPIndexOpTimeout = errors.New("pindex operation timed out")
+
+// pindexOpTimeout returns the timeout of the op ("open" or "close")
+// for a pindex of the indexType.
+func pindexOpTimeout(indexType, op string) time.Duration {
+	rv := PIndexOpenTimeout
+	if op == "close" {
+		rv = PIndexCloseTimeout
+	}
+
+	if t := PIndexImplTypes[indexType]; t != nil {
+		if op == "open" && t.OpenTimeout != 0 {
+			rv = t.OpenTimeout
+		} else if op == "close" && t.CloseTimeout != 0 {
+			rv = t.CloseTimeout
+		}
+	}
+
+	return rv
+}
+
+// runPIndexOp runs f under a watchdog, which, when f does not return
+// within the timeout, logs the goroutine dump of the stuck f and
+// returns ErrPIndexOpTimeout.
+func runPIndexOp(op, pindexName string, timeout time.Duration,
+	f func() error) error {
+	if timeout <= 0 {
+		return f()
+	}
+
+	goroutineCh := make(chan []byte, 1)
+	doneCh := make(chan error, 1)
+
+	go func() {
+		buf := make([]byte, 64)
+		buf = buf[:runtime.Stack(buf, false)]
+		goroutineCh <- buf[:bytes.IndexByte(buf, '[')]
+
+		doneCh <- f()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-doneCh:
+		return err
+
+	case <-timer.C:
+	}
+
+	log.Warnf("pindex_watchdog: %s of pindex: %s did not complete in %v,"+
+		" stuck goroutine:\n%s", op, pindexName, timeout,
+		goroutineDump(<-goroutineCh))
+
+	return fmt.Errorf("pindex_watchdog: %s of pindex: %s, timeout: %v, %w",
+		op, pindexName, timeout, ErrPIndexOpTimeout)
+}
+
+// goroutineDump returns the stack trace of the goroutine whose trace
+// starts with the prefix, like "goroutine 42 ".
+func goroutineDump(prefix []byte) []byte {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+
+	for _, trace := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(trace, prefix) {
+			return trace
+		}
+	}
+
+	return nil
+}
+
+// notePIndexOp tracks the consecutive timeouts of the ops of a pindex
+// and returns true when the pindex should be quarantined.
+func (mgr *Manager) notePIndexOp(pindexName string, err error) bool {
+	mgr.pindexOpTimeoutsM.Lock()
+	defer mgr.pindexOpTimeoutsM.Unlock()
+
+	if !errors.Is(err, ErrPIndexOpTimeout) {
+		delete(mgr.pindexOpTimeouts, pindexName)
+		return false
+	}
+
+	atomic.AddUint64(&mgr.stats.TotPIndexOpTimeout, 1)
+
+	if mgr.pindexOpTimeouts == nil {
+		mgr.pindexOpTimeouts = map[string]int{}
+	}
+	mgr.pindexOpTimeouts[pindexName]++
+
+	if mgr.pindexOpTimeouts[pindexName] < PIndexOpMaxTimeouts {
+		return false
+	}
+
+	delete(mgr.pindexOpTimeouts, pindexName)
+
+	return true
+}
+
+// openPIndexWatched is OpenPIndex() under a watchdog, where an empty
+// indexType means the default PIndexOpenTimeout.  A pindex whose open
+// completes after the timeout is closed again.
+func (mgr *Manager) openPIndexWatched(pindexName, indexType, path string) (
+	*PIndex, error) {
+	var m sync.Mutex
+	var pindex *PIndex
+	abandoned := false
+
+	returnedCh := make(chan struct{})
+
+	err := runPIndexOp("open", pindexName, pindexOpTimeout(indexType, "open"),
+		func() error {
+			defer close(returnedCh)
+
+			p, err := OpenPIndex(mgr, path)
+
+			m.Lock()
+			defer m.Unlock()
+
+			if abandoned {
+				if p != nil {
+					p.Close(false)
+				}
+				return err
+			}
+
+			pindex = p
+			return err
+		})
+
+	if errors.Is(err, ErrPIndexOpTimeout) {
+		m.Lock()
+		abandoned = true
+		if pindex != nil {
+			pindex.Close(false)
+			pindex = nil
+		}
+		m.Unlock()
+
+		if mgr.notePIndexOp(pindexName, err) {
+			mgr.quarantineHungPIndex(pindexName, path, err, returnedCh)
+		}
+
+		return nil, err
+	}
+
+	mgr.notePIndexOp(pindexName, err)
+
+	return pindex, err
+}
+
+// closePIndexWatched is PIndex.Close() under a watchdog.
+func (mgr *Manager) closePIndexWatched(pindex *PIndex, remove bool) error {
+	returnedCh := make(chan struct{})
+
+	err := runPIndexOp("close", pindex.Name,
+		pindexOpTimeout(pindex.IndexType, "close"),
+		func() error {
+			defer close(returnedCh)
+			return pindex.Close(remove)
+		})
+
+	if mgr.notePIndexOp(pindex.Name, err) {
+		mgr.quarantineHungPIndex(pindex.Name, pindex.Path, err, returnedCh)
+	}
+
+	return err
+}
+
+// quarantineHungPIndex quarantines the pindex of a hung op once the op
+// returns, which is signaled by the closing of the returnedCh, as the
+// op may still be writing into the path that the quarantine moves.
+func (mgr *Manager) quarantineHungPIndex(pindexName, path string, err error,
+	returnedCh <-chan struct{}) {
+	if path == "" {
+		return
+	}
+
+	log.Warnf("pindex_watchdog: pindex: %s, quarantine deferred until"+
+		" its hung op returns, err: %v", pindexName, err)
+
+	go func() {
+		<-returnedCh
+
+		errQ := mgr.quarantinePIndex(pindexName, path, err)
+		if errQ != nil {
+			log.Errorf("pindex_watchdog: %v", errQ)
+			return
+		}
+
+		mgr.JanitorKick("pindex_watchdog: quarantined " + pindexName)
+	}()
+}
@@ -0,0 +1,150 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunPIndexOp(t *testing.T) {
+	err := runPIndexOp("open", "p0", time.Second,
+		func() error { return fmt.Errorf("boom") })
+	if err == nil || errors.Is(err, ErrPIndexOpTimeout) {
+		t.Fatalf("expected the op's err, got: %v", err)
+	}
+
+	releaseCh := make(chan struct{})
+	defer close(releaseCh)
+
+	err = runPIndexOp("close", "p0", 10*time.Millisecond,
+		func() error { <-releaseCh; return nil })
+	if !errors.Is(err, ErrPIndexOpTimeout) {
+		t.Fatalf("expected a timeout, got: %v", err)
+	}
+}
+
+func TestPIndexOpTimeout(t *testing.T) {
+	PIndexImplTypes["testWatchdog"] = &PIndexImplType{
+		OpenTimeout: time.Second,
+	}
+	defer delete(PIndexImplTypes, "testWatchdog")
+
+	if pindexOpTimeout("testWatchdog", "open") != time.Second ||
+		pindexOpTimeout("testWatchdog", "close") != PIndexCloseTimeout ||
+		pindexOpTimeout("", "open") != PIndexOpenTimeout {
+		t.Fatalf("unexpected timeouts")
+	}
+}
+
+func TestNotePIndexOp(t *testing.T) {
+	mgr := NewManager(VERSION, nil, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	timeoutErr := fmt.Errorf("x: %w", ErrPIndexOpTimeout)
+
+	for i := 1; i < PIndexOpMaxTimeouts; i++ {
+		if mgr.notePIndexOp("p0", timeoutErr) {
+			t.Fatalf("expected no quarantine at timeout: %d", i)
+		}
+	}
+
+	// A success resets the consecutive timeouts.
+	mgr.notePIndexOp("p0", nil)
+	if mgr.notePIndexOp("p0", timeoutErr) {
+		t.Fatalf("expected no quarantine after a reset")
+	}
+
+	for i := 1; i < PIndexOpMaxTimeouts-1; i++ {
+		mgr.notePIndexOp("p0", timeoutErr)
+	}
+	if !mgr.notePIndexOp("p0", timeoutErr) {
+		t.Fatalf("expected a quarantine")
+	}
+
+	if n := atomic.LoadUint64(&mgr.stats.TotPIndexOpTimeout); n !=
+		uint64(2*PIndexOpMaxTimeouts-1) {
+		t.Fatalf("unexpected TotPIndexOpTimeout: %d", n)
+	}
+}
+
+func TestOpenPIndexWatchedSlowOpen(t *testing.T) {
+	dataDir := t.TempDir()
+
+	releaseCh := make(chan struct{})
+
+	PIndexImplTypes["testSlowOpen"] = &PIndexImplType{
+		OpenTimeout: 10 * time.Millisecond,
+		OpenUsing: func(indexType, path, indexParams string,
+			restart func()) (PIndexImpl, Dest, error) {
+			<-releaseCh
+			// A late write into the pindex's path.
+			err := os.WriteFile(filepath.Join(path, "late"), nil, 0600)
+			if err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, fmt.Errorf("slow open failed")
+		},
+	}
+	defer delete(PIndexImplTypes, "testSlowOpen")
+
+	prevMaxTimeouts := PIndexOpMaxTimeouts
+	PIndexOpMaxTimeouts = 1
+	defer func() { PIndexOpMaxTimeouts = prevMaxTimeouts }()
+
+	mgr := NewManager(VERSION, nil, NewUUID(), []string{"pindex"},
+		"", 1, "", "", dataDir, "", nil)
+
+	path := PIndexPath(dataDir, "p0")
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(path, PINDEX_META_FILENAME),
+		[]byte(`{"name":"p0","indexType":"testSlowOpen"}`), 0600)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	pindex, err := mgr.openPIndexWatched("p0", "testSlowOpen", path)
+	if pindex != nil || !errors.Is(err, ErrPIndexOpTimeout) {
+		t.Fatalf("expected a timeout, got: %v, %v", pindex, err)
+	}
+
+	// The quarantine waits for the abandoned open.
+	time.Sleep(50 * time.Millisecond)
+	if _, err = os.Stat(path); err != nil ||
+		len(mgr.QuarantinedPIndexes()) != 0 {
+		t.Fatalf("expected no quarantine while the open runs, err: %v", err)
+	}
+
+	close(releaseCh)
+
+	var quarantined []*QuarantinedPIndex
+	for i := 0; i < 200 && len(quarantined) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		quarantined = mgr.QuarantinedPIndexes()
+	}
+	if len(quarantined) != 1 {
+		t.Fatalf("expected a quarantine after the open returned")
+	}
+
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the path moved, err: %v", err)
+	}
+	_, err = os.Stat(filepath.Join(quarantined[0].Path, "late"))
+	if err != nil {
+		t.Fatalf("expected the late write quarantined, err: %v", err)
+	}
+}
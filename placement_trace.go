@@ -0,0 +1,384 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/blance"
+)
+
+// The placement traces record the inputs and outcome of each placement
+// of a pindex by the planner or the rebalancer, so that "why is this
+// pindex on that node" is answered by a lookup rather than by
+// reconstructing the planner's state.  The traces are kept in memory on
+// the nodes that computed the placements, up to PlacementTraceMax
+// pindexes with up to PlacementTraceHistory traces each.
+
+// PlacementTraceMax is the max number of pindexes whose placement
+// traces are kept, beyond which the traces of arbitrary pindexes are
+// dropped.
+var PlacementTraceMax = 10000
+
+// PlacementTraceHistory is the number of the most recent placement
+// traces that are kept for each pindex, as the plans that are computed
+// for estimates or comparisons may differ from the plan in the Cfg.
+var PlacementTraceHistory = 4
+
+// A PlacementCandidate is a node that was a candidate for a placement.
+type PlacementCandidate struct {
+	Node string `json:"node"`
+
+	// Order is the position of the node in the candidate order, which
+	// is rotated by the index name, and which breaks the ties between
+	// equally loaded nodes.
+	Order int `json:"order"`
+
+	Weight      int    `json:"weight"`                // Defaults to 1.
+	ServerGroup string `json:"serverGroup,omitempty"` // The node's parent in the hierarchy.
+
+	// The number of primary and replica pindexes on the node after the
+	// placement, across the pindexes that were balanced together.
+	Primaries int `json:"primaries"`
+	Replicas  int `json:"replicas"`
+
+	PrevState string `json:"prevState,omitempty"` // Of the pindex, before.
+	State     string `json:"state,omitempty"`     // Of the pindex, after.
+
+	Adding   bool `json:"adding,omitempty"`
+	Removing bool `json:"removing,omitempty"`
+}
+
+// A PlacementTrace records the inputs and outcome of a placement of a
+// pindex.
+type PlacementTrace struct {
+	PIndex    string    `json:"pindex"`
+	IndexName string    `json:"indexName"`
+	Mode      string    `json:"mode,omitempty"`
+	Time      time.Time `json:"time"`
+
+	// The constraints of the placement.
+	Primaries       int                   `json:"primaries"`
+	Replicas        int                   `json:"replicas"`
+	PartitionWeight int                   `json:"partitionWeight,omitempty"`
+	StateStickiness map[string]int        `json:"stateStickiness,omitempty"`
+	HierarchyRules  blance.HierarchyRules `json:"hierarchyRules,omitempty"`
+
+	// Whether the placement ignored the other indexes' pindexes when
+	// balancing the nodes, such as for the partition node stickiness.
+	SkipExistingPartitions bool `json:"skipExistingPartitions,omitempty"`
+
+	// Frozen is true when the plan of the index is frozen, so the
+	// pindex was kept on its previous nodes.
+	Frozen bool `json:"frozen,omitempty"`
+
+	// Sticky is true when the index was updated without changing its
+	// partitioning, so the pindex was kept on the nodes of its
+	// previous pindex.
+	Sticky bool `json:"sticky,omitempty"`
+
+	Candidates []*PlacementCandidate `json:"candidates,omitempty"`
+
+	// Excluded nodes that weren't candidates, with the reasons.
+	Excluded map[string]string `json:"excluded,omitempty"`
+
+	// Adjustments of the inputs, such as of the node weights.
+	Adjustments []string `json:"adjustments,omitempty"`
+
+	Warnings []string `json:"warnings,omitempty"`
+
+	Nodes map[string]*PlanPIndexNode `json:"nodes"` // The outcome.
+
+	// Explanation is a readable summary of the placement.
+	Explanation []string `json:"explanation,omitempty"`
+}
+
+var placementTracesM sync.Mutex
+var placementTraces = map[string][]*PlacementTrace{} // Keyed by pindex.
+
+// recordPlacementTrace adds a trace of a pindex, newest last.
+func recordPlacementTrace(trace *PlacementTrace) {
+	placementTracesM.Lock()
+	defer placementTracesM.Unlock()
+
+	traces := placementTraces[trace.PIndex]
+	if traces == nil {
+		for name := range placementTraces {
+			if len(placementTraces) < PlacementTraceMax {
+				break
+			}
+			delete(placementTraces, name)
+		}
+	}
+
+	traces = append(traces, trace)
+	if len(traces) > PlacementTraceHistory {
+		traces = traces[len(traces)-PlacementTraceHistory:]
+	}
+
+	placementTraces[trace.PIndex] = traces
+}
+
+// annotatePlacementTraces adds the excluded nodes and the adjustments
+// of the inputs to the latest traces of the pindexes.
+func annotatePlacementTraces(planPIndexes map[string]*PlanPIndex,
+	excluded map[string]string, adjustments []string) {
+	if len(excluded) == 0 && len(adjustments) == 0 {
+		return
+	}
+
+	placementTracesM.Lock()
+	defer placementTracesM.Unlock()
+
+	for name := range planPIndexes {
+		traces := placementTraces[name]
+		if len(traces) == 0 {
+			continue
+		}
+
+		trace := traces[len(traces)-1]
+		for node, reason := range excluded {
+			if trace.Excluded == nil {
+				trace.Excluded = map[string]string{}
+			}
+			trace.Excluded[node] = reason
+			trace.Explanation = append(trace.Explanation,
+				fmt.Sprintf("node %s was excluded: %s", node, reason))
+		}
+		trace.Adjustments = append(trace.Adjustments, adjustments...)
+	}
+}
+
+// PlacementTraces returns the recorded traces of a pindex, newest
+// first.
+func PlacementTraces(pindexName string) []*PlacementTrace {
+	placementTracesM.Lock()
+	traces := placementTraces[pindexName]
+	placementTracesM.Unlock()
+
+	rv := make([]*PlacementTrace, 0, len(traces))
+	for i := len(traces) - 1; i >= 0; i-- {
+		rv = append(rv, traces[i])
+	}
+
+	return rv
+}
+
+// ExplainPlacement returns the most recent trace of a pindex whose
+// outcome matches the nodes of the planPIndex, which may be nil, else
+// its most recent trace, where stale is true when the trace doesn't
+// match the planPIndex.  The trace is nil when this node has no traces
+// of the pindex, such as when it didn't plan it since it started.
+func ExplainPlacement(pindexName string,
+	planPIndex *PlanPIndex) (trace *PlacementTrace, stale bool) {
+	traces := PlacementTraces(pindexName)
+	if len(traces) == 0 {
+		return nil, false
+	}
+
+	if planPIndex != nil {
+		for _, t := range traces {
+			if reflect.DeepEqual(t.Nodes, planPIndex.Nodes) {
+				return t, false
+			}
+		}
+	}
+
+	return traces[0], planPIndex != nil
+}
+
+// tracePlacements records the traces of the placements of the
+// planPIndexesForIndex by blance.
+func tracePlacements(mode string, indexDef *IndexDef,
+	planPIndexesForIndex map[string]*PlanPIndex,
+	model blance.PartitionModel,
+	prevMap, nextMap blance.PartitionMap,
+	nodeUUIDsAllForIndex, nodeUUIDsToAdd, nodeUUIDsToRemove []string,
+	nodeWeights map[string]int, nodeHierarchy map[string]string,
+	stateStickiness map[string]int, skipExistingPartitions bool,
+	warnings map[string][]string) {
+	now := time.Now()
+
+	// The loads of the nodes, across the partitions balanced together.
+	counts := map[string]map[string]int{} // Keyed by state, node.
+	for _, partition := range nextMap {
+		for state, nodes := range partition.NodesByState {
+			if counts[state] == nil {
+				counts[state] = map[string]int{}
+			}
+			for _, node := range nodes {
+				counts[state][node]++
+			}
+		}
+	}
+
+	adding := StringsToMap(nodeUUIDsToAdd)
+	removing := StringsToMap(nodeUUIDsToRemove)
+
+	names := make([]string, 0, len(planPIndexesForIndex))
+	for name := range planPIndexesForIndex {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		planPIndex := planPIndexesForIndex[name]
+
+		trace := &PlacementTrace{
+			PIndex:                 name,
+			IndexName:              indexDef.Name,
+			Mode:                   mode,
+			Time:                   now,
+			Primaries:              model["primary"].Constraints,
+			Replicas:               model["replica"].Constraints,
+			PartitionWeight:        indexDef.PlanParams.PIndexWeights[name],
+			StateStickiness:        stateStickiness,
+			HierarchyRules:         indexDef.PlanParams.HierarchyRules,
+			SkipExistingPartitions: skipExistingPartitions,
+			Warnings:               warnings[name],
+			Nodes:                  copyPlanPIndexNodes(planPIndex.Nodes),
+		}
+
+		prevStates := map[string]string{}
+		if prev := prevMap[name]; prev != nil {
+			for state, nodes := range prev.NodesByState {
+				for _, node := range nodes {
+					prevStates[node] = state
+				}
+			}
+		}
+
+		for i, node := range nodeUUIDsAllForIndex {
+			c := &PlacementCandidate{
+				Node:        node,
+				Order:       i,
+				Weight:      1,
+				ServerGroup: nodeHierarchy[node],
+				Primaries:   counts["primary"][node],
+				Replicas:    counts["replica"][node],
+				PrevState:   prevStates[node],
+				Adding:      adding[node],
+				Removing:    removing[node],
+			}
+			if w, exists := nodeWeights[node]; exists {
+				c.Weight = w
+			}
+			if n := planPIndex.Nodes[node]; n != nil {
+				c.State = "primary"
+				if n.Priority > 0 {
+					c.State = "replica"
+				}
+			}
+
+			trace.Candidates = append(trace.Candidates, c)
+			trace.Explanation = append(trace.Explanation,
+				explainPlacementCandidate(c)...)
+		}
+
+		if len(trace.HierarchyRules) > 0 && len(nodeHierarchy) > 0 {
+			trace.Explanation = append(trace.Explanation,
+				"the replicas were placed by the hierarchy rules, such as"+
+					" onto other server groups than the primary")
+		}
+
+		recordPlacementTrace(trace)
+	}
+}
+
+// traceFrozenPlacements records the traces of the pindexes of an index
+// whose plan is frozen.
+func traceFrozenPlacements(indexDef *IndexDef, planPIndexes *PlanPIndexes) {
+	now := time.Now()
+
+	for name, planPIndex := range planPIndexes.PlanPIndexes {
+		if planPIndex.IndexName != indexDef.Name {
+			continue
+		}
+
+		recordPlacementTrace(&PlacementTrace{
+			PIndex:    name,
+			IndexName: indexDef.Name,
+			Time:      now,
+			Frozen:    true,
+			Nodes:     copyPlanPIndexNodes(planPIndex.Nodes),
+			Explanation: []string{
+				"the plan of the index is frozen, so the pindex was kept" +
+					" on its previous nodes",
+			},
+		})
+	}
+}
+
+// traceStickyPlacements records the traces of the pindexes of an index
+// update that were kept on the nodes of their previous pindexes.
+func traceStickyPlacements(indexDef *IndexDef,
+	planPIndexesForIndex map[string]*PlanPIndex) {
+	now := time.Now()
+
+	for name, planPIndex := range planPIndexesForIndex {
+		recordPlacementTrace(&PlacementTrace{
+			PIndex:    name,
+			IndexName: indexDef.Name,
+			Time:      now,
+			Primaries: 1,
+			Replicas:  indexDef.PlanParams.NumReplicas,
+			Sticky:    true,
+			Nodes:     copyPlanPIndexNodes(planPIndex.Nodes),
+			Explanation: []string{
+				"the index was updated without changing its partitioning," +
+					" so the pindex was kept on the nodes of its previous" +
+					" pindex",
+			},
+		})
+	}
+}
+
+func explainPlacementCandidate(c *PlacementCandidate) []string {
+	load := fmt.Sprintf("%d primaries and %d replicas at weight %d,"+
+		" candidate order %d", c.Primaries, c.Replicas, c.Weight, c.Order)
+
+	switch {
+	case c.State != "" && c.State == c.PrevState:
+		return []string{fmt.Sprintf("node %s kept the %s, as a placement"+
+			" sticks to its previous nodes (%s)", c.Node, c.State, load)}
+
+	case c.State != "":
+		return []string{fmt.Sprintf("node %s was chosen for the %s, as one"+
+			" of the least loaded candidates by weight, where ties are"+
+			" broken by the candidate order (%s)", c.Node, c.State, load)}
+
+	case c.Removing:
+		return []string{fmt.Sprintf("node %s wasn't chosen, as it's being"+
+			" removed", c.Node)}
+
+	case c.Weight < 0:
+		return []string{fmt.Sprintf("node %s wasn't chosen, as its"+
+			" weight is negative (%s)", c.Node, load)}
+
+	case c.PrevState != "":
+		return []string{fmt.Sprintf("node %s lost the %s, as other"+
+			" candidates were less loaded by weight (%s)",
+			c.Node, c.PrevState, load)}
+	}
+
+	return nil
+}
+
+func copyPlanPIndexNodes(nodes map[string]*PlanPIndexNode) map[string]*PlanPIndexNode {
+	rv := make(map[string]*PlanPIndexNode, len(nodes))
+	for node, n := range nodes {
+		c := *n
+		rv[node] = &c
+	}
+	return rv
+}
@@ -0,0 +1,107 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"testing"
+)
+
+func TestExplainPlacement(t *testing.T) {
+	indexDefs := NewIndexDefs(VERSION)
+	indexDefs.IndexDefs["i0"] = &IndexDef{
+		Name:       "i0",
+		UUID:       "u0",
+		Type:       "blackhole",
+		SourceType: "primary",
+		SourceName: "s0",
+		PlanParams: PlanParams{NumReplicas: 1},
+	}
+
+	nodeDefs := NewNodeDefs(VERSION)
+	for _, node := range []string{"n0", "n1", "n2"} {
+		nodeDefs.NodeDefs[node] = &NodeDef{UUID: node, ImplVersion: VERSION}
+	}
+	nodeDefs.NodeDefs["n2"].Maintenance = true
+
+	planPIndexes, err := CalcPlan("", indexDefs, nodeDefs,
+		NewPlanPIndexes(VERSION), VERSION, "", nil, nil)
+	if err != nil || len(planPIndexes.PlanPIndexes) == 0 {
+		t.Fatalf("expected a plan, got: %#v, err: %v", planPIndexes, err)
+	}
+
+	for name, planPIndex := range planPIndexes.PlanPIndexes {
+		trace, stale := ExplainPlacement(name, planPIndex)
+		if trace == nil || stale {
+			t.Fatalf("expected a matching trace, got: %#v", trace)
+		}
+		if trace.IndexName != "i0" || trace.Replicas != 1 ||
+			len(trace.Candidates) != 2 || len(trace.Explanation) == 0 {
+			t.Fatalf("unexpected trace: %#v", trace)
+		}
+		if trace.Excluded["n2"] == "" {
+			t.Fatalf("expected the node in maintenance to be excluded,"+
+				" got: %#v", trace.Excluded)
+		}
+
+		chosen := 0
+		for _, c := range trace.Candidates {
+			if c.State != "" {
+				chosen++
+			}
+		}
+		if chosen != 2 {
+			t.Fatalf("expected a primary and a replica, got: %#v",
+				trace.Candidates)
+		}
+
+		// A different plan of the pindex is reported as stale.
+		_, stale = ExplainPlacement(name, &PlanPIndex{Name: name})
+		if !stale {
+			t.Fatalf("expected a stale trace")
+		}
+	}
+
+	if trace, _ := ExplainPlacement("missing", nil); trace != nil {
+		t.Fatalf("expected no trace, got: %#v", trace)
+	}
+
+	// A plan that's only compared against doesn't replace the traces.
+	numTraces := map[string]int{}
+	for name := range planPIndexes.PlanPIndexes {
+		numTraces[name] = len(PlacementTraces(name))
+	}
+
+	nodeDefs.NodeDefs["n2"].Maintenance = false
+	_, err = CalcPlan("", indexDefs, nodeDefs,
+		NewPlanPIndexes(VERSION), VERSION, "",
+		map[string]string{"disablePlacementTraces": "true"}, nil)
+	if err != nil {
+		t.Fatalf("expected a plan, err: %v", err)
+	}
+	for name, planPIndex := range planPIndexes.PlanPIndexes {
+		if traces := PlacementTraces(name); len(traces) != numTraces[name] {
+			t.Fatalf("expected no more traces, got: %#v", traces)
+		}
+		if _, stale := ExplainPlacement(name, planPIndex); stale {
+			t.Fatalf("expected a matching trace")
+		}
+	}
+}
+
+func TestPlacementTraceHistory(t *testing.T) {
+	for i := 0; i < PlacementTraceHistory+2; i++ {
+		recordPlacementTrace(&PlacementTrace{PIndex: "pHistory", Primaries: i})
+	}
+
+	traces := PlacementTraces("pHistory")
+	if len(traces) != PlacementTraceHistory ||
+		traces[0].Primaries != PlacementTraceHistory+1 {
+		t.Fatalf("expected the newest traces first, got: %#v", traces)
+	}
+}
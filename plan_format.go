@@ -0,0 +1,228 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	log "github.com/couchbase/clog"
+)
+
+// The plan format versioning allows the format of the PlanPIndexes in
+// the Cfg to change between releases without breaking mixed-version
+// clusters during rolling upgrades.
+//
+// The legacy format (version 1) under PLAN_PINDEXES_KEY remains the
+// authoritative copy that every node reads, writes and CAS'es, as it's
+// the only format that older nodes understand.  Once the whole cluster
+// supports the CurrentPlanFormat, if any, nodes dual-write it under its
+// own key, alongside the legacy format.  On reads, nodes dual-read both
+// formats, and use the newer format only when it was written together
+// with the current legacy plan; otherwise, an older node (or a racing
+// writer) has since updated the legacy plan, which then wins.
+
+// PLAN_PINDEXES_FORMAT_LEGACY is the format version of the
+// PlanPIndexes stored under PLAN_PINDEXES_KEY.
+const PLAN_PINDEXES_FORMAT_LEGACY = 1
+
+// A PlanFormat is a versioned format of the PlanPIndexes in the Cfg.
+type PlanFormat struct {
+	Version int
+	Key     string // The Cfg key of the format.
+
+	// MinImplVersion is the lowest node ImplVersion (see VERSION)
+	// that understands the format.
+	MinImplVersion string
+
+	// MinClusterCompatVersion is the lowest cluster compatibility
+	// version, as reported by a Cfg that's a VersionReader, at which
+	// all the nodes understand the format.
+	MinClusterCompatVersion string
+}
+
+// PlanFormatV2 wraps the PlanPIndexes in a PlanPIndexesEnvelope that
+// records its format version, and is stored as is, without the Cfg
+// specific re-arrangements of the legacy plan (see CfgMetaKv).
+var PlanFormatV2 = &PlanFormat{
+	Version:                 2,
+	Key:                     "planPIndexesV2",
+	MinImplVersion:          "5.8.0",
+	MinClusterCompatVersion: "7.6.0",
+}
+
+// CurrentPlanFormat is the newest plan format of this node, which is
+// dual-written once the cluster supports it, and is nil while only the
+// legacy format is used.  As the dual-write doubles the plan writes, a
+// format is to be made current only once its content differs from the
+// legacy plan, and once its key is split by the Cfg providers that
+// split the legacy plan, like CfgMetaKv (see cfgMetaKvAdvancedKeys).
+// PlanFormatV2 has the same content as the legacy plan, so it's not
+// current yet.
+var CurrentPlanFormat *PlanFormat
+
+// PlanPIndexesEnvelope is the Cfg value of plan formats after the
+// legacy format.
+type PlanPIndexesEnvelope struct {
+	FormatVersion int `json:"formatVersion"`
+
+	// LegacyUUID is the UUID of the legacy plan that was written along
+	// with this plan, used to detect legacy writes by older nodes.
+	LegacyUUID string `json:"legacyUUID"`
+
+	PlanPIndexes *PlanPIndexes `json:"planPIndexes"`
+}
+
+// PlanFormatCompatHook allows applications to override the check of
+// whether all the nodes of the cluster support a plan format.
+var PlanFormatCompatHook func(cfg Cfg, format *PlanFormat) (bool, error)
+
+// PlanFormatSupported returns true when all the nodes of the cluster
+// support the plan format.  The cluster compatibility version is
+// checked when the Cfg is a VersionReader, with a fallback to the
+// ImplVersion's of the node definitions.
+func PlanFormatSupported(cfg Cfg, format *PlanFormat) (bool, error) {
+	if PlanFormatCompatHook != nil {
+		return PlanFormatCompatHook(cfg, format)
+	}
+
+	return clusterCompatible(cfg, format.MinClusterCompatVersion,
+		format.MinImplVersion)
+}
+
+// clusterCompatible returns true when all the nodes of the cluster are
+// at least at the minClusterCompatVersion, which is checked when the
+// Cfg is a VersionReader, with a fallback to the minImplVersion of the
+// node definitions.
+func clusterCompatible(cfg Cfg, minClusterCompatVersion,
+	minImplVersion string) (bool, error) {
+	if rsc, ok := cfg.(VersionReader); ok && minClusterCompatVersion != "" {
+		ccVersion, err := rsc.ClusterVersion()
+		if err == nil {
+			minVersion, err := CompatibilityVersion(minClusterCompatVersion)
+			if err != nil {
+				return false, err
+			}
+			return ccVersion >= minVersion, nil
+		}
+
+		log.Printf("plan_format: ClusterVersion, err: %v", err)
+	}
+
+	seen := false
+	for _, k := range []string{NODE_DEFS_KNOWN, NODE_DEFS_WANTED} {
+		nodeDefs, _, err := CfgGetNodeDefs(cfg, k)
+		if err != nil {
+			return false, err
+		}
+		if nodeDefs == nil {
+			continue
+		}
+		for _, nodeDef := range nodeDefs.NodeDefs {
+			if !VersionGTE(nodeDef.ImplVersion, minImplVersion) {
+				return false, nil
+			}
+			seen = true
+		}
+	}
+
+	return seen, nil
+}
+
+// ------------------------------------------------------------------------
+
+// cfgGetPlanPIndexesFormat dual-reads the plan formats, returning the
+// PlanPIndexes with the CAS of the legacy plan and the format version
+// that was used.
+func cfgGetPlanPIndexesFormat(cfg Cfg) (*PlanPIndexes, uint64, int, error) {
+	v, cas, err := cfg.Get(PLAN_PINDEXES_KEY, 0)
+	if err != nil {
+		return nil, cas, 0, err
+	}
+	if v == nil {
+		return nil, cas, 0, nil
+	}
+	rv := &PlanPIndexes{}
+	err = UnmarshalJSON(v, rv)
+	if err != nil {
+		return nil, cas, 0, err
+	}
+
+	format := CurrentPlanFormat
+	if format == nil || format.Version <= PLAN_PINDEXES_FORMAT_LEGACY {
+		return rv, cas, PLAN_PINDEXES_FORMAT_LEGACY, nil
+	}
+
+	ev, _, err := cfg.Get(format.Key, 0)
+	if err != nil || ev == nil {
+		return rv, cas, PLAN_PINDEXES_FORMAT_LEGACY, nil
+	}
+
+	env := &PlanPIndexesEnvelope{}
+	err = UnmarshalJSON(ev, env)
+	if err != nil {
+		log.Warnf("plan_format: unmarshal, key: %s, err: %v", format.Key, err)
+		return rv, cas, PLAN_PINDEXES_FORMAT_LEGACY, nil
+	}
+
+	// Formats newer than this node's, and envelopes that are stale
+	// relative to the legacy plan, are ignored.
+	if env.FormatVersion > format.Version || env.PlanPIndexes == nil ||
+		env.LegacyUUID != rv.UUID {
+		return rv, cas, PLAN_PINDEXES_FORMAT_LEGACY, nil
+	}
+
+	return env.PlanPIndexes, cas, env.FormatVersion, nil
+}
+
+// cfgSetPlanPIndexesFormat writes the legacy plan with the CAS, and
+// when the cluster supports the current plan format, also writes the
+// current plan format.  An error in writing the current plan format is
+// only logged, as readers then fall back to the legacy plan.
+func cfgSetPlanPIndexesFormat(cfg Cfg, planPIndexes *PlanPIndexes,
+	cas uint64) (uint64, error) {
+	buf, err := MarshalJSON(planPIndexes)
+	if err != nil {
+		return 0, err
+	}
+
+	casResult, err := cfg.Set(PLAN_PINDEXES_KEY, buf, cas)
+	if err != nil {
+		return casResult, err
+	}
+
+	format := CurrentPlanFormat
+	if format == nil || format.Version <= PLAN_PINDEXES_FORMAT_LEGACY {
+		return casResult, nil
+	}
+
+	supported, err := PlanFormatSupported(cfg, format)
+	if err != nil || !supported {
+		return casResult, nil
+	}
+
+	ebuf, err := MarshalJSON(&PlanPIndexesEnvelope{
+		FormatVersion: format.Version,
+		LegacyUUID:    planPIndexes.UUID,
+		PlanPIndexes:  planPIndexes,
+	})
+	if err == nil {
+		_, err = cfg.Set(format.Key, ebuf, CFG_CAS_FORCE)
+	}
+	if err != nil {
+		log.Warnf("plan_format: dual-write, key: %s, err: %v", format.Key, err)
+	}
+
+	return casResult, nil
+}
+
+// CfgGetPlanPIndexesFormatVersion returns the format version of the
+// PlanPIndexes that are read from the Cfg, which is the legacy format
+// version until the cluster supports a newer format.
+func CfgGetPlanPIndexesFormatVersion(cfg Cfg) (int, error) {
+	_, _, version, err := cfgGetPlanPIndexesFormat(cfg)
+	return version, err
+}
@@ -0,0 +1,206 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"testing"
+)
+
+func setTestNodeVersions(t *testing.T, cfg Cfg, versions map[string]string) {
+	nodeDefs := NewNodeDefs(VERSION)
+	for uuid, version := range versions {
+		nodeDefs.NodeDefs[uuid] = &NodeDef{UUID: uuid, ImplVersion: version}
+	}
+	_, cas, _ := CfgGetNodeDefs(cfg, NODE_DEFS_KNOWN)
+	_, err := CfgSetNodeDefs(cfg, NODE_DEFS_KNOWN, nodeDefs, cas)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+}
+
+func testPlan(name string) *PlanPIndexes {
+	p := NewPlanPIndexes(VERSION)
+	p.PlanPIndexes[name] = &PlanPIndex{Name: name, IndexName: "idx"}
+	return p
+}
+
+// A node of an older release only reads and writes the legacy plan.
+func setLegacyPlan(t *testing.T, cfg Cfg, p *PlanPIndexes, cas uint64) uint64 {
+	buf, err := MarshalJSON(p)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	cas, err = cfg.Set(PLAN_PINDEXES_KEY, buf, cas)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	return cas
+}
+
+func checkPlan(t *testing.T, cfg Cfg, expName string, expFormat int) uint64 {
+	p, cas, format, err := cfgGetPlanPIndexesFormat(cfg)
+	if err != nil || p == nil || p.PlanPIndexes[expName] == nil ||
+		len(p.PlanPIndexes) != 1 {
+		t.Fatalf("expected plan: %s, got: %+v, err: %v", expName, p, err)
+	}
+	if format != expFormat {
+		t.Fatalf("expected format: %d, got: %d", expFormat, format)
+	}
+	return cas
+}
+
+// useTestPlanFormat makes PlanFormatV2 the CurrentPlanFormat until
+// the test's cleanup.
+func useTestPlanFormat(t *testing.T) {
+	prev := CurrentPlanFormat
+	CurrentPlanFormat = PlanFormatV2
+	t.Cleanup(func() { CurrentPlanFormat = prev })
+}
+
+// A testGetCountCfg counts the Get's of each key.
+type testGetCountCfg struct {
+	*CfgMem
+	gets map[string]int
+}
+
+func (c *testGetCountCfg) Get(key string, cas uint64) ([]byte, uint64, error) {
+	c.gets[key]++
+	return c.CfgMem.Get(key, cas)
+}
+
+func TestPlanFormatLegacyOnly(t *testing.T) {
+	cfg := &testGetCountCfg{CfgMem: NewCfgMem(), gets: map[string]int{}}
+
+	setTestNodeVersions(t, cfg, map[string]string{"n1": VERSION, "n2": VERSION})
+
+	// Without a current plan format, even an upgraded cluster writes
+	// and reads only the legacy plan.
+	_, err := CfgSetPlanPIndexes(cfg, testPlan("a"), 0)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if v, _, _ := cfg.CfgMem.Get(PlanFormatV2.Key, 0); v != nil {
+		t.Fatalf("expected no dual-write")
+	}
+
+	checkPlan(t, cfg, "a", PLAN_PINDEXES_FORMAT_LEGACY)
+	if cfg.gets[PlanFormatV2.Key] != 0 || cfg.gets[PLAN_PINDEXES_KEY] != 1 {
+		t.Errorf("expected a single plan read, got: %v", cfg.gets)
+	}
+}
+
+func TestPlanFormatRollingUpgrade(t *testing.T) {
+	useTestPlanFormat(t)
+
+	cfg := NewCfgMem()
+
+	// A mixed-version cluster, with n1 on an older release.
+	setTestNodeVersions(t, cfg, map[string]string{"n1": "5.7.0", "n2": VERSION})
+
+	cas := setLegacyPlan(t, cfg, testPlan("old-0"), 0)
+	checkPlan(t, cfg, "old-0", PLAN_PINDEXES_FORMAT_LEGACY)
+
+	// The upgraded n2 writes only the legacy plan, readable by n1.
+	cas, err := CfgSetPlanPIndexes(cfg, testPlan("new-0"), cas)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if v, _, _ := cfg.Get(PlanFormatV2.Key, 0); v != nil {
+		t.Fatalf("expected no dual-write in a mixed cluster")
+	}
+	checkPlan(t, cfg, "new-0", PLAN_PINDEXES_FORMAT_LEGACY)
+
+	// n1 keeps planning, seen by n2.
+	cas = setLegacyPlan(t, cfg, testPlan("old-1"), cas)
+	checkPlan(t, cfg, "old-1", PLAN_PINDEXES_FORMAT_LEGACY)
+
+	// n1 is upgraded, so plans are dual-written.
+	setTestNodeVersions(t, cfg, map[string]string{"n1": VERSION, "n2": VERSION})
+
+	cas, err = CfgSetPlanPIndexes(cfg, testPlan("new-1"), cas)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if v, _, _ := cfg.Get(PlanFormatV2.Key, 0); v == nil {
+		t.Fatalf("expected a dual-write in an upgraded cluster")
+	}
+	cas = checkPlan(t, cfg, "new-1", PlanFormatV2.Version)
+
+	// The legacy plan is still updated, as is its CAS.
+	legacy := &PlanPIndexes{}
+	v, legacyCAS, _ := cfg.Get(PLAN_PINDEXES_KEY, 0)
+	if UnmarshalJSON(v, legacy) != nil || legacy.PlanPIndexes["new-1"] == nil ||
+		legacyCAS != cas {
+		t.Fatalf("expected the legacy plan, got: %s", v)
+	}
+
+	// A write with a stale CAS fails without touching either format.
+	_, err = CfgSetPlanPIndexes(cfg, testPlan("stale"), cas-1)
+	if err == nil {
+		t.Fatalf("expected a CAS err")
+	}
+	checkPlan(t, cfg, "new-1", PlanFormatV2.Version)
+
+	// A lagging legacy write (e.g., from a node that's not yet
+	// restarted on the new release) wins over the newer format.
+	cas = setLegacyPlan(t, cfg, testPlan("lagging"), cas)
+	checkPlan(t, cfg, "lagging", PLAN_PINDEXES_FORMAT_LEGACY)
+
+	cas, err = CfgSetPlanPIndexes(cfg, testPlan("new-2"), cas)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	checkPlan(t, cfg, "new-2", PlanFormatV2.Version)
+
+	// A format from a newer release is ignored for the legacy plan.
+	buf, _ := MarshalJSON(&PlanPIndexesEnvelope{
+		FormatVersion: PlanFormatV2.Version + 1,
+		LegacyUUID:    "x",
+		PlanPIndexes:  testPlan("future"),
+	})
+	cfg.Set(PlanFormatV2.Key, buf, CFG_CAS_FORCE)
+	checkPlan(t, cfg, "new-2", PLAN_PINDEXES_FORMAT_LEGACY)
+}
+
+type testVersionReaderCfg struct {
+	*CfgMem
+	version string
+}
+
+func (c *testVersionReaderCfg) ClusterVersion() (uint64, error) {
+	return CompatibilityVersion(c.version)
+}
+
+func TestPlanFormatClusterCompat(t *testing.T) {
+	useTestPlanFormat(t)
+
+	cfg := &testVersionReaderCfg{CfgMem: NewCfgMem(), version: "7.2.0"}
+
+	// The node defs are irrelevant when the cluster compat is known.
+	setTestNodeVersions(t, cfg, map[string]string{"n1": VERSION})
+
+	supported, err := PlanFormatSupported(cfg, PlanFormatV2)
+	if err != nil || supported {
+		t.Fatalf("expected unsupported, got: %v, err: %v", supported, err)
+	}
+
+	cas, err := CfgSetPlanPIndexes(cfg, testPlan("a"), 0)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	checkPlan(t, cfg, "a", PLAN_PINDEXES_FORMAT_LEGACY)
+
+	cfg.version = PlanFormatV2.MinClusterCompatVersion
+
+	_, err = CfgSetPlanPIndexes(cfg, testPlan("b"), cas)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	checkPlan(t, cfg, "b", PlanFormatV2.Version)
+}
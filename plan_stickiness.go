@@ -0,0 +1,99 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+// INDEX_UPDATE_STICKINESS_OPTION is the manager option that, when
+// "false", lets the planner rebalance the pindexes of an updated index,
+// rather than keeping them on the nodes of their previous pindexes.
+const INDEX_UPDATE_STICKINESS_OPTION = "enableIndexUpdateStickiness"
+
+// CaseIndexUpdateSticky returns true if the indexDef is an update of an
+// index that didn't change its partitioning, in which case it also
+// assigns the planPIndexesForIndex to the same nodes, with the same
+// priorities, as their previous pindexes.  That way, an update such as
+// a params tweak only restarts the pindexes in place, rather than
+// moving them around the cluster.
+//
+// The partitioning is unchanged when the index type, the source and
+// the source partitions of the pindexes are the same, and the previous
+// pindexes have the wanted number of replicas on nodes that remain in
+// the cluster.  Otherwise, the planner balances the pindexes as usual.
+func CaseIndexUpdateSticky(indexDef *IndexDef,
+	planPIndexesForIndex map[string]*PlanPIndex,
+	planPIndexesPrev *PlanPIndexes,
+	nodeUUIDsAll, nodeUUIDsToRemove []string,
+	options map[string]string) bool {
+	if options[INDEX_UPDATE_STICKINESS_OPTION] == "false" ||
+		planPIndexesPrev == nil || len(planPIndexesForIndex) == 0 {
+		return false
+	}
+
+	// The previous pindexes of the index, keyed by source partitions.
+	prevPlanPIndexes := map[string]*PlanPIndex{}
+	for _, p := range planPIndexesPrev.PlanPIndexes {
+		if p.IndexName != indexDef.Name {
+			continue
+		}
+		if p.IndexUUID == indexDef.UUID {
+			return false // Not an update.
+		}
+		if p.IndexType != indexDef.Type ||
+			p.SourceType != indexDef.SourceType ||
+			p.SourceName != indexDef.SourceName ||
+			p.SourceUUID != indexDef.SourceUUID {
+			return false
+		}
+		prevPlanPIndexes[p.SourcePartitions] = p
+	}
+
+	if len(prevPlanPIndexes) != len(planPIndexesForIndex) {
+		return false
+	}
+
+	nodes := StringsToMap(nodeUUIDsAll)
+	removing := StringsToMap(nodeUUIDsToRemove)
+
+	for _, planPIndex := range planPIndexesForIndex {
+		p := prevPlanPIndexes[planPIndex.SourcePartitions]
+		if p == nil || len(p.Nodes) != 1+indexDef.PlanParams.NumReplicas {
+			return false
+		}
+		for nodeUUID := range p.Nodes {
+			if !nodes[nodeUUID] || removing[nodeUUID] {
+				return false
+			}
+		}
+	}
+
+	for _, planPIndex := range planPIndexesForIndex {
+		p := prevPlanPIndexes[planPIndex.SourcePartitions]
+
+		planPIndex.Nodes = map[string]*PlanPIndexNode{}
+
+		for nodeUUID, planPIndexNode := range p.Nodes {
+			canRead := true
+			canWrite := true
+			nodePlanParam :=
+				GetNodePlanParam(indexDef.PlanParams.NodePlanParams,
+					nodeUUID, indexDef.Name, planPIndex.Name)
+			if nodePlanParam != nil {
+				canRead = nodePlanParam.CanRead
+				canWrite = nodePlanParam.CanWrite
+			}
+
+			planPIndex.Nodes[nodeUUID] = &PlanPIndexNode{
+				CanRead:  canRead,
+				CanWrite: canWrite,
+				Priority: planPIndexNode.Priority,
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,214 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func stickinessTestDefs(numPartitions string) (*IndexDefs, *NodeDefs) {
+	indexDefs := NewIndexDefs(VERSION)
+	indexDefs.IndexDefs["i0"] = &IndexDef{
+		Name:         "i0",
+		UUID:         "u0",
+		Type:         "blackhole",
+		SourceType:   "primary",
+		SourceName:   "s0",
+		SourceParams: `{"numPartitions":` + numPartitions + `}`,
+		PlanParams: PlanParams{
+			MaxPartitionsPerPIndex: 1,
+			NumReplicas:            1,
+		},
+	}
+
+	nodeDefs := NewNodeDefs(VERSION)
+	for _, node := range []string{"n0", "n1", "n2", "n3"} {
+		nodeDefs.NodeDefs[node] = &NodeDef{UUID: node, ImplVersion: VERSION}
+	}
+
+	return indexDefs, nodeDefs
+}
+
+// stickinessTestPrevPlan returns a plan of the index whose pindexes are
+// all on the first two nodes, which the planner would otherwise spread
+// across the nodes.
+func stickinessTestPrevPlan(t *testing.T, indexDefs *IndexDefs,
+	nodeDefs *NodeDefs) *PlanPIndexes {
+	planPIndexes, err := CalcPlan("", indexDefs, nodeDefs,
+		NewPlanPIndexes(VERSION), VERSION, "", nil, nil)
+	if err != nil || len(planPIndexes.PlanPIndexes) != 4 {
+		t.Fatalf("expected a plan, got: %#v, err: %v", planPIndexes, err)
+	}
+
+	for _, planPIndex := range planPIndexes.PlanPIndexes {
+		planPIndex.Nodes = map[string]*PlanPIndexNode{
+			"n0": {CanRead: true, CanWrite: true, Priority: 0},
+			"n1": {CanRead: true, CanWrite: true, Priority: 1},
+		}
+	}
+
+	return planPIndexes
+}
+
+func TestIndexUpdateStickiness(t *testing.T) {
+	indexDefs, nodeDefs := stickinessTestDefs("4")
+	prev := stickinessTestPrevPlan(t, indexDefs, nodeDefs)
+
+	// A params tweak, which doesn't change the partitioning.
+	indexDef := *indexDefs.IndexDefs["i0"]
+	indexDef.UUID = "u1"
+	indexDef.Params = `{"tweak":true}`
+	indexDefs.IndexDefs["i0"] = &indexDef
+
+	next, err := CalcPlan("", indexDefs, nodeDefs,
+		prev, VERSION, "", nil, nil)
+	if err != nil || len(next.PlanPIndexes) != 4 {
+		t.Fatalf("expected a plan, got: %#v, err: %v", next, err)
+	}
+
+	for name, planPIndex := range next.PlanPIndexes {
+		if planPIndex.IndexUUID != "u1" ||
+			planPIndex.IndexParams != indexDef.Params {
+			t.Fatalf("expected the updated index, got: %#v", planPIndex)
+		}
+
+		var prevPlanPIndex *PlanPIndex
+		for _, p := range prev.PlanPIndexes {
+			if p.SourcePartitions == planPIndex.SourcePartitions {
+				prevPlanPIndex = p
+			}
+		}
+		if prevPlanPIndex == nil ||
+			!reflect.DeepEqual(prevPlanPIndex.Nodes, planPIndex.Nodes) {
+			t.Fatalf("expected no moves, prev: %#v, next: %#v",
+				prevPlanPIndex, planPIndex)
+		}
+
+		traces := PlacementTraces(name)
+		if len(traces) == 0 || !traces[0].Sticky {
+			t.Fatalf("expected a sticky trace, got: %#v", traces)
+		}
+	}
+
+	// Without the stickiness, the planner spreads the pindexes.
+	next, err = CalcPlan("", indexDefs, nodeDefs, prev, VERSION, "",
+		map[string]string{INDEX_UPDATE_STICKINESS_OPTION: "false"}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	moved := false
+	for _, planPIndex := range next.PlanPIndexes {
+		for nodeUUID := range planPIndex.Nodes {
+			if nodeUUID != "n0" && nodeUUID != "n1" {
+				moved = true
+			}
+		}
+	}
+	if !moved {
+		t.Fatalf("expected moves without the stickiness, got: %#v", next)
+	}
+}
+
+func TestCaseIndexUpdateSticky(t *testing.T) {
+	indexDefs, nodeDefs := stickinessTestDefs("4")
+	prev := stickinessTestPrevPlan(t, indexDefs, nodeDefs)
+
+	nodeUUIDsAll := []string{"n0", "n1", "n2", "n3"}
+
+	tests := []struct {
+		about             string
+		update            func(indexDef *IndexDef)
+		nodeUUIDsAll      []string
+		nodeUUIDsToRemove []string
+		options           map[string]string
+		expect            bool
+	}{
+		{
+			about:  "params tweak",
+			update: func(indexDef *IndexDef) { indexDef.Params = "{}" },
+			expect: true,
+		},
+		{
+			about:  "not an update",
+			update: func(indexDef *IndexDef) { indexDef.UUID = "u0" },
+			expect: false,
+		},
+		{
+			about:  "stickiness disabled",
+			update: func(indexDef *IndexDef) {},
+			options: map[string]string{
+				INDEX_UPDATE_STICKINESS_OPTION: "false",
+			},
+			expect: false,
+		},
+		{
+			about: "partitions changed",
+			update: func(indexDef *IndexDef) {
+				indexDef.SourceParams = `{"numPartitions":2}`
+			},
+			expect: false,
+		},
+		{
+			about: "pindex partitions changed",
+			update: func(indexDef *IndexDef) {
+				indexDef.PlanParams.MaxPartitionsPerPIndex = 2
+			},
+			expect: false,
+		},
+		{
+			about: "replicas changed",
+			update: func(indexDef *IndexDef) {
+				indexDef.PlanParams.NumReplicas = 0
+			},
+			expect: false,
+		},
+		{
+			about:  "source changed",
+			update: func(indexDef *IndexDef) { indexDef.SourceName = "s1" },
+			expect: false,
+		},
+		{
+			about:             "node removed",
+			update:            func(indexDef *IndexDef) {},
+			nodeUUIDsToRemove: []string{"n1"},
+			expect:            false,
+		},
+		{
+			about:        "node gone",
+			update:       func(indexDef *IndexDef) {},
+			nodeUUIDsAll: []string{"n1", "n2", "n3"},
+			expect:       false,
+		},
+	}
+
+	for _, test := range tests {
+		indexDef := *indexDefs.IndexDefs["i0"]
+		indexDef.UUID = "u1"
+		test.update(&indexDef)
+
+		planPIndexesForIndex, err := SplitIndexDefIntoPlanPIndexes(
+			&indexDef, "", nil, NewPlanPIndexes(VERSION))
+		if err != nil {
+			t.Fatalf("%s, err: %v", test.about, err)
+		}
+
+		nodes := nodeUUIDsAll
+		if test.nodeUUIDsAll != nil {
+			nodes = test.nodeUUIDsAll
+		}
+
+		sticky := CaseIndexUpdateSticky(&indexDef, planPIndexesForIndex,
+			prev, nodes, test.nodeUUIDsToRemove, test.options)
+		if sticky != test.expect {
+			t.Errorf("%s, expected sticky: %v", test.about, test.expect)
+		}
+	}
+}
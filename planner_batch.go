@@ -0,0 +1,86 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// PLANNER_BATCH_WINDOW_OPTION is the manager option of the window, in
+// milliseconds, during which the planner batches the kicks that follow
+// a kick, so that a burst of Cfg changes, such as of many index
+// definitions on a large cluster, results in a single planning and at
+// most a single write of the plans, rather than a write per change.
+const PLANNER_BATCH_WINDOW_OPTION = "plannerBatchWindowMS"
+
+// PlannerBatchWindow is the default planner batch window, where 0
+// means that the kicks aren't batched.
+var PlannerBatchWindow = time.Duration(0)
+
+// PlannerBatchMax is the max number of requests in a planner batch,
+// beyond which the batch is planned before its window elapses.
+var PlannerBatchMax = 10000
+
+// plannerBatchWindow returns the planner batch window, which is 0 or
+// negative when the kicks aren't batched.
+func (mgr *Manager) plannerBatchWindow() time.Duration {
+	v := mgr.GetOption(PLANNER_BATCH_WINDOW_OPTION)
+	if v == "" {
+		return PlannerBatchWindow
+	}
+
+	ms, err := strconv.Atoi(v)
+	if err != nil {
+		log.Warnf("planner_batch: option: %s, err: %v",
+			PLANNER_BATCH_WINDOW_OPTION, err)
+		return PlannerBatchWindow
+	}
+	if ms == 0 {
+		return PlannerBatchWindow
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// plannerBatch returns the kick along with the planner requests that
+// arrive within the planner batch window after the kick, which are
+// then handled in their order after a single planning.
+func (mgr *Manager) plannerBatch(kick *workReq) []*workReq {
+	reqs := []*workReq{kick}
+
+	window := mgr.plannerBatchWindow()
+	if window <= 0 {
+		return reqs
+	}
+
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+
+	for len(reqs) < PlannerBatchMax {
+		select {
+		case m := <-mgr.plannerCh:
+			reqs = append(reqs, m)
+			if m.op == WORK_KICK {
+				atomic.AddUint64(&mgr.stats.TotPlannerKickBatched, 1)
+			}
+
+		case <-timer.C:
+			return reqs
+
+		case <-mgr.stopCh:
+			return reqs
+		}
+	}
+
+	return reqs
+}
@@ -0,0 +1,74 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPlannerBatch(t *testing.T) {
+	cfg := NewCfgMem()
+	mgr := NewManager(VERSION, cfg, NewUUID(), []string{"planner"},
+		"", 1, "", "", "", "", nil)
+	mgr.options = map[string]string{PLANNER_BATCH_WINDOW_OPTION: "300"}
+
+	go mgr.PlannerLoop()
+	defer mgr.Stop()
+
+	// The first kick starts a batch, which the later requests join.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		mgr.PlannerKick("first")
+		wg.Done()
+	}()
+
+	for atomic.LoadUint64(&mgr.stats.TotPlannerKick) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			mgr.PlannerKick("burst")
+			wg.Done()
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		mgr.PlannerNOOP("noop")
+		wg.Done()
+	}()
+	wg.Wait()
+
+	if n := atomic.LoadUint64(&mgr.stats.TotPlannerKickStart); n != 1 {
+		t.Errorf("expected a single planning, got: %d", n)
+	}
+	if n := atomic.LoadUint64(&mgr.stats.TotPlannerKickBatched); n != 5 {
+		t.Errorf("expected 5 batched kicks, got: %d", n)
+	}
+	if n := atomic.LoadUint64(&mgr.stats.TotPlannerOpDone); n != 7 {
+		t.Errorf("expected all the requests done, got: %d", n)
+	}
+
+	// Without a window, each kick is planned.
+	mgr.optionsMutex.Lock()
+	mgr.options = map[string]string{PLANNER_BATCH_WINDOW_OPTION: "-1"}
+	mgr.optionsMutex.Unlock()
+
+	mgr.PlannerKick("unbatched")
+	mgr.PlannerKick("unbatched")
+
+	if n := atomic.LoadUint64(&mgr.stats.TotPlannerKickStart); n != 3 {
+		t.Errorf("expected unbatched plannings, got: %d", n)
+	}
+}
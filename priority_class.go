@@ -0,0 +1,118 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The priority class of an index (see PlanParams.PriorityClass)
+// orders its background work relative to the other indexes, so that
+// business-critical indexes recover first after failures: the janitor
+// starts the pindexes of higher classes first, a rebalance moves the
+// indexes of higher classes first, and the lower classes get smaller
+// shares of the node's limited resources (e.g., the backfill slots
+// and the default query rate limits).
+const (
+	PriorityClassCritical   = "critical"
+	PriorityClassNormal     = "normal"
+	PriorityClassBackground = "background"
+)
+
+// priorityClassRanks are the ranks of the priority classes, where the
+// higher ranks go first, and "" means normal.
+var priorityClassRanks = map[string]int{
+	PriorityClassCritical:   2,
+	PriorityClassNormal:     1,
+	"":                      1,
+	PriorityClassBackground: 0,
+}
+
+// PriorityClassShares are the fractions of a node's limited resources
+// that the indexes of each priority class may use, where a missing
+// class may use all of them.  This should be set only during the
+// init()'ialization phase of the process.
+var PriorityClassShares = map[string]float64{
+	PriorityClassCritical:   1.0,
+	PriorityClassNormal:     1.0,
+	PriorityClassBackground: 0.5,
+}
+
+// ValidatePriorityClass returns an error for an unknown priority
+// class, where "" means normal.
+func ValidatePriorityClass(class string) error {
+	if _, exists := priorityClassRanks[class]; !exists {
+		return fmt.Errorf("priority_class: unknown priority class: %q,"+
+			" expected %q, %q or %q", class, PriorityClassCritical,
+			PriorityClassNormal, PriorityClassBackground)
+	}
+	return nil
+}
+
+// PriorityClassRank returns the rank of a priority class, where the
+// higher ranks go first, and an unknown class ranks as normal.
+func PriorityClassRank(class string) int {
+	if rank, exists := priorityClassRanks[class]; exists {
+		return rank
+	}
+	return priorityClassRanks[PriorityClassNormal]
+}
+
+// PriorityClassShare returns the fraction, in (0, 1], of a node's
+// limited resources that the indexes of a priority class may use.
+func PriorityClassShare(class string) float64 {
+	if class == "" {
+		class = PriorityClassNormal
+	}
+	if share, exists := PriorityClassShares[class]; exists &&
+		share > 0 && share <= 1 {
+		return share
+	}
+	return 1.0
+}
+
+// IndexPriorityClass returns the priority class of an index, per the
+// manager's cached index definitions.
+func (mgr *Manager) IndexPriorityClass(indexName string) string {
+	if mgr.cfg == nil {
+		return ""
+	}
+	_, indexDefsByName, err := mgr.GetIndexDefs(false)
+	if err != nil || indexDefsByName == nil {
+		return ""
+	}
+	if indexDef := indexDefsByName[indexName]; indexDef != nil {
+		return indexDef.PlanParams.PriorityClass
+	}
+	return ""
+}
+
+// SortPlanPIndexesByPriorityClass sorts the planPIndexes by the
+// priority classes of their indexes, highest first, and then by name,
+// where the class of each index is resolved once, up front.
+func SortPlanPIndexesByPriorityClass(planPIndexes []*PlanPIndex,
+	classOf func(indexName string) string) {
+	ranks := map[string]int{} // Keyed by index name.
+	for _, planPIndex := range planPIndexes {
+		if _, exists := ranks[planPIndex.IndexName]; !exists {
+			ranks[planPIndex.IndexName] =
+				PriorityClassRank(classOf(planPIndex.IndexName))
+		}
+	}
+
+	sort.SliceStable(planPIndexes, func(i, j int) bool {
+		ri := ranks[planPIndexes[i].IndexName]
+		rj := ranks[planPIndexes[j].IndexName]
+		if ri != rj {
+			return ri > rj
+		}
+		return planPIndexes[i].Name < planPIndexes[j].Name
+	})
+}
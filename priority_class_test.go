@@ -0,0 +1,138 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPriorityClass(t *testing.T) {
+	for _, class := range []string{"", "critical", "normal", "background"} {
+		if err := ValidatePriorityClass(class); err != nil {
+			t.Errorf("expected class: %q to be valid, err: %v", class, err)
+		}
+	}
+	if err := ValidatePriorityClass("urgent"); err == nil {
+		t.Errorf("expected an unknown class to be invalid")
+	}
+
+	if PriorityClassRank("") != PriorityClassRank(PriorityClassNormal) ||
+		PriorityClassRank("urgent") != PriorityClassRank(PriorityClassNormal) ||
+		PriorityClassRank(PriorityClassCritical) <=
+			PriorityClassRank(PriorityClassNormal) ||
+		PriorityClassRank(PriorityClassBackground) >=
+			PriorityClassRank(PriorityClassNormal) {
+		t.Errorf("unexpected ranks")
+	}
+
+	if PriorityClassShare("") != 1.0 ||
+		PriorityClassShare(PriorityClassBackground) != 0.5 {
+		t.Errorf("unexpected shares")
+	}
+
+	classes := map[string]string{"a": "background", "b": "critical"}
+	planPIndexes := []*PlanPIndex{
+		{Name: "a_0", IndexName: "a"},
+		{Name: "c_1", IndexName: "c"},
+		{Name: "b_0", IndexName: "b"},
+		{Name: "c_0", IndexName: "c"},
+	}
+	lookups := 0
+	SortPlanPIndexesByPriorityClass(planPIndexes, func(indexName string) string {
+		lookups++
+		return classes[indexName]
+	})
+	if lookups != 3 {
+		t.Errorf("expected a lookup per index, got: %d", lookups)
+	}
+
+	var names []string
+	for _, planPIndex := range planPIndexes {
+		names = append(names, planPIndex.Name)
+	}
+	if exp := []string{"b_0", "c_0", "c_1", "a_0"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("expected order: %v, got: %v", exp, names)
+	}
+}
+
+func TestPriorityClassQueryAdmission(t *testing.T) {
+	th := NewQueryThrottle(QueryRateLimits{
+		Default: &QueryRateLimit{QPS: 0.001, Burst: 4},
+		Tenants: map[string]QueryRateLimit{"t0": {QPS: 0.001, Burst: 3}},
+	})
+
+	admitted := func(pindex *PIndex, class string) int {
+		rv := 0
+		for i := 0; i < 10; i++ {
+			if th.AdmitClass(pindex, class) == nil {
+				rv++
+			}
+		}
+		return rv
+	}
+
+	for _, c := range []struct {
+		class  string
+		tenant string
+		exp    int
+	}{
+		{"", "", 4},
+		{PriorityClassCritical, "", 4},
+		{PriorityClassCritical, "t0", 3},
+		{PriorityClassBackground, "", 2},
+	} {
+		name := c.class + "_" + c.tenant
+		pindex := &PIndex{Name: "p_" + name, IndexName: "i_" + name,
+			SourceName: c.tenant}
+		if n := admitted(pindex, c.class); n != c.exp {
+			t.Errorf("class: %q, tenant: %q, expected: %d, got: %d",
+				c.class, c.tenant, c.exp, n)
+		}
+	}
+
+	// The admin can exempt the critical indexes from the tenant and
+	// default limits, but not from their own index limits.
+	th.SetLimits(QueryRateLimits{
+		Default:        &QueryRateLimit{QPS: 0.001, Burst: 4},
+		Indexes:        map[string]QueryRateLimit{"i_x": {QPS: 0.001, Burst: 1}},
+		CriticalExempt: true,
+	})
+	if n := admitted(&PIndex{Name: "p_c", IndexName: "i_c"},
+		PriorityClassCritical); n != 10 {
+		t.Errorf("expected the exempt critical index to be admitted, got: %d", n)
+	}
+	if n := admitted(&PIndex{Name: "p_x", IndexName: "i_x"},
+		PriorityClassCritical); n != 1 {
+		t.Errorf("expected the index limit to hold, got: %d", n)
+	}
+}
+
+func TestPriorityClassBackfillShare(t *testing.T) {
+	th := NewBackfillThrottle(1, 4)
+
+	for i := 0; i < 2; i++ {
+		if err := th.AcquireShare(nil, 0.5); err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+
+	// The background share is used up, but the others can go on.
+	closeCh := make(chan struct{})
+	close(closeCh)
+	if err := th.AcquireShare(closeCh, 0.5); err != ErrBackfillThrottleClosed {
+		t.Fatalf("expected the background share to be used up, err: %v", err)
+	}
+	if err := th.Acquire(closeCh); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if th.Inflight() != 3 {
+		t.Fatalf("expected 3 inflight, got: %d", th.Inflight())
+	}
+}
@@ -0,0 +1,122 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"sort"
+	"time"
+)
+
+// QueryPartitionSeq is the last processed seq of a source partition.
+type QueryPartitionSeq struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time,omitempty"` // When the seq was processed.
+}
+
+// QueryCoverage is the standard metadata that a scatter/gather query
+// attaches to its response, so that applications can reason about
+// the completeness and the freshness of the results.
+type QueryCoverage struct {
+	PartitionsTotal   int      `json:"partitionsTotal"`
+	PartitionsQueried int      `json:"partitionsQueried"`
+	PartitionsMissing []string `json:"partitionsMissing,omitempty"`
+
+	// ReplicasUsed is true when any of the partitions were served by
+	// a replica rather than by the primary.
+	ReplicasUsed bool `json:"replicasUsed"`
+
+	// LastProcessed is keyed by pindex name and then by source
+	// partition, for the pindexes whose Dest is a DestLastProcessed.
+	LastProcessed map[string]map[string]QueryPartitionSeq `json:"lastProcessed,omitempty"`
+}
+
+// Complete returns true when all of the partitions were queried.
+func (c *QueryCoverage) Complete() bool {
+	return c.PartitionsQueried >= c.PartitionsTotal &&
+		len(c.PartitionsMissing) == 0
+}
+
+// QueryCoverage returns the QueryCoverage of a query that's scattered
+// across the pindexes from CoveringPIndexesEx().  Only the local
+// pindexes contribute to LastProcessed, as the remote nodes are
+// expected to return their own QueryCoverage, which is to be folded in
+// with MergeRemote().
+func (mgr *Manager) QueryCoverage(localPIndexes []*PIndex,
+	remotePlanPIndexes []*RemotePlanPIndex,
+	missingPIndexNames []string) *QueryCoverage {
+	rv := &QueryCoverage{
+		PartitionsTotal: len(localPIndexes) + len(remotePlanPIndexes) +
+			len(missingPIndexNames),
+		PartitionsQueried: len(localPIndexes) + len(remotePlanPIndexes),
+	}
+
+	if len(missingPIndexNames) > 0 {
+		rv.PartitionsMissing = append([]string(nil), missingPIndexNames...)
+		sort.Strings(rv.PartitionsMissing)
+	}
+
+	var planPIndexes *PlanPIndexes
+	if len(localPIndexes) > 0 {
+		planPIndexes, _, _ = mgr.GetPlanPIndexes(false)
+	}
+
+	selfUUID := mgr.UUID()
+
+	for _, pindex := range localPIndexes {
+		if pindex == nil {
+			continue
+		}
+
+		if planPIndexes != nil {
+			planPIndex := planPIndexes.PlanPIndexes[pindex.Name]
+			if planPIndex != nil && planPIndex.Nodes[selfUUID] != nil &&
+				planPIndex.Nodes[selfUUID].Priority > 0 {
+				rv.ReplicasUsed = true
+			}
+		}
+
+		if d, ok := pindex.Dest.(DestLastProcessed); ok {
+			if rv.LastProcessed == nil {
+				rv.LastProcessed = map[string]map[string]QueryPartitionSeq{}
+			}
+			rv.LastProcessed[pindex.Name] = d.LastProcessed()
+		}
+	}
+
+	for _, remote := range remotePlanPIndexes {
+		if remote.PlanPIndex == nil || remote.NodeDef == nil {
+			continue
+		}
+
+		planPIndexNode := remote.PlanPIndex.Nodes[remote.NodeDef.UUID]
+		if planPIndexNode != nil && planPIndexNode.Priority > 0 {
+			rv.ReplicasUsed = true
+		}
+	}
+
+	return rv
+}
+
+// MergeRemote folds in the QueryCoverage returned by a remote node for
+// its share of a scatter/gather query.  The partition counts are not
+// merged, as they were already accounted for by the coordinating node.
+func (c *QueryCoverage) MergeRemote(remote *QueryCoverage) {
+	if remote == nil {
+		return
+	}
+
+	c.ReplicasUsed = c.ReplicasUsed || remote.ReplicasUsed
+
+	for pindexName, seqs := range remote.LastProcessed {
+		if c.LastProcessed == nil {
+			c.LastProcessed = map[string]map[string]QueryPartitionSeq{}
+		}
+		c.LastProcessed[pindexName] = seqs
+	}
+}
@@ -0,0 +1,82 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testLastProcessedDest struct {
+	TestDest
+	seqs map[string]QueryPartitionSeq
+}
+
+func (d *testLastProcessedDest) LastProcessed() map[string]QueryPartitionSeq {
+	return d.seqs
+}
+
+func TestQueryCoverage(t *testing.T) {
+	cfg := NewCfgMem()
+	mgr := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	planPIndexes := NewPlanPIndexes(VERSION)
+	planPIndexes.PlanPIndexes["p0"] = &PlanPIndex{
+		Name: "p0", IndexName: "idx",
+		Nodes: map[string]*PlanPIndexNode{
+			"other":    {Priority: 0},
+			mgr.UUID(): {Priority: 1},
+		},
+	}
+	planPIndexes.PlanPIndexes["p1"] = &PlanPIndex{
+		Name: "p1", IndexName: "idx",
+		Nodes: map[string]*PlanPIndexNode{
+			"other": {Priority: 0},
+		},
+	}
+	if _, err := CfgSetPlanPIndexes(cfg, planPIndexes, 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	mgr.GetPlanPIndexes(true)
+
+	seqs := map[string]QueryPartitionSeq{"0": {Seq: 10}}
+	local := []*PIndex{{
+		Name: "p0", Dest: &testLastProcessedDest{seqs: seqs},
+	}}
+	remote := []*RemotePlanPIndex{{
+		PlanPIndex: planPIndexes.PlanPIndexes["p1"],
+		NodeDef:    &NodeDef{UUID: "other"},
+	}}
+
+	c := mgr.QueryCoverage(local, remote, []string{"p2"})
+	if c.PartitionsTotal != 3 || c.PartitionsQueried != 2 ||
+		!reflect.DeepEqual(c.PartitionsMissing, []string{"p2"}) ||
+		!c.ReplicasUsed || c.Complete() {
+		t.Fatalf("unexpected coverage: %+v", c)
+	}
+	if !reflect.DeepEqual(c.LastProcessed["p0"], seqs) {
+		t.Fatalf("unexpected last processed: %+v", c.LastProcessed)
+	}
+
+	// Only the primary of p1 is queried.
+	c = mgr.QueryCoverage(nil, remote, nil)
+	if c.ReplicasUsed || !c.Complete() {
+		t.Fatalf("unexpected coverage: %+v", c)
+	}
+
+	c.MergeRemote(&QueryCoverage{
+		PartitionsTotal: 1, PartitionsQueried: 1, ReplicasUsed: true,
+		LastProcessed: map[string]map[string]QueryPartitionSeq{"p1": seqs},
+	})
+	if c.PartitionsTotal != 1 || !c.ReplicasUsed ||
+		!reflect.DeepEqual(c.LastProcessed["p1"], seqs) {
+		t.Fatalf("unexpected merged coverage: %+v", c)
+	}
+}
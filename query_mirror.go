@@ -0,0 +1,321 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// A query mirror validates a replacement index, such as the "green"
+// index of a blue/green migration, before the cutover to it.  A
+// fraction of the live queries of the source index is replayed on the
+// target index in the background, where the target's responses are
+// discarded, or compared with the source's responses, and the
+// comparisons and latencies are reported in the mirror's stats.
+
+// QUERY_MIRRORS_KEY is the Cfg key of the query mirrors.
+const QUERY_MIRRORS_KEY = "queryMirrors"
+
+// QueryMirrorMaxInFlight is the max number of mirrored queries that
+// are in flight on a node, beyond which the mirrored queries are
+// dropped, so that the mirroring doesn't hold up the live queries.
+var QueryMirrorMaxInFlight = 16
+
+// QueryMirrorMaxCompareBytes is the max size of the responses that are
+// compared, where the larger responses are only counted as mirrored.
+var QueryMirrorMaxCompareBytes = 1024 * 1024
+
+// QueryMirrorIgnoredKeys are the top-level keys of the JSON responses
+// that are ignored by the default comparison, as they differ between
+// any two queries, such as their timings.
+var QueryMirrorIgnoredKeys = []string{"took", "request", "status"}
+
+// QueryMirrorCompareHook returns true when the responses of the source
+// and target indexes of a mirrored query are equivalent.  It defaults
+// to a comparison of the JSON responses, without the
+// QueryMirrorIgnoredKeys, and may be overridden by the applications,
+// such as to ignore the scores of the hits.
+var QueryMirrorCompareHook = func(mirror *QueryMirrorDef,
+	sourceResp, targetResp []byte) bool {
+	if bytes.Equal(sourceResp, targetResp) {
+		return true
+	}
+
+	var s, t map[string]interface{}
+	if json.Unmarshal(sourceResp, &s) != nil ||
+		json.Unmarshal(targetResp, &t) != nil {
+		return false
+	}
+
+	for _, k := range QueryMirrorIgnoredKeys {
+		delete(s, k)
+		delete(t, k)
+	}
+
+	return reflect.DeepEqual(s, t)
+}
+
+// A QueryMirrorDef mirrors a fraction of the queries of the source
+// index to the target index.
+type QueryMirrorDef struct {
+	SourceIndex string `json:"sourceIndex"`
+	TargetIndex string `json:"targetIndex"`
+
+	// Fraction of the source's queries that are mirrored, from 0 to 1.
+	Fraction float64 `json:"fraction"`
+
+	// Compare the target's responses with the source's responses,
+	// instead of discarding them.
+	Compare bool `json:"compare,omitempty"`
+}
+
+// QueryMirrors is the Cfg value of the query mirrors.
+type QueryMirrors struct {
+	UUID        string                     `json:"uuid"`
+	ImplVersion string                     `json:"implVersion"`
+	Mirrors     map[string]*QueryMirrorDef `json:"mirrors"` // Keyed by source index.
+}
+
+// QueryMirrorStats are the stats of the mirrored queries of a source
+// index on a node.
+type QueryMirrorStats struct {
+	TotMirrored   uint64 `json:"totMirrored"`
+	TotDropped    uint64 `json:"totDropped"` // Over QueryMirrorMaxInFlight.
+	TotErr        uint64 `json:"totErr"`     // Of the target queries.
+	TotCompared   uint64 `json:"totCompared"`
+	TotMatched    uint64 `json:"totMatched"`
+	TotMismatched uint64 `json:"totMismatched"`
+
+	TotSourceTimeNS uint64 `json:"totSourceTimeNS"`
+	TotTargetTimeNS uint64 `json:"totTargetTimeNS"`
+}
+
+// CfgGetQueryMirrors retrieves the query mirrors from a Cfg provider,
+// which is nil when there are none.
+func CfgGetQueryMirrors(cfg Cfg) (*QueryMirrors, uint64, error) {
+	v, cas, err := cfg.Get(QUERY_MIRRORS_KEY, 0)
+	if err != nil {
+		return nil, cas, err
+	}
+	if v == nil {
+		return nil, cas, nil
+	}
+	rv := &QueryMirrors{}
+	err = UnmarshalJSON(v, rv)
+	if err != nil {
+		return nil, cas, err
+	}
+	if rv.Mirrors == nil {
+		rv.Mirrors = map[string]*QueryMirrorDef{}
+	}
+	return rv, cas, nil
+}
+
+// CfgSetQueryMirrors updates the query mirrors on a Cfg provider.
+func CfgSetQueryMirrors(cfg Cfg, mirrors *QueryMirrors,
+	cas uint64) (uint64, error) {
+	buf, err := MarshalJSON(mirrors)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Set(QUERY_MIRRORS_KEY, buf, cas)
+}
+
+// CfgSetQueryMirror creates or replaces the query mirror of a source
+// index, where a nil mirror deletes it.
+func CfgSetQueryMirror(cfg Cfg, version, sourceIndex string,
+	mirror *QueryMirrorDef) error {
+	if sourceIndex == "" {
+		return fmt.Errorf("query_mirror: source index is required")
+	}
+
+	if mirror != nil {
+		mirror.SourceIndex = sourceIndex
+
+		if mirror.TargetIndex == "" || mirror.TargetIndex == sourceIndex {
+			return fmt.Errorf("query_mirror: sourceIndex: %s,"+
+				" invalid targetIndex: %q", sourceIndex, mirror.TargetIndex)
+		}
+		if mirror.Fraction < 0 || mirror.Fraction > 1 {
+			return fmt.Errorf("query_mirror: sourceIndex: %s,"+
+				" fraction: %v is not from 0 to 1", sourceIndex, mirror.Fraction)
+		}
+	}
+
+	return RetryOnCASMismatch(func() error {
+		mirrors, cas, err := CfgGetQueryMirrors(cfg)
+		if err != nil {
+			return err
+		}
+		if mirrors == nil {
+			mirrors = &QueryMirrors{Mirrors: map[string]*QueryMirrorDef{}}
+		}
+
+		if mirror == nil {
+			if mirrors.Mirrors[sourceIndex] == nil {
+				return nil
+			}
+			delete(mirrors.Mirrors, sourceIndex)
+		} else {
+			mirrors.Mirrors[sourceIndex] = mirror
+		}
+
+		mirrors.UUID = NewUUID()
+		mirrors.ImplVersion = version
+
+		_, err = CfgSetQueryMirrors(cfg, mirrors, cas)
+		return err
+	}, 100)
+}
+
+// ------------------------------------------------------------------------
+
+// queryMirrorState is the state of the query mirrors of a Manager.
+type queryMirrorState struct {
+	m     sync.Mutex
+	stats map[string]*QueryMirrorStats // Keyed by source index.
+
+	inFlight int64
+}
+
+// SampleQueryMirror returns the query mirror of the index, if any,
+// when a query of the index is selected to be mirrored.
+func (mgr *Manager) SampleQueryMirror(indexName string) *QueryMirrorDef {
+	if mgr.cfg == nil {
+		return nil
+	}
+
+	mirrors, _, err := CfgGetQueryMirrors(mgr.cfg)
+	if err != nil || mirrors == nil {
+		return nil
+	}
+
+	mirror := mirrors.Mirrors[indexName]
+	if mirror == nil || mirror.Fraction <= 0 ||
+		(mirror.Fraction < 1 && rand.Float64() >= mirror.Fraction) {
+		return nil
+	}
+
+	return mirror
+}
+
+// queryMirrorStats returns the stats of a source index, creating them
+// when needed.
+func (mgr *Manager) queryMirrorStats(sourceIndex string) *QueryMirrorStats {
+	mgr.queryMirrors.m.Lock()
+	defer mgr.queryMirrors.m.Unlock()
+
+	if mgr.queryMirrors.stats == nil {
+		mgr.queryMirrors.stats = map[string]*QueryMirrorStats{}
+	}
+
+	stats := mgr.queryMirrors.stats[sourceIndex]
+	if stats == nil {
+		stats = &QueryMirrorStats{}
+		mgr.queryMirrors.stats[sourceIndex] = stats
+	}
+
+	return stats
+}
+
+// MirrorQuery replays a query of the mirror's source index on its
+// target index in the background, where the sourceResp, which may be
+// nil when it's not compared, is the source index's response that
+// took the sourceDuration.
+func (mgr *Manager) MirrorQuery(mirror *QueryMirrorDef, req []byte,
+	sourceResp []byte, sourceDuration time.Duration) {
+	stats := mgr.queryMirrorStats(mirror.SourceIndex)
+
+	if atomic.AddInt64(&mgr.queryMirrors.inFlight, 1) >
+		int64(QueryMirrorMaxInFlight) {
+		atomic.AddInt64(&mgr.queryMirrors.inFlight, -1)
+		atomic.AddUint64(&stats.TotDropped, 1)
+		return
+	}
+
+	go func() {
+		defer atomic.AddInt64(&mgr.queryMirrors.inFlight, -1)
+
+		atomic.AddUint64(&stats.TotMirrored, 1)
+		atomic.AddUint64(&stats.TotSourceTimeNS, uint64(sourceDuration))
+
+		var targetResp bytes.Buffer
+
+		startTime := time.Now()
+		err := mgr.queryMirrorTarget(mirror, req, &targetResp)
+		atomic.AddUint64(&stats.TotTargetTimeNS,
+			uint64(time.Since(startTime)))
+		if err != nil {
+			atomic.AddUint64(&stats.TotErr, 1)
+			log.Warnf("query_mirror: sourceIndex: %s, targetIndex: %s,"+
+				" err: %v", mirror.SourceIndex, mirror.TargetIndex, err)
+			return
+		}
+
+		if !mirror.Compare || sourceResp == nil ||
+			len(sourceResp) > QueryMirrorMaxCompareBytes ||
+			targetResp.Len() > QueryMirrorMaxCompareBytes {
+			return
+		}
+
+		atomic.AddUint64(&stats.TotCompared, 1)
+
+		if QueryMirrorCompareHook(mirror, sourceResp, targetResp.Bytes()) {
+			atomic.AddUint64(&stats.TotMatched, 1)
+		} else {
+			atomic.AddUint64(&stats.TotMismatched, 1)
+		}
+	}()
+}
+
+func (mgr *Manager) queryMirrorTarget(mirror *QueryMirrorDef,
+	req []byte, w *bytes.Buffer) error {
+	_, pindexImplType, err := mgr.GetIndexDef(mirror.TargetIndex, false)
+	if err != nil {
+		return err
+	}
+	if pindexImplType == nil || pindexImplType.Query == nil {
+		return fmt.Errorf("query_mirror: targetIndex: %s, not queryable",
+			mirror.TargetIndex)
+	}
+
+	return pindexImplType.Query(mgr, mirror.TargetIndex, "", req, w)
+}
+
+// QueryMirrorStatsSnapshot returns a copy of the stats of the mirrored
+// queries on this node, keyed by source index.
+func (mgr *Manager) QueryMirrorStatsSnapshot() map[string]*QueryMirrorStats {
+	mgr.queryMirrors.m.Lock()
+	defer mgr.queryMirrors.m.Unlock()
+
+	rv := make(map[string]*QueryMirrorStats, len(mgr.queryMirrors.stats))
+	for name, stats := range mgr.queryMirrors.stats {
+		rv[name] = &QueryMirrorStats{
+			TotMirrored:     atomic.LoadUint64(&stats.TotMirrored),
+			TotDropped:      atomic.LoadUint64(&stats.TotDropped),
+			TotErr:          atomic.LoadUint64(&stats.TotErr),
+			TotCompared:     atomic.LoadUint64(&stats.TotCompared),
+			TotMatched:      atomic.LoadUint64(&stats.TotMatched),
+			TotMismatched:   atomic.LoadUint64(&stats.TotMismatched),
+			TotSourceTimeNS: atomic.LoadUint64(&stats.TotSourceTimeNS),
+			TotTargetTimeNS: atomic.LoadUint64(&stats.TotTargetTimeNS),
+		}
+	}
+
+	return rv
+}
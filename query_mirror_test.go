@@ -0,0 +1,124 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCfgSetQueryMirror(t *testing.T) {
+	cfg := NewCfgMem()
+
+	for _, mirror := range []*QueryMirrorDef{
+		{TargetIndex: ""},
+		{TargetIndex: "i0"},
+		{TargetIndex: "i1", Fraction: 1.5},
+	} {
+		if err := CfgSetQueryMirror(cfg, VERSION, "i0", mirror); err == nil {
+			t.Fatalf("expected an err, mirror: %#v", mirror)
+		}
+	}
+
+	err := CfgSetQueryMirror(cfg, VERSION, "i0",
+		&QueryMirrorDef{TargetIndex: "i1", Fraction: 0.5})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mirrors, _, err := CfgGetQueryMirrors(cfg)
+	if err != nil || mirrors.Mirrors["i0"] == nil ||
+		mirrors.Mirrors["i0"].SourceIndex != "i0" {
+		t.Fatalf("unexpected mirrors: %#v, err: %v", mirrors, err)
+	}
+
+	err = CfgSetQueryMirror(cfg, VERSION, "i0", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mirrors, _, _ = CfgGetQueryMirrors(cfg)
+	if len(mirrors.Mirrors) != 0 {
+		t.Fatalf("expected no mirrors, got: %#v", mirrors)
+	}
+}
+
+func TestQueryMirrorCompareHook(t *testing.T) {
+	if !QueryMirrorCompareHook(nil,
+		[]byte(`{"hits":[1,2],"took":10}`), []byte(`{"took":20,"hits":[1,2]}`)) {
+		t.Fatalf("expected the ignored keys to match")
+	}
+	if QueryMirrorCompareHook(nil,
+		[]byte(`{"hits":[1,2]}`), []byte(`{"hits":[2,1]}`)) {
+		t.Fatalf("expected a mismatch")
+	}
+	if QueryMirrorCompareHook(nil, []byte(`x`), []byte(`y`)) {
+		t.Fatalf("expected a mismatch of invalid JSON")
+	}
+}
+
+func TestMirrorQuery(t *testing.T) {
+	var queried int32
+	PIndexImplTypes["testMirror"] = &PIndexImplType{
+		Query: func(mgr *Manager, indexName, indexUUID string,
+			req []byte, res io.Writer) error {
+			atomic.AddInt32(&queried, 1)
+			res.Write([]byte(`{"hits":["` + string(req) + `"],"took":1}`))
+			return nil
+		},
+	}
+	defer delete(PIndexImplTypes, "testMirror")
+
+	cfg := NewCfgMem()
+	indexDefs := NewIndexDefs(VERSION)
+	indexDefs.IndexDefs["i1"] = &IndexDef{Name: "i1", Type: "testMirror"}
+	_, err := CfgSetIndexDefs(cfg, indexDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mgr := NewManager(VERSION, cfg, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	if mgr.SampleQueryMirror("i0") != nil {
+		t.Fatalf("expected no mirror")
+	}
+
+	err = CfgSetQueryMirror(cfg, VERSION, "i0",
+		&QueryMirrorDef{TargetIndex: "i1", Fraction: 1, Compare: true})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mirror := mgr.SampleQueryMirror("i0")
+	if mirror == nil {
+		t.Fatalf("expected a mirror")
+	}
+
+	mgr.MirrorQuery(mirror, []byte("a"),
+		[]byte(`{"hits":["a"],"took":5}`), time.Millisecond)
+	mgr.MirrorQuery(mirror, []byte("b"),
+		[]byte(`{"hits":["a"],"took":5}`), time.Millisecond)
+
+	var stats *QueryMirrorStats
+	for i := 0; i < 100; i++ {
+		stats = mgr.QueryMirrorStatsSnapshot()["i0"]
+		if stats != nil && stats.TotMatched+stats.TotMismatched == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if stats == nil || stats.TotMirrored != 2 || stats.TotMatched != 1 ||
+		stats.TotMismatched != 1 || atomic.LoadInt32(&queried) != 2 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+}
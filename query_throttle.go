@@ -0,0 +1,332 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// QUERY_RATE_LIMITS_OPTION is the manager option that holds the JSON
+// QueryRateLimits of the node's pindexes.  An absent option means
+// unlimited.
+const QUERY_RATE_LIMITS_OPTION = "queryRateLimits"
+
+// ErrQueryThrottled is the error that a QueryThrottledError wraps.
+var ErrQueryThrottled = fmt.Errorf("query: throttled")
+
+// A QueryRateLimit is the limit of the queries per second that each
+// pindex accepts, with a burst of up to Burst queries, which defaults
+// to the QPS rounded up.  A QPS <= 0 means unlimited.
+type QueryRateLimit struct {
+	QPS   float64 `json:"qps"`
+	Burst int     `json:"burst,omitempty"`
+}
+
+// QueryRateLimits are the per pindex query rate limits, where the
+// limit of an index takes precedence over the limit of its tenant,
+// which takes precedence over the default limit.  The indexes of every
+// priority class are held to the tenant and default limits, unless the
+// admin sets CriticalExempt, which exempts the critical indexes from
+// all but their own index limits.
+type QueryRateLimits struct {
+	Default *QueryRateLimit           `json:"default,omitempty"`
+	Indexes map[string]QueryRateLimit `json:"indexes,omitempty"`
+	Tenants map[string]QueryRateLimit `json:"tenants,omitempty"`
+
+	CriticalExempt bool `json:"criticalExempt,omitempty"`
+}
+
+// QueryTenantHook returns the tenant of a pindex for its query rate
+// limits, which defaults to the source name (e.g., the bucket) of the
+// pindex.
+var QueryTenantHook = func(pindex *PIndex) string {
+	return pindex.SourceName
+}
+
+// A QueryThrottledError is returned when a query of a pindex exceeds
+// its query rate limit, and is surfaced to the query coordinator as
+// a QueryThrottledResponse.
+type QueryThrottledError struct {
+	PIndexName string         `json:"pindexName"`
+	IndexName  string         `json:"indexName"`
+	Tenant     string         `json:"tenant"`
+	Limit      QueryRateLimit `json:"limit"`
+	RetryAfter time.Duration  `json:"-"`
+}
+
+func (e *QueryThrottledError) Error() string {
+	return fmt.Sprintf("query: throttled, pindex: %s, index: %s,"+
+		" tenant: %s, qps: %g, retryAfter: %v",
+		e.PIndexName, e.IndexName, e.Tenant, e.Limit.QPS, e.RetryAfter)
+}
+
+func (e *QueryThrottledError) Unwrap() error {
+	return ErrQueryThrottled
+}
+
+// QueryThrottledResponse is the JSON response body of a query that
+// was throttled, with a http.StatusTooManyRequests status.
+type QueryThrottledResponse struct {
+	Status       string `json:"status"` // "throttled".
+	Error        string `json:"error"`
+	RetryAfterMS int64  `json:"retryAfterMs"`
+
+	*QueryThrottledError
+}
+
+// NewQueryThrottledResponse returns the response of a throttled query.
+func NewQueryThrottledResponse(e *QueryThrottledError) *QueryThrottledResponse {
+	return &QueryThrottledResponse{
+		Status:              "throttled",
+		Error:               e.Error(),
+		RetryAfterMS:        e.RetryAfter.Milliseconds(),
+		QueryThrottledError: e,
+	}
+}
+
+// ParseQueryThrottledResponse allows a query coordinator to detect
+// that a remote pindex throttled a query, returning the remote error.
+func ParseQueryThrottledResponse(statusCode int,
+	body []byte) (*QueryThrottledError, bool) {
+	if statusCode != http.StatusTooManyRequests {
+		return nil, false
+	}
+	rv := &QueryThrottledResponse{}
+	err := json.Unmarshal(body, rv)
+	if err != nil || rv.Status != "throttled" || rv.QueryThrottledError == nil {
+		return nil, false
+	}
+	rv.QueryThrottledError.RetryAfter =
+		time.Duration(rv.RetryAfterMS) * time.Millisecond
+	return rv.QueryThrottledError, true
+}
+
+// ------------------------------------------------------------------------
+
+// A QueryThrottle enforces the query rate limits of a node's pindexes,
+// with a token bucket per pindex.
+type QueryThrottle struct {
+	m       sync.Mutex
+	limits  QueryRateLimits
+	buckets map[string]*queryTokenBucket // Keyed by pindex name.
+
+	TotAdmitted  uint64
+	TotThrottled uint64
+}
+
+type queryTokenBucket struct {
+	limit QueryRateLimit
+	avail float64
+	last  time.Time
+}
+
+// NewQueryThrottle returns a QueryThrottle with the given limits.
+func NewQueryThrottle(limits QueryRateLimits) *QueryThrottle {
+	return &QueryThrottle{
+		limits:  limits,
+		buckets: map[string]*queryTokenBucket{},
+	}
+}
+
+// SetLimits changes the limits, which resets the token buckets.
+func (t *QueryThrottle) SetLimits(limits QueryRateLimits) {
+	t.m.Lock()
+	t.limits = limits
+	t.buckets = map[string]*queryTokenBucket{}
+	t.m.Unlock()
+}
+
+// Limits returns the current limits.
+func (t *QueryThrottle) Limits() QueryRateLimits {
+	t.m.Lock()
+	limits := t.limits
+	t.m.Unlock()
+	return limits
+}
+
+func (t *QueryThrottle) limitLOCKED(indexName, tenant, class string) (
+	QueryRateLimit, bool) {
+	if l, exists := t.limits.Indexes[indexName]; exists {
+		return l, true
+	}
+	if class == PriorityClassCritical && t.limits.CriticalExempt {
+		return QueryRateLimit{}, false
+	}
+
+	var l QueryRateLimit
+	if tl, exists := t.limits.Tenants[tenant]; exists {
+		l = tl
+	} else if t.limits.Default != nil {
+		l = *t.limits.Default
+	} else {
+		return QueryRateLimit{}, false
+	}
+
+	if share := PriorityClassShare(class); share < 1 && l.QPS > 0 {
+		if l.Burst <= 0 {
+			l.Burst = int(math.Ceil(l.QPS))
+		}
+		l.QPS *= share
+		l.Burst = int(math.Ceil(float64(l.Burst) * share))
+	}
+
+	return l, true
+}
+
+// Admit takes a token for a query of the pindex, or returns a
+// QueryThrottledError when the pindex is over its limit.
+func (t *QueryThrottle) Admit(pindex *PIndex) error {
+	return t.AdmitClass(pindex, "")
+}
+
+// AdmitClass is like Admit for a pindex of an index of the given
+// priority class, where the tenant and default limits are scaled by
+// the class's PriorityClassShare, and the queries of a critical index
+// are only limited by the limit of the index itself when the limits
+// are CriticalExempt.
+func (t *QueryThrottle) AdmitClass(pindex *PIndex, class string) error {
+	tenant := ""
+	if QueryTenantHook != nil {
+		tenant = QueryTenantHook(pindex)
+	}
+
+	t.m.Lock()
+
+	limit, exists := t.limitLOCKED(pindex.IndexName, tenant, class)
+	if !exists || limit.QPS <= 0 {
+		t.m.Unlock()
+		atomic.AddUint64(&t.TotAdmitted, 1)
+		return nil
+	}
+
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Ceil(limit.QPS)
+	}
+
+	now := time.Now()
+
+	b := t.buckets[pindex.Name]
+	if b == nil || b.limit != limit {
+		b = &queryTokenBucket{limit: limit, avail: burst, last: now}
+		t.buckets[pindex.Name] = b
+	}
+
+	b.avail += now.Sub(b.last).Seconds() * limit.QPS
+	if b.avail > burst {
+		b.avail = burst
+	}
+	b.last = now
+
+	if b.avail >= 1 {
+		b.avail--
+		t.m.Unlock()
+		atomic.AddUint64(&t.TotAdmitted, 1)
+		return nil
+	}
+
+	retryAfter := time.Duration((1 - b.avail) / limit.QPS * float64(time.Second))
+	t.m.Unlock()
+
+	atomic.AddUint64(&t.TotThrottled, 1)
+
+	return &QueryThrottledError{
+		PIndexName: pindex.Name,
+		IndexName:  pindex.IndexName,
+		Tenant:     tenant,
+		Limit:      limit,
+		RetryAfter: retryAfter,
+	}
+}
+
+// Forget drops the token bucket of a pindex, such as when the pindex
+// is removed.
+func (t *QueryThrottle) Forget(pindexName string) {
+	t.m.Lock()
+	delete(t.buckets, pindexName)
+	t.m.Unlock()
+}
+
+// ------------------------------------------------------------------------
+
+// QueryThrottle returns the node's query throttle of its pindexes.
+func (mgr *Manager) QueryThrottle() *QueryThrottle {
+	return mgr.queryThrottle
+}
+
+// AdmitPIndexQuery returns a QueryThrottledError when a query of the
+// pindex exceeds its query rate limit, and should be called by the
+// server side of pindex queries before querying the pindex.
+func (mgr *Manager) AdmitPIndexQuery(pindex *PIndex) error {
+	if mgr.queryThrottle == nil || pindex == nil {
+		return nil
+	}
+	err := mgr.queryThrottle.AdmitClass(pindex,
+		mgr.IndexPriorityClass(pindex.IndexName))
+	if err != nil {
+		atomic.AddUint64(&mgr.stats.TotQueryThrottled, 1)
+	}
+	return err
+}
+
+// queryRateLimitsOption returns the QueryRateLimits from the given
+// manager options.
+func queryRateLimitsOption(options map[string]string) (
+	QueryRateLimits, error) {
+	var limits QueryRateLimits
+	if v := options[QUERY_RATE_LIMITS_OPTION]; v != "" {
+		err := UnmarshalJSON([]byte(v), &limits)
+		if err != nil {
+			return QueryRateLimits{}, fmt.Errorf("query_throttle: invalid"+
+				" option: %s, err: %v", QUERY_RATE_LIMITS_OPTION, err)
+		}
+	}
+	return limits, nil
+}
+
+func newQueryThrottleFromOptions(options map[string]string) *QueryThrottle {
+	limits, err := queryRateLimitsOption(options)
+	if err != nil {
+		log.Warnf("manager: %v", err)
+	}
+	return NewQueryThrottle(limits)
+}
+
+// refreshQueryRateLimits applies the current value of the
+// queryRateLimits option to the query throttle.
+func (mgr *Manager) refreshQueryRateLimits(options map[string]string) {
+	if mgr.queryThrottle == nil {
+		return
+	}
+
+	limits, err := queryRateLimitsOption(options)
+	if err != nil {
+		log.Warnf("manager: %v", err)
+		return
+	}
+
+	if !queryRateLimitsEqual(mgr.queryThrottle.Limits(), limits) {
+		log.Printf("manager: query rate limits set to %+v", limits)
+		mgr.queryThrottle.SetLimits(limits)
+	}
+}
+
+func queryRateLimitsEqual(a, b QueryRateLimits) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
@@ -0,0 +1,92 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestQueryThrottle(t *testing.T) {
+	mgr := NewManager(VERSION, nil, NewUUID(), nil,
+		"", 1, "", "", "", "", nil)
+
+	a0 := &PIndex{Name: "a_0", IndexName: "a", SourceName: "t1"}
+	a1 := &PIndex{Name: "a_1", IndexName: "a", SourceName: "t1"}
+	b0 := &PIndex{Name: "b_0", IndexName: "b", SourceName: "t1"}
+	c0 := &PIndex{Name: "c_0", IndexName: "c", SourceName: "t2"}
+
+	admitted := func(pindex *PIndex, n int) int {
+		rv := 0
+		for i := 0; i < n; i++ {
+			if mgr.AdmitPIndexQuery(pindex) == nil {
+				rv++
+			}
+		}
+		return rv
+	}
+
+	// Unlimited without the option.
+	if n := admitted(a0, 100); n != 100 {
+		t.Fatalf("expected unlimited, got: %d", n)
+	}
+
+	mgr.SetOption(QUERY_RATE_LIMITS_OPTION, `{
+		"default": {"qps": 0.001, "burst": 5},
+		"indexes": {"a": {"qps": 0.001, "burst": 2}},
+		"tenants": {"t1": {"qps": 0.001, "burst": 3}}
+	}`, false)
+
+	// The index limit wins over the tenant limit, which wins over the
+	// default, and the limits are per pindex.
+	for _, c := range []struct {
+		pindex *PIndex
+		exp    int
+	}{
+		{a0, 2}, {a1, 2}, {b0, 3}, {c0, 5},
+	} {
+		if n := admitted(c.pindex, 10); n != c.exp {
+			t.Fatalf("pindex: %s, expected: %d, got: %d",
+				c.pindex.Name, c.exp, n)
+		}
+	}
+
+	err := mgr.AdmitPIndexQuery(b0)
+	var errQT *QueryThrottledError
+	if !errors.Is(err, ErrQueryThrottled) || !errors.As(err, &errQT) ||
+		errQT.PIndexName != "b_0" || errQT.Tenant != "t1" ||
+		errQT.RetryAfter <= 0 {
+		t.Fatalf("expected a throttled err, got: %v", err)
+	}
+
+	// The coordinator parses the remote response.
+	buf, _ := json.Marshal(NewQueryThrottledResponse(errQT))
+	got, ok := ParseQueryThrottledResponse(http.StatusTooManyRequests, buf)
+	if !ok || got.PIndexName != "b_0" || got.IndexName != "b" ||
+		got.Limit.Burst != 3 || got.RetryAfter.Milliseconds() !=
+		errQT.RetryAfter.Milliseconds() {
+		t.Fatalf("expected the parsed err, got: %+v, %s", got, buf)
+	}
+	if _, ok = ParseQueryThrottledResponse(http.StatusBadRequest, buf); ok {
+		t.Fatalf("expected no throttled err on other statuses")
+	}
+
+	// Changed limits take effect right away.
+	mgr.SetOption(QUERY_RATE_LIMITS_OPTION, "", false)
+	if n := admitted(b0, 10); n != 10 {
+		t.Fatalf("expected unlimited, got: %d", n)
+	}
+
+	if v := atomic.LoadUint64(&mgr.stats.TotQueryThrottled); v == 0 {
+		t.Fatalf("expected throttled stats")
+	}
+}
@@ -0,0 +1,299 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package rebalance
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/rest/monitor"
+	log "github.com/couchbase/clog"
+)
+
+var ErrorDrainStopped = errors.New("drain stopped")
+
+// ErrorDrainUnreachable is the error of a drain that can't reach a
+// node whose pindexes are being drained, such as when a node that's
+// being failed over is already dead.
+var ErrorDrainUnreachable = errors.New("drain node unreachable")
+
+// ErrorDrainTimeout is the error of a drain whose replicas did not
+// catch up within the drain timeout.
+var ErrorDrainTimeout = errors.New("drain timeout")
+
+// DefaultDrainTimeoutInSec is the default upper limit for the replicas
+// to catch up with their primaries during a graceful failover.
+var DefaultDrainTimeoutInSec = 300
+
+// A DrainTarget is a pindex whose primary is on a node that's being
+// gracefully failed over, along with the replicas on the remaining
+// nodes that have to catch up with the primary before being promoted.
+type DrainTarget struct {
+	PIndex           string
+	SourcePartitions []string
+	Primary          string
+	Replicas         []string
+}
+
+type DrainOptions struct {
+	// Upper limit for the replicas to catch up, where <= 0 means
+	// DefaultDrainTimeoutInSec.
+	TimeoutInSec int
+
+	StatsSampleErrorThreshold *int
+
+	// Optional, defaults to http.Get().
+	HttpGet func(url string) (resp *http.Response, err error)
+}
+
+// CalcDrainTargets returns the pindexes, sorted by name, whose primary
+// is on one of the nodesToRemove and that have replicas on the
+// remaining nodes.
+func CalcDrainTargets(planPIndexes *cbgt.PlanPIndexes,
+	nodesToRemove []string) []DrainTarget {
+	if planPIndexes == nil {
+		return nil
+	}
+
+	removing := cbgt.StringsToMap(nodesToRemove)
+
+	var rv []DrainTarget
+
+	for name, planPIndex := range planPIndexes.PlanPIndexes {
+		var primary string
+		var replicas []string
+
+		for node, planPIndexNode := range planPIndex.Nodes {
+			if planPIndexNode.Priority <= 0 {
+				if removing[node] {
+					primary = node
+				}
+			} else if !removing[node] {
+				replicas = append(replicas, node)
+			}
+		}
+
+		if primary == "" || len(replicas) == 0 {
+			continue
+		}
+
+		sort.Strings(replicas)
+
+		var sourcePartitions []string
+		if planPIndex.SourcePartitions != "" {
+			sourcePartitions = strings.Split(planPIndex.SourcePartitions, ",")
+		}
+
+		rv = append(rv, DrainTarget{
+			PIndex:           name,
+			SourcePartitions: sourcePartitions,
+			Primary:          primary,
+			Replicas:         replicas,
+		})
+	}
+
+	sort.Slice(rv, func(i, j int) bool { return rv[i].PIndex < rv[j].PIndex })
+
+	return rv
+}
+
+// DrainReplicas blocks until the replicas of the pindexes whose
+// primary is on one of the nodesToRemove have caught up with the seqs
+// that the primaries had reached at the start of the drain, so that
+// the in-flight mutations aren't lost when the replicas are promoted.
+// The returned error wraps ErrorDrainUnreachable when a node of the
+// drain doesn't respond, and ErrorDrainTimeout when the replicas don't
+// catch up in time, in which cases the caller can fall back to a hard
+// failover.
+func DrainReplicas(cfg cbgt.Cfg, version string, nodesToRemove []string,
+	options DrainOptions, stopCh <-chan struct{}) error {
+	_, nodeDefs, planPIndexes, _, err :=
+		cbgt.PlannerGetPlan(cfg, version, "")
+	if err != nil {
+		return err
+	}
+
+	targets := CalcDrainTargets(planPIndexes, nodesToRemove)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	nodesSeen := map[string]bool{}
+	for _, target := range targets {
+		nodesSeen[target.Primary] = true
+		for _, replica := range target.Replicas {
+			nodesSeen[replica] = true
+		}
+	}
+
+	var urlUUIDs []monitor.UrlUUID
+	for _, urlUUID := range monitor.NodeDefsUrlUUIDs(nodeDefs) {
+		if nodesSeen[urlUUID.UUID] {
+			urlUUIDs = append(urlUUIDs, urlUUID)
+			delete(nodesSeen, urlUUID.UUID)
+		}
+	}
+
+	// The nodes without a known address can never respond.
+	if len(nodesSeen) > 0 {
+		var unknown []string
+		for node := range nodesSeen {
+			unknown = append(unknown, node)
+		}
+		sort.Strings(unknown)
+
+		return fmt.Errorf("rebalance: DrainReplicas, nodes: %v, err: %w",
+			unknown, ErrorDrainUnreachable)
+	}
+
+	sampleCh := make(chan monitor.MonitorSample)
+
+	monitorInst, err := monitor.StartMonitorNodes(urlUUIDs, sampleCh,
+		monitor.MonitorNodesOptions{
+			DiagSampleDisable: true,
+			HttpGet:           options.HttpGet,
+		})
+	if err != nil {
+		return err
+	}
+	defer monitorInst.Stop()
+
+	errThreshold := StatsSampleErrorThreshold
+	if options.StatsSampleErrorThreshold != nil {
+		errThreshold = uint8(*options.StatsSampleErrorThreshold)
+	}
+
+	timeoutInSec := options.TimeoutInSec
+	if timeoutInSec <= 0 {
+		timeoutInSec = DefaultDrainTimeoutInSec
+	}
+
+	timer := time.NewTimer(time.Duration(timeoutInSec) * time.Second)
+	defer timer.Stop()
+
+	// pindex -> sourcePartition -> node -> cbgt.UUIDSeq.
+	currSeqs := map[string]map[string]map[string]cbgt.UUIDSeq{}
+	wantSeqs := map[string]map[string]map[string]cbgt.UUIDSeq{}
+
+	errMap := make(map[string]uint8, len(urlUUIDs))
+
+	log.Printf("rebalance: DrainReplicas, targets: %d, nodesToRemove: %v",
+		len(targets), nodesToRemove)
+
+	for {
+		select {
+		case <-stopCh:
+			return ErrorDrainStopped
+
+		case <-timer.C:
+			return fmt.Errorf("rebalance: DrainReplicas, replicas did not"+
+				" catch up within %d secs, pending: %v, err: %w", timeoutInSec,
+				drainPending(targets, currSeqs, wantSeqs), ErrorDrainTimeout)
+
+		case s := <-sampleCh:
+			if s.Error != nil || s.Data == nil {
+				errMap[s.UUID]++
+				if errMap[s.UUID] < errThreshold {
+					continue
+				}
+
+				if s.Error == nil {
+					s.Error = fmt.Errorf("empty response")
+				}
+
+				return fmt.Errorf("rebalance: DrainReplicas, node: %s,"+
+					" sample err: %v, err: %w", s.UUID, s.Error,
+					ErrorDrainUnreachable)
+			}
+
+			if s.Kind != "/api/stats?partitions=true" {
+				continue
+			}
+
+			errMap[s.UUID] = 0
+
+			m := struct {
+				PIndexes map[string]struct {
+					Partitions map[string]struct {
+						UUID      string `json:"uuid"`
+						Seq       uint64 `json:"seq"`
+						SourceSeq uint64 `json:"sourceSeq,omitempty"`
+					} `json:"partitions"`
+				} `json:"pindexes"`
+			}{}
+
+			err = cbgt.UnmarshalJSON(s.Data, &m)
+			if err != nil {
+				return fmt.Errorf("rebalance: DrainReplicas json,"+
+					" node: %s, err: %v", s.UUID, err)
+			}
+
+			for pindex, x := range m.PIndexes {
+				for sourcePartition, uuidSeq := range x.Partitions {
+					SetUUIDSeq(currSeqs, pindex, sourcePartition, s.UUID,
+						uuidSeq.UUID, uuidSeq.Seq, uuidSeq.SourceSeq)
+				}
+			}
+
+			if len(drainPending(targets, currSeqs, wantSeqs)) == 0 {
+				log.Printf("rebalance: DrainReplicas, done")
+				return nil
+			}
+		}
+	}
+}
+
+// drainPending returns the pindexes whose replicas haven't yet caught
+// up, recording the seqs to be reached from the first seen seqs of the
+// primaries.
+func drainPending(targets []DrainTarget,
+	currSeqs, wantSeqs map[string]map[string]map[string]cbgt.UUIDSeq) []string {
+	var rv []string
+
+	for _, target := range targets {
+		if !drainReached(target, currSeqs, wantSeqs) {
+			rv = append(rv, target.PIndex)
+		}
+	}
+
+	return rv
+}
+
+func drainReached(target DrainTarget,
+	currSeqs, wantSeqs map[string]map[string]map[string]cbgt.UUIDSeq) bool {
+	for _, sourcePartition := range target.SourcePartitions {
+		want, exists := GetUUIDSeq(wantSeqs,
+			target.PIndex, sourcePartition, target.Primary)
+		if !exists {
+			want, exists = GetUUIDSeq(currSeqs,
+				target.PIndex, sourcePartition, target.Primary)
+			if !exists {
+				return false
+			}
+
+			SetUUIDSeq(wantSeqs, target.PIndex, sourcePartition,
+				target.Primary, want.UUID, want.Seq, want.SourceSeq)
+		}
+
+		for _, replica := range target.Replicas {
+			curr, exists := GetUUIDSeq(currSeqs,
+				target.PIndex, sourcePartition, replica)
+			if !exists || curr.Seq < want.Seq {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,204 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package rebalance
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/couchbase/cbgt"
+)
+
+var ErrorMoveNotCancelable = errors.New("move not cancelable")
+
+// CancelMove cancels the moves of a single pindex, rather than the
+// whole rebalance, such as when a giant pindex is blocking the
+// rebalance's completion.  The pindex is put back onto the nodes that
+// it was on when the rebalance started, any of its in-flight moves are
+// interrupted, and its remaining moves are skipped, while the moves of
+// the other pindexes continue.  The moves of a pindex that's on a node
+// that's being removed can't be canceled.
+func (r *Rebalancer) CancelMove(pindex string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.canceledMoves[pindex] {
+		return nil
+	}
+
+	var begPlanPIndex *cbgt.PlanPIndex
+	if r.begPlanPIndexes != nil {
+		begPlanPIndex = r.begPlanPIndexes.PlanPIndexes[pindex]
+	}
+	if begPlanPIndex == nil || len(begPlanPIndex.Nodes) == 0 {
+		return fmt.Errorf("rebalance: CancelMove, pindex: %s,"+
+			" wasn't on any node, err: %w", pindex, ErrorMoveNotCancelable)
+	}
+
+	removing := cbgt.StringsToMap(r.nodesToRemove)
+
+	var begNodes []string
+	for node := range begPlanPIndex.Nodes {
+		if removing[node] {
+			return fmt.Errorf("rebalance: CancelMove, pindex: %s,"+
+				" is on the removed node: %s, err: %w",
+				pindex, node, ErrorMoveNotCancelable)
+		}
+		begNodes = append(begNodes, node)
+	}
+	sort.Strings(begNodes)
+
+	if !r.optionsReb.DryRun {
+		err := cbgt.CfgRetryOnCAS("rebalance", 100, func() error {
+			planPIndexes, cas, err :=
+				cbgt.PlannerGetPlanPIndexes(r.cfg, r.version)
+			if err != nil {
+				return err
+			}
+
+			planPIndex := planPIndexes.PlanPIndexes[pindex]
+			if planPIndex == nil {
+				return nil // The index was deleted.
+			}
+
+			planPIndex.Nodes = copyPlanPIndexNodes(begPlanPIndex.Nodes)
+			planPIndex.UUID = cbgt.NewUUID()
+			planPIndexes.UUID = cbgt.NewUUID()
+			planPIndexes.ImplVersion = r.version
+
+			_, err = cbgt.CfgSetPlanPIndexes(r.cfg, planPIndexes, cas)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("rebalance: CancelMove, pindex: %s,"+
+				" could not restore plan, err: %v", pindex, err)
+		}
+	}
+
+	// Replan around the canceled moves, so that the pindex is expected
+	// to stay on its beginning nodes.
+	if endPlanPIndex := r.endPlanPIndexes.PlanPIndexes[pindex]; endPlanPIndex != nil {
+		p := *endPlanPIndex // Copy, as the endPlanPIndexes are shared.
+		p.Nodes = copyPlanPIndexNodes(begPlanPIndex.Nodes)
+		r.endPlanPIndexes.PlanPIndexes[pindex] = &p
+	}
+
+	r.canceledMoves[pindex] = true
+
+	if ch, exists := r.moveCancelChs[pindex]; exists {
+		close(ch)
+		delete(r.moveCancelChs, pindex)
+	}
+
+	r.Logf("rebalance: CancelMove, pindex: %s, restored to nodes: %v",
+		pindex, begNodes)
+
+	return nil
+}
+
+// CanceledMoves returns the pindexes whose moves were canceled.
+func (r *Rebalancer) CanceledMoves() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	rv := make([]string, 0, len(r.canceledMoves))
+	for pindex := range r.canceledMoves {
+		rv = append(rv, pindex)
+	}
+	sort.Strings(rv)
+
+	return rv
+}
+
+// removeCanceledMovesLOCKED filters out the moves of the pindexes
+// whose moves were canceled.
+func (r *Rebalancer) removeCanceledMovesLOCKED(
+	pms []*pindexMoves) []*pindexMoves {
+	if len(r.canceledMoves) == 0 {
+		return pms
+	}
+
+	var rv []*pindexMoves
+	for _, pm := range pms {
+		if !r.canceledMoves[pm.name] {
+			rv = append(rv, pm)
+		}
+	}
+
+	return rv
+}
+
+// keepCanceledMovesLOCKED replans the pindexes whose moves were
+// canceled back onto their beginning nodes, so that an index that's
+// rebalanced after the cancellation doesn't move them.
+func (r *Rebalancer) keepCanceledMovesLOCKED(
+	planPIndexes map[string]*cbgt.PlanPIndex) {
+	for pindex := range r.canceledMoves {
+		planPIndex := planPIndexes[pindex]
+		begPlanPIndex := r.begPlanPIndexes.PlanPIndexes[pindex]
+		if planPIndex != nil && begPlanPIndex != nil {
+			planPIndex.Nodes = copyPlanPIndexNodes(begPlanPIndex.Nodes)
+		}
+	}
+}
+
+// moveStopCh returns a channel that's closed when either the stopCh
+// is closed or the moves of the pindex are canceled, along with a
+// func that releases its resources.
+func (r *Rebalancer) moveStopCh(stopCh chan struct{},
+	pindex string) (chan struct{}, func()) {
+	r.m.Lock()
+	cancelCh, exists := r.moveCancelChs[pindex]
+	if !exists && !r.canceledMoves[pindex] {
+		cancelCh = make(chan struct{})
+		r.moveCancelChs[pindex] = cancelCh
+	}
+	r.m.Unlock()
+
+	rv := make(chan struct{})
+	doneCh := make(chan struct{})
+
+	if cancelCh == nil { // Already canceled.
+		close(rv)
+		return rv, func() {}
+	}
+
+	go func() {
+		select {
+		case <-stopCh:
+			close(rv)
+		case <-cancelCh:
+			close(rv)
+		case <-doneCh:
+		}
+	}()
+
+	return rv, func() { close(doneCh) }
+}
+
+// isMoveCanceled returns true when the moves of the pindex were
+// canceled.
+func (r *Rebalancer) isMoveCanceled(pindex string) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.canceledMoves[pindex]
+}
+
+func copyPlanPIndexNodes(
+	nodes map[string]*cbgt.PlanPIndexNode) map[string]*cbgt.PlanPIndexNode {
+	rv := make(map[string]*cbgt.PlanPIndexNode, len(nodes))
+	for node, planPIndexNode := range nodes {
+		n := *planPIndexNode
+		rv[node] = &n
+	}
+
+	return rv
+}
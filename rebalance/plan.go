@@ -0,0 +1,480 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package rebalance
+
+import (
+	"sort"
+	"time"
+
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+)
+
+// The rebalance plan is persisted in the Cfg as the rebalance
+// progresses, so that when the orchestrator node dies mid-rebalance,
+// the rebalance that's started on the next orchestrator for the same
+// nodes to keep and eject picks up where the old one left off, keeping
+// the node assignments of the indexes that were already planned, and
+// skipping the indexes that were already done.  The topology change
+// ID isn't matched, as ns_server issues a new one on a retry.
+//
+// Only compact state is persisted: the plan itself under the
+// REBALANCE_PLAN_KEY, and each index's status and target node
+// assignments under a key of its own, so that an index starting or
+// finishing only rewrites its own key.  The keys are deleted when the
+// rebalance completes, fails or is canceled.
+
+// REBALANCE_PLAN_KEY is the Cfg key of the persisted rebalance plan.
+const REBALANCE_PLAN_KEY = "rebalancePlan"
+
+// RebalancePlanIndexKey returns the Cfg key of the persisted plan of
+// an index.
+func RebalancePlanIndexKey(indexName string) string {
+	return REBALANCE_PLAN_KEY + "-" + indexName
+}
+
+// The statuses of the indexes of a RebalancePlan.
+const (
+	RebalancePlanIndexPending = "pending"
+	RebalancePlanIndexRunning = "running"
+	RebalancePlanIndexDone    = "done"
+)
+
+// A RebalancePlan is the persisted plan of a rebalance.
+type RebalancePlan struct {
+	UUID             string    `json:"uuid"`
+	TopologyChangeID string    `json:"topologyChangeID"`
+	ImplVersion      string    `json:"implVersion"`
+	Orchestrator     string    `json:"orchestrator,omitempty"` // Node UUID.
+	StartedAt        time.Time `json:"startedAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+
+	NodesAll      []string `json:"nodesAll"`
+	NodesToAdd    []string `json:"nodesToAdd"`
+	NodesToRemove []string `json:"nodesToRemove"`
+
+	IndexLabelSelector string `json:"indexLabelSelector,omitempty"`
+
+	// IndexNames are the indexes with a persisted plan, see
+	// RebalancePlanIndexKey.
+	IndexNames []string `json:"indexNames"`
+}
+
+// A RebalancePlanIndex is the persisted plan of the moves of an index.
+type RebalancePlanIndex struct {
+	PlanUUID  string `json:"planUUID"`
+	IndexUUID string `json:"indexUUID"`
+	Status    string `json:"status"`
+
+	// Nodes are the node UUIDs of the pindexes of the index, keyed by
+	// pindex name, as planned at the end of the rebalance, where the
+	// i'th node has a priority of i.
+	Nodes map[string][]string `json:"nodes,omitempty"`
+}
+
+// CfgGetRebalancePlan retrieves the persisted rebalance plan from a
+// Cfg provider, which is nil when there's no rebalance to resume.
+func CfgGetRebalancePlan(cfg cbgt.Cfg) (*RebalancePlan, uint64, error) {
+	v, cas, err := cfg.Get(REBALANCE_PLAN_KEY, 0)
+	if err != nil {
+		return nil, cas, err
+	}
+	if v == nil {
+		return nil, cas, nil
+	}
+	rv := &RebalancePlan{}
+	err = cbgt.UnmarshalJSON(v, rv)
+	if err != nil {
+		return nil, cas, err
+	}
+	return rv, cas, nil
+}
+
+// CfgSetRebalancePlan updates the persisted rebalance plan on a Cfg
+// provider.
+func CfgSetRebalancePlan(cfg cbgt.Cfg, plan *RebalancePlan,
+	cas uint64) (uint64, error) {
+	buf, err := cbgt.MarshalJSON(plan)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Set(REBALANCE_PLAN_KEY, buf, cas)
+}
+
+// CfgGetRebalancePlanIndex retrieves the persisted plan of an index
+// from a Cfg provider.
+func CfgGetRebalancePlanIndex(cfg cbgt.Cfg, indexName string) (
+	*RebalancePlanIndex, uint64, error) {
+	v, cas, err := cfg.Get(RebalancePlanIndexKey(indexName), 0)
+	if err != nil {
+		return nil, cas, err
+	}
+	if v == nil {
+		return nil, cas, nil
+	}
+	rv := &RebalancePlanIndex{}
+	err = cbgt.UnmarshalJSON(v, rv)
+	if err != nil {
+		return nil, cas, err
+	}
+	return rv, cas, nil
+}
+
+// CfgSetRebalancePlanIndex updates the persisted plan of an index on a
+// Cfg provider.
+func CfgSetRebalancePlanIndex(cfg cbgt.Cfg, indexName string,
+	planIndex *RebalancePlanIndex, cas uint64) (uint64, error) {
+	buf, err := cbgt.MarshalJSON(planIndex)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Set(RebalancePlanIndexKey(indexName), buf, cas)
+}
+
+// matches returns true when the plan is for the same nodes to keep
+// and eject.
+func (p *RebalancePlan) matches(version, labelSelector string,
+	nodesAll, nodesToRemove []string) bool {
+	return cbgt.VersionGTE(version, p.ImplVersion) &&
+		p.IndexLabelSelector == labelSelector &&
+		sameStrings(cbgt.StringsRemoveStrings(p.NodesAll, p.NodesToRemove),
+			cbgt.StringsRemoveStrings(nodesAll, nodesToRemove)) &&
+		sameStrings(p.NodesToRemove, nodesToRemove)
+}
+
+// sameStrings returns true when a and b have the same set of strings.
+func sameStrings(a, b []string) bool {
+	return len(cbgt.StringsRemoveStrings(a, b)) == 0 &&
+		len(cbgt.StringsRemoveStrings(b, a)) == 0
+}
+
+// A resumablePlan is a persisted plan of an interrupted rebalance,
+// with the persisted plans of its indexes, keyed by index name.
+type resumablePlan struct {
+	*RebalancePlan
+	indexes map[string]*RebalancePlanIndex
+}
+
+// planIndex returns the persisted plan of an index, unless the index
+// was recreated since it was persisted.
+func (p *resumablePlan) planIndex(indexDef *cbgt.IndexDef) *RebalancePlanIndex {
+	if p == nil {
+		return nil
+	}
+	planIndex := p.indexes[indexDef.Name]
+	if planIndex == nil || planIndex.IndexUUID != indexDef.UUID {
+		return nil
+	}
+	return planIndex
+}
+
+// loadResumablePlan returns the persisted plan of an interrupted
+// rebalance of the same nodes to keep and eject, if any.
+func loadResumablePlan(cfg cbgt.Cfg, version, labelSelector string,
+	nodesAll, nodesToRemove []string,
+	optionsReb RebalanceOptions) *resumablePlan {
+	if optionsReb.DryRun || optionsReb.DisablePlanResume ||
+		optionsReb.TopologyChangeID == "" {
+		return nil
+	}
+
+	plan, _, err := CfgGetRebalancePlan(cfg)
+	if err != nil {
+		log.Warnf("rebalance: CfgGetRebalancePlan, err: %v", err)
+		return nil
+	}
+	if plan == nil || !plan.matches(version, labelSelector,
+		nodesAll, nodesToRemove) {
+		return nil
+	}
+
+	rv := &resumablePlan{
+		RebalancePlan: plan,
+		indexes:       map[string]*RebalancePlanIndex{},
+	}
+
+	for _, indexName := range plan.IndexNames {
+		planIndex, _, err := CfgGetRebalancePlanIndex(cfg, indexName)
+		if err != nil {
+			log.Warnf("rebalance: CfgGetRebalancePlanIndex, index: %s,"+
+				" err: %v", indexName, err)
+			continue
+		}
+		if planIndex != nil && planIndex.PlanUUID == plan.UUID {
+			rv.indexes[indexName] = planIndex
+		}
+	}
+
+	return rv
+}
+
+// initPlan persists a new plan for the rebalance, or takes over the
+// persisted plan when it's resuming an interrupted rebalance.
+func (r *Rebalancer) initPlan(resumedPlan *resumablePlan,
+	labelSelector string) error {
+	if r.optionsReb.DryRun || r.optionsReb.TopologyChangeID == "" {
+		return nil
+	}
+
+	if resumedPlan != nil {
+		r.planUUID = resumedPlan.UUID
+		r.resumedPlan = resumedPlan
+
+		r.Logf("rebalance: resuming plan: %s, orchestrator: %s,"+
+			" startedAt: %v", resumedPlan.UUID, resumedPlan.Orchestrator,
+			resumedPlan.StartedAt)
+
+		return r.updatePlan(func(plan *RebalancePlan) {
+			plan.TopologyChangeID = r.optionsReb.TopologyChangeID
+			plan.Orchestrator = r.orchestrator()
+		})
+	}
+
+	// Any previous plan is replaced, even when it can't be parsed.
+	prevPlan, _, _ := CfgGetRebalancePlan(r.cfg)
+	if prevPlan != nil {
+		r.deletePlanIndexes(prevPlan)
+	}
+
+	now := time.Now()
+
+	plan := &RebalancePlan{
+		UUID:               cbgt.NewUUID(),
+		TopologyChangeID:   r.optionsReb.TopologyChangeID,
+		ImplVersion:        r.version,
+		Orchestrator:       r.orchestrator(),
+		StartedAt:          now,
+		UpdatedAt:          now,
+		NodesAll:           r.nodesAll,
+		NodesToAdd:         r.nodesToAdd,
+		NodesToRemove:      r.nodesToRemove,
+		IndexLabelSelector: labelSelector,
+	}
+
+	err := cbgt.CfgRetryOnCAS("rebalance", 100, func() error {
+		_, cas, err := r.cfg.Get(REBALANCE_PLAN_KEY, 0)
+		if err != nil {
+			return err
+		}
+		_, err = CfgSetRebalancePlan(r.cfg, plan, cas)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	r.planUUID = plan.UUID
+
+	return nil
+}
+
+func (r *Rebalancer) orchestrator() string {
+	if r.optionsReb.Manager != nil {
+		return r.optionsReb.Manager.UUID()
+	}
+	return ""
+}
+
+// Resumed returns true when the rebalance resumed the persisted plan
+// of an interrupted rebalance.
+func (r *Rebalancer) Resumed() bool {
+	return r.resumedPlan != nil
+}
+
+// updatePlan applies the change to the persisted plan, unless it was
+// replaced, such as by a rebalance on another orchestrator.
+func (r *Rebalancer) updatePlan(change func(plan *RebalancePlan)) error {
+	if r.planUUID == "" {
+		return nil
+	}
+
+	return cbgt.CfgRetryOnCAS("rebalance", 100, func() error {
+		plan, cas, err := CfgGetRebalancePlan(r.cfg)
+		if err != nil {
+			return err
+		}
+		if plan == nil || plan.UUID != r.planUUID {
+			return nil
+		}
+
+		change(plan)
+		plan.UpdatedAt = time.Now()
+
+		_, err = CfgSetRebalancePlan(r.cfg, plan, cas)
+		return err
+	})
+}
+
+// updatePlanIndex applies the change to the persisted plan of an
+// index, logging rather than failing the rebalance on errors.  The
+// index is only added to the IndexNames of the plan the first time.
+func (r *Rebalancer) updatePlanIndex(indexDef *cbgt.IndexDef,
+	change func(planIndex *RebalancePlanIndex)) {
+	if r.planUUID == "" {
+		return
+	}
+
+	err := r.updatePlan(func(plan *RebalancePlan) {
+		for _, indexName := range plan.IndexNames {
+			if indexName == indexDef.Name {
+				return
+			}
+		}
+		plan.IndexNames = append(plan.IndexNames, indexDef.Name)
+	})
+	if err == nil {
+		err = cbgt.CfgRetryOnCAS("rebalance", 100, func() error {
+			planIndex, cas, err := CfgGetRebalancePlanIndex(r.cfg,
+				indexDef.Name)
+			if err != nil {
+				return err
+			}
+			if planIndex == nil || planIndex.PlanUUID != r.planUUID ||
+				planIndex.IndexUUID != indexDef.UUID {
+				planIndex = &RebalancePlanIndex{
+					PlanUUID:  r.planUUID,
+					IndexUUID: indexDef.UUID,
+				}
+			}
+
+			change(planIndex)
+
+			_, err = CfgSetRebalancePlanIndex(r.cfg, indexDef.Name,
+				planIndex, cas)
+			return err
+		})
+	}
+	if err != nil {
+		r.Logf("rebalance: updatePlanIndex, index: %s, err: %v",
+			indexDef.Name, err)
+	}
+}
+
+// planIndexStarted persists the node assignments of the end plan of
+// an index before its pindexes are moved.
+func (r *Rebalancer) planIndexStarted(indexDef *cbgt.IndexDef) {
+	r.m.Lock()
+	nodes := map[string][]string{}
+	for name, planPIndex := range r.endPlanPIndexes.PlanPIndexes {
+		if planPIndex.IndexName == indexDef.Name {
+			nodes[name] = planPIndexNodesByPriority(planPIndex.Nodes)
+		}
+	}
+	r.m.Unlock()
+
+	r.updatePlanIndex(indexDef, func(planIndex *RebalancePlanIndex) {
+		planIndex.Status = RebalancePlanIndexRunning
+		planIndex.Nodes = nodes
+	})
+}
+
+// planPIndexNodesByPriority returns the node UUIDs of a pindex, ordered
+// by priority, then by node UUID.
+func planPIndexNodesByPriority(
+	nodes map[string]*cbgt.PlanPIndexNode) []string {
+	rv := make([]string, 0, len(nodes))
+	for node := range nodes {
+		rv = append(rv, node)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		pi, pj := nodes[rv[i]].Priority, nodes[rv[j]].Priority
+		if pi != pj {
+			return pi < pj
+		}
+		return rv[i] < rv[j]
+	})
+	return rv
+}
+
+// planIndexDone persists that the moves of an index are done.
+func (r *Rebalancer) planIndexDone(indexDef *cbgt.IndexDef) {
+	r.updatePlanIndex(indexDef, func(planIndex *RebalancePlanIndex) {
+		planIndex.Status = RebalancePlanIndexDone
+		planIndex.Nodes = nil
+	})
+}
+
+// isPlanIndexDone returns true when a resumed plan says that the
+// moves of an index were already done.
+func (r *Rebalancer) isPlanIndexDone(indexDef *cbgt.IndexDef) bool {
+	planIndex := r.resumedPlan.planIndex(indexDef)
+	return planIndex != nil && planIndex.Status == RebalancePlanIndexDone
+}
+
+// resumePlanPIndexesLOCKED assigns the pindexes of an index to the
+// nodes of the end plan of the resumed plan, returning false when
+// there's no such end plan for all of the pindexes.
+func (r *Rebalancer) resumePlanPIndexesLOCKED(indexDef *cbgt.IndexDef,
+	planPIndexesForIndex map[string]*cbgt.PlanPIndex) bool {
+	planIndex := r.resumedPlan.planIndex(indexDef)
+	if planIndex == nil || len(planIndex.Nodes) == 0 {
+		return false
+	}
+
+	for name := range planPIndexesForIndex {
+		if len(planIndex.Nodes[name]) == 0 {
+			return false
+		}
+	}
+
+	for name, planPIndex := range planPIndexesForIndex {
+		planPIndex.Nodes = map[string]*cbgt.PlanPIndexNode{}
+		for priority, node := range planIndex.Nodes[name] {
+			canRead, canWrite := true, true
+			nodePlanParam := cbgt.GetNodePlanParam(
+				indexDef.PlanParams.NodePlanParams, node, indexDef.Name, name)
+			if nodePlanParam != nil {
+				canRead = nodePlanParam.CanRead
+				canWrite = nodePlanParam.CanWrite
+			}
+			planPIndex.Nodes[node] = &cbgt.PlanPIndexNode{
+				CanRead:  canRead,
+				CanWrite: canWrite,
+				Priority: priority,
+			}
+		}
+		r.existingPlanPIndexes.PlanPIndexes[name] = planPIndex
+	}
+
+	return true
+}
+
+// deletePlanIndexes deletes the persisted plans of the indexes of a
+// plan.
+func (r *Rebalancer) deletePlanIndexes(plan *RebalancePlan) {
+	for _, indexName := range plan.IndexNames {
+		err := r.cfg.Del(RebalancePlanIndexKey(indexName), 0)
+		if err != nil {
+			r.Logf("rebalance: deletePlanIndexes, index: %s, err: %v",
+				indexName, err)
+		}
+	}
+}
+
+// deletePlan deletes the persisted plan of a rebalance that's ended.
+func (r *Rebalancer) deletePlan() {
+	if r.planUUID == "" {
+		return
+	}
+
+	err := cbgt.CfgRetryOnCAS("rebalance", 100, func() error {
+		plan, cas, err := CfgGetRebalancePlan(r.cfg)
+		if err != nil {
+			return err
+		}
+		if plan == nil || plan.UUID != r.planUUID {
+			return nil
+		}
+		r.deletePlanIndexes(plan)
+		return r.cfg.Del(REBALANCE_PLAN_KEY, cas)
+	})
+	if err != nil {
+		r.Logf("rebalance: deletePlan, err: %v", err)
+	}
+}
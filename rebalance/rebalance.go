@@ -9,8 +9,10 @@
 package rebalance
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
 	"sort"
@@ -73,6 +75,17 @@ type RebalanceOptions struct {
 	// for unit testing.
 	HttpGet func(url string) (resp *http.Response, err error)
 
+	// Optional, defaults to cbgt.HttpClient().Post(), which is used by
+	// the warm cache phase of the partition moves.
+	HttpPost func(url, contentType string,
+		body io.Reader) (resp *http.Response, err error)
+
+	// WarmCache, when true, means that a pindex that's moved onto a
+	// node is pre-warmed from the hot state of its former primary
+	// before it takes traffic, when the pindex implementation supports
+	// it, see cbgt.PIndexImplType.HotState.
+	WarmCache bool
+
 	SkipSeqChecks bool // For unit-testing.
 
 	// SeqChecksTimeoutInSec is an optional configurable timeout value,
@@ -87,6 +100,31 @@ type RebalanceOptions struct {
 	StatsSampleErrorThreshold *int
 
 	ExistingNodes []string
+
+	// Optional, overrides the MoveScheduler chosen via the
+	// "rebalanceMoveScheduler" manager option.
+	MoveScheduler MoveScheduler
+
+	// Optional label selector (e.g., "tenant=tenantA"), which limits the
+	// rebalance to the matching indexes, overriding the
+	// "rebalanceIndexLabelSelector" manager option.  A label-scoped
+	// rebalance cannot remove nodes, as the partitions of the other
+	// indexes would be left behind on the removed nodes.
+	IndexLabelSelector string
+
+	// Optional, the context of the trace span of the topology change,
+	// which the trace spans of the partition moves are children of.
+	TraceContext context.Context
+
+	// DisablePlanResume, when true, means that the persisted plan of
+	// an interrupted rebalance of the same nodes to keep and eject
+	// isn't resumed, see RebalancePlan.
+	DisablePlanResume bool
+
+	// Optional, the ID of the service.TopologyChange, which is
+	// recorded in the persisted plan of the rebalance.  When empty,
+	// the plan isn't persisted and can't be resumed.
+	TopologyChangeID string
 }
 
 type RebalanceLogFunc func(format string, v ...interface{})
@@ -106,11 +144,15 @@ type Rebalancer struct {
 	monitorSampleCh     chan monitor.MonitorSample
 	monitorSampleWantCh chan chan monitor.MonitorSample
 
-	nodesAll      []string          // Array of node UUID's.
-	nodesToAdd    []string          // Array of node UUID's.
-	nodesToRemove []string          // Array of node UUID's.
-	nodeWeights   map[string]int    // Keyed by node UUID.
-	nodeHierarchy map[string]string // Keyed by node UUID.
+	nodesAll      []string       // Array of node UUID's.
+	nodesToAdd    []string       // Array of node UUID's.
+	nodesToRemove []string       // Array of node UUID's.
+	nodeWeights   map[string]int // Keyed by node UUID.
+
+	// The nodeWeights scaled by the nodes' available resources, see
+	// cbgt.ApplyResourceHeadroom().
+	headroomNodeWeights map[string]int
+	nodeHierarchy       map[string]string // Keyed by node UUID.
 
 	begIndexDefs       *cbgt.IndexDefs
 	begNodeDefs        *cbgt.NodeDefs
@@ -141,6 +183,16 @@ type Rebalancer struct {
 	stopCh chan struct{} // Closed by app or when there's an error.
 
 	transferProgress map[string]float64 // pindex -> file transfer progress
+
+	// The pindexes whose moves were canceled, see CancelMove(), and
+	// the channels that interrupt the in-flight moves of the pindexes.
+	canceledMoves map[string]bool
+	moveCancelChs map[string]chan struct{}
+
+	// The UUID of the persisted plan, see RebalancePlan, and the
+	// persisted plan of the interrupted rebalance that's being resumed.
+	planUUID    string
+	resumedPlan *resumablePlan
 }
 
 // Map of index -> pindex -> node -> StateOp.
@@ -163,20 +215,29 @@ type WantSeqs map[string]map[string]map[string]cbgt.UUIDSeq
 
 // checkpoints the rebalance status in the cfg
 func CheckPointRebalanceStatus(cfg cbgt.Cfg, status cbgt.LastRebalanceStatus) error {
-	_, cas, err := cbgt.CfgGetLastRebalanceStatus(cfg)
-	if err != nil {
-		log.Errorf("rebalance_checkpoint: GetLastRebalanceStatus, err: %v",
-			err)
-		return err
-	}
+	err := cbgt.CfgRetryOnCAS("rebalance", 100, func() error {
+		_, cas, err := cbgt.CfgGetLastRebalanceStatus(cfg)
+		if err != nil {
+			log.Errorf("rebalance_checkpoint: GetLastRebalanceStatus, err: %v",
+				err)
+			return err
+		}
 
-	_, err = cbgt.CfgSetLastRebalanceStatus(cfg, status, cas)
+		_, err = cbgt.CfgSetLastRebalanceStatus(cfg, status, cas)
+		return err
+	})
 	if err != nil {
 		log.Errorf("rebalance_checkpoint: SetLastRebalanceStatus:%v, "+
 			"err:%v", status, err)
 		return err
 	}
 
+	// Refresh the cluster summary, so that it has the rebalance's time.
+	_, err = cbgt.CfgRefreshClusterSummary(cfg)
+	if err != nil {
+		log.Warnf("rebalance_checkpoint: RefreshClusterSummary, err: %v", err)
+	}
+
 	return nil
 }
 
@@ -225,6 +286,26 @@ func StartRebalance(version string, cfg cbgt.Cfg, server string,
 
 	nodesToAdd = cbgt.StringsRemoveStrings(nodesToAdd, nodesToRemove)
 
+	labelSelector, err := rebalanceIndexLabelSelector(optionsMgr, optionsReb)
+	if err != nil {
+		return nil, err
+	}
+	if len(labelSelector) > 0 && len(nodesToRemove) > 0 {
+		return nil, fmt.Errorf("rebalance: label-scoped rebalance,"+
+			" selector: %s, cannot remove nodes: %v",
+			labelSelector, nodesToRemove)
+	}
+
+	// A rebalance of the same nodes to keep and eject that was
+	// interrupted, such as by the death of its orchestrator, is
+	// resumed with its original nodesToAdd.
+	resumedPlan := loadResumablePlan(cfg, version, labelSelector.String(),
+		nodesAll, nodesToRemove, optionsReb)
+	if resumedPlan != nil {
+		nodesToAdd = cbgt.StringsRemoveStrings(resumedPlan.NodesToAdd,
+			nodesToRemove)
+	}
+
 	if RebalanceHook != nil {
 		_, skip, err := RebalanceHook(RebalanceHookInfo{
 			Phase: RebalanceHookPhaseInit,
@@ -266,6 +347,12 @@ func StartRebalance(version string, cfg cbgt.Cfg, server string,
 	}
 	// --------------------------------------------------------
 
+	diskWarnings, err := calcDiskUsageWarnings(version, server, optionsMgr,
+		begIndexDefs, begNodeDefs, begPlanPIndexes, nodesToRemove)
+	if err != nil && !optionsReb.DryRun {
+		return nil, fmt.Errorf("rebalance: %v", err)
+	}
+
 	urlUUIDs := monitor.NodeDefsUrlUUIDs(begNodeDefs)
 
 	monitorSampleCh := make(chan monitor.MonitorSample)
@@ -285,6 +372,9 @@ func StartRebalance(version string, cfg cbgt.Cfg, server string,
 
 	stopCh := make(chan struct{})
 
+	headroomNodeWeights := cbgt.ApplyResourceHeadroom(begNodeDefs,
+		nodesAll, nodeWeights, optionsMgr)
+
 	r := &Rebalancer{
 		version:              version,
 		cfg:                  cfg,
@@ -300,6 +390,7 @@ func StartRebalance(version string, cfg cbgt.Cfg, server string,
 		nodesToAdd:           nodesToAdd,
 		nodesToRemove:        nodesToRemove,
 		nodeWeights:          nodeWeights,
+		headroomNodeWeights:  headroomNodeWeights,
 		nodeHierarchy:        nodeHierarchy,
 		begIndexDefs:         begIndexDefs,
 		begNodeDefs:          begNodeDefs,
@@ -312,12 +403,15 @@ func StartRebalance(version string, cfg cbgt.Cfg, server string,
 		wantSeqs:             map[string]map[string]map[string]cbgt.UUIDSeq{},
 		stopCh:               stopCh,
 		transferProgress:     map[string]float64{},
+		canceledMoves:        map[string]bool{},
+		moveCancelChs:        map[string]chan struct{}{},
 	}
 
 	r.Logf("rebalance: nodesAll: %#v", nodesAll)
 	r.Logf("rebalance: nodesToAdd: %#v", nodesToAdd)
 	r.Logf("rebalance: nodesToRemove: %#v", nodesToRemove)
 	r.Logf("rebalance: nodeWeights: %#v", nodeWeights)
+	r.Logf("rebalance: headroomNodeWeights: %#v", r.headroomNodeWeights)
 	r.Logf("rebalance: nodeHierarchy: %#v", nodeHierarchy)
 
 	// r.Logf("rebalance: begIndexDefs: %#v", begIndexDefs)
@@ -325,8 +419,20 @@ func StartRebalance(version string, cfg cbgt.Cfg, server string,
 
 	r.Logf("rebalance: monitor urlUUIDs: %#v", urlUUIDs)
 
+	for resourceName, warnings := range diskWarnings {
+		r.Logf("rebalance: %s, warnings: %v", resourceName, warnings)
+		r.endPlanPIndexes.Warnings[resourceName] = warnings
+	}
+
 	r.initPlansForRecoveryRebalance(nodesToAdd)
 
+	err = r.initPlan(resumedPlan, labelSelector.String())
+	if err != nil {
+		monitorInst.Stop()
+		return nil, fmt.Errorf("rebalance: could not persist plan, err: %v",
+			err)
+	}
+
 	// begPlanPIndexesJSON, _ := cbgt.MarshalJSON(begPlanPIndexes)
 	//
 	// r.Logf("rebalance: begPlanPIndexes: %s, cas: %v",
@@ -342,6 +448,41 @@ func StartRebalance(version string, cfg cbgt.Cfg, server string,
 	return r, nil
 }
 
+// calcDiskUsageWarnings projects the disk usage of the nodes at the
+// end of the topology change, before any pindexes are moved, and
+// returns the warnings for the nodes that would exceed their disk
+// high-water mark, keyed by "node:<nodeUUID>", or an error when the
+// topology change is to be refused.
+func calcDiskUsageWarnings(version, server string,
+	optionsMgr map[string]string, begIndexDefs *cbgt.IndexDefs,
+	begNodeDefs *cbgt.NodeDefs, begPlanPIndexes *cbgt.PlanPIndexes,
+	nodesToRemove []string) (map[string][]string, error) {
+	if cbgt.NodeDiskCapacityHook == nil || cbgt.PIndexDiskSizeHook == nil ||
+		begNodeDefs == nil {
+		return nil, nil
+	}
+
+	removing := cbgt.StringsToMap(nodesToRemove)
+
+	endNodeDefs := cbgt.NewNodeDefs(version)
+	for nodeUUID, nodeDef := range begNodeDefs.NodeDefs {
+		if !removing[nodeUUID] {
+			endNodeDefs.NodeDefs[nodeUUID] = nodeDef
+		}
+	}
+
+	endPlanPIndexes, err := cbgt.CalcPlan("", begIndexDefs, endNodeDefs,
+		cbgt.CopyPlanPIndexes(begPlanPIndexes, version), version, server,
+		optionsMgr, nil)
+	if err != nil {
+		log.Warnf("rebalance: calcDiskUsageWarnings, CalcPlan, err: %v", err)
+		return nil, nil
+	}
+
+	return cbgt.DiskUsageWarnings(cbgt.CalcDiskUsageProjections(
+		begPlanPIndexes, endPlanPIndexes, endNodeDefs), optionsMgr)
+}
+
 // Calculate and Returns
 //
 // (*) Difference between the number of partitions required as per
@@ -507,6 +648,10 @@ func (r *Rebalancer) runRebalanceIndexes(stopCh chan struct{}) {
 		//
 		r.Stop()
 
+		// Only the death of the orchestrator leaves the plan behind,
+		// as a canceled or failed rebalance isn't to be resumed.
+		r.deletePlan()
+
 		r.monitor.Stop()
 
 		<-r.monitorDoneCh
@@ -516,10 +661,25 @@ func (r *Rebalancer) runRebalanceIndexes(stopCh chan struct{}) {
 		// TODO: Need to close monitorSampleWantCh?
 	}()
 
-	i := 1
-	n := len(r.begIndexDefs.IndexDefs)
+	// The selector was validated by StartRebalance().
+	labelSelector, _ := rebalanceIndexLabelSelector(r.optionsMgr, r.optionsReb)
 
+	indexDefs := make([]*cbgt.IndexDef, 0, len(r.begIndexDefs.IndexDefs))
 	for _, indexDef := range r.begIndexDefs.IndexDefs {
+		if !labelSelector.Matches(indexDef.Labels) {
+			r.Logf("runRebalanceIndexes: skipping indexDef.Name: %s,"+
+				" not matching label selector: %s", indexDef.Name, labelSelector)
+			continue
+		}
+		indexDefs = append(indexDefs, indexDef)
+	}
+
+	indexDefs = r.moveScheduler().OrderIndexes(indexDefs)
+
+	i := 1
+	n := len(indexDefs)
+
+	for _, indexDef := range indexDefs {
 		select {
 		case <-stopCh:
 			return
@@ -531,6 +691,13 @@ func (r *Rebalancer) runRebalanceIndexes(stopCh chan struct{}) {
 		r.Logf("=====================================")
 		r.Logf("runRebalanceIndexes: %d of %d", i, n)
 
+		if r.isPlanIndexDone(indexDef) {
+			r.Logf("runRebalanceIndexes: skipping indexDef.Name: %s,"+
+				" done by the resumed plan", indexDef.Name)
+			i++
+			continue
+		}
+
 		_, err := r.rebalanceIndex(stopCh, indexDef)
 		if err != nil {
 			r.Logf("run: indexDef.Name: %s, err: %#v",
@@ -538,12 +705,33 @@ func (r *Rebalancer) runRebalanceIndexes(stopCh chan struct{}) {
 			return
 		}
 
+		r.planIndexDone(indexDef)
+
 		i++
 	}
 }
 
 // --------------------------------------------------------
 
+// rebalanceIndexLabelSelector returns the label selector that limits
+// the rebalance to the matching indexes, preferring the one from the
+// RebalanceOptions.
+func rebalanceIndexLabelSelector(optionsMgr map[string]string,
+	optionsReb RebalanceOptions) (cbgt.LabelSelector, error) {
+	s := optionsReb.IndexLabelSelector
+	if s == "" {
+		s = optionsMgr["rebalanceIndexLabelSelector"]
+	}
+
+	sel, err := cbgt.ParseLabelSelector(s)
+	if err != nil {
+		return nil, fmt.Errorf("rebalance: %v", err)
+	}
+	return sel, nil
+}
+
+// --------------------------------------------------------
+
 // GetMovingPartitionsCount returns the total partitions
 // to be moved as a part of the rebalance operation.
 func (r *Rebalancer) GetMovingPartitionsCount() int {
@@ -571,6 +759,24 @@ func (r *Rebalancer) GetMovingPartitionsCount() int {
 	return 0
 }
 
+// ActivePartitionMovesCount returns the number of partitions of the
+// index that's currently being rebalanced that still have moves left.
+func (r *Rebalancer) ActivePartitionMovesCount() int {
+	count := 0
+	r.m.Lock()
+	if r.o != nil {
+		r.o.VisitNextMoves(func(m map[string]*blance.NextMoves) {
+			for _, nextMoves := range m {
+				if nextMoves != nil && nextMoves.Next < len(nextMoves.Moves) {
+					count++
+				}
+			}
+		})
+	}
+	r.m.Unlock()
+	return count
+}
+
 // --------------------------------------------------------
 
 // rebalanceIndex rebalances a single index.
@@ -611,6 +817,8 @@ func (r *Rebalancer) rebalanceIndex(stopCh chan struct{},
 		return true, nil
 	}
 
+	r.planIndexStarted(indexDef)
+
 	assignPartitionsFunc := func(stopCh2 chan struct{}, node string,
 		partitions, states, ops []string) error {
 		r.Logf("rebalance: assignPIndexes, index: %s, node: %s, partitions: %v,"+
@@ -636,17 +844,24 @@ func (r *Rebalancer) rebalanceIndex(stopCh chan struct{},
 		return nil
 	}
 
+	scheduler := r.moveScheduler()
+
+	findMoveFunc := func(node string, moves []blance.PartitionMove) int {
+		return scheduler.FindMove(indexDef, node, moves)
+	}
+
 	o, err := blance.OrchestrateMoves(
 		partitionModel,
 		blance.OrchestratorOptions{
-			MaxConcurrentPartitionMovesPerNode: r.optionsReb.MaxConcurrentPartitionMovesPerNode,
-			FavorMinNodes:                      r.optionsReb.FavorMinNodes,
+			MaxConcurrentPartitionMovesPerNode: scheduler.MaxConcurrentPartitionMovesPerNode(
+				indexDef, r.optionsReb.MaxConcurrentPartitionMovesPerNode),
+			FavorMinNodes: r.optionsReb.FavorMinNodes,
 		},
 		r.nodesAll,
 		begMap,
 		endMap,
 		assignPartitionsFunc,
-		blance.LowestWeightPartitionMoveForNode) // TODO: concurrency.
+		findMoveFunc) // TODO: concurrency.
 	if err != nil {
 		return false, err
 	}
@@ -762,7 +977,12 @@ func (r *Rebalancer) calcBegEndMaps(indexDef *cbgt.IndexDef) (
 	}
 
 	var warnings map[string][]string
-	if r.recoveryPlanPIndexes != nil {
+	if r.resumePlanPIndexesLOCKED(indexDef, endPlanPIndexesForIndex) {
+		// The end plan of an index that was being rebalanced when the
+		// rebalance was interrupted is kept, so that only its remaining
+		// moves are made.
+		r.Logf("  calcBegEndMaps: resumed plan for index: %s", indexDef.Name)
+	} else if r.recoveryPlanPIndexes != nil {
 		// During the failover, cbgt ignores the new nextMap from blance
 		// and just promotes the replica partitions to primary.
 		// Hence during the failover-recovery rebalance operation,
@@ -802,6 +1022,8 @@ func (r *Rebalancer) calcBegEndMaps(indexDef *cbgt.IndexDef) (
 		}
 	}
 
+	r.keepCanceledMovesLOCKED(endPlanPIndexesForIndex)
+
 	for partitionName, partitionWarning := range warnings {
 		if _, exists := r.endPlanPIndexes.PlanPIndexes[partitionName]; exists {
 			if r.endPlanPIndexes.PlanPIndexes[partitionName].IndexName == indexDef.Name {
@@ -878,7 +1100,7 @@ func (r *Rebalancer) adjustNodeWeights(
 	indexDef *cbgt.IndexDef,
 	planPIndexesForIndex map[string]*cbgt.PlanPIndex,
 	enablePartitionNodeStickiness bool) map[string]int {
-	nodeWeights := r.nodeWeights
+	nodeWeights := r.headroomNodeWeights
 	if RebalanceHook != nil {
 		rho, _, err := RebalanceHook(RebalanceHookInfo{
 			Phase: RebalanceHookPhaseAdjustNodeWeights,
@@ -889,7 +1111,7 @@ func (r *Rebalancer) adjustNodeWeights(
 			NodeUUIDsAll:         r.nodesAll,
 			NodeUUIDsToAdd:       r.nodesToAdd,
 			NodeUUIDsToRemove:    r.nodesToRemove,
-			NodeWeights:          r.nodeWeights,
+			NodeWeights:          r.headroomNodeWeights,
 			NodeHierarchy:        r.nodeHierarchy,
 			ExistingPlanPIndexes: r.existingPlanPIndexes,
 			BegPlanPIndexes:      r.begPlanPIndexes,
@@ -942,6 +1164,11 @@ func (r *Rebalancer) assignPIndexes(stopCh, stopCh2 chan struct{},
 	var next int
 	for len(pindexesMoves) > 0 {
 		r.m.Lock() // Reduce but not eliminate CAS conflicts.
+		pindexesMoves = r.removeCanceledMovesLOCKED(pindexesMoves)
+		if len(pindexesMoves) == 0 {
+			r.m.Unlock()
+			break
+		}
 		indexDef, planPIndexes, formerPrimaryNodes, err := r.assignPIndexesLOCKED(
 			index, node, pindexesMoves, next)
 		r.m.Unlock()
@@ -966,12 +1193,41 @@ func (r *Rebalancer) assignPIndexes(stopCh, stopCh2 chan struct{},
 		for i := 0; i < len(pindexesMoves); i++ {
 			wg.Add(1)
 			go func(pm *pindexMoves, formerPrimaryNode string) {
-				err := r.waitAssignPIndexDone(stopCh, stopCh2,
+				_, span := cbgt.StartSpan(r.optionsReb.TraceContext,
+					"cbgt.rebalance.MovePIndex", cbgt.TraceAttributes{
+						"index":  index,
+						"pindex": pm.name,
+						"node":   node,
+						"state":  pm.stateOps[next].State,
+						"op":     pm.stateOps[next].Op,
+					})
+
+				activityDone := r.optionsReb.Manager.StartActivity(
+					cbgt.ACTIVITY_REBALANCE_MOVE, pm.name, index, node)
+
+				moveStopCh, release := r.moveStopCh(stopCh2, pm.name)
+
+				err := r.waitAssignPIndexDone(stopCh, moveStopCh,
 					indexDef, planPIndexes, pm.name, node,
 					pm.stateOps[next].State,
 					pm.stateOps[next].Op,
 					formerPrimaryNode,
 					len(pm.stateOps) > 1)
+
+				release()
+
+				if err != nil && r.isMoveCanceled(pm.name) {
+					r.Logf("rebalance: assignPIndexes, index: %s,"+
+						" pindex: %s, node: %s, move canceled, err: %v",
+						index, pm.name, node, err)
+					err = nil
+				}
+
+				activityDone()
+
+				span.RecordError(err)
+				span.End()
+
 				doneCh <- err
 				wg.Done()
 			}(pindexesMoves[i], formerPrimaryNodes[i])
@@ -1077,45 +1333,58 @@ func (r *Rebalancer) assignPIndexesLOCKED(index string, node string,
 		}
 	}
 
-	indexDefs, err := cbgt.PlannerGetIndexDefs(r.cfg, r.version)
-	if err != nil {
-		return nil, nil, nil, err
-	}
+	var indexDef *cbgt.IndexDef
+	var planPIndexes *cbgt.PlanPIndexes
+	var formerPrimaryNodes []string
 
-	indexDef := indexDefs.IndexDefs[index]
-	if indexDef == nil {
-		r.Logf("rebalance: assignPIndexesLOCKED,"+
-			" empty definitions found for index: %s", index)
-		return nil, nil, nil, ErrorNoIndexDefinitionFound
-	}
+	// The plan is re-read and re-updated on the CAS conflicts, such as
+	// with the planners.
+	err := cbgt.CfgRetryOnCAS("rebalance", 100, func() error {
+		indexDefs, err := cbgt.PlannerGetIndexDefs(r.cfg, r.version)
+		if err != nil {
+			return err
+		}
 
-	planPIndexes, cas, err := cbgt.PlannerGetPlanPIndexes(r.cfg, r.version)
-	if err != nil {
-		return nil, nil, nil, err
-	}
+		indexDef = indexDefs.IndexDefs[index]
+		if indexDef == nil {
+			r.Logf("rebalance: assignPIndexesLOCKED,"+
+				" empty definitions found for index: %s", index)
+			return ErrorNoIndexDefinitionFound
+		}
 
-	formerPrimaryNodes := make([]string, len(pms))
-	for i, pm := range pms {
-		formerPrimaryNodes[i], err = r.updatePlanPIndexesLOCKED(planPIndexes,
-			indexDef, pm.name, node, pm.stateOps[next].State,
-			pm.stateOps[next].Op)
+		var cas uint64
+		planPIndexes, cas, err = cbgt.PlannerGetPlanPIndexes(r.cfg, r.version)
 		if err != nil {
-			return nil, nil, nil,
-				fmt.Errorf("updatePlanPIndexesLOCKED err: %v, %w",
+			return err
+		}
+
+		formerPrimaryNodes = make([]string, len(pms))
+		for i, pm := range pms {
+			formerPrimaryNodes[i], err = r.updatePlanPIndexesLOCKED(planPIndexes,
+				indexDef, pm.name, node, pm.stateOps[next].State,
+				pm.stateOps[next].Op)
+			if err != nil {
+				return fmt.Errorf("updatePlanPIndexesLOCKED err: %v, %w",
 					err, ErrorConcurrentPlannerInProgress)
+			}
 		}
-	}
 
-	if r.optionsReb.DryRun {
-		return nil, nil, formerPrimaryNodes, nil
-	}
+		if r.optionsReb.DryRun {
+			return nil
+		}
 
-	_, err = cbgt.CfgSetPlanPIndexes(r.cfg, planPIndexes, cas)
+		_, err = cbgt.CfgSetPlanPIndexes(r.cfg, planPIndexes, cas)
+		return err
+	})
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	return indexDef, planPIndexes, formerPrimaryNodes, err
+	if r.optionsReb.DryRun {
+		return nil, nil, formerPrimaryNodes, nil
+	}
+
+	return indexDef, planPIndexes, formerPrimaryNodes, nil
 }
 
 // --------------------------------------------------------
@@ -1581,6 +1850,15 @@ CATCHUP_CUR_SEQ:
 		}
 	}
 
+	// Warm the caught up pindex before it takes traffic, which is
+	// before its promotion in a replica-promotion maneuver, or else
+	// right after its direct assignment as a primary.
+	if r.optionsReb.WarmCache &&
+		((state == "replica" && forceWaitForCatchup) ||
+			(state == "primary" && !forceWaitForCatchup)) {
+		r.warmPIndex(pindex, formerPrimaryNode, node)
+	}
+
 	return nil
 }
 
@@ -10,24 +10,80 @@ package rebalance
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/couchbase/blance"
 	"github.com/couchbase/cbgt"
 	log "github.com/couchbase/clog"
 )
 
+// testMoveSpan is a span recorded by the testMoveTracer.
+type testMoveSpan struct {
+	name   string
+	parent string
+	attrs  cbgt.TraceAttributes
+	ended  bool
+}
+
+type testMoveSpanKey struct{}
+
+// testMoveTracer is a cbgt.Tracer that records its spans.
+type testMoveTracer struct {
+	m     sync.Mutex
+	spans []*testMoveSpan
+}
+
+func (t *testMoveTracer) Start(ctx context.Context, name string,
+	attrs cbgt.TraceAttributes) (context.Context, cbgt.TraceSpan) {
+	s := &testMoveSpan{name: name, attrs: attrs}
+	if parent, ok := ctx.Value(testMoveSpanKey{}).(*testMoveSpan); ok {
+		s.parent = parent.name
+	}
+
+	t.m.Lock()
+	t.spans = append(t.spans, s)
+	t.m.Unlock()
+
+	return context.WithValue(ctx, testMoveSpanKey{}, s),
+		&testMoveTracerSpan{t: t, s: s}
+}
+
+type testMoveTracerSpan struct {
+	t *testMoveTracer
+	s *testMoveSpan
+}
+
+func (ts *testMoveTracerSpan) SetAttributes(attrs cbgt.TraceAttributes) {}
+func (ts *testMoveTracerSpan) RecordError(err error)                    {}
+
+func (ts *testMoveTracerSpan) End() {
+	ts.t.m.Lock()
+	ts.s.ended = true
+	ts.t.m.Unlock()
+}
+
 func TestRebalance(t *testing.T) {
 	testDir, _ := os.MkdirTemp("./tmp", "test")
 	defer os.RemoveAll(testDir)
 
+	tracer := &testMoveTracer{}
+	cbgt.SetTracer(tracer)
+	defer cbgt.SetTracer(nil)
+
+	traceCtx, rootSpan := cbgt.StartSpan(nil, "root", nil)
+	defer rootSpan.End()
+
 	nodeDir := func(node string) string {
 		d := testDir + string(os.PathSeparator) + node
 		os.MkdirAll(d, 0700)
@@ -190,6 +246,7 @@ func TestRebalance(t *testing.T) {
 			RebalanceOptions{
 				HttpGet:       httpGet,
 				SkipSeqChecks: true,
+				TraceContext:  traceCtx,
 			},
 		)
 		if (test.expStartErr && err == nil) ||
@@ -296,6 +353,25 @@ func TestRebalance(t *testing.T) {
 			}
 		})
 	}
+
+	// Every pindex move is traced as a child of the trace context.
+	tracer.m.Lock()
+	defer tracer.m.Unlock()
+
+	moves := 0
+	for _, s := range tracer.spans {
+		if s.name != "cbgt.rebalance.MovePIndex" {
+			continue
+		}
+		moves++
+		if s.parent != "root" || !s.ended || s.attrs["index"] == "" ||
+			s.attrs["pindex"] == "" || s.attrs["node"] == "" {
+			t.Errorf("unexpected move span: %+v", s)
+		}
+	}
+	if moves == 0 {
+		t.Errorf("expected move spans")
+	}
 }
 
 func testCreateIndex(t *testing.T,
@@ -741,3 +817,552 @@ func TestRebalanceStatsErrorCase(t *testing.T) {
 		})
 	}
 }
+
+type smallestFirstMoveScheduler struct {
+	DefaultMoveScheduler
+	sizes map[string]int
+}
+
+func (s *smallestFirstMoveScheduler) FindMove(indexDef *cbgt.IndexDef,
+	node string, moves []blance.PartitionMove) int {
+	found := -1
+	for i, move := range moves {
+		if found < 0 || s.sizes[move.Partition] < s.sizes[moves[found].Partition] {
+			found = i
+		}
+	}
+	return found
+}
+
+func TestMoveSchedulerRegistry(t *testing.T) {
+	if _, ok := GetMoveScheduler("").(DefaultMoveScheduler); !ok {
+		t.Errorf("expected the default move scheduler")
+	}
+	if _, ok := GetMoveScheduler("unknown").(DefaultMoveScheduler); !ok {
+		t.Errorf("expected the default move scheduler for an unknown name")
+	}
+
+	s := &smallestFirstMoveScheduler{
+		sizes: map[string]int{"a": 30, "b": 10, "c": 20},
+	}
+	RegisterMoveScheduler("smallestFirst", s)
+	defer RegisterMoveScheduler("smallestFirst", nil)
+
+	r := &Rebalancer{
+		optionsMgr: map[string]string{"rebalanceMoveScheduler": "smallestFirst"},
+	}
+	if r.moveScheduler() != MoveScheduler(s) {
+		t.Errorf("expected the registered move scheduler")
+	}
+
+	moves := []blance.PartitionMove{
+		{Partition: "a", Node: "n0", State: "primary", Op: "add"},
+		{Partition: "b", Node: "n0", State: "primary", Op: "add"},
+		{Partition: "c", Node: "n0", State: "primary", Op: "add"},
+	}
+	if i := r.moveScheduler().FindMove(nil, "n0", moves); i != 1 {
+		t.Errorf("expected the smallest partition move, got: %d", i)
+	}
+	if n := r.moveScheduler().MaxConcurrentPartitionMovesPerNode(nil, 4); n != 4 {
+		t.Errorf("expected the default max concurrent moves, got: %d", n)
+	}
+
+	r.optionsReb.MoveScheduler = DefaultMoveScheduler{}
+	if _, ok := r.moveScheduler().(DefaultMoveScheduler); !ok {
+		t.Errorf("expected the RebalanceOptions move scheduler to win")
+	}
+
+	RegisterMoveScheduler("smallestFirst", nil)
+	if _, ok := GetMoveScheduler("smallestFirst").(DefaultMoveScheduler); !ok {
+		t.Errorf("expected the default move scheduler after unregistering")
+	}
+}
+
+func TestCalcDrainTargets(t *testing.T) {
+	planPIndexes := cbgt.NewPlanPIndexes(cbgt.VERSION)
+	planPIndexes.PlanPIndexes["p0"] = &cbgt.PlanPIndex{
+		SourcePartitions: "0,1",
+		Nodes: map[string]*cbgt.PlanPIndexNode{
+			"a": {Priority: 0},
+			"b": {Priority: 1},
+		},
+	}
+	planPIndexes.PlanPIndexes["p1"] = &cbgt.PlanPIndex{
+		SourcePartitions: "2",
+		Nodes: map[string]*cbgt.PlanPIndexNode{
+			"b": {Priority: 0},
+			"a": {Priority: 1},
+		},
+	}
+	planPIndexes.PlanPIndexes["p2"] = &cbgt.PlanPIndex{
+		SourcePartitions: "3",
+		Nodes: map[string]*cbgt.PlanPIndexNode{
+			"a": {Priority: 0},
+		},
+	}
+
+	targets := CalcDrainTargets(planPIndexes, []string{"a"})
+	if len(targets) != 1 || targets[0].PIndex != "p0" ||
+		targets[0].Primary != "a" || len(targets[0].Replicas) != 1 ||
+		targets[0].Replicas[0] != "b" || len(targets[0].SourcePartitions) != 2 {
+		t.Fatalf("unexpected targets: %+v", targets)
+	}
+
+	currSeqs := map[string]map[string]map[string]cbgt.UUIDSeq{}
+	wantSeqs := map[string]map[string]map[string]cbgt.UUIDSeq{}
+
+	if pending := drainPending(targets, currSeqs, wantSeqs); len(pending) != 1 {
+		t.Fatalf("expected pending without seqs, got: %v", pending)
+	}
+
+	SetUUIDSeq(currSeqs, "p0", "0", "a", "u", 10, 10)
+	SetUUIDSeq(currSeqs, "p0", "1", "a", "u", 20, 20)
+	SetUUIDSeq(currSeqs, "p0", "0", "b", "u", 10, 10)
+	SetUUIDSeq(currSeqs, "p0", "1", "b", "u", 15, 20)
+	if pending := drainPending(targets, currSeqs, wantSeqs); len(pending) != 1 {
+		t.Fatalf("expected pending while behind, got: %v", pending)
+	}
+
+	// The primary moving on doesn't move the seqs to be reached.
+	SetUUIDSeq(currSeqs, "p0", "1", "a", "u", 30, 30)
+	SetUUIDSeq(currSeqs, "p0", "1", "b", "u", 20, 30)
+	if pending := drainPending(targets, currSeqs, wantSeqs); len(pending) != 0 {
+		t.Fatalf("expected drained, got: %v", pending)
+	}
+}
+
+func TestDrainReplicasUnreachable(t *testing.T) {
+	cfg := cbgt.NewCfgMem()
+
+	planPIndexes := cbgt.NewPlanPIndexes(cbgt.VERSION)
+	planPIndexes.PlanPIndexes["p0"] = &cbgt.PlanPIndex{
+		Name: "p0", IndexName: "i0", SourcePartitions: "0",
+		Nodes: map[string]*cbgt.PlanPIndexNode{
+			"a": {Priority: 0},
+			"b": {Priority: 1},
+		},
+	}
+	if _, err := cbgt.CfgSetPlanPIndexes(cfg, planPIndexes, 0); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	setNodeDefs := func(uuids ...string) {
+		nodeDefs := cbgt.NewNodeDefs(cbgt.VERSION)
+		for _, uuid := range uuids {
+			nodeDefs.NodeDefs[uuid] = &cbgt.NodeDef{
+				UUID: uuid, HostPort: uuid + ":8094", ImplVersion: cbgt.VERSION,
+			}
+		}
+		_, cas, _ := cbgt.CfgGetNodeDefs(cfg, cbgt.NODE_DEFS_WANTED)
+		_, err := cbgt.CfgSetNodeDefs(cfg, cbgt.NODE_DEFS_WANTED, nodeDefs, cas)
+		if err != nil {
+			t.Fatalf("expected no err, got: %v", err)
+		}
+	}
+
+	// The dead primary is no longer known.
+	setNodeDefs("b")
+	err := DrainReplicas(cfg, cbgt.VERSION, []string{"a"},
+		DrainOptions{}, nil)
+	if !errors.Is(err, ErrorDrainUnreachable) {
+		t.Fatalf("expected unreachable, got: %v", err)
+	}
+
+	// The dead primary is known, but doesn't respond.
+	setNodeDefs("a", "b")
+	errThreshold := 1
+	err = DrainReplicas(cfg, cbgt.VERSION, []string{"a"},
+		DrainOptions{
+			StatsSampleErrorThreshold: &errThreshold,
+			HttpGet: func(url string) (*http.Response, error) {
+				return nil, fmt.Errorf("connection refused")
+			},
+		}, nil)
+	if !errors.Is(err, ErrorDrainUnreachable) {
+		t.Fatalf("expected unreachable, got: %v", err)
+	}
+}
+
+func TestCancelMove(t *testing.T) {
+	cfg := cbgt.NewCfgMem()
+
+	begPlanPIndexes := cbgt.NewPlanPIndexes(cbgt.VERSION)
+	begPlanPIndexes.PlanPIndexes["p0"] = &cbgt.PlanPIndex{
+		Name: "p0", IndexName: "i0",
+		Nodes: map[string]*cbgt.PlanPIndexNode{
+			"a": {Priority: 0},
+			"b": {Priority: 1},
+		},
+	}
+	begPlanPIndexes.PlanPIndexes["p1"] = &cbgt.PlanPIndex{
+		Name: "p1", IndexName: "i0",
+		Nodes: map[string]*cbgt.PlanPIndexNode{
+			"x": {Priority: 0},
+		},
+	}
+
+	// The rebalance has started to move p0 from b to c.
+	planPIndexes := cbgt.CopyPlanPIndexes(begPlanPIndexes, cbgt.VERSION)
+	planPIndexes.PlanPIndexes["p0"].Nodes["c"] = &cbgt.PlanPIndexNode{Priority: 2}
+	_, err := cbgt.CfgSetPlanPIndexes(cfg, planPIndexes, 0)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	r := &Rebalancer{
+		version:         cbgt.VERSION,
+		cfg:             cfg,
+		optionsReb:      RebalanceOptions{Verbose: -1},
+		nodesToRemove:   []string{"x"},
+		begPlanPIndexes: begPlanPIndexes,
+		endPlanPIndexes: cbgt.NewPlanPIndexes(cbgt.VERSION),
+		canceledMoves:   map[string]bool{},
+		moveCancelChs:   map[string]chan struct{}{},
+	}
+
+	stopCh := make(chan struct{})
+	moveStopCh, release := r.moveStopCh(stopCh, "p0")
+	defer release()
+
+	if err = r.CancelMove("p1"); !errors.Is(err, ErrorMoveNotCancelable) {
+		t.Fatalf("expected a pindex on a removed node to fail, err: %v", err)
+	}
+	if err = r.CancelMove("unknown"); !errors.Is(err, ErrorMoveNotCancelable) {
+		t.Fatalf("expected an unknown pindex to fail, err: %v", err)
+	}
+
+	if err = r.CancelMove("p0"); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	select {
+	case <-moveStopCh:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the in-flight move to be interrupted")
+	}
+
+	planPIndexes, _, err = cbgt.CfgGetPlanPIndexes(cfg)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if nodes := planPIndexes.PlanPIndexes["p0"].Nodes; len(nodes) != 2 ||
+		nodes["a"] == nil || nodes["b"] == nil {
+		t.Fatalf("expected p0 restored onto a and b, got: %+v", nodes)
+	}
+
+	pms := r.removeCanceledMovesLOCKED([]*pindexMoves{{name: "p0"}, {name: "p1"}})
+	if len(pms) != 1 || pms[0].name != "p1" {
+		t.Fatalf("expected the p0 moves to be skipped, got: %+v", pms)
+	}
+
+	if canceled := r.CanceledMoves(); len(canceled) != 1 || canceled[0] != "p0" {
+		t.Fatalf("unexpected canceled moves: %v", canceled)
+	}
+
+	// Later moves of a canceled pindex are interrupted right away.
+	moveStopCh2, release2 := r.moveStopCh(stopCh, "p0")
+	defer release2()
+	select {
+	case <-moveStopCh2:
+	default:
+		t.Fatalf("expected a later move to be interrupted")
+	}
+}
+
+func TestRebalancePlanResume(t *testing.T) {
+	testDir, _ := os.MkdirTemp("./tmp", "test")
+	defer os.RemoveAll(testDir)
+
+	httpGet := func(url string) (resp *http.Response, err error) {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewBuffer([]byte("{}"))),
+		}, nil
+	}
+
+	cfg := cbgt.NewCfgMem()
+
+	rebalance := func(topologyChangeID string) *Rebalancer {
+		r, err := StartRebalance(cbgt.VERSION, cfg, ".", nil, nil,
+			RebalanceOptions{HttpGet: httpGet, SkipSeqChecks: true,
+				TopologyChangeID: topologyChangeID})
+		if err != nil || r == nil {
+			t.Fatalf("expected a rebalance, got: %v, err: %v", r, err)
+		}
+		for progress := range r.ProgressCh() {
+			if progress.Error != nil {
+				t.Fatalf("expected no progress err, got: %v", progress.Error)
+			}
+		}
+		return r
+	}
+
+	mgrA, err := startNodeManager(testDir, cfg, "a", "wanted", nil, "")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	defer mgrA.Stop()
+
+	testCreateIndex(t, mgrA, "x", nil, func() {})
+
+	rebalance("change-0")
+
+	plan, _, err := CfgGetRebalancePlan(cfg)
+	if err != nil || plan != nil {
+		t.Fatalf("expected the plan to be deleted, got: %+v, err: %v", plan, err)
+	}
+
+	mgrB, err := startNodeManager(testDir, cfg, "b", "wanted", nil, "")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	defer mgrB.Stop()
+
+	indexDefs, _, err := cbgt.CfgGetIndexDefs(cfg)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	planPIndexes, _, err := cbgt.CfgGetPlanPIndexes(cfg)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	// The interrupted rebalance had planned all of the pindexes of x
+	// onto b, rather than evenly across a and b.
+	nodes := map[string][]string{}
+	for name := range planPIndexes.PlanPIndexes {
+		nodes[name] = []string{"b"}
+	}
+
+	interrupted := &RebalancePlan{
+		UUID:             "interrupted",
+		TopologyChangeID: "change-1",
+		ImplVersion:      cbgt.VERSION,
+		NodesAll:         []string{"a", "b"},
+		NodesToAdd:       []string{"b"},
+		IndexNames:       []string{"x"},
+	}
+
+	_, err = CfgSetRebalancePlan(cfg, interrupted, 0)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	_, err = CfgSetRebalancePlanIndex(cfg, "x", &RebalancePlanIndex{
+		PlanUUID:  "interrupted",
+		IndexUUID: indexDefs.IndexDefs["x"].UUID,
+		Status:    RebalancePlanIndexRunning,
+		Nodes:     nodes,
+	}, 0)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	// The plan of other nodes to keep and eject isn't resumed.
+	if loadResumablePlan(cfg, cbgt.VERSION, "", []string{"a", "b"},
+		[]string{"a"}, RebalanceOptions{TopologyChangeID: "change-1"}) != nil {
+		t.Fatalf("expected no plan for other nodes to eject")
+	}
+	if loadResumablePlan(cfg, cbgt.VERSION, "", []string{"a", "b", "c"},
+		nil, RebalanceOptions{TopologyChangeID: "change-1"}) != nil {
+		t.Fatalf("expected no plan for other nodes to keep")
+	}
+
+	// The retry of the topology change has a new ID.
+	r := rebalance("change-1-retry")
+	if !r.Resumed() {
+		t.Fatalf("expected the rebalance to resume the plan")
+	}
+
+	planPIndexes, _, err = cbgt.CfgGetPlanPIndexes(cfg)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	for name, planPIndex := range planPIndexes.PlanPIndexes {
+		if len(planPIndex.Nodes) != 1 || planPIndex.Nodes["b"] == nil {
+			t.Fatalf("expected pindex: %s, on b only, got: %+v",
+				name, planPIndex.Nodes)
+		}
+	}
+
+	plan, _, err = CfgGetRebalancePlan(cfg)
+	if err != nil || plan != nil {
+		t.Fatalf("expected the plan to be deleted, got: %+v, err: %v", plan, err)
+	}
+	planIndex, _, err := CfgGetRebalancePlanIndex(cfg, "x")
+	if err != nil || planIndex != nil {
+		t.Fatalf("expected the index plan to be deleted, got: %+v, err: %v",
+			planIndex, err)
+	}
+
+	// A canceled rebalance deletes its plan.
+	mgrC, err := startNodeManager(testDir, cfg, "c", "wanted", nil, "")
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	defer mgrC.Stop()
+
+	r, err = StartRebalance(cbgt.VERSION, cfg, ".", nil, nil,
+		RebalanceOptions{HttpGet: httpGet, SkipSeqChecks: true,
+			TopologyChangeID: "change-3"})
+	if err != nil || r == nil {
+		t.Fatalf("expected a rebalance, got: %v, err: %v", r, err)
+	}
+
+	plan, _, err = CfgGetRebalancePlan(cfg)
+	if err != nil || plan == nil || plan.TopologyChangeID != "change-3" {
+		t.Fatalf("expected the plan to be persisted, got: %+v, err: %v",
+			plan, err)
+	}
+
+	r.Stop()
+	for range r.ProgressCh() {
+	}
+
+	plan, _, err = CfgGetRebalancePlan(cfg)
+	if err != nil || plan != nil {
+		t.Fatalf("expected the canceled plan to be deleted, got: %+v, err: %v",
+			plan, err)
+	}
+}
+
+func TestOrderIndexesByRebalancePriority(t *testing.T) {
+	indexDefs := []*cbgt.IndexDef{
+		{Name: "bulk1"},
+		{Name: "critical", PlanParams: cbgt.PlanParams{RebalancePriority: 10}},
+		{Name: "bulk0"},
+		{Name: "low", PlanParams: cbgt.PlanParams{RebalancePriority: -1}},
+		{Name: "high", PlanParams: cbgt.PlanParams{RebalancePriority: 5}},
+		{Name: "bg", PlanParams: cbgt.PlanParams{RebalancePriority: 100,
+			PriorityClass: cbgt.PriorityClassBackground}},
+		{Name: "vip", PlanParams: cbgt.PlanParams{
+			PriorityClass: cbgt.PriorityClassCritical}},
+	}
+
+	var names []string
+	for _, indexDef := range (DefaultMoveScheduler{}).OrderIndexes(indexDefs) {
+		names = append(names, indexDef.Name)
+	}
+
+	exp := "vip critical high bulk0 bulk1 low bg"
+	if strings.Join(names, " ") != exp {
+		t.Fatalf("expected order: %s, got: %v", exp, names)
+	}
+
+	if indexDefs[0].Name != "bulk1" {
+		t.Fatalf("expected the input to be left as is")
+	}
+}
+
+func TestCalcDiskUsageWarnings(t *testing.T) {
+	defer func() {
+		cbgt.NodeDiskCapacityHook = nil
+		cbgt.PIndexDiskSizeHook = nil
+	}()
+
+	indexDefs := cbgt.NewIndexDefs(cbgt.VERSION)
+	indexDefs.IndexDefs["i0"] = &cbgt.IndexDef{
+		Type:         "blackhole",
+		Name:         "i0",
+		UUID:         "i0uuid",
+		SourceType:   "primary",
+		SourceName:   "s0",
+		SourceParams: `{"numPartitions":2}`,
+		PlanParams:   cbgt.PlanParams{MaxPartitionsPerPIndex: 1},
+	}
+
+	nodeDefs := cbgt.NewNodeDefs(cbgt.VERSION)
+	for _, node := range []string{"a", "b"} {
+		nodeDefs.NodeDefs[node] = &cbgt.NodeDef{UUID: node, HostPort: node,
+			Tags: []string{"pindex"}, Weight: 1, ImplVersion: cbgt.VERSION}
+	}
+
+	begPlanPIndexes, err := cbgt.CalcPlan("", indexDefs, nodeDefs,
+		cbgt.NewPlanPIndexes(cbgt.VERSION), cbgt.VERSION, "", nil, nil)
+	if err != nil || len(begPlanPIndexes.PlanPIndexes) != 2 {
+		t.Fatalf("expected 2 pindexes, got: %+v, err: %v", begPlanPIndexes, err)
+	}
+
+	warnings, err := calcDiskUsageWarnings(cbgt.VERSION, "", nil,
+		indexDefs, nodeDefs, begPlanPIndexes, []string{"a"})
+	if err != nil || warnings != nil {
+		t.Fatalf("expected no warnings without hooks, got: %v, err: %v",
+			warnings, err)
+	}
+
+	cbgt.NodeDiskCapacityHook = func(nodeDef *cbgt.NodeDef) (
+		*cbgt.NodeDiskCapacity, error) {
+		return &cbgt.NodeDiskCapacity{Used: 70, Total: 100}, nil
+	}
+	cbgt.PIndexDiskSizeHook = func(planPIndex *cbgt.PlanPIndex) (int64, error) {
+		return 30, nil
+	}
+
+	// Removing a moves its pindex, which doesn't fit under the high-water
+	// mark, onto b.
+	warnings, err = calcDiskUsageWarnings(cbgt.VERSION, "", nil,
+		indexDefs, nodeDefs, begPlanPIndexes, []string{"a"})
+	if err != nil || len(warnings) != 1 || len(warnings["node:b"]) != 1 {
+		t.Fatalf("expected a warning for b, got: %v, err: %v", warnings, err)
+	}
+
+	warnings, err = calcDiskUsageWarnings(cbgt.VERSION, "",
+		map[string]string{"diskHighWaterMarkAction": "refuse"},
+		indexDefs, nodeDefs, begPlanPIndexes, []string{"a"})
+	if !errors.Is(err, cbgt.ErrDiskHighWaterMark) {
+		t.Fatalf("expected a refusal, got: %v, err: %v", warnings, err)
+	}
+
+	// Without a topology change, nothing moves.
+	warnings, err = calcDiskUsageWarnings(cbgt.VERSION, "", nil,
+		indexDefs, nodeDefs, begPlanPIndexes, nil)
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got: %v, err: %v", warnings, err)
+	}
+}
+
+func TestWarmPIndex(t *testing.T) {
+	var warmed []byte
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/src/api/pindex/p0/hotState",
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(`{"status":"ok","hotState":"aG90"}`)) // "hot"
+		})
+	mux.HandleFunc("/src/api/pindex/p1/hotState",
+		func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, "not supported", http.StatusNotImplemented)
+		})
+	mux.HandleFunc("/dst/api/pindex/p0/warm",
+		func(w http.ResponseWriter, req *http.Request) {
+			warmed, _ = io.ReadAll(req.Body)
+			w.Write([]byte(`{"status":"ok"}`))
+		})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	nodeDefs := cbgt.NewNodeDefs(cbgt.VERSION)
+	nodeDefs.NodeDefs["a"] = &cbgt.NodeDef{UUID: "a", HostPort: host + "/src"}
+	nodeDefs.NodeDefs["b"] = &cbgt.NodeDef{UUID: "b", HostPort: host + "/dst"}
+
+	r := &Rebalancer{
+		optionsReb:  RebalanceOptions{Verbose: -1},
+		begNodeDefs: nodeDefs,
+	}
+
+	if err := r.shipHotState("p0", "a", "b"); err != nil ||
+		string(warmed) != "hot" {
+		t.Fatalf("expected p0 to be warmed, got: %q, err: %v", warmed, err)
+	}
+
+	warmed = nil
+	if err := r.shipHotState("p1", "a", "b"); err == nil || warmed != nil {
+		t.Fatalf("expected an unsupported p1 to not be warmed, err: %v", err)
+	}
+
+	if err := r.shipHotState("p0", "a", "unknown"); err == nil {
+		t.Fatalf("expected an err for an unknown node")
+	}
+}
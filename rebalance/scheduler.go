@@ -0,0 +1,129 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package rebalance
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/couchbase/blance"
+	"github.com/couchbase/cbgt"
+)
+
+// A MoveScheduler controls the ordering, batching and prioritization
+// of the pindex moves performed by a Rebalancer.  Applications that
+// embed cbgt can register their own MoveScheduler, for example, to
+// move the smallest partitions first.
+type MoveScheduler interface {
+	// OrderIndexes returns the order in which the given index
+	// definitions are to be rebalanced, one index at a time.
+	OrderIndexes(indexDefs []*cbgt.IndexDef) []*cbgt.IndexDef
+
+	// MaxConcurrentPartitionMovesPerNode returns the number of
+	// concurrent partition moves allowed per node for an index,
+	// where maxDefault is the value from the RebalanceOptions.
+	MaxConcurrentPartitionMovesPerNode(indexDef *cbgt.IndexDef,
+		maxDefault int) int
+
+	// FindMove returns the index of the next move to perform out of
+	// the pending moves for a node, or -1 when there's no move to
+	// perform right now.
+	FindMove(indexDef *cbgt.IndexDef, node string,
+		moves []blance.PartitionMove) int
+}
+
+// DefaultMoveScheduler is the MoveScheduler used when no other
+// MoveScheduler has been chosen, which rebalances the indexes in the
+// order of their PlanParams.PriorityClass and RebalancePriority, and
+// performs the lowest weight partition moves first (e.g., promotions
+// before additions).
+type DefaultMoveScheduler struct{}
+
+func (DefaultMoveScheduler) OrderIndexes(
+	indexDefs []*cbgt.IndexDef) []*cbgt.IndexDef {
+	return OrderIndexesByRebalancePriority(indexDefs)
+}
+
+func (DefaultMoveScheduler) MaxConcurrentPartitionMovesPerNode(
+	indexDef *cbgt.IndexDef, maxDefault int) int {
+	return maxDefault
+}
+
+func (DefaultMoveScheduler) FindMove(indexDef *cbgt.IndexDef, node string,
+	moves []blance.PartitionMove) int {
+	return blance.LowestWeightPartitionMoveForNode(node, moves)
+}
+
+// OrderIndexesByRebalancePriority returns the index definitions
+// ordered by their PlanParams.PriorityClass and then their
+// RebalancePriority, highest first, and then by name, so that the
+// order is deterministic.
+func OrderIndexesByRebalancePriority(
+	indexDefs []*cbgt.IndexDef) []*cbgt.IndexDef {
+	rv := append([]*cbgt.IndexDef(nil), indexDefs...)
+	sort.SliceStable(rv, func(i, j int) bool {
+		ci := cbgt.PriorityClassRank(rv[i].PlanParams.PriorityClass)
+		cj := cbgt.PriorityClassRank(rv[j].PlanParams.PriorityClass)
+		if ci != cj {
+			return ci > cj
+		}
+		pi := rv[i].PlanParams.RebalancePriority
+		pj := rv[j].PlanParams.RebalancePriority
+		if pi != pj {
+			return pi > pj
+		}
+		return rv[i].Name < rv[j].Name
+	})
+	return rv
+}
+
+// --------------------------------------------------------
+
+var moveSchedulersM sync.RWMutex
+
+// moveSchedulers is a registry of the available MoveScheduler's,
+// keyed by name.  The "rebalanceMoveScheduler" manager option
+// chooses the MoveScheduler used by a rebalance.
+var moveSchedulers = map[string]MoveScheduler{
+	"": DefaultMoveScheduler{},
+}
+
+// RegisterMoveScheduler registers a MoveScheduler under a name, so
+// that it can be chosen via the "rebalanceMoveScheduler" manager
+// option.  Registering a nil MoveScheduler unregisters the name.
+func RegisterMoveScheduler(name string, s MoveScheduler) {
+	moveSchedulersM.Lock()
+	if s != nil {
+		moveSchedulers[name] = s
+	} else if name != "" {
+		delete(moveSchedulers, name)
+	}
+	moveSchedulersM.Unlock()
+}
+
+// GetMoveScheduler returns the MoveScheduler registered under a
+// name, or the DefaultMoveScheduler if there's no such registration.
+func GetMoveScheduler(name string) MoveScheduler {
+	moveSchedulersM.RLock()
+	s, exists := moveSchedulers[name]
+	moveSchedulersM.RUnlock()
+	if !exists || s == nil {
+		return DefaultMoveScheduler{}
+	}
+	return s
+}
+
+// moveScheduler returns the MoveScheduler to be used by the
+// rebalancer, preferring the one from the RebalanceOptions.
+func (r *Rebalancer) moveScheduler() MoveScheduler {
+	if r.optionsReb.MoveScheduler != nil {
+		return r.optionsReb.MoveScheduler
+	}
+	return GetMoveScheduler(r.optionsMgr["rebalanceMoveScheduler"])
+}
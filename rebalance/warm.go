@@ -0,0 +1,97 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package rebalance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/rest/monitor"
+)
+
+// warmPIndex runs the optional warm cache phase of a partition move,
+// which ships the hot state of the pindex on the sourceNode to the
+// node, so that the node pre-warms its copy of the pindex before the
+// copy takes traffic.  The phase is best effort, so that a pindex
+// implementation without warm support, or a failure, doesn't fail the
+// move, and is only logged.
+func (r *Rebalancer) warmPIndex(pindex, sourceNode, node string) {
+	err := r.shipHotState(pindex, sourceNode, node)
+	if err != nil {
+		r.Logf("rebalance: warmPIndex, pindex: %s, sourceNode: %s,"+
+			" node: %s, skipped, err: %v", pindex, sourceNode, node, err)
+		return
+	}
+
+	r.Logf("rebalance: warmPIndex, pindex: %s, sourceNode: %s,"+
+		" node: %s, warmed", pindex, sourceNode, node)
+}
+
+func (r *Rebalancer) shipHotState(pindex, sourceNode, node string) error {
+	urls := map[string]string{}
+	for _, urlUUID := range monitor.NodeDefsUrlUUIDs(r.begNodeDefs) {
+		urls[urlUUID.UUID] = urlUUID.Url
+	}
+	if urls[sourceNode] == "" || urls[node] == "" {
+		return fmt.Errorf("no url for sourceNode or node")
+	}
+
+	httpGet := r.optionsReb.HttpGet
+	if httpGet == nil {
+		httpGet = cbgt.HttpClient().Get
+	}
+
+	resp, err := httpGet(urls[sourceNode] + "/api/pindex/" + pindex + "/hotState")
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("hotState, status: %d, body: %s",
+			resp.StatusCode, body)
+	}
+
+	var rv struct {
+		HotState []byte `json:"hotState"`
+	}
+	err = json.Unmarshal(body, &rv)
+	if err != nil {
+		return err
+	}
+
+	httpPost := r.optionsReb.HttpPost
+	if httpPost == nil {
+		httpPost = cbgt.HttpClient().Post
+	}
+
+	resp, err = httpPost(urls[node]+"/api/pindex/"+pindex+"/warm",
+		"application/octet-stream", bytes.NewReader(rv.HotState))
+	if err != nil {
+		return err
+	}
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("warm, status: %d, body: %s",
+			resp.StatusCode, body)
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"sort"
+	"time"
+)
+
+// The recovery point objective (RPO) of a replica is how far behind
+// its primary the replica is, which approximates the mutations that
+// would be lost, or have to be re-ingested, if the replica were
+// promoted, such as by a graceful failover of the primary's node.
+
+// A ReplicaRPO is how far a pindex replica is behind its primary.
+type ReplicaRPO struct {
+	PIndex    string `json:"pindex"`
+	IndexName string `json:"indexName"`
+	Node      string `json:"node"`    // The replica's node UUID.
+	Primary   string `json:"primary"` // The primary's node UUID.
+
+	// SeqGap is the sum, over the source partitions, of the seqs that
+	// the primary has processed and the replica has not.
+	SeqGap uint64 `json:"seqGap"`
+
+	// LagSecs estimates the wall-clock lag of the replica, as the
+	// largest difference, over the source partitions that the replica
+	// is behind on, between when the primary and the replica processed
+	// their last seqs.  LagKnown is false when the processing times
+	// aren't reported.
+	LagSecs  float64 `json:"lagSecs"`
+	LagKnown bool    `json:"lagKnown"`
+}
+
+// A ReplicaRPOSummary aggregates the ReplicaRPO's of an index or of a
+// node.
+type ReplicaRPOSummary struct {
+	Replicas    int     `json:"replicas"`
+	TotalSeqGap uint64  `json:"totalSeqGap"`
+	MaxSeqGap   uint64  `json:"maxSeqGap"`
+	MaxLagSecs  float64 `json:"maxLagSecs"`
+}
+
+func (s *ReplicaRPOSummary) add(r *ReplicaRPO) {
+	s.Replicas++
+	s.TotalSeqGap += r.SeqGap
+	if r.SeqGap > s.MaxSeqGap {
+		s.MaxSeqGap = r.SeqGap
+	}
+	if r.LagKnown && r.LagSecs > s.MaxLagSecs {
+		s.MaxLagSecs = r.LagSecs
+	}
+}
+
+// A ReplicaRPOReport is the RPO of the pindex replicas of the cluster,
+// aggregated per index and per replica node.
+type ReplicaRPOReport struct {
+	Replicas []*ReplicaRPO                 `json:"replicas"`
+	Indexes  map[string]*ReplicaRPOSummary `json:"indexes"` // Keyed by index name.
+	Nodes    map[string]*ReplicaRPOSummary `json:"nodes"`   // Keyed by node UUID.
+
+	// Errors are the nodes whose seqs couldn't be retrieved, whose
+	// replicas, or the replicas of whose primaries, are left out.
+	Errors map[string]string `json:"errors,omitempty"` // Keyed by node UUID.
+
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CalcReplicaRPO returns how far the replicaSeqs are behind the
+// primarySeqs, where both are keyed by source partition.
+func CalcReplicaRPO(primarySeqs,
+	replicaSeqs map[string]QueryPartitionSeq) (seqGap uint64,
+	lagSecs float64, lagKnown bool) {
+	for partition, p := range primarySeqs {
+		r := replicaSeqs[partition]
+		if p.Seq <= r.Seq {
+			continue
+		}
+
+		seqGap += p.Seq - r.Seq
+
+		if p.Time.IsZero() || r.Time.IsZero() {
+			continue
+		}
+
+		lagKnown = true
+		if lag := p.Time.Sub(r.Time).Seconds(); lag > lagSecs {
+			lagSecs = lag
+		}
+	}
+
+	if seqGap == 0 {
+		lagKnown = true // Caught up.
+	}
+
+	return seqGap, lagSecs, lagKnown
+}
+
+// ReplicaRPO reports how far each pindex replica is behind its primary,
+// for the given index or, when the indexName is "", for all indexes.
+// The seqs of the local pindexes are read directly, and those of the
+// other nodes from their partition state exports.
+func (mgr *Manager) ReplicaRPO(indexName string) (*ReplicaRPOReport, error) {
+	rv := &ReplicaRPOReport{
+		Replicas:  []*ReplicaRPO{},
+		Indexes:   map[string]*ReplicaRPOSummary{},
+		Nodes:     map[string]*ReplicaRPOSummary{},
+		UpdatedAt: time.Now(),
+	}
+
+	if mgr.cfg == nil {
+		return rv, nil
+	}
+
+	planPIndexes, _, err := CfgGetPlanPIndexes(mgr.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeDefs, _, err := CfgGetNodeDefs(mgr.cfg, NODE_DEFS_WANTED)
+	if err != nil {
+		return nil, err
+	}
+
+	if planPIndexes == nil || nodeDefs == nil {
+		return rv, nil
+	}
+
+	lp := mgr.newLastProcessedFetcher(nodeDefs, indexName)
+
+	for _, planPIndex := range planPIndexes.PlanPIndexes {
+		if indexName != "" && planPIndex.IndexName != indexName {
+			continue
+		}
+
+		var primary string
+		var replicas []string
+		for nodeUUID, node := range planPIndex.Nodes {
+			if nodeDefs.NodeDefs[nodeUUID] == nil {
+				continue
+			}
+			if node.Priority <= 0 {
+				primary = nodeUUID
+			} else {
+				replicas = append(replicas, nodeUUID)
+			}
+		}
+		if primary == "" || len(replicas) == 0 {
+			continue
+		}
+
+		primarySeqs, exists := lp.seqsOf(primary, planPIndex.Name)
+		if !exists {
+			continue
+		}
+
+		for _, replica := range replicas {
+			replicaSeqs, exists := lp.seqsOf(replica, planPIndex.Name)
+			if !exists {
+				continue
+			}
+
+			r := &ReplicaRPO{
+				PIndex:    planPIndex.Name,
+				IndexName: planPIndex.IndexName,
+				Node:      replica,
+				Primary:   primary,
+			}
+			r.SeqGap, r.LagSecs, r.LagKnown =
+				CalcReplicaRPO(primarySeqs, replicaSeqs)
+
+			rv.Replicas = append(rv.Replicas, r)
+
+			for _, x := range []struct {
+				m   map[string]*ReplicaRPOSummary
+				key string
+			}{
+				{rv.Indexes, r.IndexName},
+				{rv.Nodes, r.Node},
+			} {
+				s := x.m[x.key]
+				if s == nil {
+					s = &ReplicaRPOSummary{}
+					x.m[x.key] = s
+				}
+				s.add(r)
+			}
+		}
+	}
+
+	if len(lp.errs) > 0 {
+		rv.Errors = lp.errs
+	}
+
+	sort.Slice(rv.Replicas, func(i, j int) bool {
+		if rv.Replicas[i].PIndex != rv.Replicas[j].PIndex {
+			return rv.Replicas[i].PIndex < rv.Replicas[j].PIndex
+		}
+		return rv.Replicas[i].Node < rv.Replicas[j].Node
+	})
+
+	return rv, nil
+}
+
+// A lastProcessedFetcher retrieves the last processed seqs of the
+// pindexes of an index, or of all indexes when the indexName is "",
+// once per node.
+type lastProcessedFetcher struct {
+	mgr       *Manager
+	nodeDefs  *NodeDefs
+	indexName string
+
+	// Keyed by node UUID, and then by pindex.
+	nodeSeqs map[string]map[string]map[string]QueryPartitionSeq
+
+	// The nodes whose seqs couldn't be retrieved, keyed by node UUID.
+	errs map[string]string
+}
+
+func (mgr *Manager) newLastProcessedFetcher(nodeDefs *NodeDefs,
+	indexName string) *lastProcessedFetcher {
+	return &lastProcessedFetcher{
+		mgr:       mgr,
+		nodeDefs:  nodeDefs,
+		indexName: indexName,
+		nodeSeqs:  map[string]map[string]map[string]QueryPartitionSeq{},
+	}
+}
+
+// seqsOf returns the last processed seqs of a pindex on a node, keyed
+// by source partition.  The seqs of the local pindexes are read
+// directly, and those of the other nodes from their partition state
+// exports.
+func (f *lastProcessedFetcher) seqsOf(nodeUUID, pindexName string) (
+	map[string]QueryPartitionSeq, bool) {
+	seqs, exists := f.nodeSeqs[nodeUUID]
+	if !exists {
+		if nodeUUID == f.mgr.uuid {
+			seqs = f.mgr.localLastProcessed(f.indexName)
+		} else if nodeDef := f.nodeDefs.NodeDefs[nodeUUID]; nodeDef != nil {
+			var err error
+			seqs, err = fetchLastProcessed(nodeDef, f.indexName)
+			if err != nil {
+				if f.errs == nil {
+					f.errs = map[string]string{}
+				}
+				f.errs[nodeUUID] = err.Error()
+				seqs = nil
+			}
+		}
+		f.nodeSeqs[nodeUUID] = seqs
+	}
+
+	s, exists := seqs[pindexName]
+	return s, exists
+}
+
+// localLastProcessed returns the last processed seqs of the local
+// pindexes of an index, or of all indexes when the indexName is "",
+// keyed by pindex name.
+func (mgr *Manager) localLastProcessed(
+	indexName string) map[string]map[string]QueryPartitionSeq {
+	rv := map[string]map[string]QueryPartitionSeq{}
+
+	_, pindexes := mgr.CurrentMaps()
+	for _, pindex := range pindexes {
+		if indexName != "" && pindex.IndexName != indexName {
+			continue
+		}
+		if d, ok := pindex.Dest.(DestLastProcessed); ok {
+			if seqs := d.LastProcessed(); seqs != nil {
+				rv[pindex.Name] = seqs
+			}
+		}
+	}
+
+	return rv
+}
@@ -0,0 +1,155 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalcReplicaRPO(t *testing.T) {
+	now := time.Now()
+
+	primary := map[string]QueryPartitionSeq{
+		"0": {Seq: 10, Time: now},
+		"1": {Seq: 20, Time: now},
+		"2": {Seq: 5},
+	}
+	replica := map[string]QueryPartitionSeq{
+		"0": {Seq: 10, Time: now.Add(-time.Minute)},
+		"1": {Seq: 15, Time: now.Add(-3 * time.Second)},
+		"2": {Seq: 1},
+	}
+
+	seqGap, lagSecs, lagKnown := CalcReplicaRPO(primary, replica)
+	if seqGap != 9 || lagSecs != 3 || !lagKnown {
+		t.Errorf("unexpected, seqGap: %d, lagSecs: %v, lagKnown: %v",
+			seqGap, lagSecs, lagKnown)
+	}
+
+	seqGap, lagSecs, lagKnown = CalcReplicaRPO(primary, primary)
+	if seqGap != 0 || lagSecs != 0 || !lagKnown {
+		t.Errorf("expected a caught up replica, seqGap: %d, lagSecs: %v,"+
+			" lagKnown: %v", seqGap, lagSecs, lagKnown)
+	}
+
+	_, _, lagKnown = CalcReplicaRPO(
+		map[string]QueryPartitionSeq{"0": {Seq: 2}},
+		map[string]QueryPartitionSeq{"0": {Seq: 1}})
+	if lagKnown {
+		t.Errorf("expected an unknown lag without the times")
+	}
+}
+
+func TestReplicaRPO(t *testing.T) {
+	cfg := NewCfgMem()
+	local, remote := "n0", "n1"
+
+	nodeDefs := NewNodeDefs(VERSION)
+	nodeDefs.NodeDefs[local] = &NodeDef{UUID: local, HostPort: "local:8094"}
+	nodeDefs.NodeDefs[remote] = &NodeDef{UUID: remote, HostPort: "remote:8094"}
+	_, err := CfgSetNodeDefs(cfg, NODE_DEFS_WANTED, nodeDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	planPIndexes := NewPlanPIndexes(VERSION)
+	planPIndexes.PlanPIndexes["p0"] = &PlanPIndex{
+		Name: "p0", IndexName: "i0",
+		Nodes: map[string]*PlanPIndexNode{
+			local:  {Priority: 0},
+			remote: {Priority: 1},
+		},
+	}
+	planPIndexes.PlanPIndexes["p1"] = &PlanPIndex{
+		Name: "p1", IndexName: "i0",
+		Nodes: map[string]*PlanPIndexNode{
+			local:  {Priority: 1},
+			remote: {Priority: 0},
+		},
+	}
+	_, err = CfgSetPlanPIndexes(cfg, planPIndexes, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mgr := NewManager(VERSION, cfg, local, nil,
+		"", 1, "", "", "", "", nil)
+
+	mgr.registerPIndex(&PIndex{Name: "p0", IndexName: "i0",
+		Dest: &testLastProcessedDest{
+			seqs: map[string]QueryPartitionSeq{"0": {Seq: 100}},
+		}})
+	mgr.registerPIndex(&PIndex{Name: "p1", IndexName: "i0",
+		Dest: &testLastProcessedDest{
+			seqs: map[string]QueryPartitionSeq{"1": {Seq: 40}},
+		}})
+
+	prevHttpGet := alertHttpGet
+	defer func() { alertHttpGet = prevHttpGet }()
+
+	var urls []string
+	alertHttpGet = func(u string) (*http.Response, error) {
+		urls = append(urls, u)
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for name, seqs := range map[string]map[string]QueryPartitionSeq{
+			"p0": {"0": {Seq: 70}},
+			"p1": {"1": {Seq: 50}},
+		} {
+			enc.Encode(map[string]interface{}{
+				"kind": "pindex", "name": name, "lastProcessed": seqs,
+			})
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(&buf),
+		}, nil
+	}
+
+	report, err := mgr.ReplicaRPO("")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if len(urls) != 1 || !strings.HasPrefix(urls[0], "http://remote:8094/") {
+		t.Errorf("expected one fetch of the remote node, got: %v", urls)
+	}
+
+	if len(report.Replicas) != 2 ||
+		report.Replicas[0].PIndex != "p0" ||
+		report.Replicas[0].Node != remote ||
+		report.Replicas[0].SeqGap != 30 ||
+		report.Replicas[1].PIndex != "p1" ||
+		report.Replicas[1].Node != local ||
+		report.Replicas[1].SeqGap != 10 {
+		t.Fatalf("unexpected replicas: %#v", report.Replicas)
+	}
+
+	if s := report.Indexes["i0"]; s == nil || s.Replicas != 2 ||
+		s.TotalSeqGap != 40 || s.MaxSeqGap != 30 {
+		t.Errorf("unexpected index summary: %#v", s)
+	}
+
+	if s := report.Nodes[remote]; s == nil || s.Replicas != 1 ||
+		s.MaxSeqGap != 30 {
+		t.Errorf("unexpected node summary: %#v", s)
+	}
+
+	report, err = mgr.ReplicaRPO("other")
+	if err != nil || len(report.Replicas) != 0 {
+		t.Errorf("expected no replicas of another index, report: %#v,"+
+			" err: %v", report, err)
+	}
+}
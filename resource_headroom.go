@@ -0,0 +1,260 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// A ResourceHeadroom is the share, in percent, of a node's resources
+// that's reserved for the system overhead and any co-located services,
+// which the planner and the background work treat as unavailable.
+//
+// The headroom is configured cluster-wide by the "reservedCPUPercent",
+// "reservedMemoryPercent" and "reservedDiskPercent" manager options,
+// and can be overridden per node by a "reservedHeadroom" JSON object
+// in the node's NodeDef.Extras, such as {"memoryPercent": 30}.
+type ResourceHeadroom struct {
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	DiskPercent   float64 `json:"diskPercent"`
+}
+
+// IsZero returns true when no resources are reserved.
+func (h ResourceHeadroom) IsZero() bool {
+	return h.CPUPercent <= 0 && h.MemoryPercent <= 0 && h.DiskPercent <= 0
+}
+
+// A NodeResourceUsage is the current usage, in percent, of a node's
+// resources.
+type NodeResourceUsage struct {
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	DiskPercent   float64 `json:"diskPercent"`
+}
+
+// NodeResourceUsageHook is an optional, pluggable callback that allows
+// applications to report the current resource usage of a node, such as
+// from the cluster manager's stats.  When nil, the nodes are considered
+// idle, so that only their reserved headroom is unavailable.
+var NodeResourceUsageHook func(nodeDef *NodeDef) (*NodeResourceUsage, error)
+
+// GetResourceHeadroom returns the reserved headroom of a node, where
+// the nodeDef may be nil.
+func GetResourceHeadroom(options map[string]string,
+	nodeDef *NodeDef) ResourceHeadroom {
+	var rv ResourceHeadroom
+
+	for _, x := range []struct {
+		option string
+		v      *float64
+	}{
+		{"reservedCPUPercent", &rv.CPUPercent},
+		{"reservedMemoryPercent", &rv.MemoryPercent},
+		{"reservedDiskPercent", &rv.DiskPercent},
+	} {
+		if s, exists := options[x.option]; exists && s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				log.Warnf("resource_headroom: option: %s, err: %v",
+					x.option, err)
+				continue
+			}
+			*x.v = v
+		}
+	}
+
+	if nodeDef == nil || nodeDef.Extras == "" {
+		return rv
+	}
+
+	v, err := nodeDef.GetFromParsedExtras("reservedHeadroom")
+	if err != nil || v == nil {
+		return rv
+	}
+
+	// The node's overrides apply only to the resources it mentions.
+	buf, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(buf, &rv)
+	}
+	if err != nil {
+		log.Warnf("resource_headroom: node: %s, reservedHeadroom, err: %v",
+			nodeDef.UUID, err)
+	}
+
+	return rv
+}
+
+// AvailablePercent returns the share, in percent, of the node's most
+// constrained resource that's neither reserved nor in use, where the
+// usage may be nil.
+func (h ResourceHeadroom) AvailablePercent(usage *NodeResourceUsage) float64 {
+	if usage == nil {
+		usage = &NodeResourceUsage{}
+	}
+
+	rv := math.Min(100-h.CPUPercent-usage.CPUPercent,
+		math.Min(100-h.MemoryPercent-usage.MemoryPercent,
+			100-h.DiskPercent-usage.DiskPercent))
+
+	return math.Max(0, math.Min(100, rv))
+}
+
+// Exceeded returns a non-nil error when the usage has eaten into the
+// reserved headroom of any of the node's resources.
+func (h ResourceHeadroom) Exceeded(usage *NodeResourceUsage) error {
+	if usage == nil {
+		return nil
+	}
+
+	for _, x := range []struct {
+		name            string
+		reserved, usage float64
+	}{
+		{"cpu", h.CPUPercent, usage.CPUPercent},
+		{"memory", h.MemoryPercent, usage.MemoryPercent},
+		{"disk", h.DiskPercent, usage.DiskPercent},
+	} {
+		if x.reserved > 0 && x.usage > 100-x.reserved {
+			return fmt.Errorf("resource_headroom: %s usage: %.1f%%"+
+				" exceeds the %.1f%% available", x.name, x.usage,
+				100-x.reserved)
+		}
+	}
+
+	return nil
+}
+
+// nodeResourceUsage returns the usage of the node via the
+// NodeResourceUsageHook, or nil when unknown.
+func nodeResourceUsage(nodeDef *NodeDef) *NodeResourceUsage {
+	if NodeResourceUsageHook == nil || nodeDef == nil {
+		return nil
+	}
+
+	usage, err := NodeResourceUsageHook(nodeDef)
+	if err != nil {
+		log.Warnf("resource_headroom: node: %s, usage, err: %v",
+			nodeDef.UUID, err)
+		return nil
+	}
+
+	return usage
+}
+
+// ResourceHeadroomRecheckInterval is how often the background work
+// that's held back by an exceeded headroom checks the headroom again.
+var ResourceHeadroomRecheckInterval = 5 * time.Second
+
+// ResourceHeadroomNodeWeightScale is the node weight of an otherwise
+// unweighted node with all of its resources available, so that the
+// node weights scaled by the available resources keep their precision.
+const ResourceHeadroomNodeWeightScale = 100
+
+// ApplyResourceHeadroom returns the node weights scaled by the share
+// of each node's resources that's available for partition placement,
+// so that the planner favors the nodes with more headroom and mostly
+// avoids the nodes that are out of headroom.  The nodeWeights are
+// returned as is when there's neither a reserved headroom nor a
+// NodeResourceUsageHook.
+func ApplyResourceHeadroom(nodeDefs *NodeDefs, nodeUUIDs []string,
+	nodeWeights map[string]int, options map[string]string) map[string]int {
+	if nodeDefs == nil {
+		return nodeWeights
+	}
+
+	type nodeAvailable struct {
+		uuid      string
+		available float64
+	}
+
+	var nodes []nodeAvailable
+	var reserved bool
+
+	for _, nodeUUID := range nodeUUIDs {
+		nodeDef := nodeDefs.NodeDefs[nodeUUID]
+		if nodeDef == nil {
+			continue // A node that's being removed.
+		}
+
+		headroom := GetResourceHeadroom(options, nodeDef)
+		usage := nodeResourceUsage(nodeDef)
+		if !headroom.IsZero() || usage != nil {
+			reserved = true
+		}
+
+		nodes = append(nodes, nodeAvailable{
+			uuid:      nodeUUID,
+			available: headroom.AvailablePercent(usage),
+		})
+	}
+
+	if !reserved {
+		return nodeWeights
+	}
+
+	rv := make(map[string]int, len(nodeWeights))
+	for nodeUUID, weight := range nodeWeights {
+		rv[nodeUUID] = weight
+	}
+
+	for _, node := range nodes {
+		weight := 1
+		if w, exists := nodeWeights[node.uuid]; exists {
+			if w <= 0 {
+				continue // Leave the special weights alone.
+			}
+			weight = w
+		}
+
+		w := int(float64(weight*ResourceHeadroomNodeWeightScale) *
+			node.available / 100)
+		if w < 1 {
+			// The lowest positive weight, as a weight of 0 would be
+			// treated as the default weight.
+			w = 1
+		}
+
+		rv[node.uuid] = w
+	}
+
+	return rv
+}
+
+// ResourceHeadroomExceeded returns a non-nil error when this node's
+// resource usage has eaten into its reserved headroom, in which case
+// the background work should be held back.
+func (mgr *Manager) ResourceHeadroomExceeded() error {
+	if NodeResourceUsageHook == nil {
+		return nil
+	}
+
+	var nodeDef *NodeDef
+	nodeDefs, err := mgr.GetNodeDefs(NODE_DEFS_WANTED, false)
+	if err == nil && nodeDefs != nil {
+		nodeDef = nodeDefs.NodeDefs[mgr.uuid]
+	}
+	if nodeDef == nil {
+		nodeDef = &NodeDef{UUID: mgr.uuid, HostPort: mgr.bindHttp}
+	}
+
+	headroom := GetResourceHeadroom(mgr.Options(), nodeDef)
+	if headroom.IsZero() {
+		return nil
+	}
+
+	return headroom.Exceeded(nodeResourceUsage(nodeDef))
+}
@@ -0,0 +1,103 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetResourceHeadroom(t *testing.T) {
+	options := map[string]string{
+		"reservedCPUPercent":    "10",
+		"reservedMemoryPercent": "20",
+		"reservedDiskPercent":   "oops",
+	}
+
+	h := GetResourceHeadroom(options, nil)
+	if h != (ResourceHeadroom{CPUPercent: 10, MemoryPercent: 20}) {
+		t.Fatalf("unexpected headroom: %+v", h)
+	}
+
+	nodeDef := &NodeDef{
+		UUID:   "a",
+		Extras: `{"reservedHeadroom":{"memoryPercent":30,"diskPercent":5}}`,
+	}
+
+	h = GetResourceHeadroom(options, nodeDef)
+	if h != (ResourceHeadroom{CPUPercent: 10, MemoryPercent: 30, DiskPercent: 5}) {
+		t.Fatalf("unexpected node headroom: %+v", h)
+	}
+}
+
+func TestResourceHeadroomAvailable(t *testing.T) {
+	h := ResourceHeadroom{CPUPercent: 10, MemoryPercent: 20}
+
+	if v := h.AvailablePercent(nil); v != 80 {
+		t.Fatalf("expected 80, got: %v", v)
+	}
+	if v := h.AvailablePercent(&NodeResourceUsage{CPUPercent: 85}); v != 5 {
+		t.Fatalf("expected 5, got: %v", v)
+	}
+	if v := h.AvailablePercent(&NodeResourceUsage{MemoryPercent: 95}); v != 0 {
+		t.Fatalf("expected 0, got: %v", v)
+	}
+
+	if err := h.Exceeded(nil); err != nil {
+		t.Fatalf("expected no err for an unknown usage, got: %v", err)
+	}
+	if err := h.Exceeded(&NodeResourceUsage{MemoryPercent: 80}); err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+	if err := h.Exceeded(&NodeResourceUsage{MemoryPercent: 81}); err == nil {
+		t.Fatalf("expected an err when the memory headroom is exceeded")
+	}
+	if err := h.Exceeded(&NodeResourceUsage{DiskPercent: 99}); err != nil {
+		t.Fatalf("expected no err without a disk headroom, got: %v", err)
+	}
+}
+
+func TestApplyResourceHeadroom(t *testing.T) {
+	defer func() { NodeResourceUsageHook = nil }()
+
+	nodeDefs := &NodeDefs{NodeDefs: map[string]*NodeDef{
+		"a": {UUID: "a"},
+		"b": {UUID: "b", Extras: `{"reservedHeadroom":{"cpuPercent":50}}`},
+		"c": {UUID: "c"},
+	}}
+	nodeUUIDs := []string{"a", "b", "c", "removed"}
+	nodeWeights := map[string]int{"c": 2}
+
+	rv := ApplyResourceHeadroom(nodeDefs, nodeUUIDs, nodeWeights, nil)
+	exp := map[string]int{"a": 100, "b": 50, "c": 200}
+	if !reflect.DeepEqual(rv, exp) {
+		t.Fatalf("expected: %v, got: %v", exp, rv)
+	}
+
+	// No headroom and no usage keeps the weights as is.
+	nodeDefs.NodeDefs["b"] = &NodeDef{UUID: "b"}
+	rv = ApplyResourceHeadroom(nodeDefs, nodeUUIDs, nodeWeights, nil)
+	if !reflect.DeepEqual(rv, nodeWeights) {
+		t.Fatalf("expected unchanged weights, got: %v", rv)
+	}
+
+	NodeResourceUsageHook = func(nodeDef *NodeDef) (*NodeResourceUsage, error) {
+		if nodeDef.UUID == "a" {
+			return &NodeResourceUsage{MemoryPercent: 95}, nil
+		}
+		return &NodeResourceUsage{}, nil
+	}
+
+	rv = ApplyResourceHeadroom(nodeDefs, nodeUUIDs, nodeWeights,
+		map[string]string{"reservedMemoryPercent": "10"})
+	exp = map[string]int{"a": 1, "b": 90, "c": 180}
+	if !reflect.DeepEqual(rv, exp) {
+		t.Fatalf("expected: %v, got: %v", exp, rv)
+	}
+}
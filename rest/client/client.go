@@ -0,0 +1,592 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+// Package client provides a typed Go client for the cbgt REST APIs,
+// so that tools and automation don't have to hand-roll the HTTP calls.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/cbauth/service"
+
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/ctl"
+	"github.com/couchbase/cbgt/rest"
+)
+
+// A Client invokes the REST APIs of a cbgt node.  A Client is safe
+// for concurrent use.
+type Client struct {
+	url     string // Ex: "http://10.0.0.1:8094".
+	options ClientOptions
+}
+
+// ClientOptions are the optional settings of a Client.
+type ClientOptions struct {
+	// Optional, defaults to http.DefaultClient.
+	HttpClient *http.Client
+
+	// Optional, the credentials of the basic auth.
+	Username string
+	Password string
+
+	// Optional, the path where the application mounted the
+	// ctl.CtlTaskListHandler, such as "/api/ctl/tasks", which is
+	// needed by the TaskList methods.
+	CtlTaskListPath string
+}
+
+// NewClient returns a Client of the node at the url, such as
+// "http://10.0.0.1:8094", which includes any urlPrefix of the node.
+func NewClient(url string, options ClientOptions) *Client {
+	return &Client{url: strings.TrimSuffix(url, "/"), options: options}
+}
+
+// An Error is the error of a REST API call that failed with a non-2xx
+// status code.
+type Error struct {
+	StatusCode int
+	Message    string // The "error" of the response, or else its body.
+
+	// RetryAfter is the response's Retry-After header, which is set
+	// for the errors that are worth retrying.
+	RetryAfter string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("client: status code: %d, err: %s",
+		e.StatusCode, e.Message)
+}
+
+// The path specs of the REST APIs that the Client invokes, where the
+// "{name}" parts are replaced by the arguments of pathOf().
+const (
+	pathIndexes           = "/api/index"
+	pathIndex             = "/api/index/{indexName}"
+	pathIndexStatus       = "/api/index/{indexName}/status"
+	pathIndexCount        = "/api/index/{indexName}/count"
+	pathIndexQuery        = "/api/index/{indexName}/query"
+	pathIndexTasks        = "/api/index/{indexName}/tasks"
+	pathIndexIngest       = "/api/index/{indexName}/ingestControl/{op}"
+	pathIndexIngestPause  = "/api/index/{indexName}/ingest/{op}"
+	pathIndexPlanFreeze   = "/api/index/{indexName}/planFreezeControl/{op}"
+	pathIndexQueryControl = "/api/index/{indexName}/queryControl/{op}"
+	pathStats             = "/api/stats"
+	pathIndexStats        = "/api/stats/index/{indexName}"
+	pathStatsDelta        = "/api/stats/delta"
+	pathCfg               = "/api/cfg"
+	pathTopologySnapshot  = "/api/topologySnapshot"
+	pathActivities        = "/api/activities"
+	pathCfgRefresh        = "/api/cfgRefresh"
+	pathCfgHistory        = "/api/cfgHistory"
+	pathCfgRollback       = "/api/cfgRollback"
+	pathCfgExport         = "/api/cfgExport"
+	pathCfgImport         = "/api/cfgImport"
+	pathManagerKick       = "/api/managerKick"
+	pathManagerOptions    = "/api/managerOptions"
+)
+
+// clientRoutes are the method and path spec of every REST API that the
+// Client invokes, which are checked against the REST router's metadata,
+// so that the Client is kept in step with the REST layer.
+var clientRoutes = [][2]string{
+	{"GET", pathIndexes},
+	{"GET", pathIndex},
+	{"PUT", pathIndex},
+	{"DELETE", pathIndex},
+	{"GET", pathIndexStatus},
+	{"GET", pathIndexCount},
+	{"POST", pathIndexQuery},
+	{"POST", pathIndexTasks},
+	{"POST", pathIndexIngest},
+	{"POST", pathIndexIngestPause},
+	{"POST", pathIndexPlanFreeze},
+	{"POST", pathIndexQueryControl},
+	{"GET", pathStats},
+	{"GET", pathIndexStats},
+	{"GET", pathStatsDelta},
+	{"GET", pathCfg},
+	{"GET", pathTopologySnapshot},
+	{"GET", pathActivities},
+	{"POST", pathCfgRefresh},
+	{"GET", pathCfgHistory},
+	{"POST", pathCfgRollback},
+	{"GET", pathCfgExport},
+	{"POST", pathCfgImport},
+	{"POST", pathManagerKick},
+	{"PUT", pathManagerOptions},
+}
+
+// pathOf returns the path of a path spec, whose "{name}" parts are
+// replaced, in order, by the escaped args.
+func pathOf(spec string, args ...string) string {
+	var b strings.Builder
+	for _, arg := range args {
+		i := strings.Index(spec, "{")
+		j := strings.Index(spec, "}")
+		if i < 0 || j < i {
+			break
+		}
+		b.WriteString(spec[:i])
+		b.WriteString(url.PathEscape(arg))
+		spec = spec[j+1:]
+	}
+	b.WriteString(spec)
+	return b.String()
+}
+
+// do invokes a REST API, decoding its JSON response into the rv, when
+// non-nil, and returning the response body.
+func (c *Client) do(ctx context.Context, method, path string,
+	params url.Values, body []byte, rv interface{}) ([]byte, error) {
+	u := c.url + path
+	if len(params) > 0 {
+		u = u + "?" + params.Encode()
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.options.Username != "" || c.options.Password != "" {
+		req.SetBasicAuth(c.options.Username, c.options.Password)
+	}
+
+	httpClient := c.options.HttpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		e := &Error{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(respBody)),
+			RetryAfter: resp.Header.Get("Retry-After"),
+		}
+		var failed struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(respBody, &failed) == nil && failed.Error != "" {
+			e.Message = failed.Error
+		}
+		return respBody, e
+	}
+
+	if rv != nil {
+		err = cbgt.UnmarshalJSON(respBody, rv)
+		if err != nil {
+			return respBody, fmt.Errorf("client: %s %s, could not"+
+				" unmarshal response, err: %v", method, path, err)
+		}
+	}
+
+	return respBody, nil
+}
+
+// ------------------------------------------------------------------------
+
+// ListIndexes returns the index definitions.
+func (c *Client) ListIndexes(ctx context.Context) (*cbgt.IndexDefs, error) {
+	var rv struct {
+		IndexDefs *cbgt.IndexDefs `json:"indexDefs"`
+	}
+	_, err := c.do(ctx, "GET", pathIndexes, nil, nil, &rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv.IndexDefs, nil
+}
+
+// IndexInfo is an index definition along with its plan.
+type IndexInfo struct {
+	IndexDef     *cbgt.IndexDef     `json:"indexDef"`
+	PlanPIndexes []*cbgt.PlanPIndex `json:"planPIndexes"`
+	Warnings     []string           `json:"warnings"`
+}
+
+// GetIndex returns an index definition and its plan.
+func (c *Client) GetIndex(ctx context.Context,
+	indexName string) (*IndexInfo, error) {
+	rv := &IndexInfo{}
+	_, err := c.do(ctx, "GET", pathOf(pathIndex, indexName),
+		nil, nil, rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// CreateIndex creates an index from the indexDef, whose Name, Type and
+// SourceType are required, returning the UUID of the new index.  When
+// the indexDef's UUID is not empty, the index of that UUID is updated
+// instead.
+func (c *Client) CreateIndex(ctx context.Context,
+	indexDef *cbgt.IndexDef) (string, error) {
+	body, err := cbgt.MarshalJSON(indexDef)
+	if err != nil {
+		return "", err
+	}
+
+	var params url.Values
+	if indexDef.UUID != "" {
+		params = url.Values{"prevIndexUUID": []string{indexDef.UUID}}
+	}
+
+	var rv struct {
+		UUID string `json:"uuid"`
+	}
+	_, err = c.do(ctx, "PUT", pathOf(pathIndex, indexDef.Name),
+		params, body, &rv)
+	if err != nil {
+		return "", err
+	}
+	return rv.UUID, nil
+}
+
+// DeleteIndex deletes an index.
+func (c *Client) DeleteIndex(ctx context.Context, indexName string) error {
+	_, err := c.do(ctx, "DELETE", pathOf(pathIndex, indexName),
+		nil, nil, nil)
+	return err
+}
+
+// IndexStatus returns whether an index is "Ready" or still being built,
+// "InProgress".
+func (c *Client) IndexStatus(ctx context.Context,
+	indexName string) (string, error) {
+	var rv struct {
+		IndexStatus string `json:"indexStatus"`
+	}
+	_, err := c.do(ctx, "GET", pathOf(pathIndexStatus, indexName),
+		nil, nil, &rv)
+	if err != nil {
+		return "", err
+	}
+	return rv.IndexStatus, nil
+}
+
+// IndexCount returns the number of documents/entries of an index.
+func (c *Client) IndexCount(ctx context.Context,
+	indexName string) (uint64, error) {
+	var rv struct {
+		Count uint64 `json:"count"`
+	}
+	_, err := c.do(ctx, "GET", pathOf(pathIndexCount, indexName),
+		nil, nil, &rv)
+	if err != nil {
+		return 0, err
+	}
+	return rv.Count, nil
+}
+
+// Query queries an index, where the request and the response are in
+// the index type's format.
+func (c *Client) Query(ctx context.Context, indexName string,
+	request []byte) (json.RawMessage, error) {
+	return c.do(ctx, "POST", pathOf(pathIndexQuery, indexName),
+		nil, request, nil)
+}
+
+// IndexTask requests an index level task, such as the compaction of an
+// index, returning the index type's response.
+func (c *Client) IndexTask(ctx context.Context, indexName string,
+	taskRequest *cbgt.TaskRequest) (json.RawMessage, error) {
+	body, err := cbgt.MarshalJSON(taskRequest)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(ctx, "POST", pathOf(pathIndexTasks, indexName),
+		nil, body, nil)
+}
+
+// IngestControl pauses ("pause") or resumes ("resume") the ingest of
+// an index.
+func (c *Client) IngestControl(ctx context.Context,
+	indexName, op string) error {
+	_, err := c.do(ctx, "POST", pathOf(pathIndexIngest, indexName, op),
+		nil, nil, nil)
+	return err
+}
+
+// IngestPauseControl pauses ("pause") or resumes ("resume") the ingest
+// of an index, while the index stays loaded and queryable, without
+// rebuilding its partitions.
+func (c *Client) IngestPauseControl(ctx context.Context,
+	indexName, op string) error {
+	_, err := c.do(ctx, "POST", pathOf(pathIndexIngestPause, indexName, op),
+		nil, nil, nil)
+	return err
+}
+
+// PlanFreezeControl freezes ("freeze") or unfreezes ("unfreeze") the
+// plan of an index.
+func (c *Client) PlanFreezeControl(ctx context.Context,
+	indexName, op string) error {
+	_, err := c.do(ctx, "POST", pathOf(pathIndexPlanFreeze, indexName, op),
+		nil, nil, nil)
+	return err
+}
+
+// QueryControl allows ("allow") or disallows ("disallow") the queries
+// of an index.
+func (c *Client) QueryControl(ctx context.Context,
+	indexName, op string) error {
+	_, err := c.do(ctx, "POST", pathOf(pathIndexQueryControl, indexName, op),
+		nil, nil, nil)
+	return err
+}
+
+// ------------------------------------------------------------------------
+
+// Stats returns the stats of the node.
+func (c *Client) Stats(ctx context.Context) (json.RawMessage, error) {
+	return c.do(ctx, "GET", pathStats, nil, nil, nil)
+}
+
+// IndexStats returns the stats of an index on the node.
+func (c *Client) IndexStats(ctx context.Context,
+	indexName string) (json.RawMessage, error) {
+	return c.do(ctx, "GET", pathOf(pathIndexStats, indexName),
+		nil, nil, nil)
+}
+
+// StatsDelta returns the node's stats that changed since the rev of a
+// previous StatsDelta, or all of them when the rev is 0, optionally
+// aggregated by a preset, such as "index" or "node".
+func (c *Client) StatsDelta(ctx context.Context, rev uint64,
+	preset string) (*rest.StatsDelta, error) {
+	params := url.Values{}
+	if rev > 0 {
+		params.Set("rev", strconv.FormatUint(rev, 10))
+	}
+	if preset != "" {
+		params.Set("preset", preset)
+	}
+
+	rv := &rest.StatsDelta{}
+	_, err := c.do(ctx, "GET", pathStatsDelta, params, nil, rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// ------------------------------------------------------------------------
+
+// Topology is the cluster's index definitions, node definitions and
+// plan, as read from the node's Cfg.
+type Topology struct {
+	IndexDefs      *cbgt.IndexDefs    `json:"indexDefs"`
+	NodeDefsWanted *cbgt.NodeDefs     `json:"nodeDefsWanted"`
+	NodeDefsKnown  *cbgt.NodeDefs     `json:"nodeDefsKnown"`
+	PlanPIndexes   *cbgt.PlanPIndexes `json:"planPIndexes"`
+}
+
+// Topology returns the cluster's topology.
+func (c *Client) Topology(ctx context.Context) (*Topology, error) {
+	rv := &Topology{}
+	_, err := c.do(ctx, "GET", pathCfg, nil, nil, rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// TopologySnapshot returns a consistent snapshot of the cluster's
+// topology, which includes the pindexes that each node is actually
+// running when includeActual is true.
+func (c *Client) TopologySnapshot(ctx context.Context,
+	includeActual bool) (*cbgt.TopologySnapshot, error) {
+	params := url.Values{"actual": []string{strconv.FormatBool(includeActual)}}
+
+	rv := &cbgt.TopologySnapshot{}
+	_, err := c.do(ctx, "GET", pathTopologySnapshot, params, nil, rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// Activities returns the heavyweight operations that are currently
+// running across the cluster.
+func (c *Client) Activities(ctx context.Context) (*cbgt.ClusterActivities, error) {
+	rv := &cbgt.ClusterActivities{}
+	_, err := c.do(ctx, "GET", pathActivities, nil, nil, rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// CfgRefresh has the node refresh its Cfg.
+func (c *Client) CfgRefresh(ctx context.Context) error {
+	_, err := c.do(ctx, "POST", pathCfgRefresh, nil, nil, nil)
+	return err
+}
+
+// CfgHistory returns the history of the mutations of the node's Cfg,
+// of the key or of all keys when key is "".
+func (c *Client) CfgHistory(ctx context.Context,
+	key string) ([]*cbgt.CfgHistoryEntry, error) {
+	var params url.Values
+	if key != "" {
+		params = url.Values{"key": []string{key}}
+	}
+
+	var rv struct {
+		Entries []*cbgt.CfgHistoryEntry `json:"entries"`
+	}
+	_, err := c.do(ctx, "GET", pathCfgHistory, params, nil, &rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv.Entries, nil
+}
+
+// CfgRollback rolls the Cfg key back to its revision of the history
+// entry of the seq.
+func (c *Client) CfgRollback(ctx context.Context,
+	key string, seq uint64) error {
+	params := url.Values{
+		"key": []string{key},
+		"seq": []string{strconv.FormatUint(seq, 10)},
+	}
+	_, err := c.do(ctx, "POST", pathCfgRollback, params, nil, nil)
+	return err
+}
+
+// CfgExport exports the node's Cfg keys into an archive, where nil
+// keys means the default keys.
+func (c *Client) CfgExport(ctx context.Context,
+	keys []string) (*cbgt.CfgArchive, error) {
+	var params url.Values
+	if len(keys) > 0 {
+		params = url.Values{"keys": []string{strings.Join(keys, ",")}}
+	}
+
+	rv := &cbgt.CfgArchive{}
+	_, err := c.do(ctx, "GET", pathCfgExport, params, nil, rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// CfgImport restores the node's Cfg keys from an archive, per the
+// options.
+func (c *Client) CfgImport(ctx context.Context, archive *cbgt.CfgArchive,
+	options cbgt.CfgRestoreOptions) ([]*cbgt.CfgRestoreResult, error) {
+	body, err := cbgt.MarshalJSON(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{"dryRun": []string{strconv.FormatBool(options.DryRun)}}
+	if len(options.Keys) > 0 {
+		params.Set("keys", strings.Join(options.Keys, ","))
+	}
+
+	var rv struct {
+		Results []*cbgt.CfgRestoreResult `json:"results"`
+	}
+	_, err = c.do(ctx, "POST", pathCfgImport, params, body, &rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv.Results, nil
+}
+
+// ManagerKick kicks the node's planner and janitor.
+func (c *Client) ManagerKick(ctx context.Context, msg string) error {
+	_, err := c.do(ctx, "POST", pathManagerKick,
+		url.Values{"msg": []string{msg}}, nil, nil)
+	return err
+}
+
+// SetManagerOptions replaces the node's manager options.
+func (c *Client) SetManagerOptions(ctx context.Context,
+	options map[string]string) error {
+	body, err := cbgt.MarshalJSON(options)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, "PUT", pathManagerOptions, nil, body, nil)
+	return err
+}
+
+// ------------------------------------------------------------------------
+
+// TaskList long-polls the task list of the ctl, returning when its
+// revision differs from the rev, or right away when the rev is empty.
+func (c *Client) TaskList(ctx context.Context,
+	rev service.Revision) (*service.TaskList, error) {
+	rv := &service.TaskList{}
+	err := c.taskList(ctx, rev, false, rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// TaskListDiff is like TaskList, but returns only the tasks that were
+// added, updated or removed since the rev.
+func (c *Client) TaskListDiff(ctx context.Context,
+	rev service.Revision) (*ctl.TaskListDiff, error) {
+	rv := &ctl.TaskListDiff{}
+	err := c.taskList(ctx, rev, true, rv)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+func (c *Client) taskList(ctx context.Context, rev service.Revision,
+	diff bool, rv interface{}) error {
+	if c.options.CtlTaskListPath == "" {
+		return fmt.Errorf("client: task list, no CtlTaskListPath")
+	}
+
+	params := url.Values{}
+	if len(rev) > 0 {
+		params.Set("rev", string(rev))
+	}
+	if diff {
+		params.Set("diff", "true")
+	}
+
+	_, err := c.do(ctx, "GET", c.options.CtlTaskListPath, params, nil, rv)
+	return err
+}
@@ -0,0 +1,170 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/couchbase/cbgt"
+	"github.com/couchbase/cbgt/rest"
+)
+
+func testServer(t *testing.T) (*httptest.Server, map[string]rest.RESTMeta) {
+	emptyDir, err := os.MkdirTemp("", "client_test")
+	if err != nil {
+		t.Fatalf("tempdir err: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(emptyDir) })
+
+	cfg := cbgt.NewCfgMem()
+	mgr := cbgt.NewManager(cbgt.VERSION, cfg, cbgt.NewUUID(),
+		nil, "", 1, "", ":1000", emptyDir, "some-datasource", nil)
+	err = mgr.Start("wanted")
+	if err != nil {
+		t.Fatalf("expected no start err, got: %v", err)
+	}
+	t.Cleanup(mgr.Stop)
+
+	mr, _ := cbgt.NewMsgRing(os.Stderr, 1000)
+
+	router, meta, err := rest.NewRESTRouter("v0", mgr, emptyDir, "", mr,
+		rest.AssetDir, rest.Asset)
+	if err != nil {
+		t.Fatalf("router err: %v", err)
+	}
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return server, meta
+}
+
+func TestClientRoutes(t *testing.T) {
+	_, meta := testServer(t)
+
+	for _, route := range clientRoutes {
+		key := route[1] + " " + rest.RESTMethodOrds[route[0]] + route[0]
+		if _, exists := meta[key]; !exists {
+			t.Errorf("no REST API for the client route: %s %s",
+				route[0], route[1])
+		}
+	}
+}
+
+func TestPathOf(t *testing.T) {
+	p := pathOf(pathIndexIngest, "a/b", "pause")
+	if p != "/api/index/a%2Fb/ingestControl/pause" {
+		t.Errorf("unexpected path: %s", p)
+	}
+}
+
+func TestClientIndexLifecycle(t *testing.T) {
+	server, _ := testServer(t)
+
+	ctx := context.Background()
+	c := NewClient(server.URL, ClientOptions{})
+
+	_, err := c.GetIndex(ctx, "idx")
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusBadRequest ||
+		e.Message == "" {
+		t.Fatalf("expected a bad request for a missing index, err: %v", err)
+	}
+
+	uuid, err := c.CreateIndex(ctx, &cbgt.IndexDef{
+		Name:       "idx",
+		Type:       "blackhole",
+		SourceType: "nil",
+	})
+	if err != nil || uuid == "" {
+		t.Fatalf("create, uuid: %q, err: %v", uuid, err)
+	}
+
+	indexDefs, err := c.ListIndexes(ctx)
+	if err != nil || indexDefs == nil || indexDefs.IndexDefs["idx"] == nil {
+		t.Fatalf("list, indexDefs: %#v, err: %v", indexDefs, err)
+	}
+
+	info, err := c.GetIndex(ctx, "idx")
+	if err != nil || info.IndexDef.UUID != uuid {
+		t.Fatalf("get, info: %#v, err: %v", info, err)
+	}
+
+	err = c.PlanFreezeControl(ctx, "idx", "freeze")
+	if err != nil {
+		t.Fatalf("plan freeze, err: %v", err)
+	}
+
+	topology, err := c.Topology(ctx)
+	if err != nil || topology.IndexDefs == nil ||
+		topology.IndexDefs.IndexDefs["idx"] == nil ||
+		!topology.IndexDefs.IndexDefs["idx"].PlanParams.PlanFrozen {
+		t.Fatalf("topology: %#v, err: %v", topology, err)
+	}
+
+	snapshot, err := c.TopologySnapshot(ctx, false)
+	if err != nil || !snapshot.Consistent || snapshot.Rev == "" {
+		t.Fatalf("topology snapshot: %#v, err: %v", snapshot, err)
+	}
+
+	activities, err := c.Activities(ctx)
+	if err != nil || len(activities.Nodes) == 0 {
+		t.Fatalf("activities: %#v, err: %v", activities, err)
+	}
+
+	archive, err := c.CfgExport(ctx, nil)
+	if err != nil || archive.Version != cbgt.CFG_ARCHIVE_VERSION {
+		t.Fatalf("cfg export: %#v, err: %v", archive, err)
+	}
+
+	results, err := c.CfgImport(ctx, archive,
+		cbgt.CfgRestoreOptions{DryRun: true})
+	if err != nil || len(results) != len(archive.Entries) {
+		t.Fatalf("cfg import: %#v, err: %v", results, err)
+	}
+	for _, result := range results {
+		if result.Action != "unchanged" {
+			t.Errorf("cfg import, expected unchanged: %#v", result)
+		}
+	}
+
+	_, err = c.Stats(ctx)
+	if err != nil {
+		t.Fatalf("stats, err: %v", err)
+	}
+
+	delta, err := c.StatsDelta(ctx, 0, "index")
+	if err != nil || !delta.Full || delta.Rev == 0 {
+		t.Fatalf("stats delta: %#v, err: %v", delta, err)
+	}
+
+	err = c.DeleteIndex(ctx, "idx")
+	if err != nil {
+		t.Fatalf("delete, err: %v", err)
+	}
+
+	indexDefs, err = c.ListIndexes(ctx)
+	if err != nil || (indexDefs != nil && indexDefs.IndexDefs["idx"] != nil) {
+		t.Fatalf("expected the index to be deleted, indexDefs: %#v, err: %v",
+			indexDefs, err)
+	}
+}
+
+func TestClientTaskListPath(t *testing.T) {
+	c := NewClient("http://127.0.0.1:1", ClientOptions{})
+	_, err := c.TaskList(context.Background(), nil)
+	if err == nil {
+		t.Fatalf("expected an err without a CtlTaskListPath")
+	}
+}
@@ -61,6 +61,19 @@ func ShowErrorBody(w http.ResponseWriter, requestBody []byte, msg string, code i
 }
 
 func PropagateError(w http.ResponseWriter, requestBody []byte, msg string, code int) {
+	propagateErrorDetails(w, requestBody, msg, code, nil)
+}
+
+// showErrorDetails is like ShowErrorBody, but with additional fields
+// in the error response, like the structured validation errors.
+func showErrorDetails(w http.ResponseWriter, requestBody []byte, msg string,
+	code int, extra map[string]interface{}) {
+	log.Errorf("rest: error code: %d, msg: %s", code, msg)
+	propagateErrorDetails(w, requestBody, msg, code, extra)
+}
+
+func propagateErrorDetails(w http.ResponseWriter, requestBody []byte,
+	msg string, code int, extra map[string]interface{}) {
 	if isRetryableError(code) {
 		w.Header().Set("Retry-After", RetryAfter)
 	}
@@ -69,6 +82,9 @@ func PropagateError(w http.ResponseWriter, requestBody []byte, msg string, code
 		"status": "fail",
 		"error":  msg,
 	}
+	for k, v := range extra {
+		details[k] = v
+	}
 
 	if requestBody != nil {
 		requestBodyMap := map[string]interface{}{}
@@ -259,7 +275,17 @@ func (h *HandlerWithRESTMeta) ServeHTTP(
 
 	crw := &CountResponseWriter{ResponseWriter: w}
 
-	h.h.ServeHTTP(crw, req)
+	if h.RESTMeta != nil {
+		cbgt.MetricsTime(cbgt.GetMetricsProvider().Histogram(
+			"cbgt_rest_request_seconds", cbgt.MetricLabels{
+				"path": h.RESTMeta.Path, "method": h.RESTMeta.Method,
+			}), func() error {
+			h.h.ServeHTTP(crw, req)
+			return nil
+		})
+	} else {
+		h.h.ServeHTTP(crw, req)
+	}
 
 	if req.Header.Get(CLUSTER_ACTION) == "" {
 		// account for query stats on the co-ordinating node only
@@ -419,6 +445,18 @@ func InitRESTRouterEx(r *mux.Router, versionMain string,
 			}
 		}
 
+		if v, ok := options["authProvider"]; ok {
+			if authHandler != nil {
+				return nil, nil, fmt.Errorf("rest: auth and authProvider" +
+					" are exclusive")
+			}
+			provider, ok := v.(AuthProvider)
+			if !ok {
+				return nil, nil, fmt.Errorf("rest: authProvider invalid")
+			}
+			authHandler = NewAuthProviderHandler(provider)
+		}
+
 		if v, ok := options["mapRESTPathStats"]; ok {
 			mapRESTPathStats, ok = v.(map[string]*RESTPathStats)
 			if !ok {
@@ -427,6 +465,14 @@ func InitRESTRouterEx(r *mux.Router, versionMain string,
 		}
 	}
 
+	if authHandler == nil {
+		provider, err := newOptionsAuthProvider(mgr)
+		if err != nil {
+			return nil, nil, err
+		}
+		authHandler = newOptionsAuthHandler(provider)
+	}
+
 	prefix := mgr.GetOption("urlPrefix")
 
 	PIndexTypesInitRouter(r, "manager.before", mgr)
@@ -499,6 +545,34 @@ func InitRESTRouterEx(r *mux.Router, versionMain string,
 			"version introduced": "7.5.0",
 		},
 		"indexName")
+	handle("/api/index/{indexName}/estimate", "POST",
+		NewEstimateIndexHandler(mgr),
+		map[string]string{
+			"_category": "Indexing|Index definition",
+			"_about": `Estimates the size, build time and per-node resource
+                       needs of an index definition, by sampling its source
+                       and planning its partitions, without creating it.`,
+			"version introduced": "7.6.0",
+		},
+		"indexName")
+	handle("/api/index/{indexName}/undelete", "POST",
+		NewUndeleteIndexHandler(mgr),
+		map[string]string{
+			"_category": "Indexing|Index definition",
+			"_about": `Restores a deleted index definition from the index
+                       trash, with its original UUID, while its retention
+                       window (see the indexTrashRetentionSecs option)
+                       hasn't expired.`,
+			"version introduced": "7.6.0",
+		},
+		"indexName")
+	handle("/api/indexTrash", "GET", NewIndexTrashHandler(mgr),
+		map[string]string{
+			"_category":          "Indexing|Index definition",
+			"_about":             `Returns the deleted indexes that can be undeleted.`,
+			"version introduced": "7.6.0",
+		},
+		"")
 	handle("/api/index/{indexName}", "GET", NewGetIndexHandler(mgr),
 		map[string]string{
 			"_category":          "Indexing|Index definition",
@@ -550,6 +624,33 @@ func InitRESTRouterEx(r *mux.Router, versionMain string,
 				"version introduced": "7.5.0",
 			},
 			"indexName")
+
+		handle("/api/queryMirrors", "GET", NewQueryMirrorsHandler(mgr),
+			map[string]string{
+				"_category": "Indexing|Index querying",
+				"_about": `Returns the query mirrors, and the stats of
+                       their mirrored queries on this node.`,
+				"version introduced": "7.6.0",
+			},
+			"")
+		handle("/api/index/{indexName}/queryMirror", "PUT",
+			NewQueryMirrorHandler(mgr),
+			map[string]string{
+				"_category": "Indexing|Index querying",
+				"_about": `Mirrors a fraction of the queries of an index
+                       to a target index, such as a replacement index
+                       that's validated before a cutover.`,
+				"version introduced": "7.6.0",
+			},
+			"indexName")
+		handle("/api/index/{indexName}/queryMirror", "DELETE",
+			NewQueryMirrorHandler(mgr),
+			map[string]string{
+				"_category":          "Indexing|Index querying",
+				"_about":             `Stops mirroring the queries of an index.`,
+				"version introduced": "7.6.0",
+			},
+			"indexName")
 	}
 
 	handle("/api/index/{indexName}/status", "GET",
@@ -625,6 +726,89 @@ func InitRESTRouterEx(r *mux.Router, versionMain string,
 		},
 		"indexName")
 
+	handle("/api/index/{indexName}/ingest/{op}", "POST",
+		NewIndexControlHandler(mgr, "ingest", map[string]bool{
+			"pause":  true,
+			"resume": true,
+		}),
+		map[string]string{
+			"_category": "Indexing|Index management",
+			"_about": `Pause the ingesting of document mutations by an
+                          index, while the index stays loaded and
+                          queryable, without rebuilding its partitions.`,
+			"param: op": "required, string, URL path parameter\n\n" +
+				`Allowed values for op are "pause" or "resume".`,
+			"version introduced": "7.6.0",
+		},
+		"indexName")
+	handle("/api/bucket/{bucketName}/scope/{scopeName}/index/{indexName}/ingest/{op}", "POST",
+		NewIndexControlHandler(mgr, "ingest", map[string]bool{
+			"pause":  true,
+			"resume": true,
+		}),
+		map[string]string{
+			"_category": "Indexing|Index management",
+			"_about": `Pause the ingesting of document mutations by an
+                          index, while the index stays loaded and
+                          queryable, without rebuilding its partitions.`,
+			"param: op": "required, string, URL path parameter\n\n" +
+				`Allowed values for op are "pause" or "resume".`,
+			"version introduced": "7.6.0",
+		},
+		"indexName")
+
+	handle("/api/index/{indexName}/labels", "PUT",
+		NewIndexLabelsHandler(mgr),
+		map[string]string{
+			"_category":          "Indexing|Index management",
+			"_about":             `Replaces the labels of an index.`,
+			"version introduced": "7.6.0",
+		},
+		"indexName")
+
+	handle("/api/indexes/planFreezeControl/{op}", "POST",
+		NewLabelIndexControlHandler(mgr, "planFreeze", map[string]bool{
+			"freeze":   true,
+			"unfreeze": true,
+		}),
+		map[string]string{
+			"_category": "Indexing|Index management",
+			"_about": `Freeze the assignment of index partitions to nodes,
+                          for all the indexes that match a label selector.`,
+			"param: op": "required, string, URL path parameter\n\n" +
+				`Allowed values for op are "freeze" or "unfreeze".`,
+			"version introduced": "7.6.0",
+		},
+		"")
+	handle("/api/indexes/ingestControl/{op}", "POST",
+		NewLabelIndexControlHandler(mgr, "write", map[string]bool{
+			"pause":  true,
+			"resume": true,
+		}),
+		map[string]string{
+			"_category": "Indexing|Index management",
+			"_about": `Pause index updates and maintenance, for all the
+                          indexes that match a label selector.`,
+			"param: op": "required, string, URL path parameter\n\n" +
+				`Allowed values for op are "pause" or "resume".`,
+			"version introduced": "7.6.0",
+		},
+		"")
+	handle("/api/indexes/queryControl/{op}", "POST",
+		NewLabelIndexControlHandler(mgr, "read", map[string]bool{
+			"allow":    true,
+			"disallow": true,
+		}),
+		map[string]string{
+			"_category": "Indexing|Index management",
+			"_about": `Disallow queries, for all the indexes that match
+                          a label selector.`,
+			"param: op": "required, string, URL path parameter\n\n" +
+				`Allowed values for op are "allow" or "disallow".`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
 	handle("/api/index/{indexName}/queryControl/{op}", "POST",
 		NewIndexControlHandler(mgr, "read", map[string]bool{
 			"allow":    true,
@@ -681,6 +865,74 @@ func InitRESTRouterEx(r *mux.Router, versionMain string,
 				"version introduced": "0.2.0",
 			},
 			"pindexName")
+		handle("/api/pindex/{pindexName}/hotState", "GET",
+			NewHotStatePIndexHandler(mgr),
+			map[string]string{
+				"_category": "x/Advanced|x/Index partition definition",
+				"_about": `Returns the hot in-memory state of a pindex,` +
+					` for pre-warming its copy on the destination node` +
+					` of a partition move.`,
+				"version introduced": "7.6.0",
+			},
+			"pindexName")
+		handle("/api/pindex/{pindexName}/mutationSamples", "GET",
+			NewMutationSamplesPIndexHandler(mgr),
+			map[string]string{
+				"_category": "x/Advanced|x/Index partition definition",
+				"_about": `Streams a sample of the mutations that the` +
+					` feeds deliver to a local pindex, as newline-delimited` +
+					` JSON, for debugging; requires the` +
+					` enableMutationSampling manager option.`,
+				"version introduced": "7.6.0",
+			},
+			"pindexName")
+		handle("/api/pindex/{pindexName}/deadLetters", "GET",
+			NewDeadLettersPIndexHandler(mgr),
+			map[string]string{
+				"_category": "x/Advanced|x/Index partition definition",
+				"_about": `Returns the dead letters of a local pindex,` +
+					` which are the mutations that its storage failed` +
+					` to apply; requires the deadLetterQueueMaxItems` +
+					` manager option.`,
+				"version introduced": "7.6.0",
+			},
+			"pindexName")
+		handle("/api/pindex/{pindexName}/deadLetters/{op}", "POST",
+			NewDeadLettersControlPIndexHandler(mgr),
+			map[string]string{
+				"_category": "x/Advanced|x/Index partition definition",
+				"_about": `Retries, removing those that are applied, or` +
+					` purges the dead letters of a local pindex.`,
+				"version introduced": "7.6.0",
+			},
+			"pindexName")
+		handle("/api/pindex/{pindexName}/warm", "POST",
+			NewWarmPIndexHandler(mgr),
+			map[string]string{
+				"_category": "x/Advanced|x/Index partition definition",
+				"_about": `Pre-warms a pindex from the hot state,` +
+					` in the request body, of another copy of the pindex.`,
+				"version introduced": "7.6.0",
+			},
+			"pindexName")
+
+		handle("/api/hibernation/coldIndexes", "GET",
+			NewColdIndexesHandler(mgr),
+			map[string]string{
+				"_category": "Indexing|Index querying",
+				"_about": `Returns the cold indexes of the paused buckets,` +
+					` and the stats of their local cache.`,
+				"version introduced": "7.6.0",
+			}, "")
+		handle("/api/hibernation/coldIndex/{indexName}/query", "POST",
+			NewQueryColdIndexHandler(mgr),
+			map[string]string{
+				"_category": "Indexing|Index querying",
+				"_about": `Queries a cold index of a paused bucket, whose` +
+					` data is read on demand from the hibernation remote path.`,
+				"version introduced": "7.6.0",
+			},
+			"indexName")
 
 		handle("/api/index/{indexName}/tasks", "POST",
 			NewTaskRequestHandler(mgr),
@@ -700,6 +952,17 @@ func InitRESTRouterEx(r *mux.Router, versionMain string,
 			"indexName")
 	}
 
+	handle("/api/pindex/{pindexName}/placement", "GET",
+		NewPIndexPlacementHandler(mgr),
+		map[string]string{
+			"_category": "x/Advanced|x/Index partition definition",
+			"_about": `Explains why the planner placed a pindex on its` +
+				` nodes, from the placement traces that were recorded` +
+				` when this node planned it.`,
+			"version introduced": "7.6.0",
+		},
+		"pindexName")
+
 	handle("/api/index/{indexName}/pindexLookup", "POST", NewPIndexLookUpHandler(mgr),
 		map[string]string{
 			"_category":          "Indexing|PIndex lookup",
@@ -716,6 +979,30 @@ func InitRESTRouterEx(r *mux.Router, versionMain string,
 		},
 		"indexName")
 
+	handle("/api/index/{indexName}/push", "POST", NewHTTPFeedPushHandler(mgr),
+		map[string]string{
+			"_category": "Indexing|Index updates",
+			"_about": `Applies a batch of JSON mutations, with per-partition
+                          sequence numbers, to the local partitions of an
+                          index whose source type is "http".  The
+                          mutations of the partitions of other nodes are
+                          returned as notLocal.`,
+			"version introduced": "7.6.0",
+		},
+		"indexName")
+	handle("/api/bucket/{bucketName}/scope/{scopeName}/index/{indexName}/push", "POST",
+		NewHTTPFeedPushHandler(mgr),
+		map[string]string{
+			"_category": "Indexing|Index updates",
+			"_about": `Applies a batch of JSON mutations, with per-partition
+                          sequence numbers, to the local partitions of an
+                          index whose source type is "http".  The
+                          mutations of the partitions of other nodes are
+                          returned as notLocal.`,
+			"version introduced": "7.6.0",
+		},
+		"indexName")
+
 	handle("/api/managerOptions", "PUT", NewManagerOptions(mgr),
 		map[string]string{
 			"_category":          "Node|Node configuration",
@@ -733,6 +1020,43 @@ func InitRESTRouterEx(r *mux.Router, versionMain string,
 		},
 		"")
 
+	handle("/api/cfgHistory", "GET", NewCfgHistoryHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node configuration",
+			"_about": `Returns the history of the mutations of the
+                       Cfg's keys, when the Cfg keeps a history.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/cfgRollback", "POST", NewCfgRollbackHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node configuration",
+			"_about": `Rolls a Cfg key back to a previous revision
+                       from the Cfg's history.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/cfgExport", "GET", NewCfgExportHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node configuration",
+			"_about": `Exports the Cfg's keys and their CAS values into
+                       a single versioned archive, for disaster recovery
+                       or environment cloning.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/cfgImport", "POST", NewCfgImportHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node configuration",
+			"_about": `Restores the Cfg's keys from an archive of
+                       /api/cfgExport in the request body.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
 	handle("/api/cfgRefresh", "POST", NewCfgRefreshHandler(mgr),
 		map[string]string{
 			"_category": "Node|Node configuration",
@@ -777,6 +1101,194 @@ func InitRESTRouterEx(r *mux.Router, versionMain string,
 		},
 		"")
 
+	handle("/api/topologySnapshot", "GET", NewTopologySnapshotHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node diagnostics",
+			"_about": `Returns a consistent snapshot of the cluster's
+                       node definitions, plan, actual pindex placement and
+                       last rebalance status, with a revision, for support
+                       dumps and external orchestration tools.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/activities", "GET", NewActivitiesHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node diagnostics",
+			"_about": `Returns the heavyweight operations currently running
+                       across the cluster, such as rebalance moves, replica
+                       builds, compactions, hibernation transfers and
+                       backfills, with per-node counts and resource
+                       governor shares.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/managerChangeReports", "GET", NewChangeReportsHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node diagnostics",
+			"_about": `Returns the node's recent change reports, which list
+                       the pindexes and feeds that the node's planner and
+                       janitor added, removed or restarted, and why.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/shadowCopy", "GET", NewShadowCopiesHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node diagnostics",
+			"_about": `Returns the statuses of the node's shadow copies,
+                       which keep read-only copies of indexes on a
+                       remote cluster, including their lag.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+	handle("/api/shadowCopy/{name}", "PUT", NewShadowCopyHandler(mgr),
+		map[string]string{
+			"_category":          "Indexing|Index management",
+			"_about":             `Creates or replaces a shadow copy of an index.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+	handle("/api/shadowCopy/{name}", "DELETE", NewShadowCopyHandler(mgr),
+		map[string]string{
+			"_category": "Indexing|Index management",
+			"_about": `Deletes a shadow copy, which stops its syncing, but
+                       leaves its snapshots in the remote object store.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/compaction", "GET", NewCompactionTasksHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node diagnostics",
+			"_about": `Returns the latest scheduled compactions of the
+                       node's pindexes, which run within the
+                       compactionWindow manager option, including their
+                       progress.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/featureFlags", "GET", NewFeatureFlagsHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node configuration",
+			"_about": `Returns the definitions of the feature flags, and
+                       their values on this node, which exclude the
+                       unset flags and the flags whose cluster
+                       compatibility gate isn't met.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+	handle("/api/featureFlags/{flagName}", "PUT", NewFeatureFlagHandler(mgr),
+		map[string]string{
+			"_category":          "Node|Node configuration",
+			"_about":             `Creates or replaces the definition of a feature flag.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+	handle("/api/featureFlags/{flagName}", "DELETE", NewFeatureFlagHandler(mgr),
+		map[string]string{
+			"_category":          "Node|Node configuration",
+			"_about":             `Deletes the definition of a feature flag.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/alerts", "GET", NewAlertsHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node monitoring",
+			"_about": `Returns the active alerts of this node, and the
+                       effective alert thresholds.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+	handle("/api/alertThresholds/{alertName}", "PUT", NewAlertThresholdHandler(mgr),
+		map[string]string{
+			"_category":          "Node|Node configuration",
+			"_about":             `Creates or replaces the threshold of an alert.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+	handle("/api/alertThresholds/{alertName}", "DELETE", NewAlertThresholdHandler(mgr),
+		map[string]string{
+			"_category":          "Node|Node configuration",
+			"_about":             `Reverts the threshold of an alert to its default.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+	handle("/api/oversizedIndexes", "GET", NewOversizedIndexesHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node monitoring",
+			"_about": `Returns the indexes whose partitions exceed the
+                       maxPartitionSizeBytes manager option, as flagged by
+                       the last partition size check, along with their
+                       recommended number of partitions.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/metrics", "GET", NewMetricsHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node monitoring",
+			"_about": `Returns the node's metrics, as reported to the
+                       pluggable metrics provider, in the provider's
+                       format, such as JSON or Prometheus.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+	handle("/api/hibernationGC", "POST", NewHibernationGCHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node configuration",
+			"_about": `Removes the orphaned objects of a hibernation remote
+                       path, such as the partial uploads of a canceled
+                       pause, that aren't in its checksum manifests.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/rollbacks", "GET", NewRollbacksHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node monitoring",
+			"_about": `Returns the pindexes whose rollbacks are held back
+                       by the quiesce rollbackToZeroPolicy, as JSON.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/rollbacks/resume", "POST", NewRollbacksResumeHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node configuration",
+			"_about": `Resumes the rollbacks held back by the quiesce
+                       rollbackToZeroPolicy, rebuilding their pindexes.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/rollbacks/diagnostics", "GET",
+		NewRollbackDiagnosticsHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node monitoring",
+			"_about": `Returns the most recent rollbacks of the source
+                       partitions of this node's feeds, most recent
+                       first, with their old and new UUIDs and seqs,
+                       affected pindexes and seqs to be re-indexed.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/node/{nodeUUID}/maintenanceControl/{op}", "POST",
+		NewNodeMaintenanceControlHandler(mgr),
+		map[string]string{
+			"_category": "Node|Node configuration",
+			"_about": `Puts a node into or takes it out of maintenance mode,
+                       where the planner doesn't assign the pindexes of
+                       new indexes to the node and queries prefer other
+                       nodes, such as during an OS patching window.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
 	handle("/api/managerKick", "POST", NewManagerKickHandler(mgr),
 		map[string]string{
 			"_category": "Node|Node configuration",
@@ -901,6 +1413,39 @@ func InitRESTRouterEx(r *mux.Router, versionMain string,
 		},
 		"")
 
+	handle("/api/stats/delta", "GET",
+		NewStatsDeltaHandler(mgr),
+		map[string]string{
+			"_category": "Indexing|Index monitoring",
+			"_about": `Returns the node's stats as flattened counters,
+                       where only the counters that changed since the
+                       client's rev are returned, optionally aggregated
+                       per index or for the node by a preset.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/stats/partitionState", "GET",
+		NewPartitionStateExportHandler(mgr),
+		map[string]string{
+			"_category": "Indexing|Index monitoring",
+			"_about": `Streams the partition state of the node's pindexes
+                       and feeds as newline delimited JSON.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
+	handle("/api/stats/replicaRPO", "GET",
+		NewReplicaRPOHandler(mgr),
+		map[string]string{
+			"_category": "Indexing|Index monitoring",
+			"_about": `Returns how far each pindex replica is behind its
+                       primary, as a seq gap and a wall-clock lag estimate,
+                       aggregated per index and per replica node.`,
+			"version introduced": "7.6.0",
+		},
+		"")
+
 	handle("/api/runtime/trace", "POST",
 		http.HandlerFunc(RuntimeTrace),
 		map[string]string{
@@ -0,0 +1,778 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package rest
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/cbauth"
+
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+)
+
+// An AuthIdentity is the authenticated identity of a REST request.
+type AuthIdentity struct {
+	User   string `json:"user"`
+	Domain string `json:"domain,omitempty"`
+}
+
+// An AuthProvider authenticates the REST requests, so that the REST
+// API can be protected by cbauth when running in a Couchbase Server
+// cluster, or by other means when running elsewhere.
+type AuthProvider interface {
+	// Authenticate returns the identity of the request, or an error
+	// when the request isn't authenticated.
+	Authenticate(req *http.Request) (*AuthIdentity, error)
+}
+
+// An AuthChallenger is an optional interface of an AuthProvider that
+// returns the WWW-Authenticate header of the unauthenticated
+// responses.
+type AuthChallenger interface {
+	AuthChallenge() string
+}
+
+type authIdentityKey struct{}
+
+// AuthIdentityFromRequest returns the identity of a request that was
+// authenticated by an AuthProvider, or nil.
+func AuthIdentityFromRequest(req *http.Request) *AuthIdentity {
+	id, _ := req.Context().Value(authIdentityKey{}).(*AuthIdentity)
+	return id
+}
+
+// NewAuthProviderHandler returns an auth handler, as accepted by the
+// "auth" option of InitRESTRouterEx, that rejects the requests that
+// the AuthProvider doesn't authenticate, and otherwise records their
+// identity in the request's context.
+func NewAuthProviderHandler(
+	provider AuthProvider) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			id, err := provider.Authenticate(req)
+			if err != nil || id == nil {
+				if err == nil {
+					err = fmt.Errorf("no identity")
+				}
+				if c, ok := provider.(AuthChallenger); ok {
+					w.Header().Set("WWW-Authenticate", c.AuthChallenge())
+				}
+				log.Warnf("rest_auth: path: %s, err: %v", req.URL.Path, err)
+				PropagateError(w, nil, "rest_auth: unauthorized",
+					http.StatusUnauthorized)
+				return
+			}
+
+			h.ServeHTTP(w, req.WithContext(
+				context.WithValue(req.Context(), authIdentityKey{}, id)))
+		})
+	}
+}
+
+// NewAuthProviderFromOptions returns the AuthProvider configured by
+// the "restAuthType" manager option, or nil when it isn't set.  The
+// supported types are...
+//
+//   - "cbauth", which authenticates via cbauth.
+//   - "token", which accepts the static bearer tokens of the
+//     "restAuthTokens" option, a JSON object of tokens to users.
+//   - "jwt", which validates the bearer JWTs signed by the keys of the
+//     "restAuthJWKSURL" option or the "restAuthJWTSecret" HMAC secret.
+//   - "oidc", which validates the bearer JWTs of the OpenID Connect
+//     provider of the "restAuthJWTIssuer" option.
+//
+// The JWT types also check the optional "restAuthJWTIssuer" and
+// "restAuthJWTAudience" options, and take the user from the claim of
+// the "restAuthJWTUserClaim" option, which defaults to "sub".
+func NewAuthProviderFromOptions(
+	options map[string]string) (AuthProvider, error) {
+	switch authType := options["restAuthType"]; authType {
+	case "":
+		return nil, nil
+
+	case "cbauth":
+		return CBAuthProvider{}, nil
+
+	case "token":
+		tokens := map[string]string{}
+		err := cbgt.UnmarshalJSON([]byte(options["restAuthTokens"]), &tokens)
+		if err != nil {
+			return nil, fmt.Errorf("rest_auth: restAuthTokens, err: %v", err)
+		}
+		return NewStaticTokenAuthProvider(tokens), nil
+
+	case "jwt", "oidc":
+		p := &JWTAuthProvider{
+			Issuer:    options["restAuthJWTIssuer"],
+			Audience:  options["restAuthJWTAudience"],
+			UserClaim: options["restAuthJWTUserClaim"],
+			JWKSURL:   options["restAuthJWKSURL"],
+		}
+		if secret := options["restAuthJWTSecret"]; secret != "" {
+			p.Keys = map[string]interface{}{"": []byte(secret)}
+		}
+		if authType == "oidc" {
+			if err := p.DiscoverOIDC(); err != nil {
+				return nil, err
+			}
+		}
+		if p.JWKSURL == "" && len(p.Keys) == 0 {
+			return nil, fmt.Errorf("rest_auth: restAuthType: %s,"+
+				" needs restAuthJWKSURL or restAuthJWTSecret", authType)
+		}
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("rest_auth: unknown restAuthType: %s",
+		options["restAuthType"])
+}
+
+// authOptions are the manager options of NewAuthProviderFromOptions.
+var authOptions = []string{"restAuthType", "restAuthTokens",
+	"restAuthJWKSURL", "restAuthJWTSecret", "restAuthJWTIssuer",
+	"restAuthJWTAudience", "restAuthJWTUserClaim"}
+
+// AuthProviderRebuildRetryInterval is the least time between the
+// rebuilds of the AuthProvider of the same manager options after a
+// failed rebuild, so that an unreachable OIDC provider isn't contacted
+// on every request.
+var AuthProviderRebuildRetryInterval = 5 * time.Second
+
+// An optionsAuthProvider is the AuthProvider of the manager options,
+// which is rebuilt when the options of NewAuthProviderFromOptions
+// change, such as when the tokens are rotated via the manager options
+// REST API.  A rebuild that fails keeps the previous AuthProvider, and
+// is retried after the AuthProviderRebuildRetryInterval.
+type optionsAuthProvider struct {
+	mgr *cbgt.Manager
+
+	m        sync.Mutex
+	vals     []string // The authOptions values of the provider.
+	provider AuthProvider
+
+	// Non-nil while a rebuild is in flight, closed when it's done.
+	buildDoneCh chan struct{}
+
+	failedVals []string // The authOptions values of the last failed rebuild.
+	failedAt   time.Time
+}
+
+// newOptionsAuthProvider returns an optionsAuthProvider, or an error
+// when the current manager options don't configure a valid provider.
+func newOptionsAuthProvider(mgr *cbgt.Manager) (*optionsAuthProvider, error) {
+	options := mgr.Options()
+
+	provider, err := NewAuthProviderFromOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &optionsAuthProvider{
+		mgr:      mgr,
+		vals:     authOptionValues(options),
+		provider: provider,
+	}, nil
+}
+
+func authOptionValues(options map[string]string) []string {
+	vals := make([]string, len(authOptions))
+	for i, k := range authOptions {
+		vals[i] = options[k]
+	}
+	return vals
+}
+
+func equalAuthOptionValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// current returns the AuthProvider of the current manager options,
+// which is nil when they don't configure one.  A rebuild, which may
+// need the network I/O of an OIDC discovery, is done outside of the
+// lock, and the concurrent requests wait for it rather than each
+// doing their own.
+func (p *optionsAuthProvider) current() AuthProvider {
+	options := p.mgr.Options()
+	vals := authOptionValues(options)
+
+	p.m.Lock()
+	for !equalAuthOptionValues(vals, p.vals) && p.buildDoneCh != nil {
+		buildDoneCh := p.buildDoneCh
+		p.m.Unlock()
+		<-buildDoneCh
+		p.m.Lock()
+	}
+
+	if equalAuthOptionValues(vals, p.vals) ||
+		(equalAuthOptionValues(vals, p.failedVals) &&
+			time.Since(p.failedAt) < AuthProviderRebuildRetryInterval) {
+		provider := p.provider
+		p.m.Unlock()
+		return provider
+	}
+
+	buildDoneCh := make(chan struct{})
+	p.buildDoneCh = buildDoneCh
+	p.m.Unlock()
+
+	provider, err := NewAuthProviderFromOptions(options)
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.buildDoneCh = nil
+	close(buildDoneCh)
+
+	if err != nil {
+		log.Warnf("rest_auth: keeping the previous auth provider, err: %v", err)
+		p.failedVals = vals
+		p.failedAt = time.Now()
+		return p.provider
+	}
+
+	log.Printf("rest_auth: auth provider refreshed, restAuthType: %s",
+		vals[0])
+
+	p.vals = vals
+	p.provider = provider
+	p.failedVals = nil
+
+	return provider
+}
+
+// newOptionsAuthHandler returns an auth handler of the
+// optionsAuthProvider, which lets the requests through while the
+// manager options don't configure an AuthProvider.
+func newOptionsAuthHandler(p *optionsAuthProvider) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			provider := p.current()
+			if provider == nil {
+				h.ServeHTTP(w, req)
+				return
+			}
+
+			NewAuthProviderHandler(provider)(h).ServeHTTP(w, req)
+		})
+	}
+}
+
+// unredactAuthSecretOptions restores the secrets of the prev options
+// that the next options carry only as a RedactedOptionValue, such as
+// when a client writes back the options it has read.
+func unredactAuthSecretOptions(prev, next map[string]string) {
+	for _, k := range cbgt.AuthSecretOptions {
+		if next[k] == cbgt.RedactedOptionValue {
+			if v, exists := prev[k]; exists {
+				next[k] = v
+			} else {
+				delete(next, k)
+			}
+		}
+	}
+}
+
+// ---------------------------------------------------
+
+// CBAuthProvider is an AuthProvider that authenticates the requests
+// via cbauth, for use in a Couchbase Server cluster.
+type CBAuthProvider struct{}
+
+func (CBAuthProvider) Authenticate(req *http.Request) (*AuthIdentity, error) {
+	creds, err := cbauth.AuthWebCreds(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthIdentity{User: creds.Name(), Domain: creds.Domain()}, nil
+}
+
+func (CBAuthProvider) AuthChallenge() string {
+	return `Basic realm="Couchbase"`
+}
+
+// ---------------------------------------------------
+
+// StaticTokenAuthProvider is an AuthProvider that accepts a fixed set
+// of bearer tokens, such as for the service accounts of a standalone
+// deployment.
+type StaticTokenAuthProvider struct {
+	tokens map[string]string // Keyed by token, values are users.
+}
+
+// NewStaticTokenAuthProvider returns a StaticTokenAuthProvider that
+// accepts the given tokens, keyed by token, as their users.
+func NewStaticTokenAuthProvider(
+	tokens map[string]string) *StaticTokenAuthProvider {
+	p := &StaticTokenAuthProvider{tokens: map[string]string{}}
+	for token, user := range tokens {
+		if token != "" {
+			p.tokens[token] = user
+		}
+	}
+	return p
+}
+
+func (p *StaticTokenAuthProvider) Authenticate(
+	req *http.Request) (*AuthIdentity, error) {
+	token, err := bearerToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare against all of the tokens, in constant time, so that the
+	// timing doesn't leak how much of a token matched.
+	var user string
+	var found bool
+	for t, u := range p.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			user, found = u, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("rest_auth: unknown token")
+	}
+
+	return &AuthIdentity{User: user, Domain: "token"}, nil
+}
+
+func (p *StaticTokenAuthProvider) AuthChallenge() string {
+	return "Bearer"
+}
+
+func bearerToken(req *http.Request) (string, error) {
+	h := req.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", fmt.Errorf("rest_auth: no bearer token")
+	}
+
+	token := strings.TrimSpace(h[7:])
+	if token == "" {
+		return "", fmt.Errorf("rest_auth: empty bearer token")
+	}
+
+	return token, nil
+}
+
+// ---------------------------------------------------
+
+// JWTAuthKeysMinRefreshInterval is the least time between the fetches
+// of a JWTAuthProvider's JWKS, which are otherwise triggered by the
+// tokens signed by unknown keys.
+var JWTAuthKeysMinRefreshInterval = time.Minute
+
+// JWTAuthProvider is an AuthProvider that validates bearer JSON Web
+// Tokens, such as the ID or access tokens of an OpenID Connect
+// provider.  The tokens are signed by the RS, PS, ES or HS algorithms,
+// and must not be expired.
+type JWTAuthProvider struct {
+	// Issuer, when non-empty, is the required "iss" claim.
+	Issuer string
+
+	// Audience, when non-empty, must be one of the "aud" claims.
+	Audience string
+
+	// UserClaim is the claim of the user, which defaults to "sub".
+	UserClaim string
+
+	// Leeway is the allowed clock skew of the time claims.
+	Leeway time.Duration
+
+	// Keys are the verification keys, keyed by "kid", where the ""
+	// key is used for the tokens without a "kid".  The keys are
+	// *rsa.PublicKey, *ecdsa.PublicKey, or []byte HMAC secrets.
+	Keys map[string]interface{}
+
+	// JWKSURL, when non-empty, is the URL of the JSON Web Key Set of
+	// the keys that are not in Keys.
+	JWKSURL string
+
+	// Client fetches the JWKS and the OpenID Connect discovery
+	// document, which defaults to cbgt.HttpClient().
+	Client cbgt.HTTPClient
+
+	m         sync.Mutex
+	jwksKeys  map[string]interface{}
+	jwksFetch time.Time
+}
+
+func (p *JWTAuthProvider) AuthChallenge() string {
+	return "Bearer"
+}
+
+func (p *JWTAuthProvider) httpClient() cbgt.HTTPClient {
+	if p.Client != nil {
+		return p.Client
+	}
+	return cbgt.HttpClient()
+}
+
+func (p *JWTAuthProvider) getJSON(url string, v interface{}) error {
+	resp, err := p.httpClient().Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rest_auth: url: %s, status code: %d",
+			url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// DiscoverOIDC sets the JWKSURL from the OpenID Connect discovery
+// document of the Issuer.
+func (p *JWTAuthProvider) DiscoverOIDC() error {
+	if p.Issuer == "" {
+		return fmt.Errorf("rest_auth: DiscoverOIDC, no issuer")
+	}
+
+	var doc struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	err := p.getJSON(strings.TrimSuffix(p.Issuer, "/")+
+		"/.well-known/openid-configuration", &doc)
+	if err != nil {
+		return fmt.Errorf("rest_auth: DiscoverOIDC, issuer: %s, err: %v",
+			p.Issuer, err)
+	}
+	if doc.Issuer != p.Issuer || doc.JWKSURI == "" {
+		return fmt.Errorf("rest_auth: DiscoverOIDC, issuer: %s,"+
+			" mismatched discovery document", p.Issuer)
+	}
+
+	p.JWKSURL = doc.JWKSURI
+
+	return nil
+}
+
+// key returns the verification key of a kid, fetching the JWKS when
+// the key is unknown.
+func (p *JWTAuthProvider) key(kid string) (interface{}, error) {
+	if k, exists := p.Keys[kid]; exists {
+		return k, nil
+	}
+
+	if p.JWKSURL == "" {
+		if k, exists := p.Keys[""]; exists {
+			return k, nil // The default key.
+		}
+		return nil, fmt.Errorf("rest_auth: unknown kid: %s", kid)
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if k, exists := p.jwksKeys[kid]; exists {
+		return k, nil
+	}
+
+	if time.Since(p.jwksFetch) < JWTAuthKeysMinRefreshInterval {
+		return nil, fmt.Errorf("rest_auth: unknown kid: %s", kid)
+	}
+	p.jwksFetch = time.Now()
+
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := p.getJSON(p.JWKSURL, &jwks); err != nil {
+		return nil, fmt.Errorf("rest_auth: jwks, err: %v", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, raw := range jwks.Keys {
+		jwkKID, k, err := parseJWK(raw)
+		if err != nil {
+			log.Warnf("rest_auth: jwks, skipped key, err: %v", err)
+			continue
+		}
+		keys[jwkKID] = k
+	}
+	p.jwksKeys = keys
+
+	if k, exists := keys[kid]; exists {
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("rest_auth: unknown kid: %s", kid)
+}
+
+// parseJWK returns the kid and public key of a JSON Web Key.
+func parseJWK(raw []byte) (string, interface{}, error) {
+	var jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return "", nil, err
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return "", nil, fmt.Errorf("kid: %s, use: %s", jwk.Kid, jwk.Use)
+	}
+
+	b64 := base64.RawURLEncoding
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(jwk.N)
+		if err != nil {
+			return "", nil, err
+		}
+		e, err := b64.DecodeString(jwk.E)
+		if err != nil {
+			return "", nil, err
+		}
+		return jwk.Kid, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return "", nil, fmt.Errorf("kid: %s, crv: %s", jwk.Kid, jwk.Crv)
+		}
+		x, err := b64.DecodeString(jwk.X)
+		if err != nil {
+			return "", nil, err
+		}
+		y, err := b64.DecodeString(jwk.Y)
+		if err != nil {
+			return "", nil, err
+		}
+		return jwk.Kid, &ecdsa.PublicKey{Curve: curve,
+			X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+
+	return "", nil, fmt.Errorf("kid: %s, kty: %s", jwk.Kid, jwk.Kty)
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// verifyJWTSignature checks the signature of the signed content by the
+// algorithm, where the key's type must match the algorithm so that a
+// public key can't be misused as an HMAC secret.
+func verifyJWTSignature(alg string, key interface{},
+	signed, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported alg: %s", alg)
+	}
+
+	hash, ok := jwtHashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("unsupported alg: %s", alg)
+	}
+
+	hasher := hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+		case "PS":
+			return rsa.VerifyPSS(k, hash, digest, sig, nil)
+		}
+
+	case *ecdsa.PublicKey:
+		if alg[:2] == "ES" {
+			size := (k.Curve.Params().BitSize + 7) / 8
+			if len(sig) != 2*size {
+				return fmt.Errorf("invalid signature")
+			}
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if !ecdsa.Verify(k, digest, r, s) {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		}
+
+	case []byte:
+		if alg[:2] == "HS" {
+			mac := hmac.New(hash.New, k)
+			mac.Write(signed)
+			if !hmac.Equal(mac.Sum(nil), sig) {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("alg: %s, mismatched key type: %T", alg, key)
+}
+
+func (p *JWTAuthProvider) Authenticate(
+	req *http.Request) (*AuthIdentity, error) {
+	token, err := bearerToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := p.Validate(token)
+	if err != nil {
+		return nil, err
+	}
+
+	userClaim := p.UserClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+
+	user, _ := claims[userClaim].(string)
+	if user == "" {
+		return nil, fmt.Errorf("rest_auth: jwt, no user claim: %s", userClaim)
+	}
+
+	domain := "jwt"
+	if iss, ok := claims["iss"].(string); ok && iss != "" {
+		domain = iss
+	}
+
+	return &AuthIdentity{User: user, Domain: domain}, nil
+}
+
+// Validate returns the claims of a JWT, after checking its signature
+// and its time, issuer and audience claims.
+func (p *JWTAuthProvider) Validate(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("rest_auth: jwt, malformed token")
+	}
+
+	b64 := base64.RawURLEncoding
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	buf, err := b64.DecodeString(parts[0])
+	if err == nil {
+		err = json.Unmarshal(buf, &header)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rest_auth: jwt, header, err: %v", err)
+	}
+
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("rest_auth: jwt, signature, err: %v", err)
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifyJWTSignature(header.Alg, key,
+		[]byte(parts[0]+"."+parts[1]), sig)
+	if err != nil {
+		return nil, fmt.Errorf("rest_auth: jwt, kid: %s, err: %v",
+			header.Kid, err)
+	}
+
+	claims := map[string]interface{}{}
+	buf, err = b64.DecodeString(parts[1])
+	if err == nil {
+		err = json.Unmarshal(buf, &claims)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rest_auth: jwt, claims, err: %v", err)
+	}
+
+	now := time.Now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("rest_auth: jwt, no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(p.Leeway)) {
+		return nil, fmt.Errorf("rest_auth: jwt, expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok &&
+		now.Before(time.Unix(int64(nbf), 0).Add(-p.Leeway)) {
+		return nil, fmt.Errorf("rest_auth: jwt, not yet valid")
+	}
+
+	if p.Issuer != "" && claims["iss"] != p.Issuer {
+		return nil, fmt.Errorf("rest_auth: jwt, mismatched issuer: %v",
+			claims["iss"])
+	}
+
+	if p.Audience != "" && !jwtHasAudience(claims["aud"], p.Audience) {
+		return nil, fmt.Errorf("rest_auth: jwt, mismatched audience: %v",
+			claims["aud"])
+	}
+
+	return claims, nil
+}
+
+// jwtHasAudience returns true when the "aud" claim, which is either a
+// string or an array of strings, has the audience.
+func jwtHasAudience(aud interface{}, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []interface{}:
+		for _, x := range a {
+			if x == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
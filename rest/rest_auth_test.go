@@ -0,0 +1,442 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package rest
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbgt"
+)
+
+func testJWT(header, claims map[string]interface{},
+	sign func(signed []byte) []byte) string {
+	b64 := base64.RawURLEncoding
+
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+
+	return signed + "." + b64.EncodeToString(sign([]byte(signed)))
+}
+
+func testAuthRequest(token string) *http.Request {
+	req := httptest.NewRequest("GET", "/api/index", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestStaticTokenAuthProvider(t *testing.T) {
+	p := NewStaticTokenAuthProvider(map[string]string{
+		"secret": "alice",
+		"":       "ignored",
+	})
+
+	id, err := p.Authenticate(testAuthRequest("secret"))
+	if err != nil || id.User != "alice" {
+		t.Fatalf("expected alice, got: %+v, err: %v", id, err)
+	}
+
+	for _, token := range []string{"", "secre", "secrets"} {
+		if _, err = p.Authenticate(testAuthRequest(token)); err == nil {
+			t.Fatalf("expected an err for token: %q", token)
+		}
+	}
+}
+
+func TestAuthProviderHandler(t *testing.T) {
+	var seen *AuthIdentity
+	h := NewAuthProviderHandler(NewStaticTokenAuthProvider(
+		map[string]string{"secret": "alice"}))(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			seen = AuthIdentityFromRequest(req)
+		}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, testAuthRequest("wrong"))
+	if w.Code != http.StatusUnauthorized ||
+		w.Header().Get("WWW-Authenticate") != "Bearer" || seen != nil {
+		t.Fatalf("expected a 401, got: %d, %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, testAuthRequest("secret"))
+	if w.Code != http.StatusOK || seen == nil || seen.User != "alice" {
+		t.Fatalf("expected alice, got: %d, %+v", w.Code, seen)
+	}
+}
+
+func TestJWTAuthProviderHMAC(t *testing.T) {
+	secret := []byte("shh")
+	sign := func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+
+	p, err := NewAuthProviderFromOptions(map[string]string{
+		"restAuthType":        "jwt",
+		"restAuthJWTSecret":   string(secret),
+		"restAuthJWTIssuer":   "iss0",
+		"restAuthJWTAudience": "cbgt",
+	})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	header := map[string]interface{}{"alg": "HS256"}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		claims map[string]interface{}
+		ok     bool
+	}{
+		{map[string]interface{}{"sub": "bob", "iss": "iss0",
+			"aud": "cbgt", "exp": exp}, true},
+		{map[string]interface{}{"sub": "bob", "iss": "iss0",
+			"aud": []string{"x", "cbgt"}, "exp": exp}, true},
+		{map[string]interface{}{"sub": "bob", "iss": "iss1",
+			"aud": "cbgt", "exp": exp}, false},
+		{map[string]interface{}{"sub": "bob", "iss": "iss0",
+			"aud": "other", "exp": exp}, false},
+		{map[string]interface{}{"sub": "bob", "iss": "iss0",
+			"aud": "cbgt"}, false},
+		{map[string]interface{}{"sub": "bob", "iss": "iss0",
+			"aud": "cbgt", "exp": time.Now().Add(-time.Hour).Unix()}, false},
+		{map[string]interface{}{"sub": "bob", "iss": "iss0",
+			"aud": "cbgt", "exp": exp, "nbf": exp}, false},
+		{map[string]interface{}{"iss": "iss0",
+			"aud": "cbgt", "exp": exp}, false},
+	}
+
+	for i, test := range tests {
+		id, err := p.Authenticate(
+			testAuthRequest(testJWT(header, test.claims, sign)))
+		if test.ok && (err != nil || id.User != "bob" || id.Domain != "iss0") {
+			t.Errorf("test: %d, expected bob, got: %+v, err: %v", i, id, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("test: %d, expected an err", i)
+		}
+	}
+
+	// An unsigned token or a wrong secret is rejected.
+	claims := map[string]interface{}{"sub": "bob", "iss": "iss0",
+		"aud": "cbgt", "exp": exp}
+	for _, token := range []string{
+		testJWT(map[string]interface{}{"alg": "none"}, claims,
+			func([]byte) []byte { return nil }),
+		testJWT(header, claims,
+			func([]byte) []byte { return []byte("forged") }),
+	} {
+		if _, err = p.Authenticate(testAuthRequest(token)); err == nil {
+			t.Errorf("expected an err for token: %s", token)
+		}
+	}
+}
+
+func TestJWTAuthProviderOIDC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	var issuer string
+	var jwksFetches int
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration",
+		func(w http.ResponseWriter, req *http.Request) {
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":   issuer,
+				"jwks_uri": issuer + "/jwks",
+			})
+		})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, req *http.Request) {
+		jwksFetches++
+		b64 := base64.RawURLEncoding
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k0",
+				"use": "sig",
+				"n":   b64.EncodeToString(key.N.Bytes()),
+				"e":   b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	issuer = server.URL
+
+	p, err := NewAuthProviderFromOptions(map[string]string{
+		"restAuthType":         "oidc",
+		"restAuthJWTIssuer":    issuer,
+		"restAuthJWTUserClaim": "email",
+	})
+	if err != nil {
+		t.Fatalf("expected no err, got: %v", err)
+	}
+
+	sign := func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		return sig
+	}
+	claims := map[string]interface{}{"email": "carol@example.com",
+		"iss": issuer, "exp": time.Now().Add(time.Hour).Unix()}
+
+	token := testJWT(map[string]interface{}{"alg": "RS256", "kid": "k0"},
+		claims, sign)
+	id, err := p.Authenticate(testAuthRequest(token))
+	if err != nil || id.User != "carol@example.com" {
+		t.Fatalf("expected carol, got: %+v, err: %v", id, err)
+	}
+
+	// The RSA key can't be misused as an HMAC secret.
+	token = testJWT(map[string]interface{}{"alg": "HS256", "kid": "k0"},
+		claims, sign)
+	if _, err = p.Authenticate(testAuthRequest(token)); err == nil {
+		t.Fatalf("expected an err for a mismatched alg")
+	}
+
+	// An unknown kid refetches the JWKS at most once per interval.
+	token = testJWT(map[string]interface{}{"alg": "RS256", "kid": "k1"},
+		claims, sign)
+	for i := 0; i < 3; i++ {
+		if _, err = p.Authenticate(testAuthRequest(token)); err == nil {
+			t.Fatalf("expected an err for an unknown kid")
+		}
+	}
+	if jwksFetches != 1 {
+		t.Fatalf("expected 1 jwks fetch, got: %d", jwksFetches)
+	}
+}
+
+func TestNewAuthProviderFromOptions(t *testing.T) {
+	p, err := NewAuthProviderFromOptions(nil)
+	if p != nil || err != nil {
+		t.Fatalf("expected no provider, got: %v, err: %v", p, err)
+	}
+
+	if p, _ = NewAuthProviderFromOptions(map[string]string{
+		"restAuthType": "cbauth",
+	}); p == nil {
+		t.Fatalf("expected a cbauth provider")
+	}
+
+	for _, options := range []map[string]string{
+		{"restAuthType": "unknown"},
+		{"restAuthType": "token", "restAuthTokens": "not-json"},
+		{"restAuthType": "jwt"},
+		{"restAuthType": "oidc"},
+	} {
+		if _, err = NewAuthProviderFromOptions(options); err == nil {
+			t.Errorf("expected an err for options: %v", options)
+		}
+	}
+}
+
+func TestRedactAuthSecretOptions(t *testing.T) {
+	options := map[string]string{
+		"restAuthType":      "jwt",
+		"restAuthJWTSecret": "s3cret",
+		"restAuthTokens":    `{"t0k3n":"u"}`,
+	}
+
+	redacted := cbgt.RedactAuthSecretOptions(options)
+	for _, k := range cbgt.AuthSecretOptions {
+		if redacted[k] != cbgt.RedactedOptionValue {
+			t.Errorf("expected %s to be redacted, got: %s", k, redacted[k])
+		}
+	}
+	if redacted["restAuthType"] != "jwt" {
+		t.Errorf("expected restAuthType to be kept, got: %v", redacted)
+	}
+	if options["restAuthJWTSecret"] != "s3cret" {
+		t.Errorf("expected the options to be unchanged, got: %v", options)
+	}
+
+	// Writing back the redacted options keeps the secrets.
+	unredactAuthSecretOptions(options, redacted)
+	if redacted["restAuthJWTSecret"] != "s3cret" ||
+		redacted["restAuthTokens"] != `{"t0k3n":"u"}` {
+		t.Errorf("expected the secrets to be restored, got: %v", redacted)
+	}
+}
+
+func TestOptionsAuthProviderRefresh(t *testing.T) {
+	cfg := cbgt.NewCfgMem()
+	mgr := cbgt.NewManagerEx(cbgt.VERSION, cfg, cbgt.NewUUID(),
+		nil, "", 1, "", ":1000", "", "some-datasource", nil,
+		map[string]string{
+			"restAuthType":   "token",
+			"restAuthTokens": `{"old":"alice"}`,
+		})
+
+	p, err := newOptionsAuthProvider(mgr)
+	if err != nil {
+		t.Fatalf("expected no err, err: %v", err)
+	}
+	h := newOptionsAuthHandler(p)(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {}))
+
+	check := func(token string, expCode int) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, testAuthRequest(token))
+		if w.Code != expCode {
+			t.Fatalf("token: %q, expected: %d, got: %d",
+				token, expCode, w.Code)
+		}
+	}
+
+	setOption := func(k, v string) {
+		options := map[string]string{}
+		for k, v := range mgr.Options() {
+			options[k] = v
+		}
+		options[k] = v
+		cfg.Del(cbgt.MANAGER_CLUSTER_OPTIONS_KEY, 0)
+		if err := mgr.SetOptions(options); err != nil {
+			t.Fatalf("expected no err, err: %v", err)
+		}
+	}
+
+	check("old", http.StatusOK)
+	check("new", http.StatusUnauthorized)
+
+	setOption("restAuthTokens", `{"new":"alice"}`)
+
+	check("old", http.StatusUnauthorized)
+	check("new", http.StatusOK)
+
+	// An invalid rotation keeps the previous provider.
+	setOption("restAuthTokens", `not json`)
+
+	check("old", http.StatusUnauthorized)
+	check("new", http.StatusOK)
+
+	setOption("restAuthType", "")
+
+	check("", http.StatusOK)
+}
+
+func TestOptionsAuthProviderRebuild(t *testing.T) {
+	prevRetryInterval := AuthProviderRebuildRetryInterval
+	defer func() { AuthProviderRebuildRetryInterval = prevRetryInterval }()
+	AuthProviderRebuildRetryInterval = time.Hour
+
+	var issuer string
+	var discoveries atomic.Int32
+	var discoveryFails atomic.Bool
+	discoveryFails.Store(true)
+	discoveryCh := make(chan chan struct{}, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration",
+		func(w http.ResponseWriter, req *http.Request) {
+			discoveries.Add(1)
+			select {
+			case ch := <-discoveryCh:
+				<-ch // Blocks the discovery until the test is done.
+			default:
+			}
+			if discoveryFails.Load() {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":   issuer,
+				"jwks_uri": issuer + "/jwks",
+			})
+		})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	issuer = server.URL
+
+	cfg := cbgt.NewCfgMem()
+	mgr := cbgt.NewManagerEx(cbgt.VERSION, cfg, cbgt.NewUUID(),
+		nil, "", 1, "", ":1000", "", "some-datasource", nil,
+		map[string]string{
+			"restAuthType":   "token",
+			"restAuthTokens": `{"t0":"alice"}`,
+		})
+
+	p, err := newOptionsAuthProvider(mgr)
+	if err != nil {
+		t.Fatalf("expected no err, err: %v", err)
+	}
+
+	options := map[string]string{}
+	for k, v := range mgr.Options() {
+		options[k] = v
+	}
+	options["restAuthType"] = "oidc"
+	options["restAuthJWTIssuer"] = issuer
+	cfg.Del(cbgt.MANAGER_CLUSTER_OPTIONS_KEY, 0)
+	if err = mgr.SetOptions(options); err != nil {
+		t.Fatalf("expected no err, err: %v", err)
+	}
+
+	// A failed rebuild keeps the previous provider, and isn't retried
+	// within the retry interval.
+	for i := 0; i < 3; i++ {
+		if _, ok := p.current().(*StaticTokenAuthProvider); !ok {
+			t.Fatalf("expected the previous provider")
+		}
+	}
+	if n := discoveries.Load(); n != 1 {
+		t.Fatalf("expected 1 discovery, got: %d", n)
+	}
+
+	// The failed rebuild is retried after the retry interval, where
+	// the discovery is done outside of the provider's lock.
+	AuthProviderRebuildRetryInterval = 0
+	discoveryFails.Store(false)
+
+	releaseCh := make(chan struct{})
+	discoveryCh <- releaseCh
+
+	providerCh := make(chan AuthProvider)
+	go func() { providerCh <- p.current() }()
+
+	for discoveries.Load() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if !p.m.TryLock() {
+		t.Fatalf("expected the lock to not be held during the discovery")
+	}
+	p.m.Unlock()
+
+	close(releaseCh)
+
+	if _, ok := (<-providerCh).(*JWTAuthProvider); !ok {
+		t.Fatalf("expected the retried rebuild to work")
+	}
+	_, ok := p.current().(*JWTAuthProvider)
+	if n := discoveries.Load(); !ok || n != 2 {
+		t.Fatalf("expected the rebuilt provider to be kept, discoveries: %d",
+			n)
+	}
+}
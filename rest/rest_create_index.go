@@ -137,6 +137,8 @@ func (h *CreateIndexHandler) RESTOpts(opts map[string]string) {
 			strings.Join(sourceParams, "\n\n")
 	opts["param: planParams"] =
 		"optional, JSON object, form parameter"
+	opts["param: labels"] =
+		"optional, JSON object of string key/value pairs, form parameter"
 	opts["param: prevIndexUUID / indexUUID"] =
 		"optional, string, form parameter\n\n" +
 			"Intended for clients that want to check that they are not " +
@@ -288,6 +290,21 @@ func (h *CreateIndexHandler) ServeHTTP(
 		}
 	}
 
+	labels := indexDef.Labels
+
+	labelsStr := req.FormValue("labels")
+	if labelsStr != "" {
+		labels = nil
+		err2 := cbgt.UnmarshalJSON([]byte(labelsStr), &labels)
+		if err2 != nil {
+			ShowErrorBody(w, requestBody, fmt.Sprintf("rest_create_index:"+
+				" error parsing labels: %s, indexName: %s, err: %v",
+				labelsStr, indexName, err2), http.StatusBadRequest)
+			atomic.AddUint64(&totalCreateIndexBadReqErr, 1)
+			return
+		}
+	}
+
 	payload := &cbgt.CreateIndexPayload{
 		SourceType:    sourceType,
 		SourceName:    sourceName,
@@ -298,6 +315,7 @@ func (h *CreateIndexHandler) ServeHTTP(
 		IndexParams:   indexParams,
 		PlanParams:    planParams,
 		PrevIndexUUID: prevIndexUUID,
+		Labels:        labels,
 	}
 
 	if h.scopedIndex {
@@ -324,9 +342,15 @@ func (h *CreateIndexHandler) ServeHTTP(
 			status = http.StatusBadRequest
 			atomic.AddUint64(&totalCreateIndexBadReqErr, 1)
 		}
-		ShowErrorBody(w, requestBody, fmt.Sprintf("rest_create_index:"+
-			" error creating index: %s, err: %v",
-			indexName, err), status)
+		msg := fmt.Sprintf("rest_create_index:"+
+			" error creating index: %s, err: %v", indexName, err)
+		var validationErrors cbgt.IndexDefValidationErrors
+		if errors.As(err, &validationErrors) {
+			showErrorDetails(w, requestBody, msg, status,
+				map[string]interface{}{"validationErrors": validationErrors})
+			return
+		}
+		ShowErrorBody(w, requestBody, msg, status)
 		return
 	}
 
@@ -516,3 +540,81 @@ func getNumSourcePartitionsForBucket(server, bucketName string) (int, error) {
 
 	return rv.NumVBuckets, nil
 }
+
+// ---------------------------------------------------
+
+// EstimateIndexHandler is a REST handler that estimates the cost of an
+// index definition, such as its size, build time and per-node
+// resource needs, without creating the index.
+type EstimateIndexHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewEstimateIndexHandler(mgr *cbgt.Manager) *EstimateIndexHandler {
+	return &EstimateIndexHandler{mgr: mgr}
+}
+
+func (h *EstimateIndexHandler) RESTOpts(opts map[string]string) {
+	opts["param: indexName"] =
+		"required, string, URL path parameter\n\n" +
+			"The name of the index definition to be estimated."
+	opts["request body"] =
+		"The JSON index definition, as for an index creation"
+}
+
+func (h *EstimateIndexHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	indexName := unscopedIndexNameLookup(req)
+	if indexName == "" {
+		ShowError(w, req, "rest_create_index: index name is required",
+			http.StatusBadRequest)
+		return
+	}
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		ShowErrorBody(w, nil, fmt.Sprintf("rest_create_index:"+
+			" could not read request body, indexName: %s, err: %v",
+			indexName, err), http.StatusBadRequest)
+		return
+	}
+
+	indexDef := cbgt.IndexDef{
+		PlanParams: cbgt.NewPlanParams(h.mgr),
+	}
+
+	if len(requestBody) > 0 {
+		err = cbgt.UnmarshalJSON(requestBody, &indexDef)
+		if err != nil {
+			ShowErrorBody(w, requestBody, fmt.Sprintf("rest_create_index:"+
+				" could not unmarshal json, indexName: %s, err: %v",
+				indexName, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	indexDef.Name = indexName
+	indexDef.SourceType, indexDef.SourceName =
+		ExtractSourceTypeName(req, &indexDef, indexName)
+
+	estimate, err := h.mgr.EstimateIndex(&indexDef)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var badRequestError *cbgt.BadRequestError
+		if errors.As(err, &badRequestError) {
+			status = http.StatusBadRequest
+		}
+		ShowErrorBody(w, requestBody, fmt.Sprintf("rest_create_index:"+
+			" could not estimate index, indexName: %s, err: %v",
+			indexName, err), status)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status   string              `json:"status"`
+		Estimate *cbgt.IndexEstimate `json:"estimate"`
+	}{
+		Status:   "ok",
+		Estimate: estimate,
+	})
+}
@@ -112,3 +112,82 @@ func (h *DeleteIndexHandler) ServeHTTP(
 		UUID:   indexUUID,
 	})
 }
+
+// ---------------------------------------------------
+
+// IndexTrashHandler is a REST handler that lists the deleted indexes
+// that can still be undeleted.
+type IndexTrashHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewIndexTrashHandler(mgr *cbgt.Manager) *IndexTrashHandler {
+	return &IndexTrashHandler{mgr: mgr}
+}
+
+func (h *IndexTrashHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	entries, err := h.mgr.IndexTrash()
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_delete_index:"+
+			" could not get index trash, err: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	MustEncode(w, map[string]interface{}{
+		"status":     "ok",
+		"indexTrash": entries,
+	})
+}
+
+// UndeleteIndexHandler is a REST handler that restores a deleted index
+// from the index trash.
+type UndeleteIndexHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewUndeleteIndexHandler(mgr *cbgt.Manager) *UndeleteIndexHandler {
+	return &UndeleteIndexHandler{mgr: mgr}
+}
+
+func (h *UndeleteIndexHandler) RESTOpts(opts map[string]string) {
+	opts["param: indexName"] = "required, string, URL path parameter\n\n" +
+		"The name of the deleted index definition to be restored."
+	opts["param: indexUUID"] = "optional, string, URL query parameter\n\n" +
+		"The UUID of the deleted index definition to be restored," +
+		" which defaults to the most recently deleted index of the name."
+}
+
+func (h *UndeleteIndexHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	indexName := IndexNameLookup(req)
+	if indexName == "" {
+		ShowError(w, req, "rest_delete_index: index name is required",
+			http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("rest_delete_index: undelete index request received for %v",
+		indexName)
+	indexDef, err := h.mgr.UndeleteIndex(indexName,
+		req.FormValue("indexUUID"))
+	if err != nil {
+		status := http.StatusBadRequest
+		var internalServerError *cbgt.InternalServerError
+		if errors.As(err, &internalServerError) {
+			status = http.StatusInternalServerError
+		}
+		ShowError(w, req, fmt.Sprintf("rest_delete_index:"+
+			" error undeleting index, err: %v", err), status)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status string `json:"status"`
+		UUID   string `json:"uuid"`
+	}{
+		Status: "ok",
+		UUID:   indexDef.UUID,
+	})
+}
@@ -0,0 +1,276 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/couchbase/cbgt"
+	log "github.com/couchbase/clog"
+)
+
+// PartitionStateExportHandler is a REST handler that streams the
+// complete partition state of a node in a single pass, as newline
+// delimited JSON, so that external reconciliation and inventory
+// systems don't have to issue several requests per pindex.
+type PartitionStateExportHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewPartitionStateExportHandler(
+	mgr *cbgt.Manager) *PartitionStateExportHandler {
+	return &PartitionStateExportHandler{mgr: mgr}
+}
+
+// PartitionStateExportPIndex is the line written for each pindex.
+type PartitionStateExportPIndex struct {
+	Kind             string   `json:"kind"` // "pindex".
+	Name             string   `json:"name"`
+	UUID             string   `json:"uuid"`
+	IndexType        string   `json:"indexType"`
+	IndexName        string   `json:"indexName"`
+	IndexUUID        string   `json:"indexUUID"`
+	SourceType       string   `json:"sourceType"`
+	SourceName       string   `json:"sourceName"`
+	SourceUUID       string   `json:"sourceUUID"`
+	SourcePartitions []string `json:"sourcePartitions"`
+
+	// The on-disk size in bytes, or -1 when it couldn't be computed.
+	DiskSize int64 `json:"diskSize"`
+
+	// The names of the feeds that are feeding the pindex.
+	Feeds []string `json:"feeds"`
+
+	// Keyed by source partition, when the pindex's Dest implements
+	// cbgt.DestLastProcessed.
+	LastProcessed map[string]cbgt.QueryPartitionSeq `json:"lastProcessed,omitempty"`
+
+	// Keyed by source partition, only when requested with seqno=true.
+	SourceSeqs map[string]cbgt.UUIDSeq `json:"sourceSeqs,omitempty"`
+}
+
+// PartitionStateExportFeed is the line written for each feed.
+type PartitionStateExportFeed struct {
+	Kind       string          `json:"kind"` // "feed".
+	Name       string          `json:"name"`
+	IndexName  string          `json:"indexName"`
+	Partitions []string        `json:"partitions"`
+	Stats      json.RawMessage `json:"stats,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+func (h *PartitionStateExportHandler) RESTOpts(opts map[string]string) {
+	opts["param: seqno"] =
+		"optional, bool, URL query parameter\n\n" +
+			"When true, the high seqs of the source partitions are" +
+			" included, which requires a round trip to each data source."
+	opts["param: indexName"] =
+		"optional, string, URL query parameter\n\n" +
+			"Restricts the export to the given index."
+}
+
+func (h *PartitionStateExportHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	includeSeqNos := req.FormValue("seqno") == "true"
+	indexName := req.FormValue("indexName")
+
+	feeds, pindexes := h.mgr.CurrentMaps()
+
+	feedNames := make([]string, 0, len(feeds))
+	for feedName, feed := range feeds {
+		if indexName == "" || feed.IndexName() == indexName {
+			feedNames = append(feedNames, feedName)
+		}
+	}
+	sort.Strings(feedNames)
+
+	pindexNames := make([]string, 0, len(pindexes))
+	for pindexName, pindex := range pindexes {
+		if indexName == "" || pindex.IndexName == indexName {
+			pindexNames = append(pindexNames, pindexName)
+		}
+	}
+	sort.Strings(pindexNames)
+
+	destFeeds := map[cbgt.Dest][]string{}
+	for _, feedName := range feedNames {
+		for _, dest := range feeds[feedName].Dests() {
+			names := destFeeds[dest]
+			if len(names) == 0 || names[len(names)-1] != feedName {
+				destFeeds[dest] = append(names, feedName)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	flusher, _ := w.(http.Flusher)
+
+	enc := json.NewEncoder(w)
+
+	// Source partition seqs are per source, so they're fetched once
+	// and shared by the pindexes of the same source.
+	sourceSeqs := map[string]map[string]cbgt.UUIDSeq{}
+
+	for _, pindexName := range pindexNames {
+		pindex := pindexes[pindexName]
+
+		line := &PartitionStateExportPIndex{
+			Kind:       "pindex",
+			Name:       pindex.Name,
+			UUID:       pindex.UUID,
+			IndexType:  pindex.IndexType,
+			IndexName:  pindex.IndexName,
+			IndexUUID:  pindex.IndexUUID,
+			SourceType: pindex.SourceType,
+			SourceName: pindex.SourceName,
+			SourceUUID: pindex.SourceUUID,
+			DiskSize:   -1,
+			Feeds:      destFeeds[pindex.Dest],
+		}
+
+		if pindex.SourcePartitions != "" {
+			line.SourcePartitions = strings.Split(pindex.SourcePartitions, ",")
+		}
+
+		if pindex.Path != "" {
+			size, err := cbgt.GetDirectorySize(pindex.Path)
+			if err == nil {
+				line.DiskSize = size
+			}
+		}
+
+		if dlp, ok := pindex.Dest.(cbgt.DestLastProcessed); ok {
+			line.LastProcessed = dlp.LastProcessed()
+		}
+
+		if includeSeqNos {
+			line.SourceSeqs = h.sourcePartitionSeqs(pindex, sourceSeqs)
+		}
+
+		if err := enc.Encode(line); err != nil {
+			log.Warnf("rest_export: pindex: %s, err: %v", pindexName, err)
+			return
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	for _, feedName := range feedNames {
+		feed := feeds[feedName]
+
+		line := &PartitionStateExportFeed{
+			Kind:      "feed",
+			Name:      feedName,
+			IndexName: feed.IndexName(),
+		}
+
+		for partition := range feed.Dests() {
+			line.Partitions = append(line.Partitions, partition)
+		}
+		sort.Strings(line.Partitions)
+
+		var buf bytes.Buffer
+		err := feed.Stats(&buf)
+		if err != nil {
+			line.Error = err.Error()
+		} else if buf.Len() > 0 && json.Valid(buf.Bytes()) {
+			line.Stats = buf.Bytes()
+		}
+
+		if err = enc.Encode(line); err != nil {
+			log.Warnf("rest_export: feed: %s, err: %v", feedName, err)
+			return
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// sourcePartitionSeqs returns the high seqs of the pindex's source
+// partitions, memoized per source.
+func (h *PartitionStateExportHandler) sourcePartitionSeqs(
+	pindex *cbgt.PIndex,
+	memo map[string]map[string]cbgt.UUIDSeq) map[string]cbgt.UUIDSeq {
+	key := pindex.SourceType + "/" + pindex.SourceName + "/" + pindex.SourceUUID
+
+	seqs, exists := memo[key]
+	if !exists {
+		feedType, ok := cbgt.FeedTypes[pindex.SourceType]
+		if ok && feedType.PartitionSeqs != nil {
+			var err error
+			seqs, err = feedType.PartitionSeqs(pindex.SourceType,
+				pindex.SourceName, pindex.SourceUUID, pindex.SourceParams,
+				h.mgr.Server(), h.mgr.Options())
+			if err != nil {
+				log.Warnf("rest_export: source partition seqs,"+
+					" pindex: %s, err: %v", pindex.Name, err)
+			}
+		}
+		memo[key] = seqs
+	}
+
+	if seqs == nil {
+		return nil
+	}
+
+	rv := map[string]cbgt.UUIDSeq{}
+	for _, partition := range strings.Split(pindex.SourcePartitions, ",") {
+		if uuidSeq, exists := seqs[partition]; exists {
+			rv[partition] = uuidSeq
+		}
+	}
+
+	return rv
+}
+
+// ---------------------------------------------------
+
+// ReplicaRPOHandler is a REST handler that reports the recovery point
+// objective of the pindex replicas of the cluster.
+type ReplicaRPOHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewReplicaRPOHandler(mgr *cbgt.Manager) *ReplicaRPOHandler {
+	return &ReplicaRPOHandler{mgr: mgr}
+}
+
+func (h *ReplicaRPOHandler) RESTOpts(opts map[string]string) {
+	opts["param: indexName"] =
+		"optional, string, URL query parameter\n\n" +
+			"Restricts the report to the given index."
+}
+
+func (h *ReplicaRPOHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	report, err := h.mgr.ReplicaRPO(req.FormValue("indexName"))
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_export: ReplicaRPO,"+
+			" err: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status string `json:"status"`
+		*cbgt.ReplicaRPOReport
+	}{
+		Status:           "ok",
+		ReplicaRPOReport: report,
+	})
+}
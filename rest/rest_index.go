@@ -9,12 +9,16 @@
 package rest
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -40,6 +44,13 @@ func NewListIndexHandler(mgr *cbgt.Manager) *ListIndexHandler {
 	return &ListIndexHandler{mgr: mgr}
 }
 
+func (h *ListIndexHandler) RESTOpts(opts map[string]string) {
+	opts["param: label"] =
+		"optional, string, URL query parameter\n\n" +
+			"A label selector of the form k1=v1,k2!=v2,k3 that" +
+			" filters the listed index definitions by their labels."
+}
+
 func (h *ListIndexHandler) ServeHTTP(
 	w http.ResponseWriter, req *http.Request) {
 	scopedPrefix := scopedIndexPrefix(req)
@@ -68,6 +79,24 @@ func (h *ListIndexHandler) ServeHTTP(
 
 			indexDefs = &out
 		}
+
+		if labelStr := req.FormValue("label"); labelStr != "" {
+			sel, err := cbgt.ParseLabelSelector(labelStr)
+			if err != nil {
+				ShowError(w, req, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			out := *indexDefs
+			out.IndexDefs = map[string]*cbgt.IndexDef{}
+			for k, def := range indexDefs.IndexDefs {
+				if sel.Matches(def.Labels) {
+					out.IndexDefs[k] = def
+				}
+			}
+
+			indexDefs = &out
+		}
 	}
 
 	rv := struct {
@@ -82,6 +111,70 @@ func (h *ListIndexHandler) ServeHTTP(
 
 // ---------------------------------------------------
 
+// IndexLabelsHandler is a REST handler that replaces the labels of an
+// index.
+type IndexLabelsHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewIndexLabelsHandler(mgr *cbgt.Manager) *IndexLabelsHandler {
+	return &IndexLabelsHandler{mgr: mgr}
+}
+
+func (h *IndexLabelsHandler) RESTOpts(opts map[string]string) {
+	opts["param: indexName"] =
+		"required, string, URL path parameter\n\n" +
+			"The name of the index whose labels will be replaced."
+	opts["param: indexUUID"] =
+		"optional, string, form parameter"
+	opts["request body"] =
+		"JSON object of string key/value pairs, where an empty object" +
+			" removes all the labels"
+}
+
+func (h *IndexLabelsHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	indexName := IndexNameLookup(req)
+	if indexName == "" {
+		ShowError(w, req, "index name is required", http.StatusBadRequest)
+		return
+	}
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: IndexLabels,"+
+			" could not read request body, err: %v", err),
+			http.StatusBadRequest)
+		return
+	}
+
+	var labels map[string]string
+	err = cbgt.UnmarshalJSON(requestBody, &labels)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: IndexLabels,"+
+			" could not unmarshal labels, err: %v", err),
+			http.StatusBadRequest)
+		return
+	}
+
+	err = h.mgr.SetIndexLabels(indexName, req.FormValue("indexUUID"), labels)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: IndexLabels,"+
+			" indexName: %s, err: %v", indexName, err),
+			http.StatusBadRequest)
+		return
+	}
+
+	rv := struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	}
+	MustEncode(w, rv)
+}
+
+// ---------------------------------------------------
+
 // ListIndexesForSourceHandler is a REST handler to list all index names for
 // the provided sourceName.
 type ListIndexesForSourceHandler struct {
@@ -353,7 +446,30 @@ func (h *QueryHandler) ServeHTTP(
 		return
 	}
 
-	err = pindexImplType.Query(h.mgr, indexName, indexUUID, requestBody, w)
+	// Only the queries of the clients are mirrored, rather than the
+	// scatter/gather queries of the pindexes.
+	var mirror *cbgt.QueryMirrorDef
+	if req.Header.Get(CLUSTER_ACTION) == "" {
+		mirror = h.mgr.SampleQueryMirror(indexName)
+	}
+
+	var mirrorCapture *queryMirrorCapture
+	var qw http.ResponseWriter = w
+	if mirror != nil && mirror.Compare {
+		mirrorCapture = &queryMirrorCapture{ResponseWriter: w}
+		qw = mirrorCapture
+	}
+
+	err = pindexImplType.Query(h.mgr, indexName, indexUUID, requestBody, qw)
+
+	if mirror != nil && err == nil {
+		var sourceResp []byte
+		if mirrorCapture != nil && !mirrorCapture.overflow {
+			sourceResp = mirrorCapture.buf.Bytes()
+		}
+		h.mgr.MirrorQuery(mirror, requestBody, sourceResp,
+			time.Since(startTime))
+	}
 
 	// update the total client queries statistics.
 	var focusStats *RESTFocusStats
@@ -379,10 +495,16 @@ func (h *QueryHandler) ServeHTTP(
 			d := time.Since(startTime)
 			if d > h.slowQueryLogTimeout {
 
-				creds, err2 := cbauth.AuthWebCreds(req)
 				var username string
-				if err2 == nil {
-					username = creds.Name()
+				var err2 error
+				if id := AuthIdentityFromRequest(req); id != nil {
+					username = id.User
+				} else {
+					var creds cbauth.Creds
+					creds, err2 = cbauth.AuthWebCreds(req)
+					if err2 == nil {
+						username = creds.Name()
+					}
 				}
 
 				reqStr := string(requestBody)
@@ -430,6 +552,10 @@ func (h *QueryHandler) ServeHTTP(
 			return
 		}
 
+		if showQueryThrottledError(err, w) {
+			return
+		}
+
 		status := http.StatusBadRequest
 		if err == ErrorQueryReqRejected {
 			status = http.StatusTooManyRequests
@@ -489,6 +615,12 @@ func (h *IndexControlHandler) ServeHTTP(
 		err = h.mgr.IndexControl(indexName, indexUUID, "", op, "")
 	} else if h.control == "planFreeze" {
 		err = h.mgr.IndexControl(indexName, indexUUID, "", "", op)
+	} else if h.control == "ingest" {
+		if op == "pause" {
+			err = h.mgr.PauseIndexIngest(indexName, indexUUID)
+		} else {
+			err = h.mgr.ResumeIndexIngest(indexName, indexUUID)
+		}
 	}
 	if err != nil {
 		ShowError(w, req, fmt.Sprintf("rest_index: IndexControl,"+
@@ -507,6 +639,76 @@ func (h *IndexControlHandler) ServeHTTP(
 
 // ---------------------------------------------------
 
+// LabelIndexControlHandler is a REST handler that applies an index
+// control (e.g., ingestControl pause) to all the indexes that match a
+// label selector.
+type LabelIndexControlHandler struct {
+	mgr        *cbgt.Manager
+	control    string
+	allowedOps map[string]bool
+}
+
+func NewLabelIndexControlHandler(mgr *cbgt.Manager, control string,
+	allowedOps map[string]bool) *LabelIndexControlHandler {
+	return &LabelIndexControlHandler{
+		mgr:        mgr,
+		control:    control,
+		allowedOps: allowedOps,
+	}
+}
+
+func (h *LabelIndexControlHandler) RESTOpts(opts map[string]string) {
+	opts["param: label"] =
+		"required, string, URL query parameter\n\n" +
+			"A label selector of the form k1=v1,k2!=v2,k3 that" +
+			" selects the indexes whose control values will be modified."
+}
+
+func (h *LabelIndexControlHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	sel, err := cbgt.ParseLabelSelector(req.FormValue("label"))
+	if err != nil || len(sel) == 0 {
+		ShowError(w, req, fmt.Sprintf("rest_index: LabelIndexControl,"+
+			" a valid label selector is required, err: %v", err),
+			http.StatusBadRequest)
+		return
+	}
+
+	op := RequestVariableLookup(req, "op")
+	if !h.allowedOps[op] {
+		ShowError(w, req, fmt.Sprintf("rest_index: LabelIndexControl,"+
+			" error: unsupported op: %s", op), http.StatusBadRequest)
+		return
+	}
+
+	var indexNames []string
+	err = fmt.Errorf("rest_index: unknown op")
+	if h.control == "read" {
+		indexNames, err = h.mgr.IndexControlByLabels(sel, op, "", "")
+	} else if h.control == "write" {
+		indexNames, err = h.mgr.IndexControlByLabels(sel, "", op, "")
+	} else if h.control == "planFreeze" {
+		indexNames, err = h.mgr.IndexControlByLabels(sel, "", "", op)
+	}
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: LabelIndexControl,"+
+			" control: %s, could not op: %s, indexes done: %v, err: %v",
+			h.control, op, indexNames, err), http.StatusBadRequest)
+		return
+	}
+
+	rv := struct {
+		Status  string   `json:"status"`
+		Indexes []string `json:"indexes"`
+	}{
+		Status:  "ok",
+		Indexes: indexNames,
+	}
+	MustEncode(w, rv)
+}
+
+// ---------------------------------------------------
+
 // TaskRequestHandler is a REST handler for submitting a task
 // request on an index.
 type TaskRequestHandler struct {
@@ -548,6 +750,14 @@ func (h *TaskRequestHandler) ServeHTTP(
 		return
 	}
 
+	// A compaction is an activity of the node for as long as its
+	// submission runs.
+	var taskReq cbgt.TaskRequest
+	if json.Unmarshal(requestBody, &taskReq) == nil && taskReq.Op == "compact" {
+		defer h.mgr.StartActivity(cbgt.ACTIVITY_COMPACTION,
+			indexName, indexName, "")()
+	}
+
 	var rv *cbgt.TaskRequestStatus
 	rv, err = pindexImplType.SubmitTaskRequest(h.mgr, indexName, indexUUID, requestBody)
 	if err != nil {
@@ -728,6 +938,12 @@ func (h *QueryPIndexHandler) ServeHTTP(
 		return
 	}
 
+	err := h.mgr.AdmitPIndexQuery(pindex)
+	if err != nil {
+		showQueryThrottledError(err, w)
+		return
+	}
+
 	requestBody, err := io.ReadAll(req.Body)
 	if err != nil {
 		ShowErrorBody(w, nil, fmt.Sprintf("rest_index: QueryPIndex,"+
@@ -758,6 +974,350 @@ func (h *QueryPIndexHandler) ServeHTTP(
 	}
 }
 
+// ---------------------------------------------------
+
+// HotStatePIndexHandler is a REST handler for retrieving the hot state
+// of a pindex, for the warm cache phase of a partition move.
+type HotStatePIndexHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewHotStatePIndexHandler(mgr *cbgt.Manager) *HotStatePIndexHandler {
+	return &HotStatePIndexHandler{mgr: mgr}
+}
+
+func (h *HotStatePIndexHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	pindexName := PIndexNameLookup(req)
+	if pindexName == "" {
+		ShowError(w, req, "rest_index: pindex name is required", http.StatusBadRequest)
+		return
+	}
+
+	hotState, err := h.mgr.PIndexHotState(pindexName)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: HotStatePIndex,"+
+			" pindexName: %s, err: %v", pindexName, err),
+			pindexWarmErrStatus(err))
+		return
+	}
+
+	rv := struct {
+		Status   string `json:"status"`
+		HotState []byte `json:"hotState"`
+	}{
+		Status:   "ok",
+		HotState: hotState,
+	}
+	MustEncode(w, rv)
+}
+
+// MutationSamplesPIndexHandler is a REST handler that streams, as
+// newline-delimited JSON, a sample of the mutations that the feeds
+// deliver to a local pindex, for debugging a Dest's behavior.
+type MutationSamplesPIndexHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewMutationSamplesPIndexHandler(
+	mgr *cbgt.Manager) *MutationSamplesPIndexHandler {
+	return &MutationSamplesPIndexHandler{mgr: mgr}
+}
+
+func (h *MutationSamplesPIndexHandler) RESTOpts(opts map[string]string) {
+	opts["param: durationSecs"] =
+		"optional, integer, the seconds to sample for, which is" +
+			" capped by the maximum sampling duration"
+	opts["param: rate"] =
+		"optional, number, the fraction from 0 to 1 of the mutations" +
+			" to sample, where 0 means all of them"
+	opts["param: keyPrefix"] =
+		"optional, string, samples only the keys with the prefix"
+	opts["param: partition"] =
+		"optional, string, samples only the source partition"
+	opts["param: values"] =
+		"optional, bool, when true, includes the (truncated) values"
+	opts["param: max"] =
+		"optional, integer, stops after that many samples"
+}
+
+func (h *MutationSamplesPIndexHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	pindexName := PIndexNameLookup(req)
+	if pindexName == "" {
+		ShowError(w, req, "rest_index: pindex name is required", http.StatusBadRequest)
+		return
+	}
+
+	q := req.URL.Query()
+
+	options := cbgt.MutationSamplerOptions{
+		KeyPrefix:     q.Get("keyPrefix"),
+		Partition:     q.Get("partition"),
+		IncludeValues: q.Get("values") == "true",
+	}
+
+	var err error
+	if v := q.Get("durationSecs"); v != "" {
+		var secs int
+		secs, err = strconv.Atoi(v)
+		options.Duration = time.Duration(secs) * time.Second
+	}
+	if v := q.Get("rate"); v != "" && err == nil {
+		options.Rate, err = strconv.ParseFloat(v, 64)
+	}
+	if v := q.Get("max"); v != "" && err == nil {
+		options.MaxSamples, err = strconv.Atoi(v)
+	}
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: MutationSamples,"+
+			" pindexName: %s, err: %v", pindexName, err),
+			http.StatusBadRequest)
+		return
+	}
+
+	sampler, err := h.mgr.StartMutationSampler(pindexName, options)
+	if err != nil {
+		code := http.StatusBadRequest
+		if err == cbgt.ErrMutationSamplingDisabled {
+			code = http.StatusForbidden
+		}
+		ShowError(w, req, fmt.Sprintf("rest_index: MutationSamples,"+
+			" pindexName: %s, err: %v", pindexName, err), code)
+		return
+	}
+	defer sampler.Stop()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	enc := json.NewEncoder(w)
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+
+		case sample, ok := <-sampler.Samples():
+			if !ok {
+				return
+			}
+
+			if err = enc.Encode(sample); err != nil {
+				log.Warnf("rest_index: MutationSamples,"+
+					" pindexName: %s, err: %v", pindexName, err)
+				return
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// WarmPIndexHandler is a REST handler for pre-warming a pindex from
+// the hot state of another copy of the pindex, where the request body
+// is the hot state.
+type WarmPIndexHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewWarmPIndexHandler(mgr *cbgt.Manager) *WarmPIndexHandler {
+	return &WarmPIndexHandler{mgr: mgr}
+}
+
+func (h *WarmPIndexHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	pindexName := PIndexNameLookup(req)
+	if pindexName == "" {
+		ShowError(w, req, "rest_index: pindex name is required", http.StatusBadRequest)
+		return
+	}
+
+	hotState, err := io.ReadAll(req.Body)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: WarmPIndex,"+
+			" could not read request body, pindexName: %s, err: %v",
+			pindexName, err), http.StatusBadRequest)
+		return
+	}
+
+	err = h.mgr.WarmPIndex(pindexName, hotState)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: WarmPIndex,"+
+			" pindexName: %s, err: %v", pindexName, err),
+			pindexWarmErrStatus(err))
+		return
+	}
+
+	MustEncode(w, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
+
+// PIndexPlacementHandler is a REST handler that explains the placement
+// of a pindex onto its nodes.
+type PIndexPlacementHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewPIndexPlacementHandler(mgr *cbgt.Manager) *PIndexPlacementHandler {
+	return &PIndexPlacementHandler{mgr: mgr}
+}
+
+func (h *PIndexPlacementHandler) RESTOpts(opts map[string]string) {
+	opts["param: pindexName"] =
+		"required, string, URL path parameter\n\n" +
+			"The name of the pindex whose placement is explained."
+	opts["param: history"] =
+		"optional, bool, URL query parameter\n\n" +
+			"When true, all the recorded traces of the pindex are returned."
+}
+
+func (h *PIndexPlacementHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	pindexName := PIndexNameLookup(req)
+	if pindexName == "" {
+		ShowError(w, req, "rest_index: pindex name is required", http.StatusBadRequest)
+		return
+	}
+
+	planPIndexes, _, err := h.mgr.GetPlanPIndexes(true)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: PIndexPlacement,"+
+			" could not get plan, err: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	var planPIndex *cbgt.PlanPIndex
+	if planPIndexes != nil {
+		planPIndex = planPIndexes.PlanPIndexes[pindexName]
+	}
+
+	trace, stale := cbgt.ExplainPlacement(pindexName, planPIndex)
+	if trace == nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: PIndexPlacement,"+
+			" no placement trace of pindex: %s on this node, which"+
+			" may not have planned it since it started", pindexName),
+			http.StatusNotFound)
+		return
+	}
+
+	rv := struct {
+		Status  string                          `json:"status"`
+		Trace   *cbgt.PlacementTrace            `json:"trace"`
+		Stale   bool                            `json:"stale,omitempty"`
+		Nodes   map[string]*cbgt.PlanPIndexNode `json:"nodes,omitempty"`
+		History []*cbgt.PlacementTrace          `json:"history,omitempty"`
+	}{
+		Status: "ok",
+		Trace:  trace,
+		Stale:  stale,
+	}
+	if planPIndex != nil {
+		rv.Nodes = planPIndex.Nodes
+	}
+	if req.FormValue("history") == "true" {
+		rv.History = cbgt.PlacementTraces(pindexName)
+	}
+
+	MustEncode(w, rv)
+}
+
+func pindexWarmErrStatus(err error) int {
+	if errors.Is(err, cbgt.ErrPIndexWarmNotSupported) {
+		return http.StatusNotImplemented
+	}
+	return http.StatusBadRequest
+}
+
+// ColdIndexesHandler is a REST handler for listing the cold indexes
+// of the paused buckets, along with the stats of their local cache.
+type ColdIndexesHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewColdIndexesHandler(mgr *cbgt.Manager) *ColdIndexesHandler {
+	return &ColdIndexesHandler{mgr: mgr}
+}
+
+func (h *ColdIndexesHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	MustEncode(w, struct {
+		Status      string                          `json:"status"`
+		ColdIndexes []*cbgt.ColdIndex               `json:"coldIndexes"`
+		CacheStats  *cbgt.HibernationColdCacheStats `json:"cacheStats,omitempty"`
+	}{
+		Status:      "ok",
+		ColdIndexes: h.mgr.ColdIndexes(),
+		CacheStats:  h.mgr.ColdCacheStats(),
+	})
+}
+
+// QueryColdIndexHandler is a REST handler for querying a cold index of
+// a paused bucket.
+type QueryColdIndexHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewQueryColdIndexHandler(mgr *cbgt.Manager) *QueryColdIndexHandler {
+	return &QueryColdIndexHandler{mgr: mgr}
+}
+
+func (h *QueryColdIndexHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	indexName := IndexNameLookup(req)
+	if indexName == "" {
+		ShowError(w, req, "rest_index: index name is required", http.StatusBadRequest)
+		return
+	}
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: QueryColdIndex,"+
+			" could not read request body, indexName: %s, err: %v",
+			indexName, err), http.StatusBadRequest)
+		return
+	}
+
+	err = h.mgr.QueryColdIndex(indexName, requestBody, w)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, cbgt.ErrHibernationColdQueryNotSupported) {
+			status = http.StatusNotImplemented
+		}
+		ShowError(w, req, fmt.Sprintf("rest_index: QueryColdIndex,"+
+			" indexName: %s, err: %v", indexName, err), status)
+		return
+	}
+}
+
+// showQueryThrottledError responds with a cbgt.QueryThrottledResponse
+// when the err is from a throttled query, so that the query's
+// coordinator can surface it (see cbgt.ParseQueryThrottledResponse).
+func showQueryThrottledError(err error, w http.ResponseWriter) bool {
+	var errQT *cbgt.QueryThrottledError
+	if !errors.As(err, &errQT) {
+		return false
+	}
+
+	retryAfterSecs := int64(math.Ceil(errQT.RetryAfter.Seconds()))
+	if retryAfterSecs < 1 {
+		retryAfterSecs = 1
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfterSecs, 10))
+	w.WriteHeader(http.StatusTooManyRequests)
+	MustEncode(w, cbgt.NewQueryThrottledResponse(errQT))
+	return true
+}
+
 func showConsistencyError(err error, methodName, itemName string,
 	requestBody []byte, w http.ResponseWriter) bool {
 	if errCW, ok := err.(*cbgt.ErrorConsistencyWait); ok {
@@ -876,3 +1436,331 @@ func (h *PIndexLookUpHandler) ServeHTTP(
 	}
 	MustEncode(w, rv)
 }
+
+// ---------------------------------------------------
+
+// HTTPFeedPushHandler is a REST handler that applies a batch of
+// mutations that's pushed to an index whose source type is "http".
+type HTTPFeedPushHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewHTTPFeedPushHandler(mgr *cbgt.Manager) *HTTPFeedPushHandler {
+	return &HTTPFeedPushHandler{mgr: mgr}
+}
+
+func (h *HTTPFeedPushHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	indexName := IndexNameLookup(req)
+	if indexName == "" {
+		ShowError(w, req, "index name is required", http.StatusBadRequest)
+		return
+	}
+
+	feed := h.mgr.HTTPFeedForIndex(indexName)
+	if feed == nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: HTTPFeedPush,"+
+			" no http feed on this node, indexName: %s", indexName),
+			http.StatusNotFound)
+		return
+	}
+
+	err := feed.Authorize(req.Header.Get(cbgt.HTTPFeedTokenHeader))
+	if err != nil {
+		ShowError(w, req, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: HTTPFeedPush,"+
+			" could not read request body, indexName: %s", indexName),
+			http.StatusBadRequest)
+		return
+	}
+
+	var batch cbgt.HTTPMutationBatch
+	err = cbgt.UnmarshalJSON(requestBody, &batch)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: HTTPFeedPush,"+
+			" could not parse batch, indexName: %s, err: %v",
+			indexName, err), http.StatusBadRequest)
+		return
+	}
+
+	result, err := feed.Apply(&batch)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, cbgt.ErrHTTPFeedClosed) {
+			code = http.StatusServiceUnavailable
+		}
+		ShowError(w, req, fmt.Sprintf("rest_index: HTTPFeedPush,"+
+			" indexName: %s, err: %v", indexName, err), code)
+		return
+	}
+
+	rv := struct {
+		Status string `json:"status"`
+		*cbgt.HTTPMutationBatchResult
+	}{
+		Status:                  "ok",
+		HTTPMutationBatchResult: result,
+	}
+	MustEncode(w, rv)
+}
+
+// ---------------------------------------------------
+
+// queryMirrorCapture captures the response of a query of an index that
+// is mirrored, up to the cbgt.QueryMirrorMaxCompareBytes, to compare
+// it with the response of the mirror's target index.
+type queryMirrorCapture struct {
+	http.ResponseWriter
+
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (c *queryMirrorCapture) Write(p []byte) (int, error) {
+	if !c.overflow {
+		if c.buf.Len()+len(p) > cbgt.QueryMirrorMaxCompareBytes {
+			c.overflow = true
+			c.buf = bytes.Buffer{}
+		} else {
+			c.buf.Write(p)
+		}
+	}
+
+	return c.ResponseWriter.Write(p)
+}
+
+func (c *queryMirrorCapture) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// QueryMirrorsHandler is a REST handler that returns the query mirrors
+// and the stats of their mirrored queries on this node.
+type QueryMirrorsHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewQueryMirrorsHandler(mgr *cbgt.Manager) *QueryMirrorsHandler {
+	return &QueryMirrorsHandler{mgr: mgr}
+}
+
+func (h *QueryMirrorsHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	mirrors, _, err := cbgt.CfgGetQueryMirrors(h.mgr.Cfg())
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: QueryMirrors,"+
+			" err: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rv := struct {
+		Status  string                            `json:"status"`
+		Mirrors map[string]*cbgt.QueryMirrorDef   `json:"mirrors"`
+		Stats   map[string]*cbgt.QueryMirrorStats `json:"stats"`
+	}{
+		Status:  "ok",
+		Mirrors: map[string]*cbgt.QueryMirrorDef{},
+		Stats:   h.mgr.QueryMirrorStatsSnapshot(),
+	}
+	if mirrors != nil {
+		rv.Mirrors = mirrors.Mirrors
+	}
+
+	MustEncode(w, rv)
+}
+
+// QueryMirrorHandler is a REST handler that creates, replaces or
+// deletes the query mirror of an index.
+type QueryMirrorHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewQueryMirrorHandler(mgr *cbgt.Manager) *QueryMirrorHandler {
+	return &QueryMirrorHandler{mgr: mgr}
+}
+
+func (h *QueryMirrorHandler) RESTOpts(opts map[string]string) {
+	opts["param: indexName"] =
+		"required, string, URL path parameter\n\n" +
+			"The name of the source index, whose queries are mirrored."
+	opts["request body"] =
+		"For a PUT, a JSON query mirror with the targetIndex, the" +
+			" fraction of the queries to be mirrored, from 0 to 1, and" +
+			" whether to compare the responses"
+}
+
+func (h *QueryMirrorHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	indexName := IndexNameLookup(req)
+	if indexName == "" {
+		ShowError(w, req, "index name is required", http.StatusBadRequest)
+		return
+	}
+
+	var mirror *cbgt.QueryMirrorDef
+	if req.Method != "DELETE" {
+		requestBody, err := io.ReadAll(req.Body)
+		if err != nil {
+			ShowError(w, req, fmt.Sprintf("rest_index: QueryMirror,"+
+				" could not read request body, err: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+
+		mirror = &cbgt.QueryMirrorDef{}
+		err = cbgt.UnmarshalJSON(requestBody, mirror)
+		if err != nil {
+			ShowError(w, req, fmt.Sprintf("rest_index: QueryMirror,"+
+				" could not unmarshal mirror, err: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+
+		for _, name := range []string{indexName, mirror.TargetIndex} {
+			indexDef, _, err := h.mgr.GetIndexDef(name, false)
+			if err != nil || indexDef == nil {
+				ShowError(w, req, fmt.Sprintf("rest_index: QueryMirror,"+
+					" no index: %q, err: %v", name, err),
+					http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	err := cbgt.CfgSetQueryMirror(h.mgr.Cfg(), h.mgr.Version(),
+		indexName, mirror)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: QueryMirror,"+
+			" indexName: %s, err: %v", indexName, err),
+			http.StatusBadRequest)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
+
+// ---------------------------------------------------
+
+// DeadLettersPIndexHandler is a REST handler that lists the dead
+// letters of a local pindex, which are the mutations that its Dest
+// failed to apply.
+type DeadLettersPIndexHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewDeadLettersPIndexHandler(
+	mgr *cbgt.Manager) *DeadLettersPIndexHandler {
+	return &DeadLettersPIndexHandler{mgr: mgr}
+}
+
+func (h *DeadLettersPIndexHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	pindexName := PIndexNameLookup(req)
+	if pindexName == "" {
+		ShowError(w, req, "rest_index: pindex name is required", http.StatusBadRequest)
+		return
+	}
+
+	deadLetters, err := h.mgr.DeadLetters(pindexName)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: DeadLetters,"+
+			" pindexName: %s, err: %v", pindexName, err),
+			http.StatusBadRequest)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status      string             `json:"status"`
+		DeadLetters []*cbgt.DeadLetter `json:"deadLetters"`
+	}{
+		Status:      "ok",
+		DeadLetters: deadLetters,
+	})
+}
+
+// DeadLettersControlPIndexHandler is a REST handler that retries or
+// purges the dead letters of a local pindex.
+type DeadLettersControlPIndexHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewDeadLettersControlPIndexHandler(
+	mgr *cbgt.Manager) *DeadLettersControlPIndexHandler {
+	return &DeadLettersControlPIndexHandler{mgr: mgr}
+}
+
+func (h *DeadLettersControlPIndexHandler) RESTOpts(opts map[string]string) {
+	opts["param: op"] =
+		"required, string, URL path parameter\n\n" +
+			"Either \"retry\" or \"purge\"."
+	opts["request body"] =
+		"An optional JSON object with the ids of the dead letters," +
+			` such as {"ids":[1,2]}, where no ids means all of them.`
+}
+
+func (h *DeadLettersControlPIndexHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	pindexName := PIndexNameLookup(req)
+	if pindexName == "" {
+		ShowError(w, req, "rest_index: pindex name is required", http.StatusBadRequest)
+		return
+	}
+
+	op := RequestVariableLookup(req, "op")
+	if op != "retry" && op != "purge" {
+		ShowError(w, req, fmt.Sprintf("rest_index: DeadLettersControl,"+
+			" error: unsupported op: %s", op), http.StatusBadRequest)
+		return
+	}
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: DeadLettersControl,"+
+			" could not read request body, pindexName: %s", pindexName),
+			http.StatusBadRequest)
+		return
+	}
+
+	var params struct {
+		IDs []uint64 `json:"ids"`
+	}
+	if len(requestBody) > 0 {
+		err = cbgt.UnmarshalJSON(requestBody, &params)
+		if err != nil {
+			ShowError(w, req, fmt.Sprintf("rest_index: DeadLettersControl,"+
+				" could not parse request body, pindexName: %s, err: %v",
+				pindexName, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	rv := struct {
+		Status  string `json:"status"`
+		Retried int    `json:"retried,omitempty"`
+		Failed  int    `json:"failed,omitempty"`
+		Purged  int    `json:"purged,omitempty"`
+	}{Status: "ok"}
+
+	if op == "retry" {
+		rv.Retried, rv.Failed, err =
+			h.mgr.RetryDeadLetters(pindexName, params.IDs)
+	} else {
+		rv.Purged, err = h.mgr.PurgeDeadLetters(pindexName, params.IDs)
+	}
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_index: DeadLettersControl,"+
+			" pindexName: %s, could not op: %s, err: %v", pindexName, op, err),
+			http.StatusBadRequest)
+		return
+	}
+
+	MustEncode(w, rv)
+}
@@ -11,10 +11,13 @@ package rest
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/couchbase/cbgt"
 	log "github.com/couchbase/clog"
@@ -34,7 +37,10 @@ func NewStatsHandler(mgr *cbgt.Manager) *StatsHandler {
 
 var statsFeedsPrefix = []byte("\"feeds\":{")
 var statsPIndexesPrefix = []byte("\"pindexes\":{")
+var statsFeedLagPrefix = []byte(",\"feedLag\":{")
 var statsManagerPrefix = []byte(",\"manager\":")
+var statsCfgPrefix = []byte(",\"cfg\":")
+var statsHibernationChecksumErrorsPrefix = []byte(",\"hibernationChecksumErrors\":")
 var statsNamePrefix = []byte("\"")
 var statsNameSuffix = []byte("\":")
 
@@ -226,6 +232,36 @@ func WriteManagerStatsJSON(mgr *cbgt.Manager, w io.Writer,
 	}
 	w.Write(cbgt.JsonCloseBrace)
 
+	first = true
+	w.Write(statsFeedLagPrefix)
+	for _, feedName := range feedNames {
+		if indexName == "" || indexName == feeds[feedName].IndexName() {
+			lr, ok := feeds[feedName].(cbgt.FeedLagReporter)
+			if !ok {
+				continue
+			}
+			lag, err := lr.Lag()
+			if err != nil {
+				log.Warnf("rest_manage: feed lag, feed: %s, err: %v",
+					feedName, err)
+				continue
+			}
+			lagJSON, err := cbgt.MarshalJSON(lag)
+			if err != nil {
+				continue
+			}
+			if !first {
+				w.Write(cbgt.JsonComma)
+			}
+			first = false
+			w.Write(statsNamePrefix)
+			w.Write([]byte(feedName))
+			w.Write(statsNameSuffix)
+			w.Write(lagJSON)
+		}
+	}
+	w.Write(cbgt.JsonCloseBrace)
+
 	if indexName == "" {
 		w.Write(statsManagerPrefix)
 		var mgrStats cbgt.ManagerStats
@@ -236,6 +272,16 @@ func WriteManagerStatsJSON(mgr *cbgt.Manager, w io.Writer,
 		} else {
 			w.Write(cbgt.JsonNULL)
 		}
+
+		w.Write(statsCfgPrefix)
+		var cfgStats cbgt.CfgStats
+		mgr.CfgStatsCopyTo(&cfgStats)
+		cfgStatsJSON, err := cbgt.MarshalJSON(&cfgStats)
+		if err == nil && len(cfgStatsJSON) > 0 {
+			w.Write(cfgStatsJSON)
+		} else {
+			w.Write(cbgt.JsonNULL)
+		}
 	}
 
 	w.Write(cbgt.JsonCloseBrace)
@@ -310,6 +356,17 @@ func writePartitionStatsJSON(mgr *cbgt.Manager, w http.ResponseWriter,
 	}
 
 	w.Write(cbgt.JsonCloseBrace)
+
+	// Checksum failures of hibernated downloads, which are reported
+	// to the node that is monitoring the resume.
+	if errs := mgr.HibernationChecksumErrors(); len(errs) > 0 {
+		buf, err := cbgt.MarshalJSON(errs)
+		if err == nil {
+			w.Write(statsHibernationChecksumErrorsPrefix)
+			w.Write(buf)
+		}
+	}
+
 	w.Write(cbgt.JsonCloseBrace)
 }
 
@@ -412,6 +469,218 @@ func (h *CfgGetHandler) ServeHTTP(
 
 // ---------------------------------------------------
 
+// CfgHistoryHandler is a REST handler that returns the history of the
+// mutations of the Cfg's keys.
+type CfgHistoryHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewCfgHistoryHandler(mgr *cbgt.Manager) *CfgHistoryHandler {
+	return &CfgHistoryHandler{mgr: mgr}
+}
+
+func (h *CfgHistoryHandler) RESTOpts(opts map[string]string) {
+	opts["param: key"] =
+		"optional, string, URL query parameter\n\n" +
+			"When given, only the history of this Cfg key is returned."
+}
+
+func (h *CfgHistoryHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	history := h.mgr.CfgHistory()
+	if history == nil {
+		ShowError(w, req, "rest_manage: cfg history is not enabled",
+			http.StatusBadRequest)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status  string                  `json:"status"`
+		Entries []*cbgt.CfgHistoryEntry `json:"entries"`
+	}{
+		Status:  "ok",
+		Entries: history.Entries(req.FormValue("key")),
+	})
+}
+
+// ---------------------------------------------------
+
+// CfgRollbackHandler is a REST handler that rolls a Cfg key back to a
+// previous revision from the Cfg's history.
+type CfgRollbackHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewCfgRollbackHandler(mgr *cbgt.Manager) *CfgRollbackHandler {
+	return &CfgRollbackHandler{mgr: mgr}
+}
+
+func (h *CfgRollbackHandler) RESTOpts(opts map[string]string) {
+	opts["param: key"] =
+		"required, string, URL query parameter\n\n" +
+			"The Cfg key to roll back."
+	opts["param: seq"] =
+		"required, integer, URL query parameter\n\n" +
+			"The seq of the history entry of the revision to roll back to."
+}
+
+func (h *CfgRollbackHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	history := h.mgr.CfgHistory()
+	if history == nil {
+		ShowError(w, req, "rest_manage: cfg history is not enabled",
+			http.StatusBadRequest)
+		return
+	}
+
+	key := req.FormValue("key")
+	if key == "" {
+		ShowError(w, req, "rest_manage: key is required",
+			http.StatusBadRequest)
+		return
+	}
+
+	seq, err := strconv.ParseUint(req.FormValue("seq"), 10, 64)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: invalid seq: %q",
+			req.FormValue("seq")), http.StatusBadRequest)
+		return
+	}
+
+	actor := "rest"
+	if identity := AuthIdentityFromRequest(req); identity != nil &&
+		identity.User != "" {
+		actor = identity.User
+	}
+
+	entry, err := history.Rollback(key, seq, actor)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: Rollback,"+
+			" key: %s, seq: %d, err: %v", key, seq, err),
+			http.StatusBadRequest)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status string                `json:"status"`
+		Entry  *cbgt.CfgHistoryEntry `json:"entry"`
+	}{
+		Status: "ok",
+		Entry:  entry,
+	})
+}
+
+// ---------------------------------------------------
+
+// CfgExportHandler is a REST handler that exports the Cfg's keys into
+// a CfgArchive.
+type CfgExportHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewCfgExportHandler(mgr *cbgt.Manager) *CfgExportHandler {
+	return &CfgExportHandler{mgr: mgr}
+}
+
+func (h *CfgExportHandler) RESTOpts(opts map[string]string) {
+	opts["param: keys"] =
+		"optional, string, URL query parameter\n\n" +
+			"Comma separated Cfg keys to export, which default to the" +
+			" cluster's options, index definitions, node definitions" +
+			" and plan."
+}
+
+func (h *CfgExportHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	var keys []string
+	if v := req.FormValue("keys"); v != "" {
+		keys = strings.Split(v, ",")
+	}
+
+	archive, err := cbgt.CfgExport(h.mgr.Cfg(), keys)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: CfgExport,"+
+			" err: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	MustEncode(w, archive)
+}
+
+// ---------------------------------------------------
+
+// CfgImportHandler is a REST handler that restores the Cfg's keys from
+// a CfgArchive in the request body.
+type CfgImportHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewCfgImportHandler(mgr *cbgt.Manager) *CfgImportHandler {
+	return &CfgImportHandler{mgr: mgr}
+}
+
+func (h *CfgImportHandler) RESTOpts(opts map[string]string) {
+	opts["param: keys"] =
+		"optional, string, URL query parameter\n\n" +
+			"Comma separated Cfg keys of the archive to restore," +
+			" which default to all the keys of the archive."
+	opts["param: dryRun"] =
+		"optional, bool, URL query parameter\n\n" +
+			"When true, reports what would be restored without" +
+			" changing the Cfg."
+}
+
+func (h *CfgImportHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	archive, err := cbgt.ReadCfgArchive(req.Body)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: CfgImport,"+
+			" err: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var options cbgt.CfgRestoreOptions
+	if v := req.FormValue("keys"); v != "" {
+		options.Keys = strings.Split(v, ",")
+	}
+	options.DryRun = req.FormValue("dryRun") == "true"
+
+	// The restore is attributed to the REST user when the Cfg keeps a
+	// history.
+	cfg := h.mgr.Cfg()
+	if history := h.mgr.CfgHistory(); history != nil {
+		actor := "rest"
+		if identity := AuthIdentityFromRequest(req); identity != nil &&
+			identity.User != "" {
+			actor = identity.User
+		}
+		cfg = history.WithActor(actor)
+	}
+
+	results, err := cbgt.CfgRestore(cfg, archive, options)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: CfgImport,"+
+			" err: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if !options.DryRun {
+		h.mgr.Kick("cfg import")
+	}
+
+	MustEncode(w, struct {
+		Status  string                   `json:"status"`
+		DryRun  bool                     `json:"dryRun"`
+		Results []*cbgt.CfgRestoreResult `json:"results"`
+	}{
+		Status:  "ok",
+		DryRun:  options.DryRun,
+		Results: results,
+	})
+}
+
+// ---------------------------------------------------
+
 // CfgRefreshHandler is a REST handler that processes a request for
 // the manager/node to refresh its cached snapshot of the Cfg system
 // contents.
@@ -571,7 +840,9 @@ func (h *ManagerHandler) ServeHTTP(
 			"bindHttp":  h.mgr.BindHttp(),
 			"dataDir":   h.mgr.DataDir(),
 			"server":    h.mgr.Server(),
-			"options":   h.mgr.Options(),
+			"options":   cbgt.RedactAuthSecretOptions(h.mgr.Options()),
+
+			"quarantinedPIndexes": h.mgr.QuarantinedPIndexes(),
 		},
 	}
 
@@ -580,6 +851,393 @@ func (h *ManagerHandler) ServeHTTP(
 
 // ---------------------------------------------------
 
+// TopologySnapshotHandler is a REST handler that returns a consistent
+// snapshot of the cluster's topology.
+type TopologySnapshotHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewTopologySnapshotHandler(mgr *cbgt.Manager) *TopologySnapshotHandler {
+	return &TopologySnapshotHandler{mgr: mgr}
+}
+
+func (h *TopologySnapshotHandler) RESTOpts(opts map[string]string) {
+	opts["param: actual"] =
+		"optional, bool, URL query parameter\n\n" +
+			"When false, the actual pindexes of the nodes aren't" +
+			" gathered; defaults to true."
+}
+
+func (h *TopologySnapshotHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	snapshot, err := h.mgr.TopologySnapshot(req.FormValue("actual") != "false")
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: TopologySnapshot,"+
+			" err: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status string `json:"status"`
+		*cbgt.TopologySnapshot
+	}{
+		Status:           "ok",
+		TopologySnapshot: snapshot,
+	})
+}
+
+// ---------------------------------------------------
+
+// ActivitiesHandler is a REST handler that returns the heavyweight
+// operations that are currently running across the cluster.
+type ActivitiesHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewActivitiesHandler(mgr *cbgt.Manager) *ActivitiesHandler {
+	return &ActivitiesHandler{mgr: mgr}
+}
+
+func (h *ActivitiesHandler) RESTOpts(opts map[string]string) {
+	opts["param: local"] =
+		"optional, bool, URL query parameter\n\n" +
+			"When true, only the activities of this node are returned."
+}
+
+func (h *ActivitiesHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	if req.FormValue("local") == "true" {
+		MustEncode(w, struct {
+			Status     string               `json:"status"`
+			Activities *cbgt.NodeActivities `json:"activities"`
+		}{
+			Status:     "ok",
+			Activities: h.mgr.LocalActivities(),
+		})
+		return
+	}
+
+	activities, err := h.mgr.ClusterActivities()
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: ClusterActivities,"+
+			" err: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status string `json:"status"`
+		*cbgt.ClusterActivities
+	}{
+		Status:            "ok",
+		ClusterActivities: activities,
+	})
+}
+
+// ---------------------------------------------------
+
+// ChangeReportsHandler is a REST handler that returns the node's
+// recent change reports, which describe what the node's planner and
+// janitor changed and why.
+type ChangeReportsHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewChangeReportsHandler(mgr *cbgt.Manager) *ChangeReportsHandler {
+	return &ChangeReportsHandler{mgr: mgr}
+}
+
+func (h *ChangeReportsHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	MustEncode(w, map[string]interface{}{
+		"status":        "ok",
+		"changeReports": h.mgr.ChangeReports(),
+	})
+}
+
+// ---------------------------------------------------
+
+// ShadowCopiesHandler is a REST handler that returns the statuses of
+// the node's shadow copies, including their lag.
+type ShadowCopiesHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewShadowCopiesHandler(mgr *cbgt.Manager) *ShadowCopiesHandler {
+	return &ShadowCopiesHandler{mgr: mgr}
+}
+
+func (h *ShadowCopiesHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	MustEncode(w, map[string]interface{}{
+		"status":       "ok",
+		"shadowCopies": h.mgr.ShadowCopies(),
+	})
+}
+
+// ShadowCopyHandler is a REST handler that creates, replaces or
+// deletes a shadow copy.
+type ShadowCopyHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewShadowCopyHandler(mgr *cbgt.Manager) *ShadowCopyHandler {
+	return &ShadowCopyHandler{mgr: mgr}
+}
+
+func (h *ShadowCopyHandler) RESTOpts(opts map[string]string) {
+	opts["param: name"] =
+		"required, string, URL path parameter\n\n" +
+			"The name of the shadow copy."
+	opts["request body"] =
+		"For a PUT, a JSON shadow copy definition with the indexName," +
+			" role (\"source\" or \"target\"), remotePath and optional" +
+			" region, intervalSecs and fullEvery"
+}
+
+func (h *ShadowCopyHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	name := RequestVariableLookup(req, "name")
+	if name == "" {
+		ShowError(w, req, "shadow copy name is required",
+			http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if req.Method == "DELETE" {
+		err = h.mgr.DeleteShadowCopy(name)
+	} else {
+		var requestBody []byte
+		requestBody, err = io.ReadAll(req.Body)
+		if err != nil {
+			ShowError(w, req, fmt.Sprintf("rest_manage: ShadowCopy,"+
+				" could not read request body, err: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+
+		def := &cbgt.ShadowCopyDef{}
+		err = cbgt.UnmarshalJSON(requestBody, def)
+		if err != nil {
+			ShowError(w, req, fmt.Sprintf("rest_manage: ShadowCopy,"+
+				" could not unmarshal definition, err: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+		def.Name = name
+
+		err = h.mgr.SetShadowCopy(def)
+	}
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: ShadowCopy,"+
+			" name: %s, err: %v", name, err),
+			http.StatusBadRequest)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
+
+// ---------------------------------------------------
+
+// CompactionTasksHandler is a REST handler that returns the latest
+// scheduled compactions of the node's pindexes, including their
+// progress.
+type CompactionTasksHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewCompactionTasksHandler(mgr *cbgt.Manager) *CompactionTasksHandler {
+	return &CompactionTasksHandler{mgr: mgr}
+}
+
+func (h *CompactionTasksHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	MustEncode(w, map[string]interface{}{
+		"status":      "ok",
+		"compactions": h.mgr.CompactionTasks(),
+	})
+}
+
+// ---------------------------------------------------
+
+// MetricsHandler is a REST handler that writes the node's metrics, as
+// reported to the MetricsProvider, in the provider's format.
+type MetricsHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewMetricsHandler(mgr *cbgt.Manager) *MetricsHandler {
+	return &MetricsHandler{mgr: mgr}
+}
+
+func (h *MetricsHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	mw, ok := cbgt.GetMetricsProvider().(cbgt.MetricsWriter)
+	if !ok {
+		ShowError(w, req, "rest_manage: Metrics, the metrics provider"+
+			" doesn't write its metrics", http.StatusNotFound)
+		return
+	}
+
+	h.mgr.PublishMetrics()
+
+	w.Header().Set("Content-Type", mw.ContentType())
+	err := mw.WriteMetrics(w)
+	if err != nil {
+		log.Warnf("rest_manage: Metrics, err: %v", err)
+	}
+}
+
+// ---------------------------------------------------
+
+// RollbacksHandler is a REST handler that lists the pindexes whose
+// rollbacks, after a mass rollback to zero of their source, are held
+// back by the "quiesce" rollbackToZeroPolicy.
+type RollbacksHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewRollbacksHandler(mgr *cbgt.Manager) *RollbacksHandler {
+	return &RollbacksHandler{mgr: mgr}
+}
+
+func (h *RollbacksHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	MustEncode(w, struct {
+		Status   string   `json:"status"`
+		Quiesced []string `json:"quiesced"`
+	}{
+		Status:   "ok",
+		Quiesced: h.mgr.QuiescedRollbacks(),
+	})
+}
+
+// RollbacksResumeHandler is a REST handler that resumes the quiesced
+// rollbacks, which rebuilds the pindexes.
+type RollbacksResumeHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewRollbacksResumeHandler(mgr *cbgt.Manager) *RollbacksResumeHandler {
+	return &RollbacksResumeHandler{mgr: mgr}
+}
+
+func (h *RollbacksResumeHandler) RESTOpts(opts map[string]string) {
+	opts["param: indexName"] =
+		"optional, string, URL query parameter\n\n" +
+			"Resumes only the rollbacks of the given index."
+}
+
+func (h *RollbacksResumeHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	MustEncode(w, struct {
+		Status  string   `json:"status"`
+		Resumed []string `json:"resumed"`
+	}{
+		Status:  "ok",
+		Resumed: h.mgr.ResumeQuiescedRollbacks(req.FormValue("indexName")),
+	})
+}
+
+// RollbackDiagnosticsHandler is a REST handler that lists the recorded
+// rollbacks of the source partitions of this node's feeds.
+type RollbackDiagnosticsHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewRollbackDiagnosticsHandler(
+	mgr *cbgt.Manager) *RollbackDiagnosticsHandler {
+	return &RollbackDiagnosticsHandler{mgr: mgr}
+}
+
+func (h *RollbackDiagnosticsHandler) RESTOpts(opts map[string]string) {
+	opts["param: indexName"] =
+		"optional, string, URL query parameter\n\n" +
+			"Returns only the rollbacks of the given index."
+}
+
+func (h *RollbackDiagnosticsHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	MustEncode(w, struct {
+		Status      string                     `json:"status"`
+		Diagnostics []*cbgt.RollbackDiagnostic `json:"diagnostics"`
+	}{
+		Status:      "ok",
+		Diagnostics: h.mgr.RollbackDiagnostics(req.FormValue("indexName")),
+	})
+}
+
+// ---------------------------------------------------
+
+// HibernationGCHandler is a REST handler that removes the orphaned
+// artifacts, such as the partial uploads of a canceled pause, of a
+// hibernation remote path.
+type HibernationGCHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewHibernationGCHandler(mgr *cbgt.Manager) *HibernationGCHandler {
+	return &HibernationGCHandler{mgr: mgr}
+}
+
+func (h *HibernationGCHandler) RESTOpts(opts map[string]string) {
+	opts["request body"] =
+		"A JSON object with the remotePath, an optional region and an" +
+			" optional dryRun, which only lists the orphaned objects"
+}
+
+func (h *HibernationGCHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: HibernationGC,"+
+			" could not read request body, err: %v", err),
+			http.StatusBadRequest)
+		return
+	}
+
+	var params struct {
+		RemotePath string `json:"remotePath"`
+		Region     string `json:"region"`
+		DryRun     bool   `json:"dryRun"`
+	}
+	err = cbgt.UnmarshalJSON(requestBody, &params)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: HibernationGC,"+
+			" could not unmarshal request body, err: %v", err),
+			http.StatusBadRequest)
+		return
+	}
+	if params.RemotePath == "" {
+		ShowError(w, req, "rest_manage: HibernationGC,"+
+			" remotePath is required", http.StatusBadRequest)
+		return
+	}
+
+	rv, err := h.mgr.GCHibernationRemotePath(params.RemotePath,
+		params.Region, params.DryRun)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var errBadRequest *cbgt.BadRequestError
+		if errors.As(err, &errBadRequest) {
+			status = http.StatusBadRequest
+		}
+		ShowError(w, req, fmt.Sprintf("rest_manage: HibernationGC,"+
+			" err: %v", err), status)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status string                    `json:"status"`
+		Result *cbgt.HibernationGCResult `json:"result"`
+	}{Status: "ok", Result: rv})
+}
+
+// ---------------------------------------------------
+
 // ManagerOptions is a REST handler that sets the managerOptions
 type ManagerOptions struct {
 	mgr      *cbgt.Manager
@@ -616,6 +1274,7 @@ func (h *ManagerOptions) ServeHTTP(
 		PropagateError(w, requestBody, msg, http.StatusBadRequest)
 		return
 	}
+	unredactAuthSecretOptions(opt, newOptions)
 
 	if h.Validate != nil {
 		newOptions, err = h.Validate(newOptions)
@@ -631,8 +1290,8 @@ func (h *ManagerOptions) ServeHTTP(
 		"info",
 		"Manager options updated",
 		map[string]interface{}{
-			"PrevSettings": opt,
-			"NewSettings":  newOptions,
+			"PrevSettings": cbgt.RedactAuthSecretOptions(opt),
+			"NewSettings":  cbgt.RedactAuthSecretOptions(newOptions),
 		}))
 	if err != nil {
 		log.Errorf("rest_manage: unexpected system_event error"+
@@ -643,3 +1302,271 @@ func (h *ManagerOptions) ServeHTTP(
 		Status string `json:"status"`
 	}{Status: "ok"})
 }
+
+// ---------------------------------------------------
+
+// NodeMaintenanceControlHandler is a REST handler that puts a node
+// into or takes it out of maintenance mode.
+type NodeMaintenanceControlHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewNodeMaintenanceControlHandler(
+	mgr *cbgt.Manager) *NodeMaintenanceControlHandler {
+	return &NodeMaintenanceControlHandler{mgr: mgr}
+}
+
+func (h *NodeMaintenanceControlHandler) RESTOpts(opts map[string]string) {
+	opts["param: nodeUUID"] =
+		"required, string, URL path parameter\n\n" +
+			"The UUID of the node, or \"self\" for this node."
+	opts["param: op"] =
+		"required, string, URL path parameter\n\n" +
+			"Either \"enter\" or \"exit\" maintenance mode."
+}
+
+func (h *NodeMaintenanceControlHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	nodeUUID := RequestVariableLookup(req, "nodeUUID")
+	if nodeUUID == "" {
+		ShowError(w, req, "node UUID is required", http.StatusBadRequest)
+		return
+	}
+	if nodeUUID == "self" {
+		nodeUUID = h.mgr.UUID()
+	}
+
+	op := RequestVariableLookup(req, "op")
+	if op != "enter" && op != "exit" {
+		ShowError(w, req, fmt.Sprintf("rest_manage: NodeMaintenanceControl,"+
+			" error: unsupported op: %s", op), http.StatusBadRequest)
+		return
+	}
+
+	err := h.mgr.SetNodeMaintenance(nodeUUID, op == "enter")
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: NodeMaintenanceControl,"+
+			" nodeUUID: %s, could not op: %s, err: %v", nodeUUID, op, err),
+			http.StatusBadRequest)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
+
+// ---------------------------------------------------
+
+// FeatureFlagsHandler is a REST handler that returns the definitions
+// of the feature flags, and their values on this node.
+type FeatureFlagsHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewFeatureFlagsHandler(mgr *cbgt.Manager) *FeatureFlagsHandler {
+	return &FeatureFlagsHandler{mgr: mgr}
+}
+
+func (h *FeatureFlagsHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	featureFlags, _, err := cbgt.CfgGetFeatureFlags(h.mgr.Cfg())
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: FeatureFlags,"+
+			" err: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if featureFlags == nil {
+		featureFlags = cbgt.NewFeatureFlags(h.mgr.Version())
+	}
+
+	MustEncode(w, struct {
+		Status string                       `json:"status"`
+		Flags  map[string]*cbgt.FeatureFlag `json:"flags"`
+		Values map[string]string            `json:"values"`
+	}{
+		Status: "ok",
+		Flags:  featureFlags.Flags,
+		Values: h.mgr.FeatureFlags(),
+	})
+}
+
+// FeatureFlagHandler is a REST handler that creates, replaces or
+// deletes the definition of a feature flag.
+type FeatureFlagHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewFeatureFlagHandler(mgr *cbgt.Manager) *FeatureFlagHandler {
+	return &FeatureFlagHandler{mgr: mgr}
+}
+
+func (h *FeatureFlagHandler) RESTOpts(opts map[string]string) {
+	opts["param: flagName"] =
+		"required, string, URL path parameter\n\n" +
+			"The name of the feature flag."
+	opts["request body"] =
+		"For a PUT, a JSON feature flag definition with the default" +
+			" value and optional nodeOverrides, keyed by node UUID," +
+			" minClusterCompatVersion and minImplVersion"
+}
+
+func (h *FeatureFlagHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	name := RequestVariableLookup(req, "flagName")
+	if name == "" {
+		ShowError(w, req, "feature flag name is required",
+			http.StatusBadRequest)
+		return
+	}
+
+	var flag *cbgt.FeatureFlag
+	if req.Method != "DELETE" {
+		requestBody, err := io.ReadAll(req.Body)
+		if err != nil {
+			ShowError(w, req, fmt.Sprintf("rest_manage: FeatureFlag,"+
+				" could not read request body, err: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+
+		flag = &cbgt.FeatureFlag{}
+		err = cbgt.UnmarshalJSON(requestBody, flag)
+		if err != nil {
+			ShowError(w, req, fmt.Sprintf("rest_manage: FeatureFlag,"+
+				" could not unmarshal definition, err: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := cbgt.CfgSetFeatureFlag(h.mgr.Cfg(), h.mgr.Version(), name, flag)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: FeatureFlag,"+
+			" name: %s, err: %v", name, err),
+			http.StatusBadRequest)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
+
+// ---------------------------------------------------
+
+// AlertsHandler is a REST handler that returns the active alerts of
+// this node and the effective alert thresholds.
+type AlertsHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewAlertsHandler(mgr *cbgt.Manager) *AlertsHandler {
+	return &AlertsHandler{mgr: mgr}
+}
+
+func (h *AlertsHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	thresholds, err := cbgt.EffectiveAlertThresholds(h.mgr.Cfg())
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: Alerts,"+
+			" err: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status     string                          `json:"status"`
+		Alerts     []*cbgt.Alert                   `json:"alerts"`
+		Thresholds map[string]*cbgt.AlertThreshold `json:"thresholds"`
+	}{
+		Status:     "ok",
+		Alerts:     h.mgr.ActiveAlerts(),
+		Thresholds: thresholds,
+	})
+}
+
+// AlertThresholdHandler is a REST handler that creates, replaces or
+// deletes the threshold of an alert.
+type AlertThresholdHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewAlertThresholdHandler(mgr *cbgt.Manager) *AlertThresholdHandler {
+	return &AlertThresholdHandler{mgr: mgr}
+}
+
+func (h *AlertThresholdHandler) RESTOpts(opts map[string]string) {
+	opts["param: alertName"] =
+		"required, string, URL path parameter\n\n" +
+			"The name of the alert, such as indexLag, replicaDivergence," +
+			" feedDown or diskUsage."
+	opts["request body"] =
+		"For a PUT, a JSON alert threshold with the warning and" +
+			" critical levels, and an optional disabled flag"
+}
+
+func (h *AlertThresholdHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	name := RequestVariableLookup(req, "alertName")
+	if name == "" {
+		ShowError(w, req, "alert name is required",
+			http.StatusBadRequest)
+		return
+	}
+
+	var threshold *cbgt.AlertThreshold
+	if req.Method != "DELETE" {
+		requestBody, err := io.ReadAll(req.Body)
+		if err != nil {
+			ShowError(w, req, fmt.Sprintf("rest_manage: AlertThreshold,"+
+				" could not read request body, err: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+
+		threshold = &cbgt.AlertThreshold{}
+		err = cbgt.UnmarshalJSON(requestBody, threshold)
+		if err != nil {
+			ShowError(w, req, fmt.Sprintf("rest_manage: AlertThreshold,"+
+				" could not unmarshal threshold, err: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := cbgt.CfgSetAlertThreshold(h.mgr.Cfg(), h.mgr.Version(),
+		name, threshold)
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_manage: AlertThreshold,"+
+			" name: %s, err: %v", name, err),
+			http.StatusBadRequest)
+		return
+	}
+
+	MustEncode(w, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
+
+// ---------------------------------------------------
+
+// OversizedIndexesHandler is a REST handler that returns the indexes
+// whose partitions exceed the maximum partition size.
+type OversizedIndexesHandler struct {
+	mgr *cbgt.Manager
+}
+
+func NewOversizedIndexesHandler(mgr *cbgt.Manager) *OversizedIndexesHandler {
+	return &OversizedIndexesHandler{mgr: mgr}
+}
+
+func (h *OversizedIndexesHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	MustEncode(w, struct {
+		Status           string                 `json:"status"`
+		OversizedIndexes []*cbgt.OversizedIndex `json:"oversizedIndexes"`
+	}{
+		Status:           "ok",
+		OversizedIndexes: h.mgr.OversizedIndexes(),
+	})
+}
@@ -0,0 +1,346 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/cbgt"
+)
+
+// The delta stats let monitoring clients, which scrape the stats of
+// clusters with thousands of pindexes, fetch only the counters that
+// changed since their last scrape.  The stats are flattened into
+// leaf counters, keyed by their JSON pointer path, such as
+// "/pindexes/p0/docCount", and the node remembers a few of the most
+// recent revisions, so that a client that passes back the rev of its
+// last scrape gets only the changed and removed counters.
+
+// StatsDeltaHistory is the number of revisions of the stats that are
+// remembered per preset, which a client's rev can be diffed against.
+var StatsDeltaHistory = 4
+
+// A StatsPreset aggregates the flattened stats of a node on the server
+// side, given the index name of each pindex and feed, returning the
+// flattened stats to be served.
+type StatsPreset func(stats map[string]interface{},
+	indexNames map[string]string) map[string]interface{}
+
+var statsPresetsM sync.RWMutex
+
+// statsPresets is a registry of the StatsPreset's, keyed by name.
+var statsPresets = map[string]StatsPreset{
+	"index": func(stats map[string]interface{},
+		indexNames map[string]string) map[string]interface{} {
+		return aggregateStats(stats, func(kind, name string) string {
+			if indexName := indexNames[kind+"/"+name]; indexName != "" {
+				return "/indexes/" + escapeStatsPath(indexName) + "/" + kind
+			}
+			return ""
+		})
+	},
+	"node": func(stats map[string]interface{},
+		indexNames map[string]string) map[string]interface{} {
+		return aggregateStats(stats, func(kind, name string) string {
+			return "/node/" + kind
+		})
+	},
+}
+
+// RegisterStatsPreset allows applications to register a named
+// aggregation of the delta stats, see the "preset" parameter of the
+// /api/stats/delta endpoint.  Registering a nil preset unregisters the
+// name.
+func RegisterStatsPreset(name string, preset StatsPreset) {
+	statsPresetsM.Lock()
+	if preset != nil {
+		statsPresets[name] = preset
+	} else {
+		delete(statsPresets, name)
+	}
+	statsPresetsM.Unlock()
+}
+
+// A StatsDelta is the response of the /api/stats/delta endpoint.
+type StatsDelta struct {
+	Status  string `json:"status"`
+	Rev     uint64 `json:"rev"`
+	BaseRev uint64 `json:"baseRev,omitempty"`
+
+	// Full is true when the client's rev is unknown, too old or of
+	// another preset, so the Changed stats are all the stats.
+	Full bool `json:"full"`
+
+	Preset  string                 `json:"preset,omitempty"`
+	Changed map[string]interface{} `json:"changed"`
+	Removed []string               `json:"removed,omitempty"`
+}
+
+type statsSnapshot struct {
+	rev    uint64
+	preset string
+	stats  map[string]interface{}
+}
+
+// StatsDeltaHandler is a REST handler that returns the stats of a node
+// that changed since a revision remembered by the client.
+type StatsDeltaHandler struct {
+	mgr *cbgt.Manager
+
+	m      sync.Mutex
+	revNum uint64
+	recent []*statsSnapshot // Oldest first.
+}
+
+func NewStatsDeltaHandler(mgr *cbgt.Manager) *StatsDeltaHandler {
+	return &StatsDeltaHandler{mgr: mgr}
+}
+
+func (h *StatsDeltaHandler) RESTOpts(opts map[string]string) {
+	opts["param: rev"] =
+		"optional, integer, URL query parameter\n\n" +
+			"The rev of the client's last response, to return only the" +
+			" stats that changed since then."
+	opts["param: preset"] =
+		"optional, string, URL query parameter\n\n" +
+			"The server side aggregation of the stats, such as \"index\"," +
+			" which sums the stats of the pindexes and feeds per index," +
+			" or \"node\", which sums them for the whole node."
+}
+
+func (h *StatsDeltaHandler) ServeHTTP(
+	w http.ResponseWriter, req *http.Request) {
+	var baseRev uint64
+	if v := req.FormValue("rev"); v != "" {
+		var err error
+		baseRev, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			ShowError(w, req, fmt.Sprintf("rest_stats_delta:"+
+				" invalid rev: %s, err: %v", v, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	presetName := req.FormValue("preset")
+
+	var preset StatsPreset
+	if presetName != "" {
+		statsPresetsM.RLock()
+		preset = statsPresets[presetName]
+		statsPresetsM.RUnlock()
+		if preset == nil {
+			ShowError(w, req, fmt.Sprintf("rest_stats_delta:"+
+				" unknown preset: %s", presetName), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	err := WriteManagerStatsJSON(h.mgr, &buf, "")
+	if err != nil {
+		ShowError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stats, err := flattenStatsJSON(buf.Bytes())
+	if err != nil {
+		ShowError(w, req, fmt.Sprintf("rest_stats_delta:"+
+			" could not flatten stats, err: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	if preset != nil {
+		stats = preset(stats, h.statsIndexNames())
+	}
+
+	MustEncode(w, h.delta(presetName, stats, baseRev))
+}
+
+// delta remembers the stats as the latest revision of the preset,
+// unless they're unchanged, and returns their delta from the baseRev.
+func (h *StatsDeltaHandler) delta(preset string,
+	stats map[string]interface{}, baseRev uint64) *StatsDelta {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	var latest, base *statsSnapshot
+	for _, s := range h.recent {
+		if s.preset == preset {
+			latest = s
+			if s.rev == baseRev {
+				base = s
+			}
+		}
+	}
+
+	curr := latest
+	if curr == nil || !sameStats(curr.stats, stats) {
+		h.revNum++
+		curr = &statsSnapshot{rev: h.revNum, preset: preset, stats: stats}
+		h.recent = append(h.recent, curr)
+
+		// Keep the StatsDeltaHistory most recent revisions per preset.
+		var n int
+		for i := len(h.recent) - 1; i >= 0; i-- {
+			if h.recent[i].preset == preset {
+				n++
+				if n > StatsDeltaHistory {
+					h.recent = append(h.recent[:i], h.recent[i+1:]...)
+				}
+			}
+		}
+	}
+
+	rv := &StatsDelta{
+		Status:  "ok",
+		Rev:     curr.rev,
+		Preset:  preset,
+		Changed: map[string]interface{}{},
+	}
+
+	if base == nil {
+		rv.Full = true
+		for path, v := range curr.stats {
+			rv.Changed[path] = v
+		}
+		return rv
+	}
+
+	rv.BaseRev = base.rev
+
+	for path, v := range curr.stats {
+		if prev, exists := base.stats[path]; !exists || prev != v {
+			rv.Changed[path] = v
+		}
+	}
+	for path := range base.stats {
+		if _, exists := curr.stats[path]; !exists {
+			rv.Removed = append(rv.Removed, path)
+		}
+	}
+	sort.Strings(rv.Removed)
+
+	return rv
+}
+
+// statsIndexNames returns the index names of the pindexes and feeds,
+// keyed by "pindexes/<name>" and "feeds/<name>".
+func (h *StatsDeltaHandler) statsIndexNames() map[string]string {
+	feeds, pindexes := h.mgr.CurrentMaps()
+
+	rv := make(map[string]string, len(feeds)+len(pindexes))
+	for name, feed := range feeds {
+		rv["feeds/"+name] = feed.IndexName()
+	}
+	for name, pindex := range pindexes {
+		rv["pindexes/"+name] = pindex.IndexName
+	}
+
+	return rv
+}
+
+// ------------------------------------------------------------------------
+
+// flattenStatsJSON returns the leaf values of a JSON object, keyed by
+// their JSON pointer path, where the numbers are json.Number's.
+func flattenStatsJSON(b []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := map[string]interface{}{}
+	flattenStats("", v, rv)
+
+	return rv, nil
+}
+
+func flattenStats(path string, v interface{}, rv map[string]interface{}) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, child := range x {
+			flattenStats(path+"/"+escapeStatsPath(k), child, rv)
+		}
+	case []interface{}:
+		for i, child := range x {
+			flattenStats(path+"/"+strconv.Itoa(i), child, rv)
+		}
+	default:
+		rv[path] = v
+	}
+}
+
+var statsPathEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// escapeStatsPath escapes a key as a JSON pointer token.
+func escapeStatsPath(k string) string {
+	return statsPathEscaper.Replace(k)
+}
+
+// aggregateStats sums the numeric stats of the feeds and pindexes,
+// whose paths are "/feeds/<name>/..." and "/pindexes/<name>/...",
+// into the "<group>/..." paths returned by the groupOf, which drops
+// the stats when it returns "".  The other stats are kept as-is.
+func aggregateStats(stats map[string]interface{},
+	groupOf func(kind, name string) string) map[string]interface{} {
+	rv := map[string]interface{}{}
+	sums := map[string]float64{}
+
+	for path, v := range stats {
+		parts := strings.SplitN(path, "/", 4)
+		if len(parts) < 4 || (parts[1] != "feeds" && parts[1] != "pindexes") {
+			rv[path] = v
+			continue
+		}
+
+		n, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+		f, err := n.Float64()
+		if err != nil {
+			continue
+		}
+
+		name := strings.NewReplacer("~1", "/", "~0", "~").Replace(parts[2])
+		if group := groupOf(parts[1], name); group != "" {
+			sums[group+"/"+parts[3]] += f
+		}
+	}
+
+	for path, sum := range sums {
+		rv[path] = json.Number(strconv.FormatFloat(sum, 'f', -1, 64))
+	}
+
+	return rv
+}
+
+func sameStats(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, v := range a {
+		if w, exists := b[path]; !exists || w != v {
+			return false
+		}
+	}
+	return true
+}
@@ -10,13 +10,17 @@ package rest
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"reflect"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/couchbase/cbgt"
 	"github.com/gorilla/mux"
@@ -561,6 +565,19 @@ func TestHandlersForEmptyManager(t *testing.T) {
 				`null`: true,
 			},
 		},
+		{
+			Desc:   "partition state export of other index",
+			Path:   "/api/stats/partitionState",
+			Method: "GET",
+			Params: url.Values{
+				"indexName": []string{"NOT-AN-INDEX"},
+			},
+			Body:   nil,
+			Status: 200,
+			ResponseMatch: map[string]bool{
+				`"kind"`: false,
+			},
+		},
 		{
 			Desc:   "create a blackhole index, bad params",
 			Path:   "/api/index/bh2",
@@ -627,6 +644,153 @@ func TestHandlersForEmptyManager(t *testing.T) {
 	testRESTHandlers(t, tests, router)
 }
 
+func TestPartitionStateExport(t *testing.T) {
+	emptyDir, _ := os.MkdirTemp("./tmp", "test")
+	defer os.RemoveAll(emptyDir)
+
+	cfg := cbgt.NewCfgMem()
+	mgr := cbgt.NewManager(cbgt.VERSION, cfg, cbgt.NewUUID(),
+		nil, "", 1, "", ":1000", emptyDir, "some-datasource", nil)
+	err := mgr.Start("wanted")
+	if err != nil {
+		t.Fatalf("expected start ok, err: %v", err)
+	}
+	defer mgr.Stop()
+
+	err = mgr.CreateIndex("nil", "", "", "",
+		"blackhole", "bh0", "", cbgt.PlanParams{}, "")
+	if err != nil {
+		t.Fatalf("expected create index ok, err: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		_, pindexes := mgr.CurrentMaps()
+		if len(pindexes) > 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	_, pindexes := mgr.CurrentMaps()
+	if len(pindexes) == 0 {
+		t.Fatalf("expected pindexes")
+	}
+
+	record := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/stats/partitionState", nil)
+	NewPartitionStateExportHandler(mgr).ServeHTTP(record, req)
+
+	if record.Code != http.StatusOK {
+		t.Fatalf("expected ok, got: %d, body: %s", record.Code, record.Body)
+	}
+	if ct := record.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected ndjson content type, got: %s", ct)
+	}
+
+	numPIndexes := 0
+	for _, line := range bytes.Split(
+		bytes.TrimRight(record.Body.Bytes(), "\n"), []byte("\n")) {
+		var p PartitionStateExportPIndex
+		err = json.Unmarshal(line, &p)
+		if err != nil {
+			t.Fatalf("expected json line, got: %s, err: %v", line, err)
+		}
+		if p.Kind != "pindex" {
+			continue
+		}
+		numPIndexes++
+		if p.IndexName != "bh0" || pindexes[p.Name] == nil {
+			t.Errorf("unexpected pindex line: %s", line)
+		}
+		if p.DiskSize < 0 {
+			t.Errorf("expected a disk size, got: %s", line)
+		}
+	}
+
+	if numPIndexes != len(pindexes) {
+		t.Errorf("expected %d pindex lines, got: %d",
+			len(pindexes), numPIndexes)
+	}
+}
+
+func TestStatsDelta(t *testing.T) {
+	h := NewStatsDeltaHandler(nil)
+
+	stats := map[string]interface{}{"/a": json.Number("1"), "/b": "x"}
+
+	d := h.delta("", stats, 0)
+	if !d.Full || d.Rev != 1 || len(d.Changed) != 2 {
+		t.Fatalf("expected a full response, got: %#v", d)
+	}
+
+	d = h.delta("", stats, 1)
+	if d.Full || d.Rev != 1 || d.BaseRev != 1 || len(d.Changed) != 0 {
+		t.Fatalf("expected no changes, got: %#v", d)
+	}
+
+	d = h.delta("", map[string]interface{}{
+		"/a": json.Number("2"), "/c": true}, 1)
+	if d.Full || d.Rev != 2 || d.BaseRev != 1 ||
+		!reflect.DeepEqual(d.Changed, map[string]interface{}{
+			"/a": json.Number("2"), "/c": true}) ||
+		!reflect.DeepEqual(d.Removed, []string{"/b"}) {
+		t.Fatalf("unexpected delta, got: %#v", d)
+	}
+
+	d = h.delta("node", stats, 2)
+	if !d.Full || d.Rev != 3 {
+		t.Fatalf("expected a full response of another preset, got: %#v", d)
+	}
+
+	for i := 0; i < StatsDeltaHistory; i++ {
+		h.delta("", map[string]interface{}{"/a": json.Number(fmt.Sprint(i))}, 0)
+	}
+	if d = h.delta("", stats, 1); !d.Full {
+		t.Fatalf("expected a full response for a forgotten rev, got: %#v", d)
+	}
+}
+
+func TestStatsPresets(t *testing.T) {
+	stats, err := flattenStatsJSON([]byte(`{
+		"feeds": {"f0": {"n": 1}},
+		"pindexes": {"p0": {"n": 2, "s": "x"}, "p/1": {"n": 3}},
+		"manager": {"m": 4}
+	}`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	indexNames := map[string]string{
+		"feeds/f0":     "i0",
+		"pindexes/p0":  "i0",
+		"pindexes/p/1": "i0",
+	}
+
+	rv := statsPresets["index"](stats, indexNames)
+	if !reflect.DeepEqual(rv, map[string]interface{}{
+		"/indexes/i0/feeds/n":    json.Number("1"),
+		"/indexes/i0/pindexes/n": json.Number("5"),
+		"/manager/m":             json.Number("4"),
+	}) {
+		t.Errorf("unexpected index preset, got: %#v", rv)
+	}
+
+	rv = statsPresets["node"](stats, indexNames)
+	if rv["/node/pindexes/n"] != json.Number("5") ||
+		rv["/node/feeds/n"] != json.Number("1") {
+		t.Errorf("unexpected node preset, got: %#v", rv)
+	}
+
+	record := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/stats/delta?preset=nope", nil)
+	h := NewStatsDeltaHandler(nil)
+	h.ServeHTTP(record, req)
+	if record.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad request for an unknown preset, got: %d",
+			record.Code)
+	}
+}
+
 // -------------------------------------------------------
 
 var pathFocusNameRE = regexp.MustCompile(`{([a-zA-Z]+)}`)
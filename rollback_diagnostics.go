@@ -0,0 +1,98 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// RollbackDiagnosticsMax is the number of the most recent rollback
+// diagnostics that a manager keeps.
+var RollbackDiagnosticsMax = 1000
+
+// A RollbackDiagnostic records a rollback of a source partition, as
+// requested by the source of a feed, to explain the re-indexing that
+// follows it.
+type RollbackDiagnostic struct {
+	Time       time.Time `json:"time"`
+	FeedName   string    `json:"feedName"`
+	IndexName  string    `json:"indexName"`
+	SourceName string    `json:"sourceName"`
+	Partition  string    `json:"partition"`
+
+	// The partition's UUID and last received seq before the rollback.
+	OldUUID uint64 `json:"oldUUID"`
+	OldSeq  uint64 `json:"oldSeq"`
+
+	// The UUID and seq that the partition rolls back to.
+	NewUUID uint64 `json:"newUUID"`
+	NewSeq  uint64 `json:"newSeq"`
+
+	// The pindexes of the partition, which are rolled back.
+	PIndexes []string `json:"pindexes"`
+
+	// The number of seqs to be re-indexed, which is OldSeq - NewSeq.
+	SeqsToReindex uint64 `json:"seqsToReindex"`
+
+	Err string `json:"err,omitempty"`
+}
+
+// AddRollbackDiagnostic records a rollback diagnostic, filling in its
+// Time, PIndexes and SeqsToReindex when they're unset, and is invoked
+// by the feeds.
+func (mgr *Manager) AddRollbackDiagnostic(d *RollbackDiagnostic) {
+	atomic.AddUint64(&mgr.stats.TotRollbackDiagnostics, 1)
+
+	if d.Time.IsZero() {
+		d.Time = time.Now()
+	}
+
+	if d.PIndexes == nil {
+		_, pindexes := mgr.CurrentMaps()
+		for _, pindex := range pindexes {
+			if pindex.IndexName == d.IndexName &&
+				pindex.sourcePartitionsMap[d.Partition] {
+				d.PIndexes = append(d.PIndexes, pindex.Name)
+			}
+		}
+		sort.Strings(d.PIndexes)
+	}
+
+	if d.SeqsToReindex == 0 && d.OldSeq > d.NewSeq {
+		d.SeqsToReindex = d.OldSeq - d.NewSeq
+	}
+
+	t := &mgr.rollbacks
+	t.m.Lock()
+	t.diagnostics = append(t.diagnostics, d)
+	if n := len(t.diagnostics) - RollbackDiagnosticsMax; n > 0 {
+		t.diagnostics = append([]*RollbackDiagnostic(nil),
+			t.diagnostics[n:]...)
+	}
+	t.m.Unlock()
+}
+
+// RollbackDiagnostics returns the recorded rollback diagnostics, most
+// recent first, optionally only those of an index.
+func (mgr *Manager) RollbackDiagnostics(
+	indexName string) []*RollbackDiagnostic {
+	t := &mgr.rollbacks
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	rv := make([]*RollbackDiagnostic, 0, len(t.diagnostics))
+	for i := len(t.diagnostics) - 1; i >= 0; i-- {
+		if indexName == "" || t.diagnostics[i].IndexName == indexName {
+			rv = append(rv, t.diagnostics[i])
+		}
+	}
+	return rv
+}
@@ -0,0 +1,57 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRollbackDiagnostics(t *testing.T) {
+	mgr := NewManager(VERSION, nil, NewUUID(), []string{"pindex"},
+		"", 1, "", "", "", "", nil)
+	mgr.registerPIndex(&PIndex{Name: "p0", IndexName: "i0",
+		sourcePartitionsMap: map[string]bool{"0": true, "1": true}})
+	mgr.registerPIndex(&PIndex{Name: "p1", IndexName: "i0",
+		sourcePartitionsMap: map[string]bool{"2": true}})
+	mgr.registerPIndex(&PIndex{Name: "p2", IndexName: "i1",
+		sourcePartitionsMap: map[string]bool{"1": true}})
+
+	prevMax := RollbackDiagnosticsMax
+	RollbackDiagnosticsMax = 2
+	defer func() { RollbackDiagnosticsMax = prevMax }()
+
+	mgr.AddRollbackDiagnostic(&RollbackDiagnostic{IndexName: "i0",
+		Partition: "2", OldSeq: 10})
+	mgr.AddRollbackDiagnostic(&RollbackDiagnostic{IndexName: "i0",
+		Partition: "1", OldUUID: 7, OldSeq: 100, NewUUID: 5, NewSeq: 40})
+	mgr.AddRollbackDiagnostic(&RollbackDiagnostic{IndexName: "i1",
+		Partition: "1", OldSeq: 5, NewSeq: 5})
+
+	all := mgr.RollbackDiagnostics("")
+	if len(all) != 2 || all[0].IndexName != "i1" ||
+		all[1].Partition != "1" || all[0].Time.IsZero() {
+		t.Fatalf("expected the 2 most recent diagnostics, got: %+v", all)
+	}
+
+	d := mgr.RollbackDiagnostics("i0")
+	if len(d) != 1 || !reflect.DeepEqual(d[0].PIndexes, []string{"p0"}) ||
+		d[0].SeqsToReindex != 60 {
+		t.Fatalf("unexpected diagnostics of i0: %+v", d)
+	}
+	if all[0].SeqsToReindex != 0 ||
+		!reflect.DeepEqual(all[0].PIndexes, []string{"p2"}) {
+		t.Fatalf("unexpected diagnostic of i1: %+v", all[0])
+	}
+
+	if mgr.stats.TotRollbackDiagnostics != 3 {
+		t.Errorf("expected 3 diagnostics, got: %d",
+			mgr.stats.TotRollbackDiagnostics)
+	}
+}
@@ -0,0 +1,309 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// The values of the "rollbackToZeroPolicy" manager option, which
+// controls how the pindexes are rebuilt when a source sees a rollback
+// to zero on many partitions at once, such as after a bucket flush.
+const (
+	// The default, where the pindexes are rebuilt right away.
+	RollbackToZeroImmediate = "immediate"
+
+	// The pindexes are rebuilt a few at a time, as limited by the
+	// "rollbackToZeroMaxConcurrent" and "rollbackToZeroStaggerSec"
+	// manager options.
+	RollbackToZeroStaggered = "staggered"
+
+	// The pindexes aren't rebuilt until an operator resumes them, see
+	// Manager.ResumeQuiescedRollbacks().
+	RollbackToZeroQuiesce = "quiesce"
+)
+
+// RollbackToZeroWindow is the time window in which the rollbacks to
+// zero of a source are counted towards the
+// "rollbackToZeroPartitionsThreshold" manager option.
+var RollbackToZeroWindow = time.Minute
+
+// RollbackToZeroSettle is how long a pindex rollback waits, when its
+// source has just seen a rollback to zero, for the rest of the
+// partitions' rollbacks to arrive before the policy is applied.
+var RollbackToZeroSettle = time.Second
+
+// DefaultRollbackToZeroPartitionsThreshold is the default number of
+// partitions of a source that have to roll back to zero within the
+// RollbackToZeroWindow for the policy to be applied.
+var DefaultRollbackToZeroPartitionsThreshold = 64
+
+// rollbackTracker tracks the rollbacks to zero of the sources and the
+// pindex rollbacks held back by the rollbackToZeroPolicy.
+type rollbackTracker struct {
+	m sync.Mutex
+
+	toZero map[string][]time.Time // Keyed by source name.
+
+	queue       []*PIndex // Of the staggered rollbacks.
+	dispatching bool
+
+	quiesced map[string]*PIndex // Keyed by pindex name.
+
+	diagnostics []*RollbackDiagnostic // Oldest first.
+}
+
+// NoteRollbackToZero records that a partition of the source has
+// rolled back to zero, and is invoked by the feeds.
+func (mgr *Manager) NoteRollbackToZero(sourceName string) {
+	atomic.AddUint64(&mgr.stats.TotRollbackToZero, 1)
+
+	t := &mgr.rollbacks
+	now := time.Now()
+
+	t.m.Lock()
+	if t.toZero == nil {
+		t.toZero = map[string][]time.Time{}
+	}
+	t.toZero[sourceName] = append(
+		recentRollbacksLOCKED(t.toZero[sourceName], now), now)
+	t.m.Unlock()
+}
+
+// recentRollbacksLOCKED returns the rollback times that are within the
+// RollbackToZeroWindow.
+func recentRollbacksLOCKED(times []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) > RollbackToZeroWindow {
+		i++
+	}
+	return times[i:]
+}
+
+// rollbackToZeroCount returns the number of recent rollbacks to zero
+// of the source.
+func (mgr *Manager) rollbackToZeroCount(sourceName string) int {
+	t := &mgr.rollbacks
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	recent := recentRollbacksLOCKED(t.toZero[sourceName], time.Now())
+	if len(recent) == 0 {
+		delete(t.toZero, sourceName)
+	} else {
+		t.toZero[sourceName] = recent
+	}
+
+	return len(recent)
+}
+
+// scheduleRollback rolls back the pindex through the janitor, as
+// governed by the rollbackToZeroPolicy when the pindex's source has
+// seen a mass rollback to zero.
+func (mgr *Manager) scheduleRollback(pindex *PIndex) {
+	go func() {
+		policy := mgr.GetOption("rollbackToZeroPolicy")
+		if policy == "" || policy == RollbackToZeroImmediate ||
+			mgr.rollbackToZeroCount(pindex.SourceName) == 0 {
+			mgr.JanitorRollbackKick("rollback:"+pindex.Name, pindex)
+			return
+		}
+
+		select {
+		case <-mgr.stopCh:
+			return
+		case <-time.After(RollbackToZeroSettle):
+		}
+
+		threshold := DefaultRollbackToZeroPartitionsThreshold
+		if v, found := ParseOptionsInt(mgr.Options(),
+			"rollbackToZeroPartitionsThreshold"); found {
+			threshold = v
+		}
+
+		if mgr.rollbackToZeroCount(pindex.SourceName) < threshold {
+			mgr.JanitorRollbackKick("rollback:"+pindex.Name, pindex)
+			return
+		}
+
+		switch policy {
+		case RollbackToZeroQuiesce:
+			mgr.quiesceRollback(pindex)
+		case RollbackToZeroStaggered:
+			mgr.staggerRollback(pindex)
+		default:
+			log.Warnf("rollback_policy: unknown rollbackToZeroPolicy: %s",
+				policy)
+			mgr.JanitorRollbackKick("rollback:"+pindex.Name, pindex)
+		}
+	}()
+}
+
+func (mgr *Manager) quiesceRollback(pindex *PIndex) {
+	atomic.AddUint64(&mgr.stats.TotRollbackQuiesced, 1)
+
+	log.Warnf("rollback_policy: source: %s rolled back to zero,"+
+		" quiescing the rollback of pindex: %s until it's resumed",
+		pindex.SourceName, pindex.Name)
+
+	t := &mgr.rollbacks
+	t.m.Lock()
+	if t.quiesced == nil {
+		t.quiesced = map[string]*PIndex{}
+	}
+	t.quiesced[pindex.Name] = pindex
+	t.m.Unlock()
+}
+
+func (mgr *Manager) staggerRollback(pindex *PIndex) {
+	atomic.AddUint64(&mgr.stats.TotRollbackStaggered, 1)
+
+	t := &mgr.rollbacks
+	t.m.Lock()
+	t.queue = append(t.queue, pindex)
+	dispatching := t.dispatching
+	t.dispatching = true
+	t.m.Unlock()
+
+	if !dispatching {
+		go mgr.dispatchStaggeredRollbacks()
+	}
+}
+
+// dispatchStaggeredRollbacks runs the queued rollbacks, no more than
+// "rollbackToZeroMaxConcurrent" at a time and no more often than every
+// "rollbackToZeroStaggerSec", until the queue is empty.
+func (mgr *Manager) dispatchStaggeredRollbacks() {
+	options := mgr.Options()
+
+	maxConcurrent := 1
+	if v, found := ParseOptionsInt(options,
+		"rollbackToZeroMaxConcurrent"); found && v > 0 {
+		maxConcurrent = v
+	}
+
+	stagger := 5 * time.Second
+	if v, found := ParseOptionsInt(options,
+		"rollbackToZeroStaggerSec"); found && v >= 0 {
+		stagger = time.Duration(v) * time.Second
+	}
+
+	slots := make(chan struct{}, maxConcurrent)
+
+	t := &mgr.rollbacks
+	for {
+		t.m.Lock()
+		if len(t.queue) == 0 {
+			t.dispatching = false
+			t.m.Unlock()
+			return
+		}
+		pindex := t.queue[0]
+		t.queue = t.queue[1:]
+		remaining := len(t.queue)
+		t.m.Unlock()
+
+		select {
+		case <-mgr.stopCh:
+			return
+		case slots <- struct{}{}:
+		}
+
+		// Hold back while the node has eaten into its reserved headroom.
+		for {
+			err := mgr.ResourceHeadroomExceeded()
+			if err == nil {
+				break
+			}
+
+			log.Printf("rollback_policy: holding back the staggered"+
+				" rollback of pindex: %s, %v", pindex.Name, err)
+
+			select {
+			case <-mgr.stopCh:
+				return
+			case <-time.After(ResourceHeadroomRecheckInterval):
+			}
+		}
+
+		log.Printf("rollback_policy: staggered rollback of pindex: %s,"+
+			" remaining: %d", pindex.Name, remaining)
+
+		go func() {
+			mgr.JanitorRollbackKick("rollback:"+pindex.Name, pindex)
+			<-slots
+		}()
+
+		select {
+		case <-mgr.stopCh:
+			return
+		case <-time.After(stagger):
+		}
+	}
+}
+
+// QuiescedRollbacks returns the sorted names of the pindexes whose
+// rollbacks are awaiting an operator's resume.
+func (mgr *Manager) QuiescedRollbacks() []string {
+	t := &mgr.rollbacks
+
+	t.m.Lock()
+	rv := make([]string, 0, len(t.quiesced))
+	for name := range t.quiesced {
+		rv = append(rv, name)
+	}
+	t.m.Unlock()
+
+	sort.Strings(rv)
+
+	return rv
+}
+
+// ResumeQuiescedRollbacks starts the quiesced rollbacks of the pindexes
+// of the given index, or of all the indexes when indexName is "", and
+// returns the names of the pindexes being rolled back.
+func (mgr *Manager) ResumeQuiescedRollbacks(indexName string) []string {
+	t := &mgr.rollbacks
+
+	var pindexes []*PIndex
+
+	t.m.Lock()
+	for name, pindex := range t.quiesced {
+		if indexName == "" || pindex.IndexName == indexName {
+			pindexes = append(pindexes, pindex)
+			delete(t.quiesced, name)
+		}
+	}
+	t.m.Unlock()
+
+	var rv []string
+	for _, pindex := range pindexes {
+		// Skip the pindexes that have since been removed or replaced.
+		if mgr.GetPIndex(pindex.Name) != pindex {
+			continue
+		}
+
+		rv = append(rv, pindex.Name)
+
+		go mgr.JanitorRollbackKick("rollback:"+pindex.Name, pindex)
+	}
+
+	sort.Strings(rv)
+
+	log.Printf("rollback_policy: resumed quiesced rollbacks,"+
+		" indexName: %q, pindexes: %v", indexName, rv)
+
+	return rv
+}
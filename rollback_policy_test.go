@@ -0,0 +1,98 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRecentRollbacks(t *testing.T) {
+	now := time.Now()
+	times := []time.Time{
+		now.Add(-2 * RollbackToZeroWindow),
+		now.Add(-RollbackToZeroWindow / 2),
+		now,
+	}
+
+	if got := recentRollbacksLOCKED(times, now); len(got) != 2 {
+		t.Errorf("expected 2 recent rollbacks, got: %v", got)
+	}
+}
+
+func TestRollbackToZeroPolicy(t *testing.T) {
+	settle := RollbackToZeroSettle
+	RollbackToZeroSettle = time.Millisecond
+	defer func() { RollbackToZeroSettle = settle }()
+
+	waitFor := func(f func() bool) bool {
+		for i := 0; i < 200; i++ {
+			if f() {
+				return true
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return false
+	}
+
+	// The janitor isn't enabled, so the rollbacks are no-ops.
+	mgr := NewManagerEx(VERSION, nil, NewUUID(), []string{"planner"},
+		"", 1, "", "", "", "", nil, map[string]string{
+			"rollbackToZeroPolicy":              RollbackToZeroQuiesce,
+			"rollbackToZeroPartitionsThreshold": "2",
+			"rollbackToZeroStaggerSec":          "0",
+		})
+
+	p0 := &PIndex{Name: "p0", IndexName: "i0", SourceName: "s0"}
+	p1 := &PIndex{Name: "p1", IndexName: "i1", SourceName: "s1"}
+
+	// Below the threshold, the rollback isn't held back.
+	mgr.NoteRollbackToZero("s0")
+	mgr.scheduleRollback(p0)
+	time.Sleep(20 * time.Millisecond)
+	if len(mgr.QuiescedRollbacks()) != 0 {
+		t.Errorf("expected no quiesced rollbacks below the threshold")
+	}
+
+	mgr.NoteRollbackToZero("s0")
+	mgr.NoteRollbackToZero("s1")
+	mgr.NoteRollbackToZero("s1")
+	mgr.scheduleRollback(p0)
+	mgr.scheduleRollback(p1)
+
+	if !waitFor(func() bool { return len(mgr.QuiescedRollbacks()) == 2 }) {
+		t.Fatalf("expected 2 quiesced rollbacks, got: %v",
+			mgr.QuiescedRollbacks())
+	}
+
+	// The pindexes aren't registered, so they're skipped on resume.
+	if resumed := mgr.ResumeQuiescedRollbacks("i0"); len(resumed) != 0 {
+		t.Errorf("expected unregistered pindexes to be skipped, got: %v",
+			resumed)
+	}
+	if got := mgr.QuiescedRollbacks(); len(got) != 1 || got[0] != "p1" {
+		t.Errorf("expected only p1 to remain quiesced, got: %v", got)
+	}
+
+	mgr.optionsMutex.Lock()
+	mgr.options["rollbackToZeroPolicy"] = RollbackToZeroStaggered
+	mgr.optionsMutex.Unlock()
+
+	mgr.scheduleRollback(p1)
+
+	if !waitFor(func() bool {
+		mgr.rollbacks.m.Lock()
+		defer mgr.rollbacks.m.Unlock()
+		return atomic.LoadUint64(&mgr.stats.TotRollbackStaggered) == 1 &&
+			len(mgr.rollbacks.queue) == 0 && !mgr.rollbacks.dispatching
+	}) {
+		t.Fatalf("expected the staggered rollback to be dispatched")
+	}
+}
@@ -0,0 +1,272 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// TopologySnapshotMaxAttempts is the number of times that the Cfg is
+// re-read to take a consistent topology snapshot, before the snapshot
+// is returned as inconsistent.
+var TopologySnapshotMaxAttempts = 10
+
+// A TopologySnapshot is the cluster's node definitions, plan, actual
+// pindex placement and last rebalance status, gathered together for
+// support dumps and external orchestration tools.
+type TopologySnapshot struct {
+	// Rev identifies the Cfg contents of the snapshot, so that equal
+	// revs mean the same index defs, node defs, plan and last
+	// rebalance status.
+	Rev string `json:"rev"`
+
+	// Consistent is true when the Cfg contents were unchanged while
+	// they were read, so that they're from a single point in time.
+	Consistent bool      `json:"consistent"`
+	TakenAt    time.Time `json:"takenAt"`
+
+	IndexDefs           *IndexDefs    `json:"indexDefs"`
+	NodeDefsWanted      *NodeDefs     `json:"nodeDefsWanted"`
+	NodeDefsKnown       *NodeDefs     `json:"nodeDefsKnown"`
+	PlanPIndexes        *PlanPIndexes `json:"planPIndexes"`
+	LastRebalanceStatus string        `json:"lastRebalanceStatus"`
+
+	// ActualPIndexes are the names of the pindexes that each wanted
+	// node is running, keyed by node UUID, and MissingPIndexes and
+	// UnplannedPIndexes are the differences from the plan.
+	ActualPIndexes    map[string][]string `json:"actualPIndexes,omitempty"`
+	MissingPIndexes   map[string][]string `json:"missingPIndexes,omitempty"`
+	UnplannedPIndexes map[string][]string `json:"unplannedPIndexes,omitempty"`
+
+	// Errors are the nodes whose actual pindexes couldn't be retrieved.
+	Errors map[string]string `json:"errors,omitempty"` // Keyed by node UUID.
+}
+
+// A topologyCfg is the topology's Cfg contents, as read at once.
+type topologyCfg struct {
+	indexDefs      *IndexDefs
+	nodeDefsWanted *NodeDefs
+	nodeDefsKnown  *NodeDefs
+	planPIndexes   *PlanPIndexes
+	rebStatus      LastRebalanceStatus
+	sig            string // Identifies the contents.
+}
+
+func readTopologyCfg(cfg Cfg) (*topologyCfg, error) {
+	rv := &topologyCfg{}
+
+	indexDefs, indexDefsCAS, err := CfgGetIndexDefs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	nodeDefsWanted, nodeDefsWantedCAS, err :=
+		CfgGetNodeDefs(cfg, NODE_DEFS_WANTED)
+	if err != nil {
+		return nil, err
+	}
+	nodeDefsKnown, nodeDefsKnownCAS, err :=
+		CfgGetNodeDefs(cfg, NODE_DEFS_KNOWN)
+	if err != nil {
+		return nil, err
+	}
+	planPIndexes, planPIndexesCAS, err := CfgGetPlanPIndexes(cfg)
+	if err != nil {
+		return nil, err
+	}
+	rebStatus, rebStatusCAS, err := CfgGetLastRebalanceStatus(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	rv.indexDefs = indexDefs
+	rv.nodeDefsWanted = nodeDefsWanted
+	rv.nodeDefsKnown = nodeDefsKnown
+	rv.planPIndexes = planPIndexes
+	rv.rebStatus = rebStatus
+
+	var indexDefsUUID, nodeDefsWantedUUID, nodeDefsKnownUUID,
+		planPIndexesUUID string
+	if indexDefs != nil {
+		indexDefsUUID = indexDefs.UUID
+	}
+	if nodeDefsWanted != nil {
+		nodeDefsWantedUUID = nodeDefsWanted.UUID
+	}
+	if nodeDefsKnown != nil {
+		nodeDefsKnownUUID = nodeDefsKnown.UUID
+	}
+	if planPIndexes != nil {
+		planPIndexesUUID = planPIndexes.UUID
+	}
+
+	// The UUIDs and CASes of the Cfg values, which change whenever the
+	// values change.
+	rv.sig = fmt.Sprintf("%s:%d/%s:%d/%s:%d/%s:%d/%d:%d",
+		indexDefsUUID, indexDefsCAS,
+		nodeDefsWantedUUID, nodeDefsWantedCAS,
+		nodeDefsKnownUUID, nodeDefsKnownCAS,
+		planPIndexesUUID, planPIndexesCAS,
+		rebStatus, rebStatusCAS)
+
+	return rv, nil
+}
+
+// TopologySnapshot returns a snapshot of the cluster's topology, where
+// the Cfg is re-read until it's unchanged between two reads.  When
+// includeActual is true, the pindexes that each wanted node is
+// actually running are gathered and compared with the plan.
+func (mgr *Manager) TopologySnapshot(includeActual bool) (
+	*TopologySnapshot, error) {
+	if mgr.cfg == nil {
+		return nil, fmt.Errorf("topology_snapshot: no cfg")
+	}
+
+	var tc *topologyCfg
+	var consistent bool
+
+	for i := 0; i < TopologySnapshotMaxAttempts && !consistent; i++ {
+		prev := tc
+
+		var err error
+		tc, err = readTopologyCfg(mgr.cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		consistent = prev != nil && prev.sig == tc.sig
+	}
+
+	sum := sha256.Sum256([]byte(tc.sig))
+
+	rv := &TopologySnapshot{
+		Rev:                 hex.EncodeToString(sum[:8]),
+		Consistent:          consistent,
+		TakenAt:             time.Now(),
+		IndexDefs:           tc.indexDefs,
+		NodeDefsWanted:      tc.nodeDefsWanted,
+		NodeDefsKnown:       tc.nodeDefsKnown,
+		PlanPIndexes:        tc.planPIndexes,
+		LastRebalanceStatus: clusterSummaryRebalanceStatuses[tc.rebStatus],
+	}
+
+	if includeActual && tc.nodeDefsWanted != nil {
+		mgr.gatherActualPIndexes(rv)
+	}
+
+	return rv, nil
+}
+
+// gatherActualPIndexes fills in the actual pindexes of the snapshot's
+// wanted nodes and their differences from the snapshot's plan.
+func (mgr *Manager) gatherActualPIndexes(rv *TopologySnapshot) {
+	rv.ActualPIndexes = map[string][]string{}
+	rv.MissingPIndexes = map[string][]string{}
+	rv.UnplannedPIndexes = map[string][]string{}
+
+	planned := map[string]map[string]bool{} // Keyed by node UUID.
+	if rv.PlanPIndexes != nil {
+		for name, planPIndex := range rv.PlanPIndexes.PlanPIndexes {
+			for nodeUUID := range planPIndex.Nodes {
+				if planned[nodeUUID] == nil {
+					planned[nodeUUID] = map[string]bool{}
+				}
+				planned[nodeUUID][name] = true
+			}
+		}
+	}
+
+	for nodeUUID, nodeDef := range rv.NodeDefsWanted.NodeDefs {
+		var actual []string
+		if nodeUUID == mgr.uuid {
+			_, pindexes := mgr.CurrentMaps()
+			for name := range pindexes {
+				actual = append(actual, name)
+			}
+		} else {
+			var err error
+			actual, err = fetchPIndexNames(nodeDef)
+			if err != nil {
+				if rv.Errors == nil {
+					rv.Errors = map[string]string{}
+				}
+				rv.Errors[nodeUUID] = err.Error()
+				continue
+			}
+		}
+		sort.Strings(actual)
+
+		rv.ActualPIndexes[nodeUUID] = actual
+
+		running := StringsToMap(actual)
+		for _, name := range actual {
+			if !planned[nodeUUID][name] {
+				rv.UnplannedPIndexes[nodeUUID] =
+					append(rv.UnplannedPIndexes[nodeUUID], name)
+			}
+		}
+
+		var missing []string
+		for name := range planned[nodeUUID] {
+			if !running[name] {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			rv.MissingPIndexes[nodeUUID] = missing
+		}
+	}
+}
+
+// fetchPIndexNames returns the names of the pindexes that a node is
+// running, from the node's /api/pindex endpoint.
+func fetchPIndexNames(nodeDef *NodeDef) ([]string, error) {
+	hostPortUrl := "http://" + nodeDef.HostPort
+	if u, err := nodeDef.HttpsURL(); err == nil {
+		hostPortUrl = u
+	}
+
+	resp, err := alertHttpGet(hostPortUrl + "/api/pindex")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("topology_snapshot: pindexes, status: %d",
+			resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv struct {
+		PIndexes map[string]json.RawMessage `json:"pindexes"`
+	}
+	err = UnmarshalJSON(body, &rv)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(rv.PIndexes))
+	for name := range rv.PIndexes {
+		names = append(names, name)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,106 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTopologySnapshot(t *testing.T) {
+	cfg := NewCfgMem()
+	local, remote := "n0", "n1"
+
+	nodeDefs := NewNodeDefs(VERSION)
+	nodeDefs.NodeDefs[local] = &NodeDef{UUID: local, HostPort: "local:8094"}
+	nodeDefs.NodeDefs[remote] = &NodeDef{UUID: remote, HostPort: "remote:8094"}
+	_, err := CfgSetNodeDefs(cfg, NODE_DEFS_WANTED, nodeDefs, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	planPIndexes := NewPlanPIndexes(VERSION)
+	for name, nodeUUID := range map[string]string{
+		"p0": local, "p1": remote, "p2": local,
+	} {
+		planPIndexes.PlanPIndexes[name] = &PlanPIndex{
+			Name:  name,
+			Nodes: map[string]*PlanPIndexNode{nodeUUID: {}},
+		}
+	}
+	_, err = CfgSetPlanPIndexes(cfg, planPIndexes, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mgr := NewManager(VERSION, cfg, local, nil,
+		"", 1, "", "", "", "", nil)
+
+	mgr.registerPIndex(&PIndex{Name: "p0"})
+	mgr.registerPIndex(&PIndex{Name: "px"})
+
+	prevHttpGet := alertHttpGet
+	defer func() { alertHttpGet = prevHttpGet }()
+
+	alertHttpGet = func(u string) (*http.Response, error) {
+		if u != "http://remote:8094/api/pindex" {
+			t.Errorf("unexpected url: %s", u)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body: io.NopCloser(strings.NewReader(
+				`{"status":"ok","pindexes":{"p1":{"name":"p1"}}}`)),
+		}, nil
+	}
+
+	s0, err := mgr.TopologySnapshot(true)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !s0.Consistent || s0.Rev == "" || s0.LastRebalanceStatus != "none" ||
+		len(s0.PlanPIndexes.PlanPIndexes) != 3 ||
+		len(s0.NodeDefsWanted.NodeDefs) != 2 {
+		t.Fatalf("unexpected snapshot: %#v", s0)
+	}
+
+	if !reflect.DeepEqual(s0.ActualPIndexes, map[string][]string{
+		local: {"p0", "px"}, remote: {"p1"},
+	}) {
+		t.Errorf("unexpected actual pindexes: %v", s0.ActualPIndexes)
+	}
+	if !reflect.DeepEqual(s0.MissingPIndexes, map[string][]string{
+		local: {"p2"},
+	}) {
+		t.Errorf("unexpected missing pindexes: %v", s0.MissingPIndexes)
+	}
+	if !reflect.DeepEqual(s0.UnplannedPIndexes, map[string][]string{
+		local: {"px"},
+	}) {
+		t.Errorf("unexpected unplanned pindexes: %v", s0.UnplannedPIndexes)
+	}
+
+	s1, err := mgr.TopologySnapshot(false)
+	if err != nil || s1.Rev != s0.Rev || s1.ActualPIndexes != nil {
+		t.Fatalf("expected the same rev without actual pindexes,"+
+			" s1: %#v, err: %v", s1, err)
+	}
+
+	_, err = CfgSetLastRebalanceStatus(cfg, RebCompleted, 0)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	s2, err := mgr.TopologySnapshot(false)
+	if err != nil || s2.Rev == s0.Rev || s2.LastRebalanceStatus != "completed" {
+		t.Fatalf("expected a new rev, s2: %#v, err: %v", s2, err)
+	}
+}
@@ -0,0 +1,96 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// TraceAttributes are the attributes of a trace span, such as
+// {"changeID": "..."}.
+type TraceAttributes map[string]string
+
+// A TraceSpan is an operation being traced.
+type TraceSpan interface {
+	SetAttributes(attrs TraceAttributes)
+
+	// RecordError records the error on the span, where a nil error
+	// is ignored.
+	RecordError(err error)
+
+	End()
+}
+
+// A Tracer is a pluggable tracing backend, which cbgt reports the spans
+// of its long running operations to, such as the topology change
+// lifecycle.  Embedding applications can adapt it to OpenTelemetry,
+// where the returned context carries the span so that the spans that
+// are started with it become its children.
+//
+// The spans are local to a node, as cbgt doesn't propagate the trace
+// context between nodes.  A topology change is traced on the node that
+// orchestrates it, from its prepare to its completion, with a child
+// span per pindex move that lasts until the move is done on its target
+// node.
+type Tracer interface {
+	Start(ctx context.Context, name string,
+		attrs TraceAttributes) (context.Context, TraceSpan)
+}
+
+var tracer atomic.Value // Of tracerHolder.
+
+type tracerHolder struct {
+	t Tracer
+}
+
+func init() {
+	SetTracer(nil)
+}
+
+// SetTracer replaces the process-wide Tracer, where a nil tracer means
+// a NoopTracer.
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = NoopTracer{}
+	}
+	tracer.Store(tracerHolder{t: t})
+}
+
+// GetTracer returns the process-wide Tracer, which defaults to a
+// NoopTracer.
+func GetTracer() Tracer {
+	return tracer.Load().(tracerHolder).t
+}
+
+// StartSpan starts a span with the process-wide Tracer, where a nil
+// ctx means context.Background().
+func StartSpan(ctx context.Context, name string,
+	attrs TraceAttributes) (context.Context, TraceSpan) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return GetTracer().Start(ctx, name, attrs)
+}
+
+// ------------------------------------------------------------------------
+
+// NoopTracer is a Tracer that drops all spans.
+type NoopTracer struct{}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attrs TraceAttributes) {}
+func (noopSpan) RecordError(err error)               {}
+func (noopSpan) End()                                {}
+
+func (NoopTracer) Start(ctx context.Context, name string,
+	attrs TraceAttributes) (context.Context, TraceSpan) {
+	return ctx, noopSpan{}
+}
@@ -0,0 +1,119 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+type testSpanKey struct{}
+
+type testSpan struct {
+	name   string
+	parent string
+	attrs  TraceAttributes
+	errs   []error
+	ended  bool
+}
+
+type testTracer struct {
+	m     sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string,
+	attrs TraceAttributes) (context.Context, TraceSpan) {
+	s := &testSpan{name: name, attrs: TraceAttributes{}}
+	if parent, ok := ctx.Value(testSpanKey{}).(*testSpan); ok {
+		s.parent = parent.name
+	}
+	for k, v := range attrs {
+		s.attrs[k] = v
+	}
+
+	t.m.Lock()
+	t.spans = append(t.spans, s)
+	t.m.Unlock()
+
+	return context.WithValue(ctx, testSpanKey{}, s), &testTracerSpan{t: t, s: s}
+}
+
+type testTracerSpan struct {
+	t *testTracer
+	s *testSpan
+}
+
+func (ts *testTracerSpan) SetAttributes(attrs TraceAttributes) {
+	ts.t.m.Lock()
+	for k, v := range attrs {
+		ts.s.attrs[k] = v
+	}
+	ts.t.m.Unlock()
+}
+
+func (ts *testTracerSpan) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	ts.t.m.Lock()
+	ts.s.errs = append(ts.s.errs, err)
+	ts.t.m.Unlock()
+}
+
+func (ts *testTracerSpan) End() {
+	ts.t.m.Lock()
+	ts.s.ended = true
+	ts.t.m.Unlock()
+}
+
+func TestTracer(t *testing.T) {
+	if _, ok := GetTracer().(NoopTracer); !ok {
+		t.Fatalf("expected a NoopTracer by default, got: %T", GetTracer())
+	}
+
+	// The noop spans and a nil ctx are ok.
+	ctx, span := StartSpan(nil, "noop", nil)
+	if ctx == nil {
+		t.Fatalf("expected a ctx")
+	}
+	span.RecordError(errors.New("ignored"))
+	span.End()
+
+	tr := &testTracer{}
+	SetTracer(tr)
+	defer SetTracer(nil)
+
+	ctx, root := StartSpan(nil, "root", TraceAttributes{"changeID": "c0"})
+	_, child := StartSpan(ctx, "child", nil)
+	child.RecordError(nil)
+	child.RecordError(errors.New("oops"))
+	child.End()
+	root.SetAttributes(TraceAttributes{"outcome": "failed"})
+	root.End()
+
+	if len(tr.spans) != 2 {
+		t.Fatalf("expected 2 spans, got: %d", len(tr.spans))
+	}
+	if s := tr.spans[0]; s.name != "root" || s.parent != "" || !s.ended ||
+		s.attrs["changeID"] != "c0" || s.attrs["outcome"] != "failed" {
+		t.Fatalf("unexpected root span: %+v", s)
+	}
+	if s := tr.spans[1]; s.name != "child" || s.parent != "root" ||
+		!s.ended || len(s.errs) != 1 {
+		t.Fatalf("unexpected child span: %+v", s)
+	}
+
+	SetTracer(nil)
+	if _, ok := GetTracer().(NoopTracer); !ok {
+		t.Fatalf("expected a NoopTracer after a nil SetTracer")
+	}
+}
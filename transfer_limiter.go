@@ -0,0 +1,214 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/couchbase/clog"
+)
+
+// TRANSFER_RATE_LIMIT_OPTION is the manager option that holds the
+// per-node limit, in bytes/sec, for partition file transfers, such
+// as during a file transfer based rebalance or a pause/resume.  A value
+// of 0 or an absent option means unlimited.
+const TRANSFER_RATE_LIMIT_OPTION = "transferRateLimitBytesPerSec"
+
+// TransferRateLimiterMaxSleep bounds how long a waiter sleeps before
+// re-evaluating the rate, so that rate changes made in the middle of
+// a transfer take effect promptly.
+var TransferRateLimiterMaxSleep = 100 * time.Millisecond
+
+// ErrTransferCanceled is returned when a rate limited transfer is
+// canceled while waiting for its turn.
+var ErrTransferCanceled = fmt.Errorf("transfer: canceled")
+
+// A TransferRateLimiter is a token bucket that limits the rate of the
+// partition file transfers of a node, shared by all the concurrent
+// transfers of that node.  Its rate may be changed at any time,
+// including while transfers are in flight.
+type TransferRateLimiter struct {
+	m     sync.Mutex
+	rate  int64   // Bytes/sec, where <= 0 means unlimited.
+	avail float64 // Bytes that can be transferred right now.
+	last  time.Time
+
+	TotBytes  uint64
+	TotWaits  uint64
+	TotWaitNS uint64
+}
+
+// NewTransferRateLimiter returns a TransferRateLimiter with the given
+// rate in bytes/sec, where <= 0 means unlimited.
+func NewTransferRateLimiter(bytesPerSec int64) *TransferRateLimiter {
+	return &TransferRateLimiter{rate: bytesPerSec, last: time.Now()}
+}
+
+// Rate returns the current rate limit in bytes/sec.
+func (l *TransferRateLimiter) Rate() int64 {
+	l.m.Lock()
+	rate := l.rate
+	l.m.Unlock()
+	return rate
+}
+
+// SetRate changes the rate limit in bytes/sec, where <= 0 means
+// unlimited.
+func (l *TransferRateLimiter) SetRate(bytesPerSec int64) {
+	l.m.Lock()
+	if l.rate != bytesPerSec {
+		l.rate = bytesPerSec
+		l.avail = 0
+		l.last = time.Now()
+	}
+	l.m.Unlock()
+}
+
+// WaitN blocks until n bytes may be transferred, or until the
+// cancelCh is closed.
+func (l *TransferRateLimiter) WaitN(cancelCh <-chan struct{}, n int) error {
+	atomic.AddUint64(&l.TotBytes, uint64(n))
+
+	var waited bool
+	startTime := time.Now()
+
+	for n > 0 {
+		l.m.Lock()
+		if l.rate <= 0 {
+			l.m.Unlock()
+			break
+		}
+
+		now := time.Now()
+		burst := float64(l.rate) // Allow up to 1 sec of bursting.
+		l.avail += now.Sub(l.last).Seconds() * float64(l.rate)
+		if l.avail > burst {
+			l.avail = burst
+		}
+		l.last = now
+
+		chunk := float64(n)
+		if chunk > burst {
+			chunk = burst
+		}
+
+		if l.avail >= chunk {
+			l.avail -= chunk
+			n -= int(chunk)
+			l.m.Unlock()
+			continue
+		}
+
+		sleep := time.Duration((chunk - l.avail) / float64(l.rate) *
+			float64(time.Second))
+		l.m.Unlock()
+
+		if sleep > TransferRateLimiterMaxSleep {
+			sleep = TransferRateLimiterMaxSleep
+		}
+
+		waited = true
+
+		select {
+		case <-cancelCh:
+			return ErrTransferCanceled
+		case <-time.After(sleep):
+		}
+	}
+
+	if waited {
+		atomic.AddUint64(&l.TotWaits, 1)
+		atomic.AddUint64(&l.TotWaitNS, uint64(time.Since(startTime)))
+	}
+
+	return nil
+}
+
+// Reader returns an io.Reader whose reads are rate limited.
+func (l *TransferRateLimiter) Reader(r io.Reader,
+	cancelCh <-chan struct{}) io.Reader {
+	return &transferLimitedReader{l: l, r: r, cancelCh: cancelCh}
+}
+
+// Writer returns an io.Writer whose writes are rate limited.
+func (l *TransferRateLimiter) Writer(w io.Writer,
+	cancelCh <-chan struct{}) io.Writer {
+	return &transferLimitedWriter{l: l, w: w, cancelCh: cancelCh}
+}
+
+type transferLimitedReader struct {
+	l        *TransferRateLimiter
+	r        io.Reader
+	cancelCh <-chan struct{}
+}
+
+func (t *transferLimitedReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if errW := t.l.WaitN(t.cancelCh, n); errW != nil {
+			return n, errW
+		}
+	}
+	return n, err
+}
+
+type transferLimitedWriter struct {
+	l        *TransferRateLimiter
+	w        io.Writer
+	cancelCh <-chan struct{}
+}
+
+func (t *transferLimitedWriter) Write(p []byte) (int, error) {
+	if err := t.l.WaitN(t.cancelCh, len(p)); err != nil {
+		return 0, err
+	}
+	return t.w.Write(p)
+}
+
+// ------------------------------------------------------------------------
+
+// TransferRateLimiter returns the node's shared limiter for partition
+// file transfers, which pindex implementations should use when
+// copying partition files from other nodes, and which also limits the
+// node's pause/resume transfers.
+func (mgr *Manager) TransferRateLimiter() *TransferRateLimiter {
+	return mgr.transferLimiter
+}
+
+// transferRateLimitOption returns the transfer rate limit in
+// bytes/sec from the given manager options, where 0 means unlimited.
+func transferRateLimitOption(options map[string]string) int64 {
+	if v, found := ParseOptionsInt(options, TRANSFER_RATE_LIMIT_OPTION); found {
+		return int64(v)
+	}
+	return 0
+}
+
+func newTransferRateLimiterFromOptions(
+	options map[string]string) *TransferRateLimiter {
+	return NewTransferRateLimiter(transferRateLimitOption(options))
+}
+
+// refreshTransferRateLimit applies the current value of the
+// transferRateLimitBytesPerSec option to the transfer rate limiter.
+func (mgr *Manager) refreshTransferRateLimit(options map[string]string) {
+	if mgr.transferLimiter == nil {
+		return
+	}
+
+	rate := transferRateLimitOption(options)
+	if mgr.transferLimiter.Rate() != rate {
+		log.Printf("manager: transfer rate limit set to %d bytes/sec", rate)
+		mgr.transferLimiter.SetRate(rate)
+	}
+}
@@ -0,0 +1,85 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestTransferRateLimiter(t *testing.T) {
+	l := NewTransferRateLimiter(0)
+	startTime := time.Now()
+	if err := l.WaitN(nil, 1<<30); err != nil {
+		t.Errorf("expected no err when unlimited, err: %v", err)
+	}
+	if time.Since(startTime) > time.Second {
+		t.Errorf("expected no wait when unlimited")
+	}
+
+	l.SetRate(10000)
+	var out bytes.Buffer
+	startTime = time.Now()
+	n, err := io.Copy(l.Writer(&out, nil), bytes.NewReader(make([]byte, 3000)))
+	if err != nil || n != 3000 {
+		t.Errorf("expected copy to work, n: %d, err: %v", n, err)
+	}
+	if d := time.Since(startTime); d < 200*time.Millisecond {
+		t.Errorf("expected the copy to be throttled, took: %v", d)
+	}
+	if l.TotWaits == 0 {
+		t.Errorf("expected waits to be counted")
+	}
+
+	// A canceled transfer gives up waiting.
+	l.SetRate(1)
+	cancelCh := make(chan struct{})
+	close(cancelCh)
+	if err := l.WaitN(cancelCh, 100); err != ErrTransferCanceled {
+		t.Errorf("expected ErrTransferCanceled, got: %v", err)
+	}
+}
+
+func TestManagerTransferRateLimitOption(t *testing.T) {
+	cfg := NewCfgMem()
+	mgr := NewManagerEx(VERSION, cfg, NewUUID(), nil, "", 1, "", "", "",
+		"", nil, map[string]string{TRANSFER_RATE_LIMIT_OPTION: "1000"})
+	if r := mgr.TransferRateLimiter().Rate(); r != 1000 {
+		t.Errorf("expected rate 1000, got: %d", r)
+	}
+
+	err := mgr.SetOption(TRANSFER_RATE_LIMIT_OPTION, "2000", true)
+	if err != nil {
+		t.Fatalf("expected SetOption to work, err: %v", err)
+	}
+	if r := mgr.TransferRateLimiter().Rate(); r != 2000 {
+		t.Errorf("expected rate 2000, got: %d", r)
+	}
+
+	opts, _, err := CfgGetClusterOptions(cfg)
+	if err != nil || opts.TransferRateLimitBytesPerSec != "2000" {
+		t.Errorf("expected the rate persisted in cluster options,"+
+			" opts: %+v, err: %v", opts, err)
+	}
+
+	// A cluster options change from another node, mid-transfer.
+	_, err = CfgSetClusterOptions(cfg,
+		&ClusterOptions{TransferRateLimitBytesPerSec: "0"}, CFG_CAS_FORCE)
+	if err != nil {
+		t.Fatalf("expected CfgSetClusterOptions to work, err: %v", err)
+	}
+	if err = mgr.RefreshOptions(); err != nil {
+		t.Fatalf("expected RefreshOptions to work, err: %v", err)
+	}
+	if r := mgr.TransferRateLimiter().Rate(); r != 0 {
+		t.Errorf("expected unlimited rate, got: %d", r)
+	}
+}
@@ -0,0 +1,252 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	log "github.com/couchbase/clog"
+)
+
+// TRANSFER_CHECKPOINT_SUFFIX is the suffix of the checkpoint file that
+// sits next to the destination file of an in-progress resumable
+// transfer.
+const TRANSFER_CHECKPOINT_SUFFIX = ".transfer-checkpoint"
+
+// DefaultTransferChunkSize is the default chunk size of resumable
+// transfers.
+var DefaultTransferChunkSize = 4 * 1024 * 1024
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// A TransferCheckpoint tracks the verified chunks of a resumable
+// transfer, and is persisted after every chunk so that an interrupted
+// transfer can resume from the last verified chunk instead of from
+// scratch.
+type TransferCheckpoint struct {
+	SourceID  string   `json:"sourceID,omitempty"`
+	Size      int64    `json:"size"`
+	ChunkSize int      `json:"chunkSize"`
+	Checksums []uint32 `json:"checksums"` // CRC32-C of each chunk.
+}
+
+// ResumableCopyOptions are the optional parameters of ResumableCopy.
+type ResumableCopyOptions struct {
+	ChunkSize int // Defaults to DefaultTransferChunkSize.
+
+	// SourceID identifies the version of the src, such as by its mtime
+	// or ETag, so that a checkpoint of another version of the src isn't
+	// resumed from.
+	SourceID string
+
+	// VerifyAll means all the checkpointed chunks are re-verified
+	// against the destination file on resume, rather than only the
+	// last checkpointed chunk.
+	VerifyAll bool
+
+	Limiter  *TransferRateLimiter // Optional.
+	CancelCh <-chan struct{}      // Optional.
+
+	// Optional, invoked after each chunk is copied and checkpointed.
+	OnProgress func(copied, total int64)
+}
+
+// ResumableCopy copies size bytes from the src into the file at
+// dstPath in chunks, checkpointing the checksum of each chunk once
+// it's been durably written.  If a previous copy of the same src into
+// the dstPath was interrupted, the copy resumes after the last verified
+// chunk.  The checkpoint file is removed once the copy completes.  A
+// size < 0 means the size of the src is unknown, so the copy continues
+// until a read of the src returns io.EOF.
+func ResumableCopy(src io.ReaderAt, size int64, dstPath string,
+	options ResumableCopyOptions) error {
+	chunkSize := options.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultTransferChunkSize
+	}
+
+	ckptPath := dstPath + TRANSFER_CHECKPOINT_SUFFIX
+
+	dst, err := os.OpenFile(dstPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("transfer: open dst: %s, err: %v", dstPath, err)
+	}
+	defer dst.Close()
+
+	ckpt := loadTransferCheckpoint(ckptPath, options.SourceID, size,
+		chunkSize)
+
+	// A dst that's shorter than the checkpoint (e.g., it was removed)
+	// means there's nothing to resume from.
+	if fi, err := dst.Stat(); err != nil ||
+		fi.Size() < int64(len(ckpt.Checksums)-1)*int64(chunkSize) {
+		ckpt.Checksums = nil
+	}
+
+	buf := make([]byte, chunkSize)
+
+	// Re-verify the checkpointed chunks, and resume right after the
+	// last chunk that's still intact.
+	verifyFrom := 0
+	if !options.VerifyAll && len(ckpt.Checksums) > 0 {
+		verifyFrom = len(ckpt.Checksums) - 1
+	}
+	for i := verifyFrom; i < len(ckpt.Checksums); i++ {
+		n := chunkLen(size, chunkSize, i)
+		_, err = dst.ReadAt(buf[:n], int64(i)*int64(chunkSize))
+		if err != nil || crc32.Checksum(buf[:n], crc32cTable) != ckpt.Checksums[i] {
+			log.Warnf("transfer: dst: %s, chunk: %d failed verification,"+
+				" resuming from there, err: %v", dstPath, i, err)
+			ckpt.Checksums = ckpt.Checksums[:i]
+			break
+		}
+	}
+
+	copied := int64(len(ckpt.Checksums)) * int64(chunkSize)
+	if size >= 0 && copied > size {
+		copied = size
+	}
+	if copied > 0 {
+		log.Printf("transfer: dst: %s, resuming at offset: %d of %d",
+			dstPath, copied, size)
+	}
+
+	for size < 0 || copied < size {
+		select {
+		case <-options.CancelCh:
+			return ErrTransferCanceled
+		default:
+		}
+
+		i := len(ckpt.Checksums)
+		n := chunkLen(size, chunkSize, i)
+
+		if options.Limiter != nil {
+			err = options.Limiter.WaitN(options.CancelCh, n)
+			if err != nil {
+				return err
+			}
+		}
+
+		nr, err := src.ReadAt(buf[:n], copied)
+		if nr < n {
+			if size >= 0 || err != io.EOF {
+				return fmt.Errorf("transfer: read src, offset: %d,"+
+					" short read: %d of %d, err: %v", copied, nr, n, err)
+			}
+			if nr == 0 {
+				break // The end of a src of an unknown size.
+			}
+			n = nr
+		}
+
+		_, err = dst.WriteAt(buf[:n], copied)
+		if err != nil {
+			return fmt.Errorf("transfer: write dst: %s, offset: %d, err: %v",
+				dstPath, copied, err)
+		}
+
+		// Only checkpoint chunks that are durable.
+		err = dst.Sync()
+		if err != nil {
+			return fmt.Errorf("transfer: sync dst: %s, err: %v", dstPath, err)
+		}
+
+		ckpt.Checksums = append(ckpt.Checksums,
+			crc32.Checksum(buf[:n], crc32cTable))
+
+		err = saveTransferCheckpoint(ckptPath, ckpt)
+		if err != nil {
+			return err
+		}
+
+		copied += int64(n)
+
+		if options.OnProgress != nil {
+			options.OnProgress(copied, size)
+		}
+	}
+
+	err = dst.Truncate(copied)
+	if err != nil {
+		return fmt.Errorf("transfer: truncate dst: %s, err: %v", dstPath, err)
+	}
+
+	err = os.Remove(ckptPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("transfer: remove checkpoint: %s, err: %v",
+			ckptPath, err)
+	}
+
+	return nil
+}
+
+// chunkLen returns the length of the i'th chunk of a transfer, where
+// the chunks of a transfer of an unknown size are all full.
+func chunkLen(size int64, chunkSize, i int) int {
+	remaining := size - int64(i)*int64(chunkSize)
+	if size >= 0 && remaining < int64(chunkSize) {
+		return int(remaining)
+	}
+	return chunkSize
+}
+
+// loadTransferCheckpoint returns the persisted checkpoint at the path,
+// or a fresh checkpoint if there's none or if it's for a different
+// transfer or a different version of the src.
+func loadTransferCheckpoint(path, sourceID string, size int64,
+	chunkSize int) *TransferCheckpoint {
+	fresh := &TransferCheckpoint{
+		SourceID:  sourceID,
+		Size:      size,
+		ChunkSize: chunkSize,
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return fresh
+	}
+
+	ckpt := &TransferCheckpoint{}
+	err = UnmarshalJSON(buf, ckpt)
+	if err != nil || ckpt.SourceID != sourceID ||
+		ckpt.Size != size || ckpt.ChunkSize != chunkSize {
+		log.Printf("transfer: ignoring mismatched checkpoint: %s, err: %v",
+			path, err)
+		return fresh
+	}
+
+	return ckpt
+}
+
+func saveTransferCheckpoint(path string, ckpt *TransferCheckpoint) error {
+	buf, err := MarshalJSON(ckpt)
+	if err != nil {
+		return err
+	}
+
+	// Write-then-rename, so that a crash never leaves a torn checkpoint.
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, buf, 0600)
+	if err != nil {
+		return fmt.Errorf("transfer: write checkpoint: %s, err: %v",
+			tmpPath, err)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return fmt.Errorf("transfer: rename checkpoint: %s, err: %v",
+			path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+//  Copyright 2024-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package cbgt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumableCopy(t *testing.T) {
+	testDir, _ := os.MkdirTemp("./tmp", "test")
+	defer os.RemoveAll(testDir)
+
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	src := bytes.NewReader(data)
+	dstPath := filepath.Join(testDir, "pindex.file")
+
+	// Interrupt the copy after 3 chunks.
+	cancelCh := make(chan struct{})
+	err := ResumableCopy(src, int64(len(data)), dstPath, ResumableCopyOptions{
+		ChunkSize: 1000,
+		CancelCh:  cancelCh,
+		OnProgress: func(copied, total int64) {
+			if copied == 3000 {
+				close(cancelCh)
+			}
+		},
+	})
+	if err != ErrTransferCanceled {
+		t.Fatalf("expected ErrTransferCanceled, got: %v", err)
+	}
+	if _, err = os.Stat(dstPath + TRANSFER_CHECKPOINT_SUFFIX); err != nil {
+		t.Fatalf("expected a checkpoint, err: %v", err)
+	}
+
+	// Corrupt the last checkpointed chunk, which gets re-copied.
+	f, _ := os.OpenFile(dstPath, os.O_RDWR, 0600)
+	f.WriteAt([]byte{0xff}, 2500)
+	f.Close()
+
+	var firstCopied int64
+	err = ResumableCopy(src, int64(len(data)), dstPath, ResumableCopyOptions{
+		ChunkSize: 1000,
+		OnProgress: func(copied, total int64) {
+			if firstCopied == 0 {
+				firstCopied = copied
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected resumed copy to work, err: %v", err)
+	}
+	if firstCopied != 3000 {
+		t.Errorf("expected the copy to resume at the corrupt chunk,"+
+			" first progress: %d", firstCopied)
+	}
+
+	got, _ := os.ReadFile(dstPath)
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected copied data to match")
+	}
+	if _, err = os.Stat(dstPath + TRANSFER_CHECKPOINT_SUFFIX); !os.IsNotExist(err) {
+		t.Errorf("expected the checkpoint to be removed, err: %v", err)
+	}
+}
+
+func TestResumableCopySourceChanged(t *testing.T) {
+	dstPath := filepath.Join(t.TempDir(), "pindex.file")
+
+	data := bytes.Repeat([]byte("a"), 2500)
+
+	cancelCh := make(chan struct{})
+	err := ResumableCopy(bytes.NewReader(data), -1, dstPath,
+		ResumableCopyOptions{
+			ChunkSize: 1000,
+			SourceID:  "v1",
+			CancelCh:  cancelCh,
+			OnProgress: func(copied, total int64) {
+				if copied == 2000 {
+					close(cancelCh)
+				}
+			},
+		})
+	if err != ErrTransferCanceled {
+		t.Fatalf("expected ErrTransferCanceled, got: %v", err)
+	}
+
+	// The src changed, so the copy doesn't resume from the chunks of
+	// the previous version.
+	data = bytes.Repeat([]byte("b"), 1500)
+
+	var firstCopied int64
+	err = ResumableCopy(bytes.NewReader(data), -1, dstPath,
+		ResumableCopyOptions{
+			ChunkSize: 1000,
+			SourceID:  "v2",
+			OnProgress: func(copied, total int64) {
+				if firstCopied == 0 {
+					firstCopied = copied
+				}
+			},
+		})
+	if err != nil {
+		t.Fatalf("expected the copy to work, err: %v", err)
+	}
+	if firstCopied != 1000 {
+		t.Errorf("expected the copy to start over, first progress: %d",
+			firstCopied)
+	}
+
+	got, _ := os.ReadFile(dstPath)
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected copied data to match, got: %d bytes", len(got))
+	}
+}